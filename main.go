@@ -105,10 +105,26 @@ func main() {
 		Config:           config,
 	}
 
-	var internalHub *pubsub.Hub
+	var internalHub, publicHub *pubsub.Hub
+	var pubSub, internalPubSub *pubsub.WsPubSub
 	if !config.App.Slave {
-		internalHub = pubsub.NewHub()
+		internalHub = initPubSubHub(config, "internal")
+		publicHub = initPubSubHub(config, "public")
+		pubSubAuthorizer := &handler.PubSubAuthorizer{
+			ConnOpener:   connOpener,
+			HookRegistry: pluginContext.HookRegistry,
+		}
+		pubSub = pubsub.NewWsPubsub(publicHub)
+		pubSub.Authorizer = pubSubAuthorizer
+		internalPubSub = pubsub.NewWsPubsub(internalHub)
+		internalPubSub.Authorizer = pubSubAuthorizer
+		pluginContext.Publish = func(channel string, data []byte) error {
+			return pubSub.Publish(pubsub.Client{MasterKey: true}, channel, data)
+		}
 		initSubscription(config, connOpener, internalHub, pushSender)
+		initPushQueue(config, connOpener, pushSender)
+		initPushScheduler(cronjob, connOpener)
+		initRecordChangePruner(cronjob, connOpener)
 		initDevice(config, connOpener)
 	}
 
@@ -148,6 +164,15 @@ func main() {
 		DevMode: config.App.DevMode,
 	}
 
+	assetStore := initAssetStore(config)
+	publicVariantSizes := initPublicVariantSizes(config)
+	if !config.App.Slave {
+		initUploadSessionPruner(cronjob, connOpener, assetStore)
+		if config.AssetStore.GC.Enable {
+			initAssetCollector(config, cronjob, connOpener, assetStore)
+		}
+	}
+
 	g := &inject.Graph{}
 	injectErr := g.Provide(
 		&inject.Object{
@@ -166,7 +191,7 @@ func main() {
 			Name:     "TokenStore",
 		},
 		&inject.Object{
-			Value:    initAssetStore(config),
+			Value:    assetStore,
 			Complete: true,
 			Name:     "AssetStore",
 		},
@@ -202,16 +227,31 @@ func main() {
 	r.Map("auth:login", injector.Inject(&handler.LoginHandler{}))
 	r.Map("auth:logout", injector.Inject(&handler.LogoutHandler{}))
 	r.Map("auth:password", injector.Inject(&handler.PasswordHandler{}))
+	r.Map("auth:upgrade", injector.Inject(&handler.AuthUpgradeHandler{}))
+	r.Map("auth:merge", injector.Inject(&handler.AuthMergeHandler{}))
 
 	r.Map("asset:put", injector.Inject(&handler.AssetUploadHandler{}))
+	r.Map("asset:variant_url", injector.Inject(&handler.AssetVariantURLHandler{
+		PublicVariantSizes: publicVariantSizes,
+	}))
+	r.Map("asset:upload:create", injector.Inject(&handler.AssetUploadCreateHandler{}))
+	r.Map("asset:upload:status", injector.Inject(&handler.AssetUploadStatusHandler{}))
+	r.Map("asset:upload:complete", injector.Inject(&handler.AssetUploadCompleteHandler{}))
+	r.Map("asset:upload:abort", injector.Inject(&handler.AssetUploadAbortHandler{}))
+	r.Map("asset:gc:report", injector.Inject(&handler.AssetGCReportHandler{
+		GracePeriod: time.Duration(config.AssetStore.GC.GracePeriod) * time.Second,
+	}))
 
 	r.Map("record:fetch", injector.Inject(&handler.RecordFetchHandler{}))
 	r.Map("record:query", injector.Inject(&handler.RecordQueryHandler{}))
+	r.Map("record:changes", injector.Inject(&handler.RecordChangesHandler{}))
 	r.Map("record:save", injector.Inject(&handler.RecordSaveHandler{}))
 	r.Map("record:delete", injector.Inject(&handler.RecordDeleteHandler{}))
 
 	r.Map("device:register", injector.Inject(&handler.DeviceRegisterHandler{}))
 	r.Map("device:unregister", injector.Inject(&handler.DeviceUnregisterHandler{}))
+	r.Map("device:subscribe_topic", injector.Inject(&handler.DeviceSubscribeTopicHandler{}))
+	r.Map("device:unsubscribe_topic", injector.Inject(&handler.DeviceUnsubscribeTopicHandler{}))
 
 	// subscription shares the same set of preprocessor as record read at the moment
 	r.Map("subscription:fetch_all", injector.Inject(&handler.SubscriptionFetchAllHandler{}))
@@ -229,30 +269,62 @@ func main() {
 	r.Map("user:query", injector.Inject(&handler.UserQueryHandler{}))
 	r.Map("user:update", injector.Inject(&handler.UserUpdateHandler{}))
 	r.Map("user:link", injector.Inject(&handler.UserLinkHandler{}))
+	r.Map("user:unlink", injector.Inject(&handler.UserUnlinkHandler{}))
+	r.Map("user:providers", injector.Inject(&handler.UserProvidersHandler{}))
 
 	r.Map("role:default", injector.Inject(&handler.RoleDefaultHandler{}))
 	r.Map("role:admin", injector.Inject(&handler.RoleAdminHandler{}))
+	r.Map("role:create", injector.Inject(&handler.RoleCreateHandler{}))
+	r.Map("role:list", injector.Inject(&handler.RoleListHandler{}))
+	r.Map("role:delete", injector.Inject(&handler.RoleDeleteHandler{}))
+	r.Map("role:assign", injector.Inject(&handler.RoleAssignHandler{}))
+	r.Map("role:revoke", injector.Inject(&handler.RoleRevokeHandler{}))
 
 	r.Map("push:user", injector.Inject(&handler.PushToUserHandler{}))
 	r.Map("push:device", injector.Inject(&handler.PushToDeviceHandler{}))
+	r.Map("push:query", injector.Inject(&handler.PushToQueryHandler{}))
+	r.Map("push:topic", injector.Inject(&handler.PushToTopicHandler{}))
+	r.Map("push:status", injector.Inject(&handler.PushStatusHandler{}))
+	r.Map("push:cancel", injector.Inject(&handler.PushCancelHandler{}))
+	r.Map("push:template:save", injector.Inject(&handler.PushTemplateSaveHandler{}))
+	r.Map("push:template:fetch", injector.Inject(&handler.PushTemplateFetchHandler{}))
+	r.Map("push:template:delete", injector.Inject(&handler.PushTemplateDeleteHandler{}))
+
+	r.Map("pubsub:rule:save", injector.Inject(&handler.PubSubRuleSaveHandler{}))
+	r.Map("pubsub:rule:fetch", injector.Inject(&handler.PubSubRuleFetchHandler{}))
+	r.Map("pubsub:rule:delete", injector.Inject(&handler.PubSubRuleDeleteHandler{}))
+	r.Map("pubsub:presence", injector.Inject(&handler.PubSubPresenceHandler{
+		Hub: publicHub,
+	}))
+	r.Map("pubsub:publish", injector.Inject(&handler.PubSubPublishHandler{
+		PubSub: pubSub,
+	}))
 
 	r.Map("schema:rename", injector.Inject(&handler.SchemaRenameHandler{}))
 	r.Map("schema:delete", injector.Inject(&handler.SchemaDeleteHandler{}))
 	r.Map("schema:create", injector.Inject(&handler.SchemaCreateHandler{}))
 	r.Map("schema:fetch", injector.Inject(&handler.SchemaFetchHandler{}))
 	r.Map("schema:access", injector.Inject(&handler.SchemaAccessHandler{}))
+	r.Map("schema:policy", injector.Inject(&handler.SchemaPolicyHandler{}))
+	r.Map("schema:acl_parent", injector.Inject(&handler.SchemaACLParentHandler{}))
 
 	serveMux.Handle("/", r)
 
 	// Following section is for Gateway
 	if !config.App.Slave {
-		pubSub := pubsub.NewWsPubsub(nil)
+		pubSub.QueryHandler = initLiveQuery(connOpener, assetStore)
 		pubSubGateway := router.NewGateway("", "/pubsub", serveMux)
 		pubSubGateway.GET(injector.InjectProcessors(&handler.PubSubHandler{
 			WebSocket: pubSub,
 		}))
+		pubSubGateway.POST(injector.InjectProcessors(&handler.PubSubPostHandler{
+			PubSub: pubSub,
+		}))
+		pubSubSSEGateway := router.NewGateway("", "/pubsub/sse", serveMux)
+		pubSubSSEGateway.GET(injector.InjectProcessors(&handler.PubSubSSEHandler{
+			PubSub: pubSub,
+		}))
 
-		internalPubSub := pubsub.NewWsPubsub(internalHub)
 		internalPubSubGateway := router.NewGateway("", "/_/pubsub", serveMux)
 		internalPubSubGateway.GET(injector.InjectProcessors(&handler.PubSubHandler{
 			WebSocket: internalPubSub,
@@ -261,12 +333,20 @@ func main() {
 
 	fileGateway := router.NewGateway("files/(.+)", "/files/", serveMux)
 	fileGateway.ResponseTimeout = time.Duration(config.App.ResponseTimeout) * time.Second
-	fileGateway.GET(injector.Inject(&handler.GetFileHandler{}))
+	getFileHandler := injector.Inject(&handler.GetFileHandler{
+		PublicVariantSizes: publicVariantSizes,
+	})
+	fileGateway.GET(getFileHandler)
+	fileGateway.Handle(http.MethodHead, getFileHandler)
 
 	uploadFileHandler := injector.Inject(&handler.UploadFileHandler{})
 	fileGateway.PUT(uploadFileHandler)
 	fileGateway.POST(uploadFileHandler)
 
+	uploadGateway := router.NewGateway("uploads/(.+)", "/uploads/", serveMux)
+	uploadGateway.ResponseTimeout = time.Duration(config.App.ResponseTimeout) * time.Second
+	uploadGateway.PUT(injector.Inject(&handler.UploadChunkHandler{}))
+
 	corsHost := config.App.CORSHost
 
 	var finalMux http.Handler
@@ -344,6 +424,18 @@ func ensureDB(config skyconfig.Configuration) func() (skydb.Conn, error) {
 	}
 }
 
+func initPublicVariantSizes(config skyconfig.Configuration) []asset.VariantSize {
+	sizes := []asset.VariantSize{}
+	for _, s := range config.AssetStore.PublicVariantSizes {
+		size, err := asset.ParseVariantSize(s)
+		if err != nil {
+			panic("failed to parse public variant size: " + err.Error())
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
+
 func initAssetStore(config skyconfig.Configuration) asset.Store {
 	var store asset.Store
 	switch config.AssetStore.ImplName {
@@ -386,6 +478,35 @@ func initAssetStore(config skyconfig.Configuration) asset.Store {
 	return store
 }
 
+// initPubSubHub returns a pubsub hub with the configured broker. name
+// separates messages of hubs sharing the same Redis.
+func initPubSubHub(config skyconfig.Configuration, name string) *pubsub.Hub {
+	hub := newPubSubHub(config, name)
+	hub.HistorySize = config.PubSub.HistorySize
+	hub.HistoryRetention = time.Duration(config.PubSub.HistoryRetention) * time.Second
+	return hub
+}
+
+func newPubSubHub(config skyconfig.Configuration, name string) *pubsub.Hub {
+	switch config.PubSub.Broker {
+	case "local":
+		return pubsub.NewHub()
+	case "redis":
+		if config.PubSub.RedisURL == "" {
+			log.Fatalf("PUBSUB_REDIS_URL is required for redis pubsub broker")
+		}
+		prefix := config.PubSub.Prefix
+		if prefix == "" {
+			prefix = config.App.Name
+		}
+		broker := pubsub.NewRedisBroker(config.PubSub.RedisURL, prefix+":"+name)
+		return pubsub.NewHubWithBroker(broker)
+	default:
+		log.Fatalf("Unknown pubsub broker: %s", config.PubSub.Broker)
+		return nil
+	}
+}
+
 func initDevice(config skyconfig.Configuration, connOpener func() (skydb.Conn, error)) {
 	// TODO: Create a device service to check APNs to remove obsolete devices.
 	// The current implementaion deletes pubsub devices if the last registered
@@ -410,6 +531,16 @@ func initPushSender(config skyconfig.Configuration, connOpener func() (skydb.Con
 		routeSender.Route("gcm", gcm)
 		routeSender.Route("android", gcm)
 	}
+	if config.FCM.Enable {
+		// FCM takes over android devices from the legacy GCM API
+		fcm := initFCMPusher(config, connOpener)
+		routeSender.Route("gcm", fcm)
+		routeSender.Route("android", fcm)
+	}
+	if config.WebPush.Enable {
+		webPush := initWebPushPusher(config, connOpener)
+		routeSender.Route("web", webPush)
+	}
 	return routeSender
 }
 
@@ -497,6 +628,128 @@ func initGCMPusher(config skyconfig.Configuration) *push.GCMPusher {
 	return &push.GCMPusher{APIKey: config.GCM.APIKey}
 }
 
+func initFCMPusher(config skyconfig.Configuration, connOpener func() (skydb.Conn, error)) *push.FCMPusher {
+	serviceAccount := config.FCM.ServiceAccount
+	serviceAccountPath := config.FCM.ServiceAccountPath
+	if serviceAccount == "" && serviceAccountPath != "" {
+		serviceAccountBytes, err := ioutil.ReadFile(serviceAccountPath)
+		if err != nil {
+			log.Fatalf("Failed to load FCM service account: %v", err)
+		}
+
+		serviceAccount = string(serviceAccountBytes)
+	}
+
+	pushSender, err := push.NewFCMPusher(
+		connOpener,
+		serviceAccount,
+		config.FCM.TokenURL,
+		config.FCM.BaseURL,
+	)
+	if err != nil {
+		log.Fatalf("Failed to set up FCM push sender: %v", err)
+	}
+
+	return pushSender
+}
+
+func initWebPushPusher(config skyconfig.Configuration, connOpener func() (skydb.Conn, error)) *push.WebPushPusher {
+	pushSender, err := push.NewWebPushPusher(
+		connOpener,
+		config.WebPush.Subject,
+		config.WebPush.PublicKey,
+		config.WebPush.PrivateKey,
+	)
+	if err != nil {
+		log.Fatalf("Failed to set up web push sender: %v", err)
+	}
+
+	return pushSender
+}
+
+func initPushQueue(config skyconfig.Configuration, connOpener func() (skydb.Conn, error), pushSender push.Sender) {
+	queue := push.NewQueue(connOpener, pushSender)
+	if config.PushQueue.Workers > 0 {
+		queue.Workers = config.PushQueue.Workers
+	}
+	if config.PushQueue.MaxAttempts > 0 {
+		queue.MaxAttempts = config.PushQueue.MaxAttempts
+	}
+	log.Infof("Push queue started with %d workers", queue.Workers)
+	queue.Start()
+}
+
+func initPushScheduler(cronjob *cron.Cron, connOpener func() (skydb.Conn, error)) {
+	scheduler := push.NewScheduler(connOpener, handler.DispatchPushSchedule)
+	if err := cronjob.AddFunc("@every 1m", scheduler.Run); err != nil {
+		log.Fatalf("Failed to schedule push scheduler: %v", err)
+	}
+}
+
+func initRecordChangePruner(cronjob *cron.Cron, connOpener func() (skydb.Conn, error)) {
+	prune := func() {
+		conn, err := connOpener()
+		if err != nil {
+			log.WithField("err", err).Errorln("Failed to open skydb.Conn to prune record changes")
+			return
+		}
+		defer conn.Close()
+
+		if err := handler.PruneRecordChanges(conn); err != nil {
+			log.WithField("err", err).Errorln("Failed to prune record changes")
+		}
+	}
+	if err := cronjob.AddFunc("@every 1h", prune); err != nil {
+		log.Fatalf("Failed to schedule record change pruner: %v", err)
+	}
+}
+
+func initUploadSessionPruner(cronjob *cron.Cron, connOpener func() (skydb.Conn, error), assetStore asset.Store) {
+	uploader, ok := assetStore.(asset.ChunkUploader)
+	if !ok {
+		return
+	}
+
+	prune := func() {
+		conn, err := connOpener()
+		if err != nil {
+			log.WithField("err", err).Errorln("Failed to open skydb.Conn to prune upload sessions")
+			return
+		}
+		defer conn.Close()
+
+		aborted, err := handler.PruneUploadSessions(conn, uploader)
+		if err != nil {
+			log.WithField("err", err).Errorln("Failed to prune upload sessions")
+		}
+		log.Infof("Aborted %d expired uploads", aborted)
+	}
+	if err := cronjob.AddFunc("@every 1h", prune); err != nil {
+		log.Fatalf("Failed to schedule upload session pruner: %v", err)
+	}
+}
+
+func initAssetCollector(config skyconfig.Configuration, cronjob *cron.Cron, connOpener func() (skydb.Conn, error), assetStore asset.Store) {
+	gracePeriod := time.Duration(config.AssetStore.GC.GracePeriod) * time.Second
+	collect := func() {
+		conn, err := connOpener()
+		if err != nil {
+			log.WithField("err", err).Errorln("Failed to open skydb.Conn to collect unreferenced assets")
+			return
+		}
+		defer conn.Close()
+
+		deleted, err := handler.CollectUnreferencedAssets(conn, assetStore, gracePeriod)
+		if err != nil {
+			log.WithField("err", err).Errorln("Failed to collect unreferenced assets")
+		}
+		log.Infof("Deleted %d unreferenced assets", deleted)
+	}
+	if err := cronjob.AddFunc("@every 24h", collect); err != nil {
+		log.Fatalf("Failed to schedule asset collector: %v", err)
+	}
+}
+
 func initSubscription(config skyconfig.Configuration, connOpener func() (skydb.Conn, error), hub *pubsub.Hub, pushSender push.Sender) {
 	notifiers := []subscription.Notifier{subscription.NewHubNotifier(hub)}
 	if pushSender != nil {
@@ -511,6 +764,16 @@ func initSubscription(config skyconfig.Configuration, connOpener func() (skydb.C
 	go subscriptionService.Run()
 }
 
+func initLiveQuery(connOpener func() (skydb.Conn, error), assetStore asset.Store) *handler.LiveQueryService {
+	liveQueryService := &handler.LiveQueryService{
+		ConnOpener: connOpener,
+		AssetStore: assetStore,
+	}
+	log.Infoln("Live Query Service listening...")
+	go liveQueryService.Run()
+	return liveQueryService
+}
+
 func initPlugin(config skyconfig.Configuration, ctx *plugin.Context) {
 	log.Infof("Supported plugin transports: %s", strings.Join(plugin.SupportedTransports(), ", "))
 
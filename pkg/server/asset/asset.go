@@ -16,6 +16,7 @@ package asset
 
 import (
 	"io"
+	"net/url"
 	"time"
 
 	"github.com/skygeario/skygear-server/pkg/server/logging"
@@ -34,6 +35,10 @@ type Store interface {
 	GetFileReader(name string) (io.ReadCloser, error)
 	PutFileReader(name string, src io.Reader, length int64, contentType string) error
 	GeneratePostFileRequest(name string) (*PostFileRequest, error)
+
+	// Delete deletes the named file and its cached variants. Deleting a
+	// file which does not exist is not an error.
+	Delete(name string) error
 }
 
 // URLSigner signs a signature and returns a URL accessible to that asset.
@@ -45,6 +50,22 @@ type URLSigner interface {
 	IsSignatureRequired() bool
 }
 
+// QueryURLSigner signs a URL with query parameters to an asset, such as
+// the parameters of a variant. The query is covered by the signature,
+// which is parsed with SignedNameWithQuery as the name.
+type QueryURLSigner interface {
+	SignedURLWithQuery(name string, query url.Values) (string, error)
+}
+
+// SignedNameWithQuery returns the name covered by the signature of a URL
+// to the named asset with the query.
+func SignedNameWithQuery(name string, query url.Values) string {
+	if len(query) == 0 {
+		return name
+	}
+	return name + "?" + query.Encode()
+}
+
 // SignatureParser parses a signed signature string
 type SignatureParser interface {
 	ParseSignature(signed string, name string, expiredAt time.Time) (valid bool, err error)
@@ -0,0 +1,45 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import "io"
+
+// MaxChunks is the maximum number of chunks of a chunked upload, which
+// is the limit of parts of a S3 multipart upload.
+const MaxChunks = 10000
+
+// ChunkUploader is implemented by asset stores which upload a file in
+// chunks, so that an upload interrupted by a dropped connection is
+// resumed from the last chunk uploaded.
+type ChunkUploader interface {
+	// BeginChunkUpload starts a chunked upload of the named file and
+	// returns the ID of the upload.
+	BeginChunkUpload(name string, contentType string) (uploadID string, err error)
+
+	// PutChunk stores the n-th chunk of the upload, counting from 1.
+	// Putting a chunk which is already put replaces it.
+	PutChunk(name string, uploadID string, n int, src io.ReadSeeker, length int64) error
+
+	// CompleteChunkUpload assembles the first n chunks of the upload
+	// into the named file.
+	CompleteChunkUpload(name string, uploadID string, n int) error
+
+	// AbortChunkUpload discards the chunks of the upload.
+	AbortChunkUpload(name string, uploadID string) error
+
+	// MinChunkSize returns the minimum size of chunks except the last
+	// one.
+	MinChunkSize() int64
+}
@@ -19,6 +19,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -162,6 +163,39 @@ func (s cloudStore) GetFileReader(name string) (io.ReadCloser, error) {
 	)
 }
 
+// GetRangeReader returns a reader for a range of a file with a ranged
+// GET of the signed URL
+func (s cloudStore) GetRangeReader(name string, offset int64, length int64) (io.ReadCloser, error) {
+	signedURL, err := s.SignedURL(name)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, signedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the whole file is returned if the range is ignored
+	if res.StatusCode != http.StatusPartialContent &&
+		!(res.StatusCode == http.StatusOK && offset == 0) {
+		res.Body.Close()
+		log.WithFields(logrus.Fields{
+			"name":   name,
+			"status": res.StatusCode,
+		}).Error("Fail to get range of Cloud Asset")
+
+		return nil, errors.New("Fail to get range of Cloud Asset")
+	}
+	return res.Body, nil
+}
+
 // PutFileReader return a writer for uploading files
 func (s cloudStore) PutFileReader(
 	name string,
@@ -215,6 +249,45 @@ func (s cloudStore) GeneratePostFileRequest(name string) (*PostFileRequest, erro
 	return postRequest, nil
 }
 
+// Delete requests the cloud asset store to delete a file. Variants are
+// not cached in the cloud asset store, which does not support
+// PutFileReader, so there are no variants to delete.
+func (s cloudStore) Delete(name string) error {
+	urlString := strings.Join(
+		[]string{s.host, "asset", s.appName, name},
+		"/",
+	)
+
+	req := goreq.Request{
+		Method:  http.MethodDelete,
+		Uri:     urlString,
+		Timeout: 10 * time.Second,
+	}.WithHeader("Authorization", "Bearer "+s.authToken)
+
+	res, err := req.Do()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"url":   urlString,
+			"error": err,
+		}).Error("Fail to request for deleting Cloud Asset")
+
+		return errors.New("Fail to request for deleting Cloud Asset")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound &&
+		(res.StatusCode < 200 || res.StatusCode >= 300) {
+		log.WithFields(logrus.Fields{
+			"url":    urlString,
+			"status": res.StatusCode,
+		}).Error("Fail to delete Cloud Asset")
+
+		return errors.New("Fail to delete Cloud Asset")
+	}
+
+	return nil
+}
+
 // SignedURL return a signed URL with expiry date
 func (s cloudStore) SignedURL(name string) (string, error) {
 	targetURLString := strings.Join(
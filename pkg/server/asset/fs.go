@@ -23,11 +23,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/uuid"
 )
 
 // fileStore implements Store by storing files on file system
@@ -76,6 +79,114 @@ func (s *fileStore) PutFileReader(name string, src io.Reader, length int64, cont
 	return nil
 }
 
+// BeginChunkUpload starts a chunked upload, which chunks are stored in
+// a directory of the upload until they are assembled
+func (s *fileStore) BeginChunkUpload(name string, contentType string) (string, error) {
+	uploadID := uuid.New()
+	if err := os.MkdirAll(s.uploadPath(uploadID), 0755); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// PutChunk stores a chunk of an upload
+func (s *fileStore) PutChunk(name string, uploadID string, n int, src io.ReadSeeker, length int64) error {
+	path := s.chunkPath(uploadID, n)
+
+	// the chunk is renamed after written, so that an interrupted chunk
+	// never replaces a chunk which is put, and chunks put at the same
+	// time never write to the same file
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := f.Name()
+
+	written, err := io.Copy(f, src)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written != length {
+		err = fmt.Errorf("got written %d bytes, expect %d", written, length)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return os.Rename(tempPath, path)
+}
+
+// CompleteChunkUpload assembles the chunks of an upload into the file
+func (s *fileStore) CompleteChunkUpload(name string, uploadID string, n int) error {
+	path := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// the chunks are assembled in the directory of the upload, so that
+	// the file is not replaced by an incomplete one
+	assembledPath := filepath.Join(s.uploadPath(uploadID), "assembled")
+	f, err := os.Create(assembledPath)
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i <= n && err == nil; i++ {
+		err = appendFile(f, s.chunkPath(uploadID, i))
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(assembledPath)
+		return err
+	}
+
+	if err := os.Rename(assembledPath, path); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.uploadPath(uploadID))
+}
+
+// AbortChunkUpload removes the chunks of an upload
+func (s *fileStore) AbortChunkUpload(name string, uploadID string) error {
+	return os.RemoveAll(s.uploadPath(uploadID))
+}
+
+// MinChunkSize returns 1 as chunks of any size are assembled
+func (s *fileStore) MinChunkSize() int64 {
+	return 1
+}
+
+func (s *fileStore) uploadPath(uploadID string) string {
+	return filepath.Join(s.dir, ".uploads", uploadID)
+}
+
+func (s *fileStore) chunkPath(uploadID string, n int) string {
+	return filepath.Join(s.uploadPath(uploadID), strconv.Itoa(n))
+}
+
+func appendFile(dst io.Writer, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// Delete removes a file and its cached variants from file system
+func (s *fileStore) Delete(name string) error {
+	err := os.Remove(filepath.Join(s.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(s.dir, variantCacheDir(name)))
+}
+
 // GeneratePostFileRequest return a PostFileRequest for uploading asset
 func (s *fileStore) GeneratePostFileRequest(name string) (*PostFileRequest, error) {
 	return &PostFileRequest{
@@ -92,6 +203,33 @@ func (s *fileStore) SignedURL(name string) (string, error) {
 	expiredAt := time.Now().Add(time.Minute * time.Duration(15))
 	expiredAtStr := strconv.FormatInt(expiredAt.Unix(), 10)
 
+	return fmt.Sprintf(
+		"%s/%s?expiredAt=%s&signature=%s",
+		s.prefix, name, expiredAtStr, s.sign(name, expiredAtStr),
+	), nil
+}
+
+// SignedURLWithQuery returns a signed url with the query and expiry
+// date. The query is covered by the signature.
+func (s *fileStore) SignedURLWithQuery(name string, query url.Values) (string, error) {
+	if len(query) == 0 {
+		return s.SignedURL(name)
+	}
+	if !s.IsSignatureRequired() {
+		return fmt.Sprintf("%s/%s?%s", s.prefix, name, query.Encode()), nil
+	}
+
+	expiredAt := time.Now().Add(time.Minute * time.Duration(15))
+	expiredAtStr := strconv.FormatInt(expiredAt.Unix(), 10)
+
+	return fmt.Sprintf(
+		"%s/%s?%s&expiredAt=%s&signature=%s",
+		s.prefix, name, query.Encode(), expiredAtStr,
+		s.sign(SignedNameWithQuery(name, query), expiredAtStr),
+	), nil
+}
+
+func (s *fileStore) sign(name string, expiredAtStr string) string {
 	h := hmac.New(sha256.New, []byte(s.secret))
 	io.WriteString(h, name)
 	io.WriteString(h, expiredAtStr)
@@ -99,11 +237,8 @@ func (s *fileStore) SignedURL(name string) (string, error) {
 	buf := bytes.Buffer{}
 	base64Encoder := base64.NewEncoder(base64.URLEncoding, &buf)
 	base64Encoder.Write(h.Sum(nil))
-
-	return fmt.Sprintf(
-		"%s/%s?expiredAt=%s&signature=%s",
-		s.prefix, name, expiredAtStr, buf.String(),
-	), nil
+	base64Encoder.Close()
+	return buf.String()
 }
 
 // ParseSignature tries to parse the asset signature
@@ -120,7 +255,7 @@ func (s *fileStore) ParseSignature(signed string, name string, expiredAt time.Ti
 	io.WriteString(h, name)
 	io.WriteString(h, strconv.FormatInt(expiredAt.Unix(), 10))
 
-	return !hmac.Equal(remoteSignature, h.Sum(nil)), nil
+	return hmac.Equal(remoteSignature, h.Sum(nil)), nil
 }
 
 // IsSignatureRequired indicates whether a signature is required
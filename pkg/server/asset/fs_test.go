@@ -0,0 +1,144 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFileStoreSignature(t *testing.T) {
+	Convey("fileStore signature", t, func() {
+		store := NewFileStore("", "http://skygear.test/files", "secret", false).(*fileStore)
+
+		parse := func(signedURL string, name string) bool {
+			u, err := url.Parse(signedURL)
+			So(err, ShouldBeNil)
+			query := u.Query()
+			expiredAtUnix, err := strconv.ParseInt(query.Get("expiredAt"), 10, 64)
+			So(err, ShouldBeNil)
+			valid, err := store.ParseSignature(query.Get("signature"), name, time.Unix(expiredAtUnix, 0))
+			So(err, ShouldBeNil)
+			return valid
+		}
+
+		Convey("parses signature of signed URL", func() {
+			signedURL, err := store.SignedURL("photo.jpg")
+			So(err, ShouldBeNil)
+			So(signedURL, ShouldStartWith, "http://skygear.test/files/photo.jpg?expiredAt=")
+			So(parse(signedURL, "photo.jpg"), ShouldBeTrue)
+			So(parse(signedURL, "other.jpg"), ShouldBeFalse)
+		})
+
+		Convey("rejects tampered signature", func() {
+			signedURL, err := store.SignedURL("photo.jpg")
+			So(err, ShouldBeNil)
+			i := strings.Index(signedURL, "signature=") + len("signature=")
+			replacement := "A"
+			if signedURL[i] == 'A' {
+				replacement = "B"
+			}
+			tampered := signedURL[:i] + replacement + signedURL[i+1:]
+			So(parse(tampered, "photo.jpg"), ShouldBeFalse)
+		})
+
+		Convey("covers query by signature", func() {
+			query := url.Values{"width": []string{"64"}}
+			signedURL, err := store.SignedURLWithQuery("photo.jpg", query)
+			So(err, ShouldBeNil)
+			So(signedURL, ShouldStartWith, "http://skygear.test/files/photo.jpg?width=64&expiredAt=")
+			So(parse(signedURL, SignedNameWithQuery("photo.jpg", query)), ShouldBeTrue)
+			So(parse(signedURL, "photo.jpg"), ShouldBeFalse)
+			So(parse(signedURL, SignedNameWithQuery("photo.jpg", url.Values{"width": []string{"2048"}})), ShouldBeFalse)
+		})
+
+		Convey("returns unsigned URL with query for public store", func() {
+			store.public = true
+			signedURL, err := store.SignedURLWithQuery("photo.jpg", url.Values{"width": []string{"64"}})
+			So(err, ShouldBeNil)
+			So(signedURL, ShouldEqual, "http://skygear.test/files/photo.jpg?width=64")
+		})
+	})
+}
+
+func TestFileStoreChunkUpload(t *testing.T) {
+	Convey("fileStore chunk upload", t, func() {
+		dir, err := ioutil.TempDir("", "skygear-asset")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store := NewFileStore(dir, "http://skygear.test/files", "secret", false).(*fileStore)
+		uploadID, err := store.BeginChunkUpload("video/note.txt", "plain/text")
+		So(err, ShouldBeNil)
+		So(uploadID, ShouldNotBeEmpty)
+
+		Convey("assembles chunks into file", func() {
+			So(store.PutChunk("video/note.txt", uploadID, 1, strings.NewReader("I am"), 4), ShouldBeNil)
+			So(store.PutChunk("video/note.txt", uploadID, 2, strings.NewReader(" a boy"), 6), ShouldBeNil)
+			So(store.CompleteChunkUpload("video/note.txt", uploadID, 2), ShouldBeNil)
+
+			content, err := ioutil.ReadFile(filepath.Join(dir, "video/note.txt"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "I am a boy")
+
+			_, err = os.Stat(store.uploadPath(uploadID))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("replaces chunk which is put again", func() {
+			So(store.PutChunk("video/note.txt", uploadID, 1, strings.NewReader("I was"), 5), ShouldBeNil)
+			So(store.PutChunk("video/note.txt", uploadID, 1, strings.NewReader("I am"), 4), ShouldBeNil)
+			So(store.CompleteChunkUpload("video/note.txt", uploadID, 1), ShouldBeNil)
+
+			content, err := ioutil.ReadFile(filepath.Join(dir, "video/note.txt"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "I am")
+		})
+
+		Convey("keeps chunk on interrupted put", func() {
+			So(store.PutChunk("video/note.txt", uploadID, 1, strings.NewReader("I am"), 4), ShouldBeNil)
+			So(store.PutChunk("video/note.txt", uploadID, 1, strings.NewReader("I"), 4), ShouldNotBeNil)
+			So(store.CompleteChunkUpload("video/note.txt", uploadID, 1), ShouldBeNil)
+
+			content, err := ioutil.ReadFile(filepath.Join(dir, "video/note.txt"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "I am")
+		})
+
+		Convey("errors on missing chunk", func() {
+			So(store.PutChunk("video/note.txt", uploadID, 2, strings.NewReader(" a boy"), 6), ShouldBeNil)
+			So(store.CompleteChunkUpload("video/note.txt", uploadID, 2), ShouldNotBeNil)
+
+			_, err := os.Stat(filepath.Join(dir, "video/note.txt"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("removes chunks on abort", func() {
+			So(store.PutChunk("video/note.txt", uploadID, 1, strings.NewReader("I am"), 4), ShouldBeNil)
+			So(store.AbortChunkUpload("video/note.txt", uploadID), ShouldBeNil)
+
+			_, err = os.Stat(store.uploadPath(uploadID))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,108 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"errors"
+	"io"
+)
+
+// RangeReader is implemented by asset stores which read a range of a
+// file without reading the whole file, such as with a ranged GET.
+type RangeReader interface {
+	// GetRangeReader returns a reader for length bytes of the named file
+	// starting at offset.
+	GetRangeReader(name string, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// ReadSeekCloser is a seekable reader of a file which is closed after
+// reading.
+type ReadSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// rangeReadSeeker is a seekable reader of a file read with a RangeReader.
+// The file is read from the current offset to the end when it is first
+// read after seeking.
+type rangeReadSeeker struct {
+	store  RangeReader
+	name   string
+	size   int64
+	offset int64
+	reader io.ReadCloser
+}
+
+// NewRangeReadSeeker returns a seekable reader of the named file of the
+// size, which is read with ranged reads of the store.
+func NewRangeReadSeeker(store RangeReader, name string, size int64) ReadSeekCloser {
+	return &rangeReadSeeker{
+		store: store,
+		name:  name,
+		size:  size,
+	}
+}
+
+func (r *rangeReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.reader == nil {
+		reader, err := r.store.GetRangeReader(r.name, r.offset, r.size-r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.reader = reader
+	}
+
+	n, err := r.reader.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *rangeReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset != r.offset {
+		r.closeReader()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *rangeReadSeeker) Close() error {
+	return r.closeReader()
+}
+
+func (r *rangeReadSeeker) closeReader() error {
+	if r.reader == nil {
+		return nil
+	}
+	err := r.reader.Close()
+	r.reader = nil
+	return err
+}
@@ -0,0 +1,112 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type stringRangeReader struct {
+	content string
+	offsets []int64
+	closed  int
+}
+
+func (r *stringRangeReader) GetRangeReader(name string, offset int64, length int64) (io.ReadCloser, error) {
+	r.offsets = append(r.offsets, offset)
+	return &closeCounter{
+		Reader: strings.NewReader(r.content[offset : offset+length]),
+		closed: &r.closed,
+	}, nil
+}
+
+type closeCounter struct {
+	io.Reader
+	closed *int
+}
+
+func (c *closeCounter) Close() error {
+	*c.closed++
+	return nil
+}
+
+func TestRangeReadSeeker(t *testing.T) {
+	Convey("RangeReadSeeker", t, func() {
+		store := &stringRangeReader{content: "I am a boy"}
+		reader := NewRangeReadSeeker(store, "note.txt", 10)
+
+		Convey("reads whole file", func() {
+			data, err := ioutil.ReadAll(reader)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "I am a boy")
+			So(store.offsets, ShouldResemble, []int64{0})
+		})
+
+		Convey("does not read on seek", func() {
+			offset, err := reader.Seek(0, io.SeekEnd)
+			So(err, ShouldBeNil)
+			So(offset, ShouldEqual, 10)
+
+			offset, err = reader.Seek(-5, io.SeekCurrent)
+			So(err, ShouldBeNil)
+			So(offset, ShouldEqual, 5)
+			So(store.offsets, ShouldBeEmpty)
+
+			data, err := ioutil.ReadAll(reader)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "a boy")
+			So(store.offsets, ShouldResemble, []int64{5})
+		})
+
+		Convey("reads again after seeking", func() {
+			p := make([]byte, 4)
+			_, err := io.ReadFull(reader, p)
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "I am")
+
+			_, err = reader.Seek(2, io.SeekStart)
+			So(err, ShouldBeNil)
+			So(store.closed, ShouldEqual, 1)
+
+			_, err = io.ReadFull(reader, p)
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "am a")
+			So(store.offsets, ShouldResemble, []int64{0, 2})
+
+			So(reader.Close(), ShouldBeNil)
+			So(store.closed, ShouldEqual, 2)
+		})
+
+		Convey("returns EOF at the end", func() {
+			_, err := reader.Seek(10, io.SeekStart)
+			So(err, ShouldBeNil)
+
+			n, err := reader.Read(make([]byte, 4))
+			So(n, ShouldEqual, 0)
+			So(err, ShouldEqual, io.EOF)
+			So(store.offsets, ShouldBeEmpty)
+		})
+
+		Convey("rejects negative position", func() {
+			_, err := reader.Seek(-1, io.SeekStart)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -69,6 +69,17 @@ func (s *s3Store) GetFileReader(name string) (io.ReadCloser, error) {
 	return s.bucket.GetReader(name)
 }
 
+// GetRangeReader returns a reader for a range of a file with a ranged GET
+func (s *s3Store) GetRangeReader(name string, offset int64, length int64) (io.ReadCloser, error) {
+	resp, err := s.bucket.GetResponseWithHeaders(name, map[string][]string{
+		"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
 // PutFileReader uploads a file to s3 with content from io.Reader
 func (s *s3Store) PutFileReader(
 	name string,
@@ -80,6 +91,97 @@ func (s *s3Store) PutFileReader(
 	return s.bucket.PutReader(name, src, length, contentType, s3.Private)
 }
 
+// Delete deletes a file and its cached variants on s3
+func (s *s3Store) Delete(name string) error {
+	if err := s.bucket.Del(name); err != nil {
+		return err
+	}
+
+	prefix := variantCacheDir(name) + "/"
+	marker := ""
+	for {
+		list, err := s.bucket.List(prefix, "", marker, 1000)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range list.Contents {
+			if err := s.bucket.Del(key.Key); err != nil {
+				return err
+			}
+		}
+
+		if !list.IsTruncated || len(list.Contents) == 0 {
+			return nil
+		}
+		marker = list.Contents[len(list.Contents)-1].Key
+	}
+}
+
+// BeginChunkUpload starts a multipart upload to s3
+func (s *s3Store) BeginChunkUpload(name string, contentType string) (string, error) {
+	multi, err := s.bucket.InitMulti(name, contentType, s3.Private)
+	if err != nil {
+		return "", err
+	}
+	return multi.UploadId, nil
+}
+
+// PutChunk uploads a chunk as a part of the multipart upload
+func (s *s3Store) PutChunk(name string, uploadID string, n int, src io.ReadSeeker, length int64) error {
+	part, err := s.multi(name, uploadID).PutPart(n, src)
+	if err != nil {
+		return err
+	}
+
+	if part.Size != length {
+		return fmt.Errorf("got uploaded %d bytes, expect %d", part.Size, length)
+	}
+	return nil
+}
+
+// CompleteChunkUpload completes the multipart upload with the parts
+// uploaded
+func (s *s3Store) CompleteChunkUpload(name string, uploadID string, n int) error {
+	multi := s.multi(name, uploadID)
+	parts, err := multi.ListParts()
+	if err != nil {
+		return err
+	}
+
+	completeParts := make([]s3.Part, n)
+	for _, part := range parts {
+		if part.N >= 1 && part.N <= n {
+			completeParts[part.N-1] = part
+		}
+	}
+	for i, part := range completeParts {
+		if part.N == 0 {
+			return fmt.Errorf("part %d of multipart upload not found", i+1)
+		}
+	}
+
+	return multi.Complete(completeParts)
+}
+
+// AbortChunkUpload aborts the multipart upload
+func (s *s3Store) AbortChunkUpload(name string, uploadID string) error {
+	return s.multi(name, uploadID).Abort()
+}
+
+// MinChunkSize returns the minimum size of a part of s3 multipart upload
+func (s *s3Store) MinChunkSize() int64 {
+	return 5 << 20
+}
+
+func (s *s3Store) multi(name string, uploadID string) *s3.Multi {
+	return &s3.Multi{
+		Bucket:   s.bucket,
+		Key:      name,
+		UploadId: uploadID,
+	}
+}
+
 // GeneratePostFileRequest return a PostFileRequest for uploading asset
 func (s *s3Store) GeneratePostFileRequest(name string) (*PostFileRequest, error) {
 	return &PostFileRequest{
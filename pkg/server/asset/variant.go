@@ -0,0 +1,492 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	// register the GIF decoder
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxVariantDimension is the maximum width and height of a variant.
+	MaxVariantDimension = 2048
+
+	// MaxVariantSourceSize is the maximum size in bytes of an image from
+	// which variants are generated.
+	MaxVariantSourceSize = 20 << 20
+
+	// MaxVariantSourcePixels is the maximum number of pixels of an image
+	// from which variants are generated, which prevents a small image
+	// file from taking up a lot of memory when decoded.
+	MaxVariantSourcePixels = 40000000
+
+	defaultVariantQuality = 85
+)
+
+var (
+	// ErrVariantSourceTooLarge is returned when generating a variant of
+	// an image exceeding MaxVariantSourceSize or MaxVariantSourcePixels.
+	ErrVariantSourceTooLarge = errors.New("image is too large to be transformed")
+
+	// ErrVariantSourceNotSupported is returned when generating a variant
+	// of an asset which is not a supported image.
+	ErrVariantSourceNotSupported = errors.New("asset is not a supported image")
+)
+
+// VariantFit is how an image is resized to the width and height of a
+// variant.
+type VariantFit string
+
+const (
+	// VariantFitContain resizes the image to fit within the width and
+	// height, preserving the aspect ratio.
+	VariantFitContain VariantFit = "contain"
+
+	// VariantFitCover resizes the image to cover the width and height,
+	// preserving the aspect ratio, and crops the image at the center.
+	VariantFitCover VariantFit = "cover"
+
+	// VariantFitFill resizes the image to the width and height, ignoring
+	// the aspect ratio.
+	VariantFitFill VariantFit = "fill"
+)
+
+// variantContentTypes maps supported image content types to the format
+// of variants by default.
+var variantContentTypes = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "png",
+}
+
+// IsVariantSupported returns whether variants can be generated from an
+// asset of the content type.
+func IsVariantSupported(contentType string) bool {
+	_, ok := variantContentTypes[contentType]
+	return ok
+}
+
+// VariantParamError is returned when a query parameter of a variant is
+// invalid.
+type VariantParamError struct {
+	Param   string
+	Message string
+}
+
+func (e *VariantParamError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Message)
+}
+
+// Variant is an image transformed from an image asset, specified by the
+// query parameters width, height, fit, format and quality of the asset
+// URL.
+//
+// An image is scaled down to the width or height preserving the aspect
+// ratio if only one of them is specified, and is never scaled up unless
+// both are specified with the fit cover or fill. A variant is encoded in
+// the format of the image, or PNG for GIF, unless format is specified.
+// Quality is the quality of a JPEG variant.
+type Variant struct {
+	Width   int
+	Height  int
+	Fit     VariantFit
+	Format  string
+	Quality int
+}
+
+// ParseVariant parses the variant from the query parameters of an asset
+// URL. It returns nil if no variant is specified.
+func ParseVariant(query url.Values) (*Variant, error) {
+	v := Variant{}
+	specified := false
+
+	parseInt := func(param string, min, max int) (int, error) {
+		s := query.Get(param)
+		if s == "" {
+			return 0, nil
+		}
+		specified = true
+		i, err := strconv.Atoi(s)
+		if err != nil || i < min || i > max {
+			return 0, &VariantParamError{
+				Param:   param,
+				Message: fmt.Sprintf("must be an integer between %d and %d", min, max),
+			}
+		}
+		return i, nil
+	}
+
+	var err error
+	if v.Width, err = parseInt("width", 1, MaxVariantDimension); err != nil {
+		return nil, err
+	}
+	if v.Height, err = parseInt("height", 1, MaxVariantDimension); err != nil {
+		return nil, err
+	}
+	if v.Quality, err = parseInt("quality", 1, 100); err != nil {
+		return nil, err
+	}
+
+	if fit := query.Get("fit"); fit != "" {
+		specified = true
+		switch VariantFit(fit) {
+		case VariantFitContain, VariantFitCover, VariantFitFill:
+			v.Fit = VariantFit(fit)
+		default:
+			return nil, &VariantParamError{"fit", "must be contain, cover or fill"}
+		}
+		if v.Width == 0 || v.Height == 0 {
+			return nil, &VariantParamError{"fit", "requires both width and height"}
+		}
+	}
+
+	if format := query.Get("format"); format != "" {
+		specified = true
+		switch format {
+		case "jpeg", "jpg":
+			v.Format = "jpeg"
+		case "png":
+			v.Format = "png"
+		default:
+			return nil, &VariantParamError{"format", "must be jpeg or png"}
+		}
+	}
+
+	if !specified {
+		return nil, nil
+	}
+	return &v, nil
+}
+
+// VariantSize is the width and height of a variant, either of which is
+// 0 if not specified.
+type VariantSize struct {
+	Width  int
+	Height int
+}
+
+// ParseVariantSize parses a variant size in the format WIDTHxHEIGHT,
+// such as 64x64. Either of the width and height can be omitted, such as
+// 256x for a variant of width 256.
+func ParseVariantSize(s string) (VariantSize, error) {
+	parts := strings.Split(s, "x")
+	if len(parts) != 2 || (parts[0] == "" && parts[1] == "") {
+		return VariantSize{}, fmt.Errorf("invalid variant size %q", s)
+	}
+
+	parse := func(dimension string) (int, error) {
+		if dimension == "" {
+			return 0, nil
+		}
+		i, err := strconv.Atoi(dimension)
+		if err != nil || i < 1 || i > MaxVariantDimension {
+			return 0, fmt.Errorf("invalid variant size %q", s)
+		}
+		return i, nil
+	}
+
+	width, err := parse(parts[0])
+	if err != nil {
+		return VariantSize{}, err
+	}
+	height, err := parse(parts[1])
+	if err != nil {
+		return VariantSize{}, err
+	}
+	return VariantSize{width, height}, nil
+}
+
+// Size returns the width and height of the variant.
+func (v *Variant) Size() VariantSize {
+	return VariantSize{v.Width, v.Height}
+}
+
+// Query returns the query parameters of the variant. The encoded query
+// is the same for equivalent variants, which is covered by the
+// signature of the variant URL.
+func (v *Variant) Query() url.Values {
+	query := url.Values{}
+	if v.Width != 0 {
+		query.Set("width", strconv.Itoa(v.Width))
+	}
+	if v.Height != 0 {
+		query.Set("height", strconv.Itoa(v.Height))
+	}
+	if v.Fit != "" && v.Fit != VariantFitContain {
+		query.Set("fit", string(v.Fit))
+	}
+	if v.Format != "" {
+		query.Set("format", v.Format)
+	}
+	if v.Quality != 0 {
+		query.Set("quality", strconv.Itoa(v.Quality))
+	}
+	return query
+}
+
+// CacheName returns the name of the variant of the named asset, with
+// which the generated variant is stored in an asset store. The name is
+// not accessible as an asset because it starts with a dot.
+func (v *Variant) CacheName(name string) string {
+	parts := []string{}
+	if v.Width != 0 {
+		parts = append(parts, "w"+strconv.Itoa(v.Width))
+	}
+	if v.Height != 0 {
+		parts = append(parts, "h"+strconv.Itoa(v.Height))
+	}
+	if v.Fit != "" && v.Fit != VariantFitContain {
+		parts = append(parts, string(v.Fit))
+	}
+	if v.Quality != 0 {
+		parts = append(parts, "q"+strconv.Itoa(v.Quality))
+	}
+	if v.Format != "" {
+		parts = append(parts, v.Format)
+	}
+	return variantCacheDir(name) + "/" + strings.Join(parts, "_")
+}
+
+// variantCacheDir returns the directory in which the variants of the
+// named asset are stored.
+func variantCacheDir(name string) string {
+	return ".variants/" + name
+}
+
+// ContentType returns the content type of the variant generated from an
+// image of the content type.
+func (v *Variant) ContentType(contentType string) string {
+	if v.Format != "" {
+		return "image/" + v.Format
+	}
+	return "image/" + variantContentTypes[contentType]
+}
+
+// Transform generates the variant from an image of the content type. It
+// returns the encoded variant.
+func (v *Variant) Transform(src io.Reader, contentType string) ([]byte, error) {
+	if !IsVariantSupported(contentType) {
+		return nil, ErrVariantSourceNotSupported
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(src, MaxVariantSourceSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxVariantSourceSize {
+		return nil, ErrVariantSourceTooLarge
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrVariantSourceNotSupported
+	}
+	if int64(config.Width)*int64(config.Height) > MaxVariantSourcePixels {
+		return nil, ErrVariantSourceTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrVariantSourceNotSupported
+	}
+
+	img = v.resize(img)
+
+	buf := bytes.Buffer{}
+	switch v.ContentType(contentType) {
+	case "image/jpeg":
+		quality := v.Quality
+		if quality == 0 {
+			quality = defaultVariantQuality
+		}
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: quality})
+	case "image/png":
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resize returns the image resized to the variant.
+func (v *Variant) resize(img image.Image) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	crop := bounds
+	width, height := srcWidth, srcHeight
+
+	switch {
+	case v.Width != 0 && v.Height != 0 && v.Fit == VariantFitFill:
+		width, height = v.Width, v.Height
+	case v.Width != 0 && v.Height != 0 && v.Fit == VariantFitCover:
+		width, height = v.Width, v.Height
+		// crop the image at the center to the aspect ratio of the variant
+		if srcWidth*height > srcHeight*width {
+			cropWidth := srcHeight * width / height
+			crop.Min.X += (srcWidth - cropWidth) / 2
+			crop.Max.X = crop.Min.X + cropWidth
+		} else {
+			cropHeight := srcWidth * height / width
+			crop.Min.Y += (srcHeight - cropHeight) / 2
+			crop.Max.Y = crop.Min.Y + cropHeight
+		}
+	case v.Width != 0 || v.Height != 0:
+		scale := 1.0
+		if v.Width != 0 {
+			scale = math.Min(scale, float64(v.Width)/float64(srcWidth))
+		}
+		if v.Height != 0 {
+			scale = math.Min(scale, float64(v.Height)/float64(srcHeight))
+		}
+		width = maxInt(1, int(math.Floor(float64(srcWidth)*scale+0.5)))
+		height = maxInt(1, int(math.Floor(float64(srcHeight)*scale+0.5)))
+	}
+
+	if crop == bounds && width == srcWidth && height == srcHeight {
+		return img
+	}
+	return resample(img, crop, width, height)
+}
+
+// resample scales the rectangle of the image to the width and height
+// with a triangle filter, which is widened when scaling down so that
+// all source pixels contribute to the result.
+func resample(img image.Image, rect image.Rectangle, width, height int) *image.RGBA {
+	src := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(src, src.Bounds(), img, rect.Min, draw.Src)
+
+	xWeights := resampleWeights(rect.Dx(), width)
+	yWeights := resampleWeights(rect.Dy(), height)
+
+	// scale horizontally
+	tmp := make([]float32, width*rect.Dy()*4)
+	for y := 0; y < rect.Dy(); y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, weights := range xWeights {
+			var r, g, b, a float32
+			for _, w := range weights {
+				p := row[w.index*4:]
+				r += float32(p[0]) * w.weight
+				g += float32(p[1]) * w.weight
+				b += float32(p[2]) * w.weight
+				a += float32(p[3]) * w.weight
+			}
+			i := (y*width + x) * 4
+			tmp[i], tmp[i+1], tmp[i+2], tmp[i+3] = r, g, b, a
+		}
+	}
+
+	// scale vertically
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, weights := range yWeights {
+		for x := 0; x < width; x++ {
+			var r, g, b, a float32
+			for _, w := range weights {
+				i := (w.index*width + x) * 4
+				r += tmp[i] * w.weight
+				g += tmp[i+1] * w.weight
+				b += tmp[i+2] * w.weight
+				a += tmp[i+3] * w.weight
+			}
+			p := dst.Pix[y*dst.Stride+x*4:]
+			p[0], p[1], p[2], p[3] = clampUint8(r), clampUint8(g), clampUint8(b), clampUint8(a)
+		}
+	}
+	return dst
+}
+
+type resampleWeight struct {
+	index  int
+	weight float32
+}
+
+// resampleWeights returns the weights of source pixels for each
+// destination pixel when scaling srcSize pixels to dstSize pixels.
+func resampleWeights(srcSize, dstSize int) [][]resampleWeight {
+	scale := float64(srcSize) / float64(dstSize)
+	radius := math.Max(scale, 1)
+
+	weights := make([][]resampleWeight, dstSize)
+	for i := range weights {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := int(math.Ceil(center - radius))
+		end := int(math.Floor(center + radius))
+
+		var sum float32
+		for j := start; j <= end; j++ {
+			w := float32(1 - math.Abs(float64(j)-center)/radius)
+			if w <= 0 {
+				continue
+			}
+			index := j
+			if index < 0 {
+				index = 0
+			} else if index >= srcSize {
+				index = srcSize - 1
+			}
+			weights[i] = append(weights[i], resampleWeight{index, w})
+			sum += w
+		}
+		for j := range weights[i] {
+			weights[i][j].weight /= sum
+		}
+	}
+	return weights
+}
+
+// flatten draws the image on a white background, so that transparent
+// pixels are not black when encoded in a format without transparency.
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface {
+		Opaque() bool
+	}); ok && opaque.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+func clampUint8(f float32) uint8 {
+	if f <= 0 {
+		return 0
+	} else if f >= 255 {
+		return 255
+	}
+	return uint8(f + 0.5)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,184 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseVariant(t *testing.T) {
+	Convey("ParseVariant", t, func() {
+		Convey("returns nil without variant parameters", func() {
+			variant, err := ParseVariant(url.Values{"signature": []string{"signed"}})
+			So(err, ShouldBeNil)
+			So(variant, ShouldBeNil)
+		})
+
+		Convey("parses variant", func() {
+			variant, err := ParseVariant(url.Values{
+				"width":   []string{"64"},
+				"height":  []string{"32"},
+				"fit":     []string{"cover"},
+				"format":  []string{"jpg"},
+				"quality": []string{"80"},
+			})
+			So(err, ShouldBeNil)
+			So(variant, ShouldResemble, &Variant{
+				Width:   64,
+				Height:  32,
+				Fit:     VariantFitCover,
+				Format:  "jpeg",
+				Quality: 80,
+			})
+			So(variant.Query().Encode(), ShouldEqual, "fit=cover&format=jpeg&height=32&quality=80&width=64")
+			So(variant.CacheName("photo.jpg"), ShouldEqual, ".variants/photo.jpg/w64_h32_cover_q80_jpeg")
+		})
+
+		Convey("omits default fit from query", func() {
+			variant, err := ParseVariant(url.Values{
+				"width":  []string{"64"},
+				"height": []string{"32"},
+				"fit":    []string{"contain"},
+			})
+			So(err, ShouldBeNil)
+			So(variant.Query().Encode(), ShouldEqual, "height=32&width=64")
+			So(variant.CacheName("photo.jpg"), ShouldEqual, ".variants/photo.jpg/w64_h32")
+		})
+
+		Convey("rejects invalid parameters", func() {
+			for _, query := range []url.Values{
+				{"width": []string{"0"}},
+				{"width": []string{"2049"}},
+				{"height": []string{"abc"}},
+				{"quality": []string{"101"}},
+				{"width": []string{"64"}, "fit": []string{"cover"}},
+				{"width": []string{"64"}, "height": []string{"64"}, "fit": []string{"stretch"}},
+				{"format": []string{"webp"}},
+			} {
+				_, err := ParseVariant(query)
+				So(err, ShouldHaveSameTypeAs, &VariantParamError{})
+			}
+		})
+	})
+}
+
+func TestParseVariantSize(t *testing.T) {
+	Convey("ParseVariantSize", t, func() {
+		Convey("parses width and height", func() {
+			size, err := ParseVariantSize("64x32")
+			So(err, ShouldBeNil)
+			So(size, ShouldResemble, VariantSize{64, 32})
+		})
+
+		Convey("parses size with width or height omitted", func() {
+			size, err := ParseVariantSize("256x")
+			So(err, ShouldBeNil)
+			So(size, ShouldResemble, VariantSize{256, 0})
+
+			size, err = ParseVariantSize("x128")
+			So(err, ShouldBeNil)
+			So(size, ShouldResemble, VariantSize{0, 128})
+		})
+
+		Convey("rejects invalid sizes", func() {
+			for _, s := range []string{"", "x", "64", "64x32x16", "ax64", "0x64", "4096x64"} {
+				_, err := ParseVariantSize(s)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestVariantTransform(t *testing.T) {
+	Convey("Variant.Transform", t, func() {
+		// a 40x20 image with the left half red and the right half blue
+		src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 40; x++ {
+				if x < 20 {
+					src.Set(x, y, color.RGBA{255, 0, 0, 255})
+				} else {
+					src.Set(x, y, color.RGBA{0, 0, 255, 255})
+				}
+			}
+		}
+		buf := bytes.Buffer{}
+		So(png.Encode(&buf, src), ShouldBeNil)
+		data := buf.Bytes()
+
+		transform := func(variant *Variant) image.Image {
+			result, err := variant.Transform(bytes.NewReader(data), "image/png")
+			So(err, ShouldBeNil)
+			img, _, err := image.Decode(bytes.NewReader(result))
+			So(err, ShouldBeNil)
+			return img
+		}
+
+		Convey("scales down to width preserving aspect ratio", func() {
+			img := transform(&Variant{Width: 10})
+			So(img.Bounds(), ShouldResemble, image.Rect(0, 0, 10, 5))
+			So(color.RGBAModel.Convert(img.At(0, 0)), ShouldResemble, color.RGBA{255, 0, 0, 255})
+			So(color.RGBAModel.Convert(img.At(9, 4)), ShouldResemble, color.RGBA{0, 0, 255, 255})
+		})
+
+		Convey("does not scale up", func() {
+			img := transform(&Variant{Width: 80, Height: 80})
+			So(img.Bounds(), ShouldResemble, image.Rect(0, 0, 40, 20))
+		})
+
+		Convey("contains in width and height", func() {
+			img := transform(&Variant{Width: 10, Height: 10})
+			So(img.Bounds(), ShouldResemble, image.Rect(0, 0, 10, 5))
+		})
+
+		Convey("covers width and height", func() {
+			img := transform(&Variant{Width: 10, Height: 10, Fit: VariantFitCover})
+			So(img.Bounds(), ShouldResemble, image.Rect(0, 0, 10, 10))
+			So(color.RGBAModel.Convert(img.At(0, 0)), ShouldResemble, color.RGBA{255, 0, 0, 255})
+			So(color.RGBAModel.Convert(img.At(9, 9)), ShouldResemble, color.RGBA{0, 0, 255, 255})
+		})
+
+		Convey("fills width and height", func() {
+			img := transform(&Variant{Width: 10, Height: 30, Fit: VariantFitFill})
+			So(img.Bounds(), ShouldResemble, image.Rect(0, 0, 10, 30))
+		})
+
+		Convey("converts format", func() {
+			variant := &Variant{Format: "jpeg", Quality: 50}
+			So(variant.ContentType("image/png"), ShouldEqual, "image/jpeg")
+			result, err := variant.Transform(bytes.NewReader(data), "image/png")
+			So(err, ShouldBeNil)
+			img, err := jpeg.Decode(bytes.NewReader(result))
+			So(err, ShouldBeNil)
+			So(img.Bounds(), ShouldResemble, image.Rect(0, 0, 40, 20))
+		})
+
+		Convey("rejects unsupported content", func() {
+			variant := &Variant{Width: 10}
+			_, err := variant.Transform(bytes.NewReader(data), "text/plain")
+			So(err, ShouldEqual, ErrVariantSourceNotSupported)
+			_, err = variant.Transform(bytes.NewReader([]byte("not an image")), "image/png")
+			So(err, ShouldEqual, ErrVariantSourceNotSupported)
+		})
+	})
+}
@@ -15,9 +15,13 @@
 package handler
 
 import (
+	"fmt"
+	"net/url"
 	"path/filepath"
 	"strings"
 
+	"github.com/mitchellh/mapstructure"
+
 	skyAsset "github.com/skygeario/skygear-server/pkg/server/asset"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
@@ -130,3 +134,128 @@ func (h *AssetUploadHandler) Handle(
 		Asset:       &assetMap,
 	}
 }
+
+type assetVariantURLPayload struct {
+	Name      string                 `mapstructure:"name"`
+	ExpiredAt int64                  `mapstructure:"expired_at"`
+	Signature string                 `mapstructure:"signature"`
+	Variant   map[string]interface{} `mapstructure:"variant"`
+	variant   *skyAsset.Variant
+}
+
+func (payload *assetVariantURLPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *assetVariantURLPayload) Validate() skyerr.Error {
+	if payload.Name == "" {
+		return skyerr.NewInvalidArgument("empty asset name", []string{"name"})
+	}
+
+	query := url.Values{}
+	for key, value := range payload.Variant {
+		query.Set(key, fmt.Sprint(value))
+	}
+	variant, err := skyAsset.ParseVariant(query)
+	if err != nil {
+		return variantParamError(err)
+	}
+	if variant == nil {
+		return skyerr.NewInvalidArgument("empty variant", []string{"variant"})
+	}
+	payload.variant = variant
+	return nil
+}
+
+/*
+AssetVariantURLHandler returns the URL of a variant of an image asset,
+which is signed if the asset store requires signature.
+
+The client proves its access to the asset with the expiry and signature
+of a signed URL of the asset, such as the URL of an asset in a record.
+The variant of an asset in a public asset store must be one of
+PublicVariantSizes.
+
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "asset:variant_url",
+    "api_key": "API_KEY",
+    "name": "c34e739e-ac82-44c0-b36b-28d226edb237-photo.jpg",
+    "expired_at": 1436431130,
+    "signature": "SIGNATURE",
+    "variant": {"width": 64, "height": 64, "fit": "cover"}
+}
+EOF
+*/
+type AssetVariantURLHandler struct {
+	AssetStore         skyAsset.Store   `inject:"AssetStore"`
+	AccessKey          router.Processor `preprocessor:"accesskey"`
+	DBConn             router.Processor `preprocessor:"dbconn"`
+	PluginReady        router.Processor `preprocessor:"plugin_ready"`
+	PublicVariantSizes []skyAsset.VariantSize
+	preprocessors      []router.Processor
+}
+
+func (h *AssetVariantURLHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *AssetVariantURLHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *AssetVariantURLHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &assetVariantURLPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	signer, ok := h.AssetStore.(skyAsset.QueryURLSigner)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "asset variant is not supported by the asset store")
+		return
+	}
+
+	name := clean(payload.Name)
+	if h.AssetStore.(skyAsset.URLSigner).IsSignatureRequired() {
+		if err := validateAssetGetRequest(h.AssetStore, name, payload.ExpiredAt, payload.Signature); err != nil {
+			response.Err = err
+			return
+		}
+	} else if !isPublicVariantAllowed(h.PublicVariantSizes, payload.variant) {
+		response.Err = skyerr.NewInvalidArgument("variant size is not allowed", []string{"variant"})
+		return
+	}
+
+	asset := skydb.Asset{}
+	if err := rpayload.DBConn.GetAsset(name, &asset); err != nil {
+		response.Err = skyerr.NewResourceFetchFailureErr("asset", name)
+		return
+	}
+	if !skyAsset.IsVariantSupported(asset.ContentType) {
+		response.Err = skyerr.NewInvalidArgument(
+			skyAsset.ErrVariantSourceNotSupported.Error(),
+			[]string{"name"},
+		)
+		return
+	}
+
+	signedURL, err := signer.SignedURLWithQuery(name, payload.variant.Query())
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	response.Result = map[string]interface{}{
+		"url": signedURL,
+	}
+}
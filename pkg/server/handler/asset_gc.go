@@ -0,0 +1,192 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+
+	skyAsset "github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// assetGCBatchSize is the number of unreferenced assets fetched at a
+// time when collecting assets.
+const assetGCBatchSize = 100
+
+const (
+	defaultAssetGCReportLimit = 100
+	maxAssetGCReportLimit     = 1000
+)
+
+// eachUnreferencedAsset calls fn with each asset older than gracePeriod
+// which is not referenced by any asset or JSON field of records. Assets
+// are saved before the records referencing them, so recent assets are
+// never considered unreferenced.
+func eachUnreferencedAsset(conn skydb.Conn, gracePeriod time.Duration, fn func(asset skydb.Asset)) error {
+	schemas, err := conn.PublicDB().GetRecordSchemas()
+	if err != nil {
+		return err
+	}
+
+	createdBefore := timeNow().Add(-gracePeriod)
+	afterName := ""
+	for {
+		assets, err := conn.GetUnreferencedAssets(schemas, createdBefore, afterName, assetGCBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, asset := range assets {
+			fn(asset)
+		}
+
+		if len(assets) < assetGCBatchSize {
+			return nil
+		}
+		afterName = assets[len(assets)-1].Name
+	}
+}
+
+// CollectUnreferencedAssets deletes assets older than gracePeriod which
+// are not referenced by any record, and returns the number of assets
+// deleted.
+//
+// The asset is deleted from the database before its file is deleted
+// from the store, so that an asset referenced by a record saved in the
+// meantime is skipped instead of losing its file.
+func CollectUnreferencedAssets(conn skydb.Conn, store skyAsset.Store, gracePeriod time.Duration) (int, error) {
+	deleted := 0
+	err := eachUnreferencedAsset(conn, gracePeriod, func(asset skydb.Asset) {
+		if err := conn.DeleteAsset(asset.Name); err != nil {
+			log.WithField("asset", asset.Name).Warnf("Skipped deleting unreferenced asset: %v", err)
+			return
+		}
+
+		if err := store.Delete(asset.Name); err != nil {
+			log.WithField("asset", asset.Name).Errorf("Failed to delete file of unreferenced asset: %v", err)
+			return
+		}
+		deleted++
+	})
+	return deleted, err
+}
+
+type assetGCReportPayload struct {
+	GracePeriod *int64 `mapstructure:"grace_period"`
+	Limit       int    `mapstructure:"limit"`
+}
+
+func (payload *assetGCReportPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *assetGCReportPayload) Validate() skyerr.Error {
+	if payload.GracePeriod != nil && *payload.GracePeriod < 0 {
+		return skyerr.NewInvalidArgument("grace_period cannot be negative", []string{"grace_period"})
+	}
+	if payload.Limit < 0 || payload.Limit > maxAssetGCReportLimit {
+		return skyerr.NewInvalidArgument("limit must be between 1 and 1000", []string{"limit"})
+	}
+	if payload.Limit == 0 {
+		payload.Limit = defaultAssetGCReportLimit
+	}
+	return nil
+}
+
+/*
+AssetGCReportHandler reports the assets that asset garbage collection
+would delete, without deleting them. These are assets older than the
+grace period which are not referenced by any record.
+
+The number and total size of such assets are returned, together with
+up to `limit` of the assets. `grace_period` is in seconds and defaults
+to the configured grace period.
+
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "asset:gc:report",
+    "master_key": "MASTER_KEY",
+    "grace_period": 86400,
+    "limit": 100
+}
+EOF
+*/
+type AssetGCReportHandler struct {
+	GracePeriod   time.Duration
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	preprocessors []router.Processor
+}
+
+func (h *AssetGCReportHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+	}
+}
+
+func (h *AssetGCReportHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *AssetGCReportHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &assetGCReportPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	gracePeriod := h.GracePeriod
+	if payload.GracePeriod != nil {
+		gracePeriod = time.Duration(*payload.GracePeriod) * time.Second
+	}
+
+	assets := []map[string]interface{}{}
+	count := 0
+	var size int64
+	err := eachUnreferencedAsset(rpayload.DBConn, gracePeriod, func(asset skydb.Asset) {
+		if len(assets) < payload.Limit {
+			assets = append(assets, map[string]interface{}{
+				"name":         asset.Name,
+				"content_type": asset.ContentType,
+				"size":         asset.Size,
+			})
+		}
+		count++
+		size += asset.Size
+	})
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = map[string]interface{}{
+		"assets":       assets,
+		"count":        count,
+		"size":         size,
+		"grace_period": int64(gracePeriod / time.Second),
+	}
+}
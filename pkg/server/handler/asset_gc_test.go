@@ -0,0 +1,225 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// unreferencedAssetConn returns its assets as unreferenced, except
+// those in referenced which cannot be deleted.
+type unreferencedAssetConn struct {
+	skydb.Conn
+	db            skydb.Database
+	assets        map[string]skydb.Asset
+	referenced    map[string]bool
+	createdBefore time.Time
+	schemas       map[string]skydb.RecordSchema
+}
+
+func (conn *unreferencedAssetConn) PublicDB() skydb.Database {
+	return conn.db
+}
+
+func (conn *unreferencedAssetConn) GetUnreferencedAssets(schemas map[string]skydb.RecordSchema, createdBefore time.Time, afterName string, limit int) ([]skydb.Asset, error) {
+	conn.schemas = schemas
+	conn.createdBefore = createdBefore
+
+	names := []string{}
+	for name := range conn.assets {
+		if name > afterName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > limit {
+		names = names[:limit]
+	}
+
+	assets := []skydb.Asset{}
+	for _, name := range names {
+		assets = append(assets, conn.assets[name])
+	}
+	return assets, nil
+}
+
+func (conn *unreferencedAssetConn) DeleteAsset(name string) error {
+	if conn.referenced[name] {
+		return errors.New("asset is referenced")
+	}
+	if _, ok := conn.assets[name]; !ok {
+		return skydb.ErrAssetNotFound
+	}
+	delete(conn.assets, name)
+	return nil
+}
+
+func TestCollectUnreferencedAssets(t *testing.T) {
+	Convey("CollectUnreferencedAssets", t, func() {
+		dir, err := ioutil.TempDir("", "skygear-asset")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store := asset.NewFileStore(dir, "http://skygear.test/files", "secret", true)
+		db := skydbtest.NewMapDB()
+		db.RecordSchemaMap["note"] = skydb.RecordSchema{
+			"attachment": skydb.FieldType{Type: skydb.TypeAsset},
+		}
+		conn := &unreferencedAssetConn{
+			db:         db,
+			assets:     map[string]skydb.Asset{},
+			referenced: map[string]bool{},
+		}
+
+		for _, name := range []string{"a.txt", "b.txt"} {
+			err := store.PutFileReader(name, strings.NewReader(name), int64(len(name)), "plain/text")
+			So(err, ShouldBeNil)
+			conn.assets[name] = skydb.Asset{Name: name, ContentType: "plain/text", Size: int64(len(name))}
+		}
+
+		timeNow = func() time.Time {
+			return time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		}
+		defer func() {
+			timeNow = timeNowUTC
+		}()
+
+		Convey("deletes unreferenced assets and their files", func() {
+			deleted, err := CollectUnreferencedAssets(conn, store, time.Hour)
+			So(err, ShouldBeNil)
+			So(deleted, ShouldEqual, 2)
+			So(conn.assets, ShouldBeEmpty)
+			So(conn.createdBefore, ShouldResemble, time.Date(2006, 1, 2, 14, 4, 5, 0, time.UTC))
+			So(conn.schemas, ShouldContainKey, "note")
+
+			_, err = os.Stat(filepath.Join(dir, "a.txt"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+			_, err = os.Stat(filepath.Join(dir, "b.txt"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("keeps the file of an asset which cannot be deleted", func() {
+			conn.referenced["a.txt"] = true
+
+			deleted, err := CollectUnreferencedAssets(conn, store, time.Hour)
+			So(err, ShouldBeNil)
+			So(deleted, ShouldEqual, 1)
+			So(conn.assets, ShouldContainKey, "a.txt")
+
+			_, err = os.Stat(filepath.Join(dir, "a.txt"))
+			So(err, ShouldBeNil)
+			_, err = os.Stat(filepath.Join(dir, "b.txt"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("deletes assets in batches", func() {
+			for i := 0; i < assetGCBatchSize; i++ {
+				name := fmt.Sprintf("c%03d.txt", i)
+				conn.assets[name] = skydb.Asset{Name: name, ContentType: "plain/text", Size: 1}
+			}
+
+			deleted, err := CollectUnreferencedAssets(conn, store, time.Hour)
+			So(err, ShouldBeNil)
+			So(deleted, ShouldEqual, assetGCBatchSize+2)
+			So(conn.assets, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestAssetGCReportHandler(t *testing.T) {
+	Convey("AssetGCReportHandler", t, func() {
+		conn := &unreferencedAssetConn{
+			db: skydbtest.NewMapDB(),
+			assets: map[string]skydb.Asset{
+				"a.txt": skydb.Asset{Name: "a.txt", ContentType: "plain/text", Size: 10},
+				"b.png": skydb.Asset{Name: "b.png", ContentType: "image/png", Size: 20},
+			},
+			referenced: map[string]bool{},
+		}
+
+		timeNow = func() time.Time {
+			return time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		}
+		defer func() {
+			timeNow = timeNowUTC
+		}()
+
+		r := handlertest.NewSingleRouteRouter(&AssetGCReportHandler{
+			GracePeriod: 24 * time.Hour,
+		}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		Convey("reports unreferenced assets without deleting them", func() {
+			resp := r.POST(`{}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"assets": [
+						{"name": "a.txt", "content_type": "plain/text", "size": 10},
+						{"name": "b.png", "content_type": "image/png", "size": 20}
+					],
+					"count": 2,
+					"size": 30,
+					"grace_period": 86400
+				}
+			}`)
+			So(conn.assets, ShouldHaveLength, 2)
+			So(conn.createdBefore, ShouldResemble, time.Date(2006, 1, 1, 15, 4, 5, 0, time.UTC))
+		})
+
+		Convey("reports with grace period and limit", func() {
+			resp := r.POST(`{"grace_period": 60, "limit": 1}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"assets": [
+						{"name": "a.txt", "content_type": "plain/text", "size": 10}
+					],
+					"count": 2,
+					"size": 30,
+					"grace_period": 60
+				}
+			}`)
+			So(conn.createdBefore, ShouldResemble, time.Date(2006, 1, 2, 15, 3, 5, 0, time.UTC))
+		})
+
+		Convey("rejects negative grace period", func() {
+			resp := r.POST(`{"grace_period": -1}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"name": "InvalidArgument",
+					"message": "grace_period cannot be negative",
+					"info": {"arguments": ["grace_period"]}
+				}
+			}`)
+		})
+	})
+}
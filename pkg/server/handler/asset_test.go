@@ -16,8 +16,10 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -25,6 +27,7 @@ import (
 	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -57,6 +60,10 @@ func (s generatePostFileRequestAssetStore) GeneratePostFileRequest(
 	}, nil
 }
 
+func (s generatePostFileRequestAssetStore) Delete(name string) error {
+	panic("Not Implemented")
+}
+
 func (s generatePostFileRequestAssetStore) SignedURL(name string) (string, error) {
 	return "http://asset.skygear.dev/" + name, nil
 }
@@ -169,3 +176,138 @@ func TestAssetUploadHandler(t *testing.T) {
 		})
 	})
 }
+
+func TestAssetVariantURLHandler(t *testing.T) {
+	Convey("AssetVariantURLHandler", t, func() {
+		store := asset.NewFileStore("", "http://skygear.test/files", "secret", false)
+
+		assetConn := &naiveAssetConn{}
+		assetConn.savedAsset = map[string]*skydb.Asset{
+			"photo.png": {
+				Name:        "photo.png",
+				ContentType: "image/png",
+			},
+			"note.txt": {
+				Name:        "note.txt",
+				ContentType: "plain/text",
+			},
+		}
+
+		r := handlertest.NewSingleRouteRouter(&AssetVariantURLHandler{
+			AssetStore: store,
+		}, func(p *router.Payload) {
+			p.DBConn = assetConn
+		})
+
+		signedQuery := func(name string) url.Values {
+			signedURL, err := store.(asset.URLSigner).SignedURL(name)
+			So(err, ShouldBeNil)
+			u, err := url.Parse(signedURL)
+			So(err, ShouldBeNil)
+			return u.Query()
+		}
+
+		Convey("returns signed variant URL", func() {
+			query := signedQuery("photo.png")
+			res := r.POST(fmt.Sprintf(`{
+				"name": "photo.png",
+				"expired_at": %s,
+				"signature": "%s",
+				"variant": {"width": 64, "height": 64, "fit": "cover"}
+			}`, query.Get("expiredAt"), query.Get("signature")))
+			So(res.Code, ShouldEqual, http.StatusOK)
+
+			result := struct {
+				Result struct {
+					URL string `json:"url"`
+				} `json:"result"`
+			}{}
+			So(json.Unmarshal(res.Body.Bytes(), &result), ShouldBeNil)
+			So(result.Result.URL, ShouldStartWith, "http://skygear.test/files/photo.png?fit=cover&height=64&width=64&expiredAt=")
+		})
+
+		Convey("rejects invalid signature", func() {
+			query := signedQuery("note.txt")
+			res := r.POST(fmt.Sprintf(`{
+				"name": "photo.png",
+				"expired_at": %s,
+				"signature": "%s",
+				"variant": {"width": 64}
+			}`, query.Get("expiredAt"), query.Get("signature")))
+			So(res.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 106,
+					"name": "InvalidSignature",
+					"message": "Invalid signature"
+				}
+			}`)
+		})
+
+		Convey("rejects asset which is not image", func() {
+			query := signedQuery("note.txt")
+			res := r.POST(fmt.Sprintf(`{
+				"name": "note.txt",
+				"expired_at": %s,
+				"signature": "%s",
+				"variant": {"width": 64}
+			}`, query.Get("expiredAt"), query.Get("signature")))
+			So(res.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"name": "InvalidArgument",
+					"message": "asset is not a supported image",
+					"info": {"arguments": ["name"]}
+				}
+			}`)
+		})
+
+		Convey("with public file store", func() {
+			store := asset.NewFileStore("", "http://skygear.test/files", "secret", true)
+			r := handlertest.NewSingleRouteRouter(&AssetVariantURLHandler{
+				AssetStore:         store,
+				PublicVariantSizes: []asset.VariantSize{{Width: 64, Height: 64}},
+			}, func(p *router.Payload) {
+				p.DBConn = assetConn
+			})
+
+			Convey("returns variant URL of allowed size", func() {
+				res := r.POST(`{
+					"name": "photo.png",
+					"variant": {"width": 64, "height": 64, "fit": "cover"}
+				}`)
+				So(res.Body.String(), ShouldEqualJSON, `{
+					"result": {
+						"url": "http://skygear.test/files/photo.png?fit=cover&height=64&width=64"
+					}
+				}`)
+			})
+
+			Convey("rejects variant of size not allowed", func() {
+				res := r.POST(`{
+					"name": "photo.png",
+					"variant": {"width": 128, "height": 128}
+				}`)
+				So(res.Body.String(), ShouldEqualJSON, `{
+					"error": {
+						"code": 108,
+						"name": "InvalidArgument",
+						"message": "variant size is not allowed",
+						"info": {"arguments": ["variant"]}
+					}
+				}`)
+			})
+		})
+
+		Convey("rejects empty variant", func() {
+			res := r.POST(`{"name": "photo.png"}`)
+			So(res.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"name": "InvalidArgument",
+					"message": "empty variant",
+					"info": {"arguments": ["variant"]}
+				}
+			}`)
+		})
+	})
+}
@@ -260,7 +260,7 @@ func (h *LoginHandler) Handle(payload *router.Payload, response *router.Response
 
 	if p.Provider != "" {
 		// Get AuthProvider and authenticates the user
-		principalID, authData, skyErr := h.authPrincipal(payload.Context, p)
+		principalID, authData, skyErr := authPrincipal(h.ProviderRegistry, payload.Context, p.Provider, p.AuthData)
 		if skyErr != nil {
 			response.Err = skyErr
 			return
@@ -326,19 +326,19 @@ func (h *LoginHandler) Handle(payload *router.Payload, response *router.Response
 	response.Result = authResponse
 }
 
-func (h *LoginHandler) authPrincipal(ctx context.Context, p *loginPayload) (string, map[string]interface{}, skyerr.Error) {
-	log.Debugf(`Client requested auth provider: "%v".`, p.Provider)
-	authProvider, err := h.ProviderRegistry.GetAuthProvider(p.Provider)
+func authPrincipal(registry *provider.Registry, ctx context.Context, providerName string, data map[string]interface{}) (string, map[string]interface{}, skyerr.Error) {
+	log.Debugf(`Client requested auth provider: "%v".`, providerName)
+	authProvider, err := registry.GetAuthProvider(providerName)
 	if err != nil {
 		skyErr := skyerr.NewInvalidArgument(err.Error(), []string{"provider"})
 		return "", nil, skyErr
 	}
-	principalID, authData, err := authProvider.Login(ctx, p.AuthData)
+	principalID, authData, err := authProvider.Login(ctx, data)
 	if err != nil {
 		skyErr := skyerr.NewError(skyerr.InvalidCredentials, "invalid authentication information")
 		return "", nil, skyErr
 	}
-	log.Infof(`Client authenticated as principal: "%v" (provider: "%v").`, principalID, p.Provider)
+	log.Infof(`Client authenticated as principal: "%v" (provider: "%v").`, principalID, providerName)
 	return principalID, authData, nil
 }
 
@@ -503,6 +503,274 @@ func (h *PasswordHandler) Handle(payload *router.Payload, response *router.Respo
 	}
 }
 
+type upgradePayload struct {
+	Username string                 `mapstructure:"username"`
+	Email    string                 `mapstructure:"email"`
+	Password string                 `mapstructure:"password"`
+	Provider string                 `mapstructure:"provider"`
+	AuthData map[string]interface{} `mapstructure:"auth_data"`
+}
+
+func (payload *upgradePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *upgradePayload) Validate() skyerr.Error {
+	if payload.Provider != "" {
+		return nil
+	}
+
+	identified := payload.Username != "" || payload.Email != ""
+	if !identified {
+		return skyerr.NewInvalidArgument("empty username and empty email", []string{"username", "email"})
+	}
+
+	if payload.Password == "" {
+		return skyerr.NewInvalidArgument("empty password", []string{"password"})
+	}
+
+	return nil
+}
+
+// AuthUpgradeHandler attaches credentials to the current anonymous user,
+// keeping the user ID and the records owned by the user.
+//
+// AuthUpgradeHandler receives either username / email and password, or
+// provider and auth_data. A new access token is returned because setting
+// the password invalidates existing access tokens.
+//
+//  curl -X POST -H "Content-Type: application/json" \
+//    -d @- http://localhost:3000/ <<EOF
+//  {
+//      "action": "auth:upgrade",
+//      "access_token": "some-access-token",
+//      "username": "rickmak",
+//      "email": "rick.mak@gmail.com",
+//      "password": "123456"
+//  }
+//  EOF
+type AuthUpgradeHandler struct {
+	TokenStore       authtoken.Store    `inject:"TokenStore"`
+	ProviderRegistry *provider.Registry `inject:"ProviderRegistry"`
+	Authenticator    router.Processor   `preprocessor:"authenticator"`
+	DBConn           router.Processor   `preprocessor:"dbconn"`
+	InjectUser       router.Processor   `preprocessor:"inject_user"`
+	RequireUser      router.Processor   `preprocessor:"require_user"`
+	PluginReady      router.Processor   `preprocessor:"plugin_ready"`
+	preprocessors    []router.Processor
+}
+
+func (h *AuthUpgradeHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+		h.RequireUser,
+		h.PluginReady,
+	}
+}
+
+func (h *AuthUpgradeHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *AuthUpgradeHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &upgradePayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	info := *payload.UserInfo
+	if !info.IsAnonymous() {
+		response.Err = skyerr.NewError(skyerr.PermissionDenied, "only anonymous user can be upgraded")
+		return
+	}
+
+	if p.Provider != "" {
+		principalID, authData, skyErr := authPrincipal(h.ProviderRegistry, payload.Context, p.Provider, p.AuthData)
+		if skyErr != nil {
+			response.Err = skyErr
+			return
+		}
+
+		// The principal of an existing user is supposed to be merged
+		// with auth:merge instead.
+		existing := skydb.UserInfo{}
+		if err := payload.DBConn.GetUserByPrincipalID(principalID, &existing); err == nil {
+			response.Err = errUserDuplicated
+			return
+		} else if err != skydb.ErrUserNotFound {
+			response.Err = skyerr.NewResourceFetchFailureErr("user", principalID)
+			return
+		}
+
+		info.SetProvidedAuthData(principalID, authData)
+	} else {
+		info.Username = p.Username
+		info.Email = p.Email
+		info.SetPassword(p.Password)
+	}
+
+	if err := payload.DBConn.UpdateUser(&info); err != nil {
+		if err == skydb.ErrUserDuplicated {
+			response.Err = errUserDuplicated
+		} else {
+			response.Err = skyerr.MakeError(err)
+		}
+		return
+	}
+
+	token, err := h.TokenStore.NewToken(payload.AppName, info.ID)
+	if err != nil {
+		panic(err)
+	}
+	if err = h.TokenStore.Put(&token); err != nil {
+		panic(err)
+	}
+
+	response.Result = NewAuthResponse(info, token.AccessToken)
+}
+
+// AuthMergeHandler merges the current anonymous user into an existing
+// user identified by the supplied credentials, which are the same as
+// those of auth:login.
+//
+// Records, devices, relations, subscriptions and roles of the anonymous
+// user are reassigned to the existing user inside a transaction, after
+// which the anonymous user is removed. An access token of the existing
+// user is returned.
+//
+//  curl -X POST -H "Content-Type: application/json" \
+//    -d @- http://localhost:3000/ <<EOF
+//  {
+//      "action": "auth:merge",
+//      "access_token": "some-access-token",
+//      "username": "rickmak",
+//      "password": "123456"
+//  }
+//  EOF
+type AuthMergeHandler struct {
+	TokenStore       authtoken.Store    `inject:"TokenStore"`
+	ProviderRegistry *provider.Registry `inject:"ProviderRegistry"`
+	Authenticator    router.Processor   `preprocessor:"authenticator"`
+	DBConn           router.Processor   `preprocessor:"dbconn"`
+	InjectUser       router.Processor   `preprocessor:"inject_user"`
+	InjectDB         router.Processor   `preprocessor:"inject_db"`
+	RequireUser      router.Processor   `preprocessor:"require_user"`
+	PluginReady      router.Processor   `preprocessor:"plugin_ready"`
+	preprocessors    []router.Processor
+}
+
+func (h *AuthMergeHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+		h.InjectDB,
+		h.RequireUser,
+		h.PluginReady,
+	}
+}
+
+func (h *AuthMergeHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *AuthMergeHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &upgradePayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if !payload.UserInfo.IsAnonymous() {
+		response.Err = skyerr.NewError(skyerr.PermissionDenied, "only anonymous user can be merged")
+		return
+	}
+
+	info := skydb.UserInfo{}
+	var (
+		principalID string
+		authData    map[string]interface{}
+	)
+	if p.Provider != "" {
+		principalID, authData, skyErr = authPrincipal(h.ProviderRegistry, payload.Context, p.Provider, p.AuthData)
+		if skyErr != nil {
+			response.Err = skyErr
+			return
+		}
+		if err := payload.DBConn.GetUserByPrincipalID(principalID, &info); err != nil {
+			if err == skydb.ErrUserNotFound {
+				response.Err = skyerr.NewError(skyerr.ResourceNotFound, "user not found")
+			} else {
+				response.Err = skyerr.NewResourceFetchFailureErr("user", principalID)
+			}
+			return
+		}
+	} else {
+		if err := payload.DBConn.GetUserByUsernameEmail(p.Username, p.Email, &info); err != nil {
+			if err == skydb.ErrUserNotFound {
+				response.Err = skyerr.NewError(skyerr.ResourceNotFound, "user not found")
+			} else {
+				response.Err = skyerr.NewResourceFetchFailureErr("user", p.Username)
+			}
+			return
+		}
+		if !info.IsSamePassword(p.Password) {
+			response.Err = skyerr.NewError(skyerr.InvalidCredentials, "username or password incorrect")
+			return
+		}
+	}
+
+	txDB, ok := payload.Database.(skydb.TxDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database impl does not support transaction")
+		return
+	}
+
+	txErr := withTransaction(txDB, func() error {
+		if err := payload.DBConn.MergeUser(payload.UserInfoID, info.ID); err != nil {
+			return err
+		}
+
+		// Reload the user for the roles merged from the anonymous user
+		if err := payload.DBConn.GetUser(info.ID, &info); err != nil {
+			return err
+		}
+		if principalID != "" {
+			info.SetProvidedAuthData(principalID, authData)
+		}
+		now := timeNow()
+		info.LastLoginAt = &now
+		info.LastSeenAt = &now
+		return payload.DBConn.UpdateUser(&info)
+	})
+	if txErr != nil {
+		if txErr == skydb.ErrUserNotFound {
+			response.Err = skyerr.NewError(skyerr.ResourceNotFound, "user not found")
+		} else {
+			response.Err = skyerr.MakeError(txErr)
+		}
+		return
+	}
+
+	token, err := h.TokenStore.NewToken(payload.AppName, info.ID)
+	if err != nil {
+		panic(err)
+	}
+	if err = h.TokenStore.Put(&token); err != nil {
+		panic(err)
+	}
+
+	response.Result = NewAuthResponse(info, token.AccessToken)
+}
+
 // createUserWithRecordContext is a context for creating a new user with
 // database record
 type createUserWithRecordContext struct {
@@ -447,6 +447,10 @@ func (conn *singleUserConn) GetRecordAccess(recordType string) (skydb.RecordACL,
 	return skydb.NewRecordACL([]skydb.RecordACLEntry{}), nil
 }
 
+func (conn *singleUserConn) GetRecordPolicy(recordType string) (skydb.Predicate, error) {
+	return skydb.Predicate{}, nil
+}
+
 func TestSignupHandlerAsAnonymous(t *testing.T) {
 	Convey("SignupHandler", t, func() {
 		tokenStore := authtokentest.SingleTokenStore{}
@@ -713,3 +717,168 @@ func TestPasswordHandlerWithProvider(t *testing.T) {
 
 	})
 }
+
+func TestAuthUpgradeHandler(t *testing.T) {
+	Convey("AuthUpgradeHandler", t, func() {
+		conn := skydbtest.NewMapConn()
+		tokenStore := authtokentest.SingleTokenStore{}
+		providerRegistry := provider.NewRegistry()
+		providerRegistry.RegisterAuthProvider("com.example", handlertest.NewSingleUserAuthProvider("com.example", "johndoe"))
+
+		anonymous := skydb.NewAnonymousUserInfo()
+		conn.UserMap[anonymous.ID] = anonymous
+
+		handler := &AuthUpgradeHandler{
+			TokenStore:       &tokenStore,
+			ProviderRegistry: providerRegistry,
+		}
+
+		Convey("upgrades anonymous user with username and password", func() {
+			req := router.Payload{
+				Data: map[string]interface{}{
+					"username": "john.doe",
+					"password": "secret",
+				},
+				DBConn:     conn,
+				UserInfoID: anonymous.ID,
+				UserInfo:   &anonymous,
+			}
+			resp := router.Response{}
+			handler.Handle(&req, &resp)
+
+			So(resp.Err, ShouldBeNil)
+			authResp := resp.Result.(AuthResponse)
+			So(authResp.UserID, ShouldEqual, anonymous.ID)
+			So(authResp.Username, ShouldEqual, "john.doe")
+			So(authResp.AccessToken, ShouldEqual, tokenStore.Token.AccessToken)
+
+			info := conn.UserMap[anonymous.ID]
+			So(info.Username, ShouldEqual, "john.doe")
+			So(info.IsSamePassword("secret"), ShouldBeTrue)
+		})
+
+		Convey("upgrades anonymous user with provider", func() {
+			req := router.Payload{
+				Data: map[string]interface{}{
+					"provider":  "com.example",
+					"auth_data": map[string]interface{}{"name": "johndoe"},
+				},
+				DBConn:     conn,
+				UserInfoID: anonymous.ID,
+				UserInfo:   &anonymous,
+			}
+			resp := router.Response{}
+			handler.Handle(&req, &resp)
+
+			So(resp.Err, ShouldBeNil)
+			info := conn.UserMap[anonymous.ID]
+			So(info.Auth, ShouldContainKey, "com.example:johndoe")
+		})
+
+		Convey("rejects provider linked to another user", func() {
+			existing := skydb.NewProvidedAuthUserInfo("com.example:johndoe", map[string]interface{}{})
+			conn.UserMap[existing.ID] = existing
+
+			req := router.Payload{
+				Data: map[string]interface{}{
+					"provider":  "com.example",
+					"auth_data": map[string]interface{}{"name": "johndoe"},
+				},
+				DBConn:     conn,
+				UserInfoID: anonymous.ID,
+				UserInfo:   &anonymous,
+			}
+			resp := router.Response{}
+			handler.Handle(&req, &resp)
+
+			So(resp.Err, ShouldResemble, errUserDuplicated)
+		})
+
+		Convey("rejects user that is not anonymous", func() {
+			userinfo := skydb.NewUserInfo("jane.doe", "", "secret")
+			conn.UserMap[userinfo.ID] = userinfo
+
+			req := router.Payload{
+				Data: map[string]interface{}{
+					"username": "john.doe",
+					"password": "secret",
+				},
+				DBConn:     conn,
+				UserInfoID: userinfo.ID,
+				UserInfo:   &userinfo,
+			}
+			resp := router.Response{}
+			handler.Handle(&req, &resp)
+
+			So(resp.Err, ShouldNotBeNil)
+			So(resp.Err.Code(), ShouldEqual, skyerr.PermissionDenied)
+		})
+	})
+}
+
+func TestAuthMergeHandler(t *testing.T) {
+	Convey("AuthMergeHandler", t, func() {
+		conn := skydbtest.NewMapConn()
+		db := skydbtest.NewMapDB()
+		txdb := skydbtest.NewMockTxDatabase(db)
+		tokenStore := authtokentest.SingleTokenStore{}
+
+		anonymous := skydb.NewAnonymousUserInfo()
+		anonymous.Roles = []string{"guest"}
+		conn.UserMap[anonymous.ID] = anonymous
+
+		userinfo := skydb.NewUserInfo("john.doe", "john.doe@example.com", "secret")
+		conn.CreateUser(&userinfo)
+
+		handler := &AuthMergeHandler{
+			TokenStore: &tokenStore,
+		}
+
+		Convey("merges anonymous user into existing user", func() {
+			req := router.Payload{
+				Data: map[string]interface{}{
+					"username": "john.doe",
+					"password": "secret",
+				},
+				DBConn:     conn,
+				Database:   txdb,
+				UserInfoID: anonymous.ID,
+				UserInfo:   &anonymous,
+			}
+			resp := router.Response{}
+			handler.Handle(&req, &resp)
+
+			So(resp.Err, ShouldBeNil)
+			So(txdb.DidBegin, ShouldBeTrue)
+			So(txdb.DidCommit, ShouldBeTrue)
+
+			authResp := resp.Result.(AuthResponse)
+			So(authResp.UserID, ShouldEqual, userinfo.ID)
+			So(authResp.AccessToken, ShouldEqual, tokenStore.Token.AccessToken)
+			So(tokenStore.Token.UserInfoID, ShouldEqual, userinfo.ID)
+
+			So(conn.UserMap, ShouldNotContainKey, anonymous.ID)
+			So(conn.UserMap[userinfo.ID].Roles, ShouldResemble, []string{"guest"})
+		})
+
+		Convey("rejects wrong password", func() {
+			req := router.Payload{
+				Data: map[string]interface{}{
+					"username": "john.doe",
+					"password": "wrong",
+				},
+				DBConn:     conn,
+				Database:   txdb,
+				UserInfoID: anonymous.ID,
+				UserInfo:   &anonymous,
+			}
+			resp := router.Response{}
+			handler.Handle(&req, &resp)
+
+			So(resp.Err, ShouldNotBeNil)
+			So(resp.Err.Code(), ShouldEqual, skyerr.InvalidCredentials)
+			So(txdb.DidBegin, ShouldBeFalse)
+			So(conn.UserMap, ShouldContainKey, anonymous.ID)
+		})
+	})
+}
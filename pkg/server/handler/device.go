@@ -16,10 +16,12 @@ package handler
 
 import (
 	"fmt"
+	"regexp"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/mitchellh/mapstructure"
 
+	"github.com/skygeario/skygear-server/pkg/server/push"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
@@ -30,7 +32,10 @@ type deviceRegisterPayload struct {
 	ID          string
 	Type        string
 	Topic       string
-	DeviceToken string `mapstructure:"device_token"`
+	DeviceToken string            `mapstructure:"device_token"`
+	Endpoint    string            `mapstructure:"endpoint"`
+	Keys        map[string]string `mapstructure:"keys"`
+	Locale      string            `mapstructure:"locale"`
 }
 
 func (payload *deviceRegisterPayload) Decode(data map[string]interface{}) skyerr.Error {
@@ -43,13 +48,31 @@ func (payload *deviceRegisterPayload) Decode(data map[string]interface{}) skyerr
 func (payload *deviceRegisterPayload) Validate() skyerr.Error {
 	if payload.Type == "" {
 		return skyerr.NewInvalidArgument("empty device type", []string{"type"})
-	} else if payload.Type != "ios" && payload.Type != "android" {
+	} else if payload.Type != "ios" && payload.Type != "android" && payload.Type != "web" {
 		return skyerr.NewInvalidArgument(fmt.Sprintf("unknown device type = %v", payload.Type), []string{"type"})
 	}
 
+	if payload.Type == "web" {
+		if payload.Endpoint == "" || push.ValidateWebPushEndpoint(payload.Endpoint) != nil {
+			return skyerr.NewInvalidArgument("invalid subscription endpoint", []string{"endpoint"})
+		}
+		if payload.Keys["p256dh"] == "" || payload.Keys["auth"] == "" {
+			return skyerr.NewInvalidArgument("missing subscription keys p256dh or auth", []string{"keys"})
+		}
+	}
+
 	return nil
 }
 
+// Token returns the token identifying the device at the push service,
+// which is the subscription endpoint for Web Push.
+func (payload *deviceRegisterPayload) Token() string {
+	if payload.Type == "web" {
+		return payload.Endpoint
+	}
+	return payload.DeviceToken
+}
+
 type deviceUnregisterPayload struct {
 	ID string
 }
@@ -69,6 +92,39 @@ func (payload *deviceUnregisterPayload) Validate() skyerr.Error {
 	return nil
 }
 
+// topicNameRegexp matches a valid push topic name.
+var topicNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.~%-]+$`)
+
+type deviceTopicPayload struct {
+	ID     string
+	Topics []string `mapstructure:"topics"`
+}
+
+func (payload *deviceTopicPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *deviceTopicPayload) Validate() skyerr.Error {
+	if payload.ID == "" {
+		return skyerr.NewInvalidArgument("Missing device id", []string{"id"})
+	}
+
+	if len(payload.Topics) == 0 {
+		return skyerr.NewInvalidArgument("empty topics", []string{"topics"})
+	}
+
+	for _, topic := range payload.Topics {
+		if !topicNameRegexp.MatchString(topic) {
+			return skyerr.NewInvalidArgument(fmt.Sprintf("invalid topic name = %v", topic), []string{"topics"})
+		}
+	}
+
+	return nil
+}
+
 // DeviceReigsterResult is the result put onto response.Result on
 // successful call of DeviceRegisterHandler
 type DeviceReigsterResult struct {
@@ -86,7 +142,8 @@ type DeviceReigsterResult struct {
 //		"access_token": "some-access-token",
 //		"type": "ios",
 //		"topic": "io.skygear.sample.topic",
-//		"device_token": "some-device-token"
+//		"device_token": "some-device-token",
+//		"locale": "zh-TW"
 //	}
 //	EOF
 //
@@ -103,6 +160,22 @@ type DeviceReigsterResult struct {
 //	}
 //	EOF
 //
+// Example to create a new device with a Web Push subscription:
+//
+//	curl -X POST -H "Content-Type: application/json" \
+//	  -d @- http://localhost:3000/ <<EOF
+//	{
+//		"action": "device:register",
+//		"access_token": "some-access-token",
+//		"type": "web",
+//		"endpoint": "https://push.example.com/send/some-subscription",
+//		"keys": {
+//			"p256dh": "some-public-key",
+//			"auth": "some-auth-secret"
+//		}
+//	}
+//	EOF
+//
 type DeviceRegisterHandler struct {
 	Authenticator router.Processor `preprocessor:"authenticator"`
 	DBConn        router.Processor `preprocessor:"dbconn"`
@@ -159,8 +232,19 @@ func (h *DeviceRegisterHandler) Handle(rpayload *router.Payload, response *route
 		}
 	}
 
+	// topic subscriptions are removed with the device below, keep them
+	// so that they are restored after the device is saved
+	var topics []string
+	if deviceID != "" {
+		var err error
+		if topics, err = conn.GetDeviceTopics(deviceID); err != nil {
+			response.Err = skyerr.NewResourceFetchFailureErr("device", deviceID)
+			return
+		}
+	}
+
 	// delete all devices with the same token
-	if err := conn.DeleteDevicesByToken(payload.DeviceToken, skydb.ZeroTime); err != nil {
+	if err := conn.DeleteDevicesByToken(payload.Token(), skydb.ZeroTime); err != nil {
 		if err != skydb.ErrDeviceNotFound {
 			response.Err = skyerr.NewResourceDeleteFailureErrWithStringID("device", "")
 			return
@@ -168,8 +252,10 @@ func (h *DeviceRegisterHandler) Handle(rpayload *router.Payload, response *route
 	}
 
 	device.Type = payload.Type
-	device.Token = payload.DeviceToken
+	device.Token = payload.Token()
 	device.Topic = payload.Topic
+	device.Keys = payload.Keys
+	device.Locale = payload.Locale
 	device.UserInfoID = rpayload.UserInfoID
 	device.LastRegisteredAt = timeNow()
 
@@ -180,6 +266,14 @@ func (h *DeviceRegisterHandler) Handle(rpayload *router.Payload, response *route
 			"err":      err,
 		}).Errorln("Failed to save device")
 
+		response.Err = skyerr.NewResourceSaveFailureErrWithStringID("device", deviceID)
+	} else if err := conn.SubscribeDeviceTopics(device.ID, topics); err != nil {
+		log.WithFields(logrus.Fields{
+			"deviceID": deviceID,
+			"topics":   topics,
+			"err":      err,
+		}).Errorln("Failed to restore device topics")
+
 		response.Err = skyerr.NewResourceSaveFailureErrWithStringID("device", deviceID)
 	} else {
 		response.Result = DeviceReigsterResult{device.ID}
@@ -272,3 +366,153 @@ func (h *DeviceUnregisterHandler) Handle(rpayload *router.Payload, response *rou
 
 	response.Result = DeviceReigsterResult{device.ID}
 }
+
+// DeviceTopicResult is the result put onto response.Result on
+// successful call of DeviceSubscribeTopicHandler and
+// DeviceUnsubscribeTopicHandler
+type DeviceTopicResult struct {
+	ID     string   `json:"id"`
+	Topics []string `json:"topics"`
+}
+
+// getUserDevice returns the device with the specified id if it is
+// registered by the user.
+func getUserDevice(conn skydb.Conn, deviceID string, userID string) (skydb.Device, skyerr.Error) {
+	device := skydb.Device{}
+	if err := conn.GetDevice(deviceID, &device); err != nil {
+		if err == skydb.ErrDeviceNotFound {
+			return device, skyerr.NewError(skyerr.ResourceNotFound, "Device not found")
+		}
+
+		log.WithFields(logrus.Fields{
+			"deviceID": deviceID,
+			"err":      err,
+		}).Errorln("Fail to get device")
+
+		return device, skyerr.NewResourceFetchFailureErr("device", deviceID)
+	}
+
+	if device.UserInfoID != userID {
+		return device, skyerr.NewError(skyerr.PermissionDenied, "Device is not registered by the current user")
+	}
+
+	return device, nil
+}
+
+// updateDeviceTopics updates the topics of a device with update and
+// returns the topics subscribed by the device afterwards.
+func updateDeviceTopics(rpayload *router.Payload, update func(conn skydb.Conn, payload *deviceTopicPayload) error) (interface{}, skyerr.Error) {
+	payload := deviceTopicPayload{}
+	if err := payload.Decode(rpayload.Data); err != nil {
+		return nil, err
+	}
+
+	conn := rpayload.DBConn
+	if _, err := getUserDevice(conn, payload.ID, rpayload.UserInfoID); err != nil {
+		return nil, err
+	}
+
+	if err := update(conn, &payload); err != nil {
+		log.WithFields(logrus.Fields{
+			"deviceID": payload.ID,
+			"topics":   payload.Topics,
+			"err":      err,
+		}).Errorln("Fail to update device topics")
+
+		return nil, skyerr.NewResourceSaveFailureErrWithStringID("device", payload.ID)
+	}
+
+	topics, err := conn.GetDeviceTopics(payload.ID)
+	if err != nil {
+		return nil, skyerr.NewResourceFetchFailureErr("device", payload.ID)
+	}
+
+	return DeviceTopicResult{payload.ID, topics}, nil
+}
+
+// DeviceSubscribeTopicHandler subscribes a device to push topics, so that
+// the device receives notifications sent to the topics with push:topic.
+//
+// Example to subscribe a device to topics:
+//
+//	curl -X POST -H "Content-Type: application/json" \
+//	  -d @- http://localhost:3000/ <<EOF
+//	{
+//		"action": "device:subscribe_topic",
+//		"access_token": "some-access-token",
+//		"id": "existing-device-id",
+//		"topics": ["breaking-news", "sports"]
+//	}
+//	EOF
+//
+type DeviceSubscribeTopicHandler struct {
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectUser    router.Processor `preprocessor:"inject_user"`
+	RequireUser   router.Processor `preprocessor:"require_user"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *DeviceSubscribeTopicHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+		h.RequireUser,
+		h.PluginReady,
+	}
+}
+
+func (h *DeviceSubscribeTopicHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *DeviceSubscribeTopicHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	response.Result, response.Err = updateDeviceTopics(rpayload, func(conn skydb.Conn, payload *deviceTopicPayload) error {
+		return conn.SubscribeDeviceTopics(payload.ID, payload.Topics)
+	})
+}
+
+// DeviceUnsubscribeTopicHandler unsubscribes a device from push topics.
+//
+// Example to unsubscribe a device from a topic:
+//
+//	curl -X POST -H "Content-Type: application/json" \
+//	  -d @- http://localhost:3000/ <<EOF
+//	{
+//		"action": "device:unsubscribe_topic",
+//		"access_token": "some-access-token",
+//		"id": "existing-device-id",
+//		"topics": ["sports"]
+//	}
+//	EOF
+//
+type DeviceUnsubscribeTopicHandler struct {
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectUser    router.Processor `preprocessor:"inject_user"`
+	RequireUser   router.Processor `preprocessor:"require_user"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *DeviceUnsubscribeTopicHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+		h.RequireUser,
+		h.PluginReady,
+	}
+}
+
+func (h *DeviceUnsubscribeTopicHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *DeviceUnsubscribeTopicHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	response.Result, response.Err = updateDeviceTopics(rpayload, func(conn skydb.Conn, payload *deviceTopicPayload) error {
+		return conn.UnsubscribeDeviceTopics(payload.ID, payload.Topics)
+	})
+}
@@ -15,17 +15,20 @@
 package handler
 
 import (
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	"github.com/skygeario/skygear-server/pkg/server/utils"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 type naiveConn struct {
 	devices                  map[string]skydb.Device
+	topics                   map[string][]string
 	mockGetError             error
 	mockGetByTopicError      error
 	mockSaveError            error
@@ -83,11 +86,41 @@ func (conn *naiveConn) DeleteDevicesByToken(token string, t time.Time) error {
 		}
 	}
 
+	for perID := range conn.devices {
+		if _, ok := newDevices[perID]; !ok {
+			delete(conn.topics, perID)
+		}
+	}
 	conn.devices = newDevices
 
 	return nil
 }
 
+func (conn *naiveConn) SubscribeDeviceTopics(deviceID string, topics []string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	if conn.topics == nil {
+		conn.topics = map[string][]string{}
+	}
+	conn.topics[deviceID] = append(utils.StringSliceExcept(conn.topics[deviceID], topics), topics...)
+	sort.Strings(conn.topics[deviceID])
+	return nil
+}
+
+func (conn *naiveConn) UnsubscribeDeviceTopics(deviceID string, topics []string) error {
+	conn.topics[deviceID] = utils.StringSliceExcept(conn.topics[deviceID], topics)
+	return nil
+}
+
+func (conn *naiveConn) GetDeviceTopics(deviceID string) ([]string, error) {
+	topics := conn.topics[deviceID]
+	if topics == nil {
+		topics = []string{}
+	}
+	return topics, nil
+}
+
 func TestDeviceRegisterHandler(t *testing.T) {
 	Convey("DeviceRegisterHandler", t, func() {
 		timeNow = func() time.Time { return time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC) }
@@ -128,6 +161,104 @@ func TestDeviceRegisterHandler(t *testing.T) {
 			})
 		})
 
+		Convey("creates new device with locale", func() {
+			payload.Data = map[string]interface{}{
+				"type":         "android",
+				"device_token": "some-awesome-token",
+				"locale":       "zh-TW",
+			}
+
+			handler := &DeviceRegisterHandler{}
+			handler.Handle(&payload, &resp)
+
+			result := resp.Result.(DeviceReigsterResult)
+			So(conn.devices[result.ID].Locale, ShouldEqual, "zh-TW")
+		})
+
+		Convey("creates new web device", func() {
+			payload.Data = map[string]interface{}{
+				"type":     "web",
+				"endpoint": "https://push.example.com/send/subscription",
+				"keys": map[string]interface{}{
+					"p256dh": "some-public-key",
+					"auth":   "some-auth-secret",
+				},
+			}
+
+			handler := &DeviceRegisterHandler{}
+			handler.Handle(&payload, &resp)
+
+			result := resp.Result.(DeviceReigsterResult)
+			resultID := result.ID
+			So(resultID, ShouldNotBeEmpty)
+			So(conn.devices[resultID], ShouldResemble, skydb.Device{
+				ID:               resultID,
+				Type:             "web",
+				Token:            "https://push.example.com/send/subscription",
+				UserInfoID:       "userinfoid",
+				LastRegisteredAt: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+				Keys: map[string]string{
+					"p256dh": "some-public-key",
+					"auth":   "some-auth-secret",
+				},
+			})
+		})
+
+		Convey("complains on web device without endpoint", func() {
+			payload.Data = map[string]interface{}{
+				"type": "web",
+				"keys": map[string]interface{}{
+					"p256dh": "some-public-key",
+					"auth":   "some-auth-secret",
+				},
+			}
+
+			handler := &DeviceRegisterHandler{}
+			handler.Handle(&payload, &resp)
+
+			err := resp.Err.(skyerr.Error)
+			So(err, ShouldResemble, skyerr.NewInvalidArgument("invalid subscription endpoint", []string{"endpoint"}))
+		})
+
+		Convey("complains on web device with endpoint not public https", func() {
+			for _, endpoint := range []string{
+				"http://push.example.com/send/subscription",
+				"https://localhost/send/subscription",
+				"https://127.0.0.1:8080/send/subscription",
+				"https://10.0.0.1/send/subscription",
+				"https://169.254.169.254/latest/meta-data",
+				"https://[::1]/send/subscription",
+			} {
+				payload.Data = map[string]interface{}{
+					"type":     "web",
+					"endpoint": endpoint,
+					"keys": map[string]interface{}{
+						"p256dh": "some-public-key",
+						"auth":   "some-auth-secret",
+					},
+				}
+
+				resp := router.Response{}
+				handler := &DeviceRegisterHandler{}
+				handler.Handle(&payload, &resp)
+
+				So(resp.Err, ShouldResemble, skyerr.NewInvalidArgument("invalid subscription endpoint", []string{"endpoint"}))
+			}
+		})
+
+		Convey("complains on web device without keys", func() {
+			payload.Data = map[string]interface{}{
+				"type":     "web",
+				"endpoint": "https://push.example.com/send/subscription",
+			}
+
+			handler := &DeviceRegisterHandler{}
+			handler.Handle(&payload, &resp)
+
+			err := resp.Err.(skyerr.Error)
+			So(err, ShouldResemble, skyerr.NewInvalidArgument("missing subscription keys p256dh or auth", []string{"keys"}))
+		})
+
 		Convey("updates old device", func() {
 			olddevice := skydb.Device{
 				ID:               "deviceid",
@@ -162,6 +293,33 @@ func TestDeviceRegisterHandler(t *testing.T) {
 			})
 		})
 
+		Convey("keeps topics of updated device", func() {
+			olddevice := skydb.Device{
+				ID:               "deviceid",
+				Type:             "ios",
+				Token:            "token",
+				Topic:            "topic",
+				UserInfoID:       "userinfoid",
+				LastRegisteredAt: time.Date(2005, 1, 2, 15, 4, 5, 0, time.UTC),
+			}
+			So(conn.SaveDevice(&olddevice), ShouldBeNil)
+			So(conn.SubscribeDeviceTopics("deviceid", []string{"news"}), ShouldBeNil)
+
+			payload.Data = map[string]interface{}{
+				"id":           "deviceid",
+				"type":         "ios",
+				"device_token": "token",
+				"topic":        "topic",
+			}
+
+			handler := &DeviceRegisterHandler{}
+			handler.Handle(&payload, &resp)
+
+			So(resp.Err, ShouldBeNil)
+			So(conn.devices["deviceid"].LastRegisteredAt, ShouldResemble, time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC))
+			So(conn.topics["deviceid"], ShouldResemble, []string{"news"})
+		})
+
 		Convey("remove devices with the same token when register", func() {
 			existingDevice := skydb.Device{
 				ID:               "existing_id",
@@ -412,3 +570,119 @@ func TestDeviceUnregisterHandler(t *testing.T) {
 		})
 	})
 }
+
+func TestDeviceTopicHandler(t *testing.T) {
+	Convey("DeviceTopicHandler", t, func() {
+		conn := naiveConn{
+			devices: map[string]skydb.Device{
+				"device_1": skydb.Device{
+					ID:         "device_1",
+					Type:       "ios",
+					Token:      "device_token_1",
+					Topic:      "device_topic_1",
+					UserInfoID: "user_id_1",
+				},
+			},
+		}
+		payload := router.Payload{
+			DBConn:     &conn,
+			UserInfoID: "user_id_1",
+		}
+		resp := router.Response{}
+
+		Convey("subscribes device to topics", func() {
+			So(conn.SubscribeDeviceTopics("device_1", []string{"weather"}), ShouldBeNil)
+			payload.Data = map[string]interface{}{
+				"id":     "device_1",
+				"topics": []interface{}{"news", "sports"},
+			}
+
+			handler := &DeviceSubscribeTopicHandler{}
+			handler.Handle(&payload, &resp)
+
+			So(resp.Err, ShouldBeNil)
+			So(resp.Result, ShouldResemble, DeviceTopicResult{
+				ID:     "device_1",
+				Topics: []string{"news", "sports", "weather"},
+			})
+		})
+
+		Convey("unsubscribes device from topics", func() {
+			So(conn.SubscribeDeviceTopics("device_1", []string{"news", "sports"}), ShouldBeNil)
+			payload.Data = map[string]interface{}{
+				"id":     "device_1",
+				"topics": []interface{}{"sports"},
+			}
+
+			handler := &DeviceUnsubscribeTopicHandler{}
+			handler.Handle(&payload, &resp)
+
+			So(resp.Err, ShouldBeNil)
+			So(resp.Result, ShouldResemble, DeviceTopicResult{
+				ID:     "device_1",
+				Topics: []string{"news"},
+			})
+		})
+
+		Convey("complains on device of other user", func() {
+			payload.UserInfoID = "user_id_2"
+			payload.Data = map[string]interface{}{
+				"id":     "device_1",
+				"topics": []interface{}{"news"},
+			}
+
+			handler := &DeviceSubscribeTopicHandler{}
+			handler.Handle(&payload, &resp)
+
+			So(resp.Err, ShouldResemble, skyerr.NewError(
+				skyerr.PermissionDenied,
+				"Device is not registered by the current user",
+			))
+			So(conn.topics, ShouldBeEmpty)
+		})
+
+		Convey("complains on non-existent device", func() {
+			payload.Data = map[string]interface{}{
+				"id":     "device_2",
+				"topics": []interface{}{"news"},
+			}
+
+			handler := &DeviceSubscribeTopicHandler{}
+			handler.Handle(&payload, &resp)
+
+			So(resp.Err, ShouldResemble, skyerr.NewError(
+				skyerr.ResourceNotFound,
+				"Device not found",
+			))
+		})
+
+		Convey("complains on invalid topic name", func() {
+			payload.Data = map[string]interface{}{
+				"id":     "device_1",
+				"topics": []interface{}{"breaking news"},
+			}
+
+			handler := &DeviceSubscribeTopicHandler{}
+			handler.Handle(&payload, &resp)
+
+			So(resp.Err, ShouldResemble, skyerr.NewInvalidArgument(
+				"invalid topic name = breaking news",
+				[]string{"topics"},
+			))
+		})
+
+		Convey("complains on empty topics", func() {
+			payload.Data = map[string]interface{}{
+				"id": "device_1",
+			}
+
+			handler := &DeviceUnsubscribeTopicHandler{}
+			handler.Handle(&payload, &resp)
+
+			So(resp.Err, ShouldResemble, skyerr.NewInvalidArgument(
+				"empty topics",
+				[]string{"topics"},
+			))
+		})
+	})
+}
@@ -15,6 +15,9 @@
 package handler
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -23,6 +26,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -67,10 +71,28 @@ func validateAssetGetRequest(assetStore skyAsset.Store, fileName string, expired
 }
 
 // GetFileHandler models the handler for getting asset file
+//
+// A variant of an image asset is returned if any of the query parameters
+// width, height, fit, format and quality is specified, as described in
+// asset.Variant. The parameters are covered by the signature of a signed
+// URL, which is obtained by asset:variant_url. A public asset store, which
+// does not sign URLs, only returns variants of PublicVariantSizes.
+//
+// Range requests and conditional requests with If-None-Match and
+// If-Modified-Since are supported. Files of a public asset store are
+// cached publicly, while files accessed by a signed URL are cached
+// privately until the URL expires.
+//
+// Example curl:
+//	curl 'http://localhost:3000/files/filename?width=64&height=64&fit=cover'
+//
+// Example curl (Range):
+//	curl -H 'Range: bytes=0-1023' 'http://localhost:3000/files/filename'
 type GetFileHandler struct {
-	AssetStore    skyAsset.Store   `inject:"AssetStore"`
-	DBConn        router.Processor `preprocessor:"dbconn"`
-	preprocessors []router.Processor
+	AssetStore         skyAsset.Store   `inject:"AssetStore"`
+	DBConn             router.Processor `preprocessor:"dbconn"`
+	PublicVariantSizes []skyAsset.VariantSize
+	preprocessors      []router.Processor
 }
 
 // Setup sets preprocessors being used
@@ -91,6 +113,13 @@ func (h *GetFileHandler) Handle(payload *router.Payload, response *router.Respon
 
 	store := h.AssetStore
 	fileName := clean(payload.Params[0])
+	variant, err := skyAsset.ParseVariant(payload.Req.Form)
+	if err != nil {
+		response.Err = variantParamError(err)
+		return
+	}
+
+	cacheControl := publicCacheControl
 	if store.(skyAsset.URLSigner).IsSignatureRequired() {
 		expiredAtUnix, err := strconv.ParseInt(payload.Req.Form.Get("expiredAt"), 10, 64)
 		if err != nil {
@@ -98,12 +127,23 @@ func (h *GetFileHandler) Handle(payload *router.Payload, response *router.Respon
 			return
 		}
 
+		// parameters of the variant are covered by the signature
+		signedName := fileName
+		if variant != nil {
+			signedName = skyAsset.SignedNameWithQuery(fileName, variant.Query())
+		}
+
 		signature := payload.Req.Form.Get("signature")
-		requestErr := validateAssetGetRequest(h.AssetStore, fileName, expiredAtUnix, signature)
+		requestErr := validateAssetGetRequest(h.AssetStore, signedName, expiredAtUnix, signature)
 		if requestErr != nil {
 			response.Err = requestErr
 			return
 		}
+
+		cacheControl = signedCacheControl(time.Unix(expiredAtUnix, 0))
+	} else if variant != nil && !isPublicVariantAllowed(h.PublicVariantSizes, variant) {
+		response.Err = skyerr.NewInvalidArgument("variant size is not allowed", []string{"width", "height"})
+		return
 	}
 
 	// everything's right, proceed with the request
@@ -117,13 +157,23 @@ func (h *GetFileHandler) Handle(payload *router.Payload, response *router.Respon
 		return
 	}
 
-	reader, err := store.GetFileReader(fileName)
-	if err != nil {
-		log.Errorf("Failed to get file reader: %v", err)
-
-		response.Err = skyerr.NewResourceFetchFailureErr("asset", fileName)
+	if variant != nil {
+		h.serveVariant(payload, response, fileName, asset.ContentType, variant, cacheControl)
 		return
 	}
+
+	var reader io.ReadCloser
+	if rangeReader, ok := store.(skyAsset.RangeReader); ok {
+		reader = skyAsset.NewRangeReadSeeker(rangeReader, fileName, asset.Size)
+	} else {
+		reader, err = store.GetFileReader(fileName)
+		if err != nil {
+			log.Errorf("Failed to get file reader: %v", err)
+
+			response.Err = skyerr.NewResourceFetchFailureErr("asset", fileName)
+			return
+		}
+	}
 	defer reader.Close()
 
 	writer := response.Writer()
@@ -133,7 +183,32 @@ func (h *GetFileHandler) Handle(payload *router.Payload, response *router.Respon
 	}
 
 	writer.Header().Set("Content-Type", asset.ContentType)
+	writer.Header().Set("ETag", assetETag(fileName, asset.Size))
+	writer.Header().Set("Cache-Control", cacheControl)
+
+	if readSeeker, ok := reader.(io.ReadSeeker); ok {
+		modTime := time.Time{}
+		if stater, ok := reader.(interface {
+			Stat() (os.FileInfo, error)
+		}); ok {
+			if info, err := stater.Stat(); err == nil {
+				modTime = info.ModTime()
+			}
+		}
+
+		http.ServeContent(writer, payload.Req, "", modTime, readSeeker)
+		return
+	}
+
+	if isNotModified(payload.Req, writer.Header().Get("ETag")) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	writer.Header().Set("Content-Length", strconv.FormatInt(asset.Size, 10))
+	if payload.Req.Method == http.MethodHead {
+		return
+	}
 
 	if _, err := io.Copy(writer, reader); err != nil {
 		// there is nothing we can do if error occurred after started
@@ -142,6 +217,144 @@ func (h *GetFileHandler) Handle(payload *router.Payload, response *router.Respon
 	}
 }
 
+// serveVariant writes the variant of the named asset to the response.
+// A generated variant is cached in the asset store, from which it is
+// served later.
+func (h *GetFileHandler) serveVariant(
+	payload *router.Payload,
+	response *router.Response,
+	fileName string,
+	contentType string,
+	variant *skyAsset.Variant,
+	cacheControl string,
+) {
+	if !skyAsset.IsVariantSupported(contentType) {
+		response.Err = skyerr.NewInvalidArgument(
+			skyAsset.ErrVariantSourceNotSupported.Error(),
+			[]string{"width", "height", "fit", "format", "quality"},
+		)
+		return
+	}
+
+	store := h.AssetStore
+	cacheName := variant.CacheName(fileName)
+	data, err := readVariant(store, cacheName)
+	if err != nil {
+		data, err = generateVariant(store, fileName, contentType, variant)
+		if err == skyAsset.ErrVariantSourceNotSupported || err == skyAsset.ErrVariantSourceTooLarge {
+			response.Err = skyerr.NewInvalidArgument(
+				err.Error(),
+				[]string{"width", "height", "fit", "format", "quality"},
+			)
+			return
+		} else if err != nil {
+			log.Errorf("Failed to generate asset variant: %v", err)
+
+			response.Err = skyerr.NewResourceFetchFailureErr("asset", fileName)
+			return
+		}
+
+		variantContentType := variant.ContentType(contentType)
+		if err := store.PutFileReader(cacheName, bytes.NewReader(data), int64(len(data)), variantContentType); err != nil {
+			log.Warnf("Failed to cache asset variant: %v", err)
+		}
+	}
+
+	writer := response.Writer()
+	if writer == nil {
+		// The response is already written.
+		return
+	}
+
+	writer.Header().Set("Content-Type", variant.ContentType(contentType))
+	writer.Header().Set("ETag", assetETag(cacheName, int64(len(data))))
+	writer.Header().Set("Cache-Control", cacheControl)
+
+	http.ServeContent(writer, payload.Req, "", time.Time{}, bytes.NewReader(data))
+}
+
+// publicCacheControl is the Cache-Control of files of a public asset
+// store. Asset names are unique, so the content of a file never changes.
+const publicCacheControl = "public, max-age=31536000"
+
+// signedCacheControl returns the Cache-Control of files accessed by a
+// signed URL expiring at expiredAt, which is not cached after expiry.
+func signedCacheControl(expiredAt time.Time) string {
+	maxAge := int64(expiredAt.Sub(timeNow()) / time.Second)
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	return "private, max-age=" + strconv.FormatInt(maxAge, 10)
+}
+
+// assetETag returns the strong ETag of the named file of the size.
+func assetETag(name string, size int64) string {
+	sum := sha1.Sum([]byte(name + ":" + strconv.FormatInt(size, 10)))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// isNotModified returns whether the If-None-Match header of the request
+// matches the ETag.
+func isNotModified(req *http.Request, etag string) bool {
+	ifNoneMatch := req.Header.Get("If-None-Match")
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// variantSlots limits the number of variants generated concurrently,
+// which takes up a lot of CPU and memory.
+var variantSlots = make(chan struct{}, runtime.NumCPU())
+
+func readVariant(store skyAsset.Store, cacheName string) ([]byte, error) {
+	reader, err := store.GetFileReader(cacheName)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+func generateVariant(store skyAsset.Store, fileName string, contentType string, variant *skyAsset.Variant) ([]byte, error) {
+	variantSlots <- struct{}{}
+	defer func() {
+		<-variantSlots
+	}()
+
+	reader, err := store.GetFileReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return variant.Transform(reader, contentType)
+}
+
+// isPublicVariantAllowed returns whether the variant, which is requested
+// without signature, is of one of the sizes.
+func isPublicVariantAllowed(sizes []skyAsset.VariantSize, variant *skyAsset.Variant) bool {
+	size := variant.Size()
+	for _, allowed := range sizes {
+		if size == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func variantParamError(err error) skyerr.Error {
+	if paramErr, ok := err.(*skyAsset.VariantParamError); ok {
+		return skyerr.NewInvalidArgument(paramErr.Error(), []string{paramErr.Param})
+	}
+	return skyerr.MakeError(err)
+}
+
 // UploadFileHandler receives and persists a file to be associated by Record.
 //
 // Example curl (PUT):
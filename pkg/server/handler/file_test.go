@@ -17,10 +17,15 @@ package handler
 import (
 	"bytes"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -139,6 +144,11 @@ func (store *bufferedAssetStore) GeneratePostFileRequest(name string) (*asset.Po
 	}, nil
 }
 
+func (store *bufferedAssetStore) Delete(name string) error {
+	store.buf.Reset()
+	return nil
+}
+
 func (store *bufferedAssetStore) SignedURL(name string) (string, error) {
 	return name + "?signedurl=true", nil
 }
@@ -371,3 +381,349 @@ func TestGetFileHandler(t *testing.T) {
 		})
 	})
 }
+
+func TestGetFileHandlerVariant(t *testing.T) {
+	Convey("GetFileHandler with variant", t, func() {
+		dir, err := ioutil.TempDir("", "skygear-asset")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store := asset.NewFileStore(dir, "http://skygear.test/files", "secret", false)
+		signer := store.(asset.QueryURLSigner)
+
+		img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+		buf := bytes.Buffer{}
+		So(png.Encode(&buf, img), ShouldBeNil)
+		So(store.PutFileReader("photo.png", bytes.NewReader(buf.Bytes()), int64(buf.Len()), "image/png"), ShouldBeNil)
+		So(store.PutFileReader("note.txt", strings.NewReader("I am a boy"), 10, "plain/text"), ShouldBeNil)
+
+		assetConn := &naiveAssetConn{}
+		assetConn.savedAsset = map[string]*skydb.Asset{
+			"photo.png": {
+				Name:        "photo.png",
+				ContentType: "image/png",
+				Size:        int64(buf.Len()),
+			},
+			"note.txt": {
+				Name:        "note.txt",
+				ContentType: "plain/text",
+				Size:        10,
+			},
+		}
+
+		r := newmodGateway("files/(.+)")
+		r.Handle("GET", &GetFileHandler{
+			AssetStore: store,
+		}, func(p *router.Payload) {
+			p.DBConn = assetConn
+		})
+
+		get := func(signedURL string) *httptest.ResponseRecorder {
+			return r.GET(strings.TrimPrefix(signedURL, "http://skygear.test/"))
+		}
+
+		Convey("generates variant", func() {
+			signedURL, err := signer.SignedURLWithQuery("photo.png", url.Values{
+				"width":  []string{"10"},
+				"format": []string{"jpeg"},
+			})
+			So(err, ShouldBeNil)
+
+			resp := get(signedURL)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "image/jpeg")
+			So(resp.Header().Get("Content-Length"), ShouldEqual, strconv.Itoa(resp.Body.Len()))
+			config, format, err := image.DecodeConfig(resp.Body)
+			So(err, ShouldBeNil)
+			So(format, ShouldEqual, "jpeg")
+			So(config.Width, ShouldEqual, 10)
+			So(config.Height, ShouldEqual, 5)
+
+			Convey("serves cached variant", func() {
+				So(store.PutFileReader(".variants/photo.png/w10_jpeg", strings.NewReader("cached"), 6, "image/jpeg"), ShouldBeNil)
+
+				resp := get(signedURL)
+				So(resp.Code, ShouldEqual, http.StatusOK)
+				So(resp.Header().Get("Content-Type"), ShouldEqual, "image/jpeg")
+				So(resp.Body.String(), ShouldEqual, "cached")
+			})
+		})
+
+		Convey("rejects variant not covered by signature", func() {
+			signedURL, err := signer.SignedURLWithQuery("photo.png", url.Values{
+				"width": []string{"10"},
+			})
+			So(err, ShouldBeNil)
+
+			resp := get(strings.Replace(signedURL, "width=10", "width=20", 1))
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 106,
+					"name": "InvalidSignature",
+					"message": "Invalid signature"
+				}
+			}`)
+
+			signedURL, err = signer.(asset.URLSigner).SignedURL("photo.png")
+			So(err, ShouldBeNil)
+			resp = get(signedURL + "&width=10")
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 106,
+					"name": "InvalidSignature",
+					"message": "Invalid signature"
+				}
+			}`)
+		})
+
+		Convey("rejects invalid variant", func() {
+			resp := r.GET("files/photo.png?width=4096")
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"name": "InvalidArgument",
+					"message": "invalid width: must be an integer between 1 and 2048",
+					"info": {"arguments": ["width"]}
+				}
+			}`)
+		})
+
+		Convey("rejects variant of asset which is not image", func() {
+			signedURL, err := signer.SignedURLWithQuery("note.txt", url.Values{
+				"width": []string{"10"},
+			})
+			So(err, ShouldBeNil)
+
+			resp := get(signedURL)
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"name": "InvalidArgument",
+					"message": "asset is not a supported image",
+					"info": {"arguments": ["width", "height", "fit", "format", "quality"]}
+				}
+			}`)
+		})
+
+		Convey("with public file store", func() {
+			dir, err := ioutil.TempDir("", "skygear-asset")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			store := asset.NewFileStore(dir, "http://skygear.test/files", "secret", true)
+			So(store.PutFileReader("photo.png", bytes.NewReader(buf.Bytes()), int64(buf.Len()), "image/png"), ShouldBeNil)
+
+			r := newmodGateway("files/(.+)")
+			r.Handle("GET", &GetFileHandler{
+				AssetStore:         store,
+				PublicVariantSizes: []asset.VariantSize{{Width: 10}},
+			}, func(p *router.Payload) {
+				p.DBConn = assetConn
+			})
+
+			Convey("generates variant of allowed size", func() {
+				resp := r.GET("files/photo.png?width=10")
+				So(resp.Code, ShouldEqual, http.StatusOK)
+				config, _, err := image.DecodeConfig(resp.Body)
+				So(err, ShouldBeNil)
+				So(config.Width, ShouldEqual, 10)
+			})
+
+			Convey("rejects variant of size not allowed", func() {
+				for _, query := range []string{"width=11", "width=10&height=5", "height=5"} {
+					resp := r.GET("files/photo.png?" + query)
+					So(resp.Body.String(), ShouldEqualJSON, `{
+						"error": {
+							"code": 108,
+							"name": "InvalidArgument",
+							"message": "variant size is not allowed",
+							"info": {"arguments": ["width", "height"]}
+						}
+					}`)
+				}
+			})
+		})
+	})
+}
+
+// an asset store that reads ranges of a string
+type rangeAssetStore struct {
+	*bufferedAssetStore
+	content string
+	offsets []int64
+	lengths []int64
+}
+
+func (store *rangeAssetStore) GetFileReader(name string) (io.ReadCloser, error) {
+	panic("this should not be called")
+}
+
+func (store *rangeAssetStore) GetRangeReader(name string, offset int64, length int64) (io.ReadCloser, error) {
+	store.offsets = append(store.offsets, offset)
+	store.lengths = append(store.lengths, length)
+	return ioutil.NopCloser(strings.NewReader(store.content[offset : offset+length])), nil
+}
+
+func TestGetFileHandlerCaching(t *testing.T) {
+	Convey("GetFileHandler caching and range requests", t, func() {
+		assetConn := &naiveAssetConn{}
+		assetConn.savedAsset = map[string]*skydb.Asset{
+			"note.txt": {
+				Name:        "note.txt",
+				ContentType: "plain/text",
+				Size:        10,
+			},
+		}
+		etag := assetETag("note.txt", 10)
+
+		newGateway := func(store asset.Store) *modGateway {
+			r := newmodGateway("files/(.+)")
+			getFileHandler := &GetFileHandler{
+				AssetStore: store,
+			}
+			prepare := func(p *router.Payload) {
+				p.DBConn = assetConn
+			}
+			r.Handle("GET", getFileHandler, prepare)
+			r.Handle("HEAD", getFileHandler, prepare)
+			return r
+		}
+
+		request := func(method string, path string, header map[string]string) *http.Request {
+			req, _ := http.NewRequest(method, "http://skygear.test/"+path, nil)
+			for key, value := range header {
+				req.Header.Set(key, value)
+			}
+			return req
+		}
+
+		Convey("with public file store", func() {
+			dir, err := ioutil.TempDir("", "skygear-asset")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			store := asset.NewFileStore(dir, "http://skygear.test/files", "secret", true)
+			So(store.PutFileReader("note.txt", strings.NewReader("I am a boy"), 10, "plain/text"), ShouldBeNil)
+			r := newGateway(store)
+
+			Convey("serves file with cache headers", func() {
+				resp := r.GET("files/note.txt")
+				So(resp.Code, ShouldEqual, http.StatusOK)
+				So(resp.Body.String(), ShouldEqual, "I am a boy")
+				So(resp.Header().Get("Content-Type"), ShouldEqual, "plain/text")
+				So(resp.Header().Get("Content-Length"), ShouldEqual, "10")
+				So(resp.Header().Get("Accept-Ranges"), ShouldEqual, "bytes")
+				So(resp.Header().Get("ETag"), ShouldEqual, etag)
+				So(resp.Header().Get("Cache-Control"), ShouldEqual, "public, max-age=31536000")
+				So(resp.Header().Get("Last-Modified"), ShouldNotBeEmpty)
+			})
+
+			Convey("serves range of file", func() {
+				resp := r.Do(request("GET", "files/note.txt", map[string]string{
+					"Range": "bytes=2-3",
+				}))
+				So(resp.Code, ShouldEqual, http.StatusPartialContent)
+				So(resp.Body.String(), ShouldEqual, "am")
+				So(resp.Header().Get("Content-Range"), ShouldEqual, "bytes 2-3/10")
+			})
+
+			Convey("rejects unsatisfiable range", func() {
+				resp := r.Do(request("GET", "files/note.txt", map[string]string{
+					"Range": "bytes=20-30",
+				}))
+				So(resp.Code, ShouldEqual, http.StatusRequestedRangeNotSatisfiable)
+			})
+
+			Convey("serves not modified on matching ETag", func() {
+				resp := r.Do(request("GET", "files/note.txt", map[string]string{
+					"If-None-Match": etag,
+				}))
+				So(resp.Code, ShouldEqual, http.StatusNotModified)
+				So(resp.Body.String(), ShouldBeEmpty)
+			})
+
+			Convey("serves headers only on HEAD", func() {
+				resp := r.Do(request("HEAD", "files/note.txt", nil))
+				So(resp.Code, ShouldEqual, http.StatusOK)
+				So(resp.Body.String(), ShouldBeEmpty)
+				So(resp.Header().Get("Content-Length"), ShouldEqual, "10")
+				So(resp.Header().Get("ETag"), ShouldEqual, etag)
+			})
+		})
+
+		Convey("with private file store", func() {
+			dir, err := ioutil.TempDir("", "skygear-asset")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			store := asset.NewFileStore(dir, "http://skygear.test/files", "secret", false)
+			So(store.PutFileReader("note.txt", strings.NewReader("I am a boy"), 10, "plain/text"), ShouldBeNil)
+			r := newGateway(store)
+
+			Convey("caches privately until the URL expires", func() {
+				signedURL, err := store.(asset.URLSigner).SignedURL("note.txt")
+				So(err, ShouldBeNil)
+
+				resp := r.GET(strings.TrimPrefix(signedURL, "http://skygear.test/"))
+				So(resp.Code, ShouldEqual, http.StatusOK)
+				So(resp.Body.String(), ShouldEqual, "I am a boy")
+
+				cacheControl := resp.Header().Get("Cache-Control")
+				So(cacheControl, ShouldStartWith, "private, max-age=")
+				maxAge, err := strconv.Atoi(strings.TrimPrefix(cacheControl, "private, max-age="))
+				So(err, ShouldBeNil)
+				So(maxAge, ShouldBeGreaterThan, 0)
+				So(maxAge, ShouldBeLessThanOrEqualTo, 15*60)
+			})
+		})
+
+		Convey("with range reading store", func() {
+			store := &rangeAssetStore{
+				bufferedAssetStore: newBufferedStore(),
+				content:            "I am a boy",
+			}
+			r := newGateway(store)
+
+			Convey("reads only the requested range", func() {
+				resp := r.Do(request("GET", "files/note.txt", map[string]string{
+					"Range": "bytes=5-",
+				}))
+				So(resp.Code, ShouldEqual, http.StatusPartialContent)
+				So(resp.Body.String(), ShouldEqual, "a boy")
+				So(resp.Header().Get("Content-Range"), ShouldEqual, "bytes 5-9/10")
+				So(store.offsets, ShouldResemble, []int64{5})
+				So(store.lengths, ShouldResemble, []int64{5})
+			})
+
+			Convey("reads nothing on matching ETag", func() {
+				resp := r.Do(request("GET", "files/note.txt", map[string]string{
+					"If-None-Match": etag,
+				}))
+				So(resp.Code, ShouldEqual, http.StatusNotModified)
+				So(store.offsets, ShouldBeEmpty)
+			})
+		})
+
+		Convey("with store which is not seekable", func() {
+			store := newBufferedStore()
+			io.WriteString(store.buf, "I am a boy")
+			r := newGateway(store)
+
+			Convey("serves not modified on matching ETag", func() {
+				resp := r.Do(request("GET", "files/note.txt", map[string]string{
+					"If-None-Match": `W/"abc", ` + etag,
+				}))
+				So(resp.Code, ShouldEqual, http.StatusNotModified)
+				So(resp.Body.String(), ShouldBeEmpty)
+			})
+
+			Convey("serves file with cache headers", func() {
+				resp := r.GET("files/note.txt")
+				So(resp.Code, ShouldEqual, http.StatusOK)
+				So(resp.Body.String(), ShouldEqual, "I am a boy")
+				So(resp.Header().Get("ETag"), ShouldEqual, etag)
+				So(resp.Header().Get("Cache-Control"), ShouldEqual, "public, max-age=31536000")
+			})
+		})
+	})
+}
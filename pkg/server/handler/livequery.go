@@ -0,0 +1,362 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/pubsub"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// LiveQueryService serves live queries subscribed over the pubsub
+// websocket. It implements pubsub.QueryHandler.
+//
+// A live query is a record query in the same format as record:query.
+// The subscriber receives the initial results of the query, followed by
+// a create, update or delete event whenever a record enters, changes
+// within or leaves the results:
+//
+//	{"event": "initial", "records": [{"_id": "note/1", ...}]}
+//	{"event": "create", "record_id": "note/2", "record": {"_id": "note/2", ...}}
+//	{"event": "update", "record_id": "note/1", "record": {"_id": "note/1", ...}}
+//	{"event": "delete", "record_id": "note/1", "record": {"_id": "note/1", ...}}
+//	{"event": "error", "error": {"name": "NotSupported", ...}}
+//
+// Records are filtered by record ACL and record policy as seen by the
+// subscriber. The record of a delete event is omitted unless the
+// subscriber can still read it under both. Only queries on the public
+// database are supported, and the predicate must be one that can be saved
+// in a subscription.
+type LiveQueryService struct {
+	ConnOpener func() (skydb.Conn, error)
+	AssetStore asset.Store
+
+	mutex   sync.Mutex
+	queries map[*liveQuery]struct{}
+}
+
+// Run receives record events from the database and sends changes to the
+// results of subscribed live queries. It blocks until the record
+// event channel is closed.
+func (s *LiveQueryService) Run() {
+	conn, err := s.ConnOpener()
+	if err != nil {
+		log.WithField("err", err).Errorln("livequery: failed to open skydb.Conn")
+		return
+	}
+
+	recordEventCh := make(chan skydb.RecordEvent)
+	if err := conn.Subscribe(recordEventCh); err != nil {
+		log.WithField("err", err).Errorln("livequery: failed to subscribe record events")
+		return
+	}
+
+	for event := range recordEventCh {
+		s.handleRecordEvent(event)
+	}
+}
+
+// SubscribeQuery starts a live query. data is a record:query payload.
+// Records are matched and returned as viewed by the client.
+func (s *LiveQueryService) SubscribeQuery(client pubsub.Client, data []byte, send func(data []byte)) func() {
+	lq := &liveQuery{
+		userInfo:  client.UserInfo,
+		masterKey: client.MasterKey,
+		send:      send,
+		recordIDs: map[skydb.RecordID]struct{}{},
+	}
+
+	if err := s.startLiveQuery(lq, data); err != nil {
+		lq.sendEvent(map[string]interface{}{
+			"event": "error",
+			"error": err,
+		})
+		return nil
+	}
+
+	return func() {
+		s.mutex.Lock()
+		delete(s.queries, lq)
+		s.mutex.Unlock()
+	}
+}
+
+func (s *LiveQueryService) startLiveQuery(lq *liveQuery, data []byte) skyerr.Error {
+	rawQuery := map[string]interface{}{}
+	if err := json.Unmarshal(data, &rawQuery); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the live query")
+	}
+
+	if databaseID, ok := rawQuery["database_id"]; ok && databaseID != "_public" {
+		return skyerr.NewInvalidArgument("live query only supports the public database", []string{"database_id"})
+	}
+
+	parser := QueryParser{}
+	if lq.userInfo != nil {
+		parser.UserID = lq.userInfo.ID
+	}
+	if err := parser.queryFromRaw(rawQuery, &lq.query); err != nil {
+		return err
+	}
+
+	if len(lq.query.ComputedKeys) > 0 {
+		return skyerr.NewError(skyerr.NotSupported, "include is not supported in live query")
+	}
+
+	conn, err := s.ConnOpener()
+	if err != nil {
+		return skyerr.MakeError(err)
+	}
+	defer conn.Close()
+	db := conn.PublicDB()
+
+	// reject predicate that cannot be matched against a changed record
+	if _, err := db.MatchQuery(&lq.query, &skydb.Record{}); err != nil {
+		return skyerr.MakeError(err)
+	}
+
+	lq.query.ViewAsUser = lq.userInfo
+	lq.query.BypassAccessControl = lq.masterKey
+
+	// The live query is added before querying the initial results so that
+	// changes made during the query are not missed. Such changes are
+	// handled after the initial results are sent.
+	lq.mutex.Lock()
+	defer lq.mutex.Unlock()
+
+	s.mutex.Lock()
+	if s.queries == nil {
+		s.queries = map[*liveQuery]struct{}{}
+	}
+	s.queries[lq] = struct{}{}
+	s.mutex.Unlock()
+
+	records, err := queryLiveQueryRecords(db, &lq.query)
+	if err != nil {
+		s.mutex.Lock()
+		delete(s.queries, lq)
+		s.mutex.Unlock()
+		return skyerr.MakeError(err)
+	}
+
+	makeAssetsComplete(db, conn, records)
+
+	output := make([]interface{}, len(records))
+	for i := range records {
+		lq.recordIDs[records[i].ID] = struct{}{}
+		output[i] = lq.jsonRecord(&records[i], s.AssetStore)
+	}
+
+	lq.sendEvent(map[string]interface{}{
+		"event":   "initial",
+		"records": output,
+	})
+	return nil
+}
+
+func queryLiveQueryRecords(db skydb.Database, query *skydb.Query) ([]skydb.Record, error) {
+	results, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	records := []skydb.Record{}
+	for results.Scan() {
+		records = append(records, results.Record())
+	}
+
+	return records, results.Err()
+}
+
+func (s *LiveQueryService) queriesOfType(recordType string) []*liveQuery {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	queries := []*liveQuery{}
+	for lq := range s.queries {
+		if lq.query.Type == recordType {
+			queries = append(queries, lq)
+		}
+	}
+	return queries
+}
+
+func (s *LiveQueryService) handleRecordEvent(event skydb.RecordEvent) {
+	if event.Record == nil || event.Record.DatabaseID != "" {
+		return
+	}
+
+	queries := s.queriesOfType(event.Record.ID.Type)
+	if len(queries) == 0 {
+		return
+	}
+
+	conn, err := s.ConnOpener()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"event": event,
+			"err":   err,
+		}).Errorln("livequery: failed to open skydb.Conn")
+		return
+	}
+	defer conn.Close()
+	db := conn.PublicDB()
+
+	// The record is fetched again so that it is complete and up to date
+	// with the changes that are notified later.
+	record := event.Record
+	deleted := event.Event == skydb.RecordDeleted
+	if !deleted {
+		fetched := skydb.Record{}
+		switch err := db.Get(record.ID, &fetched); err {
+		case nil:
+			records := []skydb.Record{fetched}
+			makeAssetsComplete(db, conn, records)
+			record = &records[0]
+		case skydb.ErrRecordNotFound:
+			deleted = true
+		default:
+			log.WithFields(logrus.Fields{
+				"recordID": record.ID,
+				"err":      err,
+			}).Errorln("livequery: failed to fetch changed record")
+			return
+		}
+	}
+
+	for _, lq := range queries {
+		lq.handleRecord(db, conn, record, deleted, s.AssetStore)
+	}
+}
+
+type liveQuery struct {
+	query     skydb.Query
+	userInfo  *skydb.UserInfo
+	masterKey bool
+	send      func(data []byte)
+
+	// mutex guards recordIDs and the order of events sent
+	mutex sync.Mutex
+
+	// IDs of records in the results known to the subscriber
+	recordIDs map[skydb.RecordID]struct{}
+}
+
+func (lq *liveQuery) handleRecord(db skydb.Database, conn skydb.Conn, record *skydb.Record, deleted bool, store asset.Store) {
+	lq.mutex.Lock()
+	defer lq.mutex.Unlock()
+
+	fetcher := newRecordFetcher(db, conn, lq.masterKey)
+	_, inResults := lq.recordIDs[record.ID]
+
+	matched := false
+	if !deleted {
+		var err error
+		matched, err = lq.matchRecord(fetcher, record)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"recordID": record.ID,
+				"err":      err,
+			}).Errorln("livequery: failed to match record")
+			return
+		}
+	}
+
+	var event string
+	switch {
+	case matched && inResults:
+		event = "update"
+	case matched:
+		event = "create"
+		lq.recordIDs[record.ID] = struct{}{}
+	case inResults:
+		event = "delete"
+		delete(lq.recordIDs, record.ID)
+	default:
+		return
+	}
+
+	data := map[string]interface{}{
+		"event":     event,
+		"record_id": record.ID.String(),
+	}
+	if matched || lq.readable(fetcher, record) {
+		data["record"] = lq.jsonRecord(record, store)
+	}
+
+	lq.sendEvent(data)
+}
+
+func (lq *liveQuery) matchRecord(fetcher recordFetcher, record *skydb.Record) (bool, error) {
+	if matched, err := fetcher.db.MatchQuery(&lq.query, record); err != nil || !matched {
+		return false, err
+	}
+
+	if accessible, err := fetcher.accessible(record, lq.userInfo, skydb.ReadLevel); err != nil || !accessible {
+		return false, err
+	}
+
+	if matched, err := fetcher.matchRecordPolicy(record, lq.userInfo); err != nil || !matched {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// readable returns true if the subscriber can still read the record,
+// which is checked against both record ACL and record policy.
+func (lq *liveQuery) readable(fetcher recordFetcher, record *skydb.Record) bool {
+	if accessible, err := fetcher.accessible(record, lq.userInfo, skydb.ReadLevel); err != nil || !accessible {
+		return false
+	}
+
+	matched, err := fetcher.matchRecordPolicy(record, lq.userInfo)
+	return err == nil && matched
+}
+
+// jsonRecord returns the record to be sent to the subscriber, with only
+// the desired keys of the query.
+func (lq *liveQuery) jsonRecord(record *skydb.Record, store asset.Store) *skyconv.JSONRecord {
+	r := *record
+	if lq.query.DesiredKeys != nil {
+		r.Data = skydb.Data{}
+		for _, key := range lq.query.DesiredKeys {
+			if value, ok := record.Data[key]; ok {
+				r.Data[key] = value
+			}
+		}
+	}
+
+	injectSigner(&r, store)
+	return (*skyconv.JSONRecord)(&r)
+}
+
+func (lq *liveQuery) sendEvent(data map[string]interface{}) {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		log.WithField("err", err).Errorln("livequery: failed to encode event")
+		return
+	}
+
+	lq.send(bytes)
+}
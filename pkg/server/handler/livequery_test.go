@@ -0,0 +1,312 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/pubsub"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type liveQueryConn struct {
+	db *liveQueryDatabase
+	*skydbtest.MapConn
+}
+
+func (conn *liveQueryConn) PublicDB() skydb.Database {
+	return conn.db
+}
+
+type liveQueryDatabase struct {
+	*skydbtest.MapDB
+}
+
+func (db *liveQueryDatabase) Query(query *skydb.Query) (*skydb.Rows, error) {
+	records := []skydb.Record{}
+	for _, record := range db.RecordMap {
+		record := record
+		if matched, _ := db.MatchQuery(query, &record); matched {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID.Key < records[j].ID.Key
+	})
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+func (db *liveQueryDatabase) MatchQuery(query *skydb.Query, record *skydb.Record) (bool, error) {
+	if query.Predicate.Operator == skydb.Functional {
+		return false, skyerr.NewError(skyerr.NotSupported, "functional predicate is not supported")
+	}
+	return query.Type == record.ID.Type && query.Predicate.MatchRecord(record), nil
+}
+
+func TestLiveQueryService(t *testing.T) {
+	Convey("LiveQueryService", t, func() {
+		db := &liveQueryDatabase{skydbtest.NewMapDB()}
+		conn := &liveQueryConn{db, skydbtest.NewMapConn()}
+		db.DBConn = conn
+
+		service := &LiveQueryService{
+			ConnOpener: func() (skydb.Conn, error) {
+				return conn, nil
+			},
+		}
+
+		saveNote := func(key string, score float64, acl skydb.RecordACL) *skydb.Record {
+			record := skydb.Record{
+				ID:      skydb.NewRecordID("note", key),
+				OwnerID: "user1",
+				ACL:     acl,
+				Data:    map[string]interface{}{"score": score},
+			}
+			db.RecordMap[record.ID.String()] = record
+			return &record
+		}
+
+		events := []map[string]interface{}{}
+		send := func(data []byte) {
+			event := map[string]interface{}{}
+			So(json.Unmarshal(data, &event), ShouldBeNil)
+			events = append(events, event)
+		}
+
+		recordIDOf := func(record interface{}) string {
+			return record.(map[string]interface{})["_id"].(string)
+		}
+
+		saveNote("1", 1, nil)
+		saveNote("2", 5, nil)
+
+		Convey("sends initial results", func() {
+			unsubscribe := service.SubscribeQuery(pubsub.Client{}, []byte(`{
+				"record_type": "note",
+				"predicate": ["gt", {"$type": "keypath", "$val": "score"}, 2]
+			}`), send)
+
+			So(unsubscribe, ShouldNotBeNil)
+			So(events, ShouldHaveLength, 1)
+			So(events[0]["event"], ShouldEqual, "initial")
+			records := events[0]["records"].([]interface{})
+			So(records, ShouldHaveLength, 1)
+			So(recordIDOf(records[0]), ShouldEqual, "note/2")
+			So(records[0].(map[string]interface{})["score"], ShouldEqual, 5)
+		})
+
+		Convey("sends empty initial results", func() {
+			service.SubscribeQuery(pubsub.Client{}, []byte(`{"record_type": "comment"}`), send)
+
+			So(events, ShouldHaveLength, 1)
+			So(events[0]["event"], ShouldEqual, "initial")
+			So(events[0]["records"], ShouldResemble, []interface{}{})
+		})
+
+		Convey("sends changes to the results", func() {
+			unsubscribe := service.SubscribeQuery(pubsub.Client{}, []byte(`{
+				"record_type": "note",
+				"predicate": ["gt", {"$type": "keypath", "$val": "score"}, 2]
+			}`), send)
+			events = events[:0]
+
+			Convey("sends create when a record starts matching", func() {
+				record := saveNote("3", 3, nil)
+				service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordCreated})
+				record = saveNote("1", 10, nil)
+				service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordUpdated})
+
+				So(events, ShouldHaveLength, 2)
+				So(events[0]["event"], ShouldEqual, "create")
+				So(events[0]["record_id"], ShouldEqual, "note/3")
+				So(recordIDOf(events[0]["record"]), ShouldEqual, "note/3")
+				So(events[1]["event"], ShouldEqual, "create")
+				So(events[1]["record_id"], ShouldEqual, "note/1")
+			})
+
+			Convey("sends update when a record in results changes", func() {
+				record := saveNote("2", 6, nil)
+				service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordUpdated})
+
+				So(events, ShouldHaveLength, 1)
+				So(events[0]["event"], ShouldEqual, "update")
+				So(events[0]["record"].(map[string]interface{})["score"], ShouldEqual, 6)
+			})
+
+			Convey("sends delete when a record stops matching", func() {
+				record := saveNote("2", 0, nil)
+				service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordUpdated})
+
+				So(events, ShouldHaveLength, 1)
+				So(events[0]["event"], ShouldEqual, "delete")
+				So(events[0]["record_id"], ShouldEqual, "note/2")
+				So(events[0]["record"].(map[string]interface{})["score"], ShouldEqual, 0)
+			})
+
+			Convey("sends delete when a record is deleted", func() {
+				record := db.RecordMap["note/2"]
+				delete(db.RecordMap, "note/2")
+				service.handleRecordEvent(skydb.RecordEvent{Record: &record, Event: skydb.RecordDeleted})
+
+				So(events, ShouldHaveLength, 1)
+				So(events[0]["event"], ShouldEqual, "delete")
+				So(events[0]["record_id"], ShouldEqual, "note/2")
+			})
+
+			Convey("sends delete without record when a record is no longer readable", func() {
+				record := saveNote("2", 5, skydb.NewRecordACL([]skydb.RecordACLEntry{
+					skydb.NewRecordACLEntryDirect("user1", skydb.ReadLevel),
+				}))
+				service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordUpdated})
+
+				So(events, ShouldHaveLength, 1)
+				So(events[0]["event"], ShouldEqual, "delete")
+				So(events[0]["record_id"], ShouldEqual, "note/2")
+				So(events[0], ShouldNotContainKey, "record")
+			})
+
+			Convey("sends delete without record when a record is excluded by record policy", func() {
+				conn.SetRecordPolicy("note", skydb.Predicate{
+					Operator: skydb.LessThan,
+					Children: []interface{}{
+						skydb.Expression{Type: skydb.KeyPath, Value: "score"},
+						skydb.Expression{Type: skydb.Literal, Value: float64(100)},
+					},
+				})
+				record := saveNote("2", 200, nil)
+				service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordUpdated})
+
+				So(events, ShouldHaveLength, 1)
+				So(events[0]["event"], ShouldEqual, "delete")
+				So(events[0]["record_id"], ShouldEqual, "note/2")
+				So(events[0], ShouldNotContainKey, "record")
+			})
+
+			Convey("does not send unreadable record", func() {
+				record := saveNote("3", 3, skydb.NewRecordACL([]skydb.RecordACLEntry{
+					skydb.NewRecordACLEntryDirect("user1", skydb.ReadLevel),
+				}))
+				service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordCreated})
+
+				So(events, ShouldBeEmpty)
+			})
+
+			Convey("does not send record not matching", func() {
+				record := saveNote("1", 2, nil)
+				service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordUpdated})
+
+				So(events, ShouldBeEmpty)
+			})
+
+			Convey("does not send record in private database", func() {
+				record := saveNote("3", 3, nil)
+				record.DatabaseID = "user1"
+				service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordCreated})
+
+				So(events, ShouldBeEmpty)
+			})
+
+			Convey("does not send after unsubscribe", func() {
+				unsubscribe()
+				record := saveNote("3", 3, nil)
+				service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordCreated})
+
+				So(events, ShouldBeEmpty)
+			})
+		})
+
+		Convey("sends records readable by the user", func() {
+			onlyUser1 := skydb.NewRecordACL([]skydb.RecordACLEntry{
+				skydb.NewRecordACLEntryDirect("user1", skydb.ReadLevel),
+			})
+
+			service.SubscribeQuery(pubsub.Client{
+				UserInfo: &skydb.UserInfo{ID: "user1"},
+			}, []byte(`{
+				"record_type": "note"
+			}`), send)
+			events = events[:0]
+
+			record := saveNote("3", 3, onlyUser1)
+			service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordCreated})
+
+			So(events, ShouldHaveLength, 1)
+			So(events[0]["event"], ShouldEqual, "create")
+			So(recordIDOf(events[0]["record"]), ShouldEqual, "note/3")
+		})
+
+		Convey("sends records regardless of ACL with master key", func() {
+			service.SubscribeQuery(pubsub.Client{MasterKey: true}, []byte(`{
+				"record_type": "note"
+			}`), send)
+			events = events[:0]
+
+			record := saveNote("3", 3, skydb.NewRecordACL([]skydb.RecordACLEntry{
+				skydb.NewRecordACLEntryDirect("user2", skydb.ReadLevel),
+			}))
+			service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordCreated})
+
+			So(events, ShouldHaveLength, 1)
+			So(events[0]["event"], ShouldEqual, "create")
+		})
+
+		Convey("sends only desired keys", func() {
+			db.RecordMap["note/2"].Data["title"] = "Hello"
+			service.SubscribeQuery(pubsub.Client{}, []byte(`{
+				"record_type": "note",
+				"desired_keys": ["title"]
+			}`), send)
+			events = events[:0]
+
+			record := saveNote("3", 3, nil)
+			record.Data["title"] = "World"
+			service.handleRecordEvent(skydb.RecordEvent{Record: record, Event: skydb.RecordCreated})
+
+			So(events, ShouldHaveLength, 1)
+			data := events[0]["record"].(map[string]interface{})
+			So(data["title"], ShouldEqual, "World")
+			So(data, ShouldNotContainKey, "score")
+		})
+
+		Convey("sends error for invalid live query", func() {
+			sendError := func(data string) map[string]interface{} {
+				events = events[:0]
+				So(service.SubscribeQuery(pubsub.Client{}, []byte(data), send), ShouldBeNil)
+				So(events, ShouldHaveLength, 1)
+				So(events[0]["event"], ShouldEqual, "error")
+				return events[0]["error"].(map[string]interface{})
+			}
+
+			So(sendError(`{`)["name"], ShouldEqual, "BadRequest")
+			So(sendError(`{"predicate": []}`)["name"], ShouldEqual, "InvalidArgument")
+			So(sendError(`{"record_type": "note", "database_id": "_private"}`)["name"], ShouldEqual, "InvalidArgument")
+			So(sendError(`{
+				"record_type": "note",
+				"include": {"city": {"$type": "keypath", "$val": "city"}}
+			}`)["name"], ShouldEqual, "NotSupported")
+			So(sendError(`{
+				"record_type": "note",
+				"predicate": ["func", "userDiscover", {"usernames": ["john"]}]
+			}`)["name"], ShouldEqual, "NotSupported")
+
+			So(service.queries, ShouldBeEmpty)
+		})
+	})
+}
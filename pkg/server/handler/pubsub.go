@@ -15,19 +15,37 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
 	"github.com/skygeario/skygear-server/pkg/server/pubsub"
 	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 )
 
+// PubSubHandler upgrades the request to a pubsub websocket. The client
+// connects with an API key or an access token, passed as the api_key
+// and access_token query parameters respectively.
 type PubSubHandler struct {
 	WebSocket     *pubsub.WsPubSub
-	AccessKey     router.Processor `preprocessor:"accesskey"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectUser    router.Processor `preprocessor:"inject_user"`
 	preprocessors []router.Processor
 }
 
 func (h *PubSubHandler) Setup() {
 	h.preprocessors = []router.Processor{
-		h.AccessKey,
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
 	}
 }
 
@@ -42,5 +60,702 @@ func (h *PubSubHandler) Handle(payload *router.Payload, response *router.Respons
 		return
 	}
 
-	h.WebSocket.Handle(writer, payload.Req)
+	h.WebSocket.Handle(writer, payload.Req, pubsub.Client{
+		UserInfo:  payload.UserInfo,
+		MasterKey: payload.HasMasterKey(),
+	})
+}
+
+const (
+	userChannelPrefix         = "_user_"
+	subscriptionChannelPrefix = "_sub_"
+)
+
+// PubSubAuthorizer authorizes pubsub clients to subscribe and publish to
+// channels. It implements pubsub.Authorizer.
+//
+// Clients with the master key are always authorized. Otherwise:
+//
+//   1. A user channel `_user_<user id>` is authorized only for the user.
+//   2. A subscription channel `_sub_<device id>` can only be subscribed
+//      by the owner of the device. Notices are only published by the
+//      server.
+//   3. Other channels are authorized by the channel rule matching the
+//      channel, or authorized for all clients if no rules match.
+//   4. A presence channel `<channel>/presence` can be subscribed if the
+//      channel can be subscribed. Presence events are only published by
+//      the server.
+//   5. The authorized client is further checked by the beforeSubscribe
+//      and beforePublish hooks of the channel registered by plugins.
+type PubSubAuthorizer struct {
+	ConnOpener   func() (skydb.Conn, error)
+	HookRegistry *hook.Registry
+}
+
+// AuthorizeSubscribe implements pubsub.Authorizer.
+func (a *PubSubAuthorizer) AuthorizeSubscribe(client pubsub.Client, channel string) error {
+	if err := a.authorize(client, channel, false); err != nil {
+		return err
+	}
+	return a.executeHooks(client, hook.BeforeSubscribe, channel, nil)
+}
+
+// AuthorizePublish implements pubsub.Authorizer.
+func (a *PubSubAuthorizer) AuthorizePublish(client pubsub.Client, channel string, data []byte) error {
+	if err := a.authorize(client, channel, true); err != nil {
+		return err
+	}
+	return a.executeHooks(client, hook.BeforePublish, channel, data)
+}
+
+func (a *PubSubAuthorizer) authorize(client pubsub.Client, channel string, publish bool) skyerr.Error {
+	if client.MasterKey {
+		return nil
+	}
+
+	if parent, ok := pubsub.ParsePresenceChannel(channel); ok {
+		if publish {
+			return channelPermissionDenied(channel)
+		}
+		return a.authorize(client, parent, false)
+	}
+
+	if strings.HasPrefix(channel, userChannelPrefix) {
+		userID := strings.TrimPrefix(channel, userChannelPrefix)
+		if client.UserInfo == nil || client.UserInfo.ID != userID {
+			return channelPermissionDenied(channel)
+		}
+		return nil
+	}
+
+	conn, err := a.ConnOpener()
+	if err != nil {
+		return skyerr.MakeError(err)
+	}
+	defer conn.Close()
+
+	if strings.HasPrefix(channel, subscriptionChannelPrefix) {
+		if publish || client.UserInfo == nil {
+			return channelPermissionDenied(channel)
+		}
+
+		device := skydb.Device{}
+		deviceID := strings.TrimPrefix(channel, subscriptionChannelPrefix)
+		if err := conn.GetDevice(deviceID, &device); err == skydb.ErrDeviceNotFound {
+			return channelPermissionDenied(channel)
+		} else if err != nil {
+			return skyerr.MakeError(err)
+		}
+		if device.UserInfoID != client.UserInfo.ID {
+			return channelPermissionDenied(channel)
+		}
+		return nil
+	}
+
+	rules, err := conn.GetChannelRules()
+	if err != nil {
+		return skyerr.MakeError(err)
+	}
+	rule := skydb.MatchChannelRule(rules, channel)
+	if rule == nil {
+		return nil
+	}
+
+	access := rule.Subscribe
+	if publish {
+		access = rule.Publish
+	}
+	if !channelAccessible(access, client.UserInfo) {
+		return channelPermissionDenied(channel)
+	}
+	return nil
+}
+
+func (a *PubSubAuthorizer) executeHooks(client pubsub.Client, kind hook.Kind, channel string, data []byte) error {
+	if a.HookRegistry == nil {
+		return nil
+	}
+
+	// The plugin context carries the identity of the client as in
+	// requests of other actions.
+	ctx := context.Background()
+	if client.UserInfo != nil {
+		ctx = context.WithValue(ctx, router.UserIDContextKey, client.UserInfo.ID)
+	}
+	accessKeyType := router.ClientAccessKey
+	if client.MasterKey {
+		accessKeyType = router.MasterAccessKey
+	}
+	ctx = context.WithValue(ctx, router.AccessKeyTypeContextKey, accessKeyType)
+
+	if err := a.HookRegistry.ExecuteChannelHooks(ctx, kind, channel, data); err != nil {
+		return err
+	}
+	return nil
+}
+
+func channelAccessible(access skydb.ChannelAccess, userInfo *skydb.UserInfo) bool {
+	switch access.Level {
+	case skydb.ChannelAccessPublic:
+		return true
+	case skydb.ChannelAccessAuthenticated:
+		if userInfo == nil {
+			return false
+		}
+		return len(access.Roles) == 0 || userInfo.HasAnyRoles(access.Roles)
+	default:
+		return false
+	}
+}
+
+func channelPermissionDenied(channel string) skyerr.Error {
+	return skyerr.NewErrorWithInfo(
+		skyerr.PermissionDenied,
+		fmt.Sprintf(`no permission to access channel "%s"`, channel),
+		map[string]interface{}{"channel": channel},
+	)
+}
+
+type channelRuleSavePayload struct {
+	Rule struct {
+		Channel   string              `mapstructure:"channel"`
+		Subscribe skydb.ChannelAccess `mapstructure:"subscribe"`
+		Publish   skydb.ChannelAccess `mapstructure:"publish"`
+	} `mapstructure:"rule"`
+}
+
+func (payload *channelRuleSavePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *channelRuleSavePayload) Validate() skyerr.Error {
+	rule := payload.Rule
+	if rule.Channel == "" {
+		return skyerr.NewInvalidArgument("empty channel", []string{"rule.channel"})
+	}
+	if strings.HasPrefix(rule.Channel, userChannelPrefix) || strings.HasPrefix(rule.Channel, subscriptionChannelPrefix) {
+		return skyerr.NewInvalidArgument(
+			fmt.Sprintf("channel is reserved = %v", rule.Channel),
+			[]string{"rule.channel"},
+		)
+	}
+	if strings.Contains(strings.TrimSuffix(rule.Channel, "*"), "*") {
+		return skyerr.NewInvalidArgument(
+			fmt.Sprintf(`"*" is only allowed at the end of channel = %v`, rule.Channel),
+			[]string{"rule.channel"},
+		)
+	}
+	if err := validateChannelAccess(rule.Subscribe, "rule.subscribe"); err != nil {
+		return err
+	}
+	return validateChannelAccess(rule.Publish, "rule.publish")
+}
+
+func validateChannelAccess(access skydb.ChannelAccess, field string) skyerr.Error {
+	switch access.Level {
+	case skydb.ChannelAccessPublic, skydb.ChannelAccessMasterKey:
+		if len(access.Roles) > 0 {
+			return skyerr.NewInvalidArgument(
+				fmt.Sprintf("roles are not allowed for level = %v", access.Level),
+				[]string{field + ".roles"},
+			)
+		}
+	case skydb.ChannelAccessAuthenticated:
+	default:
+		return skyerr.NewInvalidArgument(
+			fmt.Sprintf("invalid level = %v", access.Level),
+			[]string{field + ".level"},
+		)
+	}
+	return nil
+}
+
+// PubSubRuleSaveHandler creates or replaces the rule declaring the
+// clients allowed to subscribe and publish to pubsub channels.
+//
+// The channel of a rule is either a channel name, or a prefix of channel
+// names followed by "*". The level of subscribe and publish is one of
+// "public", "authenticated" and "master_key". Users of the "authenticated"
+// level can be restricted to users having any of the roles. Channels
+// matching no rules are public.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "pubsub:rule:save",
+//     "master_key": "MASTER_KEY",
+//     "rule": {
+//         "channel": "announcement/*",
+//         "subscribe": {"level": "authenticated"},
+//         "publish": {"level": "authenticated", "roles": ["admin"]}
+//     }
+// }
+// EOF
+type PubSubRuleSaveHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PubSubRuleSaveHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *PubSubRuleSaveHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PubSubRuleSaveHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &channelRuleSavePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	conn := rpayload.DBConn
+	rules, err := conn.GetChannelRules()
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	now := timeNow()
+	rule := skydb.ChannelRule{
+		Channel:   payload.Rule.Channel,
+		Subscribe: payload.Rule.Subscribe,
+		Publish:   payload.Rule.Publish,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, existing := range rules {
+		if existing.Channel == rule.Channel {
+			rule.CreatedAt = existing.CreatedAt
+		}
+	}
+
+	if err := conn.SaveChannelRule(&rule); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	response.Result = rule
+}
+
+// PubSubRuleFetchHandler returns all pubsub channel rules.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "pubsub:rule:fetch",
+//     "master_key": "MASTER_KEY"
+// }
+// EOF
+type PubSubRuleFetchHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PubSubRuleFetchHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *PubSubRuleFetchHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PubSubRuleFetchHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	rules, err := rpayload.DBConn.GetChannelRules()
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	response.Result = rules
+}
+
+type channelRuleDeletePayload struct {
+	Channel string `mapstructure:"channel"`
+}
+
+func (payload *channelRuleDeletePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	if payload.Channel == "" {
+		return skyerr.NewInvalidArgument("empty channel", []string{"channel"})
+	}
+	return nil
+}
+
+// PubSubRuleDeleteHandler deletes a pubsub channel rule.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "pubsub:rule:delete",
+//     "master_key": "MASTER_KEY",
+//     "channel": "announcement/*"
+// }
+// EOF
+type PubSubRuleDeleteHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PubSubRuleDeleteHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *PubSubRuleDeleteHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PubSubRuleDeleteHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &channelRuleDeletePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if err := rpayload.DBConn.DeleteChannelRule(payload.Channel); err == skydb.ErrChannelRuleNotFound {
+		response.Err = skyerr.NewErrorWithInfo(
+			skyerr.ResourceNotFound,
+			fmt.Sprintf(`cannot find channel rule "%s"`, payload.Channel),
+			map[string]interface{}{"channel": payload.Channel},
+		)
+		return
+	} else if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	response.Result = map[string]interface{}{
+		"channel": payload.Channel,
+	}
+}
+
+type channelPresencePayload struct {
+	Channel string `mapstructure:"channel"`
+}
+
+func (payload *channelPresencePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	if payload.Channel == "" {
+		return skyerr.NewInvalidArgument("empty channel", []string{"channel"})
+	}
+	return nil
+}
+
+// PubSubPresenceHandler returns the members of a pubsub channel, which
+// are the connections subscribing to the channel.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "pubsub:presence",
+//     "master_key": "MASTER_KEY",
+//     "channel": "chatroom"
+// }
+// EOF
+type PubSubPresenceHandler struct {
+	Hub           *pubsub.Hub
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	preprocessors []router.Processor
+}
+
+func (h *PubSubPresenceHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+	}
+}
+
+func (h *PubSubPresenceHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PubSubPresenceHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &channelPresencePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if h.Hub == nil {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "pubsub is not available")
+		return
+	}
+
+	response.Result = map[string]interface{}{
+		"channel": payload.Channel,
+		"members": h.Hub.Presence(payload.Channel),
+	}
+}
+
+type channelPublishPayload struct {
+	Channel string      `mapstructure:"channel"`
+	Data    interface{} `mapstructure:"data"`
+}
+
+func (payload *channelPublishPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *channelPublishPayload) Validate() skyerr.Error {
+	if payload.Channel == "" {
+		return skyerr.NewInvalidArgument("empty channel", []string{"channel"})
+	}
+	if _, ok := pubsub.ParsePresenceChannel(payload.Channel); ok {
+		return skyerr.NewInvalidArgument("cannot publish to presence channel", []string{"channel"})
+	}
+	if payload.Data == nil {
+		return skyerr.NewInvalidArgument("empty data", []string{"data"})
+	}
+	return nil
+}
+
+// PubSubPublishHandler publishes a message to a pubsub channel, which is
+// delivered to clients subscribing to the channel as if the message is
+// published over the websocket.
+//
+// The request is authorized as publishing over the websocket with the
+// same access key. With the master key, messages can be published to
+// any channel.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "pubsub:publish",
+//     "master_key": "MASTER_KEY",
+//     "channel": "chatroom",
+//     "data": {"text": "Hello"}
+// }
+// EOF
+type PubSubPublishHandler struct {
+	PubSub        *pubsub.WsPubSub
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PubSubPublishHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.PluginReady,
+	}
+}
+
+func (h *PubSubPublishHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PubSubPublishHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &channelPublishPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if h.PubSub == nil {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "pubsub is not available")
+		return
+	}
+
+	client := pubsub.Client{MasterKey: rpayload.HasMasterKey()}
+	if err := publishChannel(h.PubSub, client, payload); err != nil {
+		response.Err = err
+		return
+	}
+	response.Result = map[string]interface{}{
+		"channel": payload.Channel,
+	}
+}
+
+func publishChannel(pubSub *pubsub.WsPubSub, client pubsub.Client, payload *channelPublishPayload) skyerr.Error {
+	data, err := json.Marshal(payload.Data)
+	if err != nil {
+		return skyerr.NewInvalidArgument("data cannot be encoded", []string{"data"})
+	}
+
+	if err := pubSub.Publish(client, payload.Channel, data); err != nil {
+		return skyerr.MakeError(err)
+	}
+	return nil
+}
+
+// PubSubSSEHandler streams messages of pubsub channels as Server-Sent
+// Events, for clients that cannot connect to the pubsub websocket. The
+// client connects as connecting to the websocket, and subscribes to the
+// channels passed as the channel query parameters, which are authorized
+// as subscribing over the websocket.
+//
+// A reconnecting client resumes from the last event ID passed as the
+// Last-Event-ID header or the last_event_id query parameter.
+//
+// curl -N -H "X-Skygear-Api-Key: API_KEY" \
+//   -H "X-Skygear-Access-Token: ACCESS_TOKEN" \
+//   -H "Last-Event-ID: 42" \
+//   "http://localhost:3000/pubsub/sse?channel=chatroom&channel=news"
+type PubSubSSEHandler struct {
+	PubSub        *pubsub.WsPubSub
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectUser    router.Processor `preprocessor:"inject_user"`
+	preprocessors []router.Processor
+}
+
+func (h *PubSubSSEHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+	}
+}
+
+func (h *PubSubSSEHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PubSubSSEHandler) Handle(payload *router.Payload, response *router.Response) {
+	query := payload.Req.URL.Query()
+	channels := query["channel"]
+	if len(channels) == 0 {
+		response.Err = skyerr.NewInvalidArgument("empty channel", []string{"channel"})
+		return
+	}
+	for _, channel := range channels {
+		if channel == "" {
+			response.Err = skyerr.NewInvalidArgument("empty channel", []string{"channel"})
+			return
+		}
+	}
+
+	var since *uint64
+	lastEventID := payload.Req.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("last_event_id")
+	}
+	if lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			response.Err = skyerr.NewInvalidArgument("invalid last event ID", []string{"last_event_id"})
+			return
+		}
+		since = &id
+	}
+
+	client := pubsub.Client{
+		UserInfo:  payload.UserInfo,
+		MasterKey: payload.HasMasterKey(),
+	}
+	for _, channel := range channels {
+		if err := h.PubSub.AuthorizeSubscribe(client, channel); err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+	}
+
+	writer := response.Writer()
+	if writer == nil {
+		// The response is already written.
+		return
+	}
+
+	h.PubSub.HandleSSE(writer, payload.Req, client, channels, since)
+}
+
+// PubSubPostHandler publishes a message to a pubsub channel over HTTP,
+// for clients that cannot connect to the pubsub websocket. The client
+// connects as connecting to the websocket, and the message is
+// authorized as publishing over the websocket.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -H "X-Skygear-Api-Key: API_KEY" \
+//   -H "X-Skygear-Access-Token: ACCESS_TOKEN" \
+//   -d @- http://localhost:3000/pubsub <<EOF
+// {
+//     "channel": "chatroom",
+//     "data": {"text": "Hello"}
+// }
+// EOF
+type PubSubPostHandler struct {
+	PubSub        *pubsub.WsPubSub
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectUser    router.Processor `preprocessor:"inject_user"`
+	preprocessors []router.Processor
+}
+
+func (h *PubSubPostHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+	}
+}
+
+func (h *PubSubPostHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PubSubPostHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	data := map[string]interface{}{}
+	if err := json.NewDecoder(rpayload.Req.Body).Decode(&data); err != nil {
+		response.Err = skyerr.NewRequestJSONInvalidErr(err)
+		return
+	}
+
+	payload := &channelPublishPayload{}
+	skyErr := payload.Decode(data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	client := pubsub.Client{
+		UserInfo:  rpayload.UserInfo,
+		MasterKey: rpayload.HasMasterKey(),
+	}
+	if err := publishChannel(h.PubSub, client, payload); err != nil {
+		response.Err = err
+		return
+	}
+	response.Result = map[string]interface{}{
+		"channel": payload.Channel,
+	}
 }
@@ -0,0 +1,627 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/pubsub"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type channelRuleConn struct {
+	rules   []skydb.ChannelRule
+	devices map[string]skydb.Device
+	skydb.Conn
+}
+
+func (conn *channelRuleConn) SaveChannelRule(rule *skydb.ChannelRule) error {
+	for i, existing := range conn.rules {
+		if existing.Channel == rule.Channel {
+			conn.rules[i] = *rule
+			return nil
+		}
+	}
+	conn.rules = append(conn.rules, *rule)
+	return nil
+}
+
+func (conn *channelRuleConn) GetChannelRules() ([]skydb.ChannelRule, error) {
+	rules := make([]skydb.ChannelRule, len(conn.rules))
+	copy(rules, conn.rules)
+	return rules, nil
+}
+
+func (conn *channelRuleConn) DeleteChannelRule(channel string) error {
+	for i, existing := range conn.rules {
+		if existing.Channel == channel {
+			conn.rules = append(conn.rules[:i], conn.rules[i+1:]...)
+			return nil
+		}
+	}
+	return skydb.ErrChannelRuleNotFound
+}
+
+func (conn *channelRuleConn) GetDevice(id string, device *skydb.Device) error {
+	d, ok := conn.devices[id]
+	if !ok {
+		return skydb.ErrDeviceNotFound
+	}
+	*device = d
+	return nil
+}
+
+func (conn *channelRuleConn) Close() error {
+	return nil
+}
+
+func TestPubSubAuthorizer(t *testing.T) {
+	Convey("PubSubAuthorizer", t, func() {
+		conn := &channelRuleConn{
+			rules: []skydb.ChannelRule{
+				{
+					Channel:   "announcement/*",
+					Subscribe: skydb.ChannelAccess{Level: skydb.ChannelAccessAuthenticated},
+					Publish:   skydb.ChannelAccess{Level: skydb.ChannelAccessMasterKey},
+				},
+				{
+					Channel:   "staff",
+					Subscribe: skydb.ChannelAccess{Level: skydb.ChannelAccessAuthenticated, Roles: []string{"staff"}},
+					Publish:   skydb.ChannelAccess{Level: skydb.ChannelAccessAuthenticated, Roles: []string{"staff"}},
+				},
+			},
+			devices: map[string]skydb.Device{
+				"device1": {ID: "device1", UserInfoID: "user1"},
+			},
+		}
+		registry := hook.NewRegistry()
+		authorizer := &PubSubAuthorizer{
+			ConnOpener: func() (skydb.Conn, error) {
+				return conn, nil
+			},
+			HookRegistry: registry,
+		}
+
+		anonymous := pubsub.Client{}
+		user1 := pubsub.Client{UserInfo: &skydb.UserInfo{ID: "user1"}}
+		user2 := pubsub.Client{UserInfo: &skydb.UserInfo{ID: "user2", Roles: []string{"staff"}}}
+		master := pubsub.Client{MasterKey: true}
+
+		errorCode := func(err error) skyerr.ErrorCode {
+			if err == nil {
+				return 0
+			}
+			return err.(skyerr.Error).Code()
+		}
+
+		Convey("authorizes user channel for the user", func() {
+			So(authorizer.AuthorizeSubscribe(user1, "_user_user1"), ShouldBeNil)
+			So(authorizer.AuthorizePublish(user1, "_user_user1", []byte(`{}`)), ShouldBeNil)
+			So(errorCode(authorizer.AuthorizeSubscribe(user2, "_user_user1")), ShouldEqual, skyerr.PermissionDenied)
+			So(errorCode(authorizer.AuthorizeSubscribe(anonymous, "_user_user1")), ShouldEqual, skyerr.PermissionDenied)
+			So(authorizer.AuthorizeSubscribe(master, "_user_user1"), ShouldBeNil)
+		})
+
+		Convey("authorizes subscription channel for the owner of the device", func() {
+			So(authorizer.AuthorizeSubscribe(user1, "_sub_device1"), ShouldBeNil)
+			So(errorCode(authorizer.AuthorizeSubscribe(user2, "_sub_device1")), ShouldEqual, skyerr.PermissionDenied)
+			So(errorCode(authorizer.AuthorizeSubscribe(anonymous, "_sub_device1")), ShouldEqual, skyerr.PermissionDenied)
+			So(errorCode(authorizer.AuthorizeSubscribe(user1, "_sub_device2")), ShouldEqual, skyerr.PermissionDenied)
+			So(errorCode(authorizer.AuthorizePublish(user1, "_sub_device1", []byte(`{}`))), ShouldEqual, skyerr.PermissionDenied)
+		})
+
+		Convey("authorizes channel by rules", func() {
+			So(authorizer.AuthorizeSubscribe(user1, "announcement/general"), ShouldBeNil)
+			So(errorCode(authorizer.AuthorizeSubscribe(anonymous, "announcement/general")), ShouldEqual, skyerr.PermissionDenied)
+			So(errorCode(authorizer.AuthorizePublish(user1, "announcement/general", []byte(`{}`))), ShouldEqual, skyerr.PermissionDenied)
+			So(authorizer.AuthorizePublish(master, "announcement/general", []byte(`{}`)), ShouldBeNil)
+
+			So(authorizer.AuthorizePublish(user2, "staff", []byte(`{}`)), ShouldBeNil)
+			So(errorCode(authorizer.AuthorizeSubscribe(user1, "staff")), ShouldEqual, skyerr.PermissionDenied)
+		})
+
+		Convey("authorizes channel without rules for all clients", func() {
+			So(authorizer.AuthorizeSubscribe(anonymous, "chat"), ShouldBeNil)
+			So(authorizer.AuthorizePublish(anonymous, "chat", []byte(`{}`)), ShouldBeNil)
+		})
+
+		Convey("authorizes presence channel as its channel", func() {
+			So(authorizer.AuthorizeSubscribe(user1, "_user_user1/presence"), ShouldBeNil)
+			So(errorCode(authorizer.AuthorizeSubscribe(user2, "_user_user1/presence")), ShouldEqual, skyerr.PermissionDenied)
+			So(errorCode(authorizer.AuthorizeSubscribe(user1, "staff/presence")), ShouldEqual, skyerr.PermissionDenied)
+			So(authorizer.AuthorizeSubscribe(anonymous, "chat/presence"), ShouldBeNil)
+			So(errorCode(authorizer.AuthorizePublish(anonymous, "chat/presence", []byte(`{}`))), ShouldEqual, skyerr.PermissionDenied)
+		})
+
+		Convey("executes plugin hooks of the channel", func() {
+			var hookCtx context.Context
+			var hookData []byte
+			registry.RegisterChannelHook(hook.BeforeSubscribe, "chat/*", func(ctx context.Context, channel string, data []byte) skyerr.Error {
+				hookCtx = ctx
+				if channel == "chat/secret" {
+					return skyerr.NewError(skyerr.PermissionDenied, "not a member")
+				}
+				return nil
+			})
+			registry.RegisterChannelHook(hook.BeforePublish, "chat/*", func(ctx context.Context, channel string, data []byte) skyerr.Error {
+				hookData = data
+				return nil
+			})
+
+			So(authorizer.AuthorizeSubscribe(user1, "chat/lobby"), ShouldBeNil)
+			So(hookCtx.Value(router.UserIDContextKey), ShouldEqual, "user1")
+			So(hookCtx.Value(router.AccessKeyTypeContextKey), ShouldEqual, router.ClientAccessKey)
+
+			err := authorizer.AuthorizeSubscribe(user1, "chat/secret")
+			So(errorCode(err), ShouldEqual, skyerr.PermissionDenied)
+			So(err.(skyerr.Error).Message(), ShouldEqual, "not a member")
+
+			So(authorizer.AuthorizePublish(user1, "chat/lobby", []byte(`{"text":"hi"}`)), ShouldBeNil)
+			So(hookData, ShouldResemble, []byte(`{"text":"hi"}`))
+		})
+
+		Convey("does not execute plugin hooks if rejected by rules", func() {
+			executed := false
+			registry.RegisterChannelHook(hook.BeforeSubscribe, "*", func(ctx context.Context, channel string, data []byte) skyerr.Error {
+				executed = true
+				return nil
+			})
+
+			So(errorCode(authorizer.AuthorizeSubscribe(anonymous, "announcement/general")), ShouldEqual, skyerr.PermissionDenied)
+			So(executed, ShouldBeFalse)
+		})
+	})
+}
+
+func TestPubSubRuleHandlers(t *testing.T) {
+	Convey("PubSubRule handlers", t, func() {
+		conn := &channelRuleConn{}
+		injectConn := func(p *router.Payload) {
+			p.DBConn = conn
+		}
+
+		originalTimeNow := timeNow
+		timeNow = func() time.Time { return time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC) }
+		defer func() {
+			timeNow = originalTimeNow
+		}()
+
+		Convey("saves a rule", func() {
+			r := handlertest.NewSingleRouteRouter(&PubSubRuleSaveHandler{}, injectConn)
+			resp := r.POST(`{
+				"rule": {
+					"channel": "announcement/*",
+					"subscribe": {"level": "authenticated"},
+					"publish": {"level": "authenticated", "roles": ["admin"]}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"channel": "announcement/*",
+		"subscribe": {"level": "authenticated"},
+		"publish": {"level": "authenticated", "roles": ["admin"]},
+		"created_at": "2006-01-02T15:04:05Z",
+		"updated_at": "2006-01-02T15:04:05Z"
+	}
+}`)
+			So(conn.rules, ShouldHaveLength, 1)
+		})
+
+		Convey("replaces a rule and keeps its creation time", func() {
+			conn.rules = []skydb.ChannelRule{
+				{
+					Channel:   "news",
+					Subscribe: skydb.ChannelAccess{Level: skydb.ChannelAccessPublic},
+					Publish:   skydb.ChannelAccess{Level: skydb.ChannelAccessPublic},
+					CreatedAt: time.Date(2005, 1, 2, 15, 4, 5, 0, time.UTC),
+					UpdatedAt: time.Date(2005, 1, 2, 15, 4, 5, 0, time.UTC),
+				},
+			}
+
+			r := handlertest.NewSingleRouteRouter(&PubSubRuleSaveHandler{}, injectConn)
+			r.POST(`{
+				"rule": {
+					"channel": "news",
+					"subscribe": {"level": "public"},
+					"publish": {"level": "master_key"}
+				}
+			}`)
+
+			So(conn.rules, ShouldHaveLength, 1)
+			So(conn.rules[0].Publish.Level, ShouldEqual, skydb.ChannelAccessMasterKey)
+			So(conn.rules[0].CreatedAt, ShouldResemble, time.Date(2005, 1, 2, 15, 4, 5, 0, time.UTC))
+			So(conn.rules[0].UpdatedAt, ShouldResemble, time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC))
+		})
+
+		Convey("rejects invalid rules", func() {
+			r := handlertest.NewSingleRouteRouter(&PubSubRuleSaveHandler{}, injectConn)
+
+			resp := r.POST(`{
+				"rule": {
+					"channel": "_user_user1",
+					"subscribe": {"level": "public"},
+					"publish": {"level": "public"}
+				}
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "channel is reserved = _user_user1",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["rule.channel"]}
+	}
+}`)
+
+			resp = r.POST(`{
+				"rule": {
+					"channel": "chat/*/messages",
+					"subscribe": {"level": "public"},
+					"publish": {"level": "public"}
+				}
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "\"*\" is only allowed at the end of channel = chat/*/messages",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["rule.channel"]}
+	}
+}`)
+
+			resp = r.POST(`{
+				"rule": {
+					"channel": "news",
+					"subscribe": {"level": "everyone"},
+					"publish": {"level": "public"}
+				}
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "invalid level = everyone",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["rule.subscribe.level"]}
+	}
+}`)
+
+			resp = r.POST(`{
+				"rule": {
+					"channel": "news",
+					"subscribe": {"level": "public"},
+					"publish": {"level": "master_key", "roles": ["admin"]}
+				}
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "roles are not allowed for level = master_key",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["rule.publish.roles"]}
+	}
+}`)
+			So(conn.rules, ShouldBeEmpty)
+		})
+
+		Convey("fetches rules", func() {
+			conn.rules = []skydb.ChannelRule{
+				{
+					Channel:   "news",
+					Subscribe: skydb.ChannelAccess{Level: skydb.ChannelAccessPublic},
+					Publish:   skydb.ChannelAccess{Level: skydb.ChannelAccessMasterKey},
+					CreatedAt: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+					UpdatedAt: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+				},
+			}
+
+			r := handlertest.NewSingleRouteRouter(&PubSubRuleFetchHandler{}, injectConn)
+			resp := r.POST(`{}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"channel": "news",
+		"subscribe": {"level": "public"},
+		"publish": {"level": "master_key"},
+		"created_at": "2006-01-02T15:04:05Z",
+		"updated_at": "2006-01-02T15:04:05Z"
+	}]
+}`)
+		})
+
+		Convey("deletes a rule", func() {
+			conn.rules = []skydb.ChannelRule{{Channel: "news"}}
+
+			r := handlertest.NewSingleRouteRouter(&PubSubRuleDeleteHandler{}, injectConn)
+			resp := r.POST(`{"channel": "news"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{"result": {"channel": "news"}}`)
+			So(conn.rules, ShouldBeEmpty)
+
+			resp = r.POST(`{"channel": "news"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 110,
+		"message": "cannot find channel rule \"news\"",
+		"name": "ResourceNotFound",
+		"info": {"channel": "news"}
+	}
+}`)
+		})
+	})
+}
+
+func TestPubSubPresenceHandler(t *testing.T) {
+	Convey("PubSubPresenceHandler", t, func() {
+		hub := pubsub.NewHub()
+		pubsub.NewWsPubsub(hub)
+
+		r := handlertest.NewSingleRouteRouter(&PubSubPresenceHandler{
+			Hub: hub,
+		}, func(p *router.Payload) {})
+
+		Convey("returns members of channel", func() {
+			hub.Broadcast <- pubsub.Parcel{
+				Channel: "chat/presence",
+				Data:    []byte(`{"event": "join", "member": {"id": "conn1", "user_id": "user1"}}`),
+			}
+			for i := 0; i < 10 && len(hub.Presence("chat")) == 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			resp := r.POST(`{"channel": "chat"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"channel": "chat",
+		"members": [{"id": "conn1", "user_id": "user1"}]
+	}
+}`)
+		})
+
+		Convey("returns no members of empty channel", func() {
+			resp := r.POST(`{"channel": "empty"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"channel": "empty",
+		"members": []
+	}
+}`)
+		})
+
+		Convey("rejects empty channel", func() {
+			resp := r.POST(`{}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "empty channel",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["channel"]}
+	}
+}`)
+		})
+	})
+}
+
+// privateChannelAuthorizer authorizes only the user and clients with the
+// master key to access the private channel.
+type privateChannelAuthorizer struct {
+	channel string
+	userID  string
+}
+
+func (a *privateChannelAuthorizer) AuthorizeSubscribe(client pubsub.Client, channel string) error {
+	if channel != a.channel || client.MasterKey {
+		return nil
+	}
+	if client.UserInfo == nil || client.UserInfo.ID != a.userID {
+		return channelPermissionDenied(channel)
+	}
+	return nil
+}
+
+func (a *privateChannelAuthorizer) AuthorizePublish(client pubsub.Client, channel string, data []byte) error {
+	return a.AuthorizeSubscribe(client, channel)
+}
+
+func TestPubSubPublishHandler(t *testing.T) {
+	Convey("PubSubPublishHandler", t, func() {
+		hub := pubsub.NewHub()
+		ws := pubsub.NewWsPubsub(hub)
+		ws.Authorizer = &privateChannelAuthorizer{channel: "private", userID: "user1"}
+
+		masterKey := false
+		r := handlertest.NewSingleRouteRouter(&PubSubPublishHandler{
+			PubSub: ws,
+		}, func(p *router.Payload) {
+			if masterKey {
+				p.AccessKey = router.MasterAccessKey
+			}
+		})
+
+		Convey("publishes message to channel", func() {
+			resp := r.POST(`{"channel": "chat", "data": {"text": "Hello"}}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{"result": {"channel": "chat"}}`)
+		})
+
+		Convey("rejects unauthorized channel", func() {
+			resp := r.POST(`{"channel": "private", "data": {}}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 102,
+		"message": "no permission to access channel \"private\"",
+		"name": "PermissionDenied",
+		"info": {"channel": "private"}
+	}
+}`)
+		})
+
+		Convey("publishes to any channel with master key", func() {
+			masterKey = true
+			resp := r.POST(`{"channel": "private", "data": {}}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{"result": {"channel": "private"}}`)
+		})
+
+		Convey("rejects presence channel", func() {
+			resp := r.POST(`{"channel": "chat/presence", "data": {}}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "cannot publish to presence channel",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["channel"]}
+	}
+}`)
+		})
+
+		Convey("rejects empty data", func() {
+			resp := r.POST(`{"channel": "chat"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "empty data",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["data"]}
+	}
+}`)
+		})
+	})
+}
+
+func TestPubSubSSEHandler(t *testing.T) {
+	Convey("PubSubSSEHandler", t, func() {
+		hub := pubsub.NewHub()
+		ws := pubsub.NewWsPubsub(hub)
+		ws.Authorizer = &privateChannelAuthorizer{channel: "private", userID: "user1"}
+
+		g := handlertest.NewMockGateway("", "/pubsub/sse", []string{"GET"}, &PubSubSSEHandler{
+			PubSub: ws,
+		}, func(p *router.Payload) {
+			p.UserInfo = &skydb.UserInfo{ID: "user2"}
+		})
+
+		get := func(url string, lastEventID string) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest("GET", url, nil)
+			if lastEventID != "" {
+				req.Header.Set("Last-Event-ID", lastEventID)
+			}
+			resp := httptest.NewRecorder()
+			(*router.Gateway)(g).ServeHTTP(resp, req)
+			return resp
+		}
+
+		Convey("rejects request without channel", func() {
+			resp := get("/pubsub/sse", "")
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "empty channel",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["channel"]}
+	}
+}`)
+		})
+
+		Convey("rejects invalid last event ID", func() {
+			resp := get("/pubsub/sse?channel=chat", "invalid")
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "invalid last event ID",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["last_event_id"]}
+	}
+}`)
+		})
+
+		Convey("rejects unauthorized channel", func() {
+			resp := get("/pubsub/sse?channel=chat&channel=private", "")
+			So(resp.Code, ShouldEqual, http.StatusForbidden)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 102,
+		"message": "no permission to access channel \"private\"",
+		"name": "PermissionDenied",
+		"info": {"channel": "private"}
+	}
+}`)
+		})
+	})
+}
+
+func TestPubSubPostHandler(t *testing.T) {
+	Convey("PubSubPostHandler", t, func() {
+		hub := pubsub.NewHub()
+		ws := pubsub.NewWsPubsub(hub)
+		ws.Authorizer = &privateChannelAuthorizer{channel: "private", userID: "user1"}
+
+		var userInfo *skydb.UserInfo
+		g := handlertest.NewMockGateway("", "/pubsub", []string{"POST"}, &PubSubPostHandler{
+			PubSub: ws,
+		}, func(p *router.Payload) {
+			p.UserInfo = userInfo
+		})
+
+		Convey("publishes message to channel", func() {
+			resp := g.Request("POST", `{"channel": "chat", "data": {"text": "Hello"}}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{"result": {"channel": "chat"}}`)
+		})
+
+		Convey("publishes to private channel by authorized user", func() {
+			userInfo = &skydb.UserInfo{ID: "user1"}
+			resp := g.Request("POST", `{"channel": "private", "data": {}}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{"result": {"channel": "private"}}`)
+		})
+
+		Convey("rejects unauthorized channel", func() {
+			userInfo = &skydb.UserInfo{ID: "user2"}
+			resp := g.Request("POST", `{"channel": "private", "data": {}}`)
+			So(resp.Code, ShouldEqual, http.StatusForbidden)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 102,
+		"message": "no permission to access channel \"private\"",
+		"name": "PermissionDenied",
+		"info": {"channel": "private"}
+	}
+}`)
+		})
+
+		Convey("rejects invalid JSON", func() {
+			resp := g.Request("POST", `{"channel": `)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("rejects empty channel", func() {
+			resp := g.Request("POST", `{"data": {}}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "empty channel",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["channel"]}
+	}
+}`)
+		})
+	})
+}
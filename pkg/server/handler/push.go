@@ -17,7 +17,9 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/mitchellh/mapstructure"
 	"github.com/skygeario/skygear-server/pkg/server/push"
 	"github.com/skygeario/skygear-server/pkg/server/router"
@@ -26,22 +28,12 @@ import (
 )
 
 // Remarks: this variable is for mocking in test cases
-var sendPushNotification = func(sender push.Sender, device skydb.Device, m push.Mapper) {
-	go func() {
-		log.Infof("Sending notification to device token = %s", device.Token)
-		err := sender.Send(m, device)
-
-		if err != nil {
-			log.Warnf("Failed to send notification: %v\n", err)
-		} else {
-			log.Infof("Sent notification to device token = %s", device.Token)
-		}
-	}()
-}
+var enqueuePushNotification = push.Enqueue
 
 type sendPushResponseItem struct {
-	id  string
-	err *error
+	id             string
+	notificationID string
+	err            *error
 }
 
 func (e *sendPushResponseItem) MarshalJSON() ([]byte, error) {
@@ -64,14 +56,368 @@ func (e *sendPushResponseItem) MarshalJSON() ([]byte, error) {
 		}{e.id, "error", err.Message(), err.Name(), err.Code(), err.Info()})
 	}
 	return json.Marshal(&struct {
-		ID string `json:"_id"`
-	}{e.id})
+		ID             string `json:"_id"`
+		NotificationID string `json:"notification_id,omitempty"`
+	}{e.id, e.notificationID})
 }
 
-type pushToUserPayload struct {
-	UserIDs      []string               `mapstructure:"user_ids"`
-	Topic        string                 `mapstructure:"topic"`
+// pushContent is the notification of a push action. It is either given
+// in full in Notification, or refers to a template saved with
+// push:template:save which is rendered for each device with Context.
+//
+// Notifications of the same CollapseKey replace each other on a device.
+type pushContent struct {
 	Notification map[string]interface{} `mapstructure:"notification"`
+	TemplateName string                 `mapstructure:"template"`
+	Context      map[string]interface{} `mapstructure:"context"`
+	CollapseKey  string                 `mapstructure:"collapse_key"`
+
+	template *skydb.PushTemplate
+}
+
+func (c *pushContent) Validate() skyerr.Error {
+	if c.Notification == nil && c.TemplateName == "" {
+		return skyerr.NewInvalidArgument("no notification specified", []string{"notification"})
+	}
+	if c.Notification != nil && c.TemplateName != "" {
+		return skyerr.NewInvalidArgument("cannot specify both notification and template", []string{"notification", "template"})
+	}
+	return nil
+}
+
+// loadTemplate fetches the template referred by the content, if any.
+func (c *pushContent) loadTemplate(conn skydb.Conn) skyerr.Error {
+	if c.TemplateName == "" {
+		return nil
+	}
+
+	template := skydb.PushTemplate{}
+	if err := conn.GetPushTemplate(c.TemplateName, &template); err != nil {
+		return pushTemplateError(c.TemplateName, err)
+	}
+	c.template = &template
+	return nil
+}
+
+// references returns whether the content has placeholders referencing
+// a key path under name.
+func (c *pushContent) references(name string) bool {
+	if c.template != nil {
+		return push.LocalizedTemplateReferences(*c.template, name)
+	}
+	return push.TemplateReferences(c.Notification, name)
+}
+
+// enqueue enqueues the content to the devices. Placeholders are rendered
+// with context in addition to the Context of the content. A template is
+// rendered with the localization matching the locale of each device.
+func (c *pushContent) enqueue(conn skydb.Conn, notificationID string, devices []skydb.Device, context map[string]interface{}) error {
+	if c.Context != nil {
+		merged := map[string]interface{}{}
+		for key, value := range c.Context {
+			merged[key] = value
+		}
+		for key, value := range context {
+			merged[key] = value
+		}
+		context = merged
+	}
+
+	if c.template == nil {
+		var mapper push.Mapper = push.MapMapper(c.Notification)
+		if context != nil {
+			mapper = push.TemplateMapper{
+				Notification: c.Notification,
+				Context:      context,
+			}
+		}
+		return enqueuePushNotification(conn, notificationID, devices, push.WithCollapseKey(mapper, c.CollapseKey))
+	}
+
+	for _, group := range push.GroupDevicesByLocale(*c.template, devices) {
+		mapper := push.LocalizedMapper{
+			Template: *c.template,
+			Locale:   group.Locale,
+			Context:  context,
+		}
+		if err := enqueuePushNotification(conn, notificationID, group.Devices, push.WithCollapseKey(mapper, c.CollapseKey)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushOptions specifies when a notification of push:user and push:device
+// is sent.
+//
+// A notification with SendAt in the future is saved and sent by the push
+// scheduler at SendAt. A notification which is not Urgent is deferred to
+// the end of the quiet hours of a user, if the user record specifies
+// quiet hours.
+type pushOptions struct {
+	RawSendAt string `mapstructure:"send_at"`
+	Urgent    bool   `mapstructure:"urgent"`
+
+	sendAt time.Time
+}
+
+func (o *pushOptions) Validate() skyerr.Error {
+	if o.RawSendAt == "" {
+		return nil
+	}
+	sendAt, err := time.Parse(time.RFC3339Nano, o.RawSendAt)
+	if err != nil {
+		return skyerr.NewInvalidArgument("invalid send_at", []string{"send_at"})
+	}
+	o.sendAt = sendAt.UTC()
+	return nil
+}
+
+// scheduled returns whether the notification is to be sent later.
+func (o *pushOptions) scheduled() bool {
+	return o.sendAt.After(timeNow())
+}
+
+// newPushSchedule returns a schedule of the content sent at sendAt. The
+// caller sets the users or devices to send to.
+func newPushSchedule(content *pushContent, options *pushOptions, notificationID string, topic string, sendAt time.Time) skydb.PushSchedule {
+	now := timeNow()
+	return skydb.PushSchedule{
+		ID:             uuidNew(),
+		NotificationID: notificationID,
+		Topic:          topic,
+		Notification:   content.Notification,
+		Template:       content.TemplateName,
+		Context:        content.Context,
+		CollapseKey:    content.CollapseKey,
+		Urgent:         options.Urgent,
+		State:          skydb.PushScheduleScheduled,
+		SendAt:         sendAt,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+}
+
+// deferPush saves a schedule of the content at the end of the quiet hours
+// of the user, if the content is not urgent and the user is in quiet hours
+// now. It returns whether the content is deferred.
+func deferPush(conn skydb.Conn, content *pushContent, options *pushOptions, notificationID string, topic string, userID string, target func(*skydb.PushSchedule)) (bool, error) {
+	if options.Urgent || userID == "" {
+		return false, nil
+	}
+
+	sendAt, ok := push.UserQuietHours(conn, userID).Defer(timeNow())
+	if !ok {
+		return false, nil
+	}
+
+	schedule := newPushSchedule(content, options, notificationID, topic, sendAt)
+	target(&schedule)
+	if err := conn.SavePushSchedule(&schedule); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pushToUsers sends the content to the devices of each user, or defers it
+// to the end of the quiet hours of the user.
+func pushToUsers(conn skydb.Conn, content *pushContent, options *pushOptions, notificationID string, topic string, userIDs []string) []sendPushResponseItem {
+	resultItems := make([]sendPushResponseItem, len(userIDs))
+	for i, userID := range userIDs {
+		resultItems[i].id = userID
+		var devices []skydb.Device
+		var err error
+
+		if topic != "" {
+			devices, err = conn.QueryDevicesByUserAndTopic(userID, topic)
+		} else {
+			devices, err = conn.QueryDevicesByUser(userID)
+		}
+
+		if err != nil {
+			resultItems[i].err = &err
+			continue
+		}
+
+		deferred, err := deferPush(conn, content, options, notificationID, topic, userID, func(schedule *skydb.PushSchedule) {
+			schedule.UserIDs = []string{userID}
+		})
+		if err != nil {
+			resultItems[i].err = &err
+			continue
+		} else if deferred {
+			resultItems[i].notificationID = notificationID
+			continue
+		}
+
+		// FIXME: The deduplication should be done at device register.
+		deviceIDs := map[string]bool{}
+		uniqueDevices := []skydb.Device{}
+		for _, device := range devices {
+			if _, ok := deviceIDs[device.Token]; !ok {
+				deviceIDs[device.Token] = true
+				uniqueDevices = append(uniqueDevices, device)
+			}
+		}
+
+		if err := content.enqueue(conn, notificationID, uniqueDevices, nil); err != nil {
+			resultItems[i].err = &err
+		} else {
+			resultItems[i].notificationID = notificationID
+		}
+	}
+	return resultItems
+}
+
+// pushToDevices sends the content to each device, or defers it to the end
+// of the quiet hours of the owner of the device. Devices not subscribed
+// to topic are skipped.
+func pushToDevices(conn skydb.Conn, content *pushContent, options *pushOptions, notificationID string, topic string, deviceIDs []string) []sendPushResponseItem {
+	resultItems := []sendPushResponseItem{}
+	for _, deviceID := range deviceIDs {
+		device := skydb.Device{}
+		if err := conn.GetDevice(deviceID, &device); err != nil {
+			resultItems = append(resultItems, sendPushResponseItem{
+				id:  deviceID,
+				err: &err,
+			})
+			continue
+		}
+		if topic != "" && topic != device.Topic {
+			continue
+		}
+
+		deferred, err := deferPush(conn, content, options, notificationID, topic, device.UserInfoID, func(schedule *skydb.PushSchedule) {
+			schedule.DeviceIDs = []string{deviceID}
+		})
+		if err == nil && !deferred {
+			err = content.enqueue(conn, notificationID, []skydb.Device{device}, nil)
+		}
+
+		if err != nil {
+			resultItems = append(resultItems, sendPushResponseItem{
+				id:  deviceID,
+				err: &err,
+			})
+		} else {
+			resultItems = append(resultItems, sendPushResponseItem{
+				id:             deviceID,
+				notificationID: notificationID,
+			})
+		}
+	}
+	return resultItems
+}
+
+// schedulePush saves the content to be sent by the push scheduler at
+// SendAt of the options, and returns the response items of the targets.
+func schedulePush(conn skydb.Conn, content *pushContent, options *pushOptions, notificationID string, topic string, userIDs []string, deviceIDs []string) ([]sendPushResponseItem, skyerr.Error) {
+	schedule := newPushSchedule(content, options, notificationID, topic, options.sendAt)
+	schedule.UserIDs = userIDs
+	schedule.DeviceIDs = deviceIDs
+	if err := conn.SavePushSchedule(&schedule); err != nil {
+		return nil, skyerr.MakeError(err)
+	}
+
+	ids := append(append([]string{}, userIDs...), deviceIDs...)
+	resultItems := make([]sendPushResponseItem, len(ids))
+	for i, id := range ids {
+		resultItems[i] = sendPushResponseItem{
+			id:             id,
+			notificationID: notificationID,
+		}
+	}
+	return resultItems, nil
+}
+
+// DispatchPushSchedule sends a scheduled notification when it is due. It
+// is the dispatcher of the push scheduler.
+//
+// A schedule of multiple users or devices is expanded into a schedule of
+// each of them, which is then dispatched on its own. A schedule retried
+// after a failure is therefore never sent to the others again.
+//
+// Users in quiet hours are deferred again unless the notification is
+// urgent. A schedule of a template which has been deleted is dropped.
+func DispatchPushSchedule(conn skydb.Conn, schedule skydb.PushSchedule) error {
+	content := pushContent{
+		Notification: schedule.Notification,
+		TemplateName: schedule.Template,
+		Context:      schedule.Context,
+		CollapseKey:  schedule.CollapseKey,
+	}
+	options := pushOptions{
+		Urgent: schedule.Urgent,
+	}
+
+	if err := content.loadTemplate(conn); err != nil {
+		if err.Code() == skyerr.ResourceNotFound {
+			log.WithFields(logrus.Fields{
+				"notificationID": schedule.NotificationID,
+				"template":       schedule.Template,
+			}).Warn("push: dropped schedule of deleted template")
+			return nil
+		}
+		return err
+	}
+
+	if len(schedule.UserIDs)+len(schedule.DeviceIDs) > 1 {
+		return expandPushSchedule(conn, schedule)
+	}
+
+	var resultItems []sendPushResponseItem
+	if len(schedule.UserIDs) > 0 {
+		resultItems = pushToUsers(conn, &content, &options, schedule.NotificationID, schedule.Topic, schedule.UserIDs)
+	} else {
+		resultItems = pushToDevices(conn, &content, &options, schedule.NotificationID, schedule.Topic, schedule.DeviceIDs)
+	}
+
+	for _, item := range resultItems {
+		if item.err == nil {
+			continue
+		}
+		err := *item.err
+		if err == skydb.ErrUserNotFound || err == skydb.ErrDeviceNotFound {
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+// expandPushSchedule saves a schedule of each user or device of the
+// schedule, which is due at the same time.
+func expandPushSchedule(conn skydb.Conn, schedule skydb.PushSchedule) error {
+	now := timeNow()
+	expand := func(userIDs []string, deviceIDs []string) error {
+		expanded := schedule
+		expanded.ID = uuidNew()
+		expanded.UserIDs = userIDs
+		expanded.DeviceIDs = deviceIDs
+		expanded.State = skydb.PushScheduleScheduled
+		expanded.CreatedAt = now
+		expanded.UpdatedAt = now
+		return conn.SavePushSchedule(&expanded)
+	}
+
+	for _, userID := range schedule.UserIDs {
+		if err := expand([]string{userID}, nil); err != nil {
+			return err
+		}
+	}
+	for _, deviceID := range schedule.DeviceIDs {
+		if err := expand(nil, []string{deviceID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type pushToUserPayload struct {
+	UserIDs     []string `mapstructure:"user_ids"`
+	Topic       string   `mapstructure:"topic"`
+	pushContent `mapstructure:",squash"`
+	pushOptions `mapstructure:",squash"`
 }
 
 func (payload *pushToUserPayload) Decode(data map[string]interface{}) skyerr.Error {
@@ -85,20 +431,50 @@ func (payload *pushToUserPayload) Validate() skyerr.Error {
 	if len(payload.UserIDs) == 0 {
 		return skyerr.NewInvalidArgument("empty user ids", []string{"user_ids"})
 	}
-	if payload.Notification == nil {
-		return skyerr.NewInvalidArgument("no notification specified", []string{"notification"})
+	if err := payload.pushOptions.Validate(); err != nil {
+		return err
 	}
-	return nil
+	return payload.pushContent.Validate()
 }
 
+// PushToUserHandler sends a notification to the devices of users.
+//
+// The notification is sent later if "send_at" is specified, and can be
+// revoked with push:cancel before then. A notification which is not
+// "urgent" is deferred to the end of the quiet hours of a user.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "push:user",
+//     "api_key": "API_KEY",
+//     "access_token": "ACCESS_TOKEN",
+//     "user_ids": ["johndoe"],
+//     "send_at": "2006-01-02T15:04:05Z",
+//     "collapse_key": "score",
+//     "notification": {
+//         "apns": {
+//             "aps": {
+//                 "alert": "The score is 1:0."
+//             }
+//         }
+//     }
+// }
+// EOF
+//
+// {
+//     "result": [{
+//         "_id": "johndoe",
+//         "notification_id": "6d5e4c6b-0b0f-4b8c-9b6a-3e7f2f1f3c2a"
+//     }]
+// }
 type PushToUserHandler struct {
-	NotificationSender push.Sender      `inject:"PushSender"`
-	AccessKey          router.Processor `preprocessor:"accesskey"`
-	DBConn             router.Processor `preprocessor:"dbconn"`
-	InjectDB           router.Processor `preprocessor:"inject_db"`
-	Notification       router.Processor `preprocessor:"notification"`
-	PluginReady        router.Processor `preprocessor:"plugin_ready"`
-	preprocessors      []router.Processor
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	Notification  router.Processor `preprocessor:"notification"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
 }
 
 func (h *PushToUserHandler) Setup() {
@@ -124,40 +500,31 @@ func (h *PushToUserHandler) Handle(rpayload *router.Payload, response *router.Re
 	}
 
 	conn := rpayload.DBConn
-	resultItems := make([]sendPushResponseItem, len(payload.UserIDs))
-	for i, userID := range payload.UserIDs {
-		resultItems[i].id = userID
-		var devices []skydb.Device
-		var err error
+	if skyErr := payload.loadTemplate(conn); skyErr != nil {
+		response.Err = skyErr
+		return
+	}
 
-		if payload.Topic != "" {
-			devices, err = conn.QueryDevicesByUserAndTopic(userID, payload.Topic)
-		} else {
-			devices, err = conn.QueryDevicesByUser(userID)
-		}
-
-		if err != nil {
-			resultItems[i].err = &err
-		} else {
-			// FIXME: The deduplication should be done at device register.
-			deviceIDs := map[string]bool{}
-			for i := range devices {
-				device := devices[i]
-				if _, ok := deviceIDs[device.Token]; !ok {
-					deviceIDs[device.Token] = true
-					pushMap := push.MapMapper(payload.Notification)
-					sendPushNotification(h.NotificationSender, device, pushMap)
-				}
-			}
+	notificationID := uuidNew()
+	if payload.scheduled() {
+		resultItems, skyErr := schedulePush(conn, &payload.pushContent, &payload.pushOptions, notificationID, payload.Topic, payload.UserIDs, nil)
+		if skyErr != nil {
+			response.Err = skyErr
+			return
 		}
+		response.Result = resultItems
+		return
 	}
+
+	resultItems := pushToUsers(conn, &payload.pushContent, &payload.pushOptions, notificationID, payload.Topic, payload.UserIDs)
 	response.Result = resultItems
 }
 
 type pushToDevicePayload struct {
-	DeviceIDs    []string               `mapstructure:"device_ids"`
-	Topic        string                 `mapstructure:"topic"`
-	Notification map[string]interface{} `mapstructure:"notification"`
+	DeviceIDs   []string `mapstructure:"device_ids"`
+	Topic       string   `mapstructure:"topic"`
+	pushContent `mapstructure:",squash"`
+	pushOptions `mapstructure:",squash"`
 }
 
 func (payload *pushToDevicePayload) Decode(data map[string]interface{}) skyerr.Error {
@@ -171,20 +538,19 @@ func (payload *pushToDevicePayload) Validate() skyerr.Error {
 	if len(payload.DeviceIDs) == 0 {
 		return skyerr.NewInvalidArgument("empty device ids", []string{"device_ids"})
 	}
-	if payload.Notification == nil {
-		return skyerr.NewInvalidArgument("no notification specified", []string{"notification"})
+	if err := payload.pushOptions.Validate(); err != nil {
+		return err
 	}
-	return nil
+	return payload.pushContent.Validate()
 }
 
 type PushToDeviceHandler struct {
-	NotificationSender push.Sender      `inject:"PushSender"`
-	AccessKey          router.Processor `preprocessor:"accesskey"`
-	DBConn             router.Processor `preprocessor:"dbconn"`
-	InjectDB           router.Processor `preprocessor:"inject_db"`
-	Notification       router.Processor `preprocessor:"notification"`
-	PluginReady        router.Processor `preprocessor:"plugin_ready"`
-	preprocessors      []router.Processor
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	Notification  router.Processor `preprocessor:"notification"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
 }
 
 func (h *PushToDeviceHandler) Setup() {
@@ -210,21 +576,709 @@ func (h *PushToDeviceHandler) Handle(rpayload *router.Payload, response *router.
 	}
 
 	conn := rpayload.DBConn
-	resultItems := []sendPushResponseItem{}
-	for _, deviceID := range payload.DeviceIDs {
-		device := skydb.Device{}
-		if err := conn.GetDevice(deviceID, &device); err != nil {
-			resultItems = append(resultItems, sendPushResponseItem{
-				id:  deviceID,
-				err: &err,
-			})
-		} else if payload.Topic == "" || payload.Topic == device.Topic {
-			pushMap := push.MapMapper(payload.Notification)
-			sendPushNotification(h.NotificationSender, device, pushMap)
-			resultItems = append(resultItems, sendPushResponseItem{
-				id: deviceID,
-			})
+	if skyErr := payload.loadTemplate(conn); skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	notificationID := uuidNew()
+	if payload.scheduled() {
+		resultItems, skyErr := schedulePush(conn, &payload.pushContent, &payload.pushOptions, notificationID, payload.Topic, nil, payload.DeviceIDs)
+		if skyErr != nil {
+			response.Err = skyErr
+			return
 		}
+		response.Result = resultItems
+		return
 	}
+
+	resultItems := pushToDevices(conn, &payload.pushContent, &payload.pushOptions, notificationID, payload.Topic, payload.DeviceIDs)
 	response.Result = resultItems
 }
+
+// pushQueryBatchSize is the number of records fetched at a time when
+// resolving the audience of push:query.
+var pushQueryBatchSize uint64 = 100
+
+type pushToQueryPayload struct {
+	Query       skydb.Query `mapstructure:"-"`
+	Topic       string      `mapstructure:"topic"`
+	pushContent `mapstructure:",squash"`
+}
+
+func (payload *pushToQueryPayload) Decode(data map[string]interface{}, parser *QueryParser) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+
+	rawQuery, ok := data["query"].(map[string]interface{})
+	if !ok {
+		return skyerr.NewInvalidArgument("no query specified", []string{"query"})
+	}
+	if err := parser.queryFromRaw(rawQuery, &payload.Query); err != nil {
+		return err
+	}
+	return payload.Validate()
+}
+
+func (payload *pushToQueryPayload) Validate() skyerr.Error {
+	return payload.pushContent.Validate()
+}
+
+type pushToQueryResponse struct {
+	NotificationID string `json:"notification_id"`
+	UserCount      int    `json:"user_count"`
+	DeviceCount    int    `json:"device_count"`
+}
+
+// PushToQueryHandler sends a notification to the users matching a
+// record query.
+//
+// The query is made over the user record type, or over any record type
+// in which case the owners of the matched records receive the
+// notification. String values of the notification may contain
+// placeholders like `{{user.username}}` or `{{record.name}}`, which are
+// rendered for each user with the user and the first matched record.
+// The same applies to a template specified with "template" in place of
+// the notification.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "push:query",
+//     "master_key": "MASTER_KEY",
+//     "query": {
+//         "record_type": "user",
+//         "predicate": [
+//             "eq",
+//             {"$type": "keypath", "$val": "city"},
+//             "Hong Kong"
+//         ]
+//     },
+//     "notification": {
+//         "apns": {
+//             "aps": {
+//                 "alert": "Hi {{user.username}}, the sale in {{record.city}} begins now!"
+//             }
+//         }
+//     }
+// }
+// EOF
+//
+// {
+//     "result": {
+//         "notification_id": "6d5e4c6b-0b0f-4b8c-9b6a-3e7f2f1f3c2a",
+//         "user_count": 2,
+//         "device_count": 3
+//     }
+// }
+type PushToQueryHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	Notification  router.Processor `preprocessor:"notification"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PushToQueryHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.Notification,
+		h.PluginReady,
+	}
+}
+
+func (h *PushToQueryHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PushToQueryHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &pushToQueryPayload{}
+	parser := QueryParser{UserID: rpayload.UserInfoID}
+	skyErr := payload.Decode(rpayload.Data, &parser)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	conn := rpayload.DBConn
+	if skyErr := payload.loadTemplate(conn); skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := rpayload.Database
+	query := payload.Query
+	query.BypassAccessControl = true
+	query.GetCount = false
+	// sort by id so that paging over the records is stable
+	query.Sorts = append(query.Sorts, skydb.Sort{
+		KeyPath: "_id",
+		Order:   skydb.Asc,
+	})
+
+	var remaining *uint64
+	if payload.Query.Limit != nil {
+		remaining = new(uint64)
+		*remaining = *payload.Query.Limit
+	}
+
+	fetchUser := payload.references("user")
+	result := pushToQueryResponse{
+		NotificationID: uuidNew(),
+	}
+	sentUsers := map[string]bool{}
+	for remaining == nil || *remaining > 0 {
+		limit := pushQueryBatchSize
+		if remaining != nil && *remaining < limit {
+			limit = *remaining
+		}
+		query.Limit = &limit
+
+		records, err := queryRecords(db, &query)
+		if err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+
+		for _, record := range records {
+			userID := record.OwnerID
+			if query.Type == db.UserRecordType() {
+				userID = record.ID.Key
+			}
+			if userID == "" || sentUsers[userID] {
+				continue
+			}
+			sentUsers[userID] = true
+
+			deviceCount, err := h.pushToUser(conn, userID, &record, payload, result.NotificationID, fetchUser)
+			if err != nil {
+				response.Err = skyerr.MakeError(err)
+				return
+			}
+			if deviceCount > 0 {
+				result.UserCount++
+				result.DeviceCount += deviceCount
+			}
+		}
+
+		if uint64(len(records)) < limit {
+			break
+		}
+		query.Offset += limit
+		if remaining != nil {
+			*remaining -= limit
+		}
+	}
+
+	response.Result = result
+}
+
+// pushToUser enqueues the notification rendered for the user to the
+// devices of the user. It returns the number of devices enqueued.
+func (h *PushToQueryHandler) pushToUser(conn skydb.Conn, userID string, record *skydb.Record, payload *pushToQueryPayload, notificationID string, fetchUser bool) (int, error) {
+	var devices []skydb.Device
+	var err error
+	if payload.Topic != "" {
+		devices, err = conn.QueryDevicesByUserAndTopic(userID, payload.Topic)
+	} else {
+		devices, err = conn.QueryDevicesByUser(userID)
+	}
+	if err == skydb.ErrUserNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if len(devices) == 0 {
+		return 0, nil
+	}
+
+	recordData := map[string]interface{}{}
+	for key, value := range record.Data {
+		recordData[key] = value
+	}
+	recordData["_id"] = record.ID.Key
+	context := map[string]interface{}{
+		"record": recordData,
+	}
+
+	if fetchUser {
+		userinfo := skydb.UserInfo{}
+		if err := conn.GetUser(userID, &userinfo); err == skydb.ErrUserNotFound {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		context["user"] = map[string]interface{}{
+			"_id":      userinfo.ID,
+			"username": userinfo.Username,
+			"email":    userinfo.Email,
+		}
+	}
+
+	// FIXME: The deduplication should be done at device register.
+	deviceTokens := map[string]bool{}
+	uniqueDevices := []skydb.Device{}
+	for _, device := range devices {
+		if !deviceTokens[device.Token] {
+			deviceTokens[device.Token] = true
+			uniqueDevices = append(uniqueDevices, device)
+		}
+	}
+
+	if err := payload.enqueue(conn, notificationID, uniqueDevices, context); err != nil {
+		return 0, err
+	}
+	return len(uniqueDevices), nil
+}
+
+func queryRecords(db skydb.Database, query *skydb.Query) ([]skydb.Record, error) {
+	results, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	records := []skydb.Record{}
+	for results.Scan() {
+		records = append(records, results.Record())
+	}
+	return records, results.Err()
+}
+
+// pushTopicBatchSize is the number of devices loaded at a time when
+// sending to a topic.
+var pushTopicBatchSize = 1000
+
+type pushToTopicPayload struct {
+	SubscriptionTopic string `mapstructure:"subscription_topic"`
+	pushContent       `mapstructure:",squash"`
+}
+
+func (payload *pushToTopicPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *pushToTopicPayload) Validate() skyerr.Error {
+	if payload.SubscriptionTopic == "" {
+		return skyerr.NewInvalidArgument("empty subscription topic", []string{"subscription_topic"})
+	}
+	return payload.pushContent.Validate()
+}
+
+type pushToTopicResponse struct {
+	NotificationID string `json:"notification_id"`
+	DeviceCount    int    `json:"device_count"`
+}
+
+// PushToTopicHandler sends a notification to every device subscribed to
+// a topic with device:subscribe_topic.
+//
+// The topic is given as `subscription_topic`, which is unrelated to the
+// `topic` of push:user and push:device matching the APNS topic of devices.
+//
+// Devices are loaded and queued page by page, so that sending to a
+// topic with many subscribers does not load all devices into memory.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "push:topic",
+//     "master_key": "MASTER_KEY",
+//     "subscription_topic": "breaking-news",
+//     "notification": {
+//         "apns": {
+//             "aps": {
+//                 "alert": "Breaking news!"
+//             }
+//         }
+//     }
+// }
+// EOF
+//
+// {
+//     "result": {
+//         "notification_id": "6d5e4c6b-0b0f-4b8c-9b6a-3e7f2f1f3c2a",
+//         "device_count": 512034
+//     }
+// }
+type PushToTopicHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	Notification  router.Processor `preprocessor:"notification"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PushToTopicHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.Notification,
+		h.PluginReady,
+	}
+}
+
+func (h *PushToTopicHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PushToTopicHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &pushToTopicPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	conn := rpayload.DBConn
+	if skyErr := payload.loadTemplate(conn); skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	result := pushToTopicResponse{
+		NotificationID: uuidNew(),
+	}
+	afterID := ""
+	for {
+		devices, err := conn.QueryDevicesByTopic(payload.SubscriptionTopic, afterID, pushTopicBatchSize)
+		if err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+		if len(devices) == 0 {
+			break
+		}
+
+		if err := payload.enqueue(conn, result.NotificationID, devices, nil); err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+		result.DeviceCount += len(devices)
+
+		if len(devices) < pushTopicBatchSize {
+			break
+		}
+		afterID = devices[len(devices)-1].ID
+	}
+
+	response.Result = result
+}
+
+const (
+	defaultPushStatusLimit = 100
+	maxPushStatusLimit     = 1000
+)
+
+type pushStatusPayload struct {
+	NotificationID string `mapstructure:"notification_id"`
+	After          string `mapstructure:"after"`
+	Limit          int    `mapstructure:"limit"`
+}
+
+func (payload *pushStatusPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *pushStatusPayload) Validate() skyerr.Error {
+	if payload.NotificationID == "" {
+		return skyerr.NewInvalidArgument("empty notification id", []string{"notification_id"})
+	}
+
+	if payload.Limit < 0 || payload.Limit > maxPushStatusLimit {
+		return skyerr.NewInvalidArgument(
+			fmt.Sprintf("limit must be between 1 and %d", maxPushStatusLimit),
+			[]string{"limit"},
+		)
+	} else if payload.Limit == 0 {
+		payload.Limit = defaultPushStatusLimit
+	}
+	return nil
+}
+
+type pushDeliveryItem struct {
+	ID         string    `json:"id"`
+	DeviceID   string    `json:"device_id"`
+	DeviceType string    `json:"device_type"`
+	State      string    `json:"state"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type pushScheduleItem struct {
+	UserIDs   []string  `json:"user_ids,omitempty"`
+	DeviceIDs []string  `json:"device_ids,omitempty"`
+	State     string    `json:"state"`
+	SendAt    time.Time `json:"send_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type pushStatusResponse struct {
+	NotificationID string             `json:"notification_id"`
+	Counts         map[string]int     `json:"counts"`
+	Deliveries     []pushDeliveryItem `json:"deliveries"`
+	HasMore        bool               `json:"has_more"`
+	Schedules      []pushScheduleItem `json:"schedules,omitempty"`
+}
+
+// PushStatusHandler returns the delivery results of a notification sent
+// by push:user or push:device. The schedules of a notification which is
+// scheduled or deferred by quiet hours are also returned.
+//
+// The number of deliveries in each state is returned together with up to
+// `limit` deliveries, ordered by ID. If `has_more` is true, pass the ID
+// of the last returned delivery as `after` to get the next page.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "push:status",
+//     "master_key": "MASTER_KEY",
+//     "notification_id": "6d5e4c6b-0b0f-4b8c-9b6a-3e7f2f1f3c2a",
+//     "limit": 100
+// }
+// EOF
+//
+// {
+//     "result": {
+//         "notification_id": "6d5e4c6b-0b0f-4b8c-9b6a-3e7f2f1f3c2a",
+//         "counts": {
+//             "queued": 0,
+//             "sent": 1,
+//             "failed": 0,
+//             "token-invalid": 1
+//         },
+//         "deliveries": [{
+//             "id": "0b6e2f3a-6a0c-4f0e-8a4e-2f3c8e1d9b71",
+//             "device_id": "device1",
+//             "device_type": "ios",
+//             "state": "sent",
+//             "attempts": 1,
+//             "created_at": "2006-01-02T15:04:05Z",
+//             "updated_at": "2006-01-02T15:04:06Z"
+//         }, {
+//             "id": "9c1d7e4b-3f2a-4b6d-a1e8-5d2c7b9f0e34",
+//             "device_id": "device2",
+//             "device_type": "web",
+//             "state": "token-invalid",
+//             "attempts": 1,
+//             "last_error": "push: device token is no longer valid",
+//             "created_at": "2006-01-02T15:04:05Z",
+//             "updated_at": "2006-01-02T15:04:06Z"
+//         }],
+//         "has_more": false,
+//         "schedules": [{
+//             "user_ids": ["johndoe"],
+//             "state": "scheduled",
+//             "send_at": "2006-01-02T23:00:00Z",
+//             "created_at": "2006-01-02T15:04:05Z",
+//             "updated_at": "2006-01-02T15:04:05Z"
+//         }]
+//     }
+// }
+type PushStatusHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PushStatusHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *PushStatusHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PushStatusHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &pushStatusPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	conn := rpayload.DBConn
+	counts, err := conn.CountPushDeliveries(payload.NotificationID)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	schedules, err := conn.GetPushSchedules(payload.NotificationID)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	if len(counts) == 0 && len(schedules) == 0 {
+		response.Err = skyerr.NewErrorWithInfo(
+			skyerr.ResourceNotFound,
+			fmt.Sprintf(`cannot find notification "%s"`, payload.NotificationID),
+			map[string]interface{}{"id": payload.NotificationID},
+		)
+		return
+	}
+
+	deliveries, err := conn.GetPushDeliveries(payload.NotificationID, payload.After, payload.Limit+1)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	hasMore := len(deliveries) > payload.Limit
+	if hasMore {
+		deliveries = deliveries[:payload.Limit]
+	}
+
+	result := pushStatusResponse{
+		NotificationID: payload.NotificationID,
+		Counts: map[string]int{
+			string(skydb.PushDeliveryQueued):       0,
+			string(skydb.PushDeliverySent):         0,
+			string(skydb.PushDeliveryFailed):       0,
+			string(skydb.PushDeliveryTokenInvalid): 0,
+		},
+		Deliveries: make([]pushDeliveryItem, len(deliveries)),
+		HasMore:    hasMore,
+	}
+	for state, count := range counts {
+		result.Counts[string(state)] = count
+	}
+	for i, delivery := range deliveries {
+		result.Deliveries[i] = pushDeliveryItem{
+			ID:         delivery.ID,
+			DeviceID:   delivery.DeviceID,
+			DeviceType: delivery.DeviceType,
+			State:      string(delivery.State),
+			Attempts:   delivery.Attempts,
+			LastError:  delivery.LastError,
+			CreatedAt:  delivery.CreatedAt,
+			UpdatedAt:  delivery.UpdatedAt,
+		}
+	}
+	for _, schedule := range schedules {
+		result.Schedules = append(result.Schedules, pushScheduleItem{
+			UserIDs:   schedule.UserIDs,
+			DeviceIDs: schedule.DeviceIDs,
+			State:     string(schedule.State),
+			SendAt:    schedule.SendAt,
+			CreatedAt: schedule.CreatedAt,
+			UpdatedAt: schedule.UpdatedAt,
+		})
+	}
+	response.Result = result
+}
+
+type pushCancelPayload struct {
+	NotificationID string `mapstructure:"notification_id"`
+}
+
+func (payload *pushCancelPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *pushCancelPayload) Validate() skyerr.Error {
+	if payload.NotificationID == "" {
+		return skyerr.NewInvalidArgument("empty notification id", []string{"notification_id"})
+	}
+	return nil
+}
+
+type pushCancelResponse struct {
+	NotificationID string `json:"notification_id"`
+	Cancelled      int    `json:"cancelled"`
+}
+
+// PushCancelHandler revokes a notification scheduled with send_at, or
+// deferred by quiet hours, which has not been sent yet. Deliveries already
+// in the push queue are not affected.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "push:cancel",
+//     "master_key": "MASTER_KEY",
+//     "notification_id": "6d5e4c6b-0b0f-4b8c-9b6a-3e7f2f1f3c2a"
+// }
+// EOF
+//
+// {
+//     "result": {
+//         "notification_id": "6d5e4c6b-0b0f-4b8c-9b6a-3e7f2f1f3c2a",
+//         "cancelled": 1
+//     }
+// }
+type PushCancelHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PushCancelHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *PushCancelHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PushCancelHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &pushCancelPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	cancelled, err := rpayload.DBConn.CancelPushSchedules(payload.NotificationID, timeNow())
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	if cancelled == 0 {
+		response.Err = skyerr.NewErrorWithInfo(
+			skyerr.ResourceNotFound,
+			fmt.Sprintf(`cannot find scheduled notification "%s"`, payload.NotificationID),
+			map[string]interface{}{"id": payload.NotificationID},
+		)
+		return
+	}
+
+	response.Result = pushCancelResponse{
+		NotificationID: payload.NotificationID,
+		Cancelled:      cancelled,
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+var pushTemplateNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+type pushTemplateSavePayload struct {
+	Template struct {
+		Name          string                                    `mapstructure:"name"`
+		DefaultLocale string                                    `mapstructure:"default_locale"`
+		Localizations map[string]skydb.PushTemplateLocalization `mapstructure:"localizations"`
+		Sound         string                                    `mapstructure:"sound"`
+		Badge         interface{}                               `mapstructure:"badge"`
+		Data          map[string]interface{}                    `mapstructure:"data"`
+	} `mapstructure:"template"`
+}
+
+func (payload *pushTemplateSavePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *pushTemplateSavePayload) Validate() skyerr.Error {
+	template := payload.Template
+	if !pushTemplateNameRegexp.MatchString(template.Name) {
+		return skyerr.NewInvalidArgument(fmt.Sprintf("invalid template name = %v", template.Name), []string{"template.name"})
+	}
+	if len(template.Localizations) == 0 {
+		return skyerr.NewInvalidArgument("empty localizations", []string{"template.localizations"})
+	}
+	if _, ok := template.Localizations[template.DefaultLocale]; !ok {
+		return skyerr.NewInvalidArgument("default locale has no localization", []string{"template.default_locale"})
+	}
+	for locale, l := range template.Localizations {
+		if l.Title == "" && l.Body == "" && l.TitleLocKey == "" && l.BodyLocKey == "" {
+			return skyerr.NewInvalidArgument(fmt.Sprintf("empty localization = %v", locale), []string{"template.localizations"})
+		}
+	}
+	switch badge := template.Badge.(type) {
+	case nil, float64, string:
+	default:
+		return skyerr.NewInvalidArgument(fmt.Sprintf("invalid badge = %v", badge), []string{"template.badge"})
+	}
+	return nil
+}
+
+// PushTemplateSaveHandler creates or replaces a push notification
+// template.
+//
+// A template has a localization for each supported locale. It is
+// rendered for each device with the localization best matching the
+// locale of the device, falling back to the default locale. Placeholders
+// like `{{user.username}}` are rendered with the context of the push
+// action, and localization keys are passed to APNS and GCM to be
+// resolved by the app.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "push:template:save",
+//     "master_key": "MASTER_KEY",
+//     "template": {
+//         "name": "new-comment",
+//         "default_locale": "en",
+//         "localizations": {
+//             "en": {
+//                 "title": "New comment",
+//                 "body": "{{user.username}} commented on your post"
+//             },
+//             "zh-Hant": {
+//                 "body_loc_key": "NEW_COMMENT",
+//                 "body_loc_args": ["{{user.username}}"]
+//             }
+//         },
+//         "sound": "default",
+//         "data": {
+//             "post_id": "{{record._id}}"
+//         }
+//     }
+// }
+// EOF
+type PushTemplateSaveHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PushTemplateSaveHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *PushTemplateSaveHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PushTemplateSaveHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &pushTemplateSavePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	conn := rpayload.DBConn
+	now := timeNow()
+	template := skydb.PushTemplate{}
+	if err := conn.GetPushTemplate(payload.Template.Name, &template); err == skydb.ErrPushTemplateNotFound {
+		template.CreatedAt = now
+	} else if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	template.Name = payload.Template.Name
+	template.DefaultLocale = payload.Template.DefaultLocale
+	template.Localizations = payload.Template.Localizations
+	template.Sound = payload.Template.Sound
+	template.Badge = payload.Template.Badge
+	template.Data = payload.Template.Data
+	template.UpdatedAt = now
+
+	if err := conn.SavePushTemplate(&template); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	response.Result = template
+}
+
+type pushTemplateFetchPayload struct {
+	Names []string `mapstructure:"names"`
+}
+
+func (payload *pushTemplateFetchPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return nil
+}
+
+// PushTemplateFetchHandler returns the push notification templates of
+// the specified names, or all templates if no names are specified.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "push:template:fetch",
+//     "master_key": "MASTER_KEY",
+//     "names": ["new-comment"]
+// }
+// EOF
+type PushTemplateFetchHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PushTemplateFetchHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *PushTemplateFetchHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PushTemplateFetchHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &pushTemplateFetchPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	conn := rpayload.DBConn
+	if len(payload.Names) == 0 {
+		templates, err := conn.GetPushTemplates()
+		if err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+		response.Result = templates
+		return
+	}
+
+	templates := make([]skydb.PushTemplate, len(payload.Names))
+	for i, name := range payload.Names {
+		if err := conn.GetPushTemplate(name, &templates[i]); err != nil {
+			response.Err = pushTemplateError(name, err)
+			return
+		}
+	}
+	response.Result = templates
+}
+
+type pushTemplateDeletePayload struct {
+	Name string `mapstructure:"name"`
+}
+
+func (payload *pushTemplateDeletePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	if payload.Name == "" {
+		return skyerr.NewInvalidArgument("empty template name", []string{"name"})
+	}
+	return nil
+}
+
+// PushTemplateDeleteHandler deletes a push notification template.
+//
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "push:template:delete",
+//     "master_key": "MASTER_KEY",
+//     "name": "new-comment"
+// }
+// EOF
+type PushTemplateDeleteHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PushTemplateDeleteHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *PushTemplateDeleteHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PushTemplateDeleteHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &pushTemplateDeletePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if err := rpayload.DBConn.DeletePushTemplate(payload.Name); err != nil {
+		response.Err = pushTemplateError(payload.Name, err)
+		return
+	}
+	response.Result = map[string]interface{}{
+		"name": payload.Name,
+	}
+}
+
+func pushTemplateError(name string, err error) skyerr.Error {
+	if err == skydb.ErrPushTemplateNotFound {
+		return skyerr.NewErrorWithInfo(
+			skyerr.ResourceNotFound,
+			fmt.Sprintf(`cannot find push template "%s"`, name),
+			map[string]interface{}{"name": name},
+		)
+	}
+	return skyerr.MakeError(err)
+}
@@ -0,0 +1,319 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type pushTemplateConn struct {
+	templates map[string]skydb.PushTemplate
+	skydb.Conn
+}
+
+func (conn *pushTemplateConn) SavePushTemplate(template *skydb.PushTemplate) error {
+	if conn.templates == nil {
+		conn.templates = map[string]skydb.PushTemplate{}
+	}
+	conn.templates[template.Name] = *template
+	return nil
+}
+
+func (conn *pushTemplateConn) GetPushTemplate(name string, template *skydb.PushTemplate) error {
+	t, ok := conn.templates[name]
+	if !ok {
+		return skydb.ErrPushTemplateNotFound
+	}
+	*template = t
+	return nil
+}
+
+func (conn *pushTemplateConn) GetPushTemplates() ([]skydb.PushTemplate, error) {
+	names := []string{}
+	for name := range conn.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	templates := []skydb.PushTemplate{}
+	for _, name := range names {
+		templates = append(templates, conn.templates[name])
+	}
+	return templates, nil
+}
+
+func (conn *pushTemplateConn) DeletePushTemplate(name string) error {
+	if _, ok := conn.templates[name]; !ok {
+		return skydb.ErrPushTemplateNotFound
+	}
+	delete(conn.templates, name)
+	return nil
+}
+
+func TestPushTemplateSaveHandler(t *testing.T) {
+	Convey("PushTemplateSaveHandler", t, func() {
+		conn := &pushTemplateConn{}
+		r := handlertest.NewSingleRouteRouter(&PushTemplateSaveHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		originalTimeNow := timeNow
+		timeNow = func() time.Time { return time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC) }
+		defer func() {
+			timeNow = originalTimeNow
+		}()
+
+		Convey("creates a template", func() {
+			resp := r.POST(`{
+				"template": {
+					"name": "new-comment",
+					"default_locale": "en",
+					"localizations": {
+						"en": {"title": "New comment", "body": "{{user.username}} commented"},
+						"zh-Hant": {"body_loc_key": "NEW_COMMENT", "body_loc_args": ["{{user.username}}"]}
+					},
+					"sound": "default",
+					"badge": 1,
+					"data": {"post_id": "{{record._id}}"}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"name": "new-comment",
+		"default_locale": "en",
+		"localizations": {
+			"en": {"title": "New comment", "body": "{{user.username}} commented"},
+			"zh-Hant": {"body_loc_key": "NEW_COMMENT", "body_loc_args": ["{{user.username}}"]}
+		},
+		"sound": "default",
+		"badge": 1,
+		"data": {"post_id": "{{record._id}}"},
+		"created_at": "2006-01-02T15:04:05Z",
+		"updated_at": "2006-01-02T15:04:05Z"
+	}
+}`)
+			So(conn.templates["new-comment"].Localizations["zh-Hant"], ShouldResemble, skydb.PushTemplateLocalization{
+				BodyLocKey:  "NEW_COMMENT",
+				BodyLocArgs: []string{"{{user.username}}"},
+			})
+		})
+
+		Convey("replaces a template and keeps its creation time", func() {
+			conn.templates = map[string]skydb.PushTemplate{
+				"new-comment": skydb.PushTemplate{
+					Name:      "new-comment",
+					CreatedAt: time.Date(2005, 1, 2, 15, 4, 5, 0, time.UTC),
+					UpdatedAt: time.Date(2005, 1, 2, 15, 4, 5, 0, time.UTC),
+				},
+			}
+
+			r.POST(`{
+				"template": {
+					"name": "new-comment",
+					"default_locale": "en",
+					"localizations": {
+						"en": {"body": "New comment"}
+					}
+				}
+			}`)
+
+			template := conn.templates["new-comment"]
+			So(template.DefaultLocale, ShouldEqual, "en")
+			So(template.CreatedAt, ShouldResemble, time.Date(2005, 1, 2, 15, 4, 5, 0, time.UTC))
+			So(template.UpdatedAt, ShouldResemble, time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC))
+		})
+
+		Convey("complains on invalid name", func() {
+			resp := r.POST(`{
+				"template": {
+					"name": "new comment",
+					"default_locale": "en",
+					"localizations": {
+						"en": {"body": "New comment"}
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "invalid template name = new comment",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["template.name"]}
+	}
+}`)
+		})
+
+		Convey("complains on default locale without localization", func() {
+			resp := r.POST(`{
+				"template": {
+					"name": "new-comment",
+					"default_locale": "fr",
+					"localizations": {
+						"en": {"body": "New comment"}
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "default locale has no localization",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["template.default_locale"]}
+	}
+}`)
+			So(conn.templates, ShouldBeEmpty)
+		})
+
+		Convey("complains on empty localization", func() {
+			resp := r.POST(`{
+				"template": {
+					"name": "new-comment",
+					"default_locale": "en",
+					"localizations": {
+						"en": {"sound": "default"}
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "empty localization = en",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["template.localizations"]}
+	}
+}`)
+		})
+	})
+}
+
+func TestPushTemplateFetchHandler(t *testing.T) {
+	Convey("PushTemplateFetchHandler", t, func() {
+		createdAt := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		conn := &pushTemplateConn{
+			templates: map[string]skydb.PushTemplate{
+				"b": skydb.PushTemplate{
+					Name:          "b",
+					DefaultLocale: "en",
+					Localizations: map[string]skydb.PushTemplateLocalization{
+						"en": {Body: "B"},
+					},
+					CreatedAt: createdAt,
+					UpdatedAt: createdAt,
+				},
+				"a": skydb.PushTemplate{
+					Name:          "a",
+					DefaultLocale: "en",
+					Localizations: map[string]skydb.PushTemplateLocalization{
+						"en": {Body: "A"},
+					},
+					CreatedAt: createdAt,
+					UpdatedAt: createdAt,
+				},
+			},
+		}
+		r := handlertest.NewSingleRouteRouter(&PushTemplateFetchHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		Convey("fetches all templates", func() {
+			resp := r.POST(`{}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"name": "a",
+		"default_locale": "en",
+		"localizations": {"en": {"body": "A"}},
+		"created_at": "2006-01-02T15:04:05Z",
+		"updated_at": "2006-01-02T15:04:05Z"
+	}, {
+		"name": "b",
+		"default_locale": "en",
+		"localizations": {"en": {"body": "B"}},
+		"created_at": "2006-01-02T15:04:05Z",
+		"updated_at": "2006-01-02T15:04:05Z"
+	}]
+}`)
+		})
+
+		Convey("fetches templates by names", func() {
+			resp := r.POST(`{"names": ["b"]}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"name": "b",
+		"default_locale": "en",
+		"localizations": {"en": {"body": "B"}},
+		"created_at": "2006-01-02T15:04:05Z",
+		"updated_at": "2006-01-02T15:04:05Z"
+	}]
+}`)
+		})
+
+		Convey("complains on non-existent template", func() {
+			resp := r.POST(`{"names": ["a", "c"]}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 110,
+		"message": "cannot find push template \"c\"",
+		"name": "ResourceNotFound",
+		"info": {"name": "c"}
+	}
+}`)
+		})
+	})
+}
+
+func TestPushTemplateDeleteHandler(t *testing.T) {
+	Convey("PushTemplateDeleteHandler", t, func() {
+		conn := &pushTemplateConn{
+			templates: map[string]skydb.PushTemplate{
+				"a": skydb.PushTemplate{Name: "a"},
+			},
+		}
+		r := handlertest.NewSingleRouteRouter(&PushTemplateDeleteHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		Convey("deletes a template", func() {
+			resp := r.POST(`{"name": "a"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {"name": "a"}
+}`)
+			So(conn.templates, ShouldBeEmpty)
+		})
+
+		Convey("complains on non-existent template", func() {
+			resp := r.POST(`{"name": "b"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 110,
+		"message": "cannot find push template \"b\"",
+		"name": "ResourceNotFound",
+		"info": {"name": "b"}
+	}
+}`)
+		})
+	})
+}
@@ -15,7 +15,9 @@
 package handler
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
 	. "github.com/skygeario/skygear-server/pkg/server/skytest"
@@ -24,6 +26,7 @@ import (
 	"github.com/skygeario/skygear-server/pkg/server/push"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
 )
 
 func TestPushToDevice(t *testing.T) {
@@ -42,15 +45,21 @@ func TestPushToDevice(t *testing.T) {
 			p.DBConn = &conn
 		})
 
-		originalSendFunc := sendPushNotification
+		originalEnqueueFunc := enqueuePushNotification
+		originalUUIDNew := uuidNew
+		uuidNew = func() string {
+			return "notification-id"
+		}
 		defer func() {
-			sendPushNotification = originalSendFunc
+			enqueuePushNotification = originalEnqueueFunc
+			uuidNew = originalUUIDNew
 		}()
 
 		Convey("push to single device", func(c C) {
 			called := false
-			sendPushNotification = func(sender push.Sender, device skydb.Device, m push.Mapper) {
-				c.So(device, ShouldResemble, testdevice)
+			enqueuePushNotification = func(conn skydb.Conn, notificationID string, devices []skydb.Device, m push.Mapper) error {
+				c.So(notificationID, ShouldEqual, "notification-id")
+				c.So(devices, ShouldResemble, []skydb.Device{testdevice})
 				c.So(m.Map(), ShouldResemble, map[string]interface{}{
 					"aps": map[string]interface{}{
 						"alert": "This is a message.",
@@ -59,6 +68,7 @@ func TestPushToDevice(t *testing.T) {
 					"acme": "interesting",
 				})
 				called = true
+				return nil
 			}
 
 			resp := r.POST(`{
@@ -74,16 +84,38 @@ func TestPushToDevice(t *testing.T) {
 			So(resp.Code, ShouldEqual, 200)
 			So(resp.Body.Bytes(), ShouldEqualJSON, `{
 	"result": [{
-		"_id": "device"
+		"_id": "device",
+		"notification_id": "notification-id"
 	}]
 }`)
 			So(called, ShouldBeTrue)
 		})
 
+		Convey("reports error when failed to enqueue", func() {
+			enqueuePushNotification = func(conn skydb.Conn, notificationID string, devices []skydb.Device, m push.Mapper) error {
+				return errors.New("queue is full")
+			}
+			resp := r.POST(`{
+					"device_ids": ["device"],
+					"notification": {"acme": "interesting"}
+				}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "device",
+		"_type": "error",
+		"message": "queue is full",
+		"name": "UnexpectedError",
+		"code": 10000
+	}]
+}`)
+		})
+
 		Convey("push to non-existent device", func() {
 			called := false
-			sendPushNotification = func(sender push.Sender, device skydb.Device, m push.Mapper) {
+			enqueuePushNotification = func(conn skydb.Conn, notificationID string, devices []skydb.Device, m push.Mapper) error {
 				called = true
+				return nil
 			}
 			resp := r.POST(`{
 						"device_ids": ["nonexistent"],
@@ -140,14 +172,20 @@ func TestPushToUser(t *testing.T) {
 			p.DBConn = &conn
 		})
 
-		originalSendFunc := sendPushNotification
+		originalEnqueueFunc := enqueuePushNotification
+		originalUUIDNew := uuidNew
+		uuidNew = func() string {
+			return "notification-id"
+		}
 		defer func() {
-			sendPushNotification = originalSendFunc
+			enqueuePushNotification = originalEnqueueFunc
+			uuidNew = originalUUIDNew
 		}()
 
 		Convey("push to single user", func(c C) {
 			sentDevices := []skydb.Device{}
-			sendPushNotification = func(sender push.Sender, device skydb.Device, m push.Mapper) {
+			enqueuePushNotification = func(conn skydb.Conn, notificationID string, devices []skydb.Device, m push.Mapper) error {
+				c.So(notificationID, ShouldEqual, "notification-id")
 				c.So(m.Map(), ShouldResemble, map[string]interface{}{
 					"aps": map[string]interface{}{
 						"alert": "This is a message.",
@@ -155,7 +193,8 @@ func TestPushToUser(t *testing.T) {
 					},
 					"acme": "interesting",
 				})
-				sentDevices = append(sentDevices, device)
+				sentDevices = append(sentDevices, devices...)
+				return nil
 			}
 
 			resp := r.POST(`{
@@ -170,7 +209,7 @@ func TestPushToUser(t *testing.T) {
 				}`)
 			So(resp.Code, ShouldEqual, 200)
 			So(resp.Body.Bytes(), ShouldEqualJSON, `{
-	"result": [{"_id":"johndoe"}]
+	"result": [{"_id":"johndoe","notification_id":"notification-id"}]
 }`)
 
 			So(len(sentDevices), ShouldEqual, 2)
@@ -180,8 +219,9 @@ func TestPushToUser(t *testing.T) {
 
 		Convey("push to non-existent user", func() {
 			called := false
-			sendPushNotification = func(sender push.Sender, device skydb.Device, m push.Mapper) {
+			enqueuePushNotification = func(conn skydb.Conn, notificationID string, devices []skydb.Device, m push.Mapper) error {
 				called = true
+				return nil
 			}
 			resp := r.POST(`{
 					"user_ids": ["nonexistent"],
@@ -210,11 +250,1075 @@ func TestPushToUser(t *testing.T) {
 
 }
 
+func TestPushWithTemplate(t *testing.T) {
+	Convey("push with template", t, func() {
+		conn := &templateDeviceConn{
+			simpleDeviceConn: simpleDeviceConn{
+				devices: []skydb.Device{
+					{ID: "device1", Type: "web", Token: "token1", Locale: "fr-CA"},
+					{ID: "device2", Type: "web", Token: "token2"},
+				},
+			},
+			templates: map[string]skydb.PushTemplate{
+				"greeting": skydb.PushTemplate{
+					Name:          "greeting",
+					DefaultLocale: "en",
+					Localizations: map[string]skydb.PushTemplateLocalization{
+						"en": {Body: "Hello {{name}}"},
+						"fr": {Body: "Bonjour {{name}}"},
+					},
+				},
+			},
+		}
+
+		r := handlertest.NewSingleRouteRouter(&PushToDeviceHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		originalEnqueueFunc := enqueuePushNotification
+		originalUUIDNew := uuidNew
+		uuidNew = func() string {
+			return "notification-id"
+		}
+		defer func() {
+			enqueuePushNotification = originalEnqueueFunc
+			uuidNew = originalUUIDNew
+		}()
+
+		rendered := map[string]interface{}{}
+		enqueuePushNotification = func(conn skydb.Conn, notificationID string, devices []skydb.Device, m push.Mapper) error {
+			for _, device := range devices {
+				rendered[device.ID] = m.Map()["web"]
+			}
+			return nil
+		}
+
+		Convey("renders template with device locale", func() {
+			resp := r.POST(`{
+				"device_ids": ["device1", "device2"],
+				"template": "greeting",
+				"context": {"name": "John"}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "device1",
+		"notification_id": "notification-id"
+	}, {
+		"_id": "device2",
+		"notification_id": "notification-id"
+	}]
+}`)
+			So(rendered, ShouldResemble, map[string]interface{}{
+				"device1": map[string]interface{}{"body": "Bonjour John"},
+				"device2": map[string]interface{}{"body": "Hello John"},
+			})
+		})
+
+		Convey("complains on non-existent template", func() {
+			resp := r.POST(`{
+				"device_ids": ["device1"],
+				"template": "farewell"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 110,
+		"message": "cannot find push template \"farewell\"",
+		"name": "ResourceNotFound",
+		"info": {"name": "farewell"}
+	}
+}`)
+			So(rendered, ShouldBeEmpty)
+		})
+
+		Convey("complains on both notification and template", func() {
+			resp := r.POST(`{
+				"device_ids": ["device1"],
+				"template": "greeting",
+				"notification": {"web": {"body": "Hello"}}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "cannot specify both notification and template",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["notification", "template"]}
+	}
+}`)
+		})
+	})
+}
+
+type templateDeviceConn struct {
+	simpleDeviceConn
+	templates map[string]skydb.PushTemplate
+}
+
+func (conn *templateDeviceConn) GetPushTemplate(name string, template *skydb.PushTemplate) error {
+	t, ok := conn.templates[name]
+	if !ok {
+		return skydb.ErrPushTemplateNotFound
+	}
+	*template = t
+	return nil
+}
+
+func TestPushToQuery(t *testing.T) {
+	Convey("push to query", t, func() {
+		conn := &pushQueryConn{
+			simpleDeviceConn: simpleDeviceConn{
+				devices: []skydb.Device{
+					{ID: "device1", Type: "ios", Token: "token1", UserInfoID: "johndoe"},
+					{ID: "device2", Type: "ios", Token: "token2", UserInfoID: "johndoe"},
+					{ID: "device3", Type: "android", Token: "token3", UserInfoID: "janedoe"},
+				},
+			},
+			users: map[string]skydb.UserInfo{
+				"johndoe": {ID: "johndoe", Username: "john"},
+				"janedoe": {ID: "janedoe", Username: "jane"},
+			},
+		}
+		db := &pushQueryDatabase{
+			MapDB: skydbtest.NewMapDB(),
+		}
+
+		r := handlertest.NewSingleRouteRouter(&PushToQueryHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+		})
+
+		originalEnqueueFunc := enqueuePushNotification
+		originalUUIDNew := uuidNew
+		originalBatchSize := pushQueryBatchSize
+		uuidNew = func() string {
+			return "notification-id"
+		}
+		pushQueryBatchSize = 2
+		defer func() {
+			enqueuePushNotification = originalEnqueueFunc
+			uuidNew = originalUUIDNew
+			pushQueryBatchSize = originalBatchSize
+		}()
+
+		sent := map[string][]skydb.Device{}
+		rendered := map[string]map[string]interface{}{}
+		enqueuePushNotification = func(conn skydb.Conn, notificationID string, devices []skydb.Device, m push.Mapper) error {
+			userID := devices[0].UserInfoID
+			sent[userID] = append(sent[userID], devices...)
+			rendered[userID] = m.Map()
+			return nil
+		}
+
+		Convey("push to matched users with templated notification", func() {
+			db.records = []skydb.Record{
+				{ID: skydb.NewRecordID("user", "johndoe"), Data: skydb.Data{"city": "Hong Kong"}},
+				{ID: skydb.NewRecordID("user", "janedoe"), Data: skydb.Data{"city": "Taipei"}},
+				{ID: skydb.NewRecordID("user", "nodevice"), Data: skydb.Data{"city": "Tokyo"}},
+			}
+
+			resp := r.POST(`{
+				"query": {"record_type": "user"},
+				"notification": {
+					"apns": {"aps": {"alert": "Hi {{user.username}} from {{record.city}}"}}
+				}
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"notification_id": "notification-id",
+		"user_count": 2,
+		"device_count": 3
+	}
+}`)
+			So(sent["johndoe"], ShouldHaveLength, 2)
+			So(sent["janedoe"], ShouldHaveLength, 1)
+			So(rendered["johndoe"], ShouldResemble, map[string]interface{}{
+				"apns": map[string]interface{}{
+					"aps": map[string]interface{}{"alert": "Hi john from Hong Kong"},
+				},
+			})
+			So(rendered["janedoe"], ShouldResemble, map[string]interface{}{
+				"apns": map[string]interface{}{
+					"aps": map[string]interface{}{"alert": "Hi jane from Taipei"},
+				},
+			})
+
+			So(db.queries, ShouldHaveLength, 2)
+			So(db.queries[0].BypassAccessControl, ShouldBeTrue)
+			So(db.queries[0].Sorts, ShouldResemble, []skydb.Sort{{KeyPath: "_id", Order: skydb.Asc}})
+			So(db.queries[1].Offset, ShouldEqual, 2)
+		})
+
+		Convey("push to owners of matched records once", func() {
+			db.records = []skydb.Record{
+				{ID: skydb.NewRecordID("note", "note1"), OwnerID: "johndoe"},
+				{ID: skydb.NewRecordID("note", "note2"), OwnerID: "johndoe"},
+				{ID: skydb.NewRecordID("note", "note3"), OwnerID: "janedoe"},
+			}
+
+			resp := r.POST(`{
+				"query": {"record_type": "note"},
+				"notification": {"gcm": {"notification": {"title": "{{record._id}}"}}}
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"notification_id": "notification-id",
+		"user_count": 2,
+		"device_count": 3
+	}
+}`)
+			So(sent["johndoe"], ShouldHaveLength, 2)
+			So(rendered["johndoe"], ShouldResemble, map[string]interface{}{
+				"gcm": map[string]interface{}{
+					"notification": map[string]interface{}{"title": "note1"},
+				},
+			})
+		})
+
+		Convey("respects the query limit", func() {
+			db.records = []skydb.Record{
+				{ID: skydb.NewRecordID("user", "johndoe")},
+				{ID: skydb.NewRecordID("user", "janedoe")},
+			}
+
+			resp := r.POST(`{
+				"query": {"record_type": "user", "limit": 1},
+				"notification": {"apns": {"aps": {"alert": "Hello"}}}
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"notification_id": "notification-id",
+		"user_count": 1,
+		"device_count": 2
+	}
+}`)
+			So(db.queries, ShouldHaveLength, 1)
+			So(*db.queries[0].Limit, ShouldEqual, 1)
+		})
+
+		Convey("returns error when failed to query devices", func() {
+			db.records = []skydb.Record{
+				{ID: skydb.NewRecordID("user", "johndoe")},
+			}
+			conn.deviceErr = errors.New("connection refused")
+
+			resp := r.POST(`{
+				"query": {"record_type": "user"},
+				"notification": {"apns": {"aps": {"alert": "Hello"}}}
+			}`)
+			So(resp.Code, ShouldEqual, 500)
+			So(sent, ShouldBeEmpty)
+		})
+
+		Convey("returns error without query", func() {
+			resp := r.POST(`{
+				"notification": {"apns": {"aps": {"alert": "Hello"}}}
+			}`)
+			So(resp.Code, ShouldEqual, 400)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"name": "InvalidArgument",
+		"code": 108,
+		"message": "no query specified",
+		"info": {"arguments": ["query"]}
+	}
+}`)
+		})
+	})
+}
+
+type pushQueryConn struct {
+	simpleDeviceConn
+	users     map[string]skydb.UserInfo
+	deviceErr error
+}
+
+func (conn *pushQueryConn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
+	if conn.deviceErr != nil {
+		return nil, conn.deviceErr
+	}
+	return conn.simpleDeviceConn.QueryDevicesByUser(user)
+}
+
+func (conn *pushQueryConn) GetUser(id string, userinfo *skydb.UserInfo) error {
+	user, ok := conn.users[id]
+	if !ok {
+		return skydb.ErrUserNotFound
+	}
+	*userinfo = user
+	return nil
+}
+
+type pushQueryDatabase struct {
+	records []skydb.Record
+	queries []skydb.Query
+	*skydbtest.MapDB
+}
+
+func (db *pushQueryDatabase) Query(query *skydb.Query) (*skydb.Rows, error) {
+	queryCopy := *query
+	limit := *query.Limit
+	queryCopy.Limit = &limit
+	db.queries = append(db.queries, queryCopy)
+
+	records := []skydb.Record{}
+	for i := query.Offset; i < uint64(len(db.records)) && i < query.Offset+limit; i++ {
+		records = append(records, db.records[i])
+	}
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+func TestPushToTopic(t *testing.T) {
+	Convey("push to topic", t, func() {
+		conn := &pushTopicConn{
+			simpleDeviceConn: simpleDeviceConn{
+				devices: []skydb.Device{
+					{ID: "device1", Type: "ios", Token: "token1"},
+					{ID: "device2", Type: "ios", Token: "token2"},
+					{ID: "device3", Type: "android", Token: "token3"},
+					{ID: "device4", Type: "android", Token: "token4"},
+				},
+			},
+			topics: map[string][]string{
+				"news": []string{"device1", "device2", "device4"},
+			},
+		}
+
+		r := handlertest.NewSingleRouteRouter(&PushToTopicHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		originalEnqueueFunc := enqueuePushNotification
+		originalUUIDNew := uuidNew
+		originalBatchSize := pushTopicBatchSize
+		uuidNew = func() string {
+			return "notification-id"
+		}
+		pushTopicBatchSize = 2
+		defer func() {
+			enqueuePushNotification = originalEnqueueFunc
+			uuidNew = originalUUIDNew
+			pushTopicBatchSize = originalBatchSize
+		}()
+
+		batches := [][]string{}
+		var rendered map[string]interface{}
+		enqueuePushNotification = func(conn skydb.Conn, notificationID string, devices []skydb.Device, m push.Mapper) error {
+			rendered = m.Map()
+			ids := []string{}
+			for _, device := range devices {
+				ids = append(ids, device.ID)
+			}
+			batches = append(batches, ids)
+			return nil
+		}
+
+		Convey("push to subscribed devices page by page", func() {
+			resp := r.POST(`{
+				"subscription_topic": "news",
+				"notification": {
+					"apns": {
+						"aps": {
+							"alert": "Breaking news!"
+						}
+					}
+				}
+			}`)
+
+			So(batches, ShouldResemble, [][]string{
+				{"device1", "device2"},
+				{"device4"},
+			})
+			So(rendered, ShouldResemble, map[string]interface{}{
+				"apns": map[string]interface{}{
+					"aps": map[string]interface{}{
+						"alert": "Breaking news!",
+					},
+				},
+			})
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"notification_id": "notification-id",
+		"device_count": 3
+	}
+}`)
+		})
+
+		Convey("push to topic without subscribers", func() {
+			resp := r.POST(`{
+				"subscription_topic": "sports",
+				"notification": {
+					"apns": {
+						"aps": {
+							"alert": "Breaking news!"
+						}
+					}
+				}
+			}`)
+
+			So(batches, ShouldBeEmpty)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"notification_id": "notification-id",
+		"device_count": 0
+	}
+}`)
+		})
+
+		Convey("complains on empty subscription topic", func() {
+			resp := r.POST(`{
+				"topic": "news",
+				"notification": {
+					"apns": {
+						"aps": {
+							"alert": "Breaking news!"
+						}
+					}
+				}
+			}`)
+
+			So(batches, ShouldBeEmpty)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "empty subscription topic",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["subscription_topic"]}
+	}
+}`)
+		})
+	})
+}
+
+type pushTopicConn struct {
+	simpleDeviceConn
+	topics map[string][]string
+}
+
+func (conn *pushTopicConn) QueryDevicesByTopic(topic, afterID string, limit int) ([]skydb.Device, error) {
+	devices := []skydb.Device{}
+	for _, deviceID := range conn.topics[topic] {
+		if deviceID <= afterID || len(devices) >= limit {
+			continue
+		}
+		device := skydb.Device{}
+		if err := conn.GetDevice(deviceID, &device); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+func TestPushWithOptions(t *testing.T) {
+	Convey("push with options", t, func() {
+		testdevice1 := skydb.Device{
+			ID:         "device1",
+			Type:       "ios",
+			Token:      "token1",
+			UserInfoID: "johndoe",
+		}
+		testdevice2 := skydb.Device{
+			ID:         "device2",
+			Type:       "android",
+			Token:      "token2",
+			UserInfoID: "janedoe",
+		}
+		conn := simpleDeviceConn{
+			devices: []skydb.Device{testdevice1, testdevice2},
+		}
+		conn.PublicDB().Save(&skydb.Record{
+			ID: skydb.NewRecordID("user", "johndoe"),
+			Data: map[string]interface{}{
+				"quiet_hours": map[string]interface{}{
+					"start":    "22:00",
+					"end":      "07:00",
+					"timezone": "Asia/Hong_Kong",
+				},
+			},
+		})
+
+		userRouter := handlertest.NewSingleRouteRouter(&PushToUserHandler{}, func(p *router.Payload) {
+			p.DBConn = &conn
+		})
+		deviceRouter := handlertest.NewSingleRouteRouter(&PushToDeviceHandler{}, func(p *router.Payload) {
+			p.DBConn = &conn
+		})
+
+		originalEnqueueFunc := enqueuePushNotification
+		originalUUIDNew := uuidNew
+		uuidNew = func() string {
+			return "notification-id"
+		}
+		// 02:00 in Hong Kong
+		now := time.Date(2006, 1, 2, 18, 0, 0, 0, time.UTC)
+		timeNow = func() time.Time { return now }
+		defer func() {
+			enqueuePushNotification = originalEnqueueFunc
+			uuidNew = originalUUIDNew
+			timeNow = timeNowUTC
+		}()
+
+		sentDevices := []skydb.Device{}
+		collapseKeys := []string{}
+		enqueuePushNotification = func(conn skydb.Conn, notificationID string, devices []skydb.Device, m push.Mapper) error {
+			sentDevices = append(sentDevices, devices...)
+			collapseKeys = append(collapseKeys, push.CollapseKey(m))
+			return nil
+		}
+
+		Convey("schedules notification with send_at", func() {
+			resp := userRouter.POST(`{
+					"user_ids": ["johndoe", "janedoe"],
+					"send_at": "2006-01-03T10:00:00+08:00",
+					"collapse_key": "score",
+					"notification": {"aps": {"alert": "Hello"}}
+				}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [
+		{"_id": "johndoe", "notification_id": "notification-id"},
+		{"_id": "janedoe", "notification_id": "notification-id"}
+	]
+}`)
+			So(sentDevices, ShouldBeEmpty)
+			So(conn.schedules, ShouldResemble, []skydb.PushSchedule{
+				{
+					ID:             "notification-id",
+					NotificationID: "notification-id",
+					UserIDs:        []string{"johndoe", "janedoe"},
+					Notification: map[string]interface{}{
+						"aps": map[string]interface{}{"alert": "Hello"},
+					},
+					CollapseKey: "score",
+					State:       skydb.PushScheduleScheduled,
+					SendAt:      time.Date(2006, 1, 3, 2, 0, 0, 0, time.UTC),
+					CreatedAt:   now,
+					UpdatedAt:   now,
+				},
+			})
+		})
+
+		Convey("sends notification with send_at in the past", func() {
+			resp := userRouter.POST(`{
+					"user_ids": ["janedoe"],
+					"send_at": "2006-01-01T00:00:00Z",
+					"collapse_key": "score",
+					"notification": {"aps": {"alert": "Hello"}}
+				}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(sentDevices, ShouldResemble, []skydb.Device{testdevice2})
+			So(collapseKeys, ShouldResemble, []string{"score"})
+			So(conn.schedules, ShouldBeEmpty)
+		})
+
+		Convey("defers notification to user in quiet hours", func() {
+			resp := userRouter.POST(`{
+					"user_ids": ["johndoe", "janedoe"],
+					"notification": {"aps": {"alert": "Hello"}}
+				}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [
+		{"_id": "johndoe", "notification_id": "notification-id"},
+		{"_id": "janedoe", "notification_id": "notification-id"}
+	]
+}`)
+			So(sentDevices, ShouldResemble, []skydb.Device{testdevice2})
+			So(len(conn.schedules), ShouldEqual, 1)
+			So(conn.schedules[0].UserIDs, ShouldResemble, []string{"johndoe"})
+			So(conn.schedules[0].SendAt, ShouldResemble, time.Date(2006, 1, 2, 23, 0, 0, 0, time.UTC))
+		})
+
+		Convey("sends urgent notification in quiet hours", func() {
+			resp := userRouter.POST(`{
+					"user_ids": ["johndoe"],
+					"urgent": true,
+					"notification": {"aps": {"alert": "Hello"}}
+				}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(sentDevices, ShouldResemble, []skydb.Device{testdevice1})
+			So(conn.schedules, ShouldBeEmpty)
+		})
+
+		Convey("defers notification to device in quiet hours", func() {
+			resp := deviceRouter.POST(`{
+					"device_ids": ["device1", "device2"],
+					"notification": {"aps": {"alert": "Hello"}}
+				}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(sentDevices, ShouldResemble, []skydb.Device{testdevice2})
+			So(len(conn.schedules), ShouldEqual, 1)
+			So(conn.schedules[0].DeviceIDs, ShouldResemble, []string{"device1"})
+			So(conn.schedules[0].UserIDs, ShouldBeNil)
+		})
+
+		Convey("returns error for invalid send_at", func() {
+			resp := userRouter.POST(`{
+					"user_ids": ["johndoe"],
+					"send_at": "tomorrow",
+					"notification": {"aps": {"alert": "Hello"}}
+				}`)
+			So(resp.Code, ShouldEqual, 400)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"name": "InvalidArgument",
+		"code": 108,
+		"message": "invalid send_at",
+		"info": {"arguments": ["send_at"]}
+	}
+}`)
+		})
+	})
+}
+
+func TestDispatchPushSchedule(t *testing.T) {
+	Convey("dispatch push schedule", t, func() {
+		now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		testdevice := skydb.Device{
+			ID:         "device1",
+			Type:       "ios",
+			Token:      "token1",
+			UserInfoID: "johndoe",
+		}
+		conn := simpleDeviceConn{
+			devices: []skydb.Device{testdevice},
+		}
+
+		originalEnqueueFunc := enqueuePushNotification
+		defer func() {
+			enqueuePushNotification = originalEnqueueFunc
+		}()
+
+		var sentNotificationID string
+		sentDevices := []skydb.Device{}
+		var sentMapper push.Mapper
+		enqueuePushNotification = func(conn skydb.Conn, notificationID string, devices []skydb.Device, m push.Mapper) error {
+			sentNotificationID = notificationID
+			sentDevices = append(sentDevices, devices...)
+			sentMapper = m
+			return nil
+		}
+
+		schedule := skydb.PushSchedule{
+			ID:             "schedule",
+			NotificationID: "notification",
+			Notification: map[string]interface{}{
+				"aps": map[string]interface{}{"alert": "Hello"},
+			},
+			CollapseKey: "score",
+			State:       skydb.PushScheduleScheduled,
+		}
+
+		Convey("sends to user", func() {
+			schedule.UserIDs = []string{"johndoe"}
+			So(DispatchPushSchedule(&conn, schedule), ShouldBeNil)
+			So(sentNotificationID, ShouldEqual, "notification")
+			So(sentDevices, ShouldResemble, []skydb.Device{testdevice})
+			So(sentMapper.Map(), ShouldResemble, map[string]interface{}{
+				"aps": map[string]interface{}{"alert": "Hello"},
+			})
+			So(push.CollapseKey(sentMapper), ShouldEqual, "score")
+		})
+
+		Convey("sends to device", func() {
+			schedule.DeviceIDs = []string{"device1"}
+			So(DispatchPushSchedule(&conn, schedule), ShouldBeNil)
+			So(sentDevices, ShouldResemble, []skydb.Device{testdevice})
+		})
+
+		Convey("skips non-existent user", func() {
+			schedule.UserIDs = []string{"nonexistent"}
+			So(DispatchPushSchedule(&conn, schedule), ShouldBeNil)
+			So(sentDevices, ShouldBeEmpty)
+		})
+
+		Convey("expands schedule of multiple users into schedule of each user", func() {
+			originalUUIDNew := uuidNew
+			originalTimeNow := timeNow
+			ids := []string{"schedule-johndoe", "schedule-janedoe"}
+			uuidNew = func() string {
+				id := ids[0]
+				ids = ids[1:]
+				return id
+			}
+			timeNow = func() time.Time { return now }
+			defer func() {
+				uuidNew = originalUUIDNew
+				timeNow = originalTimeNow
+			}()
+
+			schedule.UserIDs = []string{"johndoe", "janedoe"}
+			schedule.SendAt = now
+			So(DispatchPushSchedule(&conn, schedule), ShouldBeNil)
+			So(sentDevices, ShouldBeEmpty)
+			So(conn.schedules, ShouldResemble, []skydb.PushSchedule{
+				{
+					ID:             "schedule-johndoe",
+					NotificationID: "notification",
+					UserIDs:        []string{"johndoe"},
+					Notification:   schedule.Notification,
+					CollapseKey:    "score",
+					State:          skydb.PushScheduleScheduled,
+					SendAt:         now,
+					CreatedAt:      now,
+					UpdatedAt:      now,
+				},
+				{
+					ID:             "schedule-janedoe",
+					NotificationID: "notification",
+					UserIDs:        []string{"janedoe"},
+					Notification:   schedule.Notification,
+					CollapseKey:    "score",
+					State:          skydb.PushScheduleScheduled,
+					SendAt:         now,
+					CreatedAt:      now,
+					UpdatedAt:      now,
+				},
+			})
+		})
+
+		Convey("drops schedule of deleted template", func() {
+			templateConn := templateDeviceConn{
+				simpleDeviceConn: conn,
+				templates:        map[string]skydb.PushTemplate{},
+			}
+			schedule.Notification = nil
+			schedule.Template = "deleted"
+			schedule.UserIDs = []string{"johndoe"}
+			So(DispatchPushSchedule(&templateConn, schedule), ShouldBeNil)
+			So(sentDevices, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestPushCancel(t *testing.T) {
+	Convey("push cancel", t, func() {
+		createdAt := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		conn := &pushDeliveryConn{
+			schedules: []skydb.PushSchedule{
+				{
+					ID:             "schedule1",
+					NotificationID: "notification",
+					UserIDs:        []string{"johndoe"},
+					State:          skydb.PushScheduleScheduled,
+					SendAt:         createdAt.Add(time.Hour),
+				},
+				{
+					ID:             "schedule2",
+					NotificationID: "sent",
+					UserIDs:        []string{"johndoe"},
+					State:          skydb.PushScheduleSent,
+					SendAt:         createdAt,
+				},
+			},
+		}
+
+		r := handlertest.NewSingleRouteRouter(&PushCancelHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		Convey("cancels scheduled notification", func() {
+			resp := r.POST(`{"notification_id": "notification"}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"notification_id": "notification",
+		"cancelled": 1
+	}
+}`)
+			So(conn.schedules[0].State, ShouldEqual, skydb.PushScheduleCancelled)
+		})
+
+		Convey("returns error for sent notification", func() {
+			resp := r.POST(`{"notification_id": "sent"}`)
+			So(resp.Code, ShouldEqual, 404)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"name": "ResourceNotFound",
+		"code": 110,
+		"message": "cannot find scheduled notification \"sent\"",
+		"info": {"id": "sent"}
+	}
+}`)
+			So(conn.schedules[1].State, ShouldEqual, skydb.PushScheduleSent)
+		})
+
+		Convey("returns error without notification id", func() {
+			resp := r.POST(`{}`)
+			So(resp.Code, ShouldEqual, 400)
+		})
+	})
+}
+
+func TestPushStatus(t *testing.T) {
+	Convey("push status", t, func() {
+		createdAt := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		conn := &pushDeliveryConn{
+			deliveries: []skydb.PushDelivery{
+				{
+					ID:             "delivery1",
+					NotificationID: "notification",
+					DeviceID:       "device1",
+					DeviceType:     "ios",
+					State:          skydb.PushDeliverySent,
+					Attempts:       1,
+					CreatedAt:      createdAt,
+					UpdatedAt:      createdAt.Add(time.Second),
+				},
+				{
+					ID:             "delivery2",
+					NotificationID: "notification",
+					DeviceID:       "device2",
+					DeviceType:     "web",
+					State:          skydb.PushDeliveryTokenInvalid,
+					Attempts:       1,
+					LastError:      "push: device token is no longer valid",
+					CreatedAt:      createdAt,
+					UpdatedAt:      createdAt.Add(time.Second),
+				},
+				{
+					ID:             "delivery3",
+					NotificationID: "notification",
+					DeviceID:       "device3",
+					DeviceType:     "android",
+					State:          skydb.PushDeliveryQueued,
+					Attempts:       2,
+					LastError:      "push/fcm: FCM responded with error = UNAVAILABLE",
+					CreatedAt:      createdAt,
+					UpdatedAt:      createdAt.Add(time.Minute),
+				},
+			},
+		}
+
+		r := handlertest.NewSingleRouteRouter(&PushStatusHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		Convey("returns deliveries of a notification", func() {
+			resp := r.POST(`{"notification_id": "notification"}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"notification_id": "notification",
+		"counts": {
+			"queued": 1,
+			"sent": 1,
+			"failed": 0,
+			"token-invalid": 1
+		},
+		"deliveries": [{
+			"id": "delivery1",
+			"device_id": "device1",
+			"device_type": "ios",
+			"state": "sent",
+			"attempts": 1,
+			"created_at": "2006-01-02T15:04:05Z",
+			"updated_at": "2006-01-02T15:04:06Z"
+		}, {
+			"id": "delivery2",
+			"device_id": "device2",
+			"device_type": "web",
+			"state": "token-invalid",
+			"attempts": 1,
+			"last_error": "push: device token is no longer valid",
+			"created_at": "2006-01-02T15:04:05Z",
+			"updated_at": "2006-01-02T15:04:06Z"
+		}, {
+			"id": "delivery3",
+			"device_id": "device3",
+			"device_type": "android",
+			"state": "queued",
+			"attempts": 2,
+			"last_error": "push/fcm: FCM responded with error = UNAVAILABLE",
+			"created_at": "2006-01-02T15:04:05Z",
+			"updated_at": "2006-01-02T15:05:05Z"
+		}],
+		"has_more": false
+	}
+}`)
+		})
+
+		Convey("returns deliveries of a notification by page", func() {
+			resp := r.POST(`{"notification_id": "notification", "limit": 1}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"notification_id": "notification",
+		"counts": {
+			"queued": 1,
+			"sent": 1,
+			"failed": 0,
+			"token-invalid": 1
+		},
+		"deliveries": [{
+			"id": "delivery1",
+			"device_id": "device1",
+			"device_type": "ios",
+			"state": "sent",
+			"attempts": 1,
+			"created_at": "2006-01-02T15:04:05Z",
+			"updated_at": "2006-01-02T15:04:06Z"
+		}],
+		"has_more": true
+	}
+}`)
+
+			resp = r.POST(`{"notification_id": "notification", "after": "delivery2", "limit": 1}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"notification_id": "notification",
+		"counts": {
+			"queued": 1,
+			"sent": 1,
+			"failed": 0,
+			"token-invalid": 1
+		},
+		"deliveries": [{
+			"id": "delivery3",
+			"device_id": "device3",
+			"device_type": "android",
+			"state": "queued",
+			"attempts": 2,
+			"last_error": "push/fcm: FCM responded with error = UNAVAILABLE",
+			"created_at": "2006-01-02T15:04:05Z",
+			"updated_at": "2006-01-02T15:05:05Z"
+		}],
+		"has_more": false
+	}
+}`)
+		})
+
+		Convey("returns error with limit out of range", func() {
+			resp := r.POST(`{"notification_id": "notification", "limit": 1001}`)
+			So(resp.Code, ShouldEqual, 400)
+		})
+
+		Convey("returns schedules of a notification", func() {
+			conn.schedules = []skydb.PushSchedule{
+				{
+					ID:             "schedule1",
+					NotificationID: "scheduled",
+					UserIDs:        []string{"johndoe"},
+					State:          skydb.PushScheduleScheduled,
+					SendAt:         createdAt.Add(time.Hour),
+					CreatedAt:      createdAt,
+					UpdatedAt:      createdAt,
+				},
+			}
+			resp := r.POST(`{"notification_id": "scheduled"}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"notification_id": "scheduled",
+		"counts": {
+			"queued": 0,
+			"sent": 0,
+			"failed": 0,
+			"token-invalid": 0
+		},
+		"deliveries": [],
+		"has_more": false,
+		"schedules": [{
+			"user_ids": ["johndoe"],
+			"state": "scheduled",
+			"send_at": "2006-01-02T16:04:05Z",
+			"created_at": "2006-01-02T15:04:05Z",
+			"updated_at": "2006-01-02T15:04:05Z"
+		}]
+	}
+}`)
+		})
+
+		Convey("returns error for non-existent notification", func() {
+			resp := r.POST(`{"notification_id": "nonexistent"}`)
+			So(resp.Code, ShouldEqual, 404)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"name": "ResourceNotFound",
+		"code": 110,
+		"message": "cannot find notification \"nonexistent\"",
+		"info": {"id": "nonexistent"}
+	}
+}`)
+		})
+
+		Convey("returns error without notification id", func() {
+			resp := r.POST(`{}`)
+			So(resp.Code, ShouldEqual, 400)
+		})
+	})
+}
+
+type pushDeliveryConn struct {
+	deliveries []skydb.PushDelivery
+	schedules  []skydb.PushSchedule
+	skydb.Conn
+}
+
+func (conn *pushDeliveryConn) GetPushSchedules(notificationID string) ([]skydb.PushSchedule, error) {
+	results := []skydb.PushSchedule{}
+	for _, schedule := range conn.schedules {
+		if schedule.NotificationID == notificationID {
+			results = append(results, schedule)
+		}
+	}
+	return results, nil
+}
+
+func (conn *pushDeliveryConn) CancelPushSchedules(notificationID string, now time.Time) (int, error) {
+	cancelled := 0
+	for i, schedule := range conn.schedules {
+		if schedule.NotificationID == notificationID && schedule.State == skydb.PushScheduleScheduled {
+			conn.schedules[i].State = skydb.PushScheduleCancelled
+			conn.schedules[i].UpdatedAt = now
+			cancelled++
+		}
+	}
+	return cancelled, nil
+}
+
+func (conn *pushDeliveryConn) GetPushDeliveries(notificationID string, afterID string, limit int) ([]skydb.PushDelivery, error) {
+	results := []skydb.PushDelivery{}
+	for _, delivery := range conn.deliveries {
+		if delivery.NotificationID == notificationID && delivery.ID > afterID && len(results) < limit {
+			results = append(results, delivery)
+		}
+	}
+	return results, nil
+}
+
+func (conn *pushDeliveryConn) CountPushDeliveries(notificationID string) (map[skydb.PushDeliveryState]int, error) {
+	counts := map[skydb.PushDeliveryState]int{}
+	for _, delivery := range conn.deliveries {
+		if delivery.NotificationID == notificationID {
+			counts[delivery.State]++
+		}
+	}
+	return counts, nil
+}
+
 type simpleDeviceConn struct {
-	devices []skydb.Device
+	devices   []skydb.Device
+	schedules []skydb.PushSchedule
+	db        *skydbtest.MapDB
 	skydb.Conn
 }
 
+func (conn *simpleDeviceConn) PublicDB() skydb.Database {
+	if conn.db == nil {
+		conn.db = skydbtest.NewMapDB()
+	}
+	return conn.db
+}
+
+func (conn *simpleDeviceConn) SavePushSchedule(schedule *skydb.PushSchedule) error {
+	conn.schedules = append(conn.schedules, *schedule)
+	return nil
+}
+
 func (conn *simpleDeviceConn) GetDevice(id string, device *skydb.Device) error {
 	for _, prospectiveDevice := range conn.devices {
 		if prospectiveDevice.ID == id {
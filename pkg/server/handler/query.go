@@ -30,6 +30,10 @@ import (
 // QueryParser is a context for parsing raw query to skydb.Query
 type QueryParser struct {
 	UserID string
+
+	// policy is true when parsing a record policy, which is the only
+	// place the current user can be referenced by the key path `$user`.
+	policy bool
 }
 
 func (parser *QueryParser) sortFromRaw(rawSort []interface{}, sort *skydb.Sort) {
@@ -169,6 +173,17 @@ func (parser *QueryParser) parseExpression(i interface{}) skydb.Expression {
 			if keyPath == "_owner" {
 				keyPath = "_owner_id"
 			}
+			if keyPath == "$user" || strings.HasPrefix(keyPath, "$user.") {
+				if !parser.policy {
+					panic(fmt.Errorf("key path `%s` is only supported in record policy", keyPath))
+				}
+				return skydb.Expression{
+					Type: skydb.Function,
+					Value: skydb.CurrentUserFunc{
+						KeyPath: strings.TrimPrefix(strings.TrimPrefix(keyPath, "$user"), "."),
+					},
+				}
+			}
 			return skydb.Expression{
 				Type:  skydb.KeyPath,
 				Value: keyPath,
@@ -353,6 +368,37 @@ func (parser *QueryParser) queryFromRaw(rawQuery map[string]interface{}, query *
 	return nil
 }
 
+// policyFromRaw parses a raw predicate into a record policy, in which the
+// current user is referenced by the key path `$user` or `$user.<field>`.
+func (parser *QueryParser) policyFromRaw(rawPredicate []interface{}, policy *skydb.Predicate) (err skyerr.Error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch policyErr := r.(type) {
+			case skyerr.Error:
+				err = policyErr
+			case error:
+				err = skyerr.NewErrorf(skyerr.InvalidArgument, "failed to construct policy: %v", policyErr.Error())
+			default:
+				log.WithField("recovered", r).Errorln("panic recovered while constructing policy")
+				err = skyerr.NewError(skyerr.InvalidArgument, "error occurred while constructing policy")
+			}
+		}
+	}()
+
+	parser.policy = true
+	defer func() {
+		parser.policy = false
+	}()
+
+	predicate := parser.predicateFromRaw(rawPredicate)
+	if err := predicate.ValidatePolicy(); err != nil {
+		return err
+	}
+
+	*policy = predicate
+	return nil
+}
+
 // execute do when if the value of key in m is []interface{}. If value exists
 // for key but its type is not []interface{} or do returns an error, it panics.
 func mustDoSlice(m map[string]interface{}, key string, do func(value []interface{}) skyerr.Error) {
@@ -128,4 +128,38 @@ func TestQueryFromRaw(t *testing.T) {
 		})
 	})
 
+	Convey("current user", t, func() {
+		Convey("is not supported in query", func() {
+			parser := &QueryParser{
+				UserID: "USER_ID",
+			}
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "note",
+				"predicate": []interface{}{
+					"eq",
+					map[string]interface{}{"$type": "keypath", "$val": "_owner"},
+					map[string]interface{}{"$type": "keypath", "$val": "$user"},
+				},
+			}, &query)
+			So(err, ShouldNotBeNil)
+			So(err.Message(), ShouldContainSubstring, "key path `$user` is only supported in record policy")
+		})
+
+		Convey("is supported in record policy", func() {
+			parser := &QueryParser{}
+			policy := skydb.Predicate{}
+			err := parser.policyFromRaw([]interface{}{
+				"eq",
+				map[string]interface{}{"$type": "keypath", "$val": "_owner"},
+				map[string]interface{}{"$type": "keypath", "$val": "$user"},
+			}, &policy)
+			So(err, ShouldBeNil)
+			So(policy.Children[1], ShouldResemble, skydb.Expression{
+				Type:  skydb.Function,
+				Value: skydb.CurrentUserFunc{},
+			})
+			So(parser.policy, ShouldBeFalse)
+		})
+	})
 }
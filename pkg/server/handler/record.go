@@ -437,6 +437,7 @@ func (h *RecordFetchHandler) Handle(payload *router.Payload, response *router.Re
 	}
 
 	db := payload.Database
+	fetcher := newRecordFetcher(db, payload.DBConn, payload.HasMasterKey())
 
 	results := make([]interface{}, p.ItemLen(), p.ItemLen())
 	for i, recordID := range p.RecordIDs {
@@ -457,16 +458,23 @@ func (h *RecordFetchHandler) Handle(payload *router.Payload, response *router.Re
 					skyerr.NewResourceFetchFailureErr("record", recordID.String()),
 				)
 			}
+		} else if accessible, err := fetcher.accessible(&record, payload.UserInfo, skydb.ReadLevel); err != nil {
+			results[i] = newSerializedError(recordID.String(), err)
+		} else if !accessible {
+			results[i] = newSerializedError(
+				recordID.String(),
+				skyerr.NewError(skyerr.PermissionDenied, "no permission to read"),
+			)
+		} else if matched, err := fetcher.matchRecordPolicy(&record, payload.UserInfo); err != nil {
+			results[i] = newSerializedError(recordID.String(), err)
+		} else if !matched {
+			results[i] = newSerializedError(
+				recordID.String(),
+				skyerr.NewError(skyerr.PermissionDenied, "no permission to read"),
+			)
 		} else {
-			if payload.HasMasterKey() || record.Accessible(payload.UserInfo, skydb.ReadLevel) {
-				injectSigner(&record, h.AssetStore)
-				results[i] = (*skyconv.JSONRecord)(&record)
-			} else {
-				results[i] = newSerializedError(
-					recordID.String(),
-					skyerr.NewError(skyerr.PermissionDenied, "no permission to read"),
-				)
-			}
+			injectSigner(&record, h.AssetStore)
+			results[i] = (*skyconv.JSONRecord)(&record)
 		}
 	}
 
@@ -0,0 +1,396 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// RecordChangeRetention is how long record changes are kept. A sync
+// token expires when changes after it might have been deleted.
+const RecordChangeRetention = 30 * 24 * time.Hour
+
+const (
+	defaultRecordChangesLimit = 100
+	maxRecordChangesLimit     = 1000
+)
+
+// PruneRecordChanges deletes record changes older than
+// RecordChangeRetention.
+func PruneRecordChanges(conn skydb.Conn) error {
+	return conn.DeleteRecordChanges(timeNow().Add(-RecordChangeRetention))
+}
+
+// syncToken is the position in the change log up to which changes are
+// fetched by the client.
+type syncToken struct {
+	Cursor skydb.RecordChangeCursor
+
+	// Time is the time of the last change fetched, or the time the token
+	// is issued if there are no more changes. Changes after Cursor are
+	// not made before Time.
+	Time time.Time
+}
+
+func (t syncToken) String() string {
+	s := fmt.Sprintf("%d:%d:%d", t.Cursor.TxID, t.Cursor.ID, t.Time.Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func parseSyncToken(s string) (syncToken, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return syncToken{}, err
+	}
+
+	parts := strings.Split(string(b), ":")
+	if len(parts) != 3 {
+		return syncToken{}, fmt.Errorf("malformed sync token")
+	}
+
+	txID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return syncToken{}, err
+	}
+	changeID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return syncToken{}, err
+	}
+	unix, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return syncToken{}, err
+	}
+
+	return syncToken{
+		Cursor: skydb.RecordChangeCursor{TxID: txID, ID: changeID},
+		Time:   time.Unix(unix, 0).UTC(),
+	}, nil
+}
+
+type recordChangesPayload struct {
+	RecordType   string `mapstructure:"record_type"`
+	RawSyncToken string `mapstructure:"sync_token"`
+	Limit        int    `mapstructure:"limit"`
+	syncToken    *syncToken
+}
+
+func (payload *recordChangesPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *recordChangesPayload) Validate() skyerr.Error {
+	if payload.RecordType == "" {
+		return skyerr.NewInvalidArgument("empty record type", []string{"record_type"})
+	}
+
+	if payload.Limit < 0 || payload.Limit > maxRecordChangesLimit {
+		return skyerr.NewInvalidArgument(
+			fmt.Sprintf("limit must be between 1 and %d", maxRecordChangesLimit),
+			[]string{"limit"},
+		)
+	} else if payload.Limit == 0 {
+		payload.Limit = defaultRecordChangesLimit
+	}
+
+	if payload.RawSyncToken != "" {
+		token, err := parseSyncToken(payload.RawSyncToken)
+		if err != nil {
+			return skyerr.NewInvalidArgument("invalid sync_token", []string{"sync_token"})
+		}
+		payload.syncToken = &token
+	}
+
+	return nil
+}
+
+type recordChange struct {
+	Event    skydb.RecordChangeEvent `json:"event"`
+	RecordID string                  `json:"record_id"`
+	Record   *skyconv.JSONRecord     `json:"record,omitempty"`
+}
+
+type recordChangesResult struct {
+	Changes   []recordChange `json:"changes"`
+	SyncToken string         `json:"sync_token"`
+	HasMore   bool           `json:"has_more"`
+}
+
+/*
+RecordChangesHandler returns changes made to records of a record type
+since a sync token, for clients to sync records incrementally.
+
+Changes are ordered by the time they are made, and multiple changes to a
+record are collapsed into the last one. Changes made in a transaction
+are returned after all transactions begun before it have ended, so that
+a change committed late is not skipped. A change of a record the user
+cannot read is omitted, except that an update making a record unreadable
+is returned as a delete without the record. The deletion of a record
+inheriting access from its ACL parent is returned to its owner only.
+
+Without sync_token, no changes are returned and the sync token of the
+latest change is returned. A client should obtain such a sync token
+before querying all records for the first time. If has_more is true,
+more changes can be fetched immediately with the returned sync token.
+
+A sync token expires after changes after it are deleted, in which case
+SyncTokenExpired is returned and the client should query all records
+again.
+
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:changes",
+    "access_token": "validToken",
+    "database_id": "_public",
+    "record_type": "note",
+    "sync_token": "MToxMjM6MTQ2MDU1MjMzMA",
+    "limit": 100
+}
+EOF
+*/
+type RecordChangesHandler struct {
+	AssetStore    asset.Store      `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectUser    router.Processor `preprocessor:"inject_user"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordChangesHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordChangesHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordChangesHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &recordChangesPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := rpayload.Database
+	if db.DatabaseType() == skydb.UnionDatabase {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "record changes of union database is not supported")
+		return
+	}
+
+	now := timeNow()
+	if payload.syncToken == nil {
+		cursor, err := rpayload.DBConn.GetLatestRecordChangeCursor()
+		if err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+		response.Result = recordChangesResult{
+			Changes:   []recordChange{},
+			SyncToken: syncToken{Cursor: cursor, Time: now}.String(),
+		}
+		return
+	}
+
+	token := *payload.syncToken
+	if token.Time.Before(now.Add(-RecordChangeRetention)) {
+		response.Err = skyerr.NewError(skyerr.SyncTokenExpired, "sync token has expired")
+		return
+	}
+
+	changes, err := db.GetRecordChanges(payload.RecordType, token.Cursor, uint64(payload.Limit+1))
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	hasMore := len(changes) > payload.Limit
+	if hasMore {
+		changes = changes[:payload.Limit]
+	}
+
+	nextToken := syncToken{Cursor: token.Cursor, Time: now}
+	if len(changes) > 0 {
+		lastChange := changes[len(changes)-1]
+		nextToken.Cursor = lastChange.Cursor()
+		if hasMore {
+			nextToken.Time = lastChange.ChangedAt
+		}
+	}
+
+	records, err := h.fetchChangedRecords(db, rpayload.DBConn, changes)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	fetcher := newRecordFetcher(db, rpayload.DBConn, rpayload.HasMasterKey())
+	results := []recordChange{}
+	for _, change := range changes {
+		result, ok, err := h.filterChange(fetcher, rpayload.UserInfo, change, records[change.RecordID])
+		if err != nil {
+			response.Err = err
+			return
+		}
+		if ok {
+			results = append(results, result)
+		}
+	}
+
+	response.Result = recordChangesResult{
+		Changes:   results,
+		SyncToken: nextToken.String(),
+		HasMore:   hasMore,
+	}
+}
+
+// fetchChangedRecords returns the current records of changes that are
+// not deletion.
+func (h *RecordChangesHandler) fetchChangedRecords(db skydb.Database, conn skydb.Conn, changes []skydb.RecordChange) (map[skydb.RecordID]*skydb.Record, error) {
+	ids := []skydb.RecordID{}
+	for _, change := range changes {
+		if change.Event != skydb.RecordChangeDelete {
+			ids = append(ids, change.RecordID)
+		}
+	}
+
+	recordMap := map[skydb.RecordID]*skydb.Record{}
+	if len(ids) == 0 {
+		return recordMap, nil
+	}
+
+	results, err := db.GetByIDs(ids)
+	if err == skydb.ErrRecordNotFound {
+		return recordMap, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	records := []skydb.Record{}
+	for results.Scan() {
+		records = append(records, results.Record())
+	}
+	if err := results.Err(); err != nil {
+		return nil, err
+	}
+
+	makeAssetsComplete(db, conn, records)
+	for i := range records {
+		recordMap[records[i].ID] = &records[i]
+	}
+	return recordMap, nil
+}
+
+// filterChange returns the change to be returned to the user, and
+// whether it should be returned at all.
+func (h *RecordChangesHandler) filterChange(fetcher recordFetcher, userInfo *skydb.UserInfo, change skydb.RecordChange, record *skydb.Record) (recordChange, bool, skyerr.Error) {
+	result := recordChange{
+		Event:    change.Event,
+		RecordID: change.RecordID.String(),
+	}
+
+	if change.Event == skydb.RecordChangeDelete {
+		accessible, err := h.deletionAccessible(fetcher, userInfo, change)
+		return result, accessible, err
+	}
+
+	if record == nil {
+		// The record is deleted after the change is fetched, which is
+		// returned in later changes.
+		return result, false, nil
+	}
+
+	accessible, err := fetcher.accessible(record, userInfo, skydb.ReadLevel)
+	if err != nil {
+		return result, false, err
+	}
+	if accessible {
+		accessible, err = fetcher.matchRecordPolicy(record, userInfo)
+		if err != nil {
+			return result, false, err
+		}
+	}
+
+	if !accessible {
+		// The user might have fetched the record before it becomes
+		// unreadable.
+		result.Event = skydb.RecordChangeDelete
+		return result, change.Event == skydb.RecordChangeUpdate, nil
+	}
+
+	injectSigner(record, h.AssetStore)
+	result.Record = (*skyconv.JSONRecord)(record)
+	return result, true, nil
+}
+
+// deletionAccessible returns true if the deletion of a record can be
+// returned to the user.
+//
+// Access of a deleted record is checked with its owner and ACL at the
+// time of deletion. The ACL parent of a record without ACL cannot be
+// resolved once the record is deleted, so such deletion is returned only
+// to the owner. Record policy is matched against the owner, as other
+// fields of the deleted record are not kept.
+func (h *RecordChangesHandler) deletionAccessible(fetcher recordFetcher, userInfo *skydb.UserInfo, change skydb.RecordChange) (bool, skyerr.Error) {
+	if fetcher.withMasterKey {
+		return true, nil
+	}
+
+	deleted := skydb.Record{
+		ID:      change.RecordID,
+		OwnerID: change.OwnerID,
+		ACL:     change.ACL,
+	}
+
+	if deleted.ACL == nil {
+		aclParent, err := fetcher.getRecordACLParent(change.RecordID.Type)
+		if err != nil {
+			return false, skyerr.MakeError(err)
+		}
+		if !aclParent.IsEmpty() && (userInfo == nil || userInfo.ID != deleted.OwnerID) {
+			return false, nil
+		}
+	}
+
+	if !deleted.Accessible(userInfo, skydb.ReadLevel) {
+		return false, nil
+	}
+
+	return fetcher.matchRecordPolicy(&deleted, userInfo)
+}
@@ -0,0 +1,376 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recordChangeConn struct {
+	latestCursor skydb.RecordChangeCursor
+	*skydbtest.MapConn
+}
+
+func (conn *recordChangeConn) GetLatestRecordChangeCursor() (skydb.RecordChangeCursor, error) {
+	return conn.latestCursor, nil
+}
+
+type recordChangeDatabase struct {
+	changes []skydb.RecordChange
+	*skydbtest.MapDB
+}
+
+func (db *recordChangeDatabase) GetRecordChanges(recordType string, since skydb.RecordChangeCursor, limit uint64) ([]skydb.RecordChange, error) {
+	changes := []skydb.RecordChange{}
+	for _, change := range db.changes {
+		after := change.TxID > since.TxID || change.TxID == since.TxID && change.ID > since.ID
+		if change.RecordID.Type == recordType && after && uint64(len(changes)) < limit {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func (db *recordChangeDatabase) GetByIDs(ids []skydb.RecordID) (*skydb.Rows, error) {
+	records := []skydb.Record{}
+	for _, id := range ids {
+		if record, ok := db.RecordMap[id.String()]; ok {
+			records = append(records, record)
+		}
+	}
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+func TestSyncToken(t *testing.T) {
+	Convey("syncToken", t, func() {
+		token := syncToken{
+			Cursor: skydb.RecordChangeCursor{TxID: 1, ID: 123},
+			Time:   time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+		}
+
+		parsed, err := parseSyncToken(token.String())
+		So(err, ShouldBeNil)
+		So(parsed, ShouldResemble, token)
+
+		_, err = parseSyncToken("MTIz")
+		So(err, ShouldNotBeNil)
+		_, err = parseSyncToken("MTIzOjE0NjA1NTIzMzA")
+		So(err, ShouldNotBeNil)
+		_, err = parseSyncToken("not a token")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRecordChangesHandler(t *testing.T) {
+	Convey("RecordChangesHandler", t, func() {
+		now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		timeNow = func() time.Time { return now }
+		defer func() {
+			timeNow = timeNowUTC
+		}()
+
+		onlyUser2 := skydb.NewRecordACL([]skydb.RecordACLEntry{
+			skydb.NewRecordACLEntryDirect("user2", skydb.ReadLevel),
+		})
+
+		conn := &recordChangeConn{skydb.RecordChangeCursor{TxID: 7, ID: 42}, skydbtest.NewMapConn()}
+		db := &recordChangeDatabase{
+			changes: []skydb.RecordChange{
+				{
+					ID:        1,
+					TxID:      5,
+					RecordID:  skydb.NewRecordID("note", "created"),
+					Event:     skydb.RecordChangeCreate,
+					ChangedAt: now.Add(-3 * time.Hour),
+				},
+				{
+					ID:        2,
+					TxID:      5,
+					RecordID:  skydb.NewRecordID("note", "unreadable"),
+					Event:     skydb.RecordChangeUpdate,
+					ChangedAt: now.Add(-2 * time.Hour),
+				},
+				{
+					ID:        3,
+					TxID:      5,
+					RecordID:  skydb.NewRecordID("note", "unreadable-created"),
+					Event:     skydb.RecordChangeCreate,
+					ChangedAt: now.Add(-2 * time.Hour),
+				},
+				{
+					ID:        4,
+					TxID:      5,
+					RecordID:  skydb.NewRecordID("note", "deleted"),
+					Event:     skydb.RecordChangeDelete,
+					ChangedAt: now.Add(-time.Hour),
+					OwnerID:   "user1",
+				},
+				{
+					ID:        5,
+					TxID:      5,
+					RecordID:  skydb.NewRecordID("note", "unreadable-deleted"),
+					Event:     skydb.RecordChangeDelete,
+					ChangedAt: now.Add(-time.Hour),
+					OwnerID:   "user2",
+					ACL:       onlyUser2,
+				},
+				{
+					ID:        6,
+					TxID:      6,
+					RecordID:  skydb.NewRecordID("task", "deleted"),
+					Event:     skydb.RecordChangeDelete,
+					ChangedAt: now.Add(-time.Hour),
+					OwnerID:   "user1",
+				},
+			},
+			MapDB: skydbtest.NewMapDB(),
+		}
+		db.RecordMap["note/created"] = skydb.Record{
+			ID:      skydb.NewRecordID("note", "created"),
+			OwnerID: "user1",
+			Data:    map[string]interface{}{"title": "Hello"},
+		}
+		db.RecordMap["note/unreadable"] = skydb.Record{
+			ID:      skydb.NewRecordID("note", "unreadable"),
+			OwnerID: "user2",
+			ACL:     onlyUser2,
+		}
+		db.RecordMap["note/unreadable-created"] = skydb.Record{
+			ID:      skydb.NewRecordID("note", "unreadable-created"),
+			OwnerID: "user2",
+			ACL:     onlyUser2,
+		}
+
+		r := handlertest.NewSingleRouteRouter(&RecordChangesHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+			p.UserInfo = &skydb.UserInfo{ID: "user1"}
+		})
+
+		Convey("returns latest sync token without sync token", func() {
+			resp := r.POST(`{"record_type": "note"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, fmt.Sprintf(`{
+				"result": {
+					"changes": [],
+					"sync_token": "%s",
+					"has_more": false
+				}
+			}`, syncToken{skydb.RecordChangeCursor{TxID: 7, ID: 42}, now}))
+		})
+
+		Convey("returns readable changes since sync token", func() {
+			token := syncToken{skydb.RecordChangeCursor{}, now.Add(-24 * time.Hour)}
+			resp := r.POST(fmt.Sprintf(`{
+				"record_type": "note",
+				"sync_token": "%s"
+			}`, token))
+			So(resp.Body.Bytes(), ShouldEqualJSON, fmt.Sprintf(`{
+				"result": {
+					"changes": [{
+						"event": "create",
+						"record_id": "note/created",
+						"record": {
+							"_id": "note/created",
+							"_type": "record",
+							"_access": null,
+							"_ownerID": "user1",
+							"title": "Hello"
+						}
+					}, {
+						"event": "delete",
+						"record_id": "note/unreadable"
+					}, {
+						"event": "delete",
+						"record_id": "note/deleted"
+					}],
+					"sync_token": "%s",
+					"has_more": false
+				}
+			}`, syncToken{skydb.RecordChangeCursor{TxID: 5, ID: 5}, now}))
+		})
+
+		Convey("returns changes in pages", func() {
+			token := syncToken{skydb.RecordChangeCursor{}, now.Add(-24 * time.Hour)}
+			resp := r.POST(fmt.Sprintf(`{
+				"record_type": "note",
+				"sync_token": "%s",
+				"limit": 2
+			}`, token))
+			So(resp.Body.Bytes(), ShouldEqualJSON, fmt.Sprintf(`{
+				"result": {
+					"changes": [{
+						"event": "create",
+						"record_id": "note/created",
+						"record": {
+							"_id": "note/created",
+							"_type": "record",
+							"_access": null,
+							"_ownerID": "user1",
+							"title": "Hello"
+						}
+					}, {
+						"event": "delete",
+						"record_id": "note/unreadable"
+					}],
+					"sync_token": "%s",
+					"has_more": true
+				}
+			}`, syncToken{skydb.RecordChangeCursor{TxID: 5, ID: 2}, now.Add(-2 * time.Hour)}))
+		})
+
+		Convey("returns no changes for other record type", func() {
+			token := syncToken{skydb.RecordChangeCursor{}, now.Add(-24 * time.Hour)}
+			resp := r.POST(fmt.Sprintf(`{
+				"record_type": "comment",
+				"sync_token": "%s"
+			}`, token))
+			So(resp.Body.Bytes(), ShouldEqualJSON, fmt.Sprintf(`{
+				"result": {
+					"changes": [],
+					"sync_token": "%s",
+					"has_more": false
+				}
+			}`, syncToken{skydb.RecordChangeCursor{}, now}))
+		})
+
+		Convey("returns deletion of record under ACL parent only to the owner", func() {
+			conn.SetRecordACLParent("task", skydb.RecordACLParent{Field: "project"})
+			token := syncToken{skydb.RecordChangeCursor{}, now.Add(-24 * time.Hour)}
+			body := fmt.Sprintf(`{
+				"record_type": "task",
+				"sync_token": "%s"
+			}`, token)
+
+			resp := r.POST(body)
+			So(resp.Body.Bytes(), ShouldEqualJSON, fmt.Sprintf(`{
+				"result": {
+					"changes": [{
+						"event": "delete",
+						"record_id": "task/deleted"
+					}],
+					"sync_token": "%s",
+					"has_more": false
+				}
+			}`, syncToken{skydb.RecordChangeCursor{TxID: 6, ID: 6}, now}))
+
+			resp = handlertest.NewSingleRouteRouter(&RecordChangesHandler{}, func(p *router.Payload) {
+				p.DBConn = conn
+				p.Database = db
+				p.UserInfo = &skydb.UserInfo{ID: "user2"}
+			}).POST(body)
+			So(resp.Body.Bytes(), ShouldEqualJSON, fmt.Sprintf(`{
+				"result": {
+					"changes": [],
+					"sync_token": "%s",
+					"has_more": false
+				}
+			}`, syncToken{skydb.RecordChangeCursor{TxID: 6, ID: 6}, now}))
+		})
+
+		Convey("returns deletion of record satisfying record policy", func() {
+			conn.SetRecordPolicy("task", skydb.Predicate{
+				Operator: skydb.NotEqual,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "_owner_id"},
+					skydb.Expression{Type: skydb.Function, Value: skydb.CurrentUserFunc{}},
+				},
+			})
+			token := syncToken{skydb.RecordChangeCursor{}, now.Add(-24 * time.Hour)}
+			body := fmt.Sprintf(`{
+				"record_type": "task",
+				"sync_token": "%s"
+			}`, token)
+
+			resp := r.POST(body)
+			So(resp.Body.Bytes(), ShouldEqualJSON, fmt.Sprintf(`{
+				"result": {
+					"changes": [],
+					"sync_token": "%s",
+					"has_more": false
+				}
+			}`, syncToken{skydb.RecordChangeCursor{TxID: 6, ID: 6}, now}))
+
+			resp = handlertest.NewSingleRouteRouter(&RecordChangesHandler{}, func(p *router.Payload) {
+				p.DBConn = conn
+				p.Database = db
+				p.UserInfo = &skydb.UserInfo{ID: "user2"}
+			}).POST(body)
+			So(resp.Body.Bytes(), ShouldEqualJSON, fmt.Sprintf(`{
+				"result": {
+					"changes": [{
+						"event": "delete",
+						"record_id": "task/deleted"
+					}],
+					"sync_token": "%s",
+					"has_more": false
+				}
+			}`, syncToken{skydb.RecordChangeCursor{TxID: 6, ID: 6}, now}))
+		})
+
+		Convey("returns error for expired sync token", func() {
+			token := syncToken{skydb.RecordChangeCursor{TxID: 5, ID: 1}, now.Add(-RecordChangeRetention - time.Second)}
+			resp := r.POST(fmt.Sprintf(`{
+				"record_type": "note",
+				"sync_token": "%s"
+			}`, token))
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 123,
+					"message": "sync token has expired",
+					"name": "SyncTokenExpired"
+				}
+			}`)
+		})
+
+		Convey("returns error for invalid sync token", func() {
+			resp := r.POST(`{
+				"record_type": "note",
+				"sync_token": "invalid"
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "invalid sync_token",
+					"name": "InvalidArgument",
+					"info": {"arguments": ["sync_token"]}
+				}
+			}`)
+		})
+
+		Convey("returns error for invalid limit", func() {
+			resp := r.POST(`{
+				"record_type": "note",
+				"limit": 1001
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "limit must be between 1 and 1000",
+					"name": "InvalidArgument",
+					"info": {"arguments": ["limit"]}
+				}
+			}`)
+		})
+	})
+}
@@ -72,6 +72,7 @@ func TestRecordDeleteHandler(t *testing.T) {
 		So(db.Save(&user), ShouldBeNil)
 
 		router := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
 			p.Database = db
 			p.UserInfo = &skydb.UserInfo{
 				ID: "user0",
@@ -529,6 +530,14 @@ func (db bogusFieldDatabaseConnection) GetRecordAccess(recordType string) (skydb
 	return skydb.NewRecordACL([]skydb.RecordACLEntry{}), nil
 }
 
+func (db bogusFieldDatabaseConnection) GetRecordPolicy(recordType string) (skydb.Predicate, error) {
+	return skydb.Predicate{}, nil
+}
+
+func (db bogusFieldDatabaseConnection) GetRecordACLParent(recordType string) (skydb.RecordACLParent, error) {
+	return skydb.RecordACLParent{}, nil
+}
+
 type bogusFieldDatabase struct {
 	SaveFunc func(record *skydb.Record) error
 	GetFunc  func(id skydb.RecordID, record *skydb.Record) error
@@ -1285,6 +1294,7 @@ func TestRecordOwnerIDSerialization(t *testing.T) {
 		}
 
 		injectDBFunc := func(payload *router.Payload) {
+			payload.DBConn = skydbtest.NewMapConn()
 			payload.Database = db
 			payload.UserInfo = &skydb.UserInfo{
 				ID: "ownerID",
@@ -1340,6 +1350,246 @@ func TestRecordOwnerIDSerialization(t *testing.T) {
 	})
 }
 
+func TestRecordPolicy(t *testing.T) {
+	Convey("Record Policy", t, func() {
+		db := skydbtest.NewMapDB()
+		conn := skydbtest.NewMapConn()
+		conn.SetRecordPolicy("note", skydb.Predicate{
+			Operator: skydb.Equal,
+			Children: []interface{}{
+				skydb.Expression{Type: skydb.KeyPath, Value: "_owner_id"},
+				skydb.Expression{Type: skydb.Function, Value: skydb.CurrentUserFunc{}},
+			},
+		})
+
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "mine"),
+			OwnerID: "user0",
+		}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "others"),
+			OwnerID: "user1",
+		}), ShouldBeNil)
+
+		injectDBFunc := func(payload *router.Payload) {
+			payload.DBConn = conn
+			payload.Database = db
+			payload.UserInfo = &skydb.UserInfo{
+				ID: "user0",
+			}
+		}
+
+		Convey("fetches record satisfying the policy", func() {
+			resp := handlertest.NewSingleRouteRouter(&RecordFetchHandler{}, injectDBFunc).POST(`{
+				"ids": ["note/mine", "note/others"]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/mine",
+					"_type": "record",
+					"_access": null,
+					"_ownerID": "user0"
+				}, {
+					"_id": "note/others",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to read",
+					"name": "PermissionDenied"
+				}]
+			}`)
+		})
+
+		Convey("cannot modify record not satisfying the policy", func() {
+			resp := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, injectDBFunc).POST(`{
+				"records": [{"_id": "note/others", "content": "hello"}]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/others",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to modify",
+					"name": "PermissionDenied"
+				}]
+			}`)
+		})
+
+		Convey("cannot delete record not satisfying the policy", func() {
+			resp := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, injectDBFunc).POST(`{
+				"ids": ["note/others"]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/others",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to delete",
+					"name": "PermissionDenied"
+				}]
+			}`)
+		})
+
+		Convey("ignores the policy with master key", func() {
+			resp := handlertest.NewSingleRouteRouter(&RecordFetchHandler{}, func(payload *router.Payload) {
+				injectDBFunc(payload)
+				payload.AccessKey = router.MasterAccessKey
+			}).POST(`{
+				"ids": ["note/others"]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/others",
+					"_type": "record",
+					"_access": null,
+					"_ownerID": "user1"
+				}]
+			}`)
+		})
+	})
+}
+
+type recordPolicyConn struct {
+	db skydb.Database
+	*skydbtest.MapConn
+}
+
+func (conn *recordPolicyConn) PublicDB() skydb.Database {
+	return conn.db
+}
+
+func TestRecordPolicyWithUnresolvedCurrentUser(t *testing.T) {
+	Convey("Record Policy with unresolved current user", t, func() {
+		db := skydbtest.NewMapDB()
+		conn := &recordPolicyConn{db, skydbtest.NewMapConn()}
+		db.DBConn = conn
+
+		ownerPolicy := skydb.Predicate{
+			Operator: skydb.Equal,
+			Children: []interface{}{
+				skydb.Expression{Type: skydb.KeyPath, Value: "_owner_id"},
+				skydb.Expression{Type: skydb.Function, Value: skydb.CurrentUserFunc{}},
+			},
+		}
+
+		So(db.Save(&skydb.Record{
+			ID: skydb.NewRecordID("user", "user0"),
+		}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "mine"),
+			OwnerID: "user0",
+			Data:    map[string]interface{}{"org": "oursky", "public": false},
+		}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "public"),
+			OwnerID: "user1",
+			Data:    map[string]interface{}{"org": "oursky", "public": true},
+		}), ShouldBeNil)
+
+		fetch := func(userInfo *skydb.UserInfo) []byte {
+			return handlertest.NewSingleRouteRouter(&RecordFetchHandler{}, func(payload *router.Payload) {
+				payload.DBConn = conn
+				payload.Database = db
+				payload.UserInfo = userInfo
+			}).POST(`{
+				"ids": ["note/mine", "note/public"]
+			}`).Body.Bytes()
+		}
+
+		Convey("fetches own record when the user record has no such field", func() {
+			conn.SetRecordPolicy("note", skydb.Predicate{
+				Operator: skydb.Or,
+				Children: []interface{}{
+					ownerPolicy,
+					skydb.Predicate{
+						Operator: skydb.Equal,
+						Children: []interface{}{
+							skydb.Expression{Type: skydb.KeyPath, Value: "org"},
+							skydb.Expression{Type: skydb.Function, Value: skydb.CurrentUserFunc{KeyPath: "org"}},
+						},
+					},
+				},
+			})
+
+			So(fetch(&skydb.UserInfo{ID: "user0"}), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/mine",
+					"_type": "record",
+					"_access": null,
+					"_ownerID": "user0",
+					"org": "oursky",
+					"public": false
+				}, {
+					"_id": "note/public",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to read",
+					"name": "PermissionDenied"
+				}]
+			}`)
+		})
+
+		Convey("fetches public record without user", func() {
+			conn.SetRecordPolicy("note", skydb.Predicate{
+				Operator: skydb.Or,
+				Children: []interface{}{
+					skydb.Predicate{
+						Operator: skydb.Equal,
+						Children: []interface{}{
+							skydb.Expression{Type: skydb.KeyPath, Value: "public"},
+							skydb.Expression{Type: skydb.Literal, Value: true},
+						},
+					},
+					ownerPolicy,
+				},
+			})
+
+			So(fetch(nil), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/mine",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to read",
+					"name": "PermissionDenied"
+				}, {
+					"_id": "note/public",
+					"_type": "record",
+					"_access": null,
+					"_ownerID": "user1",
+					"org": "oursky",
+					"public": true
+				}]
+			}`)
+		})
+
+		Convey("denies negated comparison without user", func() {
+			conn.SetRecordPolicy("note", skydb.Predicate{
+				Operator: skydb.Not,
+				Children: []interface{}{ownerPolicy},
+			})
+
+			So(fetch(nil), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/mine",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to read",
+					"name": "PermissionDenied"
+				}, {
+					"_id": "note/public",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to read",
+					"name": "PermissionDenied"
+				}]
+			}`)
+		})
+	})
+}
+
 func TestRecordMetaData(t *testing.T) {
 	Convey("Record Meta Data", t, func() {
 		db := skydbtest.NewMapDB()
@@ -1445,6 +1695,10 @@ func (s *urlOnlyAssetStore) GeneratePostFileRequest(name string) (*asset.PostFil
 	panic("not implemented")
 }
 
+func (s *urlOnlyAssetStore) Delete(name string) error {
+	panic("not implemented")
+}
+
 func (s *urlOnlyAssetStore) SignedURL(name string) (string, error) {
 	return fmt.Sprintf("http://skygear.test/asset/%s?expiredAt=1997-07-01T00:00:00", name), nil
 }
@@ -1471,6 +1725,7 @@ func TestRecordAssetSerialization(t *testing.T) {
 		r := handlertest.NewSingleRouteRouter(&RecordFetchHandler{
 			AssetStore: assetStore,
 		}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
 			p.Database = db
 		})
 
@@ -1513,6 +1768,7 @@ func TestRecordAssetSerialization(t *testing.T) {
 		r := handlertest.NewSingleRouteRouter(&RecordQueryHandler{
 			AssetStore: assetStore,
 		}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
 			p.Database = db
 		})
 
@@ -1671,6 +1927,7 @@ func TestRecordQueryWithEagerLoad(t *testing.T) {
 		}
 
 		injectDBFunc := func(payload *router.Payload) {
+			payload.DBConn = skydbtest.NewMapConn()
 			payload.Database = db
 		}
 
@@ -1770,6 +2027,7 @@ func TestRecordQueryWithEagerLoad(t *testing.T) {
 		}
 
 		injectDBFunc := func(payload *router.Payload) {
+			payload.DBConn = skydbtest.NewMapConn()
 			payload.Database = db
 		}
 
@@ -1915,6 +2173,7 @@ func TestHookExecution(t *testing.T) {
 				So(db.Save(record), ShouldBeNil)
 
 				r := handlertest.NewSingleRouteRouter(test.handler, func(p *router.Payload) {
+					p.DBConn = skydbtest.NewMapConn()
 					p.Database = db
 					p.UserInfo = &skydb.UserInfo{
 						ID: "user0",
@@ -2276,6 +2535,7 @@ func TestAtomicOperation(t *testing.T) {
 			}), ShouldBeNil)
 
 			r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, func(payload *router.Payload) {
+				payload.DBConn = conn
 				payload.Database = db
 				payload.UserInfo = &skydb.UserInfo{
 					ID: "user0",
@@ -2397,3 +2657,112 @@ func TestDeriveDeltaRecord(t *testing.T) {
 		})
 	})
 }
+
+func TestRecordACLParent(t *testing.T) {
+	Convey("Record ACL Parent", t, func() {
+		db := skydbtest.NewMapDB()
+		conn := skydbtest.NewMapConn()
+		conn.SetRecordACLParent("comment", skydb.RecordACLParent{Field: "post"})
+		conn.SetRecordACLParent("node", skydb.RecordACLParent{Field: "parent"})
+
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("post", "public"),
+			OwnerID: "user1",
+			ACL: skydb.RecordACL{
+				skydb.NewRecordACLEntryPublic(skydb.ReadLevel),
+			},
+		}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("post", "private"),
+			OwnerID: "user1",
+			ACL: skydb.RecordACL{
+				skydb.NewRecordACLEntryDirect("user1", skydb.ReadLevel),
+			},
+		}), ShouldBeNil)
+		for key, post := range map[string]string{
+			"public":  "public",
+			"private": "private",
+			"missing": "missing",
+		} {
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("comment", key),
+				OwnerID: "user1",
+				Data: skydb.Data{
+					"post": skydb.NewReference("post", post),
+				},
+			}), ShouldBeNil)
+		}
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("node", "a"),
+			OwnerID: "user1",
+			Data: skydb.Data{
+				"parent": skydb.NewReference("node", "b"),
+			},
+		}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("node", "b"),
+			OwnerID: "user1",
+			Data: skydb.Data{
+				"parent": skydb.NewReference("node", "a"),
+			},
+		}), ShouldBeNil)
+
+		injectDBFunc := func(payload *router.Payload) {
+			payload.DBConn = conn
+			payload.Database = db
+			payload.UserInfo = &skydb.UserInfo{
+				ID: "user0",
+			}
+		}
+
+		Convey("fetches record inheriting access of its ACL parent", func() {
+			resp := handlertest.NewSingleRouteRouter(&RecordFetchHandler{}, injectDBFunc).POST(`{
+				"ids": ["comment/public", "comment/private", "comment/missing", "node/a"]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "comment/public",
+					"_type": "record",
+					"_access": null,
+					"_ownerID": "user1",
+					"post": {"$id": "post/public", "$type": "ref"}
+				}, {
+					"_id": "comment/private",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to read",
+					"name": "PermissionDenied"
+				}, {
+					"_id": "comment/missing",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to read",
+					"name": "PermissionDenied"
+				}, {
+					"_id": "node/a",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to read",
+					"name": "PermissionDenied"
+				}]
+			}`)
+		})
+
+		Convey("cannot modify record whose ACL parent is not writable", func() {
+			resp := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, injectDBFunc).POST(`{
+				"records": [{"_id": "comment/public", "content": "hello"}]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "comment/public",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to modify",
+					"name": "PermissionDenied"
+				}]
+			}`)
+		})
+	})
+}
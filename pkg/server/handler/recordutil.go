@@ -108,6 +108,8 @@ type recordFetcher struct {
 	conn                   skydb.Conn
 	withMasterKey          bool
 	creationAccessCacheMap map[string]skydb.RecordACL
+	policyCacheMap         map[string]skydb.Predicate
+	aclParentCacheMap      map[string]skydb.RecordACLParent
 }
 
 func newRecordFetcher(db skydb.Database, conn skydb.Conn, withMasterKey bool) recordFetcher {
@@ -116,6 +118,8 @@ func newRecordFetcher(db skydb.Database, conn skydb.Conn, withMasterKey bool) re
 		conn:                   conn,
 		withMasterKey:          withMasterKey,
 		creationAccessCacheMap: map[string]skydb.RecordACL{},
+		policyCacheMap:         map[string]skydb.Predicate{},
+		aclParentCacheMap:      map[string]skydb.RecordACLParent{},
 	}
 }
 
@@ -133,6 +137,95 @@ func (f recordFetcher) getCreationAccess(recordType string) skydb.RecordACL {
 	return creationAccess
 }
 
+// getRecordPolicy returns the record policy of the record type bound with
+// the specified user. Record policy only applies to public database.
+func (f recordFetcher) getRecordPolicy(recordType string, userInfo *skydb.UserInfo) (skydb.Predicate, error) {
+	if f.withMasterKey {
+		return skydb.Predicate{}, nil
+	}
+
+	if policy, ok := f.policyCacheMap[recordType]; ok {
+		return policy, nil
+	}
+
+	policy, err := f.conn.GetRecordPolicy(recordType)
+	if err != nil {
+		return skydb.Predicate{}, err
+	}
+
+	if policy.IsEmpty() || f.db.DatabaseType() != skydb.PublicDatabase {
+		f.policyCacheMap[recordType] = skydb.Predicate{}
+		return skydb.Predicate{}, nil
+	}
+
+	policy, err = skydb.ResolveCurrentUser(f.db, policy, userInfo)
+	if err != nil {
+		return skydb.Predicate{}, err
+	}
+
+	f.policyCacheMap[recordType] = policy
+	return policy, nil
+}
+
+// matchRecordPolicy returns true if the record satisfies the record policy
+// of its record type.
+func (f recordFetcher) matchRecordPolicy(record *skydb.Record, userInfo *skydb.UserInfo) (bool, skyerr.Error) {
+	policy, err := f.getRecordPolicy(record.ID.Type, userInfo)
+	if err != nil {
+		return false, skyerr.MakeError(err)
+	}
+
+	return policy.MatchRecord(record), nil
+}
+
+// getRecordACLParent returns the ACL parent of the record type. ACL parent
+// only applies to public database.
+func (f recordFetcher) getRecordACLParent(recordType string) (skydb.RecordACLParent, error) {
+	if aclParent, ok := f.aclParentCacheMap[recordType]; ok {
+		return aclParent, nil
+	}
+
+	aclParent, err := f.conn.GetRecordACLParent(recordType)
+	if err != nil {
+		return skydb.RecordACLParent{}, err
+	}
+
+	if !aclParent.IsEmpty() && f.db.DatabaseType() != skydb.PublicDatabase {
+		aclParent = skydb.RecordACLParent{}
+	}
+
+	f.aclParentCacheMap[recordType] = aclParent
+	return aclParent, nil
+}
+
+// accessible returns true if the record is accessible by the user at the
+// specified level. The access of a record without ACL is inherited from its
+// ACL parent, if the record type has one.
+//
+// A record is not accessible if its ACL parents cannot be resolved.
+func (f recordFetcher) accessible(record *skydb.Record, userInfo *skydb.UserInfo, level skydb.ACLLevel) (bool, skyerr.Error) {
+	if f.withMasterKey {
+		return true, nil
+	}
+
+	if record.ACL == nil && record.ACLParent == nil {
+		err := skydb.ResolveACLParent(f.db, record, f.getRecordACLParent)
+		switch err {
+		case nil:
+		case skydb.ErrACLParentCycle, skydb.ErrACLParentTooDeep, skydb.ErrRecordNotFound:
+			log.WithFields(logrus.Fields{
+				"recordID": record.ID,
+				"err":      err,
+			}).Warnln("Failed to resolve ACL parent")
+			return false, nil
+		default:
+			return false, skyerr.MakeError(err)
+		}
+	}
+
+	return record.Accessible(userInfo, level), nil
+}
+
 func (f recordFetcher) fetchOrCreateRecord(recordID skydb.RecordID, userInfo *skydb.UserInfo) (record *skydb.Record, err skyerr.Error) {
 	dbRecord := skydb.Record{}
 	if dbErr := f.db.Get(recordID, &dbRecord); dbErr != nil {
@@ -156,7 +249,20 @@ func (f recordFetcher) fetchOrCreateRecord(recordID skydb.RecordID, userInfo *sk
 	}
 
 	record = &dbRecord
-	if !f.withMasterKey && !dbRecord.Accessible(userInfo, skydb.WriteLevel) {
+	if accessible, accessErr := f.accessible(&dbRecord, userInfo, skydb.WriteLevel); accessErr != nil {
+		err = accessErr
+		return
+	} else if !accessible {
+		err = skyerr.NewError(
+			skyerr.PermissionDenied,
+			"no permission to modify",
+		)
+		return
+	}
+
+	if matched, policyErr := f.matchRecordPolicy(&dbRecord, userInfo); policyErr != nil {
+		err = policyErr
+	} else if !matched {
 		err = skyerr.NewError(
 			skyerr.PermissionDenied,
 			"no permission to modify",
@@ -257,6 +363,15 @@ func recordSaveHandler(req *recordModifyRequest, resp *recordModifyResponse) sky
 		record.UpdatedAt = now
 		record.UpdaterID = req.UserInfo.ID
 
+		if matched, policyErr := fetcher.matchRecordPolicy(record, req.UserInfo); policyErr != nil {
+			return policyErr
+		} else if !matched {
+			return skyerr.NewError(
+				skyerr.PermissionDenied,
+				"record does not satisfy the record policy",
+			)
+		}
+
 		deriveDeltaRecord(&deltaRecord, originalRecord, record)
 
 		if dbErr := db.Save(&deltaRecord); dbErr != nil {
@@ -405,6 +520,8 @@ func recordDeleteHandler(req *recordModifyRequest, resp *recordModifyResponse) s
 	db := req.Db
 	recordIDs := req.RecordIDsToDelete
 
+	fetcher := newRecordFetcher(db, req.Conn, req.WithMasterKey)
+
 	var records []*skydb.Record
 	for _, recordID := range recordIDs {
 		if recordID.Type == db.UserRecordType() {
@@ -419,15 +536,22 @@ func recordDeleteHandler(req *recordModifyRequest, resp *recordModifyResponse) s
 			} else {
 				resp.ErrMap[recordID] = skyerr.MakeError(dbErr)
 			}
+		} else if accessible, err := fetcher.accessible(&record, req.UserInfo, skydb.WriteLevel); err != nil {
+			resp.ErrMap[recordID] = err
+		} else if !accessible {
+			resp.ErrMap[recordID] = skyerr.NewError(
+				skyerr.PermissionDenied,
+				"no permission to delete",
+			)
+		} else if matched, err := fetcher.matchRecordPolicy(&record, req.UserInfo); err != nil {
+			resp.ErrMap[recordID] = err
+		} else if !matched {
+			resp.ErrMap[recordID] = skyerr.NewError(
+				skyerr.PermissionDenied,
+				"no permission to delete",
+			)
 		} else {
-			if req.WithMasterKey || record.Accessible(req.UserInfo, skydb.WriteLevel) {
-				records = append(records, &record)
-			} else {
-				resp.ErrMap[recordID] = skyerr.NewError(
-					skyerr.PermissionDenied,
-					"no permission to delete",
-				)
-			}
+			records = append(records, &record)
 		}
 	}
 
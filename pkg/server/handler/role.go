@@ -18,6 +18,7 @@ import (
 	"github.com/mitchellh/mapstructure"
 
 	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 )
 
@@ -153,3 +154,384 @@ func (h *RoleAdminHandler) Handle(rpayload *router.Payload, response *router.Res
 	}
 	response.Result = payload.Roles
 }
+
+func roleError(err error) skyerr.Error {
+	switch err {
+	case skydb.ErrRoleDuplicated:
+		return skyerr.NewError(skyerr.Duplicated, "role already exists")
+	case skydb.ErrRoleNotFound:
+		return skyerr.NewError(skyerr.ResourceNotFound, "role not found")
+	case skydb.ErrUserNotFound:
+		return skyerr.NewError(skyerr.ResourceNotFound, "user not found")
+	}
+	return skyerr.MakeError(err)
+}
+
+type roleCreatePayload struct {
+	Name        string   `mapstructure:"name"`
+	Parent      string   `mapstructure:"parent"`
+	Permissions []string `mapstructure:"permissions"`
+}
+
+func (payload *roleCreatePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *roleCreatePayload) Validate() skyerr.Error {
+	if payload.Name == "" {
+		return skyerr.NewInvalidArgument("unspecified role name in request", []string{"name"})
+	}
+	if payload.Parent == payload.Name {
+		return skyerr.NewInvalidArgument("role cannot be the parent of itself", []string{"parent"})
+	}
+	return nil
+}
+
+// RoleCreateHandler enable system administrator to create a role, optionally
+// with a parent role and permissions granted to its members.
+//
+// Members of a role are also regarded as members of its parent role.
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "role:create",
+//     "master_key": "MASTER_KEY",
+//     "name": "editor",
+//     "parent": "writer",
+//     "permissions": [
+//        "post:publish"
+//     ]
+// }
+// EOF
+//
+// {
+//     "result": {
+//         "name": "editor",
+//         "parent": "writer",
+//         "permissions": [
+//            "post:publish"
+//         ],
+//         "is_admin": false,
+//         "by_default": false
+//     }
+// }
+type RoleCreateHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RoleCreateHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *RoleCreateHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RoleCreateHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &roleCreatePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	role := skydb.Role{
+		Name:        payload.Name,
+		Parent:      payload.Parent,
+		Permissions: payload.Permissions,
+	}
+	if err := rpayload.DBConn.CreateRole(&role); err != nil {
+		response.Err = roleError(err)
+		return
+	}
+	response.Result = role
+}
+
+// RoleListHandler returns all roles with their parent and permissions.
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "role:list",
+//     "master_key": "MASTER_KEY"
+// }
+// EOF
+//
+// {
+//     "result": [{
+//         "name": "editor",
+//         "parent": "writer",
+//         "permissions": [
+//            "post:publish"
+//         ],
+//         "is_admin": false,
+//         "by_default": false
+//     }]
+// }
+type RoleListHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RoleListHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *RoleListHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RoleListHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	roles, err := rpayload.DBConn.GetRoles()
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	response.Result = roles
+}
+
+type roleDeletePayload struct {
+	Name string `mapstructure:"name"`
+}
+
+func (payload *roleDeletePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *roleDeletePayload) Validate() skyerr.Error {
+	if payload.Name == "" {
+		return skyerr.NewInvalidArgument("unspecified role name in request", []string{"name"})
+	}
+	return nil
+}
+
+// RoleDeleteHandler enable system administrator to delete a role. The role
+// is revoked from all users, and children of the role lose their parent.
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "role:delete",
+//     "master_key": "MASTER_KEY",
+//     "name": "editor"
+// }
+// EOF
+//
+// {
+//     "result": {
+//         "name": "editor"
+//     }
+// }
+type RoleDeleteHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RoleDeleteHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *RoleDeleteHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RoleDeleteHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &roleDeletePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	txDB, ok := rpayload.Database.(skydb.TxDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database impl does not support transaction")
+		return
+	}
+
+	txErr := withTransaction(txDB, func() error {
+		return rpayload.DBConn.DeleteRole(payload.Name)
+	})
+	if txErr != nil {
+		response.Err = roleError(txErr)
+		return
+	}
+	response.Result = map[string]interface{}{
+		"name": payload.Name,
+	}
+}
+
+type roleAssignPayload struct {
+	Roles   []string `mapstructure:"roles" json:"roles"`
+	UserIDs []string `mapstructure:"users" json:"users"`
+}
+
+func (payload *roleAssignPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *roleAssignPayload) Validate() skyerr.Error {
+	if len(payload.Roles) == 0 {
+		return skyerr.NewInvalidArgument("unspecified roles in request", []string{"roles"})
+	}
+	if len(payload.UserIDs) == 0 {
+		return skyerr.NewInvalidArgument("unspecified users in request", []string{"users"})
+	}
+	return nil
+}
+
+// RoleAssignHandler enable system administrator to assign roles to users.
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "role:assign",
+//     "master_key": "MASTER_KEY",
+//     "roles": [
+//        "editor"
+//     ],
+//     "users": [
+//        "95db1e34-0cc0-47b0-8a97-3948633ce09f"
+//     ]
+// }
+// EOF
+//
+// {
+//     "result": {
+//         "roles": [
+//            "editor"
+//         ],
+//         "users": [
+//            "95db1e34-0cc0-47b0-8a97-3948633ce09f"
+//         ]
+//     }
+// }
+type RoleAssignHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RoleAssignHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *RoleAssignHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RoleAssignHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &roleAssignPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if err := rpayload.DBConn.AssignUserRoles(payload.UserIDs, payload.Roles); err != nil {
+		response.Err = roleError(err)
+		return
+	}
+	response.Result = payload
+}
+
+// RoleRevokeHandler enable system administrator to revoke roles from users.
+// curl -X POST -H "Content-Type: application/json" \
+//   -d @- http://localhost:3000/ <<EOF
+// {
+//     "action": "role:revoke",
+//     "master_key": "MASTER_KEY",
+//     "roles": [
+//        "editor"
+//     ],
+//     "users": [
+//        "95db1e34-0cc0-47b0-8a97-3948633ce09f"
+//     ]
+// }
+// EOF
+//
+// {
+//     "result": {
+//         "roles": [
+//            "editor"
+//         ],
+//         "users": [
+//            "95db1e34-0cc0-47b0-8a97-3948633ce09f"
+//         ]
+//     }
+// }
+type RoleRevokeHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RoleRevokeHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *RoleRevokeHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RoleRevokeHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &roleAssignPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if err := rpayload.DBConn.RevokeUserRoles(payload.UserIDs, payload.Roles); err != nil {
+		response.Err = roleError(err)
+		return
+	}
+	response.Result = payload
+}
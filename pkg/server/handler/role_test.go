@@ -24,6 +24,7 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
 )
 
 func TestRolePayload(t *testing.T) {
@@ -175,3 +176,218 @@ func TestRoleAdminHandler(t *testing.T) {
 		})
 	})
 }
+
+func TestRoleCreateHandler(t *testing.T) {
+	Convey("RoleCreateHandler", t, func() {
+		conn := skydbtest.NewMapConn()
+		conn.CreateRole(&skydb.Role{Name: "writer"})
+		router := handlertest.NewSingleRouteRouter(&RoleCreateHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		Convey("create role successfully", func() {
+			resp := router.POST(`{
+    "name": "editor",
+    "parent": "writer",
+    "permissions": ["post:publish"]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+    "result": {
+        "name": "editor",
+        "parent": "writer",
+        "permissions": ["post:publish"],
+        "is_admin": false,
+        "by_default": false
+    }
+}`)
+			So(conn.RoleMap["editor"], ShouldResemble, skydb.Role{
+				Name:        "editor",
+				Parent:      "writer",
+				Permissions: []string{"post:publish"},
+			})
+		})
+
+		Convey("reject duplicated role", func() {
+			resp := router.POST(`{
+    "name": "writer"
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+    "error": {
+        "code": 109,
+        "message": "role already exists",
+        "name": "Duplicated"
+    }
+}`)
+		})
+
+		Convey("reject role with non-existent parent", func() {
+			resp := router.POST(`{
+    "name": "editor",
+    "parent": "reader"
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+    "error": {
+        "code": 110,
+        "message": "role not found",
+        "name": "ResourceNotFound"
+    }
+}`)
+		})
+
+		Convey("reject role being parent of itself", func() {
+			resp := router.POST(`{
+    "name": "editor",
+    "parent": "editor"
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+    "error": {
+        "code": 108,
+        "message": "role cannot be the parent of itself",
+        "info": {
+            "arguments": [
+                "parent"
+            ]
+        },
+        "name": "InvalidArgument"
+    }
+}`)
+		})
+	})
+}
+
+func TestRoleListHandler(t *testing.T) {
+	Convey("RoleListHandler", t, func() {
+		conn := skydbtest.NewMapConn()
+		conn.CreateRole(&skydb.Role{
+			Name:        "writer",
+			Permissions: []string{"post:create"},
+		})
+		router := handlertest.NewSingleRouteRouter(&RoleListHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		Convey("list roles", func() {
+			resp := router.POST(`{}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+    "result": [{
+        "name": "writer",
+        "permissions": ["post:create"],
+        "is_admin": false,
+        "by_default": false
+    }]
+}`)
+		})
+	})
+}
+
+func TestRoleDeleteHandler(t *testing.T) {
+	Convey("RoleDeleteHandler", t, func() {
+		conn := skydbtest.NewMapConn()
+		conn.CreateRole(&skydb.Role{Name: "writer"})
+		txdb := skydbtest.NewMockTxDatabase(skydbtest.NewMapDB())
+		router := handlertest.NewSingleRouteRouter(&RoleDeleteHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = txdb
+		})
+
+		Convey("delete role successfully", func() {
+			resp := router.POST(`{
+    "name": "writer"
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+    "result": {
+        "name": "writer"
+    }
+}`)
+			So(conn.RoleMap, ShouldBeEmpty)
+			So(txdb.DidBegin, ShouldBeTrue)
+			So(txdb.DidCommit, ShouldBeTrue)
+		})
+
+		Convey("reject non-existent role", func() {
+			resp := router.POST(`{
+    "name": "reader"
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+    "error": {
+        "code": 110,
+        "message": "role not found",
+        "name": "ResourceNotFound"
+    }
+}`)
+			So(txdb.DidRollback, ShouldBeTrue)
+		})
+	})
+}
+
+func TestRoleAssignRevokeHandler(t *testing.T) {
+	Convey("RoleAssignHandler and RoleRevokeHandler", t, func() {
+		conn := skydbtest.NewMapConn()
+		conn.CreateRole(&skydb.Role{Name: "writer"})
+		conn.CreateUser(&skydb.UserInfo{ID: "userid"})
+		assignRouter := handlertest.NewSingleRouteRouter(&RoleAssignHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+		revokeRouter := handlertest.NewSingleRouteRouter(&RoleRevokeHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		Convey("assign and revoke role successfully", func() {
+			resp := assignRouter.POST(`{
+    "roles": ["writer"],
+    "users": ["userid"]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+    "result": {
+        "roles": ["writer"],
+        "users": ["userid"]
+    }
+}`)
+			So(conn.UserMap["userid"].Roles, ShouldResemble, []string{"writer"})
+
+			resp = revokeRouter.POST(`{
+    "roles": ["writer"],
+    "users": ["userid"]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+    "result": {
+        "roles": ["writer"],
+        "users": ["userid"]
+    }
+}`)
+			So(conn.UserMap["userid"].Roles, ShouldBeEmpty)
+		})
+
+		Convey("reject non-existent user", func() {
+			resp := assignRouter.POST(`{
+    "roles": ["writer"],
+    "users": ["nobody"]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+    "error": {
+        "code": 110,
+        "message": "user not found",
+        "name": "ResourceNotFound"
+    }
+}`)
+		})
+
+		Convey("reject request without users", func() {
+			resp := revokeRouter.POST(`{
+    "roles": ["writer"]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+    "error": {
+        "code": 108,
+        "message": "unspecified users in request",
+        "info": {
+            "arguments": [
+                "users"
+            ]
+        },
+        "name": "InvalidArgument"
+    }
+}`)
+		})
+	})
+}
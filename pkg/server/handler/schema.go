@@ -497,3 +497,216 @@ func (h *SchemaAccessHandler) Handle(rpayload *router.Payload, response *router.
 		CreateRoles: payload.RawCreateRoles,
 	}
 }
+
+/*
+SchemaPolicyHandler handles the update of record policy of record type.
+
+Record policy is a predicate that a record must satisfy for the current user
+to access it, in addition to the record ACL. The current user is referenced by
+the key path `$user`, and a field of the user record by `$user.<field>`.
+An empty predicate removes the record policy.
+
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/policy <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:policy",
+	"type": "note",
+	"predicate": [
+		"or",
+		["eq", {"$type": "keypath", "$val": "_owner"}, {"$type": "keypath", "$val": "$user"}],
+		["eq", {"$type": "keypath", "$val": "org"}, {"$type": "keypath", "$val": "$user.org"}]
+	]
+}
+EOF
+*/
+type SchemaPolicyHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+type schemaPolicyPayload struct {
+	Type         string        `mapstructure:"type"`
+	RawPredicate []interface{} `mapstructure:"predicate"`
+	Policy       skydb.Predicate
+}
+
+type schemaPolicyResponse struct {
+	Type      string        `json:"type"`
+	Predicate []interface{} `json:"predicate,omitempty"`
+}
+
+func (h *SchemaPolicyHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaPolicyHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (payload *schemaPolicyPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+
+	if len(payload.RawPredicate) > 0 {
+		parser := QueryParser{}
+		if err := parser.policyFromRaw(payload.RawPredicate, &payload.Policy); err != nil {
+			return err
+		}
+	}
+
+	return payload.Validate()
+}
+
+func (payload *schemaPolicyPayload) Validate() skyerr.Error {
+	if payload.Type == "" {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"type"})
+	}
+
+	return nil
+}
+
+func (h *SchemaPolicyHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := schemaPolicyPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	c := rpayload.Database.Conn()
+	if err := c.SetRecordPolicy(payload.Type, payload.Policy); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = schemaPolicyResponse{
+		Type:      payload.Type,
+		Predicate: payload.RawPredicate,
+	}
+}
+
+/*
+SchemaACLParentHandler sets the ACL parent of a record type.
+
+The ACL parent is a reference field. A record without ACL of its own
+inherits the access of the record referenced by the field, following at
+most max_depth ACL parents (default 5). A record is not accessible if its
+ACL parents form a cycle or exceed the maximum depth. An empty field
+removes the ACL parent.
+
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/acl_parent <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:acl_parent",
+	"type": "comment",
+	"field": "post",
+	"max_depth": 3
+}
+EOF
+*/
+type SchemaACLParentHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+type schemaACLParentPayload struct {
+	Type     string `mapstructure:"type"`
+	Field    string `mapstructure:"field"`
+	MaxDepth int    `mapstructure:"max_depth"`
+}
+
+type schemaACLParentResponse struct {
+	Type     string `json:"type"`
+	Field    string `json:"field,omitempty"`
+	MaxDepth int    `json:"max_depth,omitempty"`
+}
+
+func (h *SchemaACLParentHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaACLParentHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (payload *schemaACLParentPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+
+	return payload.Validate()
+}
+
+func (payload *schemaACLParentPayload) Validate() skyerr.Error {
+	if payload.Type == "" {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"type"})
+	}
+
+	if payload.MaxDepth < 0 {
+		return skyerr.NewInvalidArgument("max_depth must not be negative", []string{"max_depth"})
+	}
+
+	return nil
+}
+
+func (h *SchemaACLParentHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := schemaACLParentPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := rpayload.Database
+	if payload.Field != "" {
+		schema, err := db.GetSchema(payload.Type)
+		if err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+
+		if fieldType, ok := schema[payload.Field]; !ok || fieldType.Type != skydb.TypeReference {
+			response.Err = skyerr.NewInvalidArgument(
+				"ACL parent must be a reference field", []string{"field"})
+			return
+		}
+	}
+
+	aclParent := skydb.RecordACLParent{
+		Field:    payload.Field,
+		MaxDepth: payload.MaxDepth,
+	}
+	if err := db.Conn().SetRecordACLParent(payload.Type, aclParent); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = schemaACLParentResponse{
+		Type:     payload.Type,
+		Field:    payload.Field,
+		MaxDepth: payload.MaxDepth,
+	}
+}
@@ -799,3 +799,200 @@ func TestSchemaAccessHandler(t *testing.T) {
 		So(roleNames, ShouldContain, "Writer")
 	})
 }
+
+func TestSchemaPolicyPayload(t *testing.T) {
+	Convey("TestSchemaPolicyPayload", t, func() {
+		Convey("Valid Data", func() {
+			payload := schemaPolicyPayload{}
+			skyErr := payload.Decode(map[string]interface{}{
+				"action": "schema:policy",
+				"type":   "note",
+				"predicate": []interface{}{
+					"eq",
+					map[string]interface{}{"$type": "keypath", "$val": "org"},
+					map[string]interface{}{"$type": "keypath", "$val": "$user.org"},
+				},
+			})
+
+			So(skyErr, ShouldBeNil)
+			So(payload.Policy, ShouldResemble, skydb.Predicate{
+				Operator: skydb.Equal,
+				Children: []interface{}{
+					skydb.Expression{
+						Type:  skydb.KeyPath,
+						Value: "org",
+					},
+					skydb.Expression{
+						Type:  skydb.Function,
+						Value: skydb.CurrentUserFunc{KeyPath: "org"},
+					},
+				},
+			})
+		})
+
+		Convey("Empty predicate", func() {
+			payload := schemaPolicyPayload{}
+			skyErr := payload.Decode(map[string]interface{}{
+				"action": "schema:policy",
+				"type":   "note",
+			})
+
+			So(skyErr, ShouldBeNil)
+			So(payload.Policy.IsEmpty(), ShouldBeTrue)
+		})
+
+		Convey("Invalid Data", func() {
+			payload := schemaPolicyPayload{}
+			err := payload.Decode(map[string]interface{}{
+				"action": "schema:policy",
+			})
+
+			So(err, ShouldResemble,
+				skyerr.NewInvalidArgument("missing required fields", []string{"type"}))
+
+			err = payload.Decode(map[string]interface{}{
+				"action": "schema:policy",
+				"type":   "note",
+				"predicate": []interface{}{
+					"func",
+					"userRelation",
+					map[string]interface{}{"$type": "keypath", "$val": "_owner"},
+					map[string]interface{}{"$type": "relation", "$name": "_follow", "$direction": "outward"},
+				},
+			})
+
+			So(err, ShouldNotBeNil)
+			So(err.Code(), ShouldEqual, skyerr.NotSupported)
+		})
+	})
+}
+
+type mockSchemaPolicyDatabaseConnection struct {
+	recordType string
+	policy     skydb.Predicate
+
+	skydb.Conn
+}
+
+func (c *mockSchemaPolicyDatabaseConnection) SetRecordPolicy(recordType string, policy skydb.Predicate) error {
+	c.recordType = recordType
+	c.policy = policy
+
+	return nil
+}
+
+func TestSchemaPolicyHandler(t *testing.T) {
+	Convey("TestSchemaPolicyHandler", t, func() {
+		mockConn := &mockSchemaPolicyDatabaseConnection{}
+		mockDB := &mockSchemaAccessDatabase{}
+		mockDB.DBConn = mockConn
+
+		handler := handlertest.NewSingleRouteRouter(&SchemaPolicyHandler{}, func(p *router.Payload) {
+			p.Database = mockDB
+		})
+
+		resp := handler.POST(`{
+			"type": "note",
+			"predicate": ["eq", {"$type": "keypath", "$val": "_owner"}, {"$type": "keypath", "$val": "$user"}]
+		}`)
+
+		So(resp.Body.Bytes(), ShouldEqualJSON, `{
+			"result": {
+				"type": "note",
+				"predicate": ["eq", {"$type": "keypath", "$val": "_owner"}, {"$type": "keypath", "$val": "$user"}]
+			}
+		}`)
+
+		So(mockConn.recordType, ShouldEqual, "note")
+		So(mockConn.policy, ShouldResemble, skydb.Predicate{
+			Operator: skydb.Equal,
+			Children: []interface{}{
+				skydb.Expression{
+					Type:  skydb.KeyPath,
+					Value: "_owner_id",
+				},
+				skydb.Expression{
+					Type:  skydb.Function,
+					Value: skydb.CurrentUserFunc{},
+				},
+			},
+		})
+	})
+}
+
+func TestSchemaACLParentHandler(t *testing.T) {
+	Convey("TestSchemaACLParentHandler", t, func() {
+		conn := skydbtest.NewMapConn()
+		db := skydbtest.NewMapDB()
+		db.DBConn = conn
+		db.Extend("comment", skydb.RecordSchema{
+			"post": skydb.FieldType{
+				Type:          skydb.TypeReference,
+				ReferenceType: "post",
+			},
+			"content": skydb.FieldType{
+				Type: skydb.TypeString,
+			},
+		})
+
+		handler := handlertest.NewSingleRouteRouter(&SchemaACLParentHandler{}, func(p *router.Payload) {
+			p.Database = db
+		})
+
+		Convey("set ACL parent", func() {
+			resp := handler.POST(`{
+				"type": "comment",
+				"field": "post",
+				"max_depth": 3
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"type": "comment",
+					"field": "post",
+					"max_depth": 3
+				}
+			}`)
+
+			aclParent, _ := conn.GetRecordACLParent("comment")
+			So(aclParent, ShouldResemble, skydb.RecordACLParent{
+				Field:    "post",
+				MaxDepth: 3,
+			})
+		})
+
+		Convey("remove ACL parent", func() {
+			conn.SetRecordACLParent("comment", skydb.RecordACLParent{Field: "post"})
+			resp := handler.POST(`{
+				"type": "comment"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"type": "comment"
+				}
+			}`)
+
+			aclParent, _ := conn.GetRecordACLParent("comment")
+			So(aclParent.IsEmpty(), ShouldBeTrue)
+		})
+
+		Convey("reject non-reference field", func() {
+			resp := handler.POST(`{
+				"type": "comment",
+				"field": "content"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "ACL parent must be a reference field",
+					"info": {
+						"arguments": ["field"]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+	})
+}
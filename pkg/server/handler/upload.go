@@ -0,0 +1,635 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+
+	skyAsset "github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// maxUploadChunkSize is the maximum size of a chunk of a resumable upload,
+// which is buffered in a temp file before it is put to the asset store.
+const maxUploadChunkSize = 100 << 20
+
+// uploadChunkLease is how long an upload session is claimed for putting
+// a chunk to the asset store, which is read from a temp file.
+const uploadChunkLease = 10 * time.Minute
+
+// UploadSessionExpiry is how long an upload session is kept after the
+// last chunk is uploaded, after which the upload is aborted.
+const UploadSessionExpiry = 7 * 24 * time.Hour
+
+// uploadSessionPruneBatchSize is the number of expired upload sessions
+// fetched at a time when pruning upload sessions.
+const uploadSessionPruneBatchSize = 100
+
+// PruneUploadSessions aborts the uploads of upload sessions expired
+// after UploadSessionExpiry, and returns the number of uploads aborted.
+//
+// The session is deleted before the upload is aborted, so that a session
+// deleted in the meantime, such as a completed one, is skipped.
+func PruneUploadSessions(conn skydb.Conn, uploader skyAsset.ChunkUploader) (int, error) {
+	updatedBefore := timeNow().Add(-UploadSessionExpiry)
+	afterID := ""
+	aborted := 0
+	for {
+		sessions, err := conn.GetExpiredUploadSessions(updatedBefore, afterID, uploadSessionPruneBatchSize)
+		if err != nil {
+			return aborted, err
+		}
+
+		for _, session := range sessions {
+			if err := conn.DeleteUploadSession(session.ID); err != nil {
+				log.WithField("session", session.ID).Warnf("Skipped deleting expired upload session: %v", err)
+				continue
+			}
+
+			if err := uploader.AbortChunkUpload(session.AssetName, session.UploadID); err != nil {
+				log.WithField("session", session.ID).Errorf("Failed to abort upload of expired upload session: %v", err)
+				continue
+			}
+			aborted++
+		}
+
+		if len(sessions) < uploadSessionPruneBatchSize {
+			return aborted, nil
+		}
+		afterID = sessions[len(sessions)-1].ID
+	}
+}
+
+type uploadCreatePayload struct {
+	Filename    string `mapstructure:"filename"`
+	ContentType string `mapstructure:"content_type"`
+	Size        int64  `mapstructure:"size"`
+}
+
+func (payload *uploadCreatePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *uploadCreatePayload) Validate() skyerr.Error {
+	if payload.Filename == "" {
+		return skyerr.NewInvalidArgument("empty filename", []string{"filename"})
+	}
+	if payload.ContentType == "" {
+		return skyerr.NewInvalidArgument("empty content type", []string{"content_type"})
+	}
+	if payload.Size <= 0 {
+		return skyerr.NewInvalidArgument("size must be positive", []string{"size"})
+	}
+	if payload.Size > skyAsset.MaxChunks*maxUploadChunkSize {
+		return skyerr.NewInvalidArgument("size is too large", []string{"size"})
+	}
+	return nil
+}
+
+/*
+AssetUploadCreateHandler creates a session of a resumable upload, for
+uploading a large asset in chunks.
+
+Chunks are uploaded in order with PUT /uploads/<id>?offset=<offset>,
+where offset is the number of bytes uploaded. Each chunk except the last
+one is at least min_chunk_size bytes and at most max_chunk_size bytes.
+The number of bytes uploaded is queried with asset:upload:status to
+resume an interrupted upload. The asset is saved when the upload is
+completed with asset:upload:complete, or the upload is discarded with
+asset:upload:abort. An upload without any chunk uploaded for a week is
+discarded.
+
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "asset:upload:create",
+    "api_key": "API_KEY",
+    "access_token": "ACCESS_TOKEN",
+    "filename": "video.mp4",
+    "content_type": "video/mp4",
+    "size": 2147483648
+}
+EOF
+
+curl -X PUT -H "X-Skygear-Api-Key: API_KEY" \
+  -H "X-Skygear-Access-Token: ACCESS_TOKEN" \
+  --data-binary @chunk1 'http://localhost:3000/uploads/<id>?offset=0'
+*/
+type AssetUploadCreateHandler struct {
+	AssetStore    skyAsset.Store   `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *AssetUploadCreateHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.PluginReady,
+	}
+}
+
+func (h *AssetUploadCreateHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *AssetUploadCreateHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &uploadCreatePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	uploader, ok := h.AssetStore.(skyAsset.ChunkUploader)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "resumable upload is not supported by the asset store")
+		return
+	}
+
+	// Add UUID to Filename
+	dir, file := filepath.Split(payload.Filename)
+	file = strings.Join([]string{uuidNew(), file}, "-")
+	name := filepath.Join(dir, file)
+
+	uploadID, err := uploader.BeginChunkUpload(name, payload.ContentType)
+	if err != nil {
+		log.Errorf("Failed to begin chunk upload: %v", err)
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	now := timeNow()
+	session := skydb.UploadSession{
+		ID:          uuidNew(),
+		AssetName:   name,
+		ContentType: payload.ContentType,
+		Size:        payload.Size,
+		UploadID:    uploadID,
+		UserID:      rpayload.UserInfoID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := rpayload.DBConn.SaveUploadSession(&session); err != nil {
+		response.Err = skyerr.NewResourceSaveFailureErrWithStringID("upload session", session.ID)
+		return
+	}
+
+	response.Result = uploadSessionResult(&session, uploader)
+}
+
+// UploadChunkHandler receives a chunk of a resumable upload created by
+// asset:upload:create. The offset of the chunk must be the number of
+// bytes uploaded, which is returned along with the other progress of the
+// upload.
+//
+// Example curl:
+//	curl -XPUT \
+//		-H 'X-Skygear-API-Key: apiKey' \
+//		--data-binary '@chunk1' \
+//		'http://localhost:3000/uploads/<id>?offset=0'
+type UploadChunkHandler struct {
+	AssetStore    skyAsset.Store   `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	preprocessors []router.Processor
+}
+
+// Setup sets preprocessors being used
+func (h *UploadChunkHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+	}
+}
+
+// GetPreprocessors returns all preprocessors
+func (h *UploadChunkHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+// Handle handles the chunk upload request
+func (h *UploadChunkHandler) Handle(payload *router.Payload, response *router.Response) {
+	uploader, ok := h.AssetStore.(skyAsset.ChunkUploader)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "resumable upload is not supported by the asset store")
+		return
+	}
+
+	session, skyErr := getUploadSession(payload, payload.Params[0])
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	offset, err := strconv.ParseInt(payload.Req.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		response.Err = skyerr.NewInvalidArgument("expect offset to be an integer", []string{"offset"})
+		return
+	}
+	if offset != session.Offset {
+		response.Err = uploadOffsetError(session)
+		return
+	}
+	if session.Chunks >= skyAsset.MaxChunks {
+		response.Err = skyerr.NewError(skyerr.InvalidArgument, "too many chunks")
+		return
+	}
+
+	written, tempFile, err := copyToTempFile(io.LimitReader(payload.Req.Body, maxUploadChunkSize+1))
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	defer func() {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+	}()
+
+	if skyErr := validateChunkSize(session, uploader, written); skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	// the session is claimed before the chunk is put, so that chunks
+	// uploaded concurrently at the offset do not overwrite each other
+	err = payload.DBConn.ClaimUploadSession(session, timeNow(), uploadChunkLease)
+	if err == skydb.ErrUploadSessionConflict {
+		response.Err = uploadConflictError(payload, session.ID, offset)
+		return
+	} else if err != nil {
+		response.Err = skyerr.NewResourceSaveFailureErrWithStringID("upload session", session.ID)
+		return
+	}
+
+	if err := uploader.PutChunk(session.AssetName, session.UploadID, session.Chunks+1, tempFile, written); err != nil {
+		log.Errorf("Failed to put chunk: %v", err)
+		// release the claim without progress, so that the chunk can be
+		// uploaded again
+		if err := payload.DBConn.UpdateUploadSession(session, offset); err != nil {
+			log.Errorf("Failed to release upload session: %v", err)
+		}
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	// the progress is saved only if no other chunk is uploaded at the
+	// offset in the meantime, which would otherwise be counted twice
+	session.Offset += written
+	session.Chunks++
+	session.UpdatedAt = timeNow()
+	err = payload.DBConn.UpdateUploadSession(session, offset)
+	if err == skydb.ErrUploadSessionConflict {
+		response.Err = uploadConflictError(payload, session.ID, offset)
+		return
+	} else if err != nil {
+		response.Err = skyerr.NewResourceSaveFailureErrWithStringID("upload session", session.ID)
+		return
+	}
+
+	response.Result = uploadSessionResult(session, uploader)
+}
+
+// uploadConflictError returns the error of uploading a chunk at an offset
+// which another chunk is uploaded or being uploaded at.
+func uploadConflictError(payload *router.Payload, id string, offset int64) skyerr.Error {
+	session, skyErr := getUploadSession(payload, id)
+	if skyErr != nil {
+		return skyErr
+	}
+	if session.Offset != offset {
+		return uploadOffsetError(session)
+	}
+	return skyerr.NewError(skyerr.ConstraintViolated,
+		fmt.Sprintf("another chunk is being uploaded at offset %d", offset))
+}
+
+// uploadOffsetError returns the error of uploading a chunk at an offset
+// other than the uploaded size of the session.
+func uploadOffsetError(session *skydb.UploadSession) skyerr.Error {
+	return skyerr.NewErrorWithInfo(
+		skyerr.InvalidArgument,
+		fmt.Sprintf("expect offset to be the uploaded size %d", session.Offset),
+		map[string]interface{}{
+			"arguments": []string{"offset"},
+			"offset":    session.Offset,
+		},
+	)
+}
+
+func validateChunkSize(session *skydb.UploadSession, uploader skyAsset.ChunkUploader, size int64) skyerr.Error {
+	if size == 0 {
+		return skyerr.NewError(skyerr.InvalidArgument, "Zero-byte content")
+	}
+	if size > maxUploadChunkSize {
+		return skyerr.NewError(skyerr.InvalidArgument, fmt.Sprintf("chunk is larger than %d bytes", maxUploadChunkSize))
+	}
+
+	remaining := session.Size - session.Offset
+	if size > remaining {
+		return skyerr.NewError(skyerr.InvalidArgument, fmt.Sprintf("chunk is larger than the remaining %d bytes", remaining))
+	}
+	if size < remaining && size < uploader.MinChunkSize() {
+		return skyerr.NewError(skyerr.InvalidArgument, fmt.Sprintf("chunk is smaller than %d bytes", uploader.MinChunkSize()))
+	}
+	return nil
+}
+
+type uploadSessionPayload struct {
+	ID string `mapstructure:"id"`
+}
+
+func (payload *uploadSessionPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *uploadSessionPayload) Validate() skyerr.Error {
+	if payload.ID == "" {
+		return skyerr.NewInvalidArgument("empty upload session id", []string{"id"})
+	}
+	return nil
+}
+
+/*
+AssetUploadStatusHandler returns the progress of a resumable upload, such
+as the number of bytes uploaded, from which an interrupted upload is
+resumed.
+
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "asset:upload:status",
+    "api_key": "API_KEY",
+    "access_token": "ACCESS_TOKEN",
+    "id": "0d1b9d3a-47c4-4a52-b9e5-0bd3bbee0e5b"
+}
+EOF
+*/
+type AssetUploadStatusHandler struct {
+	AssetStore    skyAsset.Store   `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	preprocessors []router.Processor
+}
+
+func (h *AssetUploadStatusHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+	}
+}
+
+func (h *AssetUploadStatusHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *AssetUploadStatusHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &uploadSessionPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	uploader, ok := h.AssetStore.(skyAsset.ChunkUploader)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "resumable upload is not supported by the asset store")
+		return
+	}
+
+	session, skyErr := getUploadSession(rpayload, payload.ID)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	response.Result = uploadSessionResult(session, uploader)
+}
+
+/*
+AssetUploadCompleteHandler assembles the chunks of a resumable upload
+into an asset when all bytes are uploaded. The saved asset is returned
+as returned by uploading with PUT /files/<filename>.
+
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "asset:upload:complete",
+    "api_key": "API_KEY",
+    "access_token": "ACCESS_TOKEN",
+    "id": "0d1b9d3a-47c4-4a52-b9e5-0bd3bbee0e5b"
+}
+EOF
+*/
+type AssetUploadCompleteHandler struct {
+	AssetStore    skyAsset.Store   `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	preprocessors []router.Processor
+}
+
+func (h *AssetUploadCompleteHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+	}
+}
+
+func (h *AssetUploadCompleteHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *AssetUploadCompleteHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &uploadSessionPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	uploader, ok := h.AssetStore.(skyAsset.ChunkUploader)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "resumable upload is not supported by the asset store")
+		return
+	}
+
+	session, skyErr := getUploadSession(rpayload, payload.ID)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+	if session.Offset != session.Size {
+		response.Err = skyerr.NewErrorWithInfo(
+			skyerr.InvalidArgument,
+			fmt.Sprintf("upload is incomplete, %d of %d bytes are uploaded", session.Offset, session.Size),
+			map[string]interface{}{
+				"arguments": []string{"id"},
+				"offset":    session.Offset,
+			},
+		)
+		return
+	}
+
+	if err := uploader.CompleteChunkUpload(session.AssetName, session.UploadID, session.Chunks); err != nil {
+		log.Errorf("Failed to complete chunk upload: %v", err)
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	asset := skydb.Asset{
+		Name:        session.AssetName,
+		ContentType: session.ContentType,
+		Size:        session.Size,
+	}
+	conn := rpayload.DBConn
+	if err := conn.SaveAsset(&asset); err != nil {
+		response.Err = skyerr.NewResourceSaveFailureErrWithStringID("asset", asset.Name)
+		return
+	}
+	if err := conn.DeleteUploadSession(session.ID); err != nil {
+		log.Warnf("Failed to delete completed upload session: %v", err)
+	}
+
+	if signer, ok := h.AssetStore.(skyAsset.URLSigner); ok {
+		asset.Signer = signer
+	} else {
+		log.Warnf("Failed to acquire asset URLSigner, please check configuration")
+		response.Err = skyerr.NewError(skyerr.UnexpectedError, "Failed to sign the url")
+		return
+	}
+	response.Result = skyconv.ToMap((*skyconv.MapAsset)(&asset))
+}
+
+/*
+AssetUploadAbortHandler discards a resumable upload and the chunks
+uploaded.
+
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "asset:upload:abort",
+    "api_key": "API_KEY",
+    "access_token": "ACCESS_TOKEN",
+    "id": "0d1b9d3a-47c4-4a52-b9e5-0bd3bbee0e5b"
+}
+EOF
+*/
+type AssetUploadAbortHandler struct {
+	AssetStore    skyAsset.Store   `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	preprocessors []router.Processor
+}
+
+func (h *AssetUploadAbortHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+	}
+}
+
+func (h *AssetUploadAbortHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *AssetUploadAbortHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &uploadSessionPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	uploader, ok := h.AssetStore.(skyAsset.ChunkUploader)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "resumable upload is not supported by the asset store")
+		return
+	}
+
+	session, skyErr := getUploadSession(rpayload, payload.ID)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if err := uploader.AbortChunkUpload(session.AssetName, session.UploadID); err != nil {
+		log.Errorf("Failed to abort chunk upload: %v", err)
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	if err := rpayload.DBConn.DeleteUploadSession(session.ID); err != nil {
+		response.Err = uploadSessionError(session.ID, err)
+		return
+	}
+
+	response.Result = map[string]interface{}{
+		"id": session.ID,
+	}
+}
+
+// getUploadSession returns the upload session of the ID, which is only
+// accessible to the user creating it, or with the master key.
+func getUploadSession(payload *router.Payload, id string) (*skydb.UploadSession, skyerr.Error) {
+	session := skydb.UploadSession{}
+	if err := payload.DBConn.GetUploadSession(id, &session); err != nil {
+		return nil, uploadSessionError(id, err)
+	}
+
+	if session.UserID != "" && session.UserID != payload.UserInfoID && !payload.HasMasterKey() {
+		return nil, skyerr.NewError(skyerr.PermissionDenied, "no permission to access the upload session")
+	}
+	return &session, nil
+}
+
+func uploadSessionError(id string, err error) skyerr.Error {
+	if err == skydb.ErrUploadSessionNotFound {
+		return skyerr.NewErrorWithInfo(
+			skyerr.ResourceNotFound,
+			fmt.Sprintf(`cannot find upload session "%s"`, id),
+			map[string]interface{}{"id": id},
+		)
+	}
+	return skyerr.MakeError(err)
+}
+
+func uploadSessionResult(session *skydb.UploadSession, uploader skyAsset.ChunkUploader) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             session.ID,
+		"content_type":   session.ContentType,
+		"size":           session.Size,
+		"offset":         session.Offset,
+		"min_chunk_size": uploader.MinChunkSize(),
+		"max_chunk_size": maxUploadChunkSize,
+	}
+}
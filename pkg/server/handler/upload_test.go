@@ -0,0 +1,397 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	"github.com/skygeario/skygear-server/pkg/server/uuid"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type uploadSessionConn struct {
+	skydb.Conn
+	sessions map[string]skydb.UploadSession
+	assets   map[string]skydb.Asset
+
+	// beforeUpdate is called before a session is updated, to simulate a
+	// concurrent modification
+	beforeUpdate func()
+
+	lockedUntil map[string]time.Time
+}
+
+func (conn *uploadSessionConn) SaveUploadSession(session *skydb.UploadSession) error {
+	conn.sessions[session.ID] = *session
+	return nil
+}
+
+func (conn *uploadSessionConn) ClaimUploadSession(session *skydb.UploadSession, now time.Time, lease time.Duration) error {
+	saved, ok := conn.sessions[session.ID]
+	if !ok || saved.Offset != session.Offset || conn.lockedUntil[session.ID].After(now) {
+		return skydb.ErrUploadSessionConflict
+	}
+	conn.lockedUntil[session.ID] = now.Add(lease)
+	return nil
+}
+
+func (conn *uploadSessionConn) UpdateUploadSession(session *skydb.UploadSession, offset int64) error {
+	if conn.beforeUpdate != nil {
+		conn.beforeUpdate()
+	}
+
+	saved, ok := conn.sessions[session.ID]
+	if !ok || saved.Offset != offset {
+		return skydb.ErrUploadSessionConflict
+	}
+	saved.Offset = session.Offset
+	saved.Chunks = session.Chunks
+	saved.UpdatedAt = session.UpdatedAt
+	conn.sessions[session.ID] = saved
+	delete(conn.lockedUntil, session.ID)
+	return nil
+}
+
+func (conn *uploadSessionConn) GetExpiredUploadSessions(updatedBefore time.Time, afterID string, limit int) ([]skydb.UploadSession, error) {
+	ids := []string{}
+	for id, session := range conn.sessions {
+		if session.UpdatedAt.Before(updatedBefore) && id > afterID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	sessions := []skydb.UploadSession{}
+	for _, id := range ids {
+		sessions = append(sessions, conn.sessions[id])
+	}
+	return sessions, nil
+}
+
+func (conn *uploadSessionConn) GetUploadSession(id string, session *skydb.UploadSession) error {
+	saved, ok := conn.sessions[id]
+	if !ok {
+		return skydb.ErrUploadSessionNotFound
+	}
+	*session = saved
+	return nil
+}
+
+func (conn *uploadSessionConn) DeleteUploadSession(id string) error {
+	if _, ok := conn.sessions[id]; !ok {
+		return skydb.ErrUploadSessionNotFound
+	}
+	delete(conn.sessions, id)
+	return nil
+}
+
+func (conn *uploadSessionConn) SaveAsset(asset *skydb.Asset) error {
+	conn.assets[asset.Name] = *asset
+	return nil
+}
+
+func TestResumableUpload(t *testing.T) {
+	Convey("Resumable upload", t, func() {
+		dir, err := ioutil.TempDir("", "skygear-asset")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store := asset.NewFileStore(dir, "http://skygear.test/files", "secret", true)
+		conn := &uploadSessionConn{
+			sessions:    map[string]skydb.UploadSession{},
+			assets:      map[string]skydb.Asset{},
+			lockedUntil: map[string]time.Time{},
+		}
+
+		userID := "userid"
+		prepare := func(p *router.Payload) {
+			p.DBConn = conn
+			p.UserInfoID = userID
+		}
+
+		uuids := []string{"c34e739e", "session-id"}
+		uuidNew = func() string {
+			uuid := uuids[0]
+			uuids = uuids[1:]
+			return uuid
+		}
+		defer func() {
+			uuidNew = uuid.New
+		}()
+
+		timeNow = func() time.Time {
+			return time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		}
+		defer func() {
+			timeNow = timeNowUTC
+		}()
+
+		createRouter := handlertest.NewSingleRouteRouter(&AssetUploadCreateHandler{AssetStore: store}, prepare)
+		statusRouter := handlertest.NewSingleRouteRouter(&AssetUploadStatusHandler{AssetStore: store}, prepare)
+		completeRouter := handlertest.NewSingleRouteRouter(&AssetUploadCompleteHandler{AssetStore: store}, prepare)
+		abortRouter := handlertest.NewSingleRouteRouter(&AssetUploadAbortHandler{AssetStore: store}, prepare)
+		chunkGateway := newmodGateway("uploads/(.+)")
+		chunkGateway.Handle("PUT", &UploadChunkHandler{AssetStore: store}, prepare)
+
+		resp := createRouter.POST(`{
+			"filename": "video/note.txt",
+			"content_type": "plain/text",
+			"size": 10
+		}`)
+		So(resp.Body.Bytes(), ShouldEqualJSON, `{
+			"result": {
+				"id": "session-id",
+				"content_type": "plain/text",
+				"size": 10,
+				"offset": 0,
+				"min_chunk_size": 1,
+				"max_chunk_size": 104857600
+			}
+		}`)
+		session := conn.sessions["session-id"]
+		So(session.AssetName, ShouldEqual, "video/c34e739e-note.txt")
+		So(session.UserID, ShouldEqual, "userid")
+
+		Convey("uploads chunks and completes upload", func() {
+			resp := chunkGateway.PUT("uploads/session-id?offset=0", "I am")
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"id": "session-id",
+					"content_type": "plain/text",
+					"size": 10,
+					"offset": 4,
+					"min_chunk_size": 1,
+					"max_chunk_size": 104857600
+				}
+			}`)
+
+			resp = statusRouter.POST(`{"id": "session-id"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"id": "session-id",
+					"content_type": "plain/text",
+					"size": 10,
+					"offset": 4,
+					"min_chunk_size": 1,
+					"max_chunk_size": 104857600
+				}
+			}`)
+
+			resp = chunkGateway.PUT("uploads/session-id?offset=4", " a boy")
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(conn.sessions["session-id"].Chunks, ShouldEqual, 2)
+
+			resp = completeRouter.POST(`{"id": "session-id"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"$type": "asset",
+					"$name": "video/c34e739e-note.txt",
+					"$content_type": "plain/text",
+					"$url": "http://skygear.test/files/video/c34e739e-note.txt"
+				}
+			}`)
+
+			content, err := ioutil.ReadFile(filepath.Join(dir, "video/c34e739e-note.txt"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "I am a boy")
+			So(conn.assets["video/c34e739e-note.txt"], ShouldResemble, skydb.Asset{
+				Name:        "video/c34e739e-note.txt",
+				ContentType: "plain/text",
+				Size:        10,
+			})
+			So(conn.sessions, ShouldBeEmpty)
+		})
+
+		Convey("rejects chunk not at the uploaded offset", func() {
+			chunkGateway.PUT("uploads/session-id?offset=0", "I am")
+
+			resp := chunkGateway.PUT("uploads/session-id?offset=0", "I am")
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"name": "InvalidArgument",
+					"message": "expect offset to be the uploaded size 4",
+					"info": {"arguments": ["offset"], "offset": 4}
+				}
+			}`)
+		})
+
+		Convey("rejects chunk uploaded concurrently at the same offset", func() {
+			conn.beforeUpdate = func() {
+				session := conn.sessions["session-id"]
+				session.Offset = 4
+				session.Chunks = 1
+				conn.sessions["session-id"] = session
+			}
+
+			resp := chunkGateway.PUT("uploads/session-id?offset=0", "I am")
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"name": "InvalidArgument",
+					"message": "expect offset to be the uploaded size 4",
+					"info": {"arguments": ["offset"], "offset": 4}
+				}
+			}`)
+			So(conn.sessions["session-id"].Offset, ShouldEqual, 4)
+			So(conn.sessions["session-id"].Chunks, ShouldEqual, 1)
+		})
+
+		Convey("rejects chunk while another chunk is being uploaded at the offset", func() {
+			conn.lockedUntil["session-id"] = timeNow().Add(time.Minute)
+
+			resp := chunkGateway.PUT("uploads/session-id?offset=0", "I am")
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 113,
+					"name": "ConstraintViolated",
+					"message": "another chunk is being uploaded at offset 0"
+				}
+			}`)
+			So(conn.sessions["session-id"].Offset, ShouldEqual, 0)
+
+			chunks, err := ioutil.ReadDir(filepath.Join(dir, ".uploads", session.UploadID))
+			So(err, ShouldBeNil)
+			So(chunks, ShouldBeEmpty)
+		})
+
+		Convey("uploads chunk after the claim of another chunk expires", func() {
+			conn.lockedUntil["session-id"] = timeNow().Add(-time.Minute)
+
+			resp := chunkGateway.PUT("uploads/session-id?offset=0", "I am")
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(conn.sessions["session-id"].Offset, ShouldEqual, 4)
+			So(conn.lockedUntil, ShouldNotContainKey, "session-id")
+		})
+
+		Convey("rejects chunk exceeding the size", func() {
+			resp := chunkGateway.PUT("uploads/session-id?offset=0", "I am a boy!")
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"name": "InvalidArgument",
+					"message": "chunk is larger than the remaining 10 bytes"
+				}
+			}`)
+			So(conn.sessions["session-id"].Offset, ShouldEqual, 0)
+		})
+
+		Convey("rejects completing incomplete upload", func() {
+			chunkGateway.PUT("uploads/session-id?offset=0", "I am")
+
+			resp := completeRouter.POST(`{"id": "session-id"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"name": "InvalidArgument",
+					"message": "upload is incomplete, 4 of 10 bytes are uploaded",
+					"info": {"arguments": ["id"], "offset": 4}
+				}
+			}`)
+		})
+
+		Convey("aborts upload", func() {
+			chunkGateway.PUT("uploads/session-id?offset=0", "I am")
+
+			resp := abortRouter.POST(`{"id": "session-id"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {"id": "session-id"}
+			}`)
+			So(conn.sessions, ShouldBeEmpty)
+
+			resp = statusRouter.POST(`{"id": "session-id"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 110,
+					"name": "ResourceNotFound",
+					"message": "cannot find upload session \"session-id\"",
+					"info": {"id": "session-id"}
+				}
+			}`)
+		})
+
+		Convey("prunes expired upload sessions", func() {
+			chunkGateway.PUT("uploads/session-id?offset=0", "I am")
+			uploadID := conn.sessions["session-id"].UploadID
+			_, err := os.Stat(filepath.Join(dir, ".uploads", uploadID))
+			So(err, ShouldBeNil)
+
+			recent := conn.sessions["session-id"]
+			recent.ID = "recent-session-id"
+			recent.UpdatedAt = recent.UpdatedAt.Add(24 * time.Hour)
+			conn.sessions[recent.ID] = recent
+
+			timeNow = func() time.Time {
+				return time.Date(2006, 1, 10, 15, 4, 5, 0, time.UTC)
+			}
+
+			aborted, err := PruneUploadSessions(conn, store.(asset.ChunkUploader))
+			So(err, ShouldBeNil)
+			So(aborted, ShouldEqual, 1)
+			So(conn.sessions, ShouldContainKey, "recent-session-id")
+			So(conn.sessions, ShouldNotContainKey, "session-id")
+
+			_, err = os.Stat(filepath.Join(dir, ".uploads", uploadID))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("rejects access of other user", func() {
+			userID = "otheruserid"
+
+			resp := chunkGateway.PUT("uploads/session-id?offset=0", "I am")
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 102,
+					"name": "PermissionDenied",
+					"message": "no permission to access the upload session"
+				}
+			}`)
+		})
+	})
+
+	Convey("Resumable upload with store not supporting it", t, func() {
+		createRouter := handlertest.NewSingleRouteRouter(
+			&AssetUploadCreateHandler{AssetStore: newBufferedStore()},
+			func(p *router.Payload) {},
+		)
+
+		resp := createRouter.POST(`{
+			"filename": "note.txt",
+			"content_type": "plain/text",
+			"size": 10
+		}`)
+		So(resp.Body.Bytes(), ShouldEqualJSON, `{
+			"error": {
+				"code": 111,
+				"name": "NotSupported",
+				"message": "resumable upload is not supported by the asset store"
+			}
+		}`)
+	})
+}
@@ -15,7 +15,12 @@
 package handler
 
 import (
+	"encoding/json"
+	"sort"
+	"strings"
+
 	"github.com/mitchellh/mapstructure"
+	pluginEvent "github.com/skygeario/skygear-server/pkg/server/plugin/event"
 	"github.com/skygeario/skygear-server/pkg/server/plugin/provider"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
@@ -261,8 +266,12 @@ func (payload *userLinkPayload) Validate() skyerr.Error {
 
 // UserLinkHandler lets user associate third-party accounts with the
 // user, with third-party authentication handled by plugin.
+//
+// A "provider-linked" event is sent to plugins after the account is
+// linked.
 type UserLinkHandler struct {
 	ProviderRegistry *provider.Registry `inject:"ProviderRegistry"`
+	EventSender      pluginEvent.Sender `inject:"PluginEventSender"`
 	Authenticator    router.Processor   `preprocessor:"authenticator"`
 	DBConn           router.Processor   `preprocessor:"dbconn"`
 	InjectUser       router.Processor   `preprocessor:"inject_user"`
@@ -331,4 +340,212 @@ func (h *UserLinkHandler) Handle(payload *router.Payload, response *router.Respo
 		response.Err = skyerr.MakeError(err)
 		return
 	}
+
+	if h.EventSender != nil {
+		sendUserProviderEvent(h.EventSender, "provider-linked", payload.UserInfo.ID, userProvider{
+			Provider:    p.Provider,
+			PrincipalID: principalID,
+		})
+	}
+}
+
+type userProvider struct {
+	Provider    string `json:"provider"`
+	PrincipalID string `json:"principal_id"`
+}
+
+type userProvidersResponse struct {
+	Providers []userProvider `json:"providers"`
+}
+
+// userProviders returns the providers linked to the user, sorted by
+// principal ID.
+func userProviders(info *skydb.UserInfo) []userProvider {
+	principalIDs := []string{}
+	for principalID := range info.Auth {
+		principalIDs = append(principalIDs, principalID)
+	}
+	sort.Strings(principalIDs)
+
+	providers := make([]userProvider, len(principalIDs))
+	for i, principalID := range principalIDs {
+		providers[i] = userProvider{
+			Provider:    strings.SplitN(principalID, ":", 2)[0],
+			PrincipalID: principalID,
+		}
+	}
+	return providers
+}
+
+func sendUserProviderEvent(sender pluginEvent.Sender, name string, userID string, linked userProvider) {
+	data, err := json.Marshal(struct {
+		UserID string `json:"user_id"`
+		userProvider
+	}{userID, linked})
+	if err != nil {
+		log.WithField("err", err).Warnf(`Unable to encode "%s" event`, name)
+		return
+	}
+
+	sender.Send(name, data, true)
+}
+
+// UserProvidersHandler lists the third-party accounts linked to the
+// current user. Auth data of the providers are not returned.
+//
+//  curl -X POST -H "Content-Type: application/json" \
+//    -d @- http://localhost:3000/ <<EOF
+//  {
+//      "action": "user:providers",
+//      "access_token": "some-access-token"
+//  }
+//  EOF
+type UserProvidersHandler struct {
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectUser    router.Processor `preprocessor:"inject_user"`
+	RequireUser   router.Processor `preprocessor:"require_user"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *UserProvidersHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+		h.RequireUser,
+		h.PluginReady,
+	}
+}
+
+func (h *UserProvidersHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *UserProvidersHandler) Handle(payload *router.Payload, response *router.Response) {
+	response.Result = userProvidersResponse{userProviders(payload.UserInfo)}
+}
+
+type userUnlinkPayload struct {
+	Provider    string `mapstructure:"provider"`
+	PrincipalID string `mapstructure:"principal_id"`
+}
+
+func (payload *userUnlinkPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *userUnlinkPayload) Validate() skyerr.Error {
+	if payload.Provider == "" {
+		return skyerr.NewInvalidArgument("empty provider", []string{"provider"})
+	}
+
+	return nil
+}
+
+// UserUnlinkHandler removes third-party accounts of a provider from the
+// current user, optionally limited to the specified principal_id.
+//
+// The provider is asked to log out the account before the auth data is
+// removed, and a "provider-unlinked" event is sent to plugins for each
+// unlinked account. Unlinking is refused if the user would be left
+// without any credential to log in with.
+//
+//  curl -X POST -H "Content-Type: application/json" \
+//    -d @- http://localhost:3000/ <<EOF
+//  {
+//      "action": "user:unlink",
+//      "access_token": "some-access-token",
+//      "provider": "com.example"
+//  }
+//  EOF
+type UserUnlinkHandler struct {
+	ProviderRegistry *provider.Registry `inject:"ProviderRegistry"`
+	EventSender      pluginEvent.Sender `inject:"PluginEventSender"`
+	Authenticator    router.Processor   `preprocessor:"authenticator"`
+	DBConn           router.Processor   `preprocessor:"dbconn"`
+	InjectUser       router.Processor   `preprocessor:"inject_user"`
+	RequireUser      router.Processor   `preprocessor:"require_user"`
+	PluginReady      router.Processor   `preprocessor:"plugin_ready"`
+	preprocessors    []router.Processor
+}
+
+func (h *UserUnlinkHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+		h.RequireUser,
+		h.PluginReady,
+	}
+}
+
+func (h *UserUnlinkHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *UserUnlinkHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &userUnlinkPayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	info := payload.UserInfo
+	unlinked := []userProvider{}
+	for _, linked := range userProviders(info) {
+		if linked.Provider != p.Provider {
+			continue
+		}
+		if p.PrincipalID != "" && linked.PrincipalID != p.PrincipalID {
+			continue
+		}
+		unlinked = append(unlinked, linked)
+	}
+	if len(unlinked) == 0 {
+		response.Err = skyerr.NewError(skyerr.ResourceNotFound, "provider not linked")
+		return
+	}
+
+	hasPassword := len(info.HashedPassword) > 0 && (info.Username != "" || info.Email != "")
+	if !hasPassword && len(unlinked) == len(info.Auth) {
+		response.Err = skyerr.NewInvalidArgument("cannot unlink the only credential of the user", []string{"provider"})
+		return
+	}
+
+	authProvider, err := h.ProviderRegistry.GetAuthProvider(p.Provider)
+	if err != nil {
+		// The provider may have been removed, in which case there is
+		// no account to log out from.
+		log.Warnf(`Unlinking accounts of unregistered provider "%v".`, p.Provider)
+	}
+	for _, linked := range unlinked {
+		if authProvider != nil {
+			authData := info.GetProvidedAuthData(linked.PrincipalID)
+			if _, err := authProvider.Logout(payload.Context, authData); err != nil {
+				log.WithField("err", err).Errorf(`Unable to logout principal "%v".`, linked.PrincipalID)
+				response.Err = skyerr.NewError(skyerr.UnexpectedError, "unable to logout from the provider")
+				return
+			}
+		}
+		info.RemoveProvidedAuthData(linked.PrincipalID)
+	}
+
+	if err := payload.DBConn.UpdateUser(info); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	if h.EventSender != nil {
+		for _, linked := range unlinked {
+			sendUserProviderEvent(h.EventSender, "provider-unlinked", info.ID, linked)
+		}
+	}
+
+	response.Result = userProvidersResponse{userProviders(info)}
 }
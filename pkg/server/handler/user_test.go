@@ -527,3 +527,133 @@ func TestUserLinkHandler(t *testing.T) {
 		})
 	})
 }
+
+type sentEvent struct {
+	name string
+	data []byte
+}
+
+type recordingEventSender struct {
+	events []sentEvent
+}
+
+func (s *recordingEventSender) Send(name string, data []byte, async bool) {
+	s.events = append(s.events, sentEvent{name, data})
+}
+
+func TestUserProvidersHandler(t *testing.T) {
+	Convey("UserProvidersHandler", t, func() {
+		userInfo := skydb.UserInfo{
+			ID: "user0",
+			Auth: skydb.AuthInfo{
+				"org.example:johndoe": map[string]interface{}{"token": "secret"},
+				"com.example:johndoe": map[string]interface{}{"token": "secret"},
+			},
+		}
+
+		r := handlertest.NewSingleRouteRouter(&UserProvidersHandler{}, func(p *router.Payload) {
+			p.UserInfo = &userInfo
+		})
+
+		Convey("list linked providers without auth data", func() {
+			resp := r.POST(`{}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"providers": [
+			{"provider": "com.example", "principal_id": "com.example:johndoe"},
+			{"provider": "org.example", "principal_id": "org.example:johndoe"}
+		]
+	}
+}`)
+		})
+	})
+}
+
+func TestUserUnlinkHandler(t *testing.T) {
+	Convey("UserUnlinkHandler", t, func() {
+		conn := skydbtest.NewMapConn()
+		userInfo := skydb.UserInfo{
+			ID: "user0",
+			Auth: skydb.AuthInfo{
+				"com.example:johndoe": map[string]interface{}{"name": "johndoe"},
+				"org.example:johndoe": map[string]interface{}{"name": "johndoe"},
+			},
+		}
+		conn.CreateUser(&userInfo)
+
+		providerRegistry := provider.NewRegistry()
+		providerRegistry.RegisterAuthProvider("com.example", handlertest.NewSingleUserAuthProvider("com.example", "johndoe"))
+		providerRegistry.RegisterAuthProvider("org.example", handlertest.NewSingleUserAuthProvider("org.example", "johndoe"))
+		eventSender := &recordingEventSender{}
+		r := handlertest.NewSingleRouteRouter(&UserUnlinkHandler{
+			ProviderRegistry: providerRegistry,
+			EventSender:      eventSender,
+		}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.UserInfo = &userInfo
+		})
+
+		Convey("unlink provider", func() {
+			resp := r.POST(`{"provider": "com.example"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"providers": [
+			{"provider": "org.example", "principal_id": "org.example:johndoe"}
+		]
+	}
+}`)
+
+			newUserInfo := skydb.UserInfo{}
+			So(conn.GetUser("user0", &newUserInfo), ShouldBeNil)
+			So(newUserInfo.Auth, ShouldNotContainKey, "com.example:johndoe")
+			So(newUserInfo.Auth, ShouldContainKey, "org.example:johndoe")
+
+			So(len(eventSender.events), ShouldEqual, 1)
+			So(eventSender.events[0].name, ShouldEqual, "provider-unlinked")
+			So(eventSender.events[0].data, ShouldEqualJSON, `{
+	"user_id": "user0",
+	"provider": "com.example",
+	"principal_id": "com.example:johndoe"
+}`)
+		})
+
+		Convey("unlink provider not linked", func() {
+			resp := r.POST(`{"provider": "net.example"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 110,
+		"name": "ResourceNotFound",
+		"message": "provider not linked"
+	}
+}`)
+		})
+
+		Convey("refuse to unlink the last credential", func() {
+			delete(userInfo.Auth, "org.example:johndoe")
+
+			resp := r.POST(`{"provider": "com.example"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"name": "InvalidArgument",
+		"info": {"arguments": ["provider"]},
+		"message": "cannot unlink the only credential of the user"
+	}
+}`)
+			So(eventSender.events, ShouldBeEmpty)
+		})
+
+		Convey("unlink the last provider of user with password", func() {
+			userInfo.Username = "johndoe"
+			userInfo.SetPassword("secret")
+			delete(userInfo.Auth, "org.example:johndoe")
+
+			resp := r.POST(`{"provider": "com.example"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"providers": []
+	}
+}`)
+		})
+	})
+}
@@ -90,6 +90,7 @@ type execTransport struct {
 	Config      skyconfig.Configuration
 	initHandler skyplugin.TransportInitHandler
 	state       skyplugin.TransportState
+	publish     skyplugin.PublishFunc
 }
 
 func (p *execTransport) run(args []string, env []string, in []byte) (out []byte, err error) {
@@ -131,8 +132,9 @@ func (p *execTransport) runProc(args []string, env []string, in []byte) (out []b
 	}
 
 	var resp struct {
-		Result json.RawMessage   `json:"result"`
-		Err    *common.ExecError `json:"error"`
+		Result  json.RawMessage         `json:"result"`
+		Err     *common.ExecError       `json:"error"`
+		Publish []common.PublishMessage `json:"publish"`
 	}
 
 	jsonErr := json.Unmarshal(data, &resp)
@@ -152,6 +154,7 @@ func (p *execTransport) runProc(args []string, env []string, in []byte) (out []b
 		return
 	}
 
+	p.publish.PublishMessages(resp.Publish)
 	out = resp.Result
 	return
 }
@@ -235,11 +238,39 @@ func (p *execTransport) RunHook(ctx context.Context, hookName string, record *sk
 	return &recordout, nil
 }
 
+func (p *execTransport) RunChannelHook(ctx context.Context, hookName string, channel string, data []byte) error {
+	param := map[string]interface{}{
+		"channel": channel,
+	}
+	if data != nil {
+		param["data"] = json.RawMessage(data)
+	}
+	in, err := json.Marshal(param)
+	if err != nil {
+		return fmt.Errorf("failed to marshal channel hook: %v", err)
+	}
+
+	pluginCtx := skyplugin.ContextMap(ctx)
+	encodedCtx, err := common.EncodeBase64JSON(pluginCtx)
+	if err != nil {
+		return err
+	}
+	env := []string{
+		fmt.Sprintf("SKYGEAR_CONTEXT=%s", encodedCtx),
+	}
+	_, err = p.runProc([]string{"hook", hookName}, env, in)
+	return err
+}
+
 func (p *execTransport) RunTimer(name string, in []byte) (out []byte, err error) {
 	out, err = p.runProc([]string{"timer", name}, []string{}, in)
 	return
 }
 
+func (p *execTransport) SetPublishFunc(publish skyplugin.PublishFunc) {
+	p.publish = publish
+}
+
 func (p *execTransport) RunProvider(ctx context.Context, request *skyplugin.AuthRequest) (*skyplugin.AuthResponse, error) {
 	req := map[string]interface{}{
 		"auth_data": request.AuthData,
@@ -187,6 +187,44 @@ func TestRun(t *testing.T) {
 			transport.RunLambda(ctx, "work", []byte{})
 			So(executed, ShouldBeTrue)
 		})
+
+		Convey("publishes messages in response", func() {
+			published := map[string]string{}
+			transport.SetPublishFunc(func(channel string, data []byte) error {
+				published[channel] = string(data)
+				return nil
+			})
+			startCommand = func(cmd *exec.Cmd, in []byte) (out []byte, err error) {
+				return []byte(`{
+					"result": {},
+					"publish": [{"channel": "chat", "data": {"text": "hello"}}]
+				}`), nil
+			}
+
+			_, err := transport.RunLambda(context.Background(), "work", []byte{})
+			So(err, ShouldBeNil)
+			So(published, ShouldResemble, map[string]string{
+				"chat": `{"text": "hello"}`,
+			})
+		})
+
+		Convey("does not publish messages if failed", func() {
+			published := false
+			transport.SetPublishFunc(func(channel string, data []byte) error {
+				published = true
+				return nil
+			})
+			startCommand = func(cmd *exec.Cmd, in []byte) (out []byte, err error) {
+				return []byte(`{
+					"error": {"message": "failed"},
+					"publish": [{"channel": "chat", "data": {"text": "hello"}}]
+				}`), nil
+			}
+
+			_, err := transport.RunLambda(context.Background(), "work", []byte{})
+			So(err, ShouldNotBeNil)
+			So(published, ShouldBeFalse)
+		})
 	})
 
 	Convey("test hook", t, func() {
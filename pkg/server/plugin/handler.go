@@ -38,6 +38,7 @@ type Handler struct {
 	Name              string
 	AccessKeyRequired bool
 	UserRequired      bool
+	Permissions       []string
 	PreprocessorList  router.PreprocessorRegistry
 	preprocessors     []router.Processor
 }
@@ -48,13 +49,14 @@ func NewPluginHandler(info pluginHandlerInfo, ppreg router.PreprocessorRegistry,
 		Name:              info.Name,
 		AccessKeyRequired: info.KeyRequired,
 		UserRequired:      info.UserRequired,
+		Permissions:       info.Permissions,
 		PreprocessorList:  ppreg,
 	}
 	return handler
 }
 
 func (h *Handler) Setup() {
-	if h.UserRequired {
+	if h.UserRequired || len(h.Permissions) > 0 {
 		h.preprocessors = h.PreprocessorList.GetByNames(
 			"authenticator",
 			"dbconn",
@@ -78,6 +80,11 @@ func (h *Handler) GetPreprocessors() []router.Processor {
 
 // Handle executes lambda function implemented by the plugin.
 func (h *Handler) Handle(payload *router.Payload, response *router.Response) {
+	if err := checkPermissions(payload, h.Permissions); err != nil {
+		response.Err = err
+		return
+	}
+
 	body, err := ioutil.ReadAll(payload.Req.Body)
 	if err != nil {
 		panic(err)
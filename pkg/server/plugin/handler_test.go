@@ -40,6 +40,15 @@ func TestHandlerCreation(t *testing.T) {
 
 		So(handler.AccessKeyRequired, ShouldBeTrue)
 	})
+
+	Convey("create permission required Handler", t, func() {
+		handler := NewPluginHandler(pluginHandlerInfo{
+			Name:        "hello:world",
+			Permissions: []string{"post:publish"},
+		}, nil, nil)
+
+		So(handler.Permissions, ShouldResemble, []string{"post:publish"})
+	})
 }
 
 func TestHandler(t *testing.T) {
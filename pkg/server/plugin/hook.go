@@ -51,3 +51,20 @@ func CreateHookFunc(p *Plugin, hookInfo pluginHookInfo) hook.Func {
 
 	return hookFunc
 }
+
+// CreateChannelHookFunc returns a hook.ChannelFunc that run the pubsub
+// channel hook registered by a plugin
+func CreateChannelHookFunc(p *Plugin, hookInfo pluginHookInfo) hook.ChannelFunc {
+	return func(ctx context.Context, channel string, data []byte) skyerr.Error {
+		err := p.transport.RunChannelHook(ctx, hookInfo.Name, channel, data)
+		if err == nil {
+			return nil
+		}
+
+		if pluginError, ok := err.(skyerr.Error); ok {
+			return pluginError
+		}
+
+		return skyerr.MakeError(err)
+	}
+}
@@ -34,6 +34,14 @@ const (
 	AfterDelete       = "afterDelete"
 )
 
+// The kinds of hooks executed before a client subscribes or publishes to
+// a pubsub channel. Such hooks are registered by channel pattern instead
+// of record type.
+const (
+	BeforeSubscribe Kind = "beforeSubscribe"
+	BeforePublish   Kind = "beforePublish"
+)
+
 // Func defines the interface of a function that can be hooked.
 //
 // The supplied record is fully fetched for all four kind of hooks.
@@ -41,6 +49,19 @@ type Func func(context.Context, *skydb.Record, *skydb.Record) skyerr.Error
 
 type recordTypeHookMap map[string][]Func
 
+// ChannelFunc defines the interface of a function that can be hooked
+// to a pubsub channel.
+//
+// The supplied data is the message to be published, and is nil for
+// BeforeSubscribe hooks. Returning an error rejects the subscription or
+// the message.
+type ChannelFunc func(ctx context.Context, channel string, data []byte) skyerr.Error
+
+type channelHook struct {
+	pattern string
+	hook    ChannelFunc
+}
+
 // Registry is a registry of hooks by record type.
 //
 // It provides method to execute hooks but is not responsible to execute
@@ -54,6 +75,9 @@ type Registry struct {
 	afterSaveHooks    recordTypeHookMap
 	beforeDeleteHooks recordTypeHookMap
 	afterDeleteHooks  recordTypeHookMap
+
+	beforeSubscribeHooks []channelHook
+	beforePublishHooks   []channelHook
 }
 
 // NewRegistry returns a Registry ready for use.
@@ -64,6 +88,8 @@ func NewRegistry() *Registry {
 		recordTypeHookMap{},
 		recordTypeHookMap{},
 		recordTypeHookMap{},
+		nil,
+		nil,
 	}
 }
 
@@ -101,6 +127,52 @@ func (r *Registry) ExecuteHooks(ctx context.Context, kind Kind, record *skydb.Re
 	return nil
 }
 
+// RegisterChannelHook adds the specific hook for channels matching the
+// pattern to be executed at the moment provided by kind. The pattern is
+// either a channel name or a prefix followed by "*".
+func (r *Registry) RegisterChannelHook(kind Kind, pattern string, hook ChannelFunc) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch kind {
+	case BeforeSubscribe:
+		r.beforeSubscribeHooks = append(r.beforeSubscribeHooks, channelHook{pattern, hook})
+	case BeforePublish:
+		r.beforePublishHooks = append(r.beforePublishHooks, channelHook{pattern, hook})
+	default:
+		return fmt.Errorf("unrecognized kind of channel hook = %#v", string(kind))
+	}
+	return nil
+}
+
+// ExecuteChannelHooks executes registered hooks for channels matching the
+// supplied channel to be executed at the specific kind of moment.
+//
+// If one of the hooks returns an error, it halts execution of other hooks and
+// returns that error untouched.
+func (r *Registry) ExecuteChannelHooks(ctx context.Context, kind Kind, channel string, data []byte) skyerr.Error {
+	r.mutex.RLock()
+	var channelHooks []channelHook
+	switch kind {
+	case BeforeSubscribe:
+		channelHooks = r.beforeSubscribeHooks
+	case BeforePublish:
+		channelHooks = r.beforePublishHooks
+	}
+	r.mutex.RUnlock()
+
+	for _, h := range channelHooks {
+		if !skydb.MatchChannel(h.pattern, channel) {
+			continue
+		}
+		if err := h.hook(ctx, channel, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (r *Registry) hooks(kind Kind, recordType string) (m []Func, err error) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
@@ -20,6 +20,7 @@ import (
 
 	"github.com/skygeario/skygear-server/pkg/server/plugin/hook/hooktest"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -141,3 +142,45 @@ func TestHookRegistry(t *testing.T) {
 		})
 	})
 }
+
+func TestChannelHookRegistry(t *testing.T) {
+	Convey("Registry", t, func() {
+		registry := NewRegistry()
+		ctx := context.Background()
+
+		executed := []string{}
+		newHook := func(name string, err skyerr.Error) ChannelFunc {
+			return func(ctx context.Context, channel string, data []byte) skyerr.Error {
+				executed = append(executed, name+":"+channel+":"+string(data))
+				return err
+			}
+		}
+
+		Convey("executes hooks of matching channels", func() {
+			So(registry.RegisterChannelHook(BeforeSubscribe, "chat/*", newHook("chat", nil)), ShouldBeNil)
+			So(registry.RegisterChannelHook(BeforeSubscribe, "news", newHook("news", nil)), ShouldBeNil)
+			So(registry.RegisterChannelHook(BeforePublish, "chat/*", newHook("publish", nil)), ShouldBeNil)
+
+			So(registry.ExecuteChannelHooks(ctx, BeforeSubscribe, "chat/lobby", nil), ShouldBeNil)
+			So(registry.ExecuteChannelHooks(ctx, BeforePublish, "chat/lobby", []byte("hi")), ShouldBeNil)
+			So(registry.ExecuteChannelHooks(ctx, BeforeSubscribe, "sports", nil), ShouldBeNil)
+			So(executed, ShouldResemble, []string{
+				"chat:chat/lobby:",
+				"publish:chat/lobby:hi",
+			})
+		})
+
+		Convey("halts at the first error", func() {
+			denied := skyerr.NewError(skyerr.PermissionDenied, "denied")
+			registry.RegisterChannelHook(BeforeSubscribe, "*", newHook("all", denied))
+			registry.RegisterChannelHook(BeforeSubscribe, "chat/*", newHook("chat", nil))
+
+			So(registry.ExecuteChannelHooks(ctx, BeforeSubscribe, "chat/lobby", nil), ShouldEqual, denied)
+			So(executed, ShouldResemble, []string{"all:chat/lobby:"})
+		})
+
+		Convey("rejects record hook kind", func() {
+			So(registry.RegisterChannelHook(BeforeSave, "chat", newHook("chat", nil)), ShouldNotBeNil)
+		})
+	})
+}
@@ -21,11 +21,13 @@ import (
 
 	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 type hookOnlyTransport struct {
-	RunHookFunc func(context.Context, string, *skydb.Record, *skydb.Record) (*skydb.Record, error)
+	RunHookFunc        func(context.Context, string, *skydb.Record, *skydb.Record) (*skydb.Record, error)
+	RunChannelHookFunc func(context.Context, string, string, []byte) error
 	Transport
 }
 
@@ -33,6 +35,10 @@ func (t *hookOnlyTransport) RunHook(ctx context.Context, hookName string, record
 	return t.RunHookFunc(ctx, hookName, record, originalRecord)
 }
 
+func (t *hookOnlyTransport) RunChannelHook(ctx context.Context, hookName string, channel string, data []byte) error {
+	return t.RunChannelHookFunc(ctx, hookName, channel, data)
+}
+
 func TestCreateHookFunc(t *testing.T) {
 	Convey("CreateHookFunc", t, func() {
 		transport := &hookOnlyTransport{}
@@ -119,3 +125,50 @@ func TestCreateHookFunc(t *testing.T) {
 		})
 	})
 }
+
+func TestCreateChannelHookFunc(t *testing.T) {
+	Convey("CreateChannelHookFunc", t, func() {
+		transport := &hookOnlyTransport{}
+		plugin := Plugin{transport: transport}
+
+		hookFunc := CreateChannelHookFunc(&plugin, pluginHookInfo{
+			Trigger: string(hook.BeforePublish),
+			Channel: "chat/*",
+			Name:    "chat_beforePublish",
+		})
+
+		Convey("runs channel hook", func() {
+			called := false
+			transport.RunChannelHookFunc = func(ctx context.Context, hookName string, channel string, data []byte) error {
+				called = true
+				So(hookName, ShouldEqual, "chat_beforePublish")
+				So(channel, ShouldEqual, "chat/lobby")
+				So(data, ShouldResemble, []byte(`{"text":"hi"}`))
+				return nil
+			}
+
+			err := hookFunc(nil, "chat/lobby", []byte(`{"text":"hi"}`))
+			So(called, ShouldBeTrue)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("returns plugin error", func() {
+			transport.RunChannelHookFunc = func(ctx context.Context, hookName string, channel string, data []byte) error {
+				return skyerr.NewError(skyerr.PermissionDenied, "not a member")
+			}
+
+			err := hookFunc(nil, "chat/lobby", nil)
+			So(err.Code(), ShouldEqual, skyerr.PermissionDenied)
+			So(err.Message(), ShouldEqual, "not a member")
+		})
+
+		Convey("wraps transport error", func() {
+			transport.RunChannelHookFunc = func(ctx context.Context, hookName string, channel string, data []byte) error {
+				return errors.New("exit status 1")
+			}
+
+			err := hookFunc(nil, "chat/lobby", nil)
+			So(err.Error(), ShouldEqual, "UnexpectedError: exit status 1")
+		})
+	})
+}
@@ -43,6 +43,7 @@ type httpTransport struct {
 	state       skyplugin.TransportState
 	httpClient  http.Client
 	config      skyconfig.Configuration
+	publish     skyplugin.PublishFunc
 }
 
 func (p *httpTransport) rpc(req *pluginrequest.Request) (out []byte, err error) {
@@ -52,8 +53,9 @@ func (p *httpTransport) rpc(req *pluginrequest.Request) (out []byte, err error)
 	}
 
 	var resp struct {
-		Result json.RawMessage   `json:"result"`
-		Err    *common.ExecError `json:"error"`
+		Result  json.RawMessage         `json:"result"`
+		Err     *common.ExecError       `json:"error"`
+		Publish []common.PublishMessage `json:"publish"`
 	}
 
 	jsonErr := json.Unmarshal(data, &resp)
@@ -79,6 +81,7 @@ func (p *httpTransport) rpc(req *pluginrequest.Request) (out []byte, err error)
 		return
 	}
 
+	p.publish.PublishMessages(resp.Publish)
 	out = resp.Result
 	return
 }
@@ -151,12 +154,21 @@ func (p *httpTransport) RunHook(ctx context.Context, hookName string, record *sk
 	return &recordout, nil
 }
 
+func (p *httpTransport) RunChannelHook(ctx context.Context, hookName string, channel string, data []byte) error {
+	_, err := p.rpc(pluginrequest.NewChannelHookRequest(ctx, hookName, channel, data))
+	return err
+}
+
 func (p *httpTransport) RunTimer(name string, in []byte) (out []byte, err error) {
 	req := pluginrequest.NewTimerRequest(name)
 	out, err = p.rpc(req)
 	return
 }
 
+func (p *httpTransport) SetPublishFunc(publish skyplugin.PublishFunc) {
+	p.publish = publish
+}
+
 func (p *httpTransport) RunProvider(ctx context.Context, request *skyplugin.AuthRequest) (*skyplugin.AuthResponse, error) {
 	req := pluginrequest.NewAuthRequest(ctx, request)
 	out, err := p.rpc(req)
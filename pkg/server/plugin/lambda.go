@@ -28,6 +28,7 @@ type LambdaHandler struct {
 	Name              string
 	AccessKeyRequired bool
 	UserRequired      bool
+	Permissions       []string
 	PreprocessorList  router.PreprocessorRegistry
 	preprocessors     []router.Processor
 }
@@ -40,11 +41,18 @@ func NewLambdaHandler(info map[string]interface{}, ppreg router.PreprocessorRegi
 	}
 	handler.AccessKeyRequired, _ = info["key_required"].(bool)
 	handler.UserRequired, _ = info["user_required"].(bool)
+	if permissions, ok := info["permissions"].([]interface{}); ok {
+		for _, permission := range permissions {
+			if permissionStr, ok := permission.(string); ok {
+				handler.Permissions = append(handler.Permissions, permissionStr)
+			}
+		}
+	}
 	return handler
 }
 
 func (h *LambdaHandler) Setup() {
-	if h.UserRequired {
+	if h.UserRequired || len(h.Permissions) > 0 {
 		h.preprocessors = h.PreprocessorList.GetByNames(
 			"authenticator",
 			"dbconn",
@@ -68,6 +76,11 @@ func (h *LambdaHandler) GetPreprocessors() []router.Processor {
 
 // Handle executes lambda function implemented by the plugin.
 func (h *LambdaHandler) Handle(payload *router.Payload, response *router.Response) {
+	if err := checkPermissions(payload, h.Permissions); err != nil {
+		response.Err = err
+		return
+	}
+
 	inbytes, err := json.Marshal(payload.Data)
 	if err != nil {
 		response.Err = skyerr.MakeError(err)
@@ -24,6 +24,8 @@ import (
 
 	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
 	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
 )
 
 func TestLambdaCreation(t *testing.T) {
@@ -54,6 +56,15 @@ func TestLambdaCreation(t *testing.T) {
 
 		So(handler.AccessKeyRequired, ShouldBeTrue)
 	})
+
+	Convey("create permission required lambda", t, func() {
+		handler := NewLambdaHandler(map[string]interface{}{
+			"name":        "hello:world",
+			"permissions": []interface{}{"post:publish"},
+		}, nil, nil)
+
+		So(handler.Permissions, ShouldResemble, []string{"post:publish"})
+	})
 }
 
 func TestLambdaHandler(t *testing.T) {
@@ -110,3 +121,72 @@ func TestLambdaHandler(t *testing.T) {
 
 	})
 }
+
+func TestLambdaHandlerPermissions(t *testing.T) {
+	Convey("test permission required lambda", t, func() {
+		transport := &nullTransport{}
+		plugin := Plugin{
+			transport: transport,
+		}
+		handler := LambdaHandler{
+			Plugin:      &plugin,
+			Name:        "hello:world",
+			Permissions: []string{"post:publish"},
+		}
+
+		conn := skydbtest.NewMapConn()
+		conn.CreateRole(&skydb.Role{
+			Name:        "writer",
+			Permissions: []string{"post:create"},
+		})
+		conn.CreateRole(&skydb.Role{
+			Name:        "editor",
+			Parent:      "writer",
+			Permissions: []string{"post:publish"},
+		})
+
+		var userinfo *skydb.UserInfo
+		r := handlertest.NewSingleRouteRouter(&handler, func(p *router.Payload) {
+			p.Context = context.Background()
+			p.DBConn = conn
+			p.UserInfo = userinfo
+		})
+
+		Convey("permit user with the permission", func() {
+			userinfo = &skydb.UserInfo{
+				ID:             "userid",
+				Roles:          []string{"editor"},
+				InheritedRoles: []string{"writer"},
+			}
+			resp := r.POST(`{
+	"args": ["bob"]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {"args":["bob"]}
+}`)
+		})
+
+		Convey("reject user without the permission", func() {
+			userinfo = &skydb.UserInfo{
+				ID:    "userid",
+				Roles: []string{"writer"},
+			}
+			resp := r.POST(`{
+	"args": ["bob"]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error":{"code":102,"message":"no permission to perform this action","name":"PermissionDenied"}
+}`)
+		})
+
+		Convey("reject request without user", func() {
+			userinfo = nil
+			resp := r.POST(`{
+	"args": ["bob"]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error":{"code":101,"message":"authentication required","name":"NotAuthenticated"}
+}`)
+		})
+	})
+}
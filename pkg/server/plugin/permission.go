@@ -0,0 +1,46 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	"github.com/skygeario/skygear-server/pkg/server/utils"
+)
+
+// checkPermissions returns an Error if the user of the request is not
+// granted all of the required permissions through the user's roles,
+// including the inherited ones.
+//
+// Request with master key is always permitted.
+func checkPermissions(payload *router.Payload, required []string) skyerr.Error {
+	if len(required) == 0 || payload.HasMasterKey() {
+		return nil
+	}
+
+	if payload.UserInfo == nil {
+		return skyerr.NewError(skyerr.NotAuthenticated, "authentication required")
+	}
+
+	permissions, err := payload.DBConn.GetRolePermissions(payload.UserInfo.EffectiveRoles())
+	if err != nil {
+		return skyerr.MakeError(err)
+	}
+
+	if !utils.StringSliceContainAll(permissions, required) {
+		return skyerr.NewError(skyerr.PermissionDenied, "no permission to perform this action")
+	}
+	return nil
+}
@@ -53,12 +53,14 @@ type pluginHandlerInfo struct {
 	Methods      []string `json:"methods"`
 	KeyRequired  bool     `json:"key_required"`
 	UserRequired bool     `json:"user_required"`
+	Permissions  []string `json:"permissions"`
 }
 
 type pluginHookInfo struct {
 	Async   bool   `json:"async"`   // execute hook asynchronously
 	Trigger string `json:"trigger"` // before_save etc.
 	Type    string `json:"type"`    // record type
+	Channel string `json:"channel"` // channel pattern of pubsub hooks
 	Name    string `json:"name"`    // hook name
 }
 
@@ -123,6 +125,10 @@ type Context struct {
 	ProviderRegistry *provider.Registry
 	Scheduler        *cron.Cron
 	Config           skyconfig.Configuration
+
+	// Publish publishes messages from plugins to pubsub channels. It is
+	// nil if pubsub is not available.
+	Publish PublishFunc
 }
 
 // AddPluginConfiguration creates and appends a plugin
@@ -219,6 +225,7 @@ func (p *Plugin) Init(context *Context) {
 		log.WithField("error", err).Panic("Fail to get init payload")
 	}
 
+	p.transport.SetPublishFunc(context.Publish)
 	p.transport.SendEvent("before-config", data)
 	for {
 		log.
@@ -330,9 +337,12 @@ func (p *Plugin) initLambda(r *router.Router, ppreg router.PreprocessorRegistry,
 func (p *Plugin) initHook(registry *hook.Registry, hookInfos []pluginHookInfo) {
 	for _, hookInfo := range hookInfos {
 		kind := hook.Kind(hookInfo.Trigger)
-		recordType := hookInfo.Type
-
-		registry.Register(kind, recordType, CreateHookFunc(p, hookInfo))
+		switch kind {
+		case hook.BeforeSubscribe, hook.BeforePublish:
+			registry.RegisterChannelHook(kind, hookInfo.Channel, CreateChannelHookFunc(p, hookInfo))
+		default:
+			registry.Register(kind, hookInfo.Type, CreateHookFunc(p, hookInfo))
+		}
 	}
 }
 
@@ -61,6 +61,23 @@ func NewHookRequest(ctx context.Context, hookName string, record *skydb.Record,
 	return &Request{Kind: "hook", Name: hookName, Param: param, Context: ctx}
 }
 
+// ChannelHookRequest contains the channel and message involved in a
+// pubsub channel hook.
+type ChannelHookRequest struct {
+	Channel string           `json:"channel"`
+	Data    *json.RawMessage `json:"data,omitempty"`
+}
+
+// NewChannelHookRequest creates a new pubsub channel hook request.
+func NewChannelHookRequest(ctx context.Context, hookName string, channel string, data []byte) *Request {
+	param := ChannelHookRequest{Channel: channel}
+	if data != nil {
+		rawData := json.RawMessage(data)
+		param.Data = &rawData
+	}
+	return &Request{Kind: "hook", Name: hookName, Param: param, Context: ctx}
+}
+
 // NewAuthRequest creates a new auth request.
 func NewAuthRequest(ctx context.Context, authReq *skyplugin.AuthRequest) *Request {
 	return &Request{
@@ -17,6 +17,7 @@ package plugin
 import (
 	"context"
 
+	"github.com/skygeario/skygear-server/pkg/server/plugin/common"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skyconfig"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
@@ -65,6 +66,23 @@ const (
 // TransportInitHandler models the handler for transport init
 type TransportInitHandler func([]byte, error) error
 
+// PublishFunc publishes data to a pubsub channel on behalf of plugin.
+type PublishFunc func(channel string, data []byte) error
+
+// PublishMessages publishes the messages included in the response of
+// plugin. Errors are logged as they cannot be returned to the plugin.
+func (f PublishFunc) PublishMessages(messages []common.PublishMessage) {
+	for _, message := range messages {
+		if f == nil {
+			log.Warnf("Drop message published by plugin to %v: pubsub is not available", message.Channel)
+			continue
+		}
+		if err := f(message.Channel, message.Data); err != nil {
+			log.WithField("err", err).Errorf("Failed to publish message from plugin to %v", message.Channel)
+		}
+	}
+}
+
 // A Transport represents the interface of data transfer between skygear
 // and remote process.
 type Transport interface {
@@ -84,8 +102,19 @@ type Transport interface {
 	// in any of its memebers with the record being passed in.
 	RunHook(ctx context.Context, hookName string, record *skydb.Record, oldRecord *skydb.Record) (*skydb.Record, error)
 
+	// RunChannelHook runs the pubsub channel hook with a name recognized
+	// by plugin, passing in the channel and the message to be published,
+	// which is nil for subscription. The hook rejects the subscription or
+	// the message by returning an error.
+	RunChannelHook(ctx context.Context, hookName string, channel string, data []byte) error
+
 	RunTimer(name string, in []byte) ([]byte, error)
 
+	// SetPublishFunc sets the function publishing messages included by
+	// plugin in the `publish` field of its responses, which are
+	// published after the request succeeds.
+	SetPublishFunc(PublishFunc)
+
 	// RunProvider runs the auth provider with the specified AuthRequest.
 	RunProvider(context context.Context, request *AuthRequest) (*AuthResponse, error)
 }
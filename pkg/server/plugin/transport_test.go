@@ -54,11 +54,18 @@ func (t *nullTransport) RunHook(ctx context.Context, hookName string, reocrd *sk
 	t.lastContext = ctx
 	return
 }
+func (t *nullTransport) RunChannelHook(ctx context.Context, hookName string, channel string, data []byte) error {
+	t.lastContext = ctx
+	return nil
+}
 func (t *nullTransport) RunTimer(name string, in []byte) (out []byte, err error) {
 	out = in
 	return
 }
 
+func (t *nullTransport) SetPublishFunc(publish PublishFunc) {
+}
+
 func (t *nullTransport) RunProvider(ctx context.Context, request *AuthRequest) (response *AuthResponse, err error) {
 	if request.AuthData == nil {
 		request.AuthData = map[string]interface{}{}
@@ -41,6 +41,7 @@ type zmqTransport struct {
 	initHandler skyplugin.TransportInitHandler
 	logger      *logrus.Entry
 	config      skyconfig.Configuration
+	publish     skyplugin.PublishFunc
 }
 
 func (p *zmqTransport) State() skyplugin.TransportState {
@@ -89,12 +90,21 @@ func (p *zmqTransport) RunHook(ctx context.Context, hookName string, record *sky
 	return &recordout, nil
 }
 
+func (p *zmqTransport) RunChannelHook(ctx context.Context, hookName string, channel string, data []byte) error {
+	_, err := p.rpc(pluginrequest.NewChannelHookRequest(ctx, hookName, channel, data))
+	return err
+}
+
 func (p *zmqTransport) RunTimer(name string, in []byte) (out []byte, err error) {
 	req := pluginrequest.Request{Kind: "timer", Name: name}
 	out, err = p.rpc(&req)
 	return
 }
 
+func (p *zmqTransport) SetPublishFunc(publish skyplugin.PublishFunc) {
+	p.publish = publish
+}
+
 func (p *zmqTransport) RunProvider(ctx context.Context, request *skyplugin.AuthRequest) (resp *skyplugin.AuthResponse, err error) {
 	req := pluginrequest.NewAuthRequest(ctx, request)
 	out, err := p.rpc(req)
@@ -115,8 +125,9 @@ func (p *zmqTransport) rpc(req *pluginrequest.Request) (out []byte, err error) {
 	}
 
 	var resp struct {
-		Result json.RawMessage   `json:"result"`
-		Err    *common.ExecError `json:"error"`
+		Result  json.RawMessage         `json:"result"`
+		Err     *common.ExecError       `json:"error"`
+		Publish []common.PublishMessage `json:"publish"`
 	}
 
 	if err = json.Unmarshal(rawResp, &resp); err != nil {
@@ -140,6 +151,7 @@ func (p *zmqTransport) rpc(req *pluginrequest.Request) (out []byte, err error) {
 		return
 	}
 
+	p.publish.PublishMessages(resp.Publish)
 	out = resp.Result
 	return
 }
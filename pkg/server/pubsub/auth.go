@@ -0,0 +1,40 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// Client is the identity of a client connected to pubsub.
+type Client struct {
+	// UserInfo is the authenticated user, or nil if the client connects
+	// without an access token.
+	UserInfo *skydb.UserInfo
+
+	// MasterKey is true if the client connects with the master key.
+	MasterKey bool
+}
+
+// Authorizer authorizes clients to subscribe and publish to channels.
+type Authorizer interface {
+	// AuthorizeSubscribe returns an error if the client is not allowed
+	// to subscribe to the channel.
+	AuthorizeSubscribe(client Client, channel string) error
+
+	// AuthorizePublish returns an error if the client is not allowed
+	// to publish the data to the channel.
+	AuthorizePublish(client Client, channel string, data []byte) error
+}
@@ -0,0 +1,74 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"sync"
+)
+
+// DeliverFunc is called by a Broker with a message published to the
+// channel. id identifies the message, which is greater than the IDs of
+// all messages published to the channel before.
+type DeliverFunc func(channel string, id uint64, data []byte)
+
+// Broker relays messages published to hubs. A message published to the
+// broker is delivered to every hub subscribing to the broker, which
+// fans out the message to its local connections.
+type Broker interface {
+	// Publish sends the message to all subscribers of the broker,
+	// including the subscriber of the publishing hub.
+	Publish(channel string, data []byte) error
+
+	// Subscribe calls deliver with every message published to the broker
+	// until the returned function is called. Messages are delivered in
+	// the order of their IDs.
+	Subscribe(deliver DeliverFunc) (unsubscribe func())
+}
+
+// localBroker delivers messages to subscribers in the same process.
+type localBroker struct {
+	mutex       sync.Mutex
+	lastID      uint64
+	subscribers map[*DeliverFunc]struct{}
+}
+
+// NewLocalBroker returns a Broker relaying messages among hubs in the
+// same process only. Message IDs restart from 1 with the process.
+func NewLocalBroker() Broker {
+	return &localBroker{
+		subscribers: map[*DeliverFunc]struct{}{},
+	}
+}
+
+func (b *localBroker) Publish(channel string, data []byte) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.lastID++
+	for deliver := range b.subscribers {
+		(*deliver)(channel, b.lastID, data)
+	}
+	return nil
+}
+
+func (b *localBroker) Subscribe(deliver DeliverFunc) func() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.subscribers[&deliver] = struct{}{}
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subscribers, &deliver)
+	}
+}
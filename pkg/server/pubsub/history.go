@@ -0,0 +1,84 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"time"
+)
+
+var timeNow = time.Now
+
+type historyEntry struct {
+	id          uint64
+	data        []byte
+	publishedAt time.Time
+}
+
+// record adds the message to the history of its channel, and removes
+// messages exceeding the history size or retention.
+func (h *Hub) record(parcel Parcel) {
+	if h.HistorySize <= 0 {
+		return
+	}
+
+	entries := append(h.history[parcel.Channel], historyEntry{
+		id:          parcel.ID,
+		data:        parcel.Data,
+		publishedAt: timeNow(),
+	})
+	if len(entries) > h.HistorySize {
+		entries = entries[len(entries)-h.HistorySize:]
+	}
+	h.history[parcel.Channel] = h.expire(entries)
+}
+
+// expire returns the entries within the history retention.
+func (h *Hub) expire(entries []historyEntry) []historyEntry {
+	if h.HistoryRetention <= 0 {
+		return entries
+	}
+
+	expiry := timeNow().Add(-h.HistoryRetention)
+	for i, entry := range entries {
+		if entry.publishedAt.After(expiry) {
+			return entries[i:]
+		}
+	}
+	return nil
+}
+
+// replay returns messages in the history of the channel published after
+// the message with ID since. Messages expired from the history are not
+// returned.
+func (h *Hub) replay(channel string, since uint64) []Parcel {
+	entries := h.expire(h.history[channel])
+	if len(entries) == 0 {
+		delete(h.history, channel)
+		return nil
+	}
+	h.history[channel] = entries
+
+	parcels := []Parcel{}
+	for _, entry := range entries {
+		if entry.id > since {
+			parcels = append(parcels, Parcel{
+				Channel: channel,
+				Data:    entry.data,
+				ID:      entry.id,
+			})
+		}
+	}
+	return parcels
+}
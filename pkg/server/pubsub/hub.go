@@ -15,65 +15,155 @@
 package pubsub
 
 import (
+	"errors"
 	"time"
 )
 
+// ErrPresenceChannel is returned when publishing to a presence channel,
+// where only presence events are published by the hub.
+var ErrPresenceChannel = errors.New("cannot publish to presence channel")
+
 // Parcel is the protocol that Hub talk with
 type Parcel struct {
 	Channel    string
 	Data       []byte
 	Connection *connection
+
+	// ID is the ID of a message delivered to a connection.
+	ID uint64
+
+	// Since is the ID of the last message received by the subscribing
+	// connection. If it is not nil, messages published after it are
+	// replayed from the history of the channel.
+	Since *uint64
 }
 
 // Hub is the struct that hold the subscription and do the broadcast logic
+//
+// Messages sent to Broadcast are published to the broker of the hub, and
+// messages received from the broker are sent to the local connections
+// subscribing to the channel. Hubs sharing a broker, such as hubs on
+// different server instances connecting to the same Redis, receive
+// messages broadcast by each other.
+//
+// Messages are sent to a connection without waiting. A connection that
+// does not receive messages as fast as they are published is closed.
 type Hub struct {
-	Subscribe    chan Parcel
-	Unsubscribe  chan Parcel
-	Broadcast    chan Parcel
-	stop         chan int
-	subscription map[string][]*connection
-	channels     map[string]chan []byte
-	timeout      time.Duration
+	Subscribe     chan Parcel
+	Unsubscribe   chan Parcel
+	Broadcast     chan Parcel
+	stop          chan int
+	done          chan struct{}
+	deliver       chan Parcel
+	broker        Broker
+	presence      map[string]map[string]Member
+	presenceQuery chan presenceRequest
+	subscription  map[string][]*connection
+	channels      map[string]chan []byte
+	history       map[string][]historyEntry
+
+	// HistorySize is the maximum number of messages kept in the history
+	// of each channel. History is disabled if it is zero.
+	HistorySize int
+
+	// HistoryRetention is how long messages are kept in the history. If
+	// it is zero, messages are kept until there are more than
+	// HistorySize messages.
+	HistoryRetention time.Duration
 }
 
-// NewHub is factory for Hub
+// NewHub is factory for Hub, which broadcasts messages to connections in
+// the same hub only.
 func NewHub() *Hub {
+	return NewHubWithBroker(NewLocalBroker())
+}
+
+// NewHubWithBroker returns a Hub broadcasting messages via the broker.
+func NewHubWithBroker(broker Broker) *Hub {
 	return &Hub{
-		Subscribe:    make(chan Parcel),
-		Unsubscribe:  make(chan Parcel),
-		Broadcast:    make(chan Parcel),
-		stop:         make(chan int),
-		subscription: map[string][]*connection{},
-		channels:     map[string]chan []byte{},
-		timeout:      1,
+		Subscribe:     make(chan Parcel),
+		Unsubscribe:   make(chan Parcel),
+		Broadcast:     make(chan Parcel),
+		stop:          make(chan int),
+		done:          make(chan struct{}),
+		deliver:       make(chan Parcel),
+		broker:        broker,
+		presence:      map[string]map[string]Member{},
+		presenceQuery: make(chan presenceRequest),
+		subscription:  map[string][]*connection{},
+		channels:      map[string]chan []byte{},
+		history:       map[string][]historyEntry{},
 	}
 }
 
 func (h *Hub) run() {
 	log.Debugf("Hub running %p", h)
+	unsubscribe := h.broker.Subscribe(func(channel string, id uint64, data []byte) {
+		select {
+		case h.deliver <- Parcel{Channel: channel, Data: data, ID: id}:
+		case <-h.done:
+		}
+	})
 	defer func() {
-		log.Info("Hub stopped %p!", h)
+		close(h.done)
+		unsubscribe()
+		log.Infof("Hub stopped %p!", h)
 	}()
+
+	go h.relay()
 	for {
 		select {
 		case p := <-h.Subscribe:
-			h.subscribe(p.Channel, p.Connection)
+			h.subscribe(p.Channel, p.Connection, p.Since)
 		case p := <-h.Unsubscribe:
 			h.unsubscribe(p.Channel, p.Connection)
+		case p := <-h.deliver:
+			h.updatePresence(p.Channel, p.Data)
+			h.record(p)
+			h.fanOut(p)
+		case req := <-h.presenceQuery:
+			req.members <- h.members(req.channel)
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// relay publishes messages sent to Broadcast to the broker. Publishing
+// is done outside the run loop because the broker might deliver the
+// message back to this hub before Publish returns.
+func (h *Hub) relay() {
+	for {
+		select {
 		case p := <-h.Broadcast:
 			log.Warnf("Broadcast %v:%s", p.Channel, p.Data)
-			h.publish(p.Channel, p.Data)
-		case <-h.stop:
+			if err := h.publish(p.Channel, p.Data); err != nil {
+				log.WithField("err", err).Errorf("Failed to broadcast to %v", p.Channel)
+			}
+		case <-h.done:
 			return
 		}
 	}
 }
 
-func (h *Hub) timeOut() <-chan time.Time {
-	return time.After(h.timeout * time.Second)
+// Publish publishes the data to the channel. It returns after the message
+// is accepted by the broker. Unlike sending to Broadcast, it returns the
+// error of publishing, and is safe to be called by any goroutine while
+// the hub is running.
+//
+// The data is published without authorization.
+func (h *Hub) Publish(channel string, data []byte) error {
+	if _, ok := ParsePresenceChannel(channel); ok {
+		return ErrPresenceChannel
+	}
+	return h.publish(channel, data)
+}
+
+func (h *Hub) publish(channel string, data []byte) error {
+	return h.broker.Publish(channel, data)
 }
 
-func (h *Hub) subscribe(channel string, c *connection) {
+func (h *Hub) subscribe(channel string, c *connection, since *uint64) {
 	for _, existing := range h.subscription[channel] {
 		if existing == c {
 			return
@@ -81,6 +171,14 @@ func (h *Hub) subscribe(channel string, c *connection) {
 	}
 	log.Debugf("subscribe %v, %p", channel, c)
 	h.subscription[channel] = append(h.subscription[channel], c)
+
+	if since != nil {
+		for _, parcel := range h.replay(channel, *since) {
+			if !h.send(c, parcel) {
+				return
+			}
+		}
+	}
 }
 
 func (h *Hub) unsubscribe(channel string, c *connection) {
@@ -91,24 +189,45 @@ func (h *Hub) unsubscribe(channel string, c *connection) {
 			newSubscription = append(newSubscription, conn)
 		}
 	}
+	if len(newSubscription) == 0 {
+		delete(h.subscription, channel)
+		return
+	}
 	h.subscription[channel] = newSubscription
 }
 
-func (h *Hub) publish(channel string, data []byte) {
-	log.Debugf("publish %v, %s", channel, data)
-	parcel := Parcel{
-		Channel: channel,
-		Data:    data,
+// fanOut sends the message delivered by the broker to connections
+// subscribing to its channel.
+func (h *Hub) fanOut(parcel Parcel) {
+	log.Debugf("publish %v, %d, %s", parcel.Channel, parcel.ID, parcel.Data)
+	for _, c := range h.subscription[parcel.Channel] {
+		h.send(c, parcel)
 	}
-	for _, c := range h.subscription[channel] {
-		c := c
-		go func() {
-			select {
-			case c.Send <- parcel:
-				log.Debugf("Published to %p", c)
-			case <-h.timeOut():
-				log.Warnf("Can't publish, %p, %v:%s", c, channel, data)
-			}
-		}()
+}
+
+// send sends the message to the connection without blocking. If the
+// connection is not ready to receive, it is closed as a slow consumer
+// and false is returned.
+func (h *Hub) send(c *connection, parcel Parcel) bool {
+	select {
+	case c.Send <- parcel:
+		log.Debugf("Published to %p", c)
+		return true
+	default:
+		log.Warnf("Close slow consumer %p, %v:%d", c, parcel.Channel, parcel.ID)
+		h.close(c, "slow consumer")
+		return false
+	}
+}
+
+// close unsubscribes the connection from all channels and asks the
+// connection to close with the reason.
+func (h *Hub) close(c *connection, reason string) {
+	for channel := range h.subscription {
+		h.unsubscribe(channel, c)
+	}
+	select {
+	case c.closeReason <- reason:
+	default:
 	}
 }
@@ -15,10 +15,13 @@
 package pubsub
 
 import (
-	. "github.com/smartystreets/goconvey/convey"
+	"encoding/json"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
 )
 
 func TestNormalSubscription(t *testing.T) {
@@ -27,7 +30,7 @@ func TestNormalSubscription(t *testing.T) {
 			hub := NewHub()
 			go hub.run()
 			conn := connection{
-				Send: make(chan Parcel),
+				Send: make(chan Parcel, 1),
 			}
 			go func() {
 				recv := <-conn.Send
@@ -49,7 +52,7 @@ func TestNormalSubscription(t *testing.T) {
 			hub := NewHub()
 			go hub.run()
 			conn := connection{
-				Send: make(chan Parcel),
+				Send: make(chan Parcel, 1),
 			}
 			hub.Subscribe <- Parcel{
 				Channel:    "correct",
@@ -84,7 +87,7 @@ func TestNormalSubscription(t *testing.T) {
 			hub := NewHub()
 			go hub.run()
 			conn := connection{
-				Send: make(chan Parcel),
+				Send: make(chan Parcel, 1),
 			}
 			hub.Subscribe <- Parcel{
 				Channel:    "correct",
@@ -112,7 +115,7 @@ func TestNormalSubscription(t *testing.T) {
 			hub := NewHub()
 			go hub.run()
 			conn := connection{
-				Send: make(chan Parcel),
+				Send: make(chan Parcel, 1),
 			}
 			wg := sync.WaitGroup{}
 			wg.Add(1)
@@ -145,34 +148,263 @@ func TestNormalSubscription(t *testing.T) {
 			hub.stop <- 1
 		})
 
-		Convey("Received broadcast message time out", func(c C) {
+		Convey("Close slow consumer", func(c C) {
 			hub := NewHub()
-			hub.timeout = 0
 			go hub.run()
 			conn := connection{
-				Send: make(chan Parcel),
+				Send:        make(chan Parcel, 1),
+				closeReason: make(chan string, 1),
+			}
+			hub.Subscribe <- Parcel{
+				Channel:    "first",
+				Connection: &conn,
 			}
-			wg := sync.WaitGroup{}
-			wg.Add(1)
-			go func() {
-				hub.Subscribe <- Parcel{
-					Channel:    "first",
-					Connection: &conn,
-				}
-				wg.Done()
-			}()
-			wg.Wait()
 			hub.Broadcast <- Parcel{
 				Channel: "first",
 				Data:    []byte("1"),
 			}
-			time.Sleep(1 * time.Second)
+			hub.Broadcast <- Parcel{
+				Channel: "first",
+				Data:    []byte("2"),
+			}
+
+			select {
+			case reason := <-conn.closeReason:
+				c.So(reason, ShouldEqual, "slow consumer")
+			case <-time.After(50 * time.Millisecond):
+				t.Fatal("slow consumer is not closed")
+			}
+
+			recv := <-conn.Send
+			c.So(recv.Data, ShouldResemble, []byte("1"))
+			hub.Broadcast <- Parcel{
+				Channel: "first",
+				Data:    []byte("3"),
+			}
 			select {
 			case <-conn.Send:
-				t.Fatalf("Message should be time out!")
-			default:
+				t.Fatal("received message after closed")
+			case <-time.After(50 * time.Millisecond):
+				// do nothing
+			}
+			hub.stop <- 1
+		})
+	})
+}
+
+func TestBrokerSubscription(t *testing.T) {
+	Convey("Hubs sharing a broker", t, func(c C) {
+		broker := NewLocalBroker()
+		hub1 := NewHubWithBroker(broker)
+		hub2 := NewHubWithBroker(broker)
+		go hub1.run()
+		go hub2.run()
+
+		conn1 := connection{
+			Send: make(chan Parcel, 1),
+		}
+		conn2 := connection{
+			Send: make(chan Parcel, 1),
+		}
+		hub1.Subscribe <- Parcel{
+			Channel:    "correct",
+			Connection: &conn1,
+		}
+		hub2.Subscribe <- Parcel{
+			Channel:    "correct",
+			Connection: &conn2,
+		}
+
+		Convey("Received message broadcast to other hub", func(c C) {
+			hub1.Broadcast <- Parcel{
+				Channel: "correct",
+				Data:    []byte("Hello"),
+			}
+
+			for _, conn := range []*connection{&conn1, &conn2} {
+				select {
+				case recv := <-conn.Send:
+					c.So(recv.Channel, ShouldEqual, "correct")
+					c.So(recv.Data, ShouldResemble, []byte("Hello"))
+				case <-time.After(50 * time.Millisecond):
+					t.Fatal("did not receive message broadcast by other hub")
+				}
+			}
+		})
+
+		Convey("No receive message after other hub stopped", func(c C) {
+			hub2.stop <- 1
+			hub1.Broadcast <- Parcel{
+				Channel: "correct",
+				Data:    []byte("Hello"),
+			}
+
+			select {
+			case <-conn1.Send:
+				// do nothing
+			case <-time.After(50 * time.Millisecond):
+				t.Fatal("did not receive message after other hub stopped")
+			}
+
+			select {
+			case <-conn2.Send:
+				t.Fatal("received message from stopped hub")
+			case <-time.After(50 * time.Millisecond):
+				// do nothing
+			}
+		})
+
+		Reset(func() {
+			select {
+			case hub1.stop <- 1:
+			case <-hub1.done:
+			}
+			select {
+			case hub2.stop <- 1:
+			case <-hub2.done:
 			}
+		})
+	})
+}
+
+func TestHubPresence(t *testing.T) {
+	Convey("Hub presence", t, func() {
+		hub := NewHub()
+		go hub.run()
+		defer func() {
+			hub.stop <- 1
+		}()
+
+		broadcast := func(channel string, event PresenceEvent) {
+			data, _ := json.Marshal(event)
+			hub.Broadcast <- Parcel{
+				Channel: channel,
+				Data:    data,
+			}
+		}
+		// presence is updated asynchronously after broadcast
+		presence := func(channel string, expected []Member) []Member {
+			for i := 0; i < 10; i++ {
+				if members := hub.Presence(channel); reflect.DeepEqual(members, expected) {
+					return members
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			return hub.Presence(channel)
+		}
+
+		state := json.RawMessage(`{"status":"away"}`)
+		broadcast("room/presence", PresenceEvent{PresenceJoin, Member{ID: "2", UserID: "user1"}})
+		broadcast("room/presence", PresenceEvent{PresenceJoin, Member{ID: "1", UserID: "user2"}})
+		broadcast("room/presence", PresenceEvent{PresenceJoin, Member{ID: "3", UserID: "user1"}})
+		broadcast("room/presence", PresenceEvent{PresenceUpdate, Member{ID: "3", UserID: "user1", State: &state}})
+		expected := []Member{
+			{ID: "2", UserID: "user1"},
+			{ID: "3", UserID: "user1", State: &state},
+			{ID: "1", UserID: "user2"},
+		}
+		So(presence("room", expected), ShouldResemble, expected)
+
+		broadcast("room/presence", PresenceEvent{PresenceLeave, Member{ID: "2", UserID: "user1"}})
+		hub.Broadcast <- Parcel{
+			Channel: "room/presence",
+			Data:    []byte("malformed"),
+		}
+		expected = []Member{
+			{ID: "3", UserID: "user1", State: &state},
+			{ID: "1", UserID: "user2"},
+		}
+		So(presence("room", expected), ShouldResemble, expected)
+		So(hub.Presence("other"), ShouldResemble, []Member{})
+	})
+}
+
+func TestHubHistory(t *testing.T) {
+	Convey("Hub history", t, func() {
+		now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		timeNow = func() time.Time { return now }
+		defer func() {
+			timeNow = time.Now
+		}()
+
+		hub := NewHub()
+		hub.HistorySize = 2
+		go hub.run()
+		defer func() {
 			hub.stop <- 1
+		}()
+
+		// messages are recorded before received by the watcher
+		watcher := connection{
+			Send: make(chan Parcel, 10),
+		}
+		hub.Subscribe <- Parcel{
+			Channel:    "chat",
+			Connection: &watcher,
+		}
+		broadcast := func(data string) {
+			hub.Broadcast <- Parcel{
+				Channel: "chat",
+				Data:    []byte(data),
+			}
+			<-watcher.Send
+		}
+		subscribe := func(since *uint64) []Parcel {
+			conn := connection{
+				Send: make(chan Parcel, 10),
+			}
+			hub.Subscribe <- Parcel{
+				Channel:    "chat",
+				Connection: &conn,
+				Since:      since,
+			}
+			hub.Unsubscribe <- Parcel{
+				Channel:    "chat",
+				Connection: &conn,
+			}
+			close(conn.Send)
+
+			parcels := []Parcel{}
+			for parcel := range conn.Send {
+				parcels = append(parcels, parcel)
+			}
+			return parcels
+		}
+		since := func(id uint64) *uint64 {
+			return &id
+		}
+
+		broadcast("1")
+		broadcast("2")
+		now = now.Add(time.Minute)
+		broadcast("3")
+
+		Convey("replays messages after since", func() {
+			So(subscribe(since(2)), ShouldResemble, []Parcel{
+				{Channel: "chat", Data: []byte("3"), ID: 3},
+			})
+			So(subscribe(since(3)), ShouldBeEmpty)
+		})
+
+		Convey("keeps messages up to history size", func() {
+			So(subscribe(since(0)), ShouldResemble, []Parcel{
+				{Channel: "chat", Data: []byte("2"), ID: 2},
+				{Channel: "chat", Data: []byte("3"), ID: 3},
+			})
+		})
+
+		Convey("keeps messages within history retention", func() {
+			hub.HistoryRetention = time.Minute
+			So(subscribe(since(0)), ShouldResemble, []Parcel{
+				{Channel: "chat", Data: []byte("3"), ID: 3},
+			})
+
+			now = now.Add(time.Minute)
+			So(subscribe(since(0)), ShouldBeEmpty)
+		})
+
+		Convey("does not replay without since", func() {
+			So(subscribe(nil), ShouldBeEmpty)
 		})
 	})
 }
@@ -0,0 +1,146 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+const presenceSuffix = "/presence"
+
+// PresenceChannel returns the channel where presence events of the
+// channel are published.
+func PresenceChannel(channel string) string {
+	return channel + presenceSuffix
+}
+
+// ParsePresenceChannel returns the channel of which presence events are
+// published to the presence channel. ok is false if the channel is not
+// a presence channel.
+func ParsePresenceChannel(channel string) (parent string, ok bool) {
+	if !strings.HasSuffix(channel, presenceSuffix) {
+		return "", false
+	}
+	parent = strings.TrimSuffix(channel, presenceSuffix)
+	return parent, parent != ""
+}
+
+// Member is a connection subscribing to a channel.
+type Member struct {
+	// ID identifies the connection.
+	ID string `json:"id"`
+
+	// UserID is the ID of the authenticated user of the connection.
+	UserID string `json:"user_id,omitempty"`
+
+	// State is the custom state set by the client.
+	State *json.RawMessage `json:"state,omitempty"`
+}
+
+// PresenceEventType is the type of a presence event.
+type PresenceEventType string
+
+const (
+	// PresenceJoin is published when a connection subscribes to a
+	// channel.
+	PresenceJoin PresenceEventType = "join"
+
+	// PresenceLeave is published when a connection unsubscribes from a
+	// channel or is closed.
+	PresenceLeave PresenceEventType = "leave"
+
+	// PresenceUpdate is published when a connection changes its state.
+	PresenceUpdate PresenceEventType = "update"
+)
+
+// PresenceEvent is published to the presence channel of a channel when
+// the members of the channel changes.
+type PresenceEvent struct {
+	Event  PresenceEventType `json:"event"`
+	Member Member            `json:"member"`
+}
+
+type presenceRequest struct {
+	channel string
+	members chan []Member
+}
+
+// Presence returns the members of the channel, ordered by user ID and
+// then connection ID.
+//
+// Members are learnt from the presence events received by the hub. When
+// the hub shares a broker with hubs on other servers, members connected
+// to other servers before this hub starts are not known, and members of
+// a server are not removed if the server stops unexpectedly.
+func (h *Hub) Presence(channel string) []Member {
+	req := presenceRequest{
+		channel: channel,
+		members: make(chan []Member, 1),
+	}
+	h.presenceQuery <- req
+	return <-req.members
+}
+
+func (h *Hub) members(channel string) []Member {
+	members := []Member{}
+	for _, member := range h.presence[channel] {
+		members = append(members, member)
+	}
+	sort.Sort(memberList(members))
+	return members
+}
+
+type memberList []Member
+
+func (l memberList) Len() int      { return len(l) }
+func (l memberList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l memberList) Less(i, j int) bool {
+	if l[i].UserID != l[j].UserID {
+		return l[i].UserID < l[j].UserID
+	}
+	return l[i].ID < l[j].ID
+}
+
+// updatePresence updates the members of a channel with the event
+// published to its presence channel.
+func (h *Hub) updatePresence(presenceChannel string, data []byte) {
+	channel, ok := ParsePresenceChannel(presenceChannel)
+	if !ok {
+		return
+	}
+
+	var event PresenceEvent
+	if err := json.Unmarshal(data, &event); err != nil || event.Member.ID == "" {
+		log.Warnf("Ignore malformed presence event %v:%s", presenceChannel, data)
+		return
+	}
+
+	members := h.presence[channel]
+	switch event.Event {
+	case PresenceJoin, PresenceUpdate:
+		if members == nil {
+			members = map[string]Member{}
+			h.presence[channel] = members
+		}
+		members[event.Member.ID] = event.Member
+	case PresenceLeave:
+		delete(members, event.Member.ID)
+		if len(members) == 0 {
+			delete(h.presence, channel)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// redisBrokerRetryInterval is the interval to wait before subscribing to
+// Redis again after the subscription is lost.
+const redisBrokerRetryInterval = 5 * time.Second
+
+// redisPublishScript assigns the message an ID and publishes the
+// message with its ID atomically, so that messages are received in the
+// order of their IDs.
+var redisPublishScript = redis.NewScript(2, `
+local id = redis.call('INCR', KEYS[1])
+redis.call('PUBLISH', KEYS[2], id .. ':' .. ARGV[1])
+return id
+`)
+
+// RedisBroker relays messages with Redis pub/sub, so that messages
+// published on one server instance are delivered to subscribers on all
+// instances connecting to the same Redis.
+//
+// A channel is published to the Redis channel with the prefix prepended.
+// Each subscriber receives messages of all channels with the prefix.
+// Message IDs are generated by incrementing the key `<prefix>:_last_id`.
+type RedisBroker struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+// NewRedisBroker returns a RedisBroker connecting to the Redis server at
+// the URL.
+//
+// prefix is a string prepending to channel names in Redis, which
+// separates messages of different hubs sharing the same Redis.
+func NewRedisBroker(url string, prefix string) *RedisBroker {
+	return &RedisBroker{
+		pool: &redis.Pool{
+			MaxIdle: 10,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(url)
+			},
+			TestOnBorrow: func(c redis.Conn, t time.Time) error {
+				_, err := c.Do("PING")
+				return err
+			},
+		},
+		prefix: prefix + ":",
+	}
+}
+
+func (b *RedisBroker) Publish(channel string, data []byte) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	_, err := redisPublishScript.Do(conn, b.prefix+"_last_id", b.prefix+channel, data)
+	return err
+}
+
+func (b *RedisBroker) Subscribe(deliver DeliverFunc) func() {
+	done := make(chan struct{})
+	go func() {
+		for {
+			if err := b.receive(done, deliver); err != nil {
+				log.WithField("err", err).Errorln("pubsub: redis subscription lost")
+			}
+
+			select {
+			case <-done:
+				return
+			case <-time.After(redisBrokerRetryInterval):
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// receive delivers messages from Redis until done is closed or the
+// connection fails.
+func (b *RedisBroker) receive(done chan struct{}, deliver DeliverFunc) error {
+	conn := redis.PubSubConn{Conn: b.pool.Get()}
+	if err := conn.PSubscribe(b.prefix + "*"); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// closing the connection interrupts the blocking Receive
+	received := make(chan struct{})
+	defer close(received)
+	go func() {
+		select {
+		case <-done:
+		case <-received:
+		}
+		conn.Close()
+	}()
+
+	for {
+		switch v := conn.Receive().(type) {
+		case redis.PMessage:
+			id, data, err := parseRedisMessage(v.Data)
+			if err != nil {
+				log.WithField("err", err).Warnf("pubsub: ignore malformed redis message on %v", v.Channel)
+				continue
+			}
+			deliver(v.Channel[len(b.prefix):], id, data)
+		case error:
+			select {
+			case <-done:
+				return nil
+			default:
+				return v
+			}
+		}
+	}
+}
+
+// parseRedisMessage parses the message in the form `<id>:<data>` published
+// by redisPublishScript.
+func parseRedisMessage(message []byte) (uint64, []byte, error) {
+	i := bytes.IndexByte(message, ':')
+	if i < 0 {
+		return 0, nil, fmt.Errorf("missing message id")
+	}
+	id, err := strconv.ParseUint(string(message[:i]), 10, 64)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, message[i+1:], nil
+}
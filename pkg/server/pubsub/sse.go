@@ -0,0 +1,145 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/uuid"
+)
+
+// sseHeartbeatInterval is the interval of heartbeat comments sent to an
+// event stream, which keep proxies from closing an idle stream.
+var sseHeartbeatInterval = 15 * time.Second
+
+// HandleSSE streams messages published to the channels to the client as
+// Server-Sent Events, for clients that cannot connect with websocket,
+// such as clients behind proxies blocking websocket upgrades. The
+// client is a member of the channels as if it subscribes to them over
+// the websocket.
+//
+// Each message is sent as an event with the message ID as the event ID,
+// and the message as sent over the websocket as data:
+//
+//	id: 42
+//	data: {"channel":"royuen","data":{"any":"thing"},"id":42}
+//
+// If since is not nil, messages after the message with ID since are
+// replayed, so that a reconnecting client resumes from its last event
+// ID. A heartbeat comment is sent if no messages are sent for a while.
+// The stream ends when the client disconnects, or when the client does
+// not receive messages as fast as they are published.
+//
+// The client is not authorized by HandleSSE. The caller should call
+// AuthorizeSubscribe for each channel beforehand.
+func (w *WsPubSub) HandleSSE(writer http.ResponseWriter, req *http.Request, client Client, channels []string, since *uint64) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	c := &connection{
+		id:      uuid.New(),
+		client:  client,
+		queries: map[string]func(){},
+		Send:    make(chan Parcel, sendQueueSize+w.hub.HistorySize),
+		done:    make(chan bool),
+
+		closeReason: make(chan string, 1),
+	}
+	for _, channel := range channels {
+		if c.subscribed(channel) {
+			continue
+		}
+		w.hub.Subscribe <- Parcel{
+			Channel:    channel,
+			Connection: c,
+			Since:      since,
+		}
+		c.channels = append(c.channels, channel)
+		w.publishPresence(c, channel, PresenceJoin)
+	}
+	defer func() {
+		log.Debugf("Close event stream %p", c)
+		for _, channel := range c.channels {
+			w.hub.Unsubscribe <- Parcel{
+				Channel:    channel,
+				Connection: c,
+			}
+			w.publishPresence(c, channel, PresenceLeave)
+		}
+		close(c.done)
+	}()
+
+	// The response is sent after subscribing, so that messages published
+	// after the client receives the response are streamed.
+	header := writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	// disable response buffering of nginx
+	header.Set("X-Accel-Buffering", "no")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case parcel := <-c.Send:
+			log.Debugf("Writing event stream %p, %s, %s", c, parcel.Channel, parcel.Data)
+			err = writeSSEEvent(writer, parcel)
+		case <-heartbeat.C:
+			_, err = io.WriteString(writer, ": heartbeat\n\n")
+		case reason := <-c.closeReason:
+			log.Debugf("Closing event stream %p: %v", c, reason)
+			fmt.Fprintf(writer, ": closed: %s\n\n", reason)
+			flusher.Flush()
+			return
+		case <-req.Context().Done():
+			return
+		}
+		if err != nil {
+			log.Debugf("Event stream error %v", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSEEvent(writer io.Writer, parcel Parcel) error {
+	d := json.RawMessage(parcel.Data)
+	message, err := json.Marshal(wsPayload{
+		Channel: parcel.Channel,
+		Data:    &d,
+		ID:      parcel.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if parcel.ID != 0 {
+		if _, err := fmt.Fprintf(writer, "id: %d\n", parcel.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(writer, "data: %s\n\n", message)
+	return err
+}
@@ -0,0 +1,109 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWsPubSubSSE(t *testing.T) {
+	Convey("WsPubSub streams Server-Sent Events", t, func() {
+		hub := NewHub()
+		hub.HistorySize = 10
+		ws := NewWsPubsub(hub)
+		defer func() {
+			hub.stop <- 1
+		}()
+
+		defer func(interval time.Duration) {
+			sseHeartbeatInterval = interval
+		}(sseHeartbeatInterval)
+
+		connect := func(url string) (*http.Response, *bufio.Reader) {
+			resp, err := http.Get(url)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+			return resp, bufio.NewReader(resp.Body)
+		}
+
+		readEvent := func(reader *bufio.Reader) string {
+			event := ""
+			for {
+				line, err := reader.ReadString('\n')
+				So(err, ShouldBeNil)
+				if line == "\n" {
+					return event
+				}
+				event += line
+			}
+		}
+
+		Convey("with server", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var since *uint64
+				if s := r.URL.Query().Get("since"); s != "" {
+					id, _ := strconv.ParseUint(s, 10, 64)
+					since = &id
+				}
+				ws.HandleSSE(w, r, Client{}, []string{"news", "chat", "news"}, since)
+			}))
+			defer server.Close()
+
+			Convey("streams messages of channels", func() {
+				resp, reader := connect(server.URL)
+				defer resp.Body.Close()
+
+				So(hub.Publish("news", []byte(`1`)), ShouldBeNil)
+				So(hub.Publish("other", []byte(`2`)), ShouldBeNil)
+				So(hub.Publish("chat", []byte(`{"text":"hello"}`)), ShouldBeNil)
+				So(readEvent(reader), ShouldEqual, "id: 3\ndata: {\"channel\":\"news\",\"data\":1,\"id\":3}\n")
+				So(readEvent(reader), ShouldEqual, "id: 5\ndata: {\"channel\":\"chat\",\"data\":{\"text\":\"hello\"},\"id\":5}\n")
+			})
+
+			Convey("replays messages since last event ID", func() {
+				resp, reader := connect(server.URL)
+				defer resp.Body.Close()
+				So(hub.Publish("news", []byte(`1`)), ShouldBeNil)
+				So(hub.Publish("news", []byte(`2`)), ShouldBeNil)
+				So(readEvent(reader), ShouldEqual, "id: 3\ndata: {\"channel\":\"news\",\"data\":1,\"id\":3}\n")
+				So(readEvent(reader), ShouldEqual, "id: 4\ndata: {\"channel\":\"news\",\"data\":2,\"id\":4}\n")
+
+				resumed, reader := connect(server.URL + "?since=3")
+				defer resumed.Body.Close()
+				So(readEvent(reader), ShouldEqual, "id: 4\ndata: {\"channel\":\"news\",\"data\":2,\"id\":4}\n")
+			})
+		})
+
+		Convey("sends heartbeat", func() {
+			sseHeartbeatInterval = 10 * time.Millisecond
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ws.HandleSSE(w, r, Client{}, []string{"news"}, nil)
+			}))
+			defer server.Close()
+
+			resp, reader := connect(server.URL)
+			defer resp.Body.Close()
+			So(readEvent(reader), ShouldEqual, ": heartbeat\n")
+		})
+	})
+}
@@ -17,27 +17,165 @@ package pubsub
 import (
 	"encoding/json"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/skygeario/skygear-server/pkg/server/uuid"
+)
+
+const (
+	// sendQueueSize is the number of messages queued for a connection,
+	// in addition to the history size of the hub. A connection is closed
+	// if its queue is full.
+	sendQueueSize = 256
+
+	// closeTimeout is the time allowed to send the close message.
+	closeTimeout = time.Second
 )
 
 type connection struct {
 	ws       *websocket.Conn
+	id       string
+	client   Client
+	state    *json.RawMessage
 	channels []string
+	queries  map[string]func()
 	Send     chan Parcel
 	done     chan bool
+
+	// closeReason receives the reason when the hub closes the
+	// connection.
+	closeReason chan string
+
+	// writeMutex serializes writes to ws, which are made by both the
+	// reader and the writer goroutines.
+	writeMutex sync.Mutex
+}
+
+func (c *connection) writeMessage(messageType int, data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.ws.WriteMessage(messageType, data)
+}
+
+// send sends data to the connection on the channel. It returns without
+// sending if the connection is closed.
+func (c *connection) send(channel string, data []byte) {
+	select {
+	case c.Send <- Parcel{Channel: channel, Data: data}:
+	case <-c.done:
+		log.Debugf("Connection closed, drop message %p, %v:%s", c, channel, data)
+	}
+}
+
+// trySend sends data to the connection on the channel without blocking.
+// If the connection is not ready to receive, it is closed as a slow
+// consumer and false is returned.
+func (c *connection) trySend(channel string, data []byte) bool {
+	select {
+	case c.Send <- Parcel{Channel: channel, Data: data}:
+		return true
+	case <-c.done:
+		log.Debugf("Connection closed, drop message %p, %v:%s", c, channel, data)
+		return false
+	default:
+		log.Warnf("Close slow consumer %p, %v", c, channel)
+		select {
+		case c.closeReason <- "slow consumer":
+		default:
+		}
+		return false
+	}
+}
+
+// member returns the connection as a member of channels.
+func (c *connection) member() Member {
+	member := Member{
+		ID:    c.id,
+		State: c.state,
+	}
+	if c.client.UserInfo != nil {
+		member.UserID = c.client.UserInfo.ID
+	}
+	return member
+}
+
+func (c *connection) subscribed(channel string) bool {
+	for _, subscribed := range c.channels {
+		if subscribed == channel {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *connection) removeChannel(channel string) {
+	channels := []string{}
+	for _, subscribed := range c.channels {
+		if subscribed != channel {
+			channels = append(channels, subscribed)
+		}
+	}
+	c.channels = channels
 }
 
 type wsPayload struct {
 	Action  string           `json:"action,omitempty"`
 	Channel string           `json:"channel"`
 	Data    *json.RawMessage `json:"data,omitempty"`
+	ID      uint64           `json:"id,omitempty"`
+	Since   *uint64          `json:"since,omitempty"`
+}
+
+// QueryHandler handles live queries subscribed over the websocket.
+type QueryHandler interface {
+	// SubscribeQuery starts a live query with the query data sent by the
+	// client. Results of the live query, including any error, are
+	// sent to the client by calling send.
+	//
+	// send does not block. A client not receiving results as fast as
+	// they are sent is closed as a slow consumer.
+	//
+	// The returned function stops the live query. It is nil if the
+	// live query cannot be started.
+	SubscribeQuery(client Client, data []byte, send func(data []byte)) (unsubscribe func())
 }
 
 // WsPubSub is a websocket trsnaport of pubsub
 // Protocol: {"action": "sub", "channel": "royuen"}
 // {"action": "pub", "channel": "royuen", "data": {"any":"thing"}}
+//
+// Messages are delivered with IDs. If the hub keeps history, messages
+// published to a channel after the message with ID since are replayed
+// on subscription, so that a reconnecting client receives the messages
+// missed while it is disconnected:
+// {"action": "sub", "channel": "royuen", "since": 42}
+//
+// A connection not receiving messages as fast as they are published is
+// closed with the reason "slow consumer".
+//
+// If QueryHandler is set, live queries are subscribed with the channel
+// as identifier:
+// {"action": "query:sub", "channel": "q1", "data": {"record_type": "note"}}
+// {"action": "query:unsub", "channel": "q1"}
+//
+// A connection subscribing to a channel is a member of the channel.
+// Members joining and leaving, and changes of member state, are
+// published to the presence channel `<channel>/presence`, which can be
+// subscribed but not published to by clients. Members of a channel are
+// returned on its presence channel with the presence action:
+// {"action": "presence", "channel": "royuen"}
+// {"action": "presence:state", "data": {"status": "away"}}
+//
+// If Authorizer is set, subscription and publishing are rejected unless
+// authorized. Presence of a channel is authorized as subscribing to the
+// channel.
 type WsPubSub struct {
+	QueryHandler QueryHandler
+	Authorizer   Authorizer
+
 	upgrader websocket.Upgrader
 	hub      *Hub
 }
@@ -56,24 +194,31 @@ func NewWsPubsub(hub *Hub) *WsPubSub {
 		},
 	}
 	ws := WsPubSub{
-		upgrader,
-		hub,
+		upgrader: upgrader,
+		hub:      hub,
 	}
 	go hub.run()
 	return &ws
 }
 
-// Handle will hijack the http responseWriter and req.
-func (w *WsPubSub) Handle(writer http.ResponseWriter, req *http.Request) {
+// Handle will hijack the http responseWriter and req. The client is
+// the identity of the connection, which is authorized when subscribing
+// and publishing.
+func (w *WsPubSub) Handle(writer http.ResponseWriter, req *http.Request, client Client) {
 	conn, err := w.upgrader.Upgrade(writer, req, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	c := &connection{
-		ws:   conn,
-		Send: make(chan Parcel),
-		done: make(chan bool),
+		ws:      conn,
+		id:      uuid.New(),
+		client:  client,
+		queries: map[string]func(){},
+		Send:    make(chan Parcel, sendQueueSize+w.hub.HistorySize),
+		done:    make(chan bool),
+
+		closeReason: make(chan string, 1),
 	}
 	go w.writer(c)
 	go w.reader(c)
@@ -89,8 +234,18 @@ writer:
 			message, _ := json.Marshal(wsPayload{
 				Channel: parcel.Channel,
 				Data:    &d,
+				ID:      parcel.ID,
 			})
-			c.ws.WriteMessage(websocket.TextMessage, message)
+			c.writeMessage(websocket.TextMessage, message)
+		case reason := <-c.closeReason:
+			log.Debugf("Closing ws %p: %v", c.ws, reason)
+			c.ws.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason),
+				time.Now().Add(closeTimeout))
+			// the reader cleans up the connection when reading fails
+			c.ws.Close()
+			break writer
 		case <-c.done:
 			break writer
 		}
@@ -107,8 +262,12 @@ func (w *WsPubSub) reader(c *connection) {
 				Channel:    channel,
 				Connection: c,
 			}
+			w.publishPresence(c, channel, PresenceLeave)
+		}
+		for _, unsubscribe := range c.queries {
+			unsubscribe()
 		}
-		c.done <- true
+		close(c.done)
 	}()
 	for {
 		log.Debugf("Waiting ws message %p", c.ws)
@@ -122,52 +281,170 @@ func (w *WsPubSub) reader(c *connection) {
 		err = json.Unmarshal(p, &payload)
 		if err != nil {
 			log.Debugf("Can't decode Ws message %v", err)
-			c.ws.WriteMessage(
+			c.writeMessage(
 				websocket.TextMessage,
 				[]byte("Error: "+err.Error()+" \nClosing Connection"))
-			c.ws.WriteMessage(websocket.CloseMessage, nil)
+			c.writeMessage(websocket.CloseMessage, nil)
 			return
 		}
-		if payload.Channel == "" {
+		if payload.Channel == "" && payload.Action != "presence:state" {
 			log.Debugf("Got empty channel.")
-			c.ws.WriteMessage(
+			c.writeMessage(
 				websocket.TextMessage,
 				[]byte("Error: channel should not be empty. Closing Connection"),
 			)
-			c.ws.WriteMessage(websocket.CloseMessage, nil)
+			c.writeMessage(websocket.CloseMessage, nil)
 			return
 		}
 		switch payload.Action {
 		case "sub":
+			if err := w.AuthorizeSubscribe(c.client, payload.Channel); err != nil {
+				log.Debugf("Subscription rejected %p, %v: %v", c, payload.Channel, err)
+				c.writeMessage(
+					websocket.TextMessage,
+					[]byte("Error: cannot subscribe to "+payload.Channel+": "+err.Error()))
+				continue
+			}
+			if c.subscribed(payload.Channel) {
+				continue
+			}
 			w.hub.Subscribe <- Parcel{
 				Channel:    payload.Channel,
 				Connection: c,
+				Since:      payload.Since,
 			}
 			c.channels = append(c.channels, payload.Channel)
+			w.publishPresence(c, payload.Channel, PresenceJoin)
 		case "unsub":
+			if !c.subscribed(payload.Channel) {
+				continue
+			}
 			w.hub.Unsubscribe <- Parcel{
 				Channel:    payload.Channel,
 				Connection: c,
 			}
-			c.channels = append(c.channels, payload.Channel)
+			c.removeChannel(payload.Channel)
+			w.publishPresence(c, payload.Channel, PresenceLeave)
 		case "pub":
 			if payload.Data == nil {
 				log.Debugf("Got nil pub data.")
-				c.ws.WriteMessage(
+				c.writeMessage(
 					websocket.TextMessage,
 					[]byte("Error: missing data to publish. Closing Connection"),
 				)
-				c.ws.WriteMessage(websocket.CloseMessage, nil)
+				c.writeMessage(websocket.CloseMessage, nil)
+				return
+			}
+			if _, ok := ParsePresenceChannel(payload.Channel); ok {
+				c.writeMessage(
+					websocket.TextMessage,
+					[]byte("Error: cannot publish to presence channel "+payload.Channel))
+				continue
+			}
+			if err := w.Publish(c.client, payload.Channel, []byte(*payload.Data)); err != nil {
+				log.Debugf("Publish rejected %p, %v: %v", c, payload.Channel, err)
+				c.writeMessage(
+					websocket.TextMessage,
+					[]byte("Error: cannot publish to "+payload.Channel+": "+err.Error()))
+				continue
+			}
+		case "presence":
+			if err := w.AuthorizeSubscribe(c.client, payload.Channel); err != nil {
+				log.Debugf("Presence rejected %p, %v: %v", c, payload.Channel, err)
+				c.writeMessage(
+					websocket.TextMessage,
+					[]byte("Error: cannot get presence of "+payload.Channel+": "+err.Error()))
+				continue
+			}
+			data, _ := json.Marshal(struct {
+				Members []Member `json:"members"`
+			}{w.hub.Presence(payload.Channel)})
+			c.send(PresenceChannel(payload.Channel), data)
+		case "presence:state":
+			c.state = payload.Data
+			for _, channel := range c.channels {
+				w.publishPresence(c, channel, PresenceUpdate)
+			}
+		case "query:sub":
+			if w.QueryHandler == nil {
+				c.writeMessage(
+					websocket.TextMessage,
+					[]byte("Error: live query is not supported"))
+				continue
+			}
+			if payload.Data == nil {
+				log.Debugf("Got nil query data.")
+				c.writeMessage(
+					websocket.TextMessage,
+					[]byte("Error: missing query to subscribe. Closing Connection"),
+				)
+				c.writeMessage(websocket.CloseMessage, nil)
 				return
 			}
-			w.hub.Broadcast <- Parcel{
-				Channel: payload.Channel,
-				Data:    []byte(*payload.Data),
+			w.unsubscribeQuery(c, payload.Channel)
+			channel := payload.Channel
+			unsubscribe := w.QueryHandler.SubscribeQuery(
+				c.client,
+				[]byte(*payload.Data),
+				func(data []byte) {
+					c.trySend(channel, data)
+				},
+			)
+			if unsubscribe != nil {
+				c.queries[channel] = unsubscribe
 			}
+		case "query:unsub":
+			w.unsubscribeQuery(c, payload.Channel)
 		default:
-			c.ws.WriteMessage(
+			c.writeMessage(
 				websocket.TextMessage,
 				[]byte("Unknow action"))
 		}
 	}
 }
+
+// publishPresence publishes the presence event of the connection to the
+// presence channel of the channel. Subscribing to a presence channel
+// does not make the connection a member of any channel.
+func (w *WsPubSub) publishPresence(c *connection, channel string, event PresenceEventType) {
+	if _, ok := ParsePresenceChannel(channel); ok {
+		return
+	}
+	data, _ := json.Marshal(PresenceEvent{
+		Event:  event,
+		Member: c.member(),
+	})
+	if err := w.hub.publish(PresenceChannel(channel), data); err != nil {
+		log.WithField("err", err).Errorf("Failed to publish presence of %v", channel)
+	}
+}
+
+// Publish publishes the data to the channel on behalf of the client. The
+// client is authorized by Authorizer as publishing over the websocket.
+func (w *WsPubSub) Publish(client Client, channel string, data []byte) error {
+	if _, ok := ParsePresenceChannel(channel); ok {
+		return ErrPresenceChannel
+	}
+	if w.Authorizer != nil {
+		if err := w.Authorizer.AuthorizePublish(client, channel, data); err != nil {
+			return err
+		}
+	}
+	return w.hub.Publish(channel, data)
+}
+
+// AuthorizeSubscribe returns an error if the client is not authorized
+// by Authorizer to subscribe to the channel.
+func (w *WsPubSub) AuthorizeSubscribe(client Client, channel string) error {
+	if w.Authorizer == nil {
+		return nil
+	}
+	return w.Authorizer.AuthorizeSubscribe(client, channel)
+}
+
+func (w *WsPubSub) unsubscribeQuery(c *connection, channel string) {
+	if unsubscribe, ok := c.queries[channel]; ok {
+		unsubscribe()
+		delete(c.queries, channel)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type channelAuthorizer struct {
+	allowed map[string]string
+}
+
+func (a *channelAuthorizer) AuthorizeSubscribe(client Client, channel string) error {
+	if a.allowed[channel] != "" && (client.UserInfo == nil || client.UserInfo.ID != a.allowed[channel]) {
+		return errors.New("denied")
+	}
+	return nil
+}
+
+func (a *channelAuthorizer) AuthorizePublish(client Client, channel string, data []byte) error {
+	return a.AuthorizeSubscribe(client, channel)
+}
+
+func TestConnectionTrySend(t *testing.T) {
+	Convey("connection trySend", t, func() {
+		c := &connection{
+			Send:        make(chan Parcel, 1),
+			done:        make(chan bool),
+			closeReason: make(chan string, 1),
+		}
+
+		Convey("sends without blocking", func() {
+			So(c.trySend("q1", []byte("1")), ShouldBeTrue)
+			recv := <-c.Send
+			So(recv.Channel, ShouldEqual, "q1")
+			So(recv.Data, ShouldResemble, []byte("1"))
+		})
+
+		Convey("closes slow consumer", func() {
+			So(c.trySend("q1", []byte("1")), ShouldBeTrue)
+			So(c.trySend("q1", []byte("2")), ShouldBeFalse)
+			So(<-c.closeReason, ShouldEqual, "slow consumer")
+
+			So(c.trySend("q1", []byte("3")), ShouldBeFalse)
+			recv := <-c.Send
+			So(recv.Data, ShouldResemble, []byte("1"))
+		})
+
+		Convey("drops message to closed connection", func() {
+			close(c.done)
+			c.Send = make(chan Parcel)
+			So(c.trySend("q1", []byte("1")), ShouldBeFalse)
+			select {
+			case <-c.closeReason:
+				t.Fatal("closed connection is closed again")
+			default:
+			}
+		})
+	})
+}
+
+func TestWsPubSubAuthorizer(t *testing.T) {
+	Convey("WsPubSub with Authorizer", t, func() {
+		hub := NewHub()
+		ws := NewWsPubsub(hub)
+		ws.Authorizer = &channelAuthorizer{
+			allowed: map[string]string{"private": "user1"},
+		}
+		defer func() {
+			hub.stop <- 1
+		}()
+
+		var client Client
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ws.Handle(w, r, client)
+		}))
+		defer server.Close()
+
+		dial := func() *websocket.Conn {
+			url := "ws" + strings.TrimPrefix(server.URL, "http")
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			So(err, ShouldBeNil)
+			return conn
+		}
+
+		Convey("rejects unauthorized subscription", func() {
+			conn := dial()
+			defer conn.Close()
+
+			So(conn.WriteMessage(websocket.TextMessage, []byte(`{"action": "sub", "channel": "private"}`)), ShouldBeNil)
+			_, message, err := conn.ReadMessage()
+			So(err, ShouldBeNil)
+			So(string(message), ShouldEqual, "Error: cannot subscribe to private: denied")
+		})
+
+		Convey("rejects unauthorized publish", func() {
+			conn := dial()
+			defer conn.Close()
+
+			So(conn.WriteMessage(websocket.TextMessage, []byte(`{"action": "pub", "channel": "private", "data": {}}`)), ShouldBeNil)
+			_, message, err := conn.ReadMessage()
+			So(err, ShouldBeNil)
+			So(string(message), ShouldEqual, "Error: cannot publish to private: denied")
+		})
+
+		Convey("delivers message to authorized subscription", func() {
+			client = Client{UserInfo: &skydb.UserInfo{ID: "user1"}}
+			conn := dial()
+			defer conn.Close()
+
+			So(conn.WriteMessage(websocket.TextMessage, []byte(`{"action": "sub", "channel": "private"}`)), ShouldBeNil)
+			So(conn.WriteMessage(websocket.TextMessage, []byte(`{"action": "pub", "channel": "private", "data": {"hello": "world"}}`)), ShouldBeNil)
+			_, message, err := conn.ReadMessage()
+			So(err, ShouldBeNil)
+			So(string(message), ShouldEqual, `{"channel":"private","data":{"hello":"world"},"id":2}`)
+		})
+	})
+}
+
+func TestWsPubSubPresence(t *testing.T) {
+	Convey("WsPubSub presence", t, func() {
+		hub := NewHub()
+		ws := NewWsPubsub(hub)
+		defer func() {
+			hub.stop <- 1
+		}()
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ws.Handle(w, r, Client{
+				UserInfo: &skydb.UserInfo{ID: r.URL.Query().Get("user")},
+			})
+		}))
+		defer server.Close()
+
+		dial := func(userID string) *websocket.Conn {
+			url := "ws" + strings.TrimPrefix(server.URL, "http") + "?user=" + userID
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			So(err, ShouldBeNil)
+			return conn
+		}
+
+		readEvent := func(conn *websocket.Conn) PresenceEvent {
+			payload := struct {
+				Channel string        `json:"channel"`
+				Data    PresenceEvent `json:"data"`
+			}{}
+			So(conn.ReadJSON(&payload), ShouldBeNil)
+			So(payload.Channel, ShouldEqual, "room/presence")
+			return payload.Data
+		}
+
+		watcher := dial("watcher")
+		defer watcher.Close()
+		So(watcher.WriteMessage(websocket.TextMessage, []byte(`{"action": "sub", "channel": "room/presence"}`)), ShouldBeNil)
+
+		member := dial("user1")
+		defer member.Close()
+		So(member.WriteMessage(websocket.TextMessage, []byte(`{"action": "sub", "channel": "room"}`)), ShouldBeNil)
+
+		joined := readEvent(watcher)
+		So(joined.Event, ShouldEqual, PresenceJoin)
+		So(joined.Member.ID, ShouldNotBeEmpty)
+		So(joined.Member.UserID, ShouldEqual, "user1")
+
+		Convey("returns members of channel", func() {
+			So(watcher.WriteMessage(websocket.TextMessage, []byte(`{"action": "presence", "channel": "room"}`)), ShouldBeNil)
+			_, message, err := watcher.ReadMessage()
+			So(err, ShouldBeNil)
+			So(string(message), ShouldEqual, `{"channel":"room/presence","data":{"members":[{"id":"`+joined.Member.ID+`","user_id":"user1"}]}}`)
+			So(hub.Presence("room"), ShouldResemble, []Member{joined.Member})
+		})
+
+		Convey("publishes state update", func() {
+			So(member.WriteMessage(websocket.TextMessage, []byte(`{"action": "presence:state", "data": {"status":"away"}}`)), ShouldBeNil)
+
+			updated := readEvent(watcher)
+			So(updated.Event, ShouldEqual, PresenceUpdate)
+			So(updated.Member.ID, ShouldEqual, joined.Member.ID)
+			So(string(*updated.Member.State), ShouldEqual, `{"status":"away"}`)
+		})
+
+		Convey("publishes leave on unsubscribe", func() {
+			So(member.WriteMessage(websocket.TextMessage, []byte(`{"action": "unsub", "channel": "room"}`)), ShouldBeNil)
+
+			left := readEvent(watcher)
+			So(left.Event, ShouldEqual, PresenceLeave)
+			So(left.Member.ID, ShouldEqual, joined.Member.ID)
+			So(hub.Presence("room"), ShouldResemble, []Member{})
+		})
+
+		Convey("publishes leave on close", func() {
+			member.Close()
+
+			left := readEvent(watcher)
+			So(left.Event, ShouldEqual, PresenceLeave)
+			So(left.Member.ID, ShouldEqual, joined.Member.ID)
+		})
+
+		Convey("rejects publish to presence channel", func() {
+			So(watcher.WriteMessage(websocket.TextMessage, []byte(`{"action": "pub", "channel": "room/presence", "data": {}}`)), ShouldBeNil)
+			_, message, err := watcher.ReadMessage()
+			So(err, ShouldBeNil)
+			So(string(message), ShouldEqual, "Error: cannot publish to presence channel room/presence")
+		})
+	})
+}
+
+func TestWsPubSubReplay(t *testing.T) {
+	Convey("WsPubSub replays history", t, func() {
+		hub := NewHub()
+		hub.HistorySize = 10
+		ws := NewWsPubsub(hub)
+		defer func() {
+			hub.stop <- 1
+		}()
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ws.Handle(w, r, Client{})
+		}))
+		defer server.Close()
+
+		dial := func() *websocket.Conn {
+			url := "ws" + strings.TrimPrefix(server.URL, "http")
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			So(err, ShouldBeNil)
+			return conn
+		}
+
+		conn := dial()
+		defer conn.Close()
+		So(conn.WriteMessage(websocket.TextMessage, []byte(`{"action": "sub", "channel": "news"}`)), ShouldBeNil)
+		So(conn.WriteMessage(websocket.TextMessage, []byte(`{"action": "pub", "channel": "news", "data": 1}`)), ShouldBeNil)
+		So(conn.WriteMessage(websocket.TextMessage, []byte(`{"action": "pub", "channel": "news", "data": 2}`)), ShouldBeNil)
+		for _, expected := range []string{
+			`{"channel":"news","data":1,"id":2}`,
+			`{"channel":"news","data":2,"id":3}`,
+		} {
+			_, message, err := conn.ReadMessage()
+			So(err, ShouldBeNil)
+			So(string(message), ShouldEqual, expected)
+		}
+
+		reconnected := dial()
+		defer reconnected.Close()
+		So(reconnected.WriteMessage(websocket.TextMessage, []byte(`{"action": "sub", "channel": "news", "since": 2}`)), ShouldBeNil)
+		_, message, err := reconnected.ReadMessage()
+		So(err, ShouldBeNil)
+		So(string(message), ShouldEqual, `{"channel":"news","data":2,"id":3}`)
+	})
+}
@@ -15,6 +15,8 @@
 package push
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"time"
@@ -51,6 +53,22 @@ type APNSPusher interface {
 	deleteDeviceToken(token string, beforeTime time.Time) error
 }
 
+// apnsCollapseIDMaxLength is the maximum number of bytes of the
+// apns-collapse-id header.
+const apnsCollapseIDMaxLength = 64
+
+// apnsCollapseID returns the apns-collapse-id of the notification m. A
+// collapse key too long for APNS is replaced by its SHA-256 digest, so that
+// notifications of the same key still collapse.
+func apnsCollapseID(m Mapper) string {
+	key := CollapseKey(m)
+	if len(key) <= apnsCollapseIDMaxLength {
+		return key
+	}
+	digest := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(digest[:])
+}
+
 type failedNotification struct {
 	deviceToken string
 	err         push.Error
@@ -75,12 +93,16 @@ func checkFailedNotifications(pusher APNSPusher) {
 }
 
 func handleFailedNotification(pusher APNSPusher, failedNote failedNotification) {
-	if failedNote.err.Status == http.StatusGone ||
-		failedNote.err.Reason.Error() == "BadDeviceToken" {
+	if isBadDeviceToken(failedNote.err) {
 		unregisterDevice(pusher, failedNote.deviceToken, failedNote.err.Timestamp)
 	}
 }
 
+func isBadDeviceToken(err push.Error) bool {
+	return err.Status == http.StatusGone ||
+		(err.Reason != nil && err.Reason.Error() == "BadDeviceToken")
+}
+
 func unregisterDevice(pusher APNSPusher, deviceToken string, timestamp time.Time) {
 	logger := log.WithFields(logrus.Fields{
 		"deviceToken": deviceToken,
@@ -172,7 +172,8 @@ func (pusher *certBasedAPNSPusher) Send(m Mapper, device skydb.Device) error {
 	}
 
 	headers := push.Headers{
-		Topic: pusher.topic,
+		Topic:      pusher.topic,
+		CollapseID: apnsCollapseID(m),
 	}
 
 	apnsid, err := pusher.service.Push(device.Token, &headers, serializedPayload)
@@ -17,6 +17,7 @@ package push
 import (
 	"errors"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -155,6 +156,24 @@ func TestCertBaseAPNSPusherSend(t *testing.T) {
 			}`)
 		})
 
+		Convey("pushes notification with collapse key", func() {
+			notification := MapMapper{
+				"apns": map[string]interface{}{
+					"aps": map[string]interface{}{
+						"alert": "This is a message.",
+					},
+				},
+			}
+
+			So(pusher.Send(WithCollapseKey(notification, "score"), device), ShouldBeNil)
+			So(service.Sent[0].Headers.CollapseID, ShouldEqual, "score")
+
+			longKey := strings.Repeat("k", 65)
+			So(pusher.Send(WithCollapseKey(notification, longKey), device), ShouldBeNil)
+			So(service.Sent[1].Headers.CollapseID, ShouldHaveLength, 64)
+			So(service.Sent[1].Headers.CollapseID, ShouldEqual, apnsCollapseID(WithCollapseKey(EmptyMapper, longKey)))
+		})
+
 		Convey("returns error when missing apns dictionary", func() {
 			err := pusher.Send(EmptyMapper, device)
 			So(err, ShouldResemble, errors.New("push/apns: payload has no apns dictionary"))
@@ -0,0 +1,484 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// Default endpoints of FCM HTTP v1 API
+const (
+	DefaultFCMTokenURL = "https://oauth2.googleapis.com/token"
+	DefaultFCMBaseURL  = "https://fcm.googleapis.com"
+)
+
+const (
+	fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+	// the access token is refreshed a while before it expires to allow
+	// for clock skew and latency
+	fcmTokenExpiryMargin = 5 * time.Minute
+)
+
+// fcmServiceAccount is the subset of a service account JSON key file
+// used to authenticate with FCM.
+type fcmServiceAccount struct {
+	ProjectID   string `json:"project_id"`
+	PrivateKey  string `json:"private_key"`
+	ClientEmail string `json:"client_email"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// FCMPusher sends push notifications via the FCM HTTP v1 API,
+// authenticated by an OAuth2 access token exchanged with a JWT signed
+// by a service account.
+type FCMPusher struct {
+	unregisterer *deviceUnregisterer
+
+	client      *http.Client
+	tokenURL    string
+	baseURL     string
+	projectID   string
+	clientEmail string
+	privateKey  *rsa.PrivateKey
+
+	tokenMutex *sync.RWMutex
+	token      token
+}
+
+// NewFCMPusher creates a new FCMPusher from the content of a service
+// account JSON key file.
+//
+// tokenURL and baseURL are the OAuth2 token endpoint and the base URL
+// of FCM. The token endpoint specified in the service account, or
+// DefaultFCMTokenURL and DefaultFCMBaseURL are used if they are empty.
+func NewFCMPusher(
+	connOpener func() (skydb.Conn, error),
+	serviceAccountJSON string,
+	tokenURL string,
+	baseURL string,
+) (*FCMPusher, error) {
+	account := fcmServiceAccount{}
+	if err := json.Unmarshal([]byte(serviceAccountJSON), &account); err != nil {
+		return nil, fmt.Errorf("push/fcm: service account is malformed: %v", err)
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" {
+		return nil, errors.New("push/fcm: service account has no project_id or client_email")
+	}
+
+	keyBlock, _ := pem.Decode([]byte(account.PrivateKey))
+	if keyBlock == nil {
+		return nil, errors.New("push/fcm: service account private key is malformed")
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := privateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("push/fcm: service account private key is not a RSA key")
+	}
+
+	if tokenURL == "" {
+		tokenURL = account.TokenURI
+	}
+	if tokenURL == "" {
+		tokenURL = DefaultFCMTokenURL
+	}
+	if baseURL == "" {
+		baseURL = DefaultFCMBaseURL
+	}
+
+	return &FCMPusher{
+		unregisterer: newDeviceUnregisterer(connOpener),
+		client:       &http.Client{Timeout: 30 * time.Second},
+		tokenURL:     tokenURL,
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		projectID:    account.ProjectID,
+		clientEmail:  account.ClientEmail,
+		privateKey:   rsaKey,
+		tokenMutex:   &sync.RWMutex{},
+	}, nil
+}
+
+// refreshToken exchanges a JWT signed by the service account for a new
+// access token.
+func (pusher *FCMPusher) refreshToken() error {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   pusher.clientEmail,
+		"scope": fcmScope,
+		"aud":   pusher.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(pusher.privateKey)
+	if err != nil {
+		return err
+	}
+
+	resp, err := pusher.client.PostForm(pusher.tokenURL, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("push/fcm: token endpoint responded with status = %d: %s", resp.StatusCode, body)
+	}
+
+	tokenResp := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return err
+	}
+	if tokenResp.AccessToken == "" {
+		return errors.New("push/fcm: token endpoint returned no access token")
+	}
+
+	pusher.updateToken(token{
+		value:     tokenResp.AccessToken,
+		expiredAt: now.Add(time.Duration(tokenResp.ExpiresIn) * time.Second),
+	})
+	return nil
+}
+
+func (pusher *FCMPusher) updateToken(newToken token) {
+	pusher.tokenMutex.Lock()
+	defer pusher.tokenMutex.Unlock()
+
+	pusher.token = newToken
+}
+
+// getToken returns the cached access token, refreshing it if it is
+// about to expire.
+func (pusher *FCMPusher) getToken() (string, error) {
+	pusher.tokenMutex.RLock()
+	cached := pusher.token
+	pusher.tokenMutex.RUnlock()
+
+	if cached.value != "" && time.Now().Add(fcmTokenExpiryMargin).Before(cached.expiredAt) {
+		return cached.value, nil
+	}
+
+	log.Info("Refreshing FCM Token")
+	if err := pusher.refreshToken(); err != nil {
+		return "", err
+	}
+
+	pusher.tokenMutex.RLock()
+	defer pusher.tokenMutex.RUnlock()
+	return pusher.token.value, nil
+}
+
+type fcmErrorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			Type      string `json:"@type"`
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+func (resp fcmErrorResponse) errorCode() string {
+	for _, detail := range resp.Error.Details {
+		if detail.ErrorCode != "" {
+			return detail.ErrorCode
+		}
+	}
+	return resp.Error.Status
+}
+
+// Send sends the notification to the device.
+//
+// The "fcm" dictionary of m is sent as the FCM message, which may contain
+// "notification", "data" and the "android", "apns" and "webpush"
+// override blocks. If there is no "apns" block, the "apns" dictionary
+// of m is used as the APNS payload.
+func (pusher *FCMPusher) Send(m Mapper, device skydb.Device) error {
+	logger := log.WithFields(logrus.Fields{
+		"deviceID":    device.ID,
+		"deviceToken": device.Token,
+	})
+
+	if m == nil {
+		logger.Warn("Cannot send push notification with nil data.")
+		return errors.New("push/fcm: push notification has no data")
+	}
+
+	message, err := mapFCMMessage(m)
+	if err != nil {
+		return err
+	}
+	message["token"] = device.Token
+
+	body, err := json.Marshal(map[string]interface{}{
+		"message": message,
+	})
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := pusher.getToken()
+	if err != nil {
+		logger.Errorf("push/fcm: failed to obtain access token: %v", err)
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/projects/%s/messages:send", pusher.baseURL, pusher.projectID)
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	sentAt := time.Now().UTC()
+	resp, err := pusher.client.Do(req)
+	if err != nil {
+		logger.Errorf("push/fcm: failed to send push notification: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		logger.Info("push/fcm: push notification is sent")
+		return nil
+	}
+
+	errResp := fcmErrorResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		logger.WithField("status", resp.StatusCode).
+			Error("push/fcm: failed to send push notification")
+		return fmt.Errorf("push/fcm: FCM responded with status = %d", resp.StatusCode)
+	}
+
+	errorCode := errResp.errorCode()
+	logger.WithFields(logrus.Fields{
+		"status":    resp.StatusCode,
+		"errorCode": errorCode,
+		"message":   errResp.Error.Message,
+	}).Error("push/fcm: failed to send push notification")
+
+	switch {
+	case errorCode == "UNREGISTERED":
+		pusher.unregisterer.unregister(device.Token, sentAt)
+		return ErrInvalidDeviceToken
+	case resp.StatusCode == http.StatusUnauthorized:
+		// discard the access token so that it is refreshed next time
+		pusher.updateToken(token{})
+	}
+
+	return fmt.Errorf("push/fcm: FCM responded with error = %s", errorCode)
+}
+
+var fcmMessageKeys = []string{
+	"notification",
+	"data",
+	"android",
+	"apns",
+	"webpush",
+	"fcm_options",
+}
+
+// setFCMCollapseKey sets the collapse key of the FCM message for Android,
+// APNS and Web Push, unless the message has specified one for the
+// platform. The override blocks are copied before they are modified.
+func setFCMCollapseKey(message map[string]interface{}, key string) {
+	android := copyFCMBlock(message["android"])
+	if _, ok := android["collapse_key"]; !ok {
+		android["collapse_key"] = key
+	}
+	message["android"] = android
+
+	apns := copyFCMBlock(message["apns"])
+	apnsHeaders := copyFCMBlock(apns["headers"])
+	if _, ok := apnsHeaders["apns-collapse-id"]; !ok {
+		apnsHeaders["apns-collapse-id"] = apnsCollapseID(WithCollapseKey(EmptyMapper, key))
+	}
+	apns["headers"] = apnsHeaders
+	message["apns"] = apns
+
+	webpush := copyFCMBlock(message["webpush"])
+	webpushHeaders := copyFCMBlock(webpush["headers"])
+	if _, ok := webpushHeaders["Topic"]; !ok {
+		webpushHeaders["Topic"] = webPushTopic(key)
+	}
+	webpush["headers"] = webpushHeaders
+	message["webpush"] = webpush
+}
+
+func copyFCMBlock(value interface{}) map[string]interface{} {
+	block := map[string]interface{}{}
+	if m, ok := value.(map[string]interface{}); ok {
+		for k, v := range m {
+			block[k] = v
+		}
+	}
+	return block
+}
+
+// fcmAndroidNotificationKeys are keys of the notification of the legacy
+// GCM payload which are supported by the Android notification of FCM.
+var fcmAndroidNotificationKeys = []string{
+	"icon",
+	"sound",
+	"tag",
+	"color",
+	"click_action",
+	"body_loc_key",
+	"body_loc_args",
+	"title_loc_key",
+	"title_loc_args",
+}
+
+// mapLegacyGCMMessage translates the dictionary of the "gcm" key, which
+// is in the format of the legacy GCM HTTP API, into an FCM message.
+func mapLegacyGCMMessage(gcmMap map[string]interface{}) map[string]interface{} {
+	message := map[string]interface{}{}
+	android := map[string]interface{}{}
+
+	if notification, ok := gcmMap["notification"].(map[string]interface{}); ok {
+		fcmNotification := map[string]interface{}{}
+		for _, key := range []string{"title", "body"} {
+			if value, ok := notification[key]; ok {
+				fcmNotification[key] = value
+			}
+		}
+		if len(fcmNotification) > 0 {
+			message["notification"] = fcmNotification
+		}
+
+		androidNotification := map[string]interface{}{}
+		for _, key := range fcmAndroidNotificationKeys {
+			value, ok := notification[key]
+			if !ok {
+				continue
+			}
+			// The legacy API accepts localization arguments encoded
+			// as a JSON array in a string.
+			if args, ok := value.(string); ok && strings.HasSuffix(key, "_loc_args") {
+				var decoded []interface{}
+				if err := json.Unmarshal([]byte(args), &decoded); err == nil {
+					value = decoded
+				}
+			}
+			androidNotification[key] = value
+		}
+		if len(androidNotification) > 0 {
+			android["notification"] = androidNotification
+		}
+	}
+
+	if data, ok := gcmMap["data"].(map[string]interface{}); ok {
+		message["data"] = data
+	}
+
+	if collapseKey, ok := gcmMap["collapse_key"].(string); ok && collapseKey != "" {
+		android["collapse_key"] = collapseKey
+	}
+	if priority, ok := gcmMap["priority"].(string); ok && priority != "" {
+		android["priority"] = strings.ToUpper(priority)
+	}
+	if ttl, ok := gcmMap["time_to_live"].(float64); ok {
+		android["ttl"] = fmt.Sprintf("%ds", int64(ttl))
+	}
+	if name, ok := gcmMap["restricted_package_name"].(string); ok && name != "" {
+		android["restricted_package_name"] = name
+	}
+
+	if len(android) > 0 {
+		message["android"] = android
+	}
+	return message
+}
+
+// mapFCMMessage maps the payload into an FCM message. The dictionary of
+// the "fcm" key is used if present, otherwise the dictionary of the "gcm"
+// key of the legacy GCM payload is translated.
+func mapFCMMessage(mapper Mapper) (map[string]interface{}, error) {
+	m := mapper.Map()
+	message := map[string]interface{}{}
+
+	if fcmMap, ok := m["fcm"].(map[string]interface{}); ok {
+		for _, key := range fcmMessageKeys {
+			if value, ok := fcmMap[key]; ok {
+				message[key] = value
+			}
+		}
+	} else if gcmMap, ok := m["gcm"].(map[string]interface{}); ok {
+		message = mapLegacyGCMMessage(gcmMap)
+	}
+
+	if _, ok := message["apns"]; !ok {
+		if apnsMap, ok := m["apns"].(map[string]interface{}); ok {
+			message["apns"] = map[string]interface{}{
+				"payload": apnsMap,
+			}
+		}
+	}
+
+	// FCM only accepts string values in data
+	if data, ok := message["data"].(map[string]interface{}); ok {
+		stringData := map[string]string{}
+		for key, value := range data {
+			if str, ok := value.(string); ok {
+				stringData[key] = str
+				continue
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			stringData[key] = string(encoded)
+		}
+		message["data"] = stringData
+	}
+
+	if len(message) == 0 {
+		return nil, errors.New("push/fcm: payload has no fcm or gcm dictionary")
+	}
+
+	if key := CollapseKey(mapper); key != "" {
+		setFCMCollapseKey(message, key)
+	}
+
+	return message, nil
+}
@@ -15,6 +15,9 @@
 package push
 
 import (
+	"encoding/json"
+	"reflect"
+
 	"github.com/google/go-gcm"
 	"github.com/mitchellh/mapstructure"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
@@ -53,8 +56,9 @@ func mapGCMMessage(mapper Mapper, msg *gcm.HttpMessage) error {
 	m := mapper.Map()
 	if gcmMap, ok := m["gcm"].(map[string]interface{}); ok {
 		config := mapstructure.DecoderConfig{
-			TagName: "json",
-			Result:  msg,
+			DecodeHook: encodeGCMLocArgs,
+			TagName:    "json",
+			Result:     msg,
 		}
 		// NewDecoder only returns error when DecoderConfig.Result
 		// is not a pointer.
@@ -68,5 +72,25 @@ func mapGCMMessage(mapper Mapper, msg *gcm.HttpMessage) error {
 		}
 	}
 
+	if msg.CollapseKey == "" {
+		msg.CollapseKey = CollapseKey(mapper)
+	}
+
 	return nil
 }
+
+// encodeGCMLocArgs encodes an array into a JSON string. GCM expects
+// title_loc_args and body_loc_args to be a JSON array in a string, while
+// APNS expects the loc-args to be an array, so that a notification can
+// specify both in the same way.
+func encodeGCMLocArgs(from reflect.Kind, to reflect.Kind, data interface{}) (interface{}, error) {
+	if from != reflect.Slice || to != reflect.String {
+		return data, nil
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
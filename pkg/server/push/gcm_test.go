@@ -88,6 +88,42 @@ func TestGCMSend(t *testing.T) {
 			})
 		})
 
+		Convey("sends notification with localization args", func() {
+			err := pusher.Send(MapMapper{
+				"gcm": map[string]interface{}{
+					"notification": map[string]interface{}{
+						"title_loc_key": "NEW_MESSAGE_TITLE",
+						"body_loc_key":  "NEW_MESSAGE_BODY",
+						"body_loc_args": []interface{}{"John", "Hello"},
+					},
+				},
+			}, device)
+
+			So(err, ShouldBeNil)
+			So(gcmMessage.Notification, ShouldResemble, gcm.Notification{
+				TitleLocKey: "NEW_MESSAGE_TITLE",
+				BodyLocKey:  "NEW_MESSAGE_BODY",
+				BodyLocArgs: `["John","Hello"]`,
+			})
+		})
+
+		Convey("sends notification with collapse key", func() {
+			notification := MapMapper{
+				"gcm": map[string]interface{}{
+					"notification": map[string]interface{}{
+						"title": "Score",
+					},
+				},
+			}
+
+			So(pusher.Send(WithCollapseKey(notification, "score"), device), ShouldBeNil)
+			So(gcmMessage.CollapseKey, ShouldEqual, "score")
+
+			notification["gcm"].(map[string]interface{})["collapse_key"] = "specified"
+			So(pusher.Send(WithCollapseKey(notification, "score"), device), ShouldBeNil)
+			So(gcmMessage.CollapseKey, ShouldEqual, "specified")
+		})
+
 		Convey("propagates error from gcm.SendHttp", func() {
 			gcmSendHTTP = func(string, gcm.HttpMessage) (*gcm.HttpResponse, error) {
 				return nil, errors.New("gcm_test: some error")
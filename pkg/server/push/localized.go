@@ -0,0 +1,241 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// LocalizedMapper is a Mapper which renders a skydb.PushTemplate into
+// the payload of every push service, with the localization of the
+// template matching Locale and the placeholders rendered with Context.
+type LocalizedMapper struct {
+	Template skydb.PushTemplate
+	Locale   string
+	Context  map[string]interface{}
+}
+
+// Map returns the rendered notification, keyed by push service.
+func (m LocalizedMapper) Map() map[string]interface{} {
+	renderer := TemplateMapper{Context: m.Context}
+	l := m.Template.Localizations[MatchLocale(m.Template, m.Locale)]
+	text := func(s string) string {
+		value := renderer.renderString(s)
+		if value == nil {
+			return ""
+		}
+		return fmt.Sprint(value)
+	}
+	texts := func(ss []string) []interface{} {
+		if len(ss) == 0 {
+			return nil
+		}
+		rendered := make([]interface{}, len(ss))
+		for i, s := range ss {
+			rendered[i] = text(s)
+		}
+		return rendered
+	}
+
+	title, body := text(l.Title), text(l.Body)
+	titleLocArgs, bodyLocArgs := texts(l.TitleLocArgs), texts(l.BodyLocArgs)
+	badge := m.badge(renderer)
+	data, _ := renderer.render(m.Template.Data).(map[string]interface{})
+
+	alert := map[string]interface{}{}
+	setNonEmpty(alert, "title", title)
+	setNonEmpty(alert, "body", body)
+	setNonEmpty(alert, "title-loc-key", l.TitleLocKey)
+	setNonEmpty(alert, "title-loc-args", titleLocArgs)
+	setNonEmpty(alert, "loc-key", l.BodyLocKey)
+	setNonEmpty(alert, "loc-args", bodyLocArgs)
+	aps := map[string]interface{}{}
+	setNonEmpty(aps, "alert", alert)
+	setNonEmpty(aps, "sound", m.Template.Sound)
+	setNonEmpty(aps, "badge", badge)
+	// custom data is placed alongside aps as APNS does not have a
+	// dedicated key for it
+	apns := map[string]interface{}{}
+	for key, value := range data {
+		apns[key] = value
+	}
+	apns["aps"] = aps
+
+	gcmNotification := map[string]interface{}{}
+	setNonEmpty(gcmNotification, "title", title)
+	setNonEmpty(gcmNotification, "body", body)
+	setNonEmpty(gcmNotification, "title_loc_key", l.TitleLocKey)
+	setNonEmpty(gcmNotification, "title_loc_args", titleLocArgs)
+	setNonEmpty(gcmNotification, "body_loc_key", l.BodyLocKey)
+	setNonEmpty(gcmNotification, "body_loc_args", bodyLocArgs)
+	setNonEmpty(gcmNotification, "sound", m.Template.Sound)
+	if badge != nil {
+		gcmNotification["badge"] = fmt.Sprint(badge)
+	}
+	gcm := map[string]interface{}{}
+	setNonEmpty(gcm, "notification", gcmNotification)
+	setNonEmpty(gcm, "data", data)
+
+	// FCM only supports title and body in the common notification,
+	// the rest goes to the Android specific one
+	fcmNotification := map[string]interface{}{}
+	setNonEmpty(fcmNotification, "title", title)
+	setNonEmpty(fcmNotification, "body", body)
+	androidNotification := map[string]interface{}{}
+	setNonEmpty(androidNotification, "title_loc_key", l.TitleLocKey)
+	setNonEmpty(androidNotification, "title_loc_args", titleLocArgs)
+	setNonEmpty(androidNotification, "body_loc_key", l.BodyLocKey)
+	setNonEmpty(androidNotification, "body_loc_args", bodyLocArgs)
+	setNonEmpty(androidNotification, "sound", m.Template.Sound)
+	fcm := map[string]interface{}{
+		"apns": map[string]interface{}{
+			"payload": apns,
+		},
+	}
+	setNonEmpty(fcm, "notification", fcmNotification)
+	setNonEmpty(fcm, "data", data)
+	if len(androidNotification) > 0 {
+		fcm["android"] = map[string]interface{}{
+			"notification": androidNotification,
+		}
+	}
+
+	// localization keys cannot be resolved by a browser, so web push
+	// only gets the rendered text
+	web := map[string]interface{}{}
+	setNonEmpty(web, "title", title)
+	setNonEmpty(web, "body", body)
+	setNonEmpty(web, "data", data)
+
+	return map[string]interface{}{
+		"apns": apns,
+		"gcm":  gcm,
+		"fcm":  fcm,
+		"web":  web,
+	}
+}
+
+func (m LocalizedMapper) badge(renderer TemplateMapper) interface{} {
+	badge := renderer.render(m.Template.Badge)
+	if s, ok := badge.(string); ok {
+		if s == "" {
+			return nil
+		}
+		if i, err := strconv.Atoi(s); err == nil {
+			return i
+		}
+	}
+	return badge
+}
+
+func setNonEmpty(m map[string]interface{}, key string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case string:
+		if v == "" {
+			return
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			return
+		}
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return
+		}
+	}
+	m[key] = value
+}
+
+// MatchLocale returns the locale of the localization in template which
+// best matches locale.
+//
+// Subtags of locale are removed one by one until it matches a
+// localization, e.g. "zh-Hant-TW" is matched by "zh-Hant" and then "zh".
+// Matching is case insensitive and treats "_" as "-". The default locale
+// of the template is returned if nothing matches.
+func MatchLocale(template skydb.PushTemplate, locale string) string {
+	locales := make(map[string]string, len(template.Localizations))
+	for l := range template.Localizations {
+		locales[normalizeLocale(l)] = l
+	}
+
+	tag := normalizeLocale(locale)
+	for tag != "" {
+		if l, ok := locales[tag]; ok {
+			return l
+		}
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	return template.DefaultLocale
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(locale, "_", "-", -1))
+}
+
+// LocaleGroup is a group of devices sharing the same localization of
+// a template.
+type LocaleGroup struct {
+	Locale  string
+	Devices []skydb.Device
+}
+
+// GroupDevicesByLocale groups devices by the localization of template
+// matching their locale. Groups are ordered by their first device.
+func GroupDevicesByLocale(template skydb.PushTemplate, devices []skydb.Device) []LocaleGroup {
+	groups := []LocaleGroup{}
+	indices := map[string]int{}
+	for _, device := range devices {
+		locale := MatchLocale(template, device.Locale)
+		i, ok := indices[locale]
+		if !ok {
+			i = len(groups)
+			indices[locale] = i
+			groups = append(groups, LocaleGroup{Locale: locale})
+		}
+		groups[i].Devices = append(groups[i].Devices, device)
+	}
+	return groups
+}
+
+// LocalizedTemplateReferences returns whether any placeholder in
+// template references a key path under name.
+func LocalizedTemplateReferences(template skydb.PushTemplate, name string) bool {
+	texts := []interface{}{}
+	for _, l := range template.Localizations {
+		texts = append(texts, l.Title, l.Body)
+		for _, arg := range l.TitleLocArgs {
+			texts = append(texts, arg)
+		}
+		for _, arg := range l.BodyLocArgs {
+			texts = append(texts, arg)
+		}
+	}
+	return templateReferences(map[string]interface{}{
+		"texts": texts,
+		"badge": template.Badge,
+		"data":  template.Data,
+	}, name)
+}
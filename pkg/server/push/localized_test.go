@@ -0,0 +1,231 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLocalizedMapper(t *testing.T) {
+	Convey("LocalizedMapper", t, func() {
+		template := skydb.PushTemplate{
+			Name:          "new-comment",
+			DefaultLocale: "en",
+			Localizations: map[string]skydb.PushTemplateLocalization{
+				"en": {
+					Title: "New comment",
+					Body:  "{{user.username}} commented on your post",
+				},
+				"zh-Hant": {
+					TitleLocKey: "NEW_COMMENT_TITLE",
+					BodyLocKey:  "NEW_COMMENT_BODY",
+					BodyLocArgs: []string{"{{user.username}}"},
+				},
+			},
+			Sound: "default",
+			Badge: "{{user.unread}}",
+			Data: map[string]interface{}{
+				"post_id": "{{record._id}}",
+			},
+		}
+		context := map[string]interface{}{
+			"user": map[string]interface{}{
+				"username": "johndoe",
+				"unread":   float64(3),
+			},
+			"record": map[string]interface{}{
+				"_id": "post1",
+			},
+		}
+
+		Convey("renders text of a localization", func() {
+			m := LocalizedMapper{template, "en-US", context}
+			So(m.Map(), ShouldResemble, map[string]interface{}{
+				"apns": map[string]interface{}{
+					"aps": map[string]interface{}{
+						"alert": map[string]interface{}{
+							"title": "New comment",
+							"body":  "johndoe commented on your post",
+						},
+						"sound": "default",
+						"badge": float64(3),
+					},
+					"post_id": "post1",
+				},
+				"gcm": map[string]interface{}{
+					"notification": map[string]interface{}{
+						"title": "New comment",
+						"body":  "johndoe commented on your post",
+						"sound": "default",
+						"badge": "3",
+					},
+					"data": map[string]interface{}{
+						"post_id": "post1",
+					},
+				},
+				"fcm": map[string]interface{}{
+					"notification": map[string]interface{}{
+						"title": "New comment",
+						"body":  "johndoe commented on your post",
+					},
+					"data": map[string]interface{}{
+						"post_id": "post1",
+					},
+					"android": map[string]interface{}{
+						"notification": map[string]interface{}{
+							"sound": "default",
+						},
+					},
+					"apns": map[string]interface{}{
+						"payload": map[string]interface{}{
+							"aps": map[string]interface{}{
+								"alert": map[string]interface{}{
+									"title": "New comment",
+									"body":  "johndoe commented on your post",
+								},
+								"sound": "default",
+								"badge": float64(3),
+							},
+							"post_id": "post1",
+						},
+					},
+				},
+				"web": map[string]interface{}{
+					"title": "New comment",
+					"body":  "johndoe commented on your post",
+					"data": map[string]interface{}{
+						"post_id": "post1",
+					},
+				},
+			})
+		})
+
+		Convey("renders localization keys", func() {
+			m := LocalizedMapper{template, "zh-Hant-TW", context}
+			notification := m.Map()
+			So(notification["apns"].(map[string]interface{})["aps"].(map[string]interface{})["alert"], ShouldResemble, map[string]interface{}{
+				"title-loc-key": "NEW_COMMENT_TITLE",
+				"loc-key":       "NEW_COMMENT_BODY",
+				"loc-args":      []interface{}{"johndoe"},
+			})
+			So(notification["gcm"].(map[string]interface{})["notification"], ShouldResemble, map[string]interface{}{
+				"title_loc_key": "NEW_COMMENT_TITLE",
+				"body_loc_key":  "NEW_COMMENT_BODY",
+				"body_loc_args": []interface{}{"johndoe"},
+				"sound":         "default",
+				"badge":         "3",
+			})
+			So(notification["web"], ShouldResemble, map[string]interface{}{
+				"data": map[string]interface{}{
+					"post_id": "post1",
+				},
+			})
+		})
+
+		Convey("renders numeric badge in string", func() {
+			template.Badge = "5"
+			m := LocalizedMapper{template, "en", context}
+			aps := m.Map()["apns"].(map[string]interface{})["aps"].(map[string]interface{})
+			So(aps["badge"], ShouldEqual, 5)
+		})
+
+		Convey("omits missing badge", func() {
+			template.Badge = "{{user.missing}}"
+			m := LocalizedMapper{template, "en", context}
+			aps := m.Map()["apns"].(map[string]interface{})["aps"].(map[string]interface{})
+			So(aps, ShouldNotContainKey, "badge")
+		})
+	})
+}
+
+func TestMatchLocale(t *testing.T) {
+	Convey("MatchLocale", t, func() {
+		template := skydb.PushTemplate{
+			DefaultLocale: "en",
+			Localizations: map[string]skydb.PushTemplateLocalization{
+				"en":      {},
+				"zh":      {},
+				"zh-Hant": {},
+			},
+		}
+
+		Convey("matches exact locale", func() {
+			So(MatchLocale(template, "zh-Hant"), ShouldEqual, "zh-Hant")
+		})
+
+		Convey("matches case insensitively", func() {
+			So(MatchLocale(template, "zh_hant"), ShouldEqual, "zh-Hant")
+		})
+
+		Convey("matches by removing subtags", func() {
+			So(MatchLocale(template, "zh-Hant-HK"), ShouldEqual, "zh-Hant")
+			So(MatchLocale(template, "zh-Hans-CN"), ShouldEqual, "zh")
+		})
+
+		Convey("falls back to default locale", func() {
+			So(MatchLocale(template, "ja-JP"), ShouldEqual, "en")
+			So(MatchLocale(template, ""), ShouldEqual, "en")
+		})
+	})
+}
+
+func TestGroupDevicesByLocale(t *testing.T) {
+	Convey("GroupDevicesByLocale", t, func() {
+		template := skydb.PushTemplate{
+			DefaultLocale: "en",
+			Localizations: map[string]skydb.PushTemplateLocalization{
+				"en": {Body: "Hello"},
+				"fr": {Body: "Bonjour"},
+			},
+		}
+		devices := []skydb.Device{
+			{ID: "device1", Locale: "fr-CA"},
+			{ID: "device2"},
+			{ID: "device3", Locale: "fr"},
+		}
+
+		So(GroupDevicesByLocale(template, devices), ShouldResemble, []LocaleGroup{
+			{
+				Locale:  "fr",
+				Devices: []skydb.Device{devices[0], devices[2]},
+			},
+			{
+				Locale:  "en",
+				Devices: []skydb.Device{devices[1]},
+			},
+		})
+	})
+}
+
+func TestLocalizedTemplateReferences(t *testing.T) {
+	Convey("LocalizedTemplateReferences", t, func() {
+		template := skydb.PushTemplate{
+			Localizations: map[string]skydb.PushTemplateLocalization{
+				"en": {Body: "Hello"},
+				"fr": {BodyLocKey: "GREETING", BodyLocArgs: []string{"{{user.username}}"}},
+			},
+			Data: map[string]interface{}{
+				"post_id": "{{record._id}}",
+			},
+		}
+
+		So(LocalizedTemplateReferences(template, "user"), ShouldBeTrue)
+		So(LocalizedTemplateReferences(template, "record"), ShouldBeTrue)
+		So(LocalizedTemplateReferences(template, "users"), ShouldBeFalse)
+	})
+}
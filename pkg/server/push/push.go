@@ -15,9 +15,13 @@
 package push
 
 import (
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/SkygearIO/buford/push"
 
 	"github.com/skygeario/skygear-server/pkg/server/logging"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
@@ -25,6 +29,23 @@ import (
 
 var log = logging.LoggerEntry("push")
 
+// ErrInvalidDeviceToken is returned by a Sender if the push service
+// reports that the device token is no longer valid. The device is
+// unregistered and sending to it again is futile.
+var ErrInvalidDeviceToken = errors.New("push: device token is no longer valid")
+
+// IsInvalidDeviceToken returns whether err returned by a Sender signifies
+// that the device token is no longer valid.
+func IsInvalidDeviceToken(err error) bool {
+	if err == ErrInvalidDeviceToken {
+		return true
+	}
+	if pushError, ok := err.(*push.Error); ok && pushError != nil {
+		return isBadDeviceToken(*pushError)
+	}
+	return false
+}
+
 // EmptyMapper is a Mapper which always returns a empty map.
 const EmptyMapper = emptyMapper(0)
 
@@ -50,6 +71,38 @@ func (m MapMapper) Map() map[string]interface{} {
 	return map[string]interface{}(m)
 }
 
+// WithCollapseKey returns a Mapper which maps to the same notification as
+// m, and which is sent with the specified collapse key. Notifications with
+// the same collapse key replace each other on the device.
+//
+// If key is empty, m is returned.
+func WithCollapseKey(m Mapper, key string) Mapper {
+	if key == "" {
+		return m
+	}
+	return collapseMapper{Mapper: m, key: key}
+}
+
+type collapseMapper struct {
+	Mapper
+	key string
+}
+
+func (m collapseMapper) CollapseKey() string {
+	return m.key
+}
+
+// CollapseKey returns the collapse key of the notification m, or an empty
+// string if m does not have one.
+func CollapseKey(m Mapper) string {
+	if c, ok := m.(interface {
+		CollapseKey() string
+	}); ok {
+		return c.CollapseKey()
+	}
+	return ""
+}
+
 // Sender defines the methods that a push service should support.
 type Sender interface {
 	Send(m Mapper, device skydb.Device) error
@@ -93,3 +146,42 @@ func (s RouteSender) Send(m Mapper, device skydb.Device) error {
 
 	return sender.Send(m, device)
 }
+
+// deviceUnregisterer deletes devices reported as invalid by a push
+// service. The skydb connection is opened on first use.
+type deviceUnregisterer struct {
+	// Function to obtain a skydb connection
+	connOpener func() (skydb.Conn, error)
+
+	mutex sync.Mutex
+	conn  skydb.Conn
+}
+
+func newDeviceUnregisterer(connOpener func() (skydb.Conn, error)) *deviceUnregisterer {
+	return &deviceUnregisterer{connOpener: connOpener}
+}
+
+func (u *deviceUnregisterer) unregister(deviceToken string, beforeTime time.Time) {
+	logger := log.WithFields(logrus.Fields{
+		"deviceToken": deviceToken,
+	})
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.conn == nil {
+		conn, err := u.connOpener()
+		if err != nil {
+			logger.Errorf("push: failed to open skydb.Conn: %v", err)
+			return
+		}
+		u.conn = conn
+	}
+
+	if err := u.conn.DeleteDevicesByToken(deviceToken, beforeTime); err != nil && err != skydb.ErrDeviceNotFound {
+		logger.Errorf("push: failed to delete device token = %s: %v", deviceToken, err)
+		return
+	}
+
+	logger.Info("Unregistered device from skydb")
+}
@@ -0,0 +1,246 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/uuid"
+)
+
+var timeNow = func() time.Time { return time.Now().UTC() }
+
+// Default settings of a Queue.
+const (
+	DefaultQueueWorkers          = 4
+	DefaultQueueBatchSize        = 10
+	DefaultQueuePollInterval     = 5 * time.Second
+	DefaultQueueMaxAttempts      = 5
+	DefaultQueueRetryInterval    = 30 * time.Second
+	DefaultQueueMaxRetryInterval = 1 * time.Hour
+	DefaultQueueLease            = 5 * time.Minute
+)
+
+// Enqueue saves a notification to be sent to each of the devices into
+// the push queue of conn. Each device gets its own delivery identified by
+// the same notificationID.
+func Enqueue(conn skydb.Conn, notificationID string, devices []skydb.Device, m Mapper) error {
+	now := timeNow()
+	notification := m.Map()
+	collapseKey := CollapseKey(m)
+	deliveries := make([]skydb.PushDelivery, len(devices))
+	for i, device := range devices {
+		deliveries[i] = skydb.PushDelivery{
+			ID:             uuid.New(),
+			NotificationID: notificationID,
+			DeviceID:       device.ID,
+			DeviceType:     device.Type,
+			DeviceToken:    device.Token,
+			Notification:   notification,
+			CollapseKey:    collapseKey,
+			State:          skydb.PushDeliveryQueued,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+	}
+	return conn.EnqueuePushDeliveries(deliveries)
+}
+
+// Queue delivers notifications saved in the push queue by Enqueue.
+//
+// Workers of a Queue claim due deliveries from the database and send them
+// with the Sender. A failed delivery is retried with exponential backoff
+// until MaxAttempts is reached. Deliveries are claimed with a lease, so
+// multiple server instances can run a Queue on the same database.
+type Queue struct {
+	// Workers is the number of goroutines sending notifications.
+	Workers int
+
+	// BatchSize is the number of deliveries claimed by a worker at a time.
+	BatchSize int
+
+	// PollInterval is the time an idle worker waits before checking
+	// the queue again.
+	PollInterval time.Duration
+
+	// MaxAttempts is the number of attempts made before a delivery
+	// is marked as failed.
+	MaxAttempts int
+
+	// RetryInterval is the delay before the first retry. The delay
+	// doubles on each subsequent retry, up to MaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	// Lease is the time a claimed delivery is hidden from other workers.
+	// It should be much longer than the time needed to send a batch.
+	Lease time.Duration
+
+	connOpener func() (skydb.Conn, error)
+	sender     Sender
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewQueue returns a Queue with the default settings.
+func NewQueue(connOpener func() (skydb.Conn, error), sender Sender) *Queue {
+	return &Queue{
+		Workers:          DefaultQueueWorkers,
+		BatchSize:        DefaultQueueBatchSize,
+		PollInterval:     DefaultQueuePollInterval,
+		MaxAttempts:      DefaultQueueMaxAttempts,
+		RetryInterval:    DefaultQueueRetryInterval,
+		MaxRetryInterval: DefaultQueueMaxRetryInterval,
+		Lease:            DefaultQueueLease,
+		connOpener:       connOpener,
+		sender:           sender,
+	}
+}
+
+// Start starts the workers of the queue.
+func (q *Queue) Start() {
+	q.stop = make(chan struct{})
+	for i := 0; i < q.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// Stop stops the workers and waits for them to finish their current batch.
+func (q *Queue) Stop() {
+	close(q.stop)
+	q.wg.Wait()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	var conn skydb.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		claimed := 0
+		if conn == nil {
+			var err error
+			if conn, err = q.connOpener(); err != nil {
+				log.Errorf("push/queue: failed to open skydb.Conn: %v", err)
+				conn = nil
+			}
+		}
+		if conn != nil {
+			var err error
+			if claimed, err = q.deliverBatch(conn); err != nil {
+				log.Errorf("push/queue: failed to claim deliveries: %v", err)
+				// the conn may be broken, reconnect on next poll
+				conn.Close()
+				conn = nil
+			}
+		}
+
+		if claimed > 0 {
+			// there may be more due deliveries, continue unless stopped
+			select {
+			case <-q.stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-time.After(q.PollInterval):
+		}
+	}
+}
+
+// deliverBatch claims a batch of due deliveries and sends them. It returns
+// the number of deliveries claimed.
+func (q *Queue) deliverBatch(conn skydb.Conn) (int, error) {
+	deliveries, err := conn.ClaimPushDeliveries(timeNow(), q.Lease, q.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range deliveries {
+		q.deliver(conn, &deliveries[i])
+	}
+	return len(deliveries), nil
+}
+
+func (q *Queue) deliver(conn skydb.Conn, delivery *skydb.PushDelivery) {
+	delivery.Attempts++
+	logger := log.WithFields(logrus.Fields{
+		"notificationID": delivery.NotificationID,
+		"deliveryID":     delivery.ID,
+		"deviceID":       delivery.DeviceID,
+		"attempts":       delivery.Attempts,
+	})
+
+	device := skydb.Device{}
+	err := conn.GetDevice(delivery.DeviceID, &device)
+	if err == nil {
+		err = q.sender.Send(WithCollapseKey(MapMapper(delivery.Notification), delivery.CollapseKey), device)
+	}
+
+	now := timeNow()
+	delivery.UpdatedAt = now
+	switch {
+	case err == nil:
+		delivery.State = skydb.PushDeliverySent
+		delivery.LastError = ""
+		logger.Info("push/queue: notification is sent")
+	case err == skydb.ErrDeviceNotFound || IsInvalidDeviceToken(err):
+		// the device is unregistered, sending again is futile
+		delivery.State = skydb.PushDeliveryTokenInvalid
+		delivery.LastError = err.Error()
+		logger.Info("push/queue: device token is no longer valid")
+	case delivery.Attempts >= q.MaxAttempts:
+		delivery.State = skydb.PushDeliveryFailed
+		delivery.LastError = err.Error()
+		logger.WithField("err", err).Error("push/queue: notification is not sent after maximum attempts")
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(q.retryInterval(delivery.Attempts))
+		logger.WithField("err", err).Warn("push/queue: failed to send notification, will retry")
+	}
+
+	if err := conn.UpdatePushDelivery(delivery); err != nil {
+		logger.WithField("err", err).Error("push/queue: failed to update delivery")
+	}
+}
+
+// retryInterval returns the delay before retrying a delivery which has
+// been attempted for the specified number of times.
+func (q *Queue) retryInterval(attempts int) time.Duration {
+	interval := q.RetryInterval
+	for i := 1; i < attempts && interval < q.MaxRetryInterval; i++ {
+		interval *= 2
+	}
+	if interval > q.MaxRetryInterval {
+		interval = q.MaxRetryInterval
+	}
+	return interval
+}
@@ -0,0 +1,271 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type queueConn struct {
+	mutex      sync.Mutex
+	devices    map[string]skydb.Device
+	deliveries []skydb.PushDelivery
+	closed     int
+	skydb.Conn
+}
+
+func (c *queueConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed++
+	return nil
+}
+
+func (c *queueConn) GetDevice(id string, device *skydb.Device) error {
+	d, ok := c.devices[id]
+	if !ok {
+		return skydb.ErrDeviceNotFound
+	}
+	*device = d
+	return nil
+}
+
+func (c *queueConn) EnqueuePushDeliveries(deliveries []skydb.PushDelivery) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.deliveries = append(c.deliveries, deliveries...)
+	return nil
+}
+
+func (c *queueConn) ClaimPushDeliveries(now time.Time, lease time.Duration, limit int) ([]skydb.PushDelivery, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	claimed := []skydb.PushDelivery{}
+	for i, d := range c.deliveries {
+		if len(claimed) == limit {
+			break
+		}
+		if d.State == skydb.PushDeliveryQueued && !d.NextAttemptAt.After(now) {
+			c.deliveries[i].NextAttemptAt = now.Add(lease)
+			claimed = append(claimed, c.deliveries[i])
+		}
+	}
+	return claimed, nil
+}
+
+func (c *queueConn) UpdatePushDelivery(delivery *skydb.PushDelivery) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, d := range c.deliveries {
+		if d.ID == delivery.ID {
+			c.deliveries[i] = *delivery
+			return nil
+		}
+	}
+	return skydb.ErrPushDeliveryNotFound
+}
+
+func (c *queueConn) delivery(i int) skydb.PushDelivery {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.deliveries[i]
+}
+
+type countingSender struct {
+	mutex        sync.Mutex
+	sent         []skydb.Device
+	collapseKeys []string
+	err          error
+}
+
+func (s *countingSender) Send(m Mapper, device skydb.Device) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, device)
+	s.collapseKeys = append(s.collapseKeys, CollapseKey(m))
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	Convey("Queue", t, func() {
+		now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		originalTimeNow := timeNow
+		timeNow = func() time.Time { return now }
+		defer func() {
+			timeNow = originalTimeNow
+		}()
+
+		device := skydb.Device{
+			ID:    "device",
+			Type:  "ios",
+			Token: "token",
+		}
+		conn := &queueConn{
+			devices: map[string]skydb.Device{"device": device},
+		}
+		sender := &countingSender{}
+		queue := NewQueue(func() (skydb.Conn, error) {
+			return conn, nil
+		}, sender)
+
+		notification := MapMapper{
+			"apns": map[string]interface{}{"alert": "Hello"},
+		}
+
+		Convey("enqueues a delivery for each device", func() {
+			err := Enqueue(conn, "notification", []skydb.Device{device, {
+				ID:    "device2",
+				Type:  "web",
+				Token: "https://push.example.com/endpoint",
+			}}, notification)
+			So(err, ShouldBeNil)
+			So(conn.deliveries, ShouldHaveLength, 2)
+			So(conn.deliveries[0].ID, ShouldNotEqual, conn.deliveries[1].ID)
+
+			delivery := conn.deliveries[1]
+			So(delivery.NotificationID, ShouldEqual, "notification")
+			So(delivery.DeviceID, ShouldEqual, "device2")
+			So(delivery.DeviceType, ShouldEqual, "web")
+			So(delivery.DeviceToken, ShouldEqual, "https://push.example.com/endpoint")
+			So(delivery.Notification, ShouldResemble, notification.Map())
+			So(delivery.State, ShouldEqual, skydb.PushDeliveryQueued)
+			So(delivery.NextAttemptAt, ShouldResemble, now)
+		})
+
+		Convey("delivers a notification", func() {
+			So(Enqueue(conn, "notification", []skydb.Device{device}, notification), ShouldBeNil)
+
+			claimed, err := queue.deliverBatch(conn)
+			So(err, ShouldBeNil)
+			So(claimed, ShouldEqual, 1)
+			So(sender.sent, ShouldResemble, []skydb.Device{device})
+
+			delivery := conn.delivery(0)
+			So(delivery.State, ShouldEqual, skydb.PushDeliverySent)
+			So(delivery.Attempts, ShouldEqual, 1)
+		})
+
+		Convey("delivers a notification with collapse key", func() {
+			So(Enqueue(conn, "notification", []skydb.Device{device}, WithCollapseKey(notification, "score")), ShouldBeNil)
+			So(conn.delivery(0).CollapseKey, ShouldEqual, "score")
+
+			_, err := queue.deliverBatch(conn)
+			So(err, ShouldBeNil)
+			So(sender.collapseKeys, ShouldResemble, []string{"score"})
+		})
+
+		Convey("retries a failed delivery with backoff", func() {
+			So(Enqueue(conn, "notification", []skydb.Device{device}, notification), ShouldBeNil)
+			sender.err = errors.New("service unavailable")
+
+			queue.deliverBatch(conn)
+			delivery := conn.delivery(0)
+			So(delivery.State, ShouldEqual, skydb.PushDeliveryQueued)
+			So(delivery.Attempts, ShouldEqual, 1)
+			So(delivery.LastError, ShouldEqual, "service unavailable")
+			So(delivery.NextAttemptAt, ShouldResemble, now.Add(queue.RetryInterval))
+
+			claimed, _ := queue.deliverBatch(conn)
+			So(claimed, ShouldEqual, 0)
+
+			now = now.Add(queue.RetryInterval)
+			queue.deliverBatch(conn)
+			delivery = conn.delivery(0)
+			So(delivery.Attempts, ShouldEqual, 2)
+			So(delivery.NextAttemptAt, ShouldResemble, now.Add(2*queue.RetryInterval))
+
+			Convey("and marks it failed after maximum attempts", func() {
+				queue.MaxAttempts = 3
+				now = now.Add(2 * queue.RetryInterval)
+				queue.deliverBatch(conn)
+
+				delivery := conn.delivery(0)
+				So(delivery.State, ShouldEqual, skydb.PushDeliveryFailed)
+				So(delivery.Attempts, ShouldEqual, 3)
+			})
+
+			Convey("and marks it sent when the service recovers", func() {
+				sender.err = nil
+				now = now.Add(2 * queue.RetryInterval)
+				queue.deliverBatch(conn)
+
+				delivery := conn.delivery(0)
+				So(delivery.State, ShouldEqual, skydb.PushDeliverySent)
+				So(delivery.LastError, ShouldEqual, "")
+			})
+		})
+
+		Convey("marks delivery token-invalid", func() {
+			Convey("when the device token is invalid", func() {
+				So(Enqueue(conn, "notification", []skydb.Device{device}, notification), ShouldBeNil)
+				sender.err = ErrInvalidDeviceToken
+
+				queue.deliverBatch(conn)
+				So(conn.delivery(0).State, ShouldEqual, skydb.PushDeliveryTokenInvalid)
+			})
+
+			Convey("when the device is unregistered", func() {
+				So(Enqueue(conn, "notification", []skydb.Device{{ID: "deleted"}}, notification), ShouldBeNil)
+
+				queue.deliverBatch(conn)
+				So(conn.delivery(0).State, ShouldEqual, skydb.PushDeliveryTokenInvalid)
+				So(sender.sent, ShouldBeEmpty)
+			})
+		})
+
+		Convey("caps the retry interval", func() {
+			queue.RetryInterval = time.Minute
+			queue.MaxRetryInterval = 10 * time.Minute
+			So(queue.retryInterval(1), ShouldEqual, time.Minute)
+			So(queue.retryInterval(3), ShouldEqual, 4*time.Minute)
+			So(queue.retryInterval(5), ShouldEqual, 10*time.Minute)
+			So(queue.retryInterval(100), ShouldEqual, 10*time.Minute)
+		})
+	})
+
+	Convey("Queue workers deliver queued notifications", t, func() {
+		conn := &queueConn{
+			devices: map[string]skydb.Device{
+				"device": {ID: "device", Type: "ios", Token: "token"},
+			},
+		}
+		sender := &countingSender{}
+		queue := NewQueue(func() (skydb.Conn, error) {
+			return conn, nil
+		}, sender)
+		queue.Workers = 2
+		queue.PollInterval = time.Millisecond
+
+		So(Enqueue(conn, "notification", []skydb.Device{conn.devices["device"]}, EmptyMapper), ShouldBeNil)
+		queue.Start()
+		for i := 0; i < 1000 && conn.delivery(0).State == skydb.PushDeliveryQueued; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		queue.Stop()
+
+		So(conn.delivery(0).State, ShouldEqual, skydb.PushDeliverySent)
+		So(sender.sent, ShouldHaveLength, 1)
+		So(conn.closed, ShouldEqual, 2)
+	})
+}
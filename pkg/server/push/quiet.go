@@ -0,0 +1,139 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// QuietHoursField is the field of the user record specifying the quiet
+// hours of the user, in the form of:
+//
+//	{"start": "22:00", "end": "07:00", "timezone": "Asia/Hong_Kong"}
+//
+// timezone is optional and defaults to UTC.
+const QuietHoursField = "quiet_hours"
+
+// QuietHours is a daily range of time during which non-urgent
+// notifications to a user are deferred. The range wraps around midnight
+// if End is earlier than Start.
+type QuietHours struct {
+	// Start and End are minutes since midnight
+	Start    int
+	End      int
+	Location *time.Location
+}
+
+// ParseQuietHours parses the quiet hours specified in a user record.
+func ParseQuietHours(value interface{}) (*QuietHours, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("push: quiet hours is not a dictionary")
+	}
+
+	start, err := parseClock(m["start"])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(m["end"])
+	if err != nil {
+		return nil, err
+	}
+
+	location := time.UTC
+	if timezone, ok := m["timezone"].(string); ok && timezone != "" {
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("push: invalid quiet hours timezone = %s", timezone)
+		}
+	}
+
+	return &QuietHours{
+		Start:    start,
+		End:      end,
+		Location: location,
+	}, nil
+}
+
+func parseClock(value interface{}) (int, error) {
+	str, _ := value.(string)
+	t, err := time.Parse("15:04", str)
+	if err != nil {
+		return 0, fmt.Errorf("push: invalid quiet hours time = %v", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Defer returns the end of the quiet hours if t is within the quiet hours,
+// and whether the notification should be deferred to it.
+func (q *QuietHours) Defer(t time.Time) (time.Time, bool) {
+	if q == nil || q.Start == q.End {
+		return time.Time{}, false
+	}
+
+	local := t.In(q.Location)
+	minute := local.Hour()*60 + local.Minute()
+
+	var quiet bool
+	if q.Start < q.End {
+		quiet = minute >= q.Start && minute < q.End
+	} else {
+		quiet = minute >= q.Start || minute < q.End
+	}
+	if !quiet {
+		return time.Time{}, false
+	}
+
+	end := time.Date(local.Year(), local.Month(), local.Day(), q.End/60, q.End%60, 0, 0, q.Location)
+	if !end.After(local) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end.UTC(), true
+}
+
+// UserQuietHours returns the quiet hours of the user from the user record
+// in the public database of conn. It returns nil if the user has no
+// quiet hours specified.
+func UserQuietHours(conn skydb.Conn, userID string) *QuietHours {
+	logger := log.WithFields(logrus.Fields{
+		"userID": userID,
+	})
+
+	db := conn.PublicDB()
+	record := skydb.Record{}
+	if err := db.Get(skydb.NewRecordID(db.UserRecordType(), userID), &record); err != nil {
+		if err != skydb.ErrRecordNotFound {
+			logger.WithField("err", err).Error("push: failed to fetch user record")
+		}
+		return nil
+	}
+
+	value := record.Get(QuietHoursField)
+	if value == nil {
+		return nil
+	}
+
+	quietHours, err := ParseQuietHours(value)
+	if err != nil {
+		logger.WithField("err", err).Warn("push: ignored invalid quiet hours")
+		return nil
+	}
+	return quietHours
+}
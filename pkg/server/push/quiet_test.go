@@ -0,0 +1,171 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type quietHoursConn struct {
+	db *skydbtest.MapDB
+	skydb.Conn
+}
+
+func (c *quietHoursConn) PublicDB() skydb.Database {
+	return c.db
+}
+
+func TestParseQuietHours(t *testing.T) {
+	Convey("ParseQuietHours", t, func() {
+		Convey("parses quiet hours", func() {
+			quietHours, err := ParseQuietHours(map[string]interface{}{
+				"start":    "22:00",
+				"end":      "07:30",
+				"timezone": "Asia/Hong_Kong",
+			})
+			So(err, ShouldBeNil)
+			So(quietHours.Start, ShouldEqual, 22*60)
+			So(quietHours.End, ShouldEqual, 7*60+30)
+			So(quietHours.Location.String(), ShouldEqual, "Asia/Hong_Kong")
+		})
+
+		Convey("defaults to UTC", func() {
+			quietHours, err := ParseQuietHours(map[string]interface{}{
+				"start": "22:00",
+				"end":   "07:00",
+			})
+			So(err, ShouldBeNil)
+			So(quietHours.Location, ShouldEqual, time.UTC)
+		})
+
+		Convey("rejects invalid quiet hours", func() {
+			_, err := ParseQuietHours("22:00-07:00")
+			So(err, ShouldNotBeNil)
+
+			_, err = ParseQuietHours(map[string]interface{}{
+				"start": "10pm",
+				"end":   "07:00",
+			})
+			So(err, ShouldNotBeNil)
+
+			_, err = ParseQuietHours(map[string]interface{}{
+				"start":    "22:00",
+				"end":      "07:00",
+				"timezone": "Mars/Olympus_Mons",
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestQuietHoursDefer(t *testing.T) {
+	Convey("QuietHours.Defer", t, func() {
+		hongKong, _ := time.LoadLocation("Asia/Hong_Kong")
+
+		Convey("defers overnight quiet hours", func() {
+			quietHours := &QuietHours{Start: 22 * 60, End: 7 * 60, Location: hongKong}
+
+			// 23:00 in Hong Kong
+			sendAt, ok := quietHours.Defer(time.Date(2006, 1, 2, 15, 0, 0, 0, time.UTC))
+			So(ok, ShouldBeTrue)
+			So(sendAt, ShouldResemble, time.Date(2006, 1, 2, 23, 0, 0, 0, time.UTC))
+
+			// 02:00 in Hong Kong
+			sendAt, ok = quietHours.Defer(time.Date(2006, 1, 2, 18, 0, 0, 0, time.UTC))
+			So(ok, ShouldBeTrue)
+			So(sendAt, ShouldResemble, time.Date(2006, 1, 2, 23, 0, 0, 0, time.UTC))
+
+			// 07:00 in Hong Kong
+			_, ok = quietHours.Defer(time.Date(2006, 1, 2, 23, 0, 0, 0, time.UTC))
+			So(ok, ShouldBeFalse)
+
+			// 12:00 in Hong Kong
+			_, ok = quietHours.Defer(time.Date(2006, 1, 2, 4, 0, 0, 0, time.UTC))
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("defers daytime quiet hours", func() {
+			quietHours := &QuietHours{Start: 9 * 60, End: 17 * 60, Location: time.UTC}
+
+			sendAt, ok := quietHours.Defer(time.Date(2006, 1, 2, 9, 0, 0, 0, time.UTC))
+			So(ok, ShouldBeTrue)
+			So(sendAt, ShouldResemble, time.Date(2006, 1, 2, 17, 0, 0, 0, time.UTC))
+
+			_, ok = quietHours.Defer(time.Date(2006, 1, 2, 18, 0, 0, 0, time.UTC))
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("does not defer without quiet hours", func() {
+			var quietHours *QuietHours
+			_, ok := quietHours.Defer(time.Date(2006, 1, 2, 9, 0, 0, 0, time.UTC))
+			So(ok, ShouldBeFalse)
+
+			quietHours = &QuietHours{Start: 9 * 60, End: 9 * 60, Location: time.UTC}
+			_, ok = quietHours.Defer(time.Date(2006, 1, 2, 9, 0, 0, 0, time.UTC))
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestUserQuietHours(t *testing.T) {
+	Convey("UserQuietHours", t, func() {
+		db := skydbtest.NewMapDB()
+		conn := &quietHoursConn{db: db}
+
+		Convey("returns quiet hours of user record", func() {
+			db.Save(&skydb.Record{
+				ID: skydb.NewRecordID("user", "johndoe"),
+				Data: map[string]interface{}{
+					"quiet_hours": map[string]interface{}{
+						"start": "22:00",
+						"end":   "07:00",
+					},
+				},
+			})
+			So(UserQuietHours(conn, "johndoe"), ShouldResemble, &QuietHours{
+				Start:    22 * 60,
+				End:      7 * 60,
+				Location: time.UTC,
+			})
+		})
+
+		Convey("returns nil without quiet hours", func() {
+			db.Save(&skydb.Record{
+				ID:   skydb.NewRecordID("user", "johndoe"),
+				Data: map[string]interface{}{},
+			})
+			So(UserQuietHours(conn, "johndoe"), ShouldBeNil)
+		})
+
+		Convey("returns nil for invalid quiet hours", func() {
+			db.Save(&skydb.Record{
+				ID: skydb.NewRecordID("user", "johndoe"),
+				Data: map[string]interface{}{
+					"quiet_hours": "always",
+				},
+			})
+			So(UserQuietHours(conn, "johndoe"), ShouldBeNil)
+		})
+
+		Convey("returns nil without user record", func() {
+			So(UserQuietHours(conn, "nonexistent"), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,153 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// Default settings of a Scheduler.
+const (
+	DefaultSchedulerBatchSize = 50
+	DefaultSchedulerLease     = 5 * time.Minute
+)
+
+// ScheduleDispatcher sends a scheduled notification when it is due, usually
+// by enqueuing it to the push queue.
+type ScheduleDispatcher func(conn skydb.Conn, schedule skydb.PushSchedule) error
+
+// Scheduler dispatches scheduled notifications which are due.
+//
+// Run is expected to be called periodically, e.g. by a cron job. Schedules
+// are claimed with a lease, so a schedule failed to be dispatched is
+// retried after the lease expires, and multiple server instances can run
+// a Scheduler on the same database.
+type Scheduler struct {
+	// BatchSize is the number of schedules claimed at a time.
+	BatchSize int
+
+	// Lease is the time a claimed schedule is hidden from other
+	// schedulers.
+	Lease time.Duration
+
+	connOpener func() (skydb.Conn, error)
+	dispatch   ScheduleDispatcher
+
+	mutex   sync.Mutex
+	running bool
+}
+
+// NewScheduler returns a Scheduler with the default settings.
+func NewScheduler(connOpener func() (skydb.Conn, error), dispatch ScheduleDispatcher) *Scheduler {
+	return &Scheduler{
+		BatchSize:  DefaultSchedulerBatchSize,
+		Lease:      DefaultSchedulerLease,
+		connOpener: connOpener,
+		dispatch:   dispatch,
+	}
+}
+
+// Run dispatches all due schedules. Run returns immediately if the
+// previous Run is still in progress.
+func (s *Scheduler) Run() {
+	// cron may call Run again before the previous one finishes
+	if !s.setRunning(true) {
+		return
+	}
+	defer s.setRunning(false)
+
+	conn, err := s.connOpener()
+	if err != nil {
+		log.Errorf("push/scheduler: failed to open skydb.Conn: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	for {
+		schedules, err := conn.ClaimPushSchedules(timeNow(), s.Lease, s.BatchSize)
+		if err != nil {
+			log.Errorf("push/scheduler: failed to claim schedules: %v", err)
+			return
+		}
+
+		for i := range schedules {
+			s.dispatchSchedule(conn, &schedules[i])
+		}
+
+		if len(schedules) < s.BatchSize {
+			return
+		}
+	}
+}
+
+// setRunning sets whether Run is in progress. It returns false if Run
+// is already in progress when running is true.
+func (s *Scheduler) setRunning(running bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if running && s.running {
+		return false
+	}
+	s.running = running
+	return true
+}
+
+func (s *Scheduler) dispatchSchedule(conn skydb.Conn, schedule *skydb.PushSchedule) {
+	logger := log.WithFields(logrus.Fields{
+		"notificationID": schedule.NotificationID,
+		"scheduleID":     schedule.ID,
+	})
+
+	// the schedule is marked sent in the transaction which dispatches
+	// it, so that a schedule is never dispatched again once dispatched
+	err := withTransaction(conn, func() error {
+		if err := s.dispatch(conn, *schedule); err != nil {
+			return err
+		}
+
+		schedule.State = skydb.PushScheduleSent
+		schedule.UpdatedAt = timeNow()
+		return conn.UpdatePushSchedule(schedule)
+	})
+	if err != nil {
+		// the schedule is retried when the lease expires
+		logger.WithField("err", err).Error("push/scheduler: failed to dispatch schedule")
+	}
+}
+
+// withTransaction calls do in a transaction of conn, if transaction is
+// supported by the database of conn.
+func withTransaction(conn skydb.Conn, do func() error) error {
+	txDB, ok := conn.PublicDB().(skydb.TxDatabase)
+	if !ok {
+		return do()
+	}
+
+	if err := txDB.Begin(); err != nil {
+		return err
+	}
+	if err := do(); err != nil {
+		if rbErr := txDB.Rollback(); rbErr != nil {
+			log.Errorf("push/scheduler: failed to rollback: %v", rbErr)
+		}
+		return err
+	}
+	return txDB.Commit()
+}
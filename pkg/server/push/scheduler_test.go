@@ -0,0 +1,135 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type scheduleConn struct {
+	schedules   []skydb.PushSchedule
+	lockedUntil map[string]time.Time
+	db          *skydbtest.MockTxDatabase
+	closed      bool
+	skydb.Conn
+}
+
+func (c *scheduleConn) PublicDB() skydb.Database {
+	return c.db
+}
+
+func (c *scheduleConn) ClaimPushSchedules(now time.Time, lease time.Duration, limit int) ([]skydb.PushSchedule, error) {
+	claimed := []skydb.PushSchedule{}
+	for _, s := range c.schedules {
+		if len(claimed) == limit {
+			break
+		}
+		if s.State == skydb.PushScheduleScheduled && !s.SendAt.After(now) && !c.lockedUntil[s.ID].After(now) {
+			c.lockedUntil[s.ID] = now.Add(lease)
+			claimed = append(claimed, s)
+		}
+	}
+	return claimed, nil
+}
+
+func (c *scheduleConn) UpdatePushSchedule(schedule *skydb.PushSchedule) error {
+	for i, s := range c.schedules {
+		if s.ID == schedule.ID {
+			c.schedules[i] = *schedule
+			return nil
+		}
+	}
+	return skydb.ErrPushScheduleNotFound
+}
+
+func (c *scheduleConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestScheduler(t *testing.T) {
+	Convey("Scheduler", t, func() {
+		now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		originalTimeNow := timeNow
+		timeNow = func() time.Time { return now }
+		defer func() {
+			timeNow = originalTimeNow
+		}()
+
+		conn := &scheduleConn{
+			schedules: []skydb.PushSchedule{
+				{ID: "due1", State: skydb.PushScheduleScheduled, SendAt: now.Add(-time.Minute)},
+				{ID: "due2", State: skydb.PushScheduleScheduled, SendAt: now},
+				{ID: "later", State: skydb.PushScheduleScheduled, SendAt: now.Add(time.Hour)},
+				{ID: "cancelled", State: skydb.PushScheduleCancelled, SendAt: now},
+			},
+			lockedUntil: map[string]time.Time{},
+			db:          skydbtest.NewMockTxDatabase(skydbtest.NewMapDB()),
+		}
+		dispatched := []string{}
+		var dispatchErr error
+		scheduler := NewScheduler(func() (skydb.Conn, error) {
+			return conn, nil
+		}, func(conn skydb.Conn, schedule skydb.PushSchedule) error {
+			dispatched = append(dispatched, schedule.ID)
+			return dispatchErr
+		})
+		scheduler.BatchSize = 1
+
+		Convey("dispatches due schedules", func() {
+			scheduler.Run()
+			So(dispatched, ShouldResemble, []string{"due1", "due2"})
+			So(conn.schedules[0].State, ShouldEqual, skydb.PushScheduleSent)
+			So(conn.schedules[0].UpdatedAt, ShouldResemble, now)
+			So(conn.schedules[1].State, ShouldEqual, skydb.PushScheduleSent)
+			So(conn.schedules[2].State, ShouldEqual, skydb.PushScheduleScheduled)
+			So(conn.schedules[3].State, ShouldEqual, skydb.PushScheduleCancelled)
+			So(conn.db.DidBegin, ShouldBeTrue)
+			So(conn.db.DidCommit, ShouldBeTrue)
+			So(conn.db.DidRollback, ShouldBeFalse)
+			So(conn.closed, ShouldBeTrue)
+		})
+
+		Convey("retries failed schedule after lease", func() {
+			dispatchErr = errors.New("scheduler_test: some error")
+			scheduler.Run()
+			So(dispatched, ShouldResemble, []string{"due1", "due2"})
+			So(conn.schedules[0].State, ShouldEqual, skydb.PushScheduleScheduled)
+			So(conn.schedules[0].SendAt, ShouldResemble, now.Add(-time.Minute))
+			So(conn.db.DidRollback, ShouldBeTrue)
+
+			scheduler.Run()
+			So(dispatched, ShouldResemble, []string{"due1", "due2"})
+
+			dispatchErr = nil
+			now = now.Add(scheduler.Lease)
+			scheduler.Run()
+			So(dispatched, ShouldResemble, []string{"due1", "due2", "due1", "due2"})
+			So(conn.schedules[0].State, ShouldEqual, skydb.PushScheduleSent)
+		})
+
+		Convey("skips run while the previous run is in progress", func() {
+			scheduler.running = true
+			scheduler.Run()
+			So(dispatched, ShouldBeEmpty)
+		})
+	})
+}
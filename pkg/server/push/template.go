@@ -0,0 +1,127 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var placeholderRegexp = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_$]+(?:\.[A-Za-z0-9_$]+)*)\s*\}\}`)
+
+// TemplateMapper is a Mapper which renders placeholders in the string
+// values of Notification with Context.
+//
+// A placeholder is a key path enclosed in double braces, like
+// `{{user.username}}`. A placeholder referencing a missing value renders
+// as an empty string. A string consisting of a single placeholder is
+// replaced by the referenced value itself, so that non-string values
+// like a badge count keep their type.
+type TemplateMapper struct {
+	Notification map[string]interface{}
+	Context      map[string]interface{}
+}
+
+// Map returns the notification with placeholders rendered.
+func (m TemplateMapper) Map() map[string]interface{} {
+	rendered, _ := m.render(m.Notification).(map[string]interface{})
+	return rendered
+}
+
+func (m TemplateMapper) render(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			rendered[key] = m.render(value)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, value := range v {
+			rendered[i] = m.render(value)
+		}
+		return rendered
+	case string:
+		return m.renderString(v)
+	default:
+		return v
+	}
+}
+
+func (m TemplateMapper) renderString(s string) interface{} {
+	if match := placeholderRegexp.FindStringSubmatchIndex(s); match != nil &&
+		match[0] == 0 && match[1] == len(s) {
+		value, _ := lookupKeyPath(m.Context, s[match[2]:match[3]])
+		if value == nil {
+			return ""
+		}
+		return value
+	}
+
+	return placeholderRegexp.ReplaceAllStringFunc(s, func(placeholder string) string {
+		keyPath := placeholderRegexp.FindStringSubmatch(placeholder)[1]
+		value, ok := lookupKeyPath(m.Context, keyPath)
+		if !ok || value == nil {
+			return ""
+		}
+		return fmt.Sprint(value)
+	})
+}
+
+// TemplateReferences returns whether any placeholder in notification
+// references a key path under name.
+func TemplateReferences(notification map[string]interface{}, name string) bool {
+	return templateReferences(notification, name)
+}
+
+func templateReferences(value interface{}, name string) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, value := range v {
+			if templateReferences(value, name) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if templateReferences(value, name) {
+				return true
+			}
+		}
+	case string:
+		for _, match := range placeholderRegexp.FindAllStringSubmatch(v, -1) {
+			if match[1] == name || strings.HasPrefix(match[1], name+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func lookupKeyPath(context map[string]interface{}, keyPath string) (interface{}, bool) {
+	var value interface{} = context
+	for _, component := range strings.Split(keyPath, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[component]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
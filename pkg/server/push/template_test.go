@@ -0,0 +1,97 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTemplateMapper(t *testing.T) {
+	Convey("TemplateMapper", t, func() {
+		context := map[string]interface{}{
+			"user": map[string]interface{}{
+				"_id":      "userid",
+				"username": "johndoe",
+			},
+			"record": map[string]interface{}{
+				"unread": float64(3),
+			},
+		}
+
+		Convey("renders placeholders in nested values", func() {
+			mapper := TemplateMapper{
+				Notification: map[string]interface{}{
+					"apns": map[string]interface{}{
+						"aps": map[string]interface{}{
+							"alert": "Hi {{ user.username }}, you have {{record.unread}} messages.",
+							"badge": "{{record.unread}}",
+						},
+					},
+					"gcm": map[string]interface{}{
+						"data": []interface{}{"{{user._id}}", true},
+					},
+				},
+				Context: context,
+			}
+			So(mapper.Map(), ShouldResemble, map[string]interface{}{
+				"apns": map[string]interface{}{
+					"aps": map[string]interface{}{
+						"alert": "Hi johndoe, you have 3 messages.",
+						"badge": float64(3),
+					},
+				},
+				"gcm": map[string]interface{}{
+					"data": []interface{}{"userid", true},
+				},
+			})
+		})
+
+		Convey("renders missing values as empty string", func() {
+			mapper := TemplateMapper{
+				Notification: map[string]interface{}{
+					"alert": "Hi {{user.nickname}}!",
+					"title": "{{record.unread.count}}",
+				},
+				Context: context,
+			}
+			So(mapper.Map(), ShouldResemble, map[string]interface{}{
+				"alert": "Hi !",
+				"title": "",
+			})
+		})
+
+		Convey("does not modify the notification", func() {
+			notification := map[string]interface{}{
+				"alert": "Hi {{user.username}}",
+			}
+			TemplateMapper{Notification: notification, Context: context}.Map()
+			So(notification["alert"], ShouldEqual, "Hi {{user.username}}")
+		})
+	})
+
+	Convey("TemplateReferences", t, func() {
+		notification := map[string]interface{}{
+			"apns": map[string]interface{}{
+				"alert": []interface{}{"Hi {{ user.username }}"},
+			},
+			"title": "{{record}}",
+		}
+		So(TemplateReferences(notification, "user"), ShouldBeTrue)
+		So(TemplateReferences(notification, "record"), ShouldBeTrue)
+		So(TemplateReferences(notification, "use"), ShouldBeFalse)
+	})
+}
@@ -209,6 +209,7 @@ func (pusher *tokenBasedAPNSPusher) Send(m Mapper, device skydb.Device) error {
 	headers := push.Headers{
 		Topic:         device.Topic,
 		Authorization: pusher.getToken().value,
+		CollapseID:    apnsCollapseID(m),
 	}
 
 	apnsid, err := pusher.service.Push(device.Token, &headers, serializedPayload)
@@ -0,0 +1,311 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// webPushRecordSize is the record size of the aes128gcm content
+	// encoding. A single record is sent so the payload is limited to
+	// the record size less the padding delimiter and the GCM tag.
+	webPushRecordSize = 4096
+	webPushTTL        = 4 * 7 * 24 * time.Hour
+	vapidTokenExpiry  = 12 * time.Hour
+
+	webPushTopicMaxLength = 32
+)
+
+var webPushTopicRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// WebPushPusher sends push notifications to browsers via the Web Push
+// protocol. The application server is identified by VAPID and the
+// payload is encrypted with the aes128gcm content encoding.
+//
+// A device of Web Push has the subscription endpoint as Token, and the
+// "p256dh" and "auth" keys of the subscription as Keys.
+type WebPushPusher struct {
+	unregisterer *deviceUnregisterer
+
+	client     *http.Client
+	subject    string
+	publicKey  string
+	privateKey *ecdsa.PrivateKey
+}
+
+// NewWebPushPusher creates a new WebPushPusher with the VAPID key pair,
+// which are the URL-safe base64 encoded uncompressed public key and
+// private key on the P-256 curve.
+//
+// subject is the contact of the application server, in the form of a
+// mailto: or https: URL.
+func NewWebPushPusher(
+	connOpener func() (skydb.Conn, error),
+	subject string,
+	publicKey string,
+	privateKey string,
+) (*WebPushPusher, error) {
+	privateKeyBytes, err := decodeBase64URL(privateKey)
+	if err != nil || len(privateKeyBytes) != 32 {
+		return nil, errors.New("push/webpush: VAPID private key is malformed")
+	}
+
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(privateKeyBytes)}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(privateKeyBytes)
+
+	derivedPublicKey := elliptic.Marshal(curve, key.PublicKey.X, key.PublicKey.Y)
+	publicKeyBytes, err := decodeBase64URL(publicKey)
+	if err != nil || !bytes.Equal(publicKeyBytes, derivedPublicKey) {
+		return nil, errors.New("push/webpush: VAPID public key does not match the private key")
+	}
+
+	return &WebPushPusher{
+		unregisterer: newDeviceUnregisterer(connOpener),
+		client:       newWebPushClient(),
+		subject:      subject,
+		publicKey:    base64.RawURLEncoding.EncodeToString(derivedPublicKey),
+		privateKey:   key,
+	}, nil
+}
+
+// Send sends the dictionary of the "web" key in m to the device.
+func (pusher *WebPushPusher) Send(m Mapper, device skydb.Device) error {
+	logger := log.WithFields(logrus.Fields{
+		"deviceID":    device.ID,
+		"deviceToken": device.Token,
+	})
+
+	if m == nil {
+		logger.Warn("Cannot send push notification with nil data.")
+		return errors.New("push/webpush: push notification has no data")
+	}
+
+	webMap, ok := m.Map()["web"].(map[string]interface{})
+	if !ok {
+		return errors.New("push/webpush: payload has no web dictionary")
+	}
+
+	if endpointURL, err := url.Parse(device.Token); err != nil || endpointURL.Scheme != "https" {
+		logger.Error("push/webpush: subscription endpoint is not an https URL")
+		return errors.New("push/webpush: endpoint must be an https URL")
+	}
+
+	payload, err := json.Marshal(webMap)
+	if err != nil {
+		return err
+	}
+
+	body, err := encryptWebPushPayload(payload, device.Keys["p256dh"], device.Keys["auth"])
+	if err != nil {
+		logger.Errorf("push/webpush: failed to encrypt payload: %v", err)
+		return err
+	}
+
+	authorization, err := pusher.vapidAuthorization(device.Token)
+	if err != nil {
+		logger.Errorf("push/webpush: failed to sign VAPID token: %v", err)
+		return err
+	}
+
+	req, err := http.NewRequest("POST", device.Token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(webPushTTL.Seconds())))
+	if key := CollapseKey(m); key != "" {
+		req.Header.Set("Topic", webPushTopic(key))
+	}
+
+	sentAt := time.Now().UTC()
+	resp, err := pusher.client.Do(req)
+	if err != nil {
+		logger.Errorf("push/webpush: failed to send push notification: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		// The subscription is expired or unsubscribed by the user
+		logger.WithField("status", resp.StatusCode).
+			Info("push/webpush: subscription is no longer valid")
+		pusher.unregisterer.unregister(device.Token, sentAt)
+		return ErrInvalidDeviceToken
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		logger.WithField("status", resp.StatusCode).
+			Error("push/webpush: failed to send push notification")
+		return fmt.Errorf("push/webpush: push service responded with status = %d", resp.StatusCode)
+	}
+
+	logger.Info("push/webpush: push notification is sent")
+	return nil
+}
+
+// webPushTopic returns the Topic header for the collapse key. The push
+// service accepts at most 32 characters of the URL-safe base64 alphabet,
+// so other keys are replaced by their SHA-256 digest.
+func webPushTopic(key string) string {
+	if len(key) <= webPushTopicMaxLength && webPushTopicRegexp.MatchString(key) {
+		return key
+	}
+	digest := sha256.Sum256([]byte(key))
+	return base64.RawURLEncoding.EncodeToString(digest[:])[:webPushTopicMaxLength]
+}
+
+// vapidAuthorization returns the Authorization header identifying the
+// application server to the push service of the endpoint.
+func (pusher *WebPushPusher) vapidAuthorization(endpoint string) (string, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.StandardClaims{
+		Audience:  endpointURL.Scheme + "://" + endpointURL.Host,
+		ExpiresAt: time.Now().Add(vapidTokenExpiry).Unix(),
+		Subject:   pusher.subject,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	signedToken, err := token.SignedString(pusher.privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("vapid t=%s, k=%s", signedToken, pusher.publicKey), nil
+}
+
+// encryptWebPushPayload encrypts payload for the user agent identified
+// by its public key and authentication secret, using the aes128gcm
+// content encoding with a single record.
+func encryptWebPushPayload(payload []byte, userAgentPublicKey string, authSecret string) ([]byte, error) {
+	if len(payload)+1+16 > webPushRecordSize {
+		return nil, errors.New("push/webpush: payload is too large")
+	}
+
+	uaPublic, err := decodeBase64URL(userAgentPublicKey)
+	if err != nil {
+		return nil, errors.New("push/webpush: p256dh key is malformed")
+	}
+	auth, err := decodeBase64URL(authSecret)
+	if err != nil || len(auth) == 0 {
+		return nil, errors.New("push/webpush: auth secret is malformed")
+	}
+
+	curve := elliptic.P256()
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, errors.New("push/webpush: p256dh key is malformed")
+	}
+
+	asPrivate, asX, asY, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := elliptic.Marshal(curve, asX, asY)
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	sharedX, _ := curve.ScalarMult(uaX, uaY, asPrivate)
+	cek, nonce, err := deriveWebPushKeys(sharedX, auth, salt, uaPublic, asPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// The last (and only) record is delimited by 0x02 without padding
+	plaintext := append(append([]byte{}, payload...), 0x02)
+
+	header := bytes.Buffer{}
+	header.Write(salt)
+	binary.Write(&header, binary.BigEndian, uint32(webPushRecordSize))
+	header.WriteByte(byte(len(asPublic)))
+	header.Write(asPublic)
+
+	return gcm.Seal(header.Bytes(), nonce, plaintext, nil), nil
+}
+
+// deriveWebPushKeys derives the content encryption key and nonce from
+// the ECDH shared secret as specified by the Web Push encryption.
+func deriveWebPushKeys(sharedX *big.Int, auth, salt, uaPublic, asPublic []byte) ([]byte, []byte, error) {
+	sharedSecret := make([]byte, 32)
+	sharedXBytes := sharedX.Bytes()
+	copy(sharedSecret[32-len(sharedXBytes):], sharedXBytes)
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, auth, keyInfo), ikm); err != nil {
+		return nil, nil, err
+	}
+
+	cek := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return cek, nonce, nil
+}
+
+// decodeBase64URL decodes URL-safe base64 string with or without
+// padding, which are both used by browsers and key generators.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,145 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Subscription endpoints are registered by clients and requested by the
+// server, so requests to hosts in the network of the server are refused.
+var nonPublicIPNets = func() []*net.IPNet {
+	cidrs := []string{
+		"0.0.0.0/8",      // unspecified
+		"10.0.0.0/8",     // private
+		"100.64.0.0/10",  // shared address space
+		"127.0.0.0/8",    // loopback
+		"169.254.0.0/16", // link-local
+		"172.16.0.0/12",  // private
+		"192.168.0.0/16", // private
+		"224.0.0.0/4",    // multicast
+		"::/128",         // unspecified
+		"::1/128",        // loopback
+		"fc00::/7",       // unique local
+		"fe80::/10",      // link-local
+		"ff00::/8",       // multicast
+	}
+
+	ipNets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ipNets[i] = ipNet
+	}
+	return ipNets
+}()
+
+// isPublicIP returns false if ip is a loopback, private, link-local or
+// other address not routable on the Internet.
+func isPublicIP(ip net.IP) bool {
+	for _, ipNet := range nonPublicIPNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateWebPushEndpoint returns an error if endpoint cannot be a Web
+// Push subscription endpoint. The endpoint must be an https URL, whose
+// host is not localhost or a non-public IP address.
+//
+// Host names are checked again when they are resolved on sending.
+func ValidateWebPushEndpoint(endpoint string) error {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	if endpointURL.Scheme != "https" {
+		return errors.New("push/webpush: endpoint must be an https URL")
+	}
+
+	host := endpointURL.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if host == "" {
+		return errors.New("push/webpush: endpoint has no host")
+	}
+
+	if strings.ToLower(host) == "localhost" || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return errors.New("push/webpush: endpoint host is not public")
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return errors.New("push/webpush: endpoint host is not public")
+	}
+
+	return nil
+}
+
+// newWebPushClient returns an http.Client which connects to public IP
+// addresses only and does not follow redirects.
+//
+// The host is resolved and checked before connecting to the checked
+// address, so that a host resolving to a different address at connect
+// time cannot reach the network of the server.
+func newWebPushClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				host, port, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+
+				ips, err := net.LookupIP(host)
+				if err != nil {
+					return nil, err
+				}
+				for _, ip := range ips {
+					if !isPublicIP(ip) {
+						return nil, fmt.Errorf("push/webpush: endpoint host %s resolves to non-public address %s", host, ip)
+					}
+				}
+				if len(ips) == 0 {
+					return nil, fmt.Errorf("push/webpush: endpoint host %s has no address", host)
+				}
+
+				return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].String(), port))
+			},
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebPushEndpoint(t *testing.T) {
+	Convey("isPublicIP", t, func() {
+		So(isPublicIP(net.ParseIP("8.8.8.8")), ShouldBeTrue)
+		So(isPublicIP(net.ParseIP("2001:4860:4860::8888")), ShouldBeTrue)
+
+		for _, ip := range []string{
+			"127.0.0.1",
+			"10.1.2.3",
+			"172.16.0.1",
+			"192.168.1.1",
+			"169.254.169.254",
+			"0.0.0.0",
+			"::1",
+			"fd00::1",
+			"fe80::1",
+			"::ffff:127.0.0.1",
+		} {
+			So(isPublicIP(net.ParseIP(ip)), ShouldBeFalse)
+		}
+	})
+
+	Convey("ValidateWebPushEndpoint", t, func() {
+		Convey("accepts https endpoint of public host", func() {
+			So(ValidateWebPushEndpoint("https://fcm.googleapis.com/fcm/send/abc"), ShouldBeNil)
+			So(ValidateWebPushEndpoint("https://8.8.8.8:8443/send/abc"), ShouldBeNil)
+		})
+
+		Convey("rejects endpoint not https", func() {
+			So(ValidateWebPushEndpoint("http://fcm.googleapis.com/fcm/send/abc"), ShouldNotBeNil)
+			So(ValidateWebPushEndpoint("ftp://fcm.googleapis.com/fcm/send/abc"), ShouldNotBeNil)
+			So(ValidateWebPushEndpoint("/fcm/send/abc"), ShouldNotBeNil)
+		})
+
+		Convey("rejects endpoint of non-public host", func() {
+			for _, endpoint := range []string{
+				"https://localhost/send",
+				"https://LOCALHOST./send",
+				"https://api.localhost/send",
+				"https://127.0.0.1/send",
+				"https://192.168.0.10:8443/send",
+				"https://[::1]:443/send",
+				"https://[fe80::1]/send",
+			} {
+				So(ValidateWebPushEndpoint(endpoint), ShouldNotBeNil)
+			}
+		})
+	})
+
+	Convey("newWebPushClient", t, func() {
+		requested := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = true
+		}))
+		defer server.Close()
+
+		Convey("refuses to connect to non-public address", func() {
+			_, err := newWebPushClient().Get(server.URL)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "non-public address")
+			So(requested, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,271 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	testVAPIDPublicKey  = "BBLhb6eVpCxtaoDIaaVz4moGSZGtinvs88KUeegWJ0GsSdSeuDcfill8z4Duyi2nOJbddC4a83grW_V2d_uDvo0"
+	testVAPIDPrivateKey = "r0ZKTHKQ8EfXNTanVfTcjVzOHp23FhfJMPJw-_vvoXg"
+)
+
+type testUserAgent struct {
+	private    []byte
+	public     []byte
+	authSecret []byte
+}
+
+func newTestUserAgent() testUserAgent {
+	private, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+	return testUserAgent{
+		private:    private,
+		public:     elliptic.Marshal(elliptic.P256(), x, y),
+		authSecret: authSecret,
+	}
+}
+
+func (ua testUserAgent) keys() map[string]string {
+	return map[string]string{
+		"p256dh": base64.RawURLEncoding.EncodeToString(ua.public),
+		"auth":   base64.RawURLEncoding.EncodeToString(ua.authSecret),
+	}
+}
+
+func (ua testUserAgent) decrypt(body []byte) ([]byte, error) {
+	salt := body[:16]
+	recordSize := binary.BigEndian.Uint32(body[16:20])
+	idLen := int(body[20])
+	asPublic := body[21 : 21+idLen]
+	ciphertext := body[21+idLen:]
+	So(recordSize, ShouldEqual, webPushRecordSize)
+
+	curve := elliptic.P256()
+	asX, asY := elliptic.Unmarshal(curve, asPublic)
+	sharedX, _ := curve.ScalarMult(asX, asY, ua.private)
+	cek, nonce, err := deriveWebPushKeys(sharedX, ua.authSecret, salt, ua.public, asPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	// strip the last record delimiter
+	return plaintext[:len(plaintext)-1], nil
+}
+
+func TestNewWebPushPusher(t *testing.T) {
+	Convey("NewWebPushPusher", t, func() {
+		Convey("creates pusher from VAPID keys", func() {
+			pusher, err := NewWebPushPusher(nil, "mailto:admin@example.com", testVAPIDPublicKey, testVAPIDPrivateKey)
+			So(err, ShouldBeNil)
+			So(pusher.publicKey, ShouldEqual, testVAPIDPublicKey)
+		})
+
+		Convey("rejects mismatched VAPID keys", func() {
+			ua := newTestUserAgent()
+			publicKey := base64.RawURLEncoding.EncodeToString(ua.public)
+			_, err := NewWebPushPusher(nil, "mailto:admin@example.com", publicKey, testVAPIDPrivateKey)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects malformed private key", func() {
+			_, err := NewWebPushPusher(nil, "mailto:admin@example.com", testVAPIDPublicKey, "malformed")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestWebPushEncryption(t *testing.T) {
+	Convey("encryptWebPushPayload", t, func() {
+		ua := newTestUserAgent()
+		keys := ua.keys()
+
+		Convey("encrypts payload decryptable by the user agent", func() {
+			body, err := encryptWebPushPayload([]byte(`{"title":"Hello"}`), keys["p256dh"], keys["auth"])
+			So(err, ShouldBeNil)
+
+			plaintext, err := ua.decrypt(body)
+			So(err, ShouldBeNil)
+			So(plaintext, ShouldEqualJSON, `{"title":"Hello"}`)
+		})
+
+		Convey("rejects payload larger than a record", func() {
+			_, err := encryptWebPushPayload(make([]byte, webPushRecordSize), keys["p256dh"], keys["auth"])
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects malformed keys", func() {
+			_, err := encryptWebPushPayload([]byte(`{}`), "malformed", keys["auth"])
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestWebPushPusherSend(t *testing.T) {
+	Convey("WebPushPusher", t, func() {
+		conn := &mockConn{}
+		pusher, err := NewWebPushPusher(conn.Open, "mailto:admin@example.com", testVAPIDPublicKey, testVAPIDPrivateKey)
+		So(err, ShouldBeNil)
+
+		ua := newTestUserAgent()
+		var (
+			status  int
+			request *http.Request
+			body    []byte
+		)
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request = r
+			body, _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+
+		// the test server listens on loopback, which the client of the
+		// pusher refuses to connect to
+		pusher.client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+
+		device := skydb.Device{
+			ID:    "deviceid",
+			Type:  "web",
+			Token: server.URL + "/push/subscription",
+			Keys:  ua.keys(),
+		}
+		notification := MapMapper{
+			"web": map[string]interface{}{
+				"title": "Hello",
+			},
+		}
+
+		Convey("pushes notification", func() {
+			status = http.StatusCreated
+			So(pusher.Send(notification, device), ShouldBeNil)
+
+			So(request.URL.Path, ShouldEqual, "/push/subscription")
+			So(request.Header.Get("Content-Encoding"), ShouldEqual, "aes128gcm")
+			So(request.Header.Get("TTL"), ShouldNotBeEmpty)
+
+			plaintext, err := ua.decrypt(body)
+			So(err, ShouldBeNil)
+			So(plaintext, ShouldEqualJSON, `{"title": "Hello"}`)
+
+			authorization := request.Header.Get("Authorization")
+			So(authorization, ShouldStartWith, "vapid t=")
+			So(authorization, ShouldEndWith, ", k="+testVAPIDPublicKey)
+
+			signedToken := strings.TrimSuffix(strings.TrimPrefix(authorization, "vapid t="), ", k="+testVAPIDPublicKey)
+			claims := jwt.StandardClaims{}
+			_, err = jwt.ParseWithClaims(signedToken, &claims, func(token *jwt.Token) (interface{}, error) {
+				return &pusher.privateKey.PublicKey, nil
+			})
+			So(err, ShouldBeNil)
+			So(claims.Audience, ShouldEqual, server.URL)
+			So(claims.Subject, ShouldEqual, "mailto:admin@example.com")
+			So(conn.calls, ShouldBeEmpty)
+		})
+
+		Convey("unregisters device when subscription is gone", func() {
+			status = http.StatusGone
+			So(pusher.Send(notification, device), ShouldEqual, ErrInvalidDeviceToken)
+
+			So(len(conn.calls), ShouldEqual, 1)
+			So(conn.calls[0].token, ShouldEqual, device.Token)
+		})
+
+		Convey("unregisters device when subscription is not found", func() {
+			status = http.StatusNotFound
+			So(pusher.Send(notification, device), ShouldEqual, ErrInvalidDeviceToken)
+
+			So(len(conn.calls), ShouldEqual, 1)
+		})
+
+		Convey("keeps device on other errors", func() {
+			status = http.StatusTooManyRequests
+			So(pusher.Send(notification, device), ShouldNotBeNil)
+			So(conn.calls, ShouldBeEmpty)
+		})
+
+		Convey("pushes notification with collapse key", func() {
+			status = http.StatusCreated
+			So(pusher.Send(WithCollapseKey(notification, "score"), device), ShouldBeNil)
+			So(request.Header.Get("Topic"), ShouldEqual, "score")
+		})
+
+		Convey("fails with endpoint not https", func() {
+			device.Token = "http" + strings.TrimPrefix(server.URL, "https") + "/push/subscription"
+			So(pusher.Send(notification, device), ShouldNotBeNil)
+			So(request, ShouldBeNil)
+		})
+
+		Convey("fails without web dictionary", func() {
+			err := pusher.Send(MapMapper{
+				"apns": map[string]interface{}{},
+			}, device)
+			So(err, ShouldNotBeNil)
+			So(request, ShouldBeNil)
+		})
+	})
+}
+
+func TestWebPushTopic(t *testing.T) {
+	Convey("webPushTopic", t, func() {
+		Convey("keeps valid topic", func() {
+			So(webPushTopic("score_1-2"), ShouldEqual, "score_1-2")
+		})
+
+		Convey("replaces invalid topic with digest", func() {
+			topic := webPushTopic("score 1:2")
+			So(topic, ShouldHaveLength, 32)
+			So(webPushTopicRegexp.MatchString(topic), ShouldBeTrue)
+			So(webPushTopic("score 1:2"), ShouldEqual, topic)
+		})
+
+		Convey("replaces long topic with digest", func() {
+			topic := webPushTopic(strings.Repeat("a", 33))
+			So(topic, ShouldHaveLength, 32)
+			So(topic, ShouldNotEqual, strings.Repeat("a", 32))
+		})
+	})
+}
@@ -29,6 +29,10 @@ type responseLogger struct {
 	status int
 	size   int
 	b      bytes.Buffer
+
+	// skipBody is true if the response body is not logged, in which case
+	// it is not kept.
+	skipBody bool
 }
 
 func (l *responseLogger) Header() http.Header {
@@ -40,7 +44,9 @@ func (l *responseLogger) Write(b []byte) (int, error) {
 		// The status will be StatusOK if WriteHeader has not been called yet
 		l.status = http.StatusOK
 	}
-	l.b.Write(b)
+	if !l.skipBody {
+		l.b.Write(b)
+	}
 	size, err := l.w.Write(b)
 	l.size += size
 	return size, err
@@ -68,6 +74,12 @@ func (l *responseLogger) Hijack() (c net.Conn, w *bufio.ReadWriter, e error) {
 	return hijacker.Hijack()
 }
 
+func (l *responseLogger) Flush() {
+	if flusher, ok := l.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 type LoggingMiddleware struct {
 	Skips       []string
 	MimeConcern []string
@@ -103,7 +115,7 @@ func (l *LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Debugf("%d bytes of request body", len(body))
 	}
 
-	rlogger := &responseLogger{w: w}
+	rlogger := &responseLogger{w: w, skipBody: skipBody}
 	l.Next.ServeHTTP(rlogger, r)
 
 	log.Debugln("------ Response: ------")
@@ -81,6 +81,12 @@ type Configuration struct {
 		ImplName string `json:"implementation"`
 		Public   bool   `json:"public"`
 
+		// PublicVariantSizes are the sizes of image variants, in the
+		// format WIDTHxHEIGHT, which can be requested from a public
+		// asset store. Variants are not available from a public asset
+		// store if it is empty.
+		PublicVariantSizes []string `json:"public_variant_sizes"`
+
 		FileSystemStore struct {
 			Path      string `json:"-"`
 			URLPrefix string `json:"url_prefix"`
@@ -101,6 +107,13 @@ type Configuration struct {
 			PublicPrefix  string `json:"public_prefix"`
 			PrivatePrefix string `json:"private_prefix"`
 		} `json:"cloud"`
+
+		// GC deletes assets not referenced by any record which are
+		// older than GracePeriod in seconds.
+		GC struct {
+			Enable      bool  `json:"enable"`
+			GracePeriod int64 `json:"grace_period"`
+		} `json:"gc"`
 	} `json:"asset_store"`
 	APNS struct {
 		Enable bool   `json:"enable"`
@@ -125,6 +138,31 @@ type Configuration struct {
 		Enable bool   `json:"enable"`
 		APIKey string `json:"api_key"`
 	} `json:"gcm"`
+	FCM struct {
+		Enable             bool   `json:"enable"`
+		ServiceAccount     string `json:"service_account"`
+		ServiceAccountPath string `json:"-"`
+		TokenURL           string `json:"token_url"`
+		BaseURL            string `json:"base_url"`
+	} `json:"fcm"`
+	WebPush struct {
+		Enable     bool   `json:"enable"`
+		Subject    string `json:"subject"`
+		PublicKey  string `json:"public_key"`
+		PrivateKey string `json:"private_key"`
+	} `json:"web_push"`
+	PushQueue struct {
+		Workers     int `json:"workers"`
+		MaxAttempts int `json:"max_attempts"`
+	} `json:"push_queue"`
+	PubSub struct {
+		Broker   string `json:"broker"`
+		RedisURL string `json:"redis_url"`
+		Prefix   string `json:"prefix"`
+
+		HistorySize      int   `json:"history_size"`
+		HistoryRetention int64 `json:"history_retention"`
+	} `json:"pubsub"`
 	LOG struct {
 		Level           string            `json:"-"`
 		LoggersLevel    map[string]string `json:"-"`
@@ -157,10 +195,16 @@ func NewConfiguration() Configuration {
 	config.AssetStore.ImplName = "fs"
 	config.AssetStore.FileSystemStore.Path = "data/asset"
 	config.AssetStore.FileSystemStore.URLPrefix = "http://localhost:3000/files"
+	config.AssetStore.GC.GracePeriod = 86400
 	config.APNS.Enable = false
 	config.APNS.Type = "cert"
 	config.APNS.Env = "sandbox"
 	config.GCM.Enable = false
+	config.FCM.Enable = false
+	config.WebPush.Enable = false
+	config.PushQueue.Workers = 4
+	config.PushQueue.MaxAttempts = 5
+	config.PubSub.Broker = "local"
 	config.LOG.Level = "debug"
 	config.LOG.LoggersLevel = map[string]string{
 		"plugin": "info",
@@ -198,6 +242,17 @@ func (config *Configuration) Validate() error {
 	if config.APNS.Enable && !regexp.MustCompile("^(cert|token)$").MatchString(config.APNS.Type) {
 		return fmt.Errorf("APNS_TYPE must be cert or token")
 	}
+	if config.FCM.Enable && config.FCM.ServiceAccount == "" && config.FCM.ServiceAccountPath == "" {
+		return errors.New("FCM_SERVICE_ACCOUNT or FCM_SERVICE_ACCOUNT_PATH must be set")
+	}
+	if config.WebPush.Enable && (config.WebPush.PublicKey == "" || config.WebPush.PrivateKey == "") {
+		return errors.New("WEB_PUSH_VAPID_PUBLIC_KEY and WEB_PUSH_VAPID_PRIVATE_KEY must be set")
+	}
+	for _, size := range config.AssetStore.PublicVariantSizes {
+		if !regexp.MustCompile("^([0-9]+x[0-9]*|x[0-9]+)$").MatchString(size) {
+			return fmt.Errorf("ASSET_STORE_PUBLIC_VARIANT_SIZES contains invalid size '%s'", size)
+		}
+	}
 	return nil
 }
 
@@ -259,6 +314,10 @@ func (config *Configuration) ReadFromEnv() {
 	config.readAssetStore()
 	config.readAPNS()
 	config.readGCM()
+	config.readFCM()
+	config.readWebPush()
+	config.readPushQueue()
+	config.readPubSub()
 	config.readLog()
 	config.readPlugins()
 }
@@ -313,6 +372,9 @@ func (config *Configuration) readAssetStore() {
 	if assetStorePublic, err := parseBool(os.Getenv("ASSET_STORE_PUBLIC")); err == nil {
 		config.AssetStore.Public = assetStorePublic
 	}
+	if variantSizes := os.Getenv("ASSET_STORE_PUBLIC_VARIANT_SIZES"); variantSizes != "" {
+		config.AssetStore.PublicVariantSizes = strings.Split(variantSizes, ",")
+	}
 
 	// Local Storage related
 	assetStorePath := os.Getenv("ASSET_STORE_PATH")
@@ -367,6 +429,13 @@ func (config *Configuration) readAssetStore() {
 	if cloudAssetPrivatePrefix != "" {
 		config.AssetStore.CloudStore.PrivatePrefix = cloudAssetPrivatePrefix
 	}
+
+	if gcEnable, err := parseBool(os.Getenv("ASSET_STORE_GC_ENABLE")); err == nil {
+		config.AssetStore.GC.Enable = gcEnable
+	}
+	if gracePeriod, err := strconv.ParseInt(os.Getenv("ASSET_STORE_GC_GRACE_PERIOD"), 10, 64); err == nil {
+		config.AssetStore.GC.GracePeriod = gracePeriod
+	}
 }
 
 func (config *Configuration) readAPNS() {
@@ -447,6 +516,88 @@ func (config *Configuration) readGCM() {
 	}
 }
 
+func (config *Configuration) readFCM() {
+	if shouldEnableFCM, err := parseBool(os.Getenv("FCM_ENABLE")); err == nil {
+		config.FCM.Enable = shouldEnableFCM
+	}
+
+	serviceAccount := os.Getenv("FCM_SERVICE_ACCOUNT")
+	if serviceAccount != "" {
+		config.FCM.ServiceAccount = serviceAccount
+	}
+
+	serviceAccountPath := os.Getenv("FCM_SERVICE_ACCOUNT_PATH")
+	if serviceAccountPath != "" {
+		config.FCM.ServiceAccountPath = serviceAccountPath
+	}
+
+	tokenURL := os.Getenv("FCM_TOKEN_URL")
+	if tokenURL != "" {
+		config.FCM.TokenURL = tokenURL
+	}
+
+	baseURL := os.Getenv("FCM_BASE_URL")
+	if baseURL != "" {
+		config.FCM.BaseURL = baseURL
+	}
+}
+
+func (config *Configuration) readWebPush() {
+	if shouldEnableWebPush, err := parseBool(os.Getenv("WEB_PUSH_ENABLE")); err == nil {
+		config.WebPush.Enable = shouldEnableWebPush
+	}
+
+	subject := os.Getenv("WEB_PUSH_SUBJECT")
+	if subject != "" {
+		config.WebPush.Subject = subject
+	}
+
+	publicKey := os.Getenv("WEB_PUSH_VAPID_PUBLIC_KEY")
+	if publicKey != "" {
+		config.WebPush.PublicKey = publicKey
+	}
+
+	privateKey := os.Getenv("WEB_PUSH_VAPID_PRIVATE_KEY")
+	if privateKey != "" {
+		config.WebPush.PrivateKey = privateKey
+	}
+}
+
+func (config *Configuration) readPushQueue() {
+	if workers, err := strconv.Atoi(os.Getenv("PUSH_QUEUE_WORKERS")); err == nil {
+		config.PushQueue.Workers = workers
+	}
+
+	if maxAttempts, err := strconv.Atoi(os.Getenv("PUSH_QUEUE_MAX_ATTEMPTS")); err == nil {
+		config.PushQueue.MaxAttempts = maxAttempts
+	}
+}
+
+func (config *Configuration) readPubSub() {
+	broker := os.Getenv("PUBSUB_BROKER")
+	if broker != "" {
+		config.PubSub.Broker = broker
+	}
+
+	redisURL := os.Getenv("PUBSUB_REDIS_URL")
+	if redisURL != "" {
+		config.PubSub.RedisURL = redisURL
+	}
+
+	prefix := os.Getenv("PUBSUB_PREFIX")
+	if prefix != "" {
+		config.PubSub.Prefix = prefix
+	}
+
+	if size, err := strconv.Atoi(os.Getenv("PUBSUB_HISTORY_SIZE")); err == nil {
+		config.PubSub.HistorySize = size
+	}
+
+	if retention, err := strconv.ParseInt(os.Getenv("PUBSUB_HISTORY_RETENTION"), 10, 64); err == nil {
+		config.PubSub.HistoryRetention = retention
+	}
+}
+
 func (config *Configuration) readLog() {
 	logLevel := os.Getenv("LOG_LEVEL")
 	if logLevel != "" {
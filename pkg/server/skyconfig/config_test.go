@@ -86,6 +86,114 @@ func TestRequiredConfig(t *testing.T) {
 			os.Setenv("APNS_ENABLE", "")
 		})
 
+		Convey("Validate the FCM service account", func() {
+			config := NewConfigurationWithKeys()
+			os.Setenv("FCM_ENABLE", "YES")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldNotBeNil)
+
+			os.Setenv("FCM_SERVICE_ACCOUNT_PATH", "/etc/fcm/service-account.json")
+			os.Setenv("FCM_BASE_URL", "http://localhost:8080")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldBeNil)
+			So(config.FCM.ServiceAccountPath, ShouldEqual, "/etc/fcm/service-account.json")
+			So(config.FCM.BaseURL, ShouldEqual, "http://localhost:8080")
+
+			os.Setenv("FCM_ENABLE", "")
+			os.Setenv("FCM_SERVICE_ACCOUNT_PATH", "")
+			os.Setenv("FCM_BASE_URL", "")
+		})
+
+		Convey("Validate the web push VAPID keys", func() {
+			config := NewConfigurationWithKeys()
+			os.Setenv("WEB_PUSH_ENABLE", "YES")
+			os.Setenv("WEB_PUSH_SUBJECT", "mailto:admin@example.com")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldNotBeNil)
+
+			os.Setenv("WEB_PUSH_VAPID_PUBLIC_KEY", "public-key")
+			os.Setenv("WEB_PUSH_VAPID_PRIVATE_KEY", "private-key")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldBeNil)
+			So(config.WebPush.Subject, ShouldEqual, "mailto:admin@example.com")
+			So(config.WebPush.PublicKey, ShouldEqual, "public-key")
+			So(config.WebPush.PrivateKey, ShouldEqual, "private-key")
+
+			os.Setenv("WEB_PUSH_ENABLE", "")
+			os.Setenv("WEB_PUSH_SUBJECT", "")
+			os.Setenv("WEB_PUSH_VAPID_PUBLIC_KEY", "")
+			os.Setenv("WEB_PUSH_VAPID_PRIVATE_KEY", "")
+		})
+
+		Convey("Read asset store gc config correctly", func() {
+			config := NewConfigurationWithKeys()
+			So(config.AssetStore.GC.Enable, ShouldBeFalse)
+			So(config.AssetStore.GC.GracePeriod, ShouldEqual, 86400)
+
+			os.Setenv("ASSET_STORE_GC_ENABLE", "YES")
+			os.Setenv("ASSET_STORE_GC_GRACE_PERIOD", "3600")
+			config.readAssetStore()
+			So(config.AssetStore.GC.Enable, ShouldBeTrue)
+			So(config.AssetStore.GC.GracePeriod, ShouldEqual, 3600)
+
+			os.Setenv("ASSET_STORE_GC_ENABLE", "")
+			os.Setenv("ASSET_STORE_GC_GRACE_PERIOD", "")
+		})
+
+		Convey("Read asset store public variant sizes correctly", func() {
+			config := NewConfigurationWithKeys()
+			So(config.AssetStore.PublicVariantSizes, ShouldBeEmpty)
+
+			os.Setenv("ASSET_STORE_PUBLIC_VARIANT_SIZES", "64x64,256x")
+			config.readAssetStore()
+			So(config.Validate(), ShouldBeNil)
+			So(config.AssetStore.PublicVariantSizes, ShouldResemble, []string{"64x64", "256x"})
+
+			os.Setenv("ASSET_STORE_PUBLIC_VARIANT_SIZES", "64x64,large")
+			config.readAssetStore()
+			So(config.Validate(), ShouldNotBeNil)
+
+			os.Setenv("ASSET_STORE_PUBLIC_VARIANT_SIZES", "")
+		})
+
+		Convey("Read push queue config correctly", func() {
+			config := NewConfigurationWithKeys()
+			So(config.PushQueue.Workers, ShouldEqual, 4)
+			So(config.PushQueue.MaxAttempts, ShouldEqual, 5)
+
+			os.Setenv("PUSH_QUEUE_WORKERS", "8")
+			os.Setenv("PUSH_QUEUE_MAX_ATTEMPTS", "10")
+			config.readPushQueue()
+			So(config.PushQueue.Workers, ShouldEqual, 8)
+			So(config.PushQueue.MaxAttempts, ShouldEqual, 10)
+
+			os.Setenv("PUSH_QUEUE_WORKERS", "")
+			os.Setenv("PUSH_QUEUE_MAX_ATTEMPTS", "")
+		})
+
+		Convey("Read pubsub config correctly", func() {
+			config := NewConfigurationWithKeys()
+			So(config.PubSub.Broker, ShouldEqual, "local")
+
+			os.Setenv("PUBSUB_BROKER", "redis")
+			os.Setenv("PUBSUB_REDIS_URL", "redis://redis:6379")
+			os.Setenv("PUBSUB_PREFIX", "PREFIX")
+			os.Setenv("PUBSUB_HISTORY_SIZE", "100")
+			os.Setenv("PUBSUB_HISTORY_RETENTION", "3600")
+			config.readPubSub()
+			So(config.PubSub.Broker, ShouldEqual, "redis")
+			So(config.PubSub.RedisURL, ShouldEqual, "redis://redis:6379")
+			So(config.PubSub.Prefix, ShouldEqual, "PREFIX")
+			So(config.PubSub.HistorySize, ShouldEqual, 100)
+			So(config.PubSub.HistoryRetention, ShouldEqual, 3600)
+
+			os.Setenv("PUBSUB_BROKER", "")
+			os.Setenv("PUBSUB_REDIS_URL", "")
+			os.Setenv("PUBSUB_PREFIX", "")
+			os.Setenv("PUBSUB_HISTORY_SIZE", "")
+			os.Setenv("PUBSUB_HISTORY_RETENTION", "")
+		})
+
 		Convey("Read token store config correctly", func() {
 			config := NewConfigurationWithKeys()
 			os.Setenv("TOKEN_STORE", "redis")
@@ -0,0 +1,115 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"errors"
+)
+
+// DefaultACLParentMaxDepth is the maximum number of ACL parents
+// followed when RecordACLParent does not specify MaxDepth.
+const DefaultACLParentMaxDepth = 5
+
+// ErrACLParentCycle is returned by ResolveACLParent when a record is
+// found more than once in the chain of ACL parents.
+var ErrACLParentCycle = errors.New("skydb: cycle detected in ACL parents")
+
+// ErrACLParentTooDeep is returned by ResolveACLParent when the chain of ACL
+// parents is longer than the maximum depth.
+var ErrACLParentTooDeep = errors.New("skydb: ACL parents exceed maximum depth")
+
+// RecordACLParent marks a reference field of a record type as the ACL
+// parent of the record type.
+//
+// A record without ACL of its own inherits the access of the record
+// referenced by the field, which may in turn inherit from its ACL parent.
+// MaxDepth limits the number of ACL parents followed from a record.
+type RecordACLParent struct {
+	Field    string `json:"field"`
+	MaxDepth int    `json:"max_depth,omitempty"`
+}
+
+// IsEmpty returns true if no ACL parent is specified.
+func (p RecordACLParent) IsEmpty() bool {
+	return p.Field == ""
+}
+
+// Depth returns the maximum number of ACL parents to be followed.
+func (p RecordACLParent) Depth() int {
+	if p.MaxDepth <= 0 {
+		return DefaultACLParentMaxDepth
+	}
+	return p.MaxDepth
+}
+
+// ACLParentGetter returns the RecordACLParent of a record type.
+type ACLParentGetter func(recordType string) (RecordACLParent, error)
+
+// ResolveACLParent fetches the chain of ACL parents of the record from db,
+// assigning each parent to the ACLParent field of its child. The chain
+// ends at a record that has its own ACL, or one not referencing
+// an ACL parent.
+//
+// ResolveACLParent returns ErrACLParentCycle or ErrACLParentTooDeep if the
+// chain cannot be resolved, and ErrRecordNotFound if an ACL parent
+// does not exist. The record should be regarded as inaccessible in
+// such cases.
+func ResolveACLParent(db Database, record *Record, getACLParent ACLParentGetter) error {
+	visited := map[RecordID]bool{
+		record.ID: true,
+	}
+	maxDepth := 0
+
+	for current := record; current.ACL == nil; current = current.ACLParent {
+		aclParent, err := getACLParent(current.ID.Type)
+		if err != nil {
+			return err
+		}
+		if aclParent.IsEmpty() {
+			return nil
+		}
+		if maxDepth == 0 {
+			maxDepth = aclParent.Depth()
+		}
+
+		var ref Reference
+		switch v := current.Get(aclParent.Field).(type) {
+		case Reference:
+			ref = v
+		case *Reference:
+			if v != nil {
+				ref = *v
+			}
+		}
+		if ref.IsEmpty() {
+			return nil
+		}
+
+		if len(visited) > maxDepth {
+			return ErrACLParentTooDeep
+		}
+		if visited[ref.ID] {
+			return ErrACLParentCycle
+		}
+		visited[ref.ID] = true
+
+		parent := Record{}
+		if err := db.Get(ref.ID, &parent); err != nil {
+			return err
+		}
+		current.ACLParent = &parent
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type aclParentDB struct {
+	Database
+	records map[RecordID]Record
+}
+
+func (db aclParentDB) Get(id RecordID, record *Record) error {
+	r, ok := db.records[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	*record = r
+	return nil
+}
+
+func TestResolveACLParent(t *testing.T) {
+	Convey("ResolveACLParent", t, func() {
+		db := aclParentDB{records: map[RecordID]Record{}}
+		save := func(record Record) {
+			db.records[record.ID] = record
+		}
+		aclParents := map[string]RecordACLParent{
+			"comment": RecordACLParent{Field: "post"},
+			"node":    RecordACLParent{Field: "parent", MaxDepth: 2},
+		}
+		getACLParent := func(recordType string) (RecordACLParent, error) {
+			return aclParents[recordType], nil
+		}
+		userinfo := &UserInfo{ID: "user0"}
+
+		save(Record{
+			ID:      NewRecordID("post", "private"),
+			OwnerID: "user1",
+			ACL: RecordACL{
+				NewRecordACLEntryDirect("user0", ReadLevel),
+			},
+		})
+
+		Convey("inherits access of parent", func() {
+			comment := Record{
+				ID:      NewRecordID("comment", "1"),
+				OwnerID: "user1",
+				Data: Data{
+					"post": NewReference("post", "private"),
+				},
+			}
+			So(ResolveACLParent(db, &comment, getACLParent), ShouldBeNil)
+			So(comment.ACLParent.ID, ShouldResemble, NewRecordID("post", "private"))
+			So(comment.Accessible(userinfo, ReadLevel), ShouldBeTrue)
+			So(comment.Accessible(userinfo, WriteLevel), ShouldBeFalse)
+			So(comment.Accessible(&UserInfo{ID: "user2"}, ReadLevel), ShouldBeFalse)
+			So(comment.Accessible(&UserInfo{ID: "user1"}, WriteLevel), ShouldBeTrue)
+		})
+
+		Convey("ignores parent of record with ACL", func() {
+			comment := Record{
+				ID:  NewRecordID("comment", "1"),
+				ACL: RecordACL{NewRecordACLEntryPublic(ReadLevel)},
+				Data: Data{
+					"post": NewReference("post", "private"),
+				},
+			}
+			So(ResolveACLParent(db, &comment, getACLParent), ShouldBeNil)
+			So(comment.ACLParent, ShouldBeNil)
+			So(comment.Accessible(&UserInfo{ID: "user2"}, ReadLevel), ShouldBeTrue)
+		})
+
+		Convey("ignores empty reference", func() {
+			comment := Record{
+				ID:   NewRecordID("comment", "1"),
+				Data: Data{},
+			}
+			So(ResolveACLParent(db, &comment, getACLParent), ShouldBeNil)
+			So(comment.ACLParent, ShouldBeNil)
+		})
+
+		Convey("returns error for non-existent parent", func() {
+			comment := Record{
+				ID: NewRecordID("comment", "1"),
+				Data: Data{
+					"post": NewReference("post", "missing"),
+				},
+			}
+			So(ResolveACLParent(db, &comment, getACLParent), ShouldEqual, ErrRecordNotFound)
+		})
+
+		Convey("detects cycle", func() {
+			save(Record{
+				ID:   NewRecordID("node", "a"),
+				Data: Data{"parent": NewReference("node", "b")},
+			})
+			save(Record{
+				ID:   NewRecordID("node", "b"),
+				Data: Data{"parent": NewReference("node", "a")},
+			})
+			node := db.records[NewRecordID("node", "a")]
+			So(ResolveACLParent(db, &node, getACLParent), ShouldEqual, ErrACLParentCycle)
+		})
+
+		Convey("limits depth", func() {
+			save(Record{
+				ID:   NewRecordID("node", "a"),
+				Data: Data{"parent": NewReference("node", "b")},
+			})
+			save(Record{
+				ID:   NewRecordID("node", "b"),
+				Data: Data{"parent": NewReference("node", "c")},
+			})
+			save(Record{
+				ID:   NewRecordID("node", "c"),
+				Data: Data{"parent": NewReference("node", "d")},
+			})
+			save(Record{
+				ID:  NewRecordID("node", "d"),
+				ACL: RecordACL{NewRecordACLEntryPublic(ReadLevel)},
+			})
+
+			node := db.records[NewRecordID("node", "b")]
+			So(ResolveACLParent(db, &node, getACLParent), ShouldBeNil)
+			So(node.ACLParent.ACLParent.ID, ShouldResemble, NewRecordID("node", "d"))
+			So(node.Accessible(userinfo, ReadLevel), ShouldBeTrue)
+
+			node = db.records[NewRecordID("node", "a")]
+			So(ResolveACLParent(db, &node, getACLParent), ShouldEqual, ErrACLParentTooDeep)
+		})
+	})
+}
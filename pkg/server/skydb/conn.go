@@ -30,11 +30,51 @@ var ErrUserNotFound = errors.New("skydb: UserInfo ID not found")
 
 var ErrRoleUpdatesFailed = errors.New("skydb: Update of user roles failed")
 
+// ErrRoleDuplicated is returned by Conn.CreateRole when the Role to be
+// created has the same name as an existing role
+var ErrRoleDuplicated = errors.New("skydb: duplicated role")
+
+// ErrRoleNotFound is returned by Conn.CreateRole, Conn.DeleteRole,
+// Conn.AssignUserRoles and Conn.RevokeUserRoles when a specified role
+// is not found in the current container
+var ErrRoleNotFound = errors.New("skydb: role not found")
+
 // ErrDeviceNotFound is returned by Conn.GetDevice, Conn.DeleteDevice,
 // Conn.DeleteDevicesByToken and Conn.DeleteEmptyDevicesByTime, if the desired Device
 // cannot be found in the current container
 var ErrDeviceNotFound = errors.New("skydb: Specific device not found")
 
+// ErrPushDeliveryNotFound is returned by Conn.UpdatePushDelivery if the
+// desired PushDelivery cannot be found in the current container
+var ErrPushDeliveryNotFound = errors.New("skydb: push delivery not found")
+
+// ErrPushScheduleNotFound is returned by Conn.UpdatePushSchedule if the
+// desired PushSchedule cannot be found in the current container
+var ErrPushScheduleNotFound = errors.New("skydb: push schedule not found")
+
+// ErrPushTemplateNotFound is returned by Conn.GetPushTemplate and
+// Conn.DeletePushTemplate if the desired PushTemplate cannot be found in
+// the current container
+var ErrPushTemplateNotFound = errors.New("skydb: push template not found")
+
+// ErrChannelRuleNotFound is returned by Conn.DeleteChannelRule if the
+// desired ChannelRule cannot be found in the current container
+var ErrChannelRuleNotFound = errors.New("skydb: channel rule not found")
+
+// ErrAssetNotFound is returned by Conn.DeleteAsset if the desired Asset
+// cannot be found in the current container
+var ErrAssetNotFound = errors.New("skydb: asset not found")
+
+// ErrUploadSessionNotFound is returned by Conn.GetUploadSession and
+// Conn.DeleteUploadSession if the desired UploadSession cannot be found
+// in the current container
+var ErrUploadSessionNotFound = errors.New("skydb: upload session not found")
+
+// ErrUploadSessionConflict is returned by Conn.ClaimUploadSession and
+// Conn.UpdateUploadSession if the upload session is modified or claimed
+// since it is fetched
+var ErrUploadSessionConflict = errors.New("skydb: upload session is modified concurrently")
+
 // ErrDatabaseIsReadOnly is returned by skydb.Database if the requested
 // operation modifies the database and the database is readonly.
 var ErrDatabaseIsReadOnly = errors.New("skydb: database is read only")
@@ -98,6 +138,19 @@ type Conn interface {
 	// exist in the container.
 	DeleteUser(id string) error
 
+	// MergeUser reassigns the records, record changes, devices, relations,
+	// subscriptions, roles, upload sessions and scheduled pushes of the
+	// user with ID fromID to the user with ID toID, and then removes the
+	// user with ID fromID. The user record of fromID is discarded.
+	//
+	// MergeUser should be called within a transaction so that a
+	// failure leaves both users intact.
+	//
+	// MergeUser returns ErrUserNotFound if either user does not exist,
+	// and a ConstraintViolated error if private records of both users
+	// have the same ID.
+	MergeUser(fromID string, toID string) error
+
 	// GetAdminRoles return the current admine roles
 	GetAdminRoles() ([]string, error)
 
@@ -111,12 +164,60 @@ type Conn interface {
 	// to newly created user CreateUser
 	SetDefaultRoles(roles []string) error
 
+	// CreateRole creates a new Role in the container.
+	//
+	// CreateRole returns ErrRoleDuplicated if a role of the same name
+	// exists, and ErrRoleNotFound if the parent of the role does not exist.
+	CreateRole(role *Role) error
+
+	// GetRoles returns all roles in the container.
+	GetRoles() ([]Role, error)
+
+	// DeleteRole removes the role with the supplied name. Users are no
+	// longer assigned to the role, and children of the role lose their
+	// parent.
+	//
+	// DeleteRole returns ErrRoleNotFound if such role does not exist.
+	DeleteRole(name string) error
+
+	// AssignUserRoles assigns the supplied roles to the supplied users.
+	//
+	// AssignUserRoles returns ErrRoleNotFound if one of the roles does not
+	// exist, and ErrUserNotFound if one of the users does not exist.
+	AssignUserRoles(userIDs []string, roles []string) error
+
+	// RevokeUserRoles revokes the supplied roles from the supplied users.
+	//
+	// RevokeUserRoles returns ErrRoleNotFound if one of the roles does not
+	// exist.
+	RevokeUserRoles(userIDs []string, roles []string) error
+
+	// GetRolePermissions returns the permissions granted by the supplied
+	// roles. Inherited roles are expected to be included in roles.
+	GetRolePermissions(roles []string) ([]string, error)
+
 	// SetRecordAccess sets default record access of a specific type
 	SetRecordAccess(recordType string, acl RecordACL) error
 
 	// GetRecordAccess returns default record access of a specific type
 	GetRecordAccess(recordType string) (RecordACL, error)
 
+	// SetRecordPolicy sets the record policy of a specific type. Setting an
+	// empty predicate removes the record policy.
+	SetRecordPolicy(recordType string, policy Predicate) error
+
+	// GetRecordPolicy returns the record policy of a specific type, an empty
+	// predicate is returned if the type has no record policy.
+	GetRecordPolicy(recordType string) (Predicate, error)
+
+	// SetRecordACLParent sets the ACL parent of a specific type. Setting an
+	// empty RecordACLParent removes the ACL parent.
+	SetRecordACLParent(recordType string, aclParent RecordACLParent) error
+
+	// GetRecordACLParent returns the ACL parent of a specific type, an empty
+	// RecordACLParent is returned if the type has no ACL parent.
+	GetRecordACLParent(recordType string) (RecordACLParent, error)
+
 	// GetAsset retrieves Asset information by its name
 	GetAsset(name string, asset *Asset) error
 
@@ -126,6 +227,54 @@ type Conn interface {
 	// be referenced by records.
 	SaveAsset(asset *Asset) error
 
+	// GetUnreferencedAssets returns up to limit assets saved before
+	// createdBefore which are not referenced by any asset or JSON field of
+	// the record schemas, ordered by name. Only assets which name is greater
+	// than afterName are returned, so that the name of the last returned
+	// asset is passed as afterName to get the next page.
+	GetUnreferencedAssets(schemas map[string]RecordSchema, createdBefore time.Time, afterName string, limit int) ([]Asset, error)
+
+	// DeleteAsset deletes the Asset information with the specified name.
+	// Assets referenced by records cannot be deleted.
+	//
+	// If such asset does not exist, ErrAssetNotFound is returned.
+	DeleteAsset(name string) error
+
+	// SaveUploadSession creates or updates an upload session.
+	SaveUploadSession(session *UploadSession) error
+
+	// ClaimUploadSession locks an upload session for uploading a chunk at
+	// its Offset, so that no other chunk is uploaded at the same offset
+	// until the session is updated or lease has passed.
+	//
+	// If the uploaded size of the saved session is not Offset, or the
+	// session is already claimed, ErrUploadSessionConflict is returned.
+	ClaimUploadSession(session *UploadSession, now time.Time, lease time.Duration) error
+
+	// UpdateUploadSession saves the progress of an upload session, which
+	// are Offset, Chunks and UpdatedAt, if the uploaded size of the saved
+	// session is still offset. The claim of the session is released.
+	//
+	// If the session is modified or deleted in the meantime,
+	// ErrUploadSessionConflict is returned.
+	UpdateUploadSession(session *UploadSession, offset int64) error
+
+	// GetUploadSession fetches the upload session with the specified ID.
+	//
+	// If such session does not exist, ErrUploadSessionNotFound is returned.
+	GetUploadSession(id string, session *UploadSession) error
+
+	// GetExpiredUploadSessions returns up to limit upload sessions last
+	// updated before updatedBefore, ordered by ID. Only sessions which ID
+	// is greater than afterID are returned, so that the ID of the last
+	// returned session is passed as afterID to get the next page.
+	GetExpiredUploadSessions(updatedBefore time.Time, afterID string, limit int) ([]UploadSession, error)
+
+	// DeleteUploadSession deletes the upload session with the specified ID.
+	//
+	// If such session does not exist, ErrUploadSessionNotFound is returned.
+	DeleteUploadSession(id string) error
+
 	QueryRelation(user string, name string, direction string, config QueryConfig) []UserInfo
 	QueryRelationCount(user string, name string, direction string) (uint64, error)
 	AddRelation(user string, name string, targetUser string) error
@@ -152,6 +301,110 @@ type Conn interface {
 	// If such device does not exist, ErrDeviceNotFound is returned.
 	DeleteEmptyDevicesByTime(t time.Time) error
 
+	// SubscribeDeviceTopics subscribes a device to push topics. Subscribing
+	// to a topic already subscribed is not an error.
+	//
+	// If such device does not exist, ErrDeviceNotFound is returned.
+	SubscribeDeviceTopics(deviceID string, topics []string) error
+
+	// UnsubscribeDeviceTopics unsubscribes a device from push topics.
+	UnsubscribeDeviceTopics(deviceID string, topics []string) error
+
+	// GetDeviceTopics returns the push topics subscribed by a device,
+	// sorted by name.
+	GetDeviceTopics(deviceID string) ([]string, error)
+
+	// QueryDevicesByTopic returns up to limit devices subscribed to a push
+	// topic which ID is greater than afterID, ordered by ID. Pass the ID
+	// of the last returned device as afterID to get the next page.
+	QueryDevicesByTopic(topic string, afterID string, limit int) ([]Device, error)
+
+	// EnqueuePushDeliveries saves deliveries into the push queue.
+	//
+	// Queued deliveries to the same device with the same collapse key as
+	// one of deliveries are marked as PushDeliveryCollapsed.
+	EnqueuePushDeliveries(deliveries []PushDelivery) error
+
+	// ClaimPushDeliveries returns up to limit queued deliveries which
+	// NextAttemptAt is not after now. The NextAttemptAt of the returned
+	// deliveries is postponed to now + lease, so that concurrent callers
+	// never claim the same delivery and deliveries claimed by a crashed
+	// worker are retried after the lease.
+	ClaimPushDeliveries(now time.Time, lease time.Duration, limit int) ([]PushDelivery, error)
+
+	// UpdatePushDelivery saves the state of a delivery.
+	//
+	// If such delivery does not exist, ErrPushDeliveryNotFound is returned.
+	UpdatePushDelivery(delivery *PushDelivery) error
+
+	// GetPushDeliveries returns up to limit deliveries of a notification
+	// which ID is greater than afterID, ordered by ID. Pass the ID of the
+	// last returned delivery as afterID to get the next page.
+	GetPushDeliveries(notificationID string, afterID string, limit int) ([]PushDelivery, error)
+
+	// CountPushDeliveries returns the number of deliveries of a
+	// notification in each state.
+	CountPushDeliveries(notificationID string) (map[PushDeliveryState]int, error)
+
+	// SavePushSchedule saves a new schedule.
+	SavePushSchedule(schedule *PushSchedule) error
+
+	// ClaimPushSchedules returns up to limit scheduled schedules which
+	// are due at now. Claimed schedules are locked and not returned
+	// again until lease has passed. The SendAt of claimed schedules is
+	// not changed.
+	ClaimPushSchedules(now time.Time, lease time.Duration, limit int) ([]PushSchedule, error)
+
+	// UpdatePushSchedule saves the state and send time of a schedule.
+	//
+	// If such schedule does not exist, ErrPushScheduleNotFound is returned.
+	UpdatePushSchedule(schedule *PushSchedule) error
+
+	// CancelPushSchedules cancels the scheduled schedules of a
+	// notification and returns the number of schedules cancelled.
+	CancelPushSchedules(notificationID string, now time.Time) (int, error)
+
+	// GetPushSchedules returns all schedules of a notification.
+	GetPushSchedules(notificationID string) ([]PushSchedule, error)
+
+	// SavePushTemplate creates or replaces the template with the same name.
+	SavePushTemplate(template *PushTemplate) error
+
+	// GetPushTemplate fetches the template with the specified name.
+	//
+	// If such template does not exist, ErrPushTemplateNotFound is returned.
+	GetPushTemplate(name string, template *PushTemplate) error
+
+	// GetPushTemplates returns all templates ordered by name.
+	GetPushTemplates() ([]PushTemplate, error)
+
+	// DeletePushTemplate deletes the template with the specified name.
+	//
+	// If such template does not exist, ErrPushTemplateNotFound is returned.
+	DeletePushTemplate(name string) error
+
+	// SaveChannelRule creates or replaces the pubsub channel rule with the
+	// same channel.
+	SaveChannelRule(rule *ChannelRule) error
+
+	// GetChannelRules returns all pubsub channel rules ordered by channel.
+	GetChannelRules() ([]ChannelRule, error)
+
+	// DeleteChannelRule deletes the pubsub channel rule with the specified
+	// channel.
+	//
+	// If such rule does not exist, ErrChannelRuleNotFound is returned.
+	DeleteChannelRule(channel string) error
+
+	// GetLatestRecordChangeCursor returns the cursor of all databases
+	// after which GetRecordChanges returns changes which are not
+	// returned by GetRecordChanges now.
+	GetLatestRecordChangeCursor() (RecordChangeCursor, error)
+
+	// DeleteRecordChanges deletes record changes of all databases made
+	// before the specified time.
+	DeleteRecordChanges(before time.Time) error
+
 	PublicDB() Database
 	PrivateDB(userKey string) Database
 	UnionDB() Database
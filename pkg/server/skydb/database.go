@@ -146,6 +146,25 @@ type Database interface {
 	DeleteSubscription(key string, deviceID string) error
 	GetSubscriptionsByDeviceID(deviceID string) []Subscription
 	GetMatchingSubscriptions(record *Record) []Subscription
+
+	// MatchQuery returns whether the record is of the record type of the
+	// query and satisfies its predicate. Sorts and limits of the query
+	// are not considered.
+	//
+	// An error of skyerr.NotSupported is returned if the predicate cannot
+	// be evaluated against a single record, for example it contains a
+	// functional predicate.
+	MatchQuery(query *Query, record *Record) (bool, error)
+
+	// GetRecordChanges returns changes made to records of the record type
+	// after the cursor since, ordered by cursor. At most limit changes
+	// are returned. Changes of transactions which might not have ended
+	// are not returned yet.
+	//
+	// Changes to the same record are collapsed into the last one. The
+	// collapsed change has Event RecordChangeCreate if the record is
+	// created after since and not deleted afterwards.
+	GetRecordChanges(recordType string, since RecordChangeCursor, limit uint64) ([]RecordChange, error)
 }
 
 // TxDatabase defines the methods for a Database that supports
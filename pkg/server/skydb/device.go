@@ -24,4 +24,12 @@ type Device struct {
 	UserInfoID       string
 	Topic            string
 	LastRegisteredAt time.Time
+
+	// Locale is the preferred language of the device in BCP 47 format,
+	// e.g. "zh-TW". It selects the localization of a push template.
+	Locale string
+
+	// Keys are the keys for encrypting payload sent to the device,
+	// e.g. the "p256dh" and "auth" keys of a Web Push subscription.
+	Keys map[string]string
 }
@@ -40,6 +40,59 @@ func (_mr *_MockConnRecorder) AddRelation(arg0, arg1, arg2 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddRelation", arg0, arg1, arg2)
 }
 
+func (_m *MockConn) AssignUserRoles(_param0 []string, _param1 []string) error {
+	ret := _m.ctrl.Call(_m, "AssignUserRoles", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) AssignUserRoles(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AssignUserRoles", arg0, arg1)
+}
+
+func (_m *MockConn) CancelPushSchedules(_param0 string, _param1 time.Time) (int, error) {
+	ret := _m.ctrl.Call(_m, "CancelPushSchedules", _param0, _param1)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) CancelPushSchedules(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CancelPushSchedules", arg0, arg1)
+}
+
+func (_m *MockConn) ClaimPushDeliveries(_param0 time.Time, _param1 time.Duration, _param2 int) ([]skydb.PushDelivery, error) {
+	ret := _m.ctrl.Call(_m, "ClaimPushDeliveries", _param0, _param1, _param2)
+	ret0, _ := ret[0].([]skydb.PushDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) ClaimPushDeliveries(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ClaimPushDeliveries", arg0, arg1, arg2)
+}
+
+func (_m *MockConn) ClaimPushSchedules(_param0 time.Time, _param1 time.Duration, _param2 int) ([]skydb.PushSchedule, error) {
+	ret := _m.ctrl.Call(_m, "ClaimPushSchedules", _param0, _param1, _param2)
+	ret0, _ := ret[0].([]skydb.PushSchedule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) ClaimPushSchedules(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ClaimPushSchedules", arg0, arg1, arg2)
+}
+
+func (_m *MockConn) ClaimUploadSession(_param0 *skydb.UploadSession, _param1 time.Time, _param2 time.Duration) error {
+	ret := _m.ctrl.Call(_m, "ClaimUploadSession", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) ClaimUploadSession(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ClaimUploadSession", arg0, arg1, arg2)
+}
+
 func (_m *MockConn) Close() error {
 	ret := _m.ctrl.Call(_m, "Close")
 	ret0, _ := ret[0].(error)
@@ -50,6 +103,27 @@ func (_mr *_MockConnRecorder) Close() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Close")
 }
 
+func (_m *MockConn) CountPushDeliveries(_param0 string) (map[skydb.PushDeliveryState]int, error) {
+	ret := _m.ctrl.Call(_m, "CountPushDeliveries", _param0)
+	ret0, _ := ret[0].(map[skydb.PushDeliveryState]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) CountPushDeliveries(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CountPushDeliveries", arg0)
+}
+
+func (_m *MockConn) CreateRole(_param0 *skydb.Role) error {
+	ret := _m.ctrl.Call(_m, "CreateRole", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) CreateRole(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateRole", arg0)
+}
+
 func (_m *MockConn) CreateUser(_param0 *skydb.UserInfo) error {
 	ret := _m.ctrl.Call(_m, "CreateUser", _param0)
 	ret0, _ := ret[0].(error)
@@ -60,6 +134,26 @@ func (_mr *_MockConnRecorder) CreateUser(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateUser", arg0)
 }
 
+func (_m *MockConn) DeleteAsset(_param0 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteAsset", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) DeleteAsset(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteAsset", arg0)
+}
+
+func (_m *MockConn) DeleteChannelRule(_param0 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteChannelRule", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) DeleteChannelRule(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteChannelRule", arg0)
+}
+
 func (_m *MockConn) DeleteDevice(_param0 string) error {
 	ret := _m.ctrl.Call(_m, "DeleteDevice", _param0)
 	ret0, _ := ret[0].(error)
@@ -90,6 +184,46 @@ func (_mr *_MockConnRecorder) DeleteEmptyDevicesByTime(arg0 interface{}) *gomock
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteEmptyDevicesByTime", arg0)
 }
 
+func (_m *MockConn) DeletePushTemplate(_param0 string) error {
+	ret := _m.ctrl.Call(_m, "DeletePushTemplate", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) DeletePushTemplate(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeletePushTemplate", arg0)
+}
+
+func (_m *MockConn) DeleteRecordChanges(_param0 time.Time) error {
+	ret := _m.ctrl.Call(_m, "DeleteRecordChanges", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) DeleteRecordChanges(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteRecordChanges", arg0)
+}
+
+func (_m *MockConn) DeleteRole(_param0 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteRole", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) DeleteRole(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteRole", arg0)
+}
+
+func (_m *MockConn) DeleteUploadSession(_param0 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteUploadSession", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) DeleteUploadSession(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteUploadSession", arg0)
+}
+
 func (_m *MockConn) DeleteUser(_param0 string) error {
 	ret := _m.ctrl.Call(_m, "DeleteUser", _param0)
 	ret0, _ := ret[0].(error)
@@ -100,6 +234,16 @@ func (_mr *_MockConnRecorder) DeleteUser(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteUser", arg0)
 }
 
+func (_m *MockConn) EnqueuePushDeliveries(_param0 []skydb.PushDelivery) error {
+	ret := _m.ctrl.Call(_m, "EnqueuePushDeliveries", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) EnqueuePushDeliveries(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "EnqueuePushDeliveries", arg0)
+}
+
 func (_m *MockConn) GetAdminRoles() ([]string, error) {
 	ret := _m.ctrl.Call(_m, "GetAdminRoles")
 	ret0, _ := ret[0].([]string)
@@ -132,6 +276,17 @@ func (_mr *_MockConnRecorder) GetAssets(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetAssets", arg0, arg1)
 }
 
+func (_m *MockConn) GetChannelRules() ([]skydb.ChannelRule, error) {
+	ret := _m.ctrl.Call(_m, "GetChannelRules")
+	ret0, _ := ret[0].([]skydb.ChannelRule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetChannelRules() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetChannelRules")
+}
+
 func (_m *MockConn) GetDefaultRoles() ([]string, error) {
 	ret := _m.ctrl.Call(_m, "GetDefaultRoles")
 	ret0, _ := ret[0].([]string)
@@ -153,6 +308,93 @@ func (_mr *_MockConnRecorder) GetDevice(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetDevice", arg0, arg1)
 }
 
+func (_m *MockConn) GetDeviceTopics(_param0 string) ([]string, error) {
+	ret := _m.ctrl.Call(_m, "GetDeviceTopics", _param0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetDeviceTopics(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetDeviceTopics", arg0)
+}
+
+func (_m *MockConn) GetExpiredUploadSessions(_param0 time.Time, _param1 string, _param2 int) ([]skydb.UploadSession, error) {
+	ret := _m.ctrl.Call(_m, "GetExpiredUploadSessions", _param0, _param1, _param2)
+	ret0, _ := ret[0].([]skydb.UploadSession)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetExpiredUploadSessions(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetExpiredUploadSessions", arg0, arg1, arg2)
+}
+
+func (_m *MockConn) GetLatestRecordChangeCursor() (skydb.RecordChangeCursor, error) {
+	ret := _m.ctrl.Call(_m, "GetLatestRecordChangeCursor")
+	ret0, _ := ret[0].(skydb.RecordChangeCursor)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetLatestRecordChangeCursor() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetLatestRecordChangeCursor")
+}
+
+func (_m *MockConn) GetPushDeliveries(_param0 string, _param1 string, _param2 int) ([]skydb.PushDelivery, error) {
+	ret := _m.ctrl.Call(_m, "GetPushDeliveries", _param0, _param1, _param2)
+	ret0, _ := ret[0].([]skydb.PushDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetPushDeliveries(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetPushDeliveries", arg0, arg1, arg2)
+}
+
+func (_m *MockConn) GetPushSchedules(_param0 string) ([]skydb.PushSchedule, error) {
+	ret := _m.ctrl.Call(_m, "GetPushSchedules", _param0)
+	ret0, _ := ret[0].([]skydb.PushSchedule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetPushSchedules(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetPushSchedules", arg0)
+}
+
+func (_m *MockConn) GetPushTemplate(_param0 string, _param1 *skydb.PushTemplate) error {
+	ret := _m.ctrl.Call(_m, "GetPushTemplate", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) GetPushTemplate(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetPushTemplate", arg0, arg1)
+}
+
+func (_m *MockConn) GetPushTemplates() ([]skydb.PushTemplate, error) {
+	ret := _m.ctrl.Call(_m, "GetPushTemplates")
+	ret0, _ := ret[0].([]skydb.PushTemplate)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetPushTemplates() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetPushTemplates")
+}
+
+func (_m *MockConn) GetRecordACLParent(_param0 string) (skydb.RecordACLParent, error) {
+	ret := _m.ctrl.Call(_m, "GetRecordACLParent", _param0)
+	ret0, _ := ret[0].(skydb.RecordACLParent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetRecordACLParent(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRecordACLParent", arg0)
+}
+
 func (_m *MockConn) GetRecordAccess(_param0 string) (skydb.RecordACL, error) {
 	ret := _m.ctrl.Call(_m, "GetRecordAccess", _param0)
 	ret0, _ := ret[0].(skydb.RecordACL)
@@ -164,6 +406,60 @@ func (_mr *_MockConnRecorder) GetRecordAccess(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRecordAccess", arg0)
 }
 
+func (_m *MockConn) GetRecordPolicy(_param0 string) (skydb.Predicate, error) {
+	ret := _m.ctrl.Call(_m, "GetRecordPolicy", _param0)
+	ret0, _ := ret[0].(skydb.Predicate)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetRecordPolicy(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRecordPolicy", arg0)
+}
+
+func (_m *MockConn) GetRolePermissions(_param0 []string) ([]string, error) {
+	ret := _m.ctrl.Call(_m, "GetRolePermissions", _param0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetRolePermissions(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRolePermissions", arg0)
+}
+
+func (_m *MockConn) GetRoles() ([]skydb.Role, error) {
+	ret := _m.ctrl.Call(_m, "GetRoles")
+	ret0, _ := ret[0].([]skydb.Role)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetRoles() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRoles")
+}
+
+func (_m *MockConn) GetUnreferencedAssets(_param0 map[string]skydb.RecordSchema, _param1 time.Time, _param2 string, _param3 int) ([]skydb.Asset, error) {
+	ret := _m.ctrl.Call(_m, "GetUnreferencedAssets", _param0, _param1, _param2, _param3)
+	ret0, _ := ret[0].([]skydb.Asset)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) GetUnreferencedAssets(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetUnreferencedAssets", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockConn) GetUploadSession(_param0 string, _param1 *skydb.UploadSession) error {
+	ret := _m.ctrl.Call(_m, "GetUploadSession", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) GetUploadSession(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetUploadSession", arg0, arg1)
+}
+
 func (_m *MockConn) GetUser(_param0 string, _param1 *skydb.UserInfo) error {
 	ret := _m.ctrl.Call(_m, "GetUser", _param0, _param1)
 	ret0, _ := ret[0].(error)
@@ -194,6 +490,16 @@ func (_mr *_MockConnRecorder) GetUserByUsernameEmail(arg0, arg1, arg2 interface{
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetUserByUsernameEmail", arg0, arg1, arg2)
 }
 
+func (_m *MockConn) MergeUser(_param0 string, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "MergeUser", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) MergeUser(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "MergeUser", arg0, arg1)
+}
+
 func (_m *MockConn) PrivateDB(_param0 string) skydb.Database {
 	ret := _m.ctrl.Call(_m, "PrivateDB", _param0)
 	ret0, _ := ret[0].(skydb.Database)
@@ -214,6 +520,17 @@ func (_mr *_MockConnRecorder) PublicDB() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "PublicDB")
 }
 
+func (_m *MockConn) QueryDevicesByTopic(_param0 string, _param1 string, _param2 int) ([]skydb.Device, error) {
+	ret := _m.ctrl.Call(_m, "QueryDevicesByTopic", _param0, _param1, _param2)
+	ret0, _ := ret[0].([]skydb.Device)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnRecorder) QueryDevicesByTopic(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "QueryDevicesByTopic", arg0, arg1, arg2)
+}
+
 func (_m *MockConn) QueryDevicesByUser(_param0 string) ([]skydb.Device, error) {
 	ret := _m.ctrl.Call(_m, "QueryDevicesByUser", _param0)
 	ret0, _ := ret[0].([]skydb.Device)
@@ -278,6 +595,16 @@ func (_mr *_MockConnRecorder) RemoveRelation(arg0, arg1, arg2 interface{}) *gomo
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoveRelation", arg0, arg1, arg2)
 }
 
+func (_m *MockConn) RevokeUserRoles(_param0 []string, _param1 []string) error {
+	ret := _m.ctrl.Call(_m, "RevokeUserRoles", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) RevokeUserRoles(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RevokeUserRoles", arg0, arg1)
+}
+
 func (_m *MockConn) SaveAsset(_param0 *skydb.Asset) error {
 	ret := _m.ctrl.Call(_m, "SaveAsset", _param0)
 	ret0, _ := ret[0].(error)
@@ -288,6 +615,16 @@ func (_mr *_MockConnRecorder) SaveAsset(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SaveAsset", arg0)
 }
 
+func (_m *MockConn) SaveChannelRule(_param0 *skydb.ChannelRule) error {
+	ret := _m.ctrl.Call(_m, "SaveChannelRule", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) SaveChannelRule(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SaveChannelRule", arg0)
+}
+
 func (_m *MockConn) SaveDevice(_param0 *skydb.Device) error {
 	ret := _m.ctrl.Call(_m, "SaveDevice", _param0)
 	ret0, _ := ret[0].(error)
@@ -298,6 +635,36 @@ func (_mr *_MockConnRecorder) SaveDevice(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SaveDevice", arg0)
 }
 
+func (_m *MockConn) SavePushSchedule(_param0 *skydb.PushSchedule) error {
+	ret := _m.ctrl.Call(_m, "SavePushSchedule", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) SavePushSchedule(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SavePushSchedule", arg0)
+}
+
+func (_m *MockConn) SavePushTemplate(_param0 *skydb.PushTemplate) error {
+	ret := _m.ctrl.Call(_m, "SavePushTemplate", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) SavePushTemplate(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SavePushTemplate", arg0)
+}
+
+func (_m *MockConn) SaveUploadSession(_param0 *skydb.UploadSession) error {
+	ret := _m.ctrl.Call(_m, "SaveUploadSession", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) SaveUploadSession(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SaveUploadSession", arg0)
+}
+
 func (_m *MockConn) SetAdminRoles(_param0 []string) error {
 	ret := _m.ctrl.Call(_m, "SetAdminRoles", _param0)
 	ret0, _ := ret[0].(error)
@@ -318,6 +685,16 @@ func (_mr *_MockConnRecorder) SetDefaultRoles(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetDefaultRoles", arg0)
 }
 
+func (_m *MockConn) SetRecordACLParent(_param0 string, _param1 skydb.RecordACLParent) error {
+	ret := _m.ctrl.Call(_m, "SetRecordACLParent", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) SetRecordACLParent(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetRecordACLParent", arg0, arg1)
+}
+
 func (_m *MockConn) SetRecordAccess(_param0 string, _param1 skydb.RecordACL) error {
 	ret := _m.ctrl.Call(_m, "SetRecordAccess", _param0, _param1)
 	ret0, _ := ret[0].(error)
@@ -328,6 +705,16 @@ func (_mr *_MockConnRecorder) SetRecordAccess(arg0, arg1 interface{}) *gomock.Ca
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetRecordAccess", arg0, arg1)
 }
 
+func (_m *MockConn) SetRecordPolicy(_param0 string, _param1 skydb.Predicate) error {
+	ret := _m.ctrl.Call(_m, "SetRecordPolicy", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) SetRecordPolicy(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetRecordPolicy", arg0, arg1)
+}
+
 func (_m *MockConn) Subscribe(_param0 chan skydb.RecordEvent) error {
 	ret := _m.ctrl.Call(_m, "Subscribe", _param0)
 	ret0, _ := ret[0].(error)
@@ -338,6 +725,16 @@ func (_mr *_MockConnRecorder) Subscribe(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Subscribe", arg0)
 }
 
+func (_m *MockConn) SubscribeDeviceTopics(_param0 string, _param1 []string) error {
+	ret := _m.ctrl.Call(_m, "SubscribeDeviceTopics", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) SubscribeDeviceTopics(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SubscribeDeviceTopics", arg0, arg1)
+}
+
 func (_m *MockConn) UnionDB() skydb.Database {
 	ret := _m.ctrl.Call(_m, "UnionDB")
 	ret0, _ := ret[0].(skydb.Database)
@@ -348,6 +745,46 @@ func (_mr *_MockConnRecorder) UnionDB() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "UnionDB")
 }
 
+func (_m *MockConn) UnsubscribeDeviceTopics(_param0 string, _param1 []string) error {
+	ret := _m.ctrl.Call(_m, "UnsubscribeDeviceTopics", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) UnsubscribeDeviceTopics(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UnsubscribeDeviceTopics", arg0, arg1)
+}
+
+func (_m *MockConn) UpdatePushDelivery(_param0 *skydb.PushDelivery) error {
+	ret := _m.ctrl.Call(_m, "UpdatePushDelivery", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) UpdatePushDelivery(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdatePushDelivery", arg0)
+}
+
+func (_m *MockConn) UpdatePushSchedule(_param0 *skydb.PushSchedule) error {
+	ret := _m.ctrl.Call(_m, "UpdatePushSchedule", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) UpdatePushSchedule(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdatePushSchedule", arg0)
+}
+
+func (_m *MockConn) UpdateUploadSession(_param0 *skydb.UploadSession, _param1 int64) error {
+	ret := _m.ctrl.Call(_m, "UpdateUploadSession", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnRecorder) UpdateUploadSession(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateUploadSession", arg0, arg1)
+}
+
 func (_m *MockConn) UpdateUser(_param0 *skydb.UserInfo) error {
 	ret := _m.ctrl.Call(_m, "UpdateUser", _param0)
 	ret0, _ := ret[0].(error)
@@ -471,6 +908,17 @@ func (_mr *_MockDatabaseRecorder) GetMatchingSubscriptions(arg0 interface{}) *go
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetMatchingSubscriptions", arg0)
 }
 
+func (_m *MockDatabase) GetRecordChanges(_param0 string, _param1 skydb.RecordChangeCursor, _param2 uint64) ([]skydb.RecordChange, error) {
+	ret := _m.ctrl.Call(_m, "GetRecordChanges", _param0, _param1, _param2)
+	ret0, _ := ret[0].([]skydb.RecordChange)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetRecordChanges(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRecordChanges", arg0, arg1, arg2)
+}
+
 func (_m *MockDatabase) GetRecordSchemas() (map[string]skydb.RecordSchema, error) {
 	ret := _m.ctrl.Call(_m, "GetRecordSchemas")
 	ret0, _ := ret[0].(map[string]skydb.RecordSchema)
@@ -533,6 +981,17 @@ func (_mr *_MockDatabaseRecorder) IsReadOnly() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsReadOnly")
 }
 
+func (_m *MockDatabase) MatchQuery(_param0 *skydb.Query, _param1 *skydb.Record) (bool, error) {
+	ret := _m.ctrl.Call(_m, "MatchQuery", _param0, _param1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) MatchQuery(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "MatchQuery", arg0, arg1)
+}
+
 func (_m *MockDatabase) Query(_param0 *skydb.Query) (*skydb.Rows, error) {
 	ret := _m.ctrl.Call(_m, "Query", _param0)
 	ret0, _ := ret[0].(*skydb.Rows)
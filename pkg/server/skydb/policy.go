@@ -0,0 +1,393 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// A record policy is a Predicate that a record of a record type must
+// satisfy for an user to access it, in addition to the record ACL.
+//
+// Record policy references the current user with CurrentUserFunc, which
+// is resolved by BindCurrentUser before the policy is evaluated, either
+// in memory by MatchRecord or by the database as part of a query.
+
+// ValidatePolicy returns an Error if the Predicate cannot be used as
+// a record policy.
+//
+// A record policy consists of compound and comparison predicates only.
+// Key paths cannot reference another record, and CurrentUserFunc is the only
+// function allowed.
+func (p Predicate) ValidatePolicy() skyerr.Error {
+	if p.IsEmpty() {
+		return nil
+	}
+
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	return p.validatePolicy()
+}
+
+func (p Predicate) validatePolicy() skyerr.Error {
+	if p.Operator == Functional {
+		return skyerr.NewError(skyerr.NotSupported,
+			`functional predicate is not supported in record policy`)
+	}
+
+	if p.Operator.IsCompound() {
+		for _, child := range p.GetSubPredicates() {
+			if err := child.validatePolicy(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, expr := range p.GetExpressions() {
+		switch expr.Type {
+		case KeyPath:
+			if len(expr.KeyPathComponents()) > 1 {
+				return skyerr.NewErrorf(skyerr.NotSupported,
+					`key path "%s" is not supported in record policy`, expr.Value)
+			}
+		case Function:
+			if _, ok := expr.Value.(CurrentUserFunc); !ok {
+				return skyerr.NewErrorf(skyerr.NotSupported,
+					`function %T is not supported in record policy`, expr.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// ReferencesUserRecord returns true if the Predicate contains
+// a CurrentUserFunc that refers to a field of the user record.
+func (p Predicate) ReferencesUserRecord() bool {
+	if p.IsEmpty() {
+		return false
+	}
+
+	if p.Operator.IsCompound() {
+		for _, child := range p.GetSubPredicates() {
+			if child.ReferencesUserRecord() {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, child := range p.Children {
+		expr, ok := child.(Expression)
+		if !ok || expr.Type != Function {
+			continue
+		}
+		if f, ok := expr.Value.(CurrentUserFunc); ok && f.KeyPath != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// BindCurrentUser returns a copy of the Predicate with every
+// CurrentUserFunc replaced by the value it refers to.
+//
+// userRecord is consulted when CurrentUserFunc refers to a field of the user
+// record. A comparison with a CurrentUserFunc that cannot be resolved, such
+// as when there is no current user, is replaced by a predicate that denies
+// access: one that matches nothing, or one that matches everything if the
+// comparison is negated by an odd number of Not predicates. Other
+// comparisons of the Predicate are unaffected.
+func (p Predicate) BindCurrentUser(userID string, userRecord *Record) Predicate {
+	return p.bindCurrentUser(userID, userRecord, false)
+}
+
+func (p Predicate) bindCurrentUser(userID string, userRecord *Record, negated bool) Predicate {
+	if p.IsEmpty() || p.Operator == Functional {
+		return p
+	}
+
+	bound := Predicate{
+		Operator: p.Operator,
+		Children: make([]interface{}, len(p.Children)),
+	}
+
+	if p.Operator.IsCompound() {
+		if p.Operator == Not {
+			negated = !negated
+		}
+		for i, child := range p.GetSubPredicates() {
+			bound.Children[i] = child.bindCurrentUser(userID, userRecord, negated)
+		}
+		return bound
+	}
+
+	for i, expr := range p.GetExpressions() {
+		f, ok := expr.Value.(CurrentUserFunc)
+		if expr.Type != Function || !ok {
+			bound.Children[i] = expr
+			continue
+		}
+
+		var value interface{}
+		if f.KeyPath == "" {
+			if userID != "" {
+				value = userID
+			}
+		} else if userRecord != nil {
+			value = userRecord.Get(f.KeyPath)
+		}
+
+		if value == nil {
+			if negated {
+				return everythingPredicate()
+			}
+			return nothingPredicate()
+		}
+		bound.Children[i] = Expression{
+			Type:  Literal,
+			Value: value,
+		}
+	}
+	return bound
+}
+
+// nothingPredicate returns a predicate that matches no record.
+func nothingPredicate() Predicate {
+	return Predicate{
+		Operator: Equal,
+		Children: []interface{}{
+			Expression{Type: KeyPath, Value: "_id"},
+			Expression{Type: Literal, Value: nil},
+		},
+	}
+}
+
+// everythingPredicate returns a predicate that matches every record.
+func everythingPredicate() Predicate {
+	return Predicate{
+		Operator: NotEqual,
+		Children: []interface{}{
+			Expression{Type: KeyPath, Value: "_id"},
+			Expression{Type: Literal, Value: nil},
+		},
+	}
+}
+
+// ResolveCurrentUser binds the Predicate with the specified user,
+// fetching the user record from the public database of db if
+// the Predicate references it.
+//
+// userInfo can be nil if the Predicate is evaluated for an unauthenticated
+// request, in which case comparisons with the current user deny access
+// as described in BindCurrentUser.
+func ResolveCurrentUser(db Database, p Predicate, userInfo *UserInfo) (Predicate, error) {
+	if p.IsEmpty() {
+		return p, nil
+	}
+
+	if userInfo == nil {
+		return p.BindCurrentUser("", nil), nil
+	}
+
+	var userRecord *Record
+	if p.ReferencesUserRecord() {
+		publicDB := db.Conn().PublicDB()
+		record := Record{}
+		err := publicDB.Get(NewRecordID(publicDB.UserRecordType(), userInfo.ID), &record)
+		if err == nil {
+			userRecord = &record
+		} else if err != ErrRecordNotFound {
+			return Predicate{}, err
+		}
+	}
+
+	return p.BindCurrentUser(userInfo.ID, userRecord), nil
+}
+
+// MatchRecord returns true if the record satisfies the Predicate.
+//
+// The Predicate is expected to be a valid record policy bound with
+// BindCurrentUser. Comparison that is not supported evaluates to false.
+func (p Predicate) MatchRecord(record *Record) bool {
+	if p.IsEmpty() {
+		return true
+	}
+
+	switch p.Operator {
+	case And:
+		for _, child := range p.GetSubPredicates() {
+			if !child.MatchRecord(record) {
+				return false
+			}
+		}
+		return true
+	case Or:
+		for _, child := range p.GetSubPredicates() {
+			if child.MatchRecord(record) {
+				return true
+			}
+		}
+		return false
+	case Not:
+		return !p.GetSubPredicates()[0].MatchRecord(record)
+	case Functional:
+		return false
+	}
+
+	exprs := p.GetExpressions()
+	lv := policyOperandValue(exprs[0], record)
+	rv := policyOperandValue(exprs[1], record)
+
+	switch p.Operator {
+	case Equal:
+		return reflect.DeepEqual(lv, rv)
+	case NotEqual:
+		return !reflect.DeepEqual(lv, rv)
+	case GreaterThan:
+		cmp, ok := comparePolicyValues(lv, rv)
+		return ok && cmp > 0
+	case GreaterThanOrEqual:
+		cmp, ok := comparePolicyValues(lv, rv)
+		return ok && cmp >= 0
+	case LessThan:
+		cmp, ok := comparePolicyValues(lv, rv)
+		return ok && cmp < 0
+	case LessThanOrEqual:
+		cmp, ok := comparePolicyValues(lv, rv)
+		return ok && cmp <= 0
+	case Like, ILike:
+		s, sok := lv.(string)
+		pattern, pok := rv.(string)
+		return sok && pok && likeMatch(s, pattern, p.Operator == ILike)
+	case In:
+		haystack, ok := rv.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, hay := range haystack {
+			if reflect.DeepEqual(lv, policyValue(hay)) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func policyOperandValue(expr Expression, record *Record) interface{} {
+	switch expr.Type {
+	case KeyPath:
+		return policyValue(record.Get(expr.Value.(string)))
+	case Literal:
+		return policyValue(expr.Value)
+	}
+	return nil
+}
+
+// policyValue normalizes value such that values of the same meaning
+// are deeply equal to each other.
+func policyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case Reference:
+		return v.ID.Key
+	case *Reference:
+		return v.ID.Key
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case time.Time:
+		return v.UTC()
+	}
+	return value
+}
+
+// comparePolicyValues compares two normalized values. The second value
+// returned is false if the values cannot be compared.
+func comparePolicyValues(lv, rv interface{}) (int, bool) {
+	switch l := lv.(type) {
+	case float64:
+		r, ok := rv.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l < r:
+			return -1, true
+		case l > r:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		r, ok := rv.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(l, r), true
+	case time.Time:
+		r, ok := rv.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l.Before(r):
+			return -1, true
+		case l.After(r):
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// likeMatch reports whether s matches the SQL LIKE pattern.
+func likeMatch(s string, pattern string, caseInsensitive bool) bool {
+	var b bytes.Buffer
+	if caseInsensitive {
+		b.WriteString(`(?i)`)
+	}
+	b.WriteString(`(?s)^`)
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(`.*`)
+		case r == '_':
+			b.WriteString(`.`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(`$`)
+
+	matched, err := regexp.MatchString(b.String(), s)
+	return err == nil && matched
+}
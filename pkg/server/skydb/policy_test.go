@@ -0,0 +1,236 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordPolicy(t *testing.T) {
+	ownerPolicy := Predicate{
+		Operator: Equal,
+		Children: []interface{}{
+			Expression{Type: KeyPath, Value: "_owner_id"},
+			Expression{Type: Function, Value: CurrentUserFunc{}},
+		},
+	}
+	orgPolicy := Predicate{
+		Operator: Equal,
+		Children: []interface{}{
+			Expression{Type: KeyPath, Value: "org"},
+			Expression{Type: Function, Value: CurrentUserFunc{KeyPath: "org"}},
+		},
+	}
+	policy := Predicate{
+		Operator: Or,
+		Children: []interface{}{ownerPolicy, orgPolicy},
+	}
+
+	Convey("validates policy", t, func() {
+		So(policy.ValidatePolicy(), ShouldBeNil)
+		So(Predicate{}.ValidatePolicy(), ShouldBeNil)
+
+		err := Predicate{
+			Operator: Equal,
+			Children: []interface{}{
+				Expression{Type: KeyPath, Value: "org.name"},
+				Expression{Type: Literal, Value: "oursky"},
+			},
+		}.ValidatePolicy()
+		So(err, ShouldNotBeNil)
+		So(err.Code(), ShouldEqual, skyerr.NotSupported)
+
+		err = Predicate{
+			Operator: Functional,
+			Children: []interface{}{
+				Expression{Type: Function, Value: UserRelationFunc{
+					KeyPath:      "_owner_id",
+					RelationName: "_friend",
+				}},
+			},
+		}.ValidatePolicy()
+		So(err, ShouldNotBeNil)
+		So(err.Code(), ShouldEqual, skyerr.NotSupported)
+	})
+
+	Convey("references user record", t, func() {
+		So(ownerPolicy.ReferencesUserRecord(), ShouldBeFalse)
+		So(orgPolicy.ReferencesUserRecord(), ShouldBeTrue)
+		So(policy.ReferencesUserRecord(), ShouldBeTrue)
+	})
+
+	Convey("binds current user", t, func() {
+		userRecord := Record{
+			ID:   NewRecordID("user", "user0"),
+			Data: map[string]interface{}{"org": "oursky"},
+		}
+
+		bound := policy.BindCurrentUser("user0", &userRecord)
+		So(bound, ShouldResemble, Predicate{
+			Operator: Or,
+			Children: []interface{}{
+				Predicate{
+					Operator: Equal,
+					Children: []interface{}{
+						Expression{Type: KeyPath, Value: "_owner_id"},
+						Expression{Type: Literal, Value: "user0"},
+					},
+				},
+				Predicate{
+					Operator: Equal,
+					Children: []interface{}{
+						Expression{Type: KeyPath, Value: "org"},
+						Expression{Type: Literal, Value: "oursky"},
+					},
+				},
+			},
+		})
+	})
+
+	Convey("binds unresolved current user to nothing", t, func() {
+		So(ownerPolicy.BindCurrentUser("", nil), ShouldResemble, nothingPredicate())
+
+		bound := policy.BindCurrentUser("user0", &Record{
+			ID: NewRecordID("user", "user0"),
+		})
+		So(bound, ShouldResemble, Predicate{
+			Operator: Or,
+			Children: []interface{}{
+				Predicate{
+					Operator: Equal,
+					Children: []interface{}{
+						Expression{Type: KeyPath, Value: "_owner_id"},
+						Expression{Type: Literal, Value: "user0"},
+					},
+				},
+				nothingPredicate(),
+			},
+		})
+		So(bound.MatchRecord(&Record{
+			ID:      NewRecordID("note", "0"),
+			OwnerID: "user0",
+		}), ShouldBeTrue)
+		So(bound.MatchRecord(&Record{
+			ID:      NewRecordID("note", "1"),
+			OwnerID: "user1",
+		}), ShouldBeFalse)
+	})
+
+	Convey("binds negated unresolved current user to everything", t, func() {
+		notOwnerPolicy := Predicate{
+			Operator: Not,
+			Children: []interface{}{ownerPolicy},
+		}
+
+		bound, err := ResolveCurrentUser(nil, notOwnerPolicy, nil)
+		So(err, ShouldBeNil)
+		So(bound, ShouldResemble, Predicate{
+			Operator: Not,
+			Children: []interface{}{everythingPredicate()},
+		})
+		So(bound.MatchRecord(&Record{
+			ID:      NewRecordID("note", "0"),
+			OwnerID: "user1",
+		}), ShouldBeFalse)
+	})
+
+	Convey("resolves public records for unauthenticated user", t, func() {
+		publicOrOwnerPolicy := Predicate{
+			Operator: Or,
+			Children: []interface{}{
+				Predicate{
+					Operator: Equal,
+					Children: []interface{}{
+						Expression{Type: KeyPath, Value: "public"},
+						Expression{Type: Literal, Value: true},
+					},
+				},
+				ownerPolicy,
+			},
+		}
+
+		bound, err := ResolveCurrentUser(nil, publicOrOwnerPolicy, nil)
+		So(err, ShouldBeNil)
+		So(bound.MatchRecord(&Record{
+			ID:      NewRecordID("note", "0"),
+			OwnerID: "user0",
+			Data:    map[string]interface{}{"public": true},
+		}), ShouldBeTrue)
+		So(bound.MatchRecord(&Record{
+			ID:      NewRecordID("note", "1"),
+			OwnerID: "user0",
+			Data:    map[string]interface{}{"public": false},
+		}), ShouldBeFalse)
+	})
+
+	Convey("matches record", t, func() {
+		userRecord := Record{
+			ID:   NewRecordID("user", "user0"),
+			Data: map[string]interface{}{"org": "oursky"},
+		}
+		bound := policy.BindCurrentUser("user0", &userRecord)
+
+		So(bound.MatchRecord(&Record{
+			ID:      NewRecordID("note", "0"),
+			OwnerID: "user0",
+		}), ShouldBeTrue)
+		So(bound.MatchRecord(&Record{
+			ID:      NewRecordID("note", "1"),
+			OwnerID: "user1",
+			Data:    map[string]interface{}{"org": "oursky"},
+		}), ShouldBeTrue)
+		So(bound.MatchRecord(&Record{
+			ID:      NewRecordID("note", "2"),
+			OwnerID: "user1",
+			Data:    map[string]interface{}{"org": "skygear"},
+		}), ShouldBeFalse)
+	})
+
+	Convey("matches comparison", t, func() {
+		record := Record{
+			ID: NewRecordID("note", "0"),
+			Data: map[string]interface{}{
+				"priority": float64(3),
+				"title":    "Hello World",
+				"tags":     []interface{}{"a", "b"},
+			},
+		}
+
+		compare := func(op Operator, lhs Expression, rhs Expression) bool {
+			return Predicate{
+				Operator: op,
+				Children: []interface{}{lhs, rhs},
+			}.MatchRecord(&record)
+		}
+		keyPath := func(key string) Expression {
+			return Expression{Type: KeyPath, Value: key}
+		}
+		literal := func(value interface{}) Expression {
+			return Expression{Type: Literal, Value: value}
+		}
+
+		So(compare(GreaterThan, keyPath("priority"), literal(2)), ShouldBeTrue)
+		So(compare(LessThanOrEqual, keyPath("priority"), literal(float64(2))), ShouldBeFalse)
+		So(compare(Like, keyPath("title"), literal("Hello%")), ShouldBeTrue)
+		So(compare(Like, keyPath("title"), literal("hello%")), ShouldBeFalse)
+		So(compare(ILike, keyPath("title"), literal("hello_world")), ShouldBeTrue)
+		So(compare(In, keyPath("priority"), literal([]interface{}{float64(1), float64(3)})), ShouldBeTrue)
+		So(compare(In, literal("b"), keyPath("tags")), ShouldBeTrue)
+		So(compare(In, literal("c"), keyPath("tags")), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,56 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"database/sql"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) SetRecordACLParent(recordType string, aclParent skydb.RecordACLParent) error {
+	if aclParent.IsEmpty() {
+		builder := psql.Delete(c.tableName("_record_acl_parent")).
+			Where(sq.Eq{"record_type": recordType})
+		_, err := c.ExecWith(builder)
+		return err
+	}
+
+	builder := upsertQuery(c.tableName("_record_acl_parent"), map[string]interface{}{
+		"record_type": recordType,
+	}, map[string]interface{}{
+		"field_name": aclParent.Field,
+		"max_depth":  aclParent.MaxDepth,
+	})
+	_, err := c.ExecWith(builder)
+	return err
+}
+
+func (c *conn) GetRecordACLParent(recordType string) (skydb.RecordACLParent, error) {
+	builder := psql.Select("field_name", "max_depth").
+		From(c.tableName("_record_acl_parent")).
+		Where(sq.Eq{"record_type": recordType})
+
+	aclParent := skydb.RecordACLParent{}
+	err := c.QueryRowWith(builder).Scan(&aclParent.Field, &aclParent.MaxDepth)
+	if err == sql.ErrNoRows {
+		return skydb.RecordACLParent{}, nil
+	} else if err != nil {
+		return skydb.RecordACLParent{}, err
+	}
+
+	return aclParent, nil
+}
@@ -16,9 +16,13 @@ package pq
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"time"
 
-	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	sq "github.com/lann/squirrel"
+	"github.com/lib/pq"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
 )
 
 func (c *conn) GetAsset(name string, asset *skydb.Asset) error {
@@ -81,3 +85,82 @@ func (c *conn) SaveAsset(asset *skydb.Asset) error {
 	_, err := c.ExecWith(upsert)
 	return err
 }
+
+func (c *conn) GetUnreferencedAssets(schemas map[string]skydb.RecordSchema, createdBefore time.Time, afterName string, limit int) ([]skydb.Asset, error) {
+	builder := psql.Select("a.id", "a.content_type", "a.size").
+		From(c.tableName("_asset")+" AS a").
+		Where("a.created_at < ? AND a.id > ?", createdBefore.UTC(), afterName).
+		OrderBy("a.id").
+		Limit(uint64(limit))
+
+	recordTypes := []string{}
+	for recordType := range schemas {
+		recordTypes = append(recordTypes, recordType)
+	}
+	sort.Strings(recordTypes)
+
+	for _, recordType := range recordTypes {
+		columns := []string{}
+		for column := range schemas[recordType] {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+
+		for _, column := range columns {
+			switch schemas[recordType][column].Type {
+			case skydb.TypeAsset:
+				builder = builder.Where(fmt.Sprintf(
+					"NOT EXISTS (SELECT 1 FROM %s WHERE %s = a.id)",
+					c.tableName(recordType),
+					pq.QuoteIdentifier(column),
+				))
+			case skydb.TypeJSON:
+				// An asset in a JSON field is saved as an object which
+				// keys depend on how it is saved, so the asset is
+				// referenced by any string in the field equal to its name.
+				builder = builder.Where(fmt.Sprintf(
+					"NOT EXISTS (SELECT 1 FROM %s WHERE strpos(%s::text, to_json(a.id)::text) > 0)",
+					c.tableName(recordType),
+					pq.QuoteIdentifier(column),
+				))
+			}
+		}
+	}
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []skydb.Asset{}
+	for rows.Next() {
+		a := skydb.Asset{}
+		if err := rows.Scan(&a.Name, &a.ContentType, &a.Size); err != nil {
+			return nil, err
+		}
+		results = append(results, a)
+	}
+
+	return results, rows.Err()
+}
+
+func (c *conn) DeleteAsset(name string) error {
+	builder := psql.Delete(c.tableName("_asset")).
+		Where("id = ?", name)
+
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrAssetNotFound
+	}
+
+	return nil
+}
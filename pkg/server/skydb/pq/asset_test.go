@@ -0,0 +1,119 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnreferencedAssets(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		for _, name := range []string{"a.png", "b.png", "c.png"} {
+			So(c.SaveAsset(&skydb.Asset{
+				Name:        name,
+				ContentType: "image/png",
+				Size:        1,
+			}), ShouldBeNil)
+		}
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"image": skydb.FieldType{Type: skydb.TypeAsset},
+		})
+		So(err, ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID: skydb.NewRecordID("note", "id"),
+			Data: map[string]interface{}{
+				"image": &skydb.Asset{Name: "a.png"},
+			},
+			OwnerID: "user_id",
+		}), ShouldBeNil)
+
+		schemas, err := db.GetRecordSchemas()
+		So(err, ShouldBeNil)
+		later := time.Now().Add(time.Minute)
+
+		Convey("gets assets not referenced by records", func() {
+			assets, err := c.GetUnreferencedAssets(schemas, later, "", 10)
+			So(err, ShouldBeNil)
+			So(assets, ShouldResemble, []skydb.Asset{
+				{Name: "b.png", ContentType: "image/png", Size: 1},
+				{Name: "c.png", ContentType: "image/png", Size: 1},
+			})
+		})
+
+		Convey("excludes assets referenced in JSON fields", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"attachments": skydb.FieldType{Type: skydb.TypeJSON},
+			})
+			So(err, ShouldBeNil)
+			So(db.Save(&skydb.Record{
+				ID: skydb.NewRecordID("note", "id2"),
+				Data: map[string]interface{}{
+					"attachments": []interface{}{
+						map[string]interface{}{"file": &skydb.Asset{Name: "c.png"}},
+					},
+				},
+				OwnerID: "user_id",
+			}), ShouldBeNil)
+
+			schemas, err := db.GetRecordSchemas()
+			So(err, ShouldBeNil)
+			assets, err := c.GetUnreferencedAssets(schemas, later, "", 10)
+			So(err, ShouldBeNil)
+			So(assets, ShouldResemble, []skydb.Asset{
+				{Name: "b.png", ContentType: "image/png", Size: 1},
+			})
+		})
+
+		Convey("gets next page of assets", func() {
+			assets, err := c.GetUnreferencedAssets(schemas, later, "", 1)
+			So(err, ShouldBeNil)
+			So(len(assets), ShouldEqual, 1)
+			So(assets[0].Name, ShouldEqual, "b.png")
+
+			assets, err = c.GetUnreferencedAssets(schemas, later, "b.png", 1)
+			So(err, ShouldBeNil)
+			So(len(assets), ShouldEqual, 1)
+			So(assets[0].Name, ShouldEqual, "c.png")
+		})
+
+		Convey("excludes assets created after the time", func() {
+			assets, err := c.GetUnreferencedAssets(schemas, time.Now().Add(-time.Hour), "", 10)
+			So(err, ShouldBeNil)
+			So(assets, ShouldBeEmpty)
+		})
+
+		Convey("deletes asset", func() {
+			So(c.DeleteAsset("b.png"), ShouldBeNil)
+			assets, err := c.GetAssets([]string{"b.png"})
+			So(err, ShouldBeNil)
+			So(assets, ShouldBeEmpty)
+
+			So(c.DeleteAsset("b.png"), ShouldEqual, skydb.ErrAssetNotFound)
+		})
+
+		Convey("does not delete asset referenced by record", func() {
+			So(c.DeleteAsset("a.png"), ShouldNotBeNil)
+		})
+	})
+}
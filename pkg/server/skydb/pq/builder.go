@@ -183,10 +183,72 @@ func (f *predicateSqlizerFactory) newUserDiscoverFunctionalPredicateSqlizer(fn s
 }
 
 func (f *predicateSqlizerFactory) newAccessControlSqlizer(user *skydb.UserInfo, aclLevel skydb.ACLLevel) (sq.Sqlizer, error) {
-	return &accessPredicateSqlizer{
+	aclParent, err := f.db.c.GetRecordACLParent(f.primaryTable)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.newACLParentAccessSqlizer(user, aclLevel, f.primaryTable,
+		f.primaryTable, aclParent, aclParent.Depth())
+}
+
+// newACLParentAccessSqlizer returns a sqlizer for the access of records
+// of recordType in the table aliased as alias, following at most depth
+// ACL parents.
+func (f *predicateSqlizerFactory) newACLParentAccessSqlizer(user *skydb.UserInfo, aclLevel skydb.ACLLevel, recordType string, alias string, aclParent skydb.RecordACLParent, depth int) (sq.Sqlizer, error) {
+	access := accessPredicateSqlizer{
 		user,
 		aclLevel,
-	}, nil
+	}
+	if aclParent.IsEmpty() {
+		return &access, nil
+	}
+
+	typemap, err := f.db.remoteColumnTypes(recordType)
+	if err != nil {
+		return nil, err
+	}
+	fieldType, ok := typemap[aclParent.Field]
+	if !ok || fieldType.Type != skydb.TypeReference {
+		return nil, fmt.Errorf("ACL parent field %s of %s is not a reference", aclParent.Field, recordType)
+	}
+
+	sqlizer := &aclParentAccessSqlizer{
+		alias:       alias,
+		field:       aclParent.Field,
+		parentTable: f.db.tableName(fieldType.ReferenceType),
+		parentAlias: fmt.Sprintf("_acl_parent_%d", depth),
+	}
+	sqlizer.accessPredicateSqlizer = access
+	if depth > 0 {
+		grandParent, err := f.db.c.GetRecordACLParent(fieldType.ReferenceType)
+		if err != nil {
+			return nil, err
+		}
+		sqlizer.parent, err = f.newACLParentAccessSqlizer(user, aclLevel,
+			fieldType.ReferenceType, sqlizer.parentAlias, grandParent, depth-1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sqlizer, nil
+}
+
+// newRecordPolicySqlizer returns a sqlizer of the record policy of the
+// record type bound with the specified user, or nil if the record type
+// has no record policy.
+func (f *predicateSqlizerFactory) newRecordPolicySqlizer(recordType string, user *skydb.UserInfo) (sq.Sqlizer, error) {
+	policy, err := f.db.c.GetRecordPolicy(recordType)
+	if err != nil || policy.IsEmpty() {
+		return nil, err
+	}
+
+	policy, err = skydb.ResolveCurrentUser(f.db, policy, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.newPredicateSqlizer(policy)
 }
 
 func (f *predicateSqlizerFactory) newComparisonPredicateSqlizer(p skydb.Predicate) (sq.Sqlizer, error) {
@@ -387,6 +449,15 @@ type accessPredicateSqlizer struct {
 func (p accessPredicateSqlizer) ToSql() (string, []interface{}, error) {
 	var b bytes.Buffer
 	b.WriteString(`(`)
+	args := p.writeGrants(&b)
+	b.WriteString(`_access IS NULL)`)
+
+	return b.String(), args, nil
+}
+
+// writeGrants writes the expressions matching ACE that grants access to
+// the user, each followed by an OR.
+func (p accessPredicateSqlizer) writeGrants(b *bytes.Buffer) []interface{} {
 	args := []interface{}{}
 
 	if p.user != nil {
@@ -399,7 +470,7 @@ func (p accessPredicateSqlizer) ToSql() (string, []interface{}, error) {
 			panic("unexpected serialize error on user_id")
 		}
 
-		for _, role := range p.user.Roles {
+		for _, role := range p.user.EffectiveRoles() {
 			escapedRole, err := json.Marshal(role)
 			if err != nil {
 				panic("unexpected serialize error on role")
@@ -418,7 +489,52 @@ func (p accessPredicateSqlizer) ToSql() (string, []interface{}, error) {
 		b.WriteString(`_access @> '[{"public": true, "level": "write"}]' OR `)
 	}
 
-	b.WriteString(`_access IS NULL)`)
+	return args
+}
+
+// aclParentAccessSqlizer extends accessPredicateSqlizer such that a record
+// without ACL is accessible only if the record referenced by its ACL
+// parent field is accessible.
+//
+// The sql for record of type comment with ACL parent field post
+// `(... OR ("comment"."_access" IS NULL AND ("comment"."post" IS NULL OR
+// EXISTS (SELECT 1 FROM "post" AS "_acl_parent_1" WHERE
+// "_acl_parent_1"."_id" = "comment"."post" AND ...))))`
+//
+// parent is nil when the maximum depth of ACL parents is reached, such
+// that record referencing an ACL parent is not accessible.
+type aclParentAccessSqlizer struct {
+	accessPredicateSqlizer
+	alias       string
+	field       string
+	parentTable string
+	parentAlias string
+	parent      sq.Sqlizer
+}
+
+func (p aclParentAccessSqlizer) ToSql() (string, []interface{}, error) {
+	var b bytes.Buffer
+	b.WriteString(`(`)
+	args := p.writeGrants(&b)
+	b.WriteString(fmt.Sprintf(`(%s IS NULL AND (%s IS NULL`,
+		fullQuoteIdentifier(p.alias, "_access"),
+		fullQuoteIdentifier(p.alias, p.field)))
+
+	if p.parent != nil {
+		parentSQL, parentArgs, err := p.parent.ToSql()
+		if err != nil {
+			return "", nil, err
+		}
+		b.WriteString(fmt.Sprintf(` OR EXISTS (SELECT 1 FROM %s AS %s WHERE %s = %s AND %s = '' AND %s)`,
+			p.parentTable,
+			pq.QuoteIdentifier(p.parentAlias),
+			fullQuoteIdentifier(p.parentAlias, "_id"),
+			fullQuoteIdentifier(p.alias, p.field),
+			fullQuoteIdentifier(p.parentAlias, "_database_id"),
+			parentSQL))
+		args = append(args, parentArgs...)
+	}
+	b.WriteString(`)))`)
 
 	return b.String(), args, nil
 }
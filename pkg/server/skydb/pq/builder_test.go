@@ -379,6 +379,56 @@ func TestAccessPredicateSqlizer(t *testing.T) {
 	})
 }
 
+func TestACLParentAccessSqlizer(t *testing.T) {
+	Convey("ACL parent access predicate", t, func() {
+		userinfo := skydb.UserInfo{
+			ID: "userid",
+		}
+
+		Convey("serialized with parent", func() {
+			sqlizer := &aclParentAccessSqlizer{
+				accessPredicateSqlizer: accessPredicateSqlizer{&userinfo, skydb.ReadLevel},
+				alias:                  "comment",
+				field:                  "post",
+				parentTable:            `"app"."post"`,
+				parentAlias:            "_acl_parent_1",
+				parent:                 &accessPredicateSqlizer{&userinfo, skydb.ReadLevel},
+			}
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual,
+				`(_access @> '[{"user_id": "userid"}]' OR `+
+					`_owner_id = ? OR `+
+					`_access @> '[{"public": true}]' OR `+
+					`("comment"."_access" IS NULL AND ("comment"."post" IS NULL OR `+
+					`EXISTS (SELECT 1 FROM "app"."post" AS "_acl_parent_1" WHERE `+
+					`"_acl_parent_1"."_id" = "comment"."post" AND `+
+					`"_acl_parent_1"."_database_id" = '' AND `+
+					`(_access @> '[{"user_id": "userid"}]' OR `+
+					`_owner_id = ? OR `+
+					`_access @> '[{"public": true}]' OR `+
+					`_access IS NULL)))))`)
+			So(args, ShouldResemble, []interface{}{"userid", "userid"})
+		})
+
+		Convey("serialized when maximum depth is reached", func() {
+			sqlizer := &aclParentAccessSqlizer{
+				accessPredicateSqlizer: accessPredicateSqlizer{nil, skydb.ReadLevel},
+				alias:                  "comment",
+				field:                  "post",
+				parentTable:            `"app"."post"`,
+				parentAlias:            "_acl_parent_0",
+			}
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual,
+				`(_access @> '[{"public": true}]' OR `+
+					`("comment"."_access" IS NULL AND ("comment"."post" IS NULL)))`)
+			So(args, ShouldResemble, []interface{}{})
+		})
+	})
+}
+
 func TestDistancePredicateSqlizer(t *testing.T) {
 	Convey("distance predicate", t, func() {
 		Convey("serialized", func() {
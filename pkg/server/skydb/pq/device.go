@@ -18,25 +18,31 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	sq "github.com/lann/squirrel"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 )
 
 func (c *conn) GetDevice(id string, device *skydb.Device) error {
-	builder := psql.Select("type", "token", "user_id", "topic", "last_registered_at").
+	builder := psql.Select("type", "token", "user_id", "topic", "last_registered_at", "keys", "locale").
 		From(c.tableName("_device")).
 		Where("id = ?", id)
 
 	nullableToken := sql.NullString{}
 	nullableTopic := sql.NullString{}
 	nullableUserID := sql.NullString{}
+	nullableKeys := nullJSONStringMap{}
+	nullableLocale := sql.NullString{}
 	err := c.QueryRowWith(builder).Scan(
 		&device.Type,
 		&nullableToken,
 		&nullableUserID,
 		&nullableTopic,
 		&device.LastRegisteredAt,
+		&nullableKeys,
+		&nullableLocale,
 	)
 
 	if err == sql.ErrNoRows {
@@ -48,6 +54,8 @@ func (c *conn) GetDevice(id string, device *skydb.Device) error {
 	device.Token = nullableToken.String
 	device.Topic = nullableTopic.String
 	device.UserInfoID = nullableUserID.String
+	device.Keys = nullableKeys.m
+	device.Locale = nullableLocale.String
 	device.LastRegisteredAt = device.LastRegisteredAt.In(time.UTC)
 	device.ID = id
 
@@ -55,7 +63,7 @@ func (c *conn) GetDevice(id string, device *skydb.Device) error {
 }
 
 func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
-	builder := psql.Select("id", "type", "token", "user_id", "topic", "last_registered_at").
+	builder := psql.Select("id", "type", "token", "user_id", "topic", "last_registered_at", "keys", "locale").
 		From(c.tableName("_device")).
 		Where("user_id = ?", user)
 
@@ -68,6 +76,8 @@ func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
 	for rows.Next() {
 		nullableToken := sql.NullString{}
 		nullableTopic := sql.NullString{}
+		nullableKeys := nullJSONStringMap{}
+		nullableLocale := sql.NullString{}
 		d := skydb.Device{}
 		if err := rows.Scan(
 			&d.ID,
@@ -75,12 +85,16 @@ func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
 			&nullableToken,
 			&d.UserInfoID,
 			&nullableTopic,
-			&d.LastRegisteredAt); err != nil {
+			&d.LastRegisteredAt,
+			&nullableKeys,
+			&nullableLocale); err != nil {
 
 			panic(err)
 		}
 		d.Token = nullableToken.String
 		d.Topic = nullableTopic.String
+		d.Keys = nullableKeys.m
+		d.Locale = nullableLocale.String
 		d.LastRegisteredAt = d.LastRegisteredAt.UTC()
 		results = append(results, d)
 	}
@@ -89,7 +103,7 @@ func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
 }
 
 func (c *conn) QueryDevicesByUserAndTopic(user, topic string) ([]skydb.Device, error) {
-	builder := psql.Select("id", "type", "token", "user_id", "topic", "last_registered_at").
+	builder := psql.Select("id", "type", "token", "user_id", "topic", "last_registered_at", "keys", "locale").
 		From(c.tableName("_device")).
 		Where("user_id = ? AND topic = ?", user, topic)
 
@@ -101,6 +115,8 @@ func (c *conn) QueryDevicesByUserAndTopic(user, topic string) ([]skydb.Device, e
 	results := []skydb.Device{}
 	for rows.Next() {
 		var nullableToken sql.NullString
+		nullableKeys := nullJSONStringMap{}
+		nullableLocale := sql.NullString{}
 		d := skydb.Device{}
 		if err := rows.Scan(
 			&d.ID,
@@ -108,11 +124,15 @@ func (c *conn) QueryDevicesByUserAndTopic(user, topic string) ([]skydb.Device, e
 			&nullableToken,
 			&d.UserInfoID,
 			&d.Topic,
-			&d.LastRegisteredAt); err != nil {
+			&d.LastRegisteredAt,
+			&nullableKeys,
+			&nullableLocale); err != nil {
 
 			panic(err)
 		}
 		d.Token = nullableToken.String
+		d.Keys = nullableKeys.m
+		d.Locale = nullableLocale.String
 		d.LastRegisteredAt = d.LastRegisteredAt.UTC()
 		results = append(results, d)
 	}
@@ -144,6 +164,16 @@ func (c *conn) SaveDevice(device *skydb.Device) error {
 		data["topic"] = device.Topic
 	}
 
+	data["locale"] = sql.NullString{
+		String: device.Locale,
+		Valid:  device.Locale != "",
+	}
+
+	data["keys"] = nullJSONStringMap{
+		m:     device.Keys,
+		Valid: device.Keys != nil,
+	}
+
 	upsert := upsertQuery(c.tableName("_device"), pkData, data)
 	_, err := c.ExecWith(upsert)
 	return err
@@ -216,3 +246,109 @@ func (c *conn) DeleteEmptyDevicesByTime(t time.Time) error {
 
 	return nil
 }
+
+func (c *conn) SubscribeDeviceTopics(deviceID string, topics []string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+
+	values := make([]string, len(topics))
+	args := []interface{}{deviceID}
+	for i, topic := range topics {
+		values[i] = fmt.Sprintf("($1, $%d)", i+2)
+		args = append(args, topic)
+	}
+
+	_, err := c.Exec(fmt.Sprintf(
+		"INSERT INTO %s (device_id, topic) VALUES %s ON CONFLICT DO NOTHING",
+		c.tableName("_topic_subscription"),
+		strings.Join(values, ", "),
+	), args...)
+	if isForeignKeyViolated(err) {
+		return skydb.ErrDeviceNotFound
+	}
+	return err
+}
+
+func (c *conn) UnsubscribeDeviceTopics(deviceID string, topics []string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+
+	builder := psql.Delete(c.tableName("_topic_subscription")).
+		Where("device_id = ?", deviceID).
+		Where(sq.Eq{"topic": topics})
+	_, err := c.ExecWith(builder)
+	return err
+}
+
+func (c *conn) GetDeviceTopics(deviceID string) ([]string, error) {
+	builder := psql.Select("topic").
+		From(c.tableName("_topic_subscription")).
+		Where("device_id = ?", deviceID).
+		OrderBy("topic")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	topics := []string{}
+	for rows.Next() {
+		var topic string
+		if err := rows.Scan(&topic); err != nil {
+			return nil, err
+		}
+		topics = append(topics, topic)
+	}
+	return topics, rows.Err()
+}
+
+func (c *conn) QueryDevicesByTopic(topic string, afterID string, limit int) ([]skydb.Device, error) {
+	// paging by device id makes use of the (topic, device_id) primary key,
+	// so that each page is an index range scan
+	builder := psql.Select("d.id", "d.type", "d.token", "d.user_id", "d.topic", "d.last_registered_at", "d.keys", "d.locale").
+		From(c.tableName("_topic_subscription")+" AS s").
+		Join(c.tableName("_device")+" AS d ON d.id = s.device_id").
+		Where("s.topic = ? AND s.device_id > ?", topic, afterID).
+		OrderBy("s.device_id").
+		Limit(uint64(limit))
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []skydb.Device{}
+	for rows.Next() {
+		nullableToken := sql.NullString{}
+		nullableUserID := sql.NullString{}
+		nullableTopic := sql.NullString{}
+		nullableKeys := nullJSONStringMap{}
+		nullableLocale := sql.NullString{}
+		d := skydb.Device{}
+		if err := rows.Scan(
+			&d.ID,
+			&d.Type,
+			&nullableToken,
+			&nullableUserID,
+			&nullableTopic,
+			&d.LastRegisteredAt,
+			&nullableKeys,
+			&nullableLocale); err != nil {
+
+			return nil, err
+		}
+		d.Token = nullableToken.String
+		d.UserInfoID = nullableUserID.String
+		d.Topic = nullableTopic.String
+		d.Keys = nullableKeys.m
+		d.Locale = nullableLocale.String
+		d.LastRegisteredAt = d.LastRegisteredAt.UTC()
+		results = append(results, d)
+	}
+
+	return results, rows.Err()
+}
@@ -53,6 +53,55 @@ func TestDevice(t *testing.T) {
 			})
 		})
 
+		Convey("gets an existing Device with keys", func() {
+			device := skydb.Device{
+				ID:               "deviceid",
+				Type:             "web",
+				Token:            "https://push.example.com/send/endpoint",
+				UserInfoID:       "userid",
+				LastRegisteredAt: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+				Keys: map[string]string{
+					"p256dh": "public-key",
+					"auth":   "auth-secret",
+				},
+			}
+			So(c.SaveDevice(&device), ShouldBeNil)
+
+			device = skydb.Device{}
+			err := c.GetDevice("deviceid", &device)
+			So(err, ShouldBeNil)
+			So(device.Keys, ShouldResemble, map[string]string{
+				"p256dh": "public-key",
+				"auth":   "auth-secret",
+			})
+
+			devices, err := c.QueryDevicesByUser("userid")
+			So(err, ShouldBeNil)
+			So(devices, ShouldHaveLength, 1)
+			So(devices[0].Keys, ShouldResemble, device.Keys)
+		})
+
+		Convey("gets an existing Device with locale", func() {
+			device := skydb.Device{
+				ID:               "deviceid",
+				Type:             "android",
+				Token:            "devicetoken",
+				UserInfoID:       "userid",
+				LastRegisteredAt: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+				Locale:           "zh-TW",
+			}
+			So(c.SaveDevice(&device), ShouldBeNil)
+
+			device = skydb.Device{}
+			So(c.GetDevice("deviceid", &device), ShouldBeNil)
+			So(device.Locale, ShouldEqual, "zh-TW")
+
+			devices, err := c.QueryDevicesByUser("userid")
+			So(err, ShouldBeNil)
+			So(devices, ShouldHaveLength, 1)
+			So(devices[0].Locale, ShouldEqual, "zh-TW")
+		})
+
 		Convey("creates a new Device", func() {
 			device := skydb.Device{
 				ID:               "deviceid",
@@ -389,3 +438,73 @@ func TestDevice(t *testing.T) {
 		})
 	})
 }
+
+func TestDeviceTopic(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		addUser(t, c, "userid")
+
+		for _, deviceID := range []string{"device1", "device2", "device3"} {
+			device := skydb.Device{
+				ID:               deviceID,
+				Type:             "ios",
+				Token:            "token-" + deviceID,
+				UserInfoID:       "userid",
+				LastRegisteredAt: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+			}
+			So(c.SaveDevice(&device), ShouldBeNil)
+		}
+
+		Convey("subscribes a device to topics", func() {
+			So(c.SubscribeDeviceTopics("device1", []string{"news", "sports"}), ShouldBeNil)
+			So(c.SubscribeDeviceTopics("device1", []string{"news", "weather"}), ShouldBeNil)
+
+			topics, err := c.GetDeviceTopics("device1")
+			So(err, ShouldBeNil)
+			So(topics, ShouldResemble, []string{"news", "sports", "weather"})
+		})
+
+		Convey("returns ErrDeviceNotFound when subscribing a non-existent device", func() {
+			err := c.SubscribeDeviceTopics("nonexistent", []string{"news"})
+			So(err, ShouldEqual, skydb.ErrDeviceNotFound)
+		})
+
+		Convey("unsubscribes a device from topics", func() {
+			So(c.SubscribeDeviceTopics("device1", []string{"news", "sports"}), ShouldBeNil)
+			So(c.UnsubscribeDeviceTopics("device1", []string{"news", "weather"}), ShouldBeNil)
+
+			topics, err := c.GetDeviceTopics("device1")
+			So(err, ShouldBeNil)
+			So(topics, ShouldResemble, []string{"sports"})
+		})
+
+		Convey("queries devices by topic page by page", func() {
+			So(c.SubscribeDeviceTopics("device3", []string{"news"}), ShouldBeNil)
+			So(c.SubscribeDeviceTopics("device1", []string{"news"}), ShouldBeNil)
+			So(c.SubscribeDeviceTopics("device2", []string{"news"}), ShouldBeNil)
+
+			devices, err := c.QueryDevicesByTopic("news", "", 2)
+			So(err, ShouldBeNil)
+			So(devices, ShouldHaveLength, 2)
+			So(devices[0].ID, ShouldEqual, "device1")
+			So(devices[0].Token, ShouldEqual, "token-device1")
+			So(devices[1].ID, ShouldEqual, "device2")
+
+			devices, err = c.QueryDevicesByTopic("news", "device2", 2)
+			So(err, ShouldBeNil)
+			So(devices, ShouldHaveLength, 1)
+			So(devices[0].ID, ShouldEqual, "device3")
+		})
+
+		Convey("removes subscriptions of a deleted device", func() {
+			So(c.SubscribeDeviceTopics("device1", []string{"news"}), ShouldBeNil)
+			So(c.DeleteDevice("device1"), ShouldBeNil)
+
+			devices, err := c.QueryDevicesByTopic("news", "", 10)
+			So(err, ShouldBeNil)
+			So(devices, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_2a9d4c7e1b38 struct {
+}
+
+func (r *revision_2a9d4c7e1b38) Version() string {
+	return "2a9d4c7e1b38"
+}
+
+func (r *revision_2a9d4c7e1b38) Up(tx *sqlx.Tx) error {
+	stmt := `
+CREATE TABLE _topic_subscription (
+	device_id text REFERENCES _device (id) ON DELETE CASCADE NOT NULL,
+	topic text NOT NULL,
+	PRIMARY KEY(topic, device_id)
+);
+CREATE INDEX ON _topic_subscription (device_id);
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_2a9d4c7e1b38) Down(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`DROP TABLE _topic_subscription;`)
+	return err
+}
@@ -0,0 +1,51 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_3f8a6c2d9e71 struct {
+}
+
+func (r *revision_3f8a6c2d9e71) Version() string {
+	return "3f8a6c2d9e71"
+}
+
+func (r *revision_3f8a6c2d9e71) Up(tx *sqlx.Tx) error {
+	stmt := `
+CREATE TABLE _record_change (
+	id bigserial PRIMARY KEY,
+	database_id text NOT NULL,
+	record_type text NOT NULL,
+	record_id text NOT NULL,
+	event text NOT NULL,
+	owner_id text,
+	access jsonb,
+	changed_at timestamp without time zone NOT NULL
+);
+CREATE INDEX ON _record_change (database_id, record_type, id);
+CREATE INDEX ON _record_change (changed_at);
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_3f8a6c2d9e71) Down(tx *sqlx.Tx) error {
+	stmt := `
+DROP TABLE _record_change;
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
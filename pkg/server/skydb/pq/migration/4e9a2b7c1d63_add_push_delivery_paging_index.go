@@ -0,0 +1,42 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_4e9a2b7c1d63 struct {
+}
+
+func (r *revision_4e9a2b7c1d63) Version() string {
+	return "4e9a2b7c1d63"
+}
+
+func (r *revision_4e9a2b7c1d63) Up(tx *sqlx.Tx) error {
+	stmt := `
+DROP INDEX _push_delivery_notification_id_idx;
+CREATE INDEX ON _push_delivery (notification_id, id);
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_4e9a2b7c1d63) Down(tx *sqlx.Tx) error {
+	stmt := `
+DROP INDEX _push_delivery_notification_id_id_idx;
+CREATE INDEX ON _push_delivery (notification_id);
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
@@ -0,0 +1,44 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_5c1e2f7b9d04 struct {
+}
+
+func (r *revision_5c1e2f7b9d04) Version() string {
+	return "5c1e2f7b9d04"
+}
+
+func (r *revision_5c1e2f7b9d04) Up(tx *sqlx.Tx) error {
+	stmt := `
+ALTER TABLE _role
+	ADD COLUMN parent_id text REFERENCES _role (id) ON DELETE SET NULL,
+	ADD COLUMN permissions jsonb;
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_5c1e2f7b9d04) Down(tx *sqlx.Tx) error {
+	stmt := `
+ALTER TABLE _role
+	DROP COLUMN parent_id,
+	DROP COLUMN permissions;
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
@@ -0,0 +1,47 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_6b0e3d9a4f21 struct {
+}
+
+func (r *revision_6b0e3d9a4f21) Version() string {
+	return "6b0e3d9a4f21"
+}
+
+func (r *revision_6b0e3d9a4f21) Up(tx *sqlx.Tx) error {
+	stmt := `
+ALTER TABLE _device ADD COLUMN locale text;
+CREATE TABLE _push_template (
+	name text PRIMARY KEY,
+	content jsonb NOT NULL,
+	created_at timestamp without time zone NOT NULL,
+	updated_at timestamp without time zone NOT NULL
+);
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_6b0e3d9a4f21) Down(tx *sqlx.Tx) error {
+	stmt := `
+DROP TABLE _push_template;
+ALTER TABLE _device DROP COLUMN locale;
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
@@ -0,0 +1,46 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_7c4e1a9b3d52 struct {
+}
+
+func (r *revision_7c4e1a9b3d52) Version() string {
+	return "7c4e1a9b3d52"
+}
+
+func (r *revision_7c4e1a9b3d52) Up(tx *sqlx.Tx) error {
+	stmt := `
+CREATE TABLE _pubsub_channel_rule (
+	channel text PRIMARY KEY,
+	subscribe jsonb NOT NULL,
+	publish jsonb NOT NULL,
+	created_at timestamp without time zone NOT NULL,
+	updated_at timestamp without time zone NOT NULL
+);
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_7c4e1a9b3d52) Down(tx *sqlx.Tx) error {
+	stmt := `
+DROP TABLE _pubsub_channel_rule;
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
@@ -0,0 +1,41 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_8e3b0d51c6a2 struct {
+}
+
+func (r *revision_8e3b0d51c6a2) Version() string {
+	return "8e3b0d51c6a2"
+}
+
+func (r *revision_8e3b0d51c6a2) Up(tx *sqlx.Tx) error {
+	stmt := `
+CREATE TABLE _record_acl_parent (
+	record_type text PRIMARY KEY,
+	field_name text NOT NULL,
+	max_depth integer NOT NULL DEFAULT 0
+);
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_8e3b0d51c6a2) Down(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`DROP TABLE _record_acl_parent;`)
+	return err
+}
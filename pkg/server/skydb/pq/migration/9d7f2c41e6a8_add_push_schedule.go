@@ -0,0 +1,60 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_9d7f2c41e6a8 struct {
+}
+
+func (r *revision_9d7f2c41e6a8) Version() string {
+	return "9d7f2c41e6a8"
+}
+
+func (r *revision_9d7f2c41e6a8) Up(tx *sqlx.Tx) error {
+	stmt := `
+ALTER TABLE _push_delivery ADD COLUMN collapse_key text;
+CREATE INDEX ON _push_delivery (device_id, collapse_key) WHERE state = 'queued';
+CREATE TABLE _push_schedule (
+	id text PRIMARY KEY,
+	notification_id text NOT NULL,
+	user_ids jsonb,
+	device_ids jsonb,
+	topic text,
+	notification jsonb,
+	template text,
+	context jsonb,
+	collapse_key text,
+	urgent boolean NOT NULL DEFAULT FALSE,
+	state text NOT NULL,
+	send_at timestamp without time zone NOT NULL,
+	created_at timestamp without time zone NOT NULL,
+	updated_at timestamp without time zone NOT NULL
+);
+CREATE INDEX ON _push_schedule (notification_id);
+CREATE INDEX ON _push_schedule (send_at) WHERE state = 'scheduled';
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_9d7f2c41e6a8) Down(tx *sqlx.Tx) error {
+	stmt := `
+DROP TABLE _push_schedule;
+ALTER TABLE _push_delivery DROP COLUMN collapse_key;
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
@@ -0,0 +1,34 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_c7e2a9f4b613 struct {
+}
+
+func (r *revision_c7e2a9f4b613) Version() string {
+	return "c7e2a9f4b613"
+}
+
+func (r *revision_c7e2a9f4b613) Up(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE _device ADD COLUMN keys jsonb;`)
+	return err
+}
+
+func (r *revision_c7e2a9f4b613) Down(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE _device DROP COLUMN keys;`)
+	return err
+}
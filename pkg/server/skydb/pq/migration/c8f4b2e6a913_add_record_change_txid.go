@@ -0,0 +1,44 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_c8f4b2e6a913 struct {
+}
+
+func (r *revision_c8f4b2e6a913) Version() string {
+	return "c8f4b2e6a913"
+}
+
+func (r *revision_c8f4b2e6a913) Up(tx *sqlx.Tx) error {
+	stmt := `
+ALTER TABLE _record_change ADD COLUMN txid bigint NOT NULL DEFAULT txid_current();
+DROP INDEX _record_change_database_id_record_type_id_idx;
+CREATE INDEX ON _record_change (database_id, record_type, txid, id);
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_c8f4b2e6a913) Down(tx *sqlx.Tx) error {
+	stmt := `
+DROP INDEX _record_change_database_id_record_type_txid_id_idx;
+CREATE INDEX ON _record_change (database_id, record_type, id);
+ALTER TABLE _record_change DROP COLUMN txid;
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
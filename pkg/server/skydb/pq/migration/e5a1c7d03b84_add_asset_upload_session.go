@@ -0,0 +1,51 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_e5a1c7d03b84 struct {
+}
+
+func (r *revision_e5a1c7d03b84) Version() string {
+	return "e5a1c7d03b84"
+}
+
+func (r *revision_e5a1c7d03b84) Up(tx *sqlx.Tx) error {
+	stmt := `
+CREATE TABLE _asset_upload_session (
+	id text PRIMARY KEY,
+	asset_name text NOT NULL,
+	content_type text NOT NULL,
+	size bigint NOT NULL,
+	upload_id text NOT NULL,
+	uploaded_size bigint NOT NULL,
+	chunks integer NOT NULL,
+	user_id text,
+	created_at timestamp without time zone NOT NULL,
+	updated_at timestamp without time zone NOT NULL
+);
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_e5a1c7d03b84) Down(tx *sqlx.Tx) error {
+	stmt := `
+DROP TABLE _asset_upload_session;
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
@@ -0,0 +1,40 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_f2d8b5a1c364 struct {
+}
+
+func (r *revision_f2d8b5a1c364) Version() string {
+	return "f2d8b5a1c364"
+}
+
+func (r *revision_f2d8b5a1c364) Up(tx *sqlx.Tx) error {
+	stmt := `
+ALTER TABLE _asset_upload_session ADD COLUMN locked_until timestamp without time zone;
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_f2d8b5a1c364) Down(tx *sqlx.Tx) error {
+	stmt := `
+ALTER TABLE _asset_upload_session DROP COLUMN locked_until;
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
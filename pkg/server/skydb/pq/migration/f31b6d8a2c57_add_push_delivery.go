@@ -0,0 +1,52 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_f31b6d8a2c57 struct {
+}
+
+func (r *revision_f31b6d8a2c57) Version() string {
+	return "f31b6d8a2c57"
+}
+
+func (r *revision_f31b6d8a2c57) Up(tx *sqlx.Tx) error {
+	stmt := `
+CREATE TABLE _push_delivery (
+	id text PRIMARY KEY,
+	notification_id text NOT NULL,
+	device_id text NOT NULL,
+	device_type text NOT NULL,
+	device_token text,
+	notification jsonb NOT NULL,
+	state text NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	last_error text,
+	next_attempt_at timestamp without time zone NOT NULL,
+	created_at timestamp without time zone NOT NULL,
+	updated_at timestamp without time zone NOT NULL
+);
+CREATE INDEX ON _push_delivery (notification_id);
+CREATE INDEX ON _push_delivery (next_attempt_at) WHERE state = 'queued';
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_f31b6d8a2c57) Down(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`DROP TABLE _push_delivery;`)
+	return err
+}
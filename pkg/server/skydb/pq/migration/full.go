@@ -25,7 +25,7 @@ import (
 type fullMigration struct {
 }
 
-func (r *fullMigration) Version() string { return "1981535c8aeb" }
+func (r *fullMigration) Version() string { return "f2d8b5a1c364" }
 
 func (r *fullMigration) createTable(tx *sqlx.Tx) error {
 	const stmt = `
@@ -72,7 +72,9 @@ CREATE TABLE _user (
 CREATE TABLE _role (
 	id text PRIMARY KEY,
 	by_default boolean DEFAULT FALSE,
-	is_admin boolean DEFAULT FALSE
+	is_admin boolean DEFAULT FALSE,
+	parent_id text REFERENCES _role (id) ON DELETE SET NULL,
+	permissions jsonb
 );
 
 CREATE TABLE _user_role (
@@ -84,8 +86,10 @@ CREATE TABLE _user_role (
 CREATE TABLE _asset (
 	id text PRIMARY KEY,
 	content_type text NOT NULL,
-	size bigint NOT NULL
+	size bigint NOT NULL,
+	created_at timestamp without time zone NOT NULL DEFAULT (now() AT TIME ZONE 'UTC')
 );
+CREATE INDEX ON _asset (created_at);
 CREATE TABLE _device (
 	id text PRIMARY KEY,
 	user_id text REFERENCES _user (id),
@@ -93,6 +97,8 @@ CREATE TABLE _device (
 	token text,
 	topic text,
 	last_registered_at timestamp without time zone NOT NULL,
+	keys jsonb,
+	locale text,
 	UNIQUE (user_id, type, token)
 );
 CREATE INDEX ON _device (token, last_registered_at);
@@ -122,6 +128,97 @@ CREATE TABLE _record_creation (
     FOREIGN KEY (role_id) REFERENCES _role(id)
 );
 CREATE INDEX _record_creation_unique_record_type ON _record_creation (record_type);
+CREATE TABLE _record_policy (
+	record_type text PRIMARY KEY,
+	predicate jsonb NOT NULL
+);
+CREATE TABLE _record_acl_parent (
+	record_type text PRIMARY KEY,
+	field_name text NOT NULL,
+	max_depth integer NOT NULL DEFAULT 0
+);
+CREATE TABLE _push_delivery (
+	id text PRIMARY KEY,
+	notification_id text NOT NULL,
+	device_id text NOT NULL,
+	device_type text NOT NULL,
+	device_token text,
+	notification jsonb NOT NULL,
+	state text NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	last_error text,
+	next_attempt_at timestamp without time zone NOT NULL,
+	created_at timestamp without time zone NOT NULL,
+	updated_at timestamp without time zone NOT NULL,
+	collapse_key text
+);
+CREATE INDEX ON _push_delivery (notification_id, id);
+CREATE INDEX ON _push_delivery (next_attempt_at) WHERE state = 'queued';
+CREATE INDEX ON _push_delivery (device_id, collapse_key) WHERE state = 'queued';
+CREATE TABLE _topic_subscription (
+	device_id text REFERENCES _device (id) ON DELETE CASCADE NOT NULL,
+	topic text NOT NULL,
+	PRIMARY KEY(topic, device_id)
+);
+CREATE INDEX ON _topic_subscription (device_id);
+CREATE TABLE _push_template (
+	name text PRIMARY KEY,
+	content jsonb NOT NULL,
+	created_at timestamp without time zone NOT NULL,
+	updated_at timestamp without time zone NOT NULL
+);
+CREATE TABLE _push_schedule (
+	id text PRIMARY KEY,
+	notification_id text NOT NULL,
+	user_ids jsonb,
+	device_ids jsonb,
+	topic text,
+	notification jsonb,
+	template text,
+	context jsonb,
+	collapse_key text,
+	urgent boolean NOT NULL DEFAULT FALSE,
+	state text NOT NULL,
+	send_at timestamp without time zone NOT NULL,
+	created_at timestamp without time zone NOT NULL,
+	updated_at timestamp without time zone NOT NULL,
+	locked_until timestamp without time zone
+);
+CREATE INDEX ON _push_schedule (notification_id);
+CREATE INDEX ON _push_schedule (send_at) WHERE state = 'scheduled';
+CREATE TABLE _record_change (
+	id bigserial PRIMARY KEY,
+	database_id text NOT NULL,
+	record_type text NOT NULL,
+	record_id text NOT NULL,
+	event text NOT NULL,
+	owner_id text,
+	access jsonb,
+	changed_at timestamp without time zone NOT NULL,
+	txid bigint NOT NULL DEFAULT txid_current()
+);
+CREATE INDEX ON _record_change (database_id, record_type, txid, id);
+CREATE INDEX ON _record_change (changed_at);
+CREATE TABLE _pubsub_channel_rule (
+	channel text PRIMARY KEY,
+	subscribe jsonb NOT NULL,
+	publish jsonb NOT NULL,
+	created_at timestamp without time zone NOT NULL,
+	updated_at timestamp without time zone NOT NULL
+);
+CREATE TABLE _asset_upload_session (
+	id text PRIMARY KEY,
+	asset_name text NOT NULL,
+	content_type text NOT NULL,
+	size bigint NOT NULL,
+	upload_id text NOT NULL,
+	uploaded_size bigint NOT NULL,
+	chunks integer NOT NULL,
+	user_id text,
+	created_at timestamp without time zone NOT NULL,
+	updated_at timestamp without time zone NOT NULL,
+	locked_until timestamp without time zone
+);
 `
 	_, err := tx.Exec(stmt)
 	return err
@@ -28,4 +28,20 @@ var revisions = []Revision{
 	&revision_88a550bf579{},
 	&revision_db76e79e987{},
 	&revision_1981535c8aeb{},
+	&revision_a498057b3bd3{},
+	&revision_5c1e2f7b9d04{},
+	&revision_8e3b0d51c6a2{},
+	&revision_c7e2a9f4b613{},
+	&revision_f31b6d8a2c57{},
+	&revision_2a9d4c7e1b38{},
+	&revision_6b0e3d9a4f21{},
+	&revision_9d7f2c41e6a8{},
+	&revision_3f8a6c2d9e71{},
+	&revision_7c4e1a9b3d52{},
+	&revision_e5a1c7d03b84{},
+	&revision_b2f6e8d4a197{},
+	&revision_4e9a2b7c1d63{},
+	&revision_a5c3e9d1f742{},
+	&revision_c8f4b2e6a913{},
+	&revision_f2d8b5a1c364{},
 }
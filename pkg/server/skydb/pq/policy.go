@@ -0,0 +1,112 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// policyValue implements sql.Valuer and sql.Scanner s.t. a record policy
+// can be saved into and recovered from postgresql.
+//
+// CurrentUserFunc is the only function allowed in record policy, so every
+// functional expression is recovered as CurrentUserFunc.
+type policyValue skydb.Predicate
+
+func (policy policyValue) Value() (driver.Value, error) {
+	return json.Marshal(skydb.Predicate(policy))
+}
+
+func (policy *policyValue) Scan(value interface{}) error {
+	if value == nil {
+		*policy = policyValue{}
+		return nil
+	}
+
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("skydb: unsupported Scan pair: %T -> %T", value, policy)
+	}
+
+	var p jsonPredicate
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+
+	*policy = policyValue(restoreCurrentUserFunc(skydb.Predicate(p)))
+	return nil
+}
+
+func restoreCurrentUserFunc(p skydb.Predicate) skydb.Predicate {
+	for i, child := range p.Children {
+		switch child := child.(type) {
+		case skydb.Predicate:
+			p.Children[i] = restoreCurrentUserFunc(child)
+		case skydb.Expression:
+			if child.Type != skydb.Function {
+				continue
+			}
+
+			f := skydb.CurrentUserFunc{}
+			if m, ok := child.Value.(map[string]interface{}); ok {
+				f.KeyPath, _ = m["KeyPath"].(string)
+			}
+			p.Children[i] = skydb.Expression{
+				Type:  skydb.Function,
+				Value: f,
+			}
+		}
+	}
+	return p
+}
+
+func (c *conn) SetRecordPolicy(recordType string, policy skydb.Predicate) error {
+	if policy.IsEmpty() {
+		builder := psql.Delete(c.tableName("_record_policy")).
+			Where(sq.Eq{"record_type": recordType})
+		_, err := c.ExecWith(builder)
+		return err
+	}
+
+	builder := upsertQuery(c.tableName("_record_policy"), map[string]interface{}{
+		"record_type": recordType,
+	}, map[string]interface{}{
+		"predicate": policyValue(policy),
+	})
+	_, err := c.ExecWith(builder)
+	return err
+}
+
+func (c *conn) GetRecordPolicy(recordType string) (skydb.Predicate, error) {
+	builder := psql.Select("predicate").
+		From(c.tableName("_record_policy")).
+		Where(sq.Eq{"record_type": recordType})
+
+	var policy policyValue
+	err := c.QueryRowWith(builder).Scan(&policy)
+	if err == sql.ErrNoRows {
+		return skydb.Predicate{}, nil
+	} else if err != nil {
+		return skydb.Predicate{}, err
+	}
+
+	return skydb.Predicate(policy), nil
+}
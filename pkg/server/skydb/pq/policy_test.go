@@ -0,0 +1,228 @@
+package pq
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordPolicy(t *testing.T) {
+	var c *conn
+
+	Convey("RecordPolicy", t, func() {
+		c = getTestConn(t)
+		defer cleanupConn(t, c)
+
+		policy := skydb.Predicate{
+			Operator: skydb.Or,
+			Children: []interface{}{
+				skydb.Predicate{
+					Operator: skydb.Equal,
+					Children: []interface{}{
+						skydb.Expression{Type: skydb.KeyPath, Value: "_owner_id"},
+						skydb.Expression{Type: skydb.Function, Value: skydb.CurrentUserFunc{}},
+					},
+				},
+				skydb.Predicate{
+					Operator: skydb.Equal,
+					Children: []interface{}{
+						skydb.Expression{Type: skydb.KeyPath, Value: "org"},
+						skydb.Expression{Type: skydb.Function, Value: skydb.CurrentUserFunc{KeyPath: "org"}},
+					},
+				},
+			},
+		}
+
+		Convey("gets empty policy", func() {
+			p, err := c.GetRecordPolicy("note")
+			So(err, ShouldBeNil)
+			So(p.IsEmpty(), ShouldBeTrue)
+		})
+
+		Convey("sets and gets policy", func() {
+			So(c.SetRecordPolicy("note", policy), ShouldBeNil)
+
+			p, err := c.GetRecordPolicy("note")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, policy)
+		})
+
+		Convey("removes policy", func() {
+			So(c.SetRecordPolicy("note", policy), ShouldBeNil)
+			So(c.SetRecordPolicy("note", skydb.Predicate{}), ShouldBeNil)
+
+			p, err := c.GetRecordPolicy("note")
+			So(err, ShouldBeNil)
+			So(p.IsEmpty(), ShouldBeTrue)
+		})
+
+		Convey("filters query by policy", func() {
+			addUser(t, c, "user0")
+			addUser(t, c, "user1")
+
+			db := c.PublicDB()
+			_, err := db.Extend("user", skydb.RecordSchema{
+				"org": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+			_, err = db.Extend("note", skydb.RecordSchema{
+				"org": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("user", "user0"),
+				OwnerID: "user0",
+				Data:    map[string]interface{}{"org": "oursky"},
+			}), ShouldBeNil)
+
+			mine := skydb.Record{
+				ID:      skydb.NewRecordID("note", "mine"),
+				OwnerID: "user0",
+				Data:    map[string]interface{}{"org": "skygear"},
+			}
+			sameOrg := skydb.Record{
+				ID:      skydb.NewRecordID("note", "sameOrg"),
+				OwnerID: "user1",
+				Data:    map[string]interface{}{"org": "oursky"},
+			}
+			others := skydb.Record{
+				ID:      skydb.NewRecordID("note", "others"),
+				OwnerID: "user1",
+				Data:    map[string]interface{}{"org": "skygear"},
+			}
+			So(db.Save(&mine), ShouldBeNil)
+			So(db.Save(&sameOrg), ShouldBeNil)
+			So(db.Save(&others), ShouldBeNil)
+
+			So(c.SetRecordPolicy("note", policy), ShouldBeNil)
+
+			query := skydb.Query{
+				Type: "note",
+				Sorts: []skydb.Sort{
+					skydb.Sort{
+						KeyPath: "_id",
+						Order:   skydb.Ascending,
+					},
+				},
+				ViewAsUser: &skydb.UserInfo{ID: "user0"},
+			}
+			records, err := exhaustRows(db.Query(&query))
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 2)
+			So(records[0].ID, ShouldResemble, mine.ID)
+			So(records[1].ID, ShouldResemble, sameOrg.ID)
+
+			query.ViewAsUser = nil
+			records, err = exhaustRows(db.Query(&query))
+			So(err, ShouldBeNil)
+			So(records, ShouldBeEmpty)
+
+			query.ViewAsUser = &skydb.UserInfo{ID: "user0"}
+			query.BypassAccessControl = true
+			records, err = exhaustRows(db.Query(&query))
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 3)
+		})
+
+		Convey("filters query by owner when the user record has no such field", func() {
+			addUser(t, c, "user0")
+			addUser(t, c, "user1")
+
+			db := c.PublicDB()
+			_, err := db.Extend("user", skydb.RecordSchema{
+				"org": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+			_, err = db.Extend("note", skydb.RecordSchema{
+				"org": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("user", "user0"),
+				OwnerID: "user0",
+			}), ShouldBeNil)
+
+			mine := skydb.Record{
+				ID:      skydb.NewRecordID("note", "mine"),
+				OwnerID: "user0",
+				Data:    map[string]interface{}{"org": "oursky"},
+			}
+			others := skydb.Record{
+				ID:      skydb.NewRecordID("note", "others"),
+				OwnerID: "user1",
+				Data:    map[string]interface{}{"org": "oursky"},
+			}
+			So(db.Save(&mine), ShouldBeNil)
+			So(db.Save(&others), ShouldBeNil)
+
+			So(c.SetRecordPolicy("note", policy), ShouldBeNil)
+
+			query := skydb.Query{
+				Type:       "note",
+				ViewAsUser: &skydb.UserInfo{ID: "user0"},
+			}
+			records, err := exhaustRows(db.Query(&query))
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 1)
+			So(records[0].ID, ShouldResemble, mine.ID)
+		})
+
+		Convey("filters query by public or owner policy without user", func() {
+			addUser(t, c, "user0")
+
+			db := c.PublicDB()
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"public": skydb.FieldType{Type: skydb.TypeBoolean},
+			})
+			So(err, ShouldBeNil)
+
+			public := skydb.Record{
+				ID:      skydb.NewRecordID("note", "public"),
+				OwnerID: "user0",
+				Data:    map[string]interface{}{"public": true},
+			}
+			private := skydb.Record{
+				ID:      skydb.NewRecordID("note", "private"),
+				OwnerID: "user0",
+				Data:    map[string]interface{}{"public": false},
+			}
+			So(db.Save(&public), ShouldBeNil)
+			So(db.Save(&private), ShouldBeNil)
+
+			ownerPolicy := policy.Children[0].(skydb.Predicate)
+			So(c.SetRecordPolicy("note", skydb.Predicate{
+				Operator: skydb.Or,
+				Children: []interface{}{
+					skydb.Predicate{
+						Operator: skydb.Equal,
+						Children: []interface{}{
+							skydb.Expression{Type: skydb.KeyPath, Value: "public"},
+							skydb.Expression{Type: skydb.Literal, Value: true},
+						},
+					},
+					ownerPolicy,
+				},
+			}), ShouldBeNil)
+
+			query := skydb.Query{
+				Type: "note",
+			}
+			records, err := exhaustRows(db.Query(&query))
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 1)
+			So(records[0].ID, ShouldResemble, public.ID)
+
+			So(c.SetRecordPolicy("note", skydb.Predicate{
+				Operator: skydb.Not,
+				Children: []interface{}{ownerPolicy},
+			}), ShouldBeNil)
+
+			records, err = exhaustRows(db.Query(&query))
+			So(err, ShouldBeNil)
+			So(records, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) SaveChannelRule(rule *skydb.ChannelRule) error {
+	if rule.Channel == "" || rule.CreatedAt.IsZero() {
+		return errors.New("invalid channel rule: empty channel or created at")
+	}
+
+	subscribe, err := json.Marshal(rule.Subscribe)
+	if err != nil {
+		return err
+	}
+	publish, err := json.Marshal(rule.Publish)
+	if err != nil {
+		return err
+	}
+
+	updatedAt := rule.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = rule.CreatedAt
+	}
+
+	pkData := map[string]interface{}{"channel": rule.Channel}
+	data := map[string]interface{}{
+		"subscribe":  subscribe,
+		"publish":    publish,
+		"created_at": rule.CreatedAt.UTC(),
+		"updated_at": updatedAt.UTC(),
+	}
+
+	upsert := upsertQuery(c.tableName("_pubsub_channel_rule"), pkData, data).
+		IgnoreKeyOnUpdate("created_at")
+	_, err = c.ExecWith(upsert)
+	return err
+}
+
+func (c *conn) GetChannelRules() ([]skydb.ChannelRule, error) {
+	builder := psql.Select("channel", "subscribe", "publish", "created_at", "updated_at").
+		From(c.tableName("_pubsub_channel_rule")).
+		OrderBy("channel")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []skydb.ChannelRule{}
+	for rows.Next() {
+		rule := skydb.ChannelRule{}
+		var subscribe, publish []byte
+		if err := rows.Scan(&rule.Channel, &subscribe, &publish, &rule.CreatedAt, &rule.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(subscribe, &rule.Subscribe); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(publish, &rule.Publish); err != nil {
+			return nil, err
+		}
+		rule.CreatedAt = rule.CreatedAt.UTC()
+		rule.UpdatedAt = rule.UpdatedAt.UTC()
+		results = append(results, rule)
+	}
+
+	return results, rows.Err()
+}
+
+func (c *conn) DeleteChannelRule(channel string) error {
+	builder := psql.Delete(c.tableName("_pubsub_channel_rule")).
+		Where("channel = ?", channel)
+
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrChannelRuleNotFound
+	}
+
+	return nil
+}
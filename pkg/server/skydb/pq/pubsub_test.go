@@ -0,0 +1,74 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChannelRule(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		createdAt := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		rule := skydb.ChannelRule{
+			Channel: "chat/*",
+			Subscribe: skydb.ChannelAccess{
+				Level: skydb.ChannelAccessAuthenticated,
+				Roles: []string{"member"},
+			},
+			Publish: skydb.ChannelAccess{
+				Level: skydb.ChannelAccessMasterKey,
+			},
+			CreatedAt: createdAt,
+			UpdatedAt: createdAt,
+		}
+		So(c.SaveChannelRule(&rule), ShouldBeNil)
+
+		Convey("gets rules", func() {
+			rules, err := c.GetChannelRules()
+			So(err, ShouldBeNil)
+			So(rules, ShouldResemble, []skydb.ChannelRule{rule})
+		})
+
+		Convey("replaces rule of the same channel", func() {
+			rule.Publish.Level = skydb.ChannelAccessPublic
+			rule.CreatedAt = createdAt.Add(time.Hour)
+			rule.UpdatedAt = createdAt.Add(time.Hour)
+			So(c.SaveChannelRule(&rule), ShouldBeNil)
+
+			rules, err := c.GetChannelRules()
+			So(err, ShouldBeNil)
+			So(len(rules), ShouldEqual, 1)
+			So(rules[0].Publish.Level, ShouldEqual, skydb.ChannelAccessPublic)
+			So(rules[0].CreatedAt, ShouldResemble, createdAt)
+			So(rules[0].UpdatedAt, ShouldResemble, createdAt.Add(time.Hour))
+		})
+
+		Convey("deletes rule", func() {
+			So(c.DeleteChannelRule("chat/*"), ShouldBeNil)
+			rules, err := c.GetChannelRules()
+			So(err, ShouldBeNil)
+			So(rules, ShouldBeEmpty)
+
+			So(c.DeleteChannelRule("chat/*"), ShouldEqual, skydb.ErrChannelRuleNotFound)
+		})
+	})
+}
@@ -0,0 +1,576 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+var pushDeliveryColumns = []string{
+	"id",
+	"notification_id",
+	"device_id",
+	"device_type",
+	"device_token",
+	"notification",
+	"state",
+	"attempts",
+	"last_error",
+	"next_attempt_at",
+	"created_at",
+	"updated_at",
+	"collapse_key",
+}
+
+func (c *conn) EnqueuePushDeliveries(deliveries []skydb.PushDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err := c.collapsePushDeliveries(deliveries); err != nil {
+		return err
+	}
+
+	builder := psql.Insert(c.tableName("_push_delivery")).Columns(pushDeliveryColumns...)
+	for _, delivery := range deliveries {
+		if delivery.ID == "" || delivery.NotificationID == "" || delivery.DeviceID == "" || delivery.CreatedAt.IsZero() {
+			return errors.New("invalid push delivery: empty id, notification id, device id or created at")
+		}
+
+		state := delivery.State
+		if state == "" {
+			state = skydb.PushDeliveryQueued
+		}
+		nextAttemptAt := delivery.NextAttemptAt
+		if nextAttemptAt.IsZero() {
+			nextAttemptAt = delivery.CreatedAt
+		}
+		updatedAt := delivery.UpdatedAt
+		if updatedAt.IsZero() {
+			updatedAt = delivery.CreatedAt
+		}
+
+		builder = builder.Values(
+			delivery.ID,
+			delivery.NotificationID,
+			delivery.DeviceID,
+			delivery.DeviceType,
+			sql.NullString{String: delivery.DeviceToken, Valid: delivery.DeviceToken != ""},
+			jsonMapValue(delivery.Notification),
+			string(state),
+			delivery.Attempts,
+			sql.NullString{String: delivery.LastError, Valid: delivery.LastError != ""},
+			nextAttemptAt.UTC(),
+			delivery.CreatedAt.UTC(),
+			updatedAt.UTC(),
+			sql.NullString{String: delivery.CollapseKey, Valid: delivery.CollapseKey != ""},
+		)
+	}
+
+	_, err := c.ExecWith(builder)
+	return err
+}
+
+// collapsePushDeliveries marks queued deliveries replaced by deliveries
+// as collapsed.
+func (c *conn) collapsePushDeliveries(deliveries []skydb.PushDelivery) error {
+	conditions := []string{}
+	args := []interface{}{
+		string(skydb.PushDeliveryCollapsed),
+		string(skydb.PushDeliveryQueued),
+	}
+	for _, delivery := range deliveries {
+		if delivery.CollapseKey == "" {
+			continue
+		}
+		conditions = append(conditions, fmt.Sprintf("($%d, $%d)", len(args)+1, len(args)+2))
+		args = append(args, delivery.DeviceID, delivery.CollapseKey)
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	_, err := c.Exec(fmt.Sprintf(
+		"UPDATE %s SET state = $1 WHERE state = $2 AND (device_id, collapse_key) IN (%s)",
+		c.tableName("_push_delivery"),
+		strings.Join(conditions, ", "),
+	), args...)
+	return err
+}
+
+func (c *conn) ClaimPushDeliveries(now time.Time, lease time.Duration, limit int) ([]skydb.PushDelivery, error) {
+	// SKIP LOCKED lets concurrent workers claim different deliveries
+	// without waiting for each other.
+	table := c.tableName("_push_delivery")
+	query := fmt.Sprintf(`
+UPDATE %[1]s SET next_attempt_at = $1
+WHERE id IN (
+	SELECT id FROM %[1]s
+	WHERE state = $2 AND next_attempt_at <= $3
+	ORDER BY next_attempt_at
+	LIMIT $4
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING %[2]s
+`, table, strings.Join(pushDeliveryColumns, ", "))
+
+	rows, err := c.Queryx(query,
+		now.Add(lease).UTC(),
+		string(skydb.PushDeliveryQueued),
+		now.UTC(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanPushDeliveries(rows)
+}
+
+func (c *conn) UpdatePushDelivery(delivery *skydb.PushDelivery) error {
+	builder := psql.Update(c.tableName("_push_delivery")).
+		Set("state", string(delivery.State)).
+		Set("attempts", delivery.Attempts).
+		Set("last_error", sql.NullString{String: delivery.LastError, Valid: delivery.LastError != ""}).
+		Set("next_attempt_at", delivery.NextAttemptAt.UTC()).
+		Set("updated_at", delivery.UpdatedAt.UTC()).
+		Where("id = ?", delivery.ID)
+
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrPushDeliveryNotFound
+	}
+
+	return nil
+}
+
+func (c *conn) GetPushDeliveries(notificationID string, afterID string, limit int) ([]skydb.PushDelivery, error) {
+	// paging by id makes use of the (notification_id, id) index, so that
+	// each page is an index range scan
+	builder := psql.Select(pushDeliveryColumns...).
+		From(c.tableName("_push_delivery")).
+		Where("notification_id = ? AND id > ?", notificationID, afterID).
+		OrderBy("id").
+		Limit(uint64(limit))
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	return scanPushDeliveries(rows)
+}
+
+func (c *conn) CountPushDeliveries(notificationID string) (map[skydb.PushDeliveryState]int, error) {
+	builder := psql.Select("state", "count(*)").
+		From(c.tableName("_push_delivery")).
+		Where("notification_id = ?", notificationID).
+		GroupBy("state")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[skydb.PushDeliveryState]int{}
+	for rows.Next() {
+		var (
+			state string
+			count int
+		)
+		if err := rows.Scan(&state, &count); err != nil {
+			return nil, err
+		}
+		counts[skydb.PushDeliveryState(state)] = count
+	}
+	return counts, rows.Err()
+}
+
+func scanPushDeliveries(rows *sqlx.Rows) ([]skydb.PushDelivery, error) {
+	defer rows.Close()
+
+	results := []skydb.PushDelivery{}
+	for rows.Next() {
+		d := skydb.PushDelivery{}
+		var (
+			state             string
+			nullableToken     sql.NullString
+			nullableLastError sql.NullString
+			notification      nullJSON
+			collapseKey       sql.NullString
+		)
+		if err := rows.Scan(
+			&d.ID,
+			&d.NotificationID,
+			&d.DeviceID,
+			&d.DeviceType,
+			&nullableToken,
+			&notification,
+			&state,
+			&d.Attempts,
+			&nullableLastError,
+			&d.NextAttemptAt,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+			&collapseKey,
+		); err != nil {
+			return nil, err
+		}
+
+		d.DeviceToken = nullableToken.String
+		d.CollapseKey = collapseKey.String
+		d.LastError = nullableLastError.String
+		d.State = skydb.PushDeliveryState(state)
+		if m, ok := notification.JSON.(map[string]interface{}); ok {
+			d.Notification = m
+		}
+		d.NextAttemptAt = d.NextAttemptAt.UTC()
+		d.CreatedAt = d.CreatedAt.UTC()
+		d.UpdatedAt = d.UpdatedAt.UTC()
+		results = append(results, d)
+	}
+
+	return results, rows.Err()
+}
+
+var pushScheduleColumns = []string{
+	"id",
+	"notification_id",
+	"user_ids",
+	"device_ids",
+	"topic",
+	"notification",
+	"template",
+	"context",
+	"collapse_key",
+	"urgent",
+	"state",
+	"send_at",
+	"created_at",
+	"updated_at",
+}
+
+func (c *conn) SavePushSchedule(schedule *skydb.PushSchedule) error {
+	if schedule.ID == "" || schedule.NotificationID == "" || schedule.SendAt.IsZero() || schedule.CreatedAt.IsZero() {
+		return errors.New("invalid push schedule: empty id, notification id, send at or created at")
+	}
+
+	state := schedule.State
+	if state == "" {
+		state = skydb.PushScheduleScheduled
+	}
+	updatedAt := schedule.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = schedule.CreatedAt
+	}
+
+	builder := psql.Insert(c.tableName("_push_schedule")).
+		Columns(pushScheduleColumns...).
+		Values(
+			schedule.ID,
+			schedule.NotificationID,
+			nullJSONStringSlice{slice: schedule.UserIDs, Valid: schedule.UserIDs != nil},
+			nullJSONStringSlice{slice: schedule.DeviceIDs, Valid: schedule.DeviceIDs != nil},
+			sql.NullString{String: schedule.Topic, Valid: schedule.Topic != ""},
+			jsonMapValue(schedule.Notification),
+			sql.NullString{String: schedule.Template, Valid: schedule.Template != ""},
+			jsonMapValue(schedule.Context),
+			sql.NullString{String: schedule.CollapseKey, Valid: schedule.CollapseKey != ""},
+			schedule.Urgent,
+			string(state),
+			schedule.SendAt.UTC(),
+			schedule.CreatedAt.UTC(),
+			updatedAt.UTC(),
+		)
+
+	_, err := c.ExecWith(builder)
+	return err
+}
+
+func (c *conn) ClaimPushSchedules(now time.Time, lease time.Duration, limit int) ([]skydb.PushSchedule, error) {
+	table := c.tableName("_push_schedule")
+	query := fmt.Sprintf(`
+UPDATE %[1]s SET locked_until = $1
+WHERE id IN (
+	SELECT id FROM %[1]s
+	WHERE state = $2 AND send_at <= $3 AND (locked_until IS NULL OR locked_until <= $3)
+	ORDER BY send_at
+	LIMIT $4
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING %[2]s
+`, table, strings.Join(pushScheduleColumns, ", "))
+
+	rows, err := c.Queryx(query,
+		now.Add(lease).UTC(),
+		string(skydb.PushScheduleScheduled),
+		now.UTC(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanPushSchedules(rows)
+}
+
+func (c *conn) UpdatePushSchedule(schedule *skydb.PushSchedule) error {
+	builder := psql.Update(c.tableName("_push_schedule")).
+		Set("state", string(schedule.State)).
+		Set("send_at", schedule.SendAt.UTC()).
+		Set("updated_at", schedule.UpdatedAt.UTC()).
+		Where("id = ?", schedule.ID)
+
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrPushScheduleNotFound
+	}
+
+	return nil
+}
+
+func (c *conn) CancelPushSchedules(notificationID string, now time.Time) (int, error) {
+	builder := psql.Update(c.tableName("_push_schedule")).
+		Set("state", string(skydb.PushScheduleCancelled)).
+		Set("updated_at", now.UTC()).
+		Where("notification_id = ? AND state = ?", notificationID, string(skydb.PushScheduleScheduled))
+
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+func (c *conn) GetPushSchedules(notificationID string) ([]skydb.PushSchedule, error) {
+	builder := psql.Select(pushScheduleColumns...).
+		From(c.tableName("_push_schedule")).
+		Where("notification_id = ?", notificationID).
+		OrderBy("created_at", "id")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	return scanPushSchedules(rows)
+}
+
+func scanPushSchedules(rows *sqlx.Rows) ([]skydb.PushSchedule, error) {
+	defer rows.Close()
+
+	results := []skydb.PushSchedule{}
+	for rows.Next() {
+		s := skydb.PushSchedule{}
+		var (
+			state        string
+			userIDs      nullJSONStringSlice
+			deviceIDs    nullJSONStringSlice
+			topic        sql.NullString
+			notification nullJSON
+			template     sql.NullString
+			context      nullJSON
+			collapseKey  sql.NullString
+		)
+		if err := rows.Scan(
+			&s.ID,
+			&s.NotificationID,
+			&userIDs,
+			&deviceIDs,
+			&topic,
+			&notification,
+			&template,
+			&context,
+			&collapseKey,
+			&s.Urgent,
+			&state,
+			&s.SendAt,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		s.UserIDs = userIDs.slice
+		s.DeviceIDs = deviceIDs.slice
+		s.Topic = topic.String
+		if m, ok := notification.JSON.(map[string]interface{}); ok {
+			s.Notification = m
+		}
+		s.Template = template.String
+		if m, ok := context.JSON.(map[string]interface{}); ok {
+			s.Context = m
+		}
+		s.CollapseKey = collapseKey.String
+		s.State = skydb.PushScheduleState(state)
+		s.SendAt = s.SendAt.UTC()
+		s.CreatedAt = s.CreatedAt.UTC()
+		s.UpdatedAt = s.UpdatedAt.UTC()
+		results = append(results, s)
+	}
+
+	return results, rows.Err()
+}
+
+// pushTemplateContent is the part of a PushTemplate saved in the content
+// column of _push_template.
+type pushTemplateContent struct {
+	DefaultLocale string                                    `json:"default_locale"`
+	Localizations map[string]skydb.PushTemplateLocalization `json:"localizations"`
+	Sound         string                                    `json:"sound,omitempty"`
+	Badge         interface{}                               `json:"badge,omitempty"`
+	Data          map[string]interface{}                    `json:"data,omitempty"`
+}
+
+func (c *conn) SavePushTemplate(template *skydb.PushTemplate) error {
+	if template.Name == "" || template.CreatedAt.IsZero() {
+		return errors.New("invalid push template: empty name or created at")
+	}
+
+	content, err := json.Marshal(pushTemplateContent{
+		DefaultLocale: template.DefaultLocale,
+		Localizations: template.Localizations,
+		Sound:         template.Sound,
+		Badge:         template.Badge,
+		Data:          template.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	updatedAt := template.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = template.CreatedAt
+	}
+
+	pkData := map[string]interface{}{"name": template.Name}
+	data := map[string]interface{}{
+		"content":    content,
+		"created_at": template.CreatedAt.UTC(),
+		"updated_at": updatedAt.UTC(),
+	}
+
+	upsert := upsertQuery(c.tableName("_push_template"), pkData, data).
+		IgnoreKeyOnUpdate("created_at")
+	_, err = c.ExecWith(upsert)
+	return err
+}
+
+func (c *conn) GetPushTemplate(name string, template *skydb.PushTemplate) error {
+	builder := psql.Select("name", "content", "created_at", "updated_at").
+		From(c.tableName("_push_template")).
+		Where("name = ?", name)
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return err
+	}
+	templates, err := scanPushTemplates(rows)
+	if err != nil {
+		return err
+	}
+	if len(templates) == 0 {
+		return skydb.ErrPushTemplateNotFound
+	}
+
+	*template = templates[0]
+	return nil
+}
+
+func (c *conn) GetPushTemplates() ([]skydb.PushTemplate, error) {
+	builder := psql.Select("name", "content", "created_at", "updated_at").
+		From(c.tableName("_push_template")).
+		OrderBy("name")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	return scanPushTemplates(rows)
+}
+
+func (c *conn) DeletePushTemplate(name string) error {
+	builder := psql.Delete(c.tableName("_push_template")).
+		Where("name = ?", name)
+
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrPushTemplateNotFound
+	}
+
+	return nil
+}
+
+func scanPushTemplates(rows *sqlx.Rows) ([]skydb.PushTemplate, error) {
+	defer rows.Close()
+
+	results := []skydb.PushTemplate{}
+	for rows.Next() {
+		t := skydb.PushTemplate{}
+		var content []byte
+		if err := rows.Scan(&t.Name, &content, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		c := pushTemplateContent{}
+		if err := json.Unmarshal(content, &c); err != nil {
+			return nil, err
+		}
+		t.DefaultLocale = c.DefaultLocale
+		t.Localizations = c.Localizations
+		t.Sound = c.Sound
+		t.Badge = c.Badge
+		t.Data = c.Data
+		t.CreatedAt = t.CreatedAt.UTC()
+		t.UpdatedAt = t.UpdatedAt.UTC()
+		results = append(results, t)
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,431 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPushDelivery(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		createdAt := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		deliveries := []skydb.PushDelivery{
+			{
+				ID:             "delivery1",
+				NotificationID: "notification",
+				DeviceID:       "device1",
+				DeviceType:     "ios",
+				DeviceToken:    "token1",
+				Notification:   map[string]interface{}{"apns": map[string]interface{}{"alert": "Hello"}},
+				CreatedAt:      createdAt,
+			},
+			{
+				ID:             "delivery2",
+				NotificationID: "notification",
+				DeviceID:       "device2",
+				DeviceType:     "android",
+				DeviceToken:    "token2",
+				Notification:   map[string]interface{}{"gcm": map[string]interface{}{"data": "Hello"}},
+				CreatedAt:      createdAt,
+				NextAttemptAt:  createdAt.Add(time.Hour),
+			},
+		}
+		So(c.EnqueuePushDeliveries(deliveries), ShouldBeNil)
+
+		Convey("gets deliveries of a notification", func() {
+			results, err := c.GetPushDeliveries("notification", "", 10)
+			So(err, ShouldBeNil)
+			So(results, ShouldResemble, []skydb.PushDelivery{
+				{
+					ID:             "delivery1",
+					NotificationID: "notification",
+					DeviceID:       "device1",
+					DeviceType:     "ios",
+					DeviceToken:    "token1",
+					Notification:   map[string]interface{}{"apns": map[string]interface{}{"alert": "Hello"}},
+					State:          skydb.PushDeliveryQueued,
+					NextAttemptAt:  createdAt,
+					CreatedAt:      createdAt,
+					UpdatedAt:      createdAt,
+				},
+				{
+					ID:             "delivery2",
+					NotificationID: "notification",
+					DeviceID:       "device2",
+					DeviceType:     "android",
+					DeviceToken:    "token2",
+					Notification:   map[string]interface{}{"gcm": map[string]interface{}{"data": "Hello"}},
+					State:          skydb.PushDeliveryQueued,
+					NextAttemptAt:  createdAt.Add(time.Hour),
+					CreatedAt:      createdAt,
+					UpdatedAt:      createdAt,
+				},
+			})
+		})
+
+		Convey("gets deliveries of a notification by page", func() {
+			results, err := c.GetPushDeliveries("notification", "", 1)
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 1)
+			So(results[0].ID, ShouldEqual, "delivery1")
+
+			results, err = c.GetPushDeliveries("notification", "delivery1", 1)
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 1)
+			So(results[0].ID, ShouldEqual, "delivery2")
+
+			results, err = c.GetPushDeliveries("notification", "delivery2", 1)
+			So(err, ShouldBeNil)
+			So(results, ShouldBeEmpty)
+		})
+
+		Convey("counts deliveries of a notification by state", func() {
+			delivery := deliveries[0]
+			delivery.State = skydb.PushDeliverySent
+			delivery.Attempts = 1
+			delivery.UpdatedAt = createdAt.Add(time.Second)
+			So(c.UpdatePushDelivery(&delivery), ShouldBeNil)
+
+			counts, err := c.CountPushDeliveries("notification")
+			So(err, ShouldBeNil)
+			So(counts, ShouldResemble, map[skydb.PushDeliveryState]int{
+				skydb.PushDeliveryQueued: 1,
+				skydb.PushDeliverySent:   1,
+			})
+		})
+
+		Convey("gets no deliveries of a non-existent notification", func() {
+			results, err := c.GetPushDeliveries("nonexistent", "", 10)
+			So(err, ShouldBeNil)
+			So(results, ShouldBeEmpty)
+		})
+
+		Convey("claims due deliveries", func() {
+			now := createdAt.Add(time.Minute)
+			results, err := c.ClaimPushDeliveries(now, time.Minute, 10)
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 1)
+			So(results[0].ID, ShouldEqual, "delivery1")
+			So(results[0].NextAttemptAt, ShouldResemble, now.Add(time.Minute))
+
+			Convey("and does not claim them again within the lease", func() {
+				results, err := c.ClaimPushDeliveries(now, time.Minute, 10)
+				So(err, ShouldBeNil)
+				So(results, ShouldBeEmpty)
+			})
+
+			Convey("and claims them again after the lease", func() {
+				results, err := c.ClaimPushDeliveries(now.Add(2*time.Minute), time.Minute, 10)
+				So(err, ShouldBeNil)
+				So(results, ShouldHaveLength, 1)
+				So(results[0].ID, ShouldEqual, "delivery1")
+			})
+		})
+
+		Convey("does not claim delivered deliveries", func() {
+			delivery := deliveries[0]
+			delivery.State = skydb.PushDeliverySent
+			delivery.Attempts = 1
+			delivery.UpdatedAt = createdAt.Add(time.Second)
+			So(c.UpdatePushDelivery(&delivery), ShouldBeNil)
+
+			results, err := c.ClaimPushDeliveries(createdAt.Add(2*time.Hour), time.Minute, 10)
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 1)
+			So(results[0].ID, ShouldEqual, "delivery2")
+		})
+
+		Convey("updates a delivery", func() {
+			delivery := deliveries[0]
+			delivery.State = skydb.PushDeliveryFailed
+			delivery.Attempts = 3
+			delivery.LastError = "service unavailable"
+			delivery.UpdatedAt = createdAt.Add(time.Second)
+			So(c.UpdatePushDelivery(&delivery), ShouldBeNil)
+
+			results, err := c.GetPushDeliveries("notification", "", 10)
+			So(err, ShouldBeNil)
+			So(results[0].State, ShouldEqual, skydb.PushDeliveryFailed)
+			So(results[0].Attempts, ShouldEqual, 3)
+			So(results[0].LastError, ShouldEqual, "service unavailable")
+			So(results[0].UpdatedAt, ShouldResemble, createdAt.Add(time.Second))
+		})
+
+		Convey("collapses queued deliveries with the same collapse key", func() {
+			So(c.EnqueuePushDeliveries([]skydb.PushDelivery{
+				{
+					ID:             "delivery3",
+					NotificationID: "notification2",
+					DeviceID:       "device1",
+					DeviceType:     "ios",
+					Notification:   map[string]interface{}{},
+					CollapseKey:    "score",
+					CreatedAt:      createdAt,
+				},
+				{
+					ID:             "delivery4",
+					NotificationID: "notification2",
+					DeviceID:       "device2",
+					DeviceType:     "android",
+					Notification:   map[string]interface{}{},
+					CollapseKey:    "score",
+					CreatedAt:      createdAt,
+				},
+			}), ShouldBeNil)
+
+			So(c.EnqueuePushDeliveries([]skydb.PushDelivery{
+				{
+					ID:             "delivery5",
+					NotificationID: "notification3",
+					DeviceID:       "device1",
+					DeviceType:     "ios",
+					Notification:   map[string]interface{}{},
+					CollapseKey:    "score",
+					CreatedAt:      createdAt.Add(time.Second),
+				},
+			}), ShouldBeNil)
+
+			results, err := c.GetPushDeliveries("notification2", "", 10)
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 2)
+			So(results[0].State, ShouldEqual, skydb.PushDeliveryCollapsed)
+			So(results[0].CollapseKey, ShouldEqual, "score")
+			So(results[1].State, ShouldEqual, skydb.PushDeliveryQueued)
+
+			results, err = c.GetPushDeliveries("notification", "", 10)
+			So(err, ShouldBeNil)
+			So(results[0].State, ShouldEqual, skydb.PushDeliveryQueued)
+		})
+
+		Convey("returns ErrPushDeliveryNotFound when updating a non-existent delivery", func() {
+			err := c.UpdatePushDelivery(&skydb.PushDelivery{
+				ID:    "nonexistent",
+				State: skydb.PushDeliverySent,
+			})
+			So(err, ShouldEqual, skydb.ErrPushDeliveryNotFound)
+		})
+	})
+}
+
+func TestPushTemplate(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		createdAt := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		template := skydb.PushTemplate{
+			Name:          "new-comment",
+			DefaultLocale: "en",
+			Localizations: map[string]skydb.PushTemplateLocalization{
+				"en": {
+					Title: "New comment",
+					Body:  "{{user.username}} commented on your post",
+				},
+				"zh": {
+					BodyLocKey:  "NEW_COMMENT",
+					BodyLocArgs: []string{"{{user.username}}"},
+				},
+			},
+			Sound:     "default",
+			Badge:     float64(1),
+			Data:      map[string]interface{}{"post_id": "{{record._id}}"},
+			CreatedAt: createdAt,
+		}
+		So(c.SavePushTemplate(&template), ShouldBeNil)
+
+		Convey("gets a template", func() {
+			result := skydb.PushTemplate{}
+			So(c.GetPushTemplate("new-comment", &result), ShouldBeNil)
+
+			template.UpdatedAt = createdAt
+			So(result, ShouldResemble, template)
+		})
+
+		Convey("replaces a template and keeps its creation time", func() {
+			template.DefaultLocale = "zh"
+			template.CreatedAt = createdAt.Add(time.Hour)
+			template.UpdatedAt = createdAt.Add(time.Hour)
+			So(c.SavePushTemplate(&template), ShouldBeNil)
+
+			result := skydb.PushTemplate{}
+			So(c.GetPushTemplate("new-comment", &result), ShouldBeNil)
+			So(result.DefaultLocale, ShouldEqual, "zh")
+			So(result.CreatedAt, ShouldResemble, createdAt)
+			So(result.UpdatedAt, ShouldResemble, createdAt.Add(time.Hour))
+		})
+
+		Convey("gets all templates ordered by name", func() {
+			So(c.SavePushTemplate(&skydb.PushTemplate{
+				Name:      "another",
+				CreatedAt: createdAt,
+			}), ShouldBeNil)
+
+			results, err := c.GetPushTemplates()
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 2)
+			So(results[0].Name, ShouldEqual, "another")
+			So(results[1].Name, ShouldEqual, "new-comment")
+		})
+
+		Convey("deletes a template", func() {
+			So(c.DeletePushTemplate("new-comment"), ShouldBeNil)
+
+			err := c.GetPushTemplate("new-comment", &skydb.PushTemplate{})
+			So(err, ShouldEqual, skydb.ErrPushTemplateNotFound)
+		})
+
+		Convey("returns ErrPushTemplateNotFound for a non-existent template", func() {
+			err := c.GetPushTemplate("nonexistent", &skydb.PushTemplate{})
+			So(err, ShouldEqual, skydb.ErrPushTemplateNotFound)
+
+			err = c.DeletePushTemplate("nonexistent")
+			So(err, ShouldEqual, skydb.ErrPushTemplateNotFound)
+		})
+	})
+}
+
+func TestPushSchedule(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		createdAt := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		schedules := []skydb.PushSchedule{
+			{
+				ID:             "schedule1",
+				NotificationID: "notification",
+				UserIDs:        []string{"user1", "user2"},
+				Topic:          "topic",
+				Notification:   map[string]interface{}{"apns": map[string]interface{}{"alert": "Hello"}},
+				CollapseKey:    "greeting",
+				SendAt:         createdAt.Add(time.Hour),
+				CreatedAt:      createdAt,
+			},
+			{
+				ID:             "schedule2",
+				NotificationID: "notification",
+				DeviceIDs:      []string{"device1"},
+				Template:       "greeting",
+				Context:        map[string]interface{}{"name": "John"},
+				Urgent:         true,
+				SendAt:         createdAt.Add(2 * time.Hour),
+				CreatedAt:      createdAt,
+			},
+		}
+		for i := range schedules {
+			So(c.SavePushSchedule(&schedules[i]), ShouldBeNil)
+		}
+
+		Convey("gets schedules of a notification", func() {
+			results, err := c.GetPushSchedules("notification")
+			So(err, ShouldBeNil)
+			So(results, ShouldResemble, []skydb.PushSchedule{
+				{
+					ID:             "schedule1",
+					NotificationID: "notification",
+					UserIDs:        []string{"user1", "user2"},
+					Topic:          "topic",
+					Notification:   map[string]interface{}{"apns": map[string]interface{}{"alert": "Hello"}},
+					CollapseKey:    "greeting",
+					State:          skydb.PushScheduleScheduled,
+					SendAt:         createdAt.Add(time.Hour),
+					CreatedAt:      createdAt,
+					UpdatedAt:      createdAt,
+				},
+				{
+					ID:             "schedule2",
+					NotificationID: "notification",
+					DeviceIDs:      []string{"device1"},
+					Template:       "greeting",
+					Context:        map[string]interface{}{"name": "John"},
+					Urgent:         true,
+					State:          skydb.PushScheduleScheduled,
+					SendAt:         createdAt.Add(2 * time.Hour),
+					CreatedAt:      createdAt,
+					UpdatedAt:      createdAt,
+				},
+			})
+		})
+
+		Convey("claims due schedules", func() {
+			now := createdAt.Add(time.Hour)
+			results, err := c.ClaimPushSchedules(now, time.Minute, 10)
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 1)
+			So(results[0].ID, ShouldEqual, "schedule1")
+			So(results[0].SendAt, ShouldResemble, createdAt.Add(time.Hour))
+
+			Convey("and does not claim them again within the lease", func() {
+				results, err := c.ClaimPushSchedules(now, time.Minute, 10)
+				So(err, ShouldBeNil)
+				So(results, ShouldBeEmpty)
+			})
+
+			Convey("and claims them again after the lease", func() {
+				results, err := c.ClaimPushSchedules(now.Add(time.Minute), time.Minute, 10)
+				So(err, ShouldBeNil)
+				So(results, ShouldHaveLength, 1)
+				So(results[0].ID, ShouldEqual, "schedule1")
+				So(results[0].SendAt, ShouldResemble, createdAt.Add(time.Hour))
+			})
+
+			Convey("and does not claim them again after sent", func() {
+				schedule := results[0]
+				schedule.State = skydb.PushScheduleSent
+				schedule.UpdatedAt = now
+				So(c.UpdatePushSchedule(&schedule), ShouldBeNil)
+
+				results, err := c.ClaimPushSchedules(now.Add(time.Hour), time.Minute, 10)
+				So(err, ShouldBeNil)
+				So(results, ShouldHaveLength, 1)
+				So(results[0].ID, ShouldEqual, "schedule2")
+			})
+		})
+
+		Convey("cancels schedules of a notification", func() {
+			count, err := c.CancelPushSchedules("notification", createdAt.Add(time.Minute))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			results, err := c.ClaimPushSchedules(createdAt.Add(3*time.Hour), time.Minute, 10)
+			So(err, ShouldBeNil)
+			So(results, ShouldBeEmpty)
+
+			results, err = c.GetPushSchedules("notification")
+			So(err, ShouldBeNil)
+			So(results[0].State, ShouldEqual, skydb.PushScheduleCancelled)
+			So(results[0].UpdatedAt, ShouldResemble, createdAt.Add(time.Minute))
+
+			count, err = c.CancelPushSchedules("notification", createdAt.Add(time.Minute))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+
+		Convey("returns ErrPushScheduleNotFound when updating a non-existent schedule", func() {
+			err := c.UpdatePushSchedule(&skydb.PushSchedule{
+				ID:    "nonexistent",
+				State: skydb.PushScheduleSent,
+			})
+			So(err, ShouldEqual, skydb.ErrPushScheduleNotFound)
+		})
+	})
+}
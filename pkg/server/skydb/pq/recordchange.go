@@ -0,0 +1,112 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (db *database) GetRecordChanges(recordType string, since skydb.RecordChangeCursor, limit uint64) ([]skydb.RecordChange, error) {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return nil, fmt.Errorf("record changes of union database is not supported")
+	}
+
+	// Changes are returned after all transactions with a smaller txid
+	// have ended, so that a change committed later is never ordered
+	// before the changes returned.
+	query := fmt.Sprintf(`
+SELECT c.id, c.txid, c.record_id, c.event, c.owner_id, c.access, c.changed_at, s.created
+FROM %[1]s AS c
+JOIN (
+	SELECT max(id) AS id, bool_or(event = $1) AS created
+	FROM %[1]s
+	WHERE database_id = $2 AND record_type = $3 AND (txid, id) > ($4, $5)
+		AND txid < txid_snapshot_xmin(txid_current_snapshot())
+	GROUP BY record_id
+) AS s ON c.id = s.id
+ORDER BY c.txid, c.id
+LIMIT $6
+`, db.tableName("_record_change"))
+
+	rows, err := db.c.Queryx(query,
+		string(skydb.RecordChangeCreate),
+		db.userID,
+		recordType,
+		since.TxID,
+		since.ID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []skydb.RecordChange{}
+	for rows.Next() {
+		var (
+			change  skydb.RecordChange
+			key     string
+			event   string
+			ownerID sql.NullString
+			access  sql.NullString
+			created bool
+		)
+		if err := rows.Scan(&change.ID, &change.TxID, &key, &event, &ownerID, &access, &change.ChangedAt, &created); err != nil {
+			return nil, err
+		}
+
+		change.RecordID = skydb.NewRecordID(recordType, key)
+		change.Event = skydb.RecordChangeEvent(event)
+		if change.Event != skydb.RecordChangeDelete {
+			if created {
+				change.Event = skydb.RecordChangeCreate
+			} else {
+				change.Event = skydb.RecordChangeUpdate
+			}
+		}
+		change.OwnerID = ownerID.String
+		if access.Valid {
+			if err := json.Unmarshal([]byte(access.String), &change.ACL); err != nil {
+				return nil, err
+			}
+		}
+		change.ChangedAt = change.ChangedAt.UTC()
+
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+func (c *conn) GetLatestRecordChangeCursor() (skydb.RecordChangeCursor, error) {
+	// changes of transactions before the oldest transaction in progress
+	// are all committed or rolled back
+	var xmin int64
+	err := c.QueryRowx(`SELECT txid_snapshot_xmin(txid_current_snapshot())`).Scan(&xmin)
+	return skydb.RecordChangeCursor{TxID: xmin}, err
+}
+
+func (c *conn) DeleteRecordChanges(before time.Time) error {
+	builder := psql.Delete(c.tableName("_record_change")).
+		Where("changed_at < ?", before.UTC())
+
+	_, err := c.ExecWith(builder)
+	return err
+}
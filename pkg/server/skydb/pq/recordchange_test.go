@@ -0,0 +1,123 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordChanges(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		note1 := skydb.Record{
+			ID:      skydb.NewRecordID("note", "note1"),
+			OwnerID: "user1",
+			Data:    map[string]interface{}{"content": "one"},
+		}
+		note2 := skydb.Record{
+			ID:      skydb.NewRecordID("note", "note2"),
+			OwnerID: "user2",
+			Data:    map[string]interface{}{"content": "two"},
+		}
+
+		Convey("records changes of saved and deleted records", func() {
+			So(db.Save(&note1), ShouldBeNil)
+			So(db.Save(&note2), ShouldBeNil)
+			note1.Data["content"] = "updated"
+			So(db.Save(&note1), ShouldBeNil)
+			So(db.Delete(note2.ID), ShouldBeNil)
+
+			changes, err := db.GetRecordChanges("note", skydb.RecordChangeCursor{}, 10)
+			So(err, ShouldBeNil)
+			So(len(changes), ShouldEqual, 2)
+			So(changes[0].RecordID, ShouldResemble, note1.ID)
+			So(changes[0].Event, ShouldEqual, skydb.RecordChangeCreate)
+			So(changes[1].RecordID, ShouldResemble, note2.ID)
+			So(changes[1].Event, ShouldEqual, skydb.RecordChangeDelete)
+			So(changes[1].OwnerID, ShouldEqual, "user2")
+
+			latest, err := c.GetLatestRecordChangeCursor()
+			So(err, ShouldBeNil)
+			So(latest.TxID, ShouldBeGreaterThan, changes[1].TxID)
+		})
+
+		Convey("returns update for record created before since", func() {
+			So(db.Save(&note1), ShouldBeNil)
+			since, err := c.GetLatestRecordChangeCursor()
+			So(err, ShouldBeNil)
+
+			note1.Data["content"] = "updated"
+			So(db.Save(&note1), ShouldBeNil)
+
+			changes, err := db.GetRecordChanges("note", since, 10)
+			So(err, ShouldBeNil)
+			So(len(changes), ShouldEqual, 1)
+			So(changes[0].Event, ShouldEqual, skydb.RecordChangeUpdate)
+		})
+
+		Convey("returns changes up to limit", func() {
+			So(db.Save(&note1), ShouldBeNil)
+			So(db.Save(&note2), ShouldBeNil)
+
+			changes, err := db.GetRecordChanges("note", skydb.RecordChangeCursor{}, 1)
+			So(err, ShouldBeNil)
+			So(len(changes), ShouldEqual, 1)
+			So(changes[0].RecordID, ShouldResemble, note1.ID)
+		})
+
+		Convey("holds back changes committed after a transaction in progress", func() {
+			other, err := Open(c.appName, skydb.RoleBasedAccess, "", false)
+			So(err, ShouldBeNil)
+			otherDB := other.PublicDB()
+			So(otherDB.(skydb.TxDatabase).Begin(), ShouldBeNil)
+			So(otherDB.Save(&note1), ShouldBeNil)
+
+			So(db.Save(&note2), ShouldBeNil)
+
+			changes, err := db.GetRecordChanges("note", skydb.RecordChangeCursor{}, 10)
+			So(err, ShouldBeNil)
+			So(changes, ShouldBeEmpty)
+
+			So(otherDB.(skydb.TxDatabase).Commit(), ShouldBeNil)
+
+			changes, err = db.GetRecordChanges("note", skydb.RecordChangeCursor{}, 10)
+			So(err, ShouldBeNil)
+			So(len(changes), ShouldEqual, 2)
+			So(changes[0].RecordID, ShouldResemble, note1.ID)
+			So(changes[1].RecordID, ShouldResemble, note2.ID)
+		})
+
+		Convey("deletes changes before a time", func() {
+			So(db.Save(&note1), ShouldBeNil)
+			So(c.DeleteRecordChanges(time.Now().UTC().Add(time.Minute)), ShouldBeNil)
+
+			changes, err := db.GetRecordChanges("note", skydb.RecordChangeCursor{}, 10)
+			So(err, ShouldBeNil)
+			So(changes, ShouldBeEmpty)
+		})
+	})
+}
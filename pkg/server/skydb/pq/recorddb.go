@@ -115,10 +115,21 @@ func (db *database) Save(record *skydb.Record) error {
 			"_database_id": db.userID,
 		}
 	}
+	// The record is saved and a change is written in a single statement,
+	// so that whether the record is created is decided by the upsert.
 	upsert := upsertQuery(db.tableName(record.ID.Type), pkData, convert(record)).
 		IgnoreKeyOnUpdate("_owner_id").
 		IgnoreKeyOnUpdate("_created_at").
-		IgnoreKeyOnUpdate("_created_by")
+		IgnoreKeyOnUpdate("_created_by").
+		Then(fmt.Sprintf(`
+INSERT INTO %s (database_id, record_type, record_id, event, owner_id, access, changed_at)
+SELECT _database_id, ?, _id, CASE WHEN _inserted THEN ? ELSE ? END, _owner_id, _access, ? FROM upserted
+`, db.tableName("_record_change")),
+			record.ID.Type,
+			string(skydb.RecordChangeCreate),
+			string(skydb.RecordChangeUpdate),
+			time.Now().UTC(),
+		)
 
 	typemap, err := db.remoteColumnTypes(record.ID.Type)
 	if err != nil {
@@ -186,19 +197,28 @@ func convert(r *skydb.Record) map[string]interface{} {
 }
 
 func (db *database) Delete(id skydb.RecordID) error {
-	builder := psql.Delete(db.tableName(id.Type)).
-		Where("_id = ?", id.Key)
-
-	switch db.DatabaseType() {
-	case skydb.UnionDatabase:
+	if db.DatabaseType() == skydb.UnionDatabase {
 		return skydb.ErrDatabaseIsReadOnly
-	case skydb.PublicDatabase:
-		fallthrough
-	case skydb.PrivateDatabase:
-		builder = builder.Where("_database_id = ?", db.userID)
 	}
 
-	result, err := db.c.ExecWith(builder)
+	// The record is deleted and a change is written in a single statement,
+	// keeping owner and ACL of the deleted record in the change.
+	query := fmt.Sprintf(`
+WITH deleted AS (
+	DELETE FROM %s WHERE _id = $1 AND _database_id = $2
+	RETURNING _owner_id, _access
+)
+INSERT INTO %s (database_id, record_type, record_id, event, owner_id, access, changed_at)
+SELECT $2, $3, $1, $4, _owner_id, _access, $5 FROM deleted
+`, db.tableName(id.Type), db.tableName("_record_change"))
+
+	result, err := db.c.Exec(query,
+		id.Key,
+		db.userID,
+		id.Type,
+		string(skydb.RecordChangeDelete),
+		time.Now().UTC(),
+	)
 	if isUndefinedTable(err) {
 		return skydb.ErrRecordNotFound
 	} else if isForeignKeyViolated(err) {
@@ -245,6 +265,14 @@ func (db *database) applyQueryPredicate(q sq.SelectBuilder, factory *predicateSq
 			return q, err
 		}
 		q = q.Where(aclSqlizer)
+
+		policySqlizer, err := factory.newRecordPolicySqlizer(query.Type, query.ViewAsUser)
+		if err != nil {
+			return q, err
+		}
+		if policySqlizer != nil {
+			q = q.Where(policySqlizer)
+		}
 	}
 
 	return q, nil
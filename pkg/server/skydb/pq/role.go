@@ -16,6 +16,7 @@ package pq
 
 import (
 	"bytes"
+	"database/sql"
 	"fmt"
 	"strconv"
 	"text/template"
@@ -233,3 +234,207 @@ func (c *conn) ensureRole(roles []string) ([]string, error) {
 	absenceRoles := utils.StringSliceExcept(roles, existedRole)
 	return absenceRoles, c.createRoles(absenceRoles)
 }
+
+func stringsToInterfaces(strs []string) []interface{} {
+	args := make([]interface{}, len(strs))
+	for i, v := range strs {
+		args[i] = v
+	}
+	return args
+}
+
+func (c *conn) CreateRole(role *skydb.Role) error {
+	log.Debugf("CreateRole %v", role)
+	var parent sql.NullString
+	if role.Parent != "" {
+		parent = sql.NullString{String: role.Parent, Valid: true}
+	}
+	permissions := nullJSONStringSlice{
+		slice: role.Permissions,
+		Valid: role.Permissions != nil,
+	}
+
+	builder := psql.Insert(c.tableName("_role")).Columns(
+		"id",
+		"parent_id",
+		"permissions",
+		"is_admin",
+		"by_default",
+	).Values(
+		role.Name,
+		parent,
+		permissions,
+		role.IsAdmin,
+		role.ByDefault,
+	)
+	_, err := c.ExecWith(builder)
+	if isUniqueViolated(err) {
+		return skydb.ErrRoleDuplicated
+	} else if isForeignKeyViolated(err) {
+		return skydb.ErrRoleNotFound
+	}
+	return err
+}
+
+func (c *conn) GetRoles() ([]skydb.Role, error) {
+	builder := psql.Select("id", "parent_id", "permissions",
+		"is_admin", "by_default").
+		From(c.tableName("_role")).
+		OrderBy("id")
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []skydb.Role{}
+	for rows.Next() {
+		var (
+			role        skydb.Role
+			parent      sql.NullString
+			permissions nullJSONStringSlice
+			isAdmin     sql.NullBool
+			byDefault   sql.NullBool
+		)
+		if err := rows.Scan(&role.Name, &parent, &permissions,
+			&isAdmin, &byDefault); err != nil {
+			return nil, err
+		}
+		role.Parent = parent.String
+		role.Permissions = permissions.slice
+		role.IsAdmin = isAdmin.Bool
+		role.ByDefault = byDefault.Bool
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (c *conn) DeleteRole(name string) error {
+	log.Debugf("DeleteRole %v", name)
+	for _, table := range []string{"_user_role", "_record_creation"} {
+		builder := psql.Delete(c.tableName(table)).Where("role_id = ?", name)
+		if _, err := c.ExecWith(builder); err != nil {
+			return err
+		}
+	}
+
+	builder := psql.Delete(c.tableName("_role")).Where("id = ?", name)
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrRoleNotFound
+	}
+	return nil
+}
+
+// ensureRoleExist returns ErrRoleNotFound if one of the roles does not exist.
+func (c *conn) ensureRoleExist(roles []string) error {
+	existedRoles, err := c.queryRoles(roles)
+	if err != nil {
+		return err
+	}
+	if len(utils.StringSliceExcept(roles, existedRoles)) > 0 {
+		return skydb.ErrRoleNotFound
+	}
+	return nil
+}
+
+func (c *conn) queryUserRoles(userID string) ([]string, error) {
+	builder := psql.Select("role_id").
+		From(c.tableName("_user_role")).
+		Where("user_id = ?", userID)
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (c *conn) AssignUserRoles(userIDs []string, roles []string) error {
+	log.Debugf("AssignUserRoles %v %v", userIDs, roles)
+	if len(userIDs) == 0 || len(roles) == 0 {
+		return nil
+	}
+	if err := c.ensureRoleExist(roles); err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		assignedRoles, err := c.queryUserRoles(userID)
+		if err != nil {
+			return err
+		}
+		newRoles := utils.StringSliceExcept(roles, assignedRoles)
+		if len(newRoles) == 0 {
+			continue
+		}
+
+		stmt, args := c.batchUserRoleSQL(userID, newRoles)
+		_, err = c.Exec(stmt, args...)
+		if isForeignKeyViolated(err) {
+			return skydb.ErrUserNotFound
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *conn) RevokeUserRoles(userIDs []string, roles []string) error {
+	log.Debugf("RevokeUserRoles %v %v", userIDs, roles)
+	if len(userIDs) == 0 || len(roles) == 0 {
+		return nil
+	}
+	if err := c.ensureRoleExist(roles); err != nil {
+		return err
+	}
+
+	builder := psql.Delete(c.tableName("_user_role")).
+		Where("user_id IN ("+sq.Placeholders(len(userIDs))+")", stringsToInterfaces(userIDs)...).
+		Where("role_id IN ("+sq.Placeholders(len(roles))+")", stringsToInterfaces(roles)...)
+	_, err := c.ExecWith(builder)
+	return err
+}
+
+func (c *conn) GetRolePermissions(roles []string) ([]string, error) {
+	permissions := []string{}
+	if len(roles) == 0 {
+		return permissions, nil
+	}
+
+	builder := psql.Select("permissions").
+		From(c.tableName("_role")).
+		Where("id IN ("+sq.Placeholders(len(roles))+")", stringsToInterfaces(roles)...)
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rolePermissions nullJSONStringSlice
+		if err := rows.Scan(&rolePermissions); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions,
+			utils.StringSliceExcept(rolePermissions.slice, permissions)...)
+	}
+	return permissions, rows.Err()
+}
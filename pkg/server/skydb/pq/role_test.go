@@ -219,3 +219,104 @@ func TestRoleType(t *testing.T) {
 		})
 	})
 }
+
+func TestRoleHierarchy(t *testing.T) {
+	var c *conn
+
+	Convey("Conn", t, func() {
+		c = getTestConn(t)
+		defer cleanupConn(t, c)
+
+		So(c.CreateRole(&skydb.Role{
+			Name:        "writer",
+			Permissions: []string{"post:create"},
+		}), ShouldBeNil)
+		So(c.CreateRole(&skydb.Role{
+			Name:        "editor",
+			Parent:      "writer",
+			Permissions: []string{"post:publish"},
+		}), ShouldBeNil)
+
+		Convey("create role with parent and permissions", func() {
+			var (
+				parent      string
+				permissions nullJSONStringSlice
+			)
+			err := c.QueryRowx("SELECT parent_id, permissions FROM _role WHERE id = 'editor'").
+				Scan(&parent, &permissions)
+			So(err, ShouldBeNil)
+			So(parent, ShouldEqual, "writer")
+			So(permissions.slice, ShouldResemble, []string{"post:publish"})
+		})
+
+		Convey("reject duplicated role", func() {
+			err := c.CreateRole(&skydb.Role{Name: "writer"})
+			So(err, ShouldEqual, skydb.ErrRoleDuplicated)
+		})
+
+		Convey("reject role with non-existent parent", func() {
+			err := c.CreateRole(&skydb.Role{Name: "reader", Parent: "nobody"})
+			So(err, ShouldEqual, skydb.ErrRoleNotFound)
+		})
+
+		Convey("list roles", func() {
+			roles, err := c.GetRoles()
+			So(err, ShouldBeNil)
+			So(roles, ShouldContain, skydb.Role{
+				Name:        "editor",
+				Parent:      "writer",
+				Permissions: []string{"post:publish"},
+			})
+		})
+
+		Convey("assign and revoke roles", func() {
+			userinfo := skydb.UserInfo{ID: "userid"}
+			So(c.CreateUser(&userinfo), ShouldBeNil)
+
+			So(c.AssignUserRoles([]string{"userid"}, []string{"editor"}), ShouldBeNil)
+			So(c.AssignUserRoles([]string{"userid"}, []string{"editor"}), ShouldBeNil)
+
+			fetched := skydb.UserInfo{}
+			So(c.GetUser("userid", &fetched), ShouldBeNil)
+			So(fetched.Roles, ShouldResemble, []string{"editor"})
+			So(fetched.InheritedRoles, ShouldResemble, []string{"writer"})
+			So(fetched.EffectiveRoles(), ShouldResemble, []string{"editor", "writer"})
+
+			So(c.RevokeUserRoles([]string{"userid"}, []string{"editor"}), ShouldBeNil)
+			fetched = skydb.UserInfo{}
+			So(c.GetUser("userid", &fetched), ShouldBeNil)
+			So(fetched.Roles, ShouldBeEmpty)
+			So(fetched.InheritedRoles, ShouldBeEmpty)
+		})
+
+		Convey("reject assigning non-existent role or user", func() {
+			err := c.AssignUserRoles([]string{"userid"}, []string{"nobody"})
+			So(err, ShouldEqual, skydb.ErrRoleNotFound)
+
+			err = c.AssignUserRoles([]string{"nobody"}, []string{"writer"})
+			So(err, ShouldEqual, skydb.ErrUserNotFound)
+		})
+
+		Convey("get permissions of roles", func() {
+			permissions, err := c.GetRolePermissions([]string{"editor", "writer"})
+			So(err, ShouldBeNil)
+			So(permissions, ShouldContain, "post:create")
+			So(permissions, ShouldContain, "post:publish")
+			So(len(permissions), ShouldEqual, 2)
+		})
+
+		Convey("delete role", func() {
+			userinfo := skydb.UserInfo{ID: "userid"}
+			So(c.CreateUser(&userinfo), ShouldBeNil)
+			So(c.AssignUserRoles([]string{"userid"}, []string{"editor"}), ShouldBeNil)
+
+			So(c.DeleteRole("writer"), ShouldBeNil)
+			So(c.DeleteRole("writer"), ShouldEqual, skydb.ErrRoleNotFound)
+
+			fetched := skydb.UserInfo{}
+			So(c.GetUser("userid", &fetched), ShouldBeNil)
+			So(fetched.Roles, ShouldResemble, []string{"editor"})
+			So(fetched.InheritedRoles, ShouldBeEmpty)
+		})
+	})
+}
@@ -15,17 +15,23 @@
 package pq
 
 import (
+	"bytes"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/lib/pq"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 )
 
 func isDeviceNotFound(err error) bool {
@@ -70,7 +76,12 @@ func (ni *nullNotificationInfo) Scan(value interface{}) error {
 type queryValue skydb.Query
 
 func (query queryValue) Value() (driver.Value, error) {
-	return json.Marshal(query)
+	// the predicate is saved with typed values so that it can be matched
+	// against records after it is loaded
+	return json.Marshal(struct {
+		skydb.Query
+		Predicate jsonPredicate
+	}{skydb.Query(query), jsonPredicate(query.Predicate)})
 }
 
 func (query *queryValue) Scan(value interface{}) error {
@@ -111,6 +122,25 @@ func (query *queryValue) Scan(value interface{}) error {
 
 type jsonPredicate skydb.Predicate
 
+func (p jsonPredicate) MarshalJSON() ([]byte, error) {
+	children := []interface{}{}
+	for _, child := range p.Children {
+		switch child := child.(type) {
+		case skydb.Predicate:
+			children = append(children, jsonPredicate(child))
+		case skydb.Expression:
+			children = append(children, jsonExpression(child))
+		default:
+			children = append(children, child)
+		}
+	}
+
+	return json.Marshal(struct {
+		Operator skydb.Operator
+		Children []interface{}
+	}{p.Operator, children})
+}
+
 func (p *jsonPredicate) UnmarshalJSON(data []byte) error {
 	v := struct {
 		Operator skydb.Operator
@@ -132,18 +162,129 @@ func (p *jsonPredicate) UnmarshalJSON(data []byte) error {
 			p.Children = append(p.Children, skydb.Predicate(pred))
 		}
 	} else {
-		expressions := []skydb.Expression{}
+		expressions := []jsonExpression{}
 		if err := json.Unmarshal(v.Children, &expressions); err != nil {
 			return err
 		}
 		for _, expr := range expressions {
-			p.Children = append(p.Children, expr)
+			p.Children = append(p.Children, skydb.Expression(expr))
 		}
 	}
 
 	return nil
 }
 
+// jsonExpression is a skydb.Expression saved in JSON. Literal values are
+// saved in the same form as record data in the API, and functions are
+// saved with their names in "$func".
+//
+// Expressions saved before typed values were introduced are loaded with
+// the values as decoded from JSON.
+type jsonExpression skydb.Expression
+
+func (expr jsonExpression) MarshalJSON() ([]byte, error) {
+	var value interface{}
+	switch expr.Type {
+	case skydb.Literal:
+		value = encodeLiteral(expr.Value)
+	case skydb.Function:
+		value = encodeFunc(expr.Value)
+	default:
+		value = expr.Value
+	}
+
+	return json.Marshal(struct {
+		Type  skydb.ExpressionType
+		Value interface{}
+	}{expr.Type, value})
+}
+
+func (expr *jsonExpression) UnmarshalJSON(data []byte) (err error) {
+	v := struct {
+		Type  skydb.ExpressionType
+		Value interface{}
+	}{}
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	expr.Type = v.Type
+	switch v.Type {
+	case skydb.Literal:
+		defer func() {
+			// skyconv.ParseLiteral panics on malformed value
+			if r := recover(); r != nil {
+				err = fmt.Errorf("malformed literal in expression: %v", r)
+			}
+		}()
+		expr.Value = skyconv.ParseLiteral(v.Value)
+	case skydb.Function:
+		expr.Value = decodeFunc(v.Value)
+	default:
+		expr.Value = v.Value
+	}
+	return nil
+}
+
+func encodeLiteral(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return skyconv.ToMap(skyconv.MapTime(v))
+	case skydb.Reference:
+		return skyconv.ToMap(skyconv.MapReference(v))
+	case skydb.Location:
+		return skyconv.ToMap(skyconv.MapLocation(v))
+	case *skydb.Location:
+		if v == nil {
+			return nil
+		}
+		return skyconv.ToMap(skyconv.MapLocation(*v))
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = encodeLiteral(item)
+		}
+		return values
+	}
+	return value
+}
+
+// encodeFunc encodes a function which can be matched against records.
+func encodeFunc(value interface{}) interface{} {
+	if f, ok := value.(skydb.DistanceFunc); ok {
+		return map[string]interface{}{
+			"$func":    "distance",
+			"field":    f.Field,
+			"location": skyconv.ToMap(skyconv.MapLocation(f.Location)),
+		}
+	}
+	return value
+}
+
+// decodeFunc decodes a function saved by encodeFunc. Other functions are
+// returned as is, which cannot be matched against records.
+func decodeFunc(value interface{}) interface{} {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+
+	if m["$func"] == "distance" {
+		field, _ := m["field"].(string)
+		location := skydb.Location{}
+		locationMap, _ := m["location"].(map[string]interface{})
+		if err := (*skyconv.MapLocation)(&location).FromMap(locationMap); err != nil {
+			return value
+		}
+		return skydb.DistanceFunc{
+			Field:    field,
+			Location: location,
+		}
+	}
+	return value
+}
+
 func (db *database) GetSubscription(key string, deviceID string, subscription *skydb.Subscription) error {
 	if db.DatabaseType() == skydb.UnionDatabase {
 		return errors.New("union database does not implement subscription")
@@ -189,6 +330,9 @@ func (db *database) SaveSubscription(subscription *skydb.Subscription) error {
 	if subscription.DeviceID == "" {
 		return errors.New("empty device id")
 	}
+	if err := checkPredicateMatchable(&subscription.Query.Predicate); err != nil {
+		return err
+	}
 
 	nullinfo := nullNotificationInfo{}
 	if subscription.NotificationInfo != nil {
@@ -346,10 +490,32 @@ func (db *database) GetMatchingSubscriptions(record *skydb.Record) (subscription
 		subscriptions = append(subscriptions, s)
 	}
 
+	// referenced records are fetched once for all subscriptions
+	referenced := map[skydb.RecordID]*skydb.Record{}
+	matcher := recordMatcher{
+		getRecord: func(id skydb.RecordID, record *skydb.Record) error {
+			if r, ok := referenced[id]; ok {
+				if r == nil {
+					return skydb.ErrRecordNotFound
+				}
+				*record = *r
+				return nil
+			}
+			err := db.Get(id, record)
+			if err == skydb.ErrRecordNotFound {
+				referenced[id] = nil
+			} else if err == nil {
+				r := *record
+				referenced[id] = &r
+			}
+			return err
+		},
+	}
+
 	// filter without allocation
 	matchingSubs := subscriptions[:0]
 	for _, subscription := range subscriptions {
-		if predMatchRecord(&(subscription.Query.Predicate), record) {
+		if matcher.match(&(subscription.Query.Predicate), record) {
 			matchingSubs = append(matchingSubs, subscription)
 		}
 	}
@@ -367,85 +533,484 @@ func (db *database) GetMatchingSubscriptions(record *skydb.Record) (subscription
 	return matchingSubs
 }
 
-func predMatchRecord(p *skydb.Predicate, record *skydb.Record) (b bool) {
+func (db *database) MatchQuery(query *skydb.Query, record *skydb.Record) (bool, error) {
+	if err := checkPredicateMatchable(&query.Predicate); err != nil {
+		return false, err
+	}
+
+	if query.Type != record.ID.Type {
+		return false, nil
+	}
+
+	matcher := recordMatcher{getRecord: db.Get}
+	return matcher.match(&query.Predicate, record), nil
+}
+
+// predMatchRecord returns whether the record satisfies the predicate.
+// Key paths into referenced records are not followed.
+func predMatchRecord(p *skydb.Predicate, record *skydb.Record) bool {
+	matcher := recordMatcher{}
+	return matcher.match(p, record)
+}
+
+// recordMatcher evaluates a predicate against a record in memory, with the
+// same semantics as the SQL generated by predicateSqlizerFactory, which
+// includes the three-valued logic of NULL.
+type recordMatcher struct {
+	// getRecord fetches a record referenced by a key path of two
+	// components. If getRecord is nil, such key path evaluates to NULL.
+	getRecord func(id skydb.RecordID, record *skydb.Record) error
+}
+
+// match returns whether the record satisfies the predicate. A predicate
+// which cannot be evaluated does not match any record.
+func (m *recordMatcher) match(p *skydb.Predicate, record *skydb.Record) bool {
+	result, err := m.evaluate(p, record)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"predicate": p,
+			"record":    record.ID,
+			"err":       err,
+		}).Errorln("failed to match record with predicate")
+		return false
+	}
+	return result == matchTrue
+}
+
+// matchResult is the three-valued result of a predicate in SQL.
+type matchResult int
+
+const (
+	matchFalse matchResult = iota
+	matchTrue
+	matchUnknown
+)
+
+func matchResultOf(b bool) matchResult {
+	if b {
+		return matchTrue
+	}
+	return matchFalse
+}
+
+func (m *recordMatcher) evaluate(p *skydb.Predicate, record *skydb.Record) (matchResult, error) {
 	if p == nil || p.IsEmpty() {
-		return true
+		return matchTrue, nil
 	}
 
 	switch p.Operator {
 	case skydb.And:
-		b = true
+		result := matchTrue
 		for _, childPred := range p.GetSubPredicates() {
-			if !predMatchRecord(&childPred, record) {
-				b = false
-				break
+			childResult, err := m.evaluate(&childPred, record)
+			if err != nil {
+				return matchFalse, err
+			}
+			if childResult == matchFalse {
+				return matchFalse, nil
+			} else if childResult == matchUnknown {
+				result = matchUnknown
 			}
 		}
+		return result, nil
 	case skydb.Or:
+		result := matchFalse
 		for _, childPred := range p.GetSubPredicates() {
-			if predMatchRecord(&childPred, record) {
-				b = true
-				break
+			childResult, err := m.evaluate(&childPred, record)
+			if err != nil {
+				return matchFalse, err
+			}
+			if childResult == matchTrue {
+				return matchTrue, nil
+			} else if childResult == matchUnknown {
+				result = matchUnknown
 			}
 		}
+		return result, nil
 	case skydb.Not:
-		b = !predMatchRecord(&p.GetSubPredicates()[0], record)
+		childResult, err := m.evaluate(&p.GetSubPredicates()[0], record)
+		if err != nil {
+			return matchFalse, err
+		}
+		switch childResult {
+		case matchTrue:
+			return matchFalse, nil
+		case matchFalse:
+			return matchTrue, nil
+		}
+		return matchUnknown, nil
+	case skydb.In:
+		return m.evaluateIn(p, record)
+	case skydb.Equal, skydb.NotEqual, skydb.GreaterThan, skydb.LessThan,
+		skydb.GreaterThanOrEqual, skydb.LessThanOrEqual, skydb.Like, skydb.ILike:
+		return m.evaluateComparison(p, record)
+	}
+
+	return matchFalse, fmt.Errorf("operator `%v` is not supported", p.Operator)
+}
+
+func (m *recordMatcher) evaluateComparison(p *skydb.Predicate, record *skydb.Record) (matchResult, error) {
+	exprs := p.GetExpressions()
+	lhs, rhs := exprs[0], exprs[1]
+	if p.Operator.IsCommutative() && lhs.IsLiteralNull() && !rhs.IsLiteralNull() {
+		lhs, rhs = rhs, lhs
+	}
+
+	lv, err := m.extractValue(lhs, record)
+	if err != nil {
+		return matchFalse, err
+	}
+	rv, err := m.extractValue(rhs, record)
+	if err != nil {
+		return matchFalse, err
+	}
+
+	if rhs.IsLiteralNull() {
+		// IS NULL and IS NOT NULL
+		switch p.Operator {
+		case skydb.Equal:
+			return matchResultOf(lv == nil), nil
+		case skydb.NotEqual:
+			return matchResultOf(lv != nil), nil
+		}
+	}
+	if lv == nil || rv == nil {
+		return matchUnknown, nil
+	}
+
+	switch p.Operator {
 	case skydb.Equal:
-		lv, rv := extractBinaryOperands(p.GetExpressions(), record)
-		return reflect.DeepEqual(lv, rv)
-	// case skydb.GreaterThan:
-	// case skydb.LessThan:
-	// case skydb.GreaterThanOrEqual:
-	// case skydb.LessThanOrEqual:
+		return matchResultOf(valueEqual(lv, rv)), nil
 	case skydb.NotEqual:
-		lv, rv := extractBinaryOperands(p.GetExpressions(), record)
-		return !reflect.DeepEqual(lv, rv)
-	case skydb.In:
-		lv, rv := extractBinaryOperands(p.GetExpressions(), record)
-		haystack, ok := rv.([]interface{})
-		if !ok {
-			log.Panicf("unknown value in right hand side of `In` operand = %v", rv)
+		return matchResultOf(!valueEqual(lv, rv)), nil
+	case skydb.Like, skydb.ILike:
+		str, ok := lv.(string)
+		pattern, patternOK := rv.(string)
+		if !ok || !patternOK {
+			return matchFalse, fmt.Errorf("operator `%v` compares strings, got %T and %T", p.Operator, lv, rv)
 		}
+		matched, err := likeMatch(str, pattern, p.Operator == skydb.ILike)
+		return matchResultOf(matched), err
+	}
 
-		return deepEqualIn(lv, haystack)
-	// case skydb.Like:
-	// case skydb.ILike:
+	cmp, err := compareValues(lv, rv)
+	if err != nil {
+		return matchFalse, err
+	}
+	switch p.Operator {
+	case skydb.GreaterThan:
+		return matchResultOf(cmp > 0), nil
+	case skydb.LessThan:
+		return matchResultOf(cmp < 0), nil
+	case skydb.GreaterThanOrEqual:
+		return matchResultOf(cmp >= 0), nil
 	default:
-		log.Panicf("unknown Predicate.Operator = %v", p.Operator)
+		return matchResultOf(cmp <= 0), nil
 	}
-
-	return
 }
 
-func extractBinaryOperands(exprs []skydb.Expression, record *skydb.Record) (lv interface{}, rv interface{}) {
-	lv = extractValue(exprs[0], record)
-	rv = extractValue(exprs[1], record)
-	return
+// evaluateIn evaluates `keypath IN (values...)` if the key path is on the
+// left hand side, or whether the JSON array or object at the key path
+// contains the string on the left hand side otherwise.
+func (m *recordMatcher) evaluateIn(p *skydb.Predicate, record *skydb.Record) (matchResult, error) {
+	exprs := p.GetExpressions()
+	lv, err := m.extractValue(exprs[0], record)
+	if err != nil {
+		return matchFalse, err
+	}
+	rv, err := m.extractValue(exprs[1], record)
+	if err != nil {
+		return matchFalse, err
+	}
+
+	if exprs[0].Type == skydb.Literal && exprs[1].Type == skydb.KeyPath {
+		needle, ok := lv.(string)
+		if !ok {
+			return matchFalse, fmt.Errorf("operator `In` with a key path on the right hand side requires a string, got %T", lv)
+		}
+		switch haystack := rv.(type) {
+		case nil:
+			return matchUnknown, nil
+		case []interface{}:
+			for _, hay := range haystack {
+				if hay == needle {
+					return matchTrue, nil
+				}
+			}
+			return matchFalse, nil
+		case map[string]interface{}:
+			_, ok := haystack[needle]
+			return matchResultOf(ok), nil
+		default:
+			return matchFalse, nil
+		}
+	}
+
+	haystack, ok := rv.([]interface{})
+	if !ok {
+		return matchFalse, fmt.Errorf("unknown value in right hand side of `In` operand = %v", rv)
+	}
+	if len(haystack) == 0 {
+		// `IN (NULL)` is never true
+		return matchFalse, nil
+	}
+	if lv == nil {
+		return matchUnknown, nil
+	}
+
+	result := matchFalse
+	for _, hay := range haystack {
+		normalized := normalizeValue(hay)
+		if normalized == nil {
+			result = matchUnknown
+		} else if valueEqual(lv, normalized) {
+			return matchTrue, nil
+		}
+	}
+	return result, nil
 }
 
-func extractValue(expr skydb.Expression, record *skydb.Record) interface{} {
+// extractValue returns the value of the expression evaluated for the
+// record, normalized by normalizeValue.
+func (m *recordMatcher) extractValue(expr skydb.Expression, record *skydb.Record) (interface{}, error) {
 	switch expr.Type {
 	case skydb.Literal:
-		switch expr.Value.(type) {
-		case bool, float64, string, time.Time, *skydb.Location, skydb.Reference, []interface{}:
-			return expr.Value
-		default:
-			panic(fmt.Sprintf("unknown type %[1]T of Expression.Value = %[1]v", expr.Value))
+		if array, ok := expr.Value.([]interface{}); ok {
+			return array, nil
 		}
+		return normalizeValue(expr.Value), nil
 	case skydb.KeyPath:
-		return record.Get(expr.Value.(string))
+		return m.extractKeyPath(expr, record)
 	case skydb.Function:
-		panic("unsupported type of predicate expression = Function")
+		distanceFunc, ok := expr.Value.(skydb.DistanceFunc)
+		if !ok {
+			return nil, fmt.Errorf("function %T is not supported", expr.Value)
+		}
+		location, ok := normalizeValue(record.Get(distanceFunc.Field)).(skydb.Location)
+		if !ok {
+			return nil, nil
+		}
+		return sphereDistance(location, distanceFunc.Location), nil
 	}
 
-	panic("unreachable code")
+	return nil, fmt.Errorf("unknown type of expression = %v", expr.Type)
 }
 
-func deepEqualIn(needle interface{}, haystack []interface{}) bool {
-	for _, hay := range haystack {
-		if reflect.DeepEqual(needle, hay) {
-			return true
+func (m *recordMatcher) extractKeyPath(expr skydb.Expression, record *skydb.Record) (interface{}, error) {
+	components := expr.KeyPathComponents()
+	switch len(components) {
+	case 1:
+		return normalizeRecordValue(components[0], record.Get(components[0])), nil
+	case 2:
+		ref, ok := record.Get(components[0]).(skydb.Reference)
+		if !ok || ref.IsEmpty() || m.getRecord == nil {
+			return nil, nil
 		}
+
+		referenced := skydb.Record{}
+		if err := m.getRecord(ref.ID, &referenced); err == skydb.ErrRecordNotFound {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return normalizeRecordValue(components[1], referenced.Get(components[1])), nil
 	}
-	return false
+
+	return nil, fmt.Errorf(`keypath "%v" with more than 2 components is not supported`, expr.Value)
+}
+
+// normalizeRecordValue normalizes a value of a record. The zero time of
+// the timestamps of a record not yet saved is considered NULL.
+func normalizeRecordValue(key string, value interface{}) interface{} {
+	if t, ok := value.(time.Time); ok && t.IsZero() && (key == "_created_at" || key == "_updated_at") {
+		return nil
+	}
+	return normalizeValue(value)
+}
+
+// normalizeValue converts a value of a record or a literal into the form
+// stored in the database, so that they can be compared.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case skydb.Reference:
+		return v.ID.Key
+	case *skydb.Reference:
+		if v == nil {
+			return nil
+		}
+		return v.ID.Key
+	case skydb.Location:
+		return v
+	case *skydb.Location:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case *skydb.Asset:
+		if v == nil {
+			return nil
+		}
+		return v.Name
+	case skydb.Asset:
+		return v.Name
+	case time.Time:
+		return v.UTC()
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	}
+	return value
+}
+
+func valueEqual(lv, rv interface{}) bool {
+	if lt, ok := lv.(time.Time); ok {
+		rt, ok := rv.(time.Time)
+		return ok && lt.Equal(rt)
+	}
+	return reflect.DeepEqual(lv, rv)
+}
+
+// compareValues returns -1, 0 or 1 if lv is less than, equal to or greater
+// than rv. Values of different types cannot be compared.
+func compareValues(lv, rv interface{}) (int, error) {
+	switch l := lv.(type) {
+	case float64:
+		if r, ok := rv.(float64); ok {
+			switch {
+			case l < r:
+				return -1, nil
+			case l > r:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if r, ok := rv.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	case time.Time:
+		if r, ok := rv.(time.Time); ok {
+			switch {
+			case l.Before(r):
+				return -1, nil
+			case l.After(r):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case bool:
+		if r, ok := rv.(bool); ok {
+			switch {
+			case l == r:
+				return 0, nil
+			case r:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %T with %T", lv, rv)
+}
+
+// likeMatch returns whether str matches the LIKE pattern, in which `%`
+// matches any sequence of characters, `_` matches any single character
+// and `\` escapes the next character.
+func likeMatch(str string, pattern string, caseInsensitive bool) (bool, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString(`^`)
+	if caseInsensitive {
+		buffer.WriteString(`(?i)`)
+	}
+	buffer.WriteString(`(?s)`)
+
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			buffer.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			buffer.WriteString(`.*`)
+		case r == '_':
+			buffer.WriteString(`.`)
+		default:
+			buffer.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		return false, errors.New("LIKE pattern must not end with escape character")
+	}
+	buffer.WriteString(`$`)
+
+	re, err := regexp.Compile(buffer.String())
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(str), nil
+}
+
+// sphereEarthRadius is the radius of the earth used by ST_Distance_Sphere.
+const sphereEarthRadius = 6370986.0
+
+// sphereDistance returns the distance in meters between two locations on
+// a spherical earth, as calculated by ST_Distance_Sphere.
+func sphereDistance(a, b skydb.Location) float64 {
+	lat1 := a.Lat() * math.Pi / 180
+	lat2 := b.Lat() * math.Pi / 180
+	dLat := lat2 - lat1
+	dLng := (b.Lng() - a.Lng()) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * sphereEarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// checkPredicateMatchable returns an error if the predicate cannot be
+// evaluated by recordMatcher, so that such subscription is rejected
+// when saved.
+func checkPredicateMatchable(p *skydb.Predicate) skyerr.Error {
+	if p == nil || p.IsEmpty() {
+		return nil
+	}
+
+	switch p.Operator {
+	case skydb.And, skydb.Or, skydb.Not:
+		for _, childPred := range p.GetSubPredicates() {
+			if err := checkPredicateMatchable(&childPred); err != nil {
+				return err
+			}
+		}
+		return nil
+	case skydb.Functional:
+		return skyerr.NewError(skyerr.NotSupported,
+			"functional predicate is not supported in subscription")
+	}
+
+	if !p.Operator.IsBinary() {
+		return skyerr.NewErrorf(skyerr.NotSupported,
+			"operator `%v` is not supported in subscription", p.Operator)
+	}
+
+	for _, expr := range p.GetExpressions() {
+		switch expr.Type {
+		case skydb.KeyPath:
+			if len(expr.KeyPathComponents()) > 2 {
+				return skyerr.NewErrorf(skyerr.NotSupported,
+					`keypath "%v" with more than 2 components is not supported in subscription`, expr.Value)
+			}
+		case skydb.Function:
+			if _, ok := expr.Value.(skydb.DistanceFunc); !ok {
+				return skyerr.NewErrorf(skyerr.NotSupported,
+					"function %T is not supported in subscription", expr.Value)
+			}
+		}
+	}
+	return nil
 }
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -195,6 +196,21 @@ func TestSubscriptionCRUD(t *testing.T) {
 			So(err, ShouldEqual, skydb.ErrDeviceNotFound)
 		})
 
+		Convey("cannot save subscription with predicate that cannot be matched", func() {
+			subscription.Query.Predicate = skydb.Predicate{
+				Operator: skydb.Functional,
+				Children: []interface{}{
+					skydb.Expression{
+						Type:  skydb.Function,
+						Value: skydb.UserDiscoverFunc{Usernames: []string{"john"}},
+					},
+				},
+			}
+			err := db.SaveSubscription(&subscription)
+			So(err, ShouldHaveSameTypeAs, skyerr.NewError(skyerr.NotSupported, ""))
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.NotSupported)
+		})
+
 		Convey("delets an existing subscription", func() {
 			So(db.SaveSubscription(&subscription), ShouldBeNil)
 
@@ -405,6 +421,56 @@ func subscriptionForTest(deviceID, id, queryRecordType string) skydb.Subscriptio
 	}
 }
 
+func TestMatchQuery(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB()
+		record := skydb.Record{
+			ID:   skydb.NewRecordID("note", "id"),
+			Data: map[string]interface{}{"title": "Hello"},
+		}
+		query := skydb.Query{
+			Type: "note",
+			Predicate: skydb.Predicate{
+				Operator: skydb.Equal,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "title"},
+					skydb.Expression{Type: skydb.Literal, Value: "Hello"},
+				},
+			},
+		}
+
+		Convey("matches record satisfying the query", func() {
+			matched, err := db.MatchQuery(&query, &record)
+			So(err, ShouldBeNil)
+			So(matched, ShouldBeTrue)
+		})
+
+		Convey("does not match record of another type", func() {
+			query.Type = "comment"
+			matched, err := db.MatchQuery(&query, &record)
+			So(err, ShouldBeNil)
+			So(matched, ShouldBeFalse)
+		})
+
+		Convey("returns error for predicate that cannot be matched", func() {
+			query.Predicate = skydb.Predicate{
+				Operator: skydb.Functional,
+				Children: []interface{}{
+					skydb.Expression{
+						Type:  skydb.Function,
+						Value: skydb.UserDiscoverFunc{Usernames: []string{"john"}},
+					},
+				},
+			}
+			_, err := db.MatchQuery(&query, &record)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.NotSupported)
+		})
+	})
+}
+
 func TestPredicateMatchRecord(t *testing.T) {
 	Convey("Records", t, func() {
 		record1 := skydb.Record{ID: skydb.NewRecordID("record", "id")}
@@ -447,5 +513,295 @@ func TestPredicateMatchRecord(t *testing.T) {
 
 			So(predMatchRecord(&predicate, &record1), ShouldBeFalse)
 		})
+
+		binaryPred := func(op skydb.Operator, k string, v interface{}) skydb.Predicate {
+			return skydb.Predicate{
+				Operator: op,
+				Children: []interface{}{
+					skydb.Expression{
+						Type:  skydb.KeyPath,
+						Value: k,
+					},
+					skydb.Expression{
+						Type:  skydb.Literal,
+						Value: v,
+					},
+				},
+			}
+		}
+
+		createdAt := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		record2 := skydb.Record{
+			ID:        skydb.NewRecordID("note", "id"),
+			CreatedAt: createdAt,
+			Data: map[string]interface{}{
+				"title":    "Hello World",
+				"score":    float64(3),
+				"count":    int64(5),
+				"city":     skydb.NewReference("city", "hongkong"),
+				"location": skydb.NewLocation(114.1694, 22.3193),
+				"tags":     []interface{}{"news", "sports"},
+				"empty":    nil,
+			},
+		}
+
+		Convey("Match record with comparison predicates", func() {
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.GreaterThan, "score", float64(2))}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.GreaterThan, "score", float64(3))}[0], &record2), ShouldBeFalse)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.GreaterThanOrEqual, "score", float64(3))}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.LessThan, "count", float64(6))}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.LessThanOrEqual, "count", float64(4))}[0], &record2), ShouldBeFalse)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.LessThan, "title", "Hi")}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.GreaterThan, "_created_at", createdAt.Add(-time.Second))}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.LessThan, "_created_at", createdAt)}[0], &record2), ShouldBeFalse)
+		})
+
+		Convey("Match record with predicate like", func() {
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.Like, "title", "Hello%")}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.Like, "title", "hello%")}[0], &record2), ShouldBeFalse)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.ILike, "title", "hello%")}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.Like, "title", "Hello_World")}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.Like, "title", "Hello\\_World")}[0], &record2), ShouldBeFalse)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.Like, "title", "%.%")}[0], &record2), ShouldBeFalse)
+		})
+
+		Convey("Match record with null semantics", func() {
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.Equal, "empty", nil)}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.Equal, "missing", nil)}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.NotEqual, "title", nil)}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.NotEqual, "missing", "value")}[0], &record2), ShouldBeFalse)
+
+			predicate := skydb.Predicate{
+				Operator: skydb.Not,
+				Children: []interface{}{
+					binaryPred(skydb.Equal, "missing", "value"),
+				},
+			}
+			So(predMatchRecord(&predicate, &record2), ShouldBeFalse)
+		})
+
+		Convey("Match record with reference and in", func() {
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.Equal, "city", skydb.NewReference("city", "hongkong"))}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.Equal, "_id", skydb.NewReference("note", "id"))}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.In, "count", []interface{}{float64(5), float64(6)})}[0], &record2), ShouldBeTrue)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.In, "count", []interface{}{})}[0], &record2), ShouldBeFalse)
+
+			predicate := skydb.Predicate{
+				Operator: skydb.In,
+				Children: []interface{}{
+					skydb.Expression{
+						Type:  skydb.Literal,
+						Value: "sports",
+					},
+					skydb.Expression{
+						Type:  skydb.KeyPath,
+						Value: "tags",
+					},
+				},
+			}
+			So(predMatchRecord(&predicate, &record2), ShouldBeTrue)
+		})
+
+		Convey("Match record with distance", func() {
+			distancePred := func(op skydb.Operator, meters float64) *skydb.Predicate {
+				return &skydb.Predicate{
+					Operator: op,
+					Children: []interface{}{
+						skydb.Expression{
+							Type: skydb.Function,
+							Value: skydb.DistanceFunc{
+								Field: "location",
+								// Kowloon, about 3.5 km away
+								Location: skydb.NewLocation(114.1722, 22.3500),
+							},
+						},
+						skydb.Expression{
+							Type:  skydb.Literal,
+							Value: meters,
+						},
+					},
+				}
+			}
+			So(predMatchRecord(distancePred(skydb.LessThan, 4000), &record2), ShouldBeTrue)
+			So(predMatchRecord(distancePred(skydb.LessThan, 3000), &record2), ShouldBeFalse)
+			So(predMatchRecord(distancePred(skydb.GreaterThan, 3000), &record2), ShouldBeTrue)
+		})
+
+		Convey("Match record with key path into reference", func() {
+			predicate := binaryPred(skydb.Equal, "city.name", "Hong Kong")
+			matcher := recordMatcher{
+				getRecord: func(id skydb.RecordID, record *skydb.Record) error {
+					if id != skydb.NewRecordID("city", "hongkong") {
+						return skydb.ErrRecordNotFound
+					}
+					*record = skydb.Record{
+						ID:   id,
+						Data: map[string]interface{}{"name": "Hong Kong"},
+					}
+					return nil
+				},
+			}
+			So(matcher.match(&predicate, &record2), ShouldBeTrue)
+
+			record2.Data["city"] = skydb.NewReference("city", "tokyo")
+			So(matcher.match(&predicate, &record2), ShouldBeFalse)
+		})
+
+		Convey("Not match record with unsupported predicate", func() {
+			predicate := skydb.Predicate{
+				Operator: skydb.Functional,
+				Children: []interface{}{
+					skydb.Expression{
+						Type: skydb.Function,
+						Value: skydb.UserRelationFunc{
+							KeyPath:           "_owner",
+							RelationName:      "_friend",
+							RelationDirection: "outward",
+							User:              "userid",
+						},
+					},
+				},
+			}
+			So(predMatchRecord(&predicate, &record2), ShouldBeFalse)
+			So(predMatchRecord(&[]skydb.Predicate{binaryPred(skydb.GreaterThan, "title", float64(1))}[0], &record2), ShouldBeFalse)
+		})
+	})
+}
+
+func TestCheckPredicateMatchable(t *testing.T) {
+	Convey("checkPredicateMatchable", t, func() {
+		Convey("accepts predicates with distance and key path", func() {
+			predicate := skydb.Predicate{
+				Operator: skydb.And,
+				Children: []interface{}{
+					skydb.Predicate{
+						Operator: skydb.LessThan,
+						Children: []interface{}{
+							skydb.Expression{
+								Type:  skydb.Function,
+								Value: skydb.DistanceFunc{Field: "location"},
+							},
+							skydb.Expression{Type: skydb.Literal, Value: float64(100)},
+						},
+					},
+					skydb.Predicate{
+						Operator: skydb.Like,
+						Children: []interface{}{
+							skydb.Expression{Type: skydb.KeyPath, Value: "city.name"},
+							skydb.Expression{Type: skydb.Literal, Value: "Hong%"},
+						},
+					},
+				},
+			}
+			So(checkPredicateMatchable(&predicate), ShouldBeNil)
+		})
+
+		Convey("rejects functional predicate", func() {
+			predicate := skydb.Predicate{
+				Operator: skydb.Not,
+				Children: []interface{}{
+					skydb.Predicate{
+						Operator: skydb.Functional,
+						Children: []interface{}{
+							skydb.Expression{
+								Type:  skydb.Function,
+								Value: skydb.UserDiscoverFunc{Usernames: []string{"john"}},
+							},
+						},
+					},
+				},
+			}
+			err := checkPredicateMatchable(&predicate)
+			So(err, ShouldNotBeNil)
+			So(err.Code(), ShouldEqual, skyerr.NotSupported)
+		})
+
+		Convey("rejects key path with more than 2 components", func() {
+			predicate := skydb.Predicate{
+				Operator: skydb.Equal,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "city.country.name"},
+					skydb.Expression{Type: skydb.Literal, Value: "China"},
+				},
+			}
+			So(checkPredicateMatchable(&predicate), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestQueryValue(t *testing.T) {
+	Convey("queryValue", t, func() {
+		Convey("saves predicate with typed values", func() {
+			query := skydb.Query{
+				Type: "note",
+				Predicate: skydb.Predicate{
+					Operator: skydb.And,
+					Children: []interface{}{
+						skydb.Predicate{
+							Operator: skydb.GreaterThan,
+							Children: []interface{}{
+								skydb.Expression{Type: skydb.KeyPath, Value: "_created_at"},
+								skydb.Expression{
+									Type:  skydb.Literal,
+									Value: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+								},
+							},
+						},
+						skydb.Predicate{
+							Operator: skydb.In,
+							Children: []interface{}{
+								skydb.Expression{Type: skydb.KeyPath, Value: "city"},
+								skydb.Expression{
+									Type:  skydb.Literal,
+									Value: []interface{}{skydb.NewReference("city", "hongkong")},
+								},
+							},
+						},
+						skydb.Predicate{
+							Operator: skydb.LessThan,
+							Children: []interface{}{
+								skydb.Expression{
+									Type: skydb.Function,
+									Value: skydb.DistanceFunc{
+										Field:    "location",
+										Location: skydb.NewLocation(114.1694, 22.3193),
+									},
+								},
+								skydb.Expression{Type: skydb.Literal, Value: float64(500)},
+							},
+						},
+					},
+				},
+			}
+
+			value, err := queryValue(query).Value()
+			So(err, ShouldBeNil)
+
+			scanned := queryValue{}
+			So(scanned.Scan(value), ShouldBeNil)
+			So(skydb.Query(scanned), ShouldResemble, query)
+		})
+
+		Convey("loads predicate saved with untyped values", func() {
+			scanned := queryValue{}
+			err := scanned.Scan([]byte(`{
+				"Type": "note",
+				"Predicate": {
+					"Operator": 4,
+					"Children": [
+						{"Type": 2, "Value": "title"},
+						{"Type": 1, "Value": "Hello"}
+					]
+				}
+			}`))
+			So(err, ShouldBeNil)
+			So(scanned.Predicate, ShouldResemble, skydb.Predicate{
+				Operator: skydb.Equal,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "title"},
+					skydb.Expression{Type: skydb.Literal, Value: "Hello"},
+				},
+			})
+		})
 	})
 }
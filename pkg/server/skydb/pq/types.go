@@ -89,6 +89,39 @@ func (njss *nullJSONStringSlice) Scan(value interface{}) error {
 	return err
 }
 
+func (njss nullJSONStringSlice) Value() (driver.Value, error) {
+	if !njss.Valid {
+		return nil, nil
+	}
+	return json.Marshal(njss.slice)
+}
+
+type nullJSONStringMap struct {
+	m     map[string]string
+	Valid bool
+}
+
+func (njsm *nullJSONStringMap) Scan(value interface{}) error {
+	data, ok := value.([]byte)
+	if value == nil || !ok {
+		njsm.m = nil
+		njsm.Valid = false
+		return nil
+	}
+
+	njsm.m = map[string]string{}
+	err := json.Unmarshal(data, &njsm.m)
+	njsm.Valid = err == nil
+	return err
+}
+
+func (njsm nullJSONStringMap) Value() (driver.Value, error) {
+	if !njsm.Valid {
+		return nil, nil
+	}
+	return json.Marshal(njsm.m)
+}
+
 type assetValue skydb.Asset
 
 func (asset assetValue) Value() (driver.Value, error) {
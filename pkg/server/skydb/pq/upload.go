@@ -0,0 +1,176 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) SaveUploadSession(session *skydb.UploadSession) error {
+	if session.ID == "" || session.CreatedAt.IsZero() {
+		return errors.New("invalid upload session: empty id or created at")
+	}
+
+	updatedAt := session.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = session.CreatedAt
+	}
+
+	pkData := map[string]interface{}{"id": session.ID}
+	data := map[string]interface{}{
+		"asset_name":    session.AssetName,
+		"content_type":  session.ContentType,
+		"size":          session.Size,
+		"upload_id":     session.UploadID,
+		"uploaded_size": session.Offset,
+		"chunks":        session.Chunks,
+		"user_id":       sql.NullString{String: session.UserID, Valid: session.UserID != ""},
+		"created_at":    session.CreatedAt.UTC(),
+		"updated_at":    updatedAt.UTC(),
+	}
+
+	upsert := upsertQuery(c.tableName("_asset_upload_session"), pkData, data).
+		IgnoreKeyOnUpdate("created_at")
+	_, err := c.ExecWith(upsert)
+	return err
+}
+
+func (c *conn) ClaimUploadSession(session *skydb.UploadSession, now time.Time, lease time.Duration) error {
+	builder := psql.Update(c.tableName("_asset_upload_session")).
+		Set("locked_until", now.Add(lease).UTC()).
+		Where("id = ? AND uploaded_size = ? AND (locked_until IS NULL OR locked_until <= ?)",
+			session.ID, session.Offset, now.UTC())
+
+	return c.execUploadSessionCAS(builder)
+}
+
+func (c *conn) UpdateUploadSession(session *skydb.UploadSession, offset int64) error {
+	builder := psql.Update(c.tableName("_asset_upload_session")).
+		Set("uploaded_size", session.Offset).
+		Set("chunks", session.Chunks).
+		Set("updated_at", session.UpdatedAt.UTC()).
+		Set("locked_until", nil).
+		Where("id = ? AND uploaded_size = ?", session.ID, offset)
+
+	return c.execUploadSessionCAS(builder)
+}
+
+// execUploadSessionCAS executes an update of upload session, returning
+// ErrUploadSessionConflict if no session is updated.
+func (c *conn) execUploadSessionCAS(builder sq.Sqlizer) error {
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrUploadSessionConflict
+	}
+
+	return nil
+}
+
+func (c *conn) GetUploadSession(id string, session *skydb.UploadSession) error {
+	builder := psql.Select(uploadSessionColumns...).
+		From(c.tableName("_asset_upload_session")).
+		Where("id = ?", id)
+
+	err := scanUploadSession(c.QueryRowWith(builder), session)
+	if err == sql.ErrNoRows {
+		return skydb.ErrUploadSessionNotFound
+	}
+	return err
+}
+
+func (c *conn) GetExpiredUploadSessions(updatedBefore time.Time, afterID string, limit int) ([]skydb.UploadSession, error) {
+	builder := psql.Select(uploadSessionColumns...).
+		From(c.tableName("_asset_upload_session")).
+		Where("updated_at < ? AND id > ?", updatedBefore.UTC(), afterID).
+		OrderBy("id").
+		Limit(uint64(limit))
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []skydb.UploadSession{}
+	for rows.Next() {
+		session := skydb.UploadSession{}
+		if err := scanUploadSession(rows, &session); err != nil {
+			return nil, err
+		}
+		results = append(results, session)
+	}
+
+	return results, rows.Err()
+}
+
+var uploadSessionColumns = []string{"id", "asset_name", "content_type", "size", "upload_id",
+	"uploaded_size", "chunks", "user_id", "created_at", "updated_at"}
+
+func scanUploadSession(scanner columnsScanner, session *skydb.UploadSession) error {
+	var userID sql.NullString
+	err := scanner.Scan(
+		&session.ID,
+		&session.AssetName,
+		&session.ContentType,
+		&session.Size,
+		&session.UploadID,
+		&session.Offset,
+		&session.Chunks,
+		&userID,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	session.UserID = userID.String
+	session.CreatedAt = session.CreatedAt.UTC()
+	session.UpdatedAt = session.UpdatedAt.UTC()
+	return nil
+}
+
+func (c *conn) DeleteUploadSession(id string) error {
+	builder := psql.Delete(c.tableName("_asset_upload_session")).
+		Where("id = ?", id)
+
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrUploadSessionNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUploadSession(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		createdAt := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		session := skydb.UploadSession{
+			ID:          "session-id",
+			AssetName:   "c34e739e-video.mp4",
+			ContentType: "video/mp4",
+			Size:        2 << 30,
+			UploadID:    "upload-id",
+			UserID:      "userid",
+			CreatedAt:   createdAt,
+			UpdatedAt:   createdAt,
+		}
+		So(c.SaveUploadSession(&session), ShouldBeNil)
+
+		Convey("gets session", func() {
+			fetched := skydb.UploadSession{}
+			So(c.GetUploadSession("session-id", &fetched), ShouldBeNil)
+			So(fetched, ShouldResemble, session)
+		})
+
+		Convey("updates progress of session", func() {
+			session.Offset = 5 << 20
+			session.Chunks = 1
+			session.UpdatedAt = createdAt.Add(time.Minute)
+			So(c.SaveUploadSession(&session), ShouldBeNil)
+
+			fetched := skydb.UploadSession{}
+			So(c.GetUploadSession("session-id", &fetched), ShouldBeNil)
+			So(fetched, ShouldResemble, session)
+		})
+
+		Convey("updates progress of session at the uploaded size", func() {
+			session.Offset = 5 << 20
+			session.Chunks = 1
+			session.UpdatedAt = createdAt.Add(time.Minute)
+			So(c.UpdateUploadSession(&session, 0), ShouldBeNil)
+
+			fetched := skydb.UploadSession{}
+			So(c.GetUploadSession("session-id", &fetched), ShouldBeNil)
+			So(fetched, ShouldResemble, session)
+
+			session.Offset = 10 << 20
+			session.Chunks = 2
+			So(c.UpdateUploadSession(&session, 0), ShouldEqual, skydb.ErrUploadSessionConflict)
+
+			So(c.GetUploadSession("session-id", &fetched), ShouldBeNil)
+			So(fetched.Offset, ShouldEqual, 5<<20)
+			So(fetched.Chunks, ShouldEqual, 1)
+		})
+
+		Convey("claims session until updated or the lease has passed", func() {
+			now := createdAt.Add(time.Minute)
+			So(c.ClaimUploadSession(&session, now, time.Minute), ShouldBeNil)
+			So(c.ClaimUploadSession(&session, now, time.Minute), ShouldEqual, skydb.ErrUploadSessionConflict)
+			So(c.ClaimUploadSession(&session, now.Add(time.Minute), time.Minute), ShouldBeNil)
+
+			So(c.UpdateUploadSession(&session, 0), ShouldBeNil)
+			So(c.ClaimUploadSession(&session, now.Add(time.Minute), time.Minute), ShouldBeNil)
+		})
+
+		Convey("rejects claiming session not at the uploaded size", func() {
+			claimed := session
+			claimed.Offset = 5 << 20
+			So(c.ClaimUploadSession(&claimed, createdAt, time.Minute), ShouldEqual, skydb.ErrUploadSessionConflict)
+		})
+
+		Convey("rejects updating deleted session", func() {
+			So(c.DeleteUploadSession("session-id"), ShouldBeNil)
+			So(c.UpdateUploadSession(&session, 0), ShouldEqual, skydb.ErrUploadSessionConflict)
+		})
+
+		Convey("gets expired sessions", func() {
+			recent := session
+			recent.ID = "recent-session-id"
+			recent.UpdatedAt = createdAt.Add(time.Hour)
+			So(c.SaveUploadSession(&recent), ShouldBeNil)
+
+			expired := session
+			expired.ID = "expired-session-id"
+			So(c.SaveUploadSession(&expired), ShouldBeNil)
+
+			sessions, err := c.GetExpiredUploadSessions(createdAt.Add(time.Minute), "", 10)
+			So(err, ShouldBeNil)
+			So(sessions, ShouldResemble, []skydb.UploadSession{expired, session})
+
+			sessions, err = c.GetExpiredUploadSessions(createdAt.Add(time.Minute), "expired-session-id", 10)
+			So(err, ShouldBeNil)
+			So(sessions, ShouldResemble, []skydb.UploadSession{session})
+
+			sessions, err = c.GetExpiredUploadSessions(createdAt.Add(time.Minute), "", 1)
+			So(err, ShouldBeNil)
+			So(sessions, ShouldResemble, []skydb.UploadSession{expired})
+		})
+
+		Convey("deletes session", func() {
+			So(c.DeleteUploadSession("session-id"), ShouldBeNil)
+			So(c.GetUploadSession("session-id", &skydb.UploadSession{}), ShouldEqual, skydb.ErrUploadSessionNotFound)
+			So(c.DeleteUploadSession("session-id"), ShouldEqual, skydb.ErrUploadSessionNotFound)
+		})
+	})
+}
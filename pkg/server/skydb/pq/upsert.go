@@ -42,7 +42,13 @@ WITH updated AS (
 	SELECT {{placeholderList 0 (len .InsertCols)}}
 	WHERE NOT EXISTS (SELECT * FROM updated)
 	RETURNING *
-)
+){{if .Then}}, upserted AS (
+	SELECT *, FALSE AS _inserted FROM updated
+	UNION ALL
+	SELECT *, TRUE AS _inserted FROM inserted
+), after_upsert AS (
+	{{.Then}}
+){{end}}
 SELECT * FROM updated
 UNION ALL
 SELECT * FROM inserted;
@@ -117,6 +123,8 @@ type upsertQueryBuilder struct {
 	pkData         map[string]interface{}
 	data           map[string]interface{}
 	updateIngnores map[string]struct{}
+	then           string
+	thenArgs       []interface{}
 }
 
 // TODO(limouren): we can support a better fluent builder like this
@@ -130,7 +138,12 @@ type upsertQueryBuilder struct {
 //		})
 //
 func upsertQuery(table string, pkData, data map[string]interface{}) *upsertQueryBuilder {
-	return &upsertQueryBuilder{table, pkData, data, map[string]struct{}{}}
+	return &upsertQueryBuilder{
+		table:          table,
+		pkData:         pkData,
+		data:           data,
+		updateIngnores: map[string]struct{}{},
+	}
 }
 
 func (upsert *upsertQueryBuilder) IgnoreKeyOnUpdate(col string) *upsertQueryBuilder {
@@ -138,6 +151,18 @@ func (upsert *upsertQueryBuilder) IgnoreKeyOnUpdate(col string) *upsertQueryBuil
 	return upsert
 }
 
+// Then adds a data-modifying statement to the upsert, which is executed
+// in the same query, e.g. an INSERT logging the upsert.
+//
+// The statement can select from upserted, which is the upserted row with
+// an additional boolean column _inserted telling whether the row is
+// inserted or updated. Placeholders of args in the statement are ?.
+func (upsert *upsertQueryBuilder) Then(stmt string, args ...interface{}) *upsertQueryBuilder {
+	upsert.then = stmt
+	upsert.thenArgs = args
+	return upsert
+}
+
 // err always returns nil
 func (upsert *upsertQueryBuilder) ToSql() (sql string, args []interface{}, err error) {
 	// extract columns values pair
@@ -147,23 +172,44 @@ func (upsert *upsertQueryBuilder) ToSql() (sql string, args []interface{}, err e
 	cols, args, ignored := sortColsArgs(cols, args, upsert.updateIngnores)
 	updateCols := cols[:len(cols)-ignored]
 
+	args = append(pkArgs, args...)
+	then := numberPlaceholders(upsert.then, len(args)+1)
+
 	b := bytes.Buffer{}
 	err = upsertTemplate.Execute(&b, struct {
 		Table      string
 		Keys       []string
 		UpdateCols []string
 		InsertCols []string
+		Then       string
 	}{
 		Table:      upsert.table,
 		Keys:       pks,
 		UpdateCols: updateCols,
 		InsertCols: append(pks, cols...),
+		Then:       then,
 	})
 	if err != nil {
 		panic(err)
 	}
 
-	return b.String(), append(pkArgs, args...), nil
+	return b.String(), append(args, upsert.thenArgs...), nil
+}
+
+// numberPlaceholders replaces each ? in stmt with $n, where n starts at
+// from.
+func numberPlaceholders(stmt string, from int) string {
+	b := bytes.Buffer{}
+	for _, r := range stmt {
+		if r == '?' {
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(from))
+			from++
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
 }
 
 func extractKeyAndValue(data map[string]interface{}) (keys []string, values []interface{}) {
@@ -23,6 +23,7 @@ import (
 	sq "github.com/lann/squirrel"
 	"github.com/lib/pq"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 )
 
 func (c *conn) CreateUser(userinfo *skydb.UserInfo) (err error) {
@@ -151,10 +152,35 @@ func (c *conn) UpdateUser(userinfo *skydb.UserInfo) (err error) {
 	return nil
 }
 
+// inheritedRolesColumn returns a column expression of roles inherited by
+// the user through the parents of the user's roles.
+//
+// UNION discards duplicated rows so that the recursion terminates even if
+// the role hierarchy contains a cycle.
+func (c *conn) inheritedRolesColumn() string {
+	return fmt.Sprintf(`(
+WITH RECURSIVE ancestor(id) AS (
+	SELECT r.parent_id FROM %[1]s AS r
+	JOIN %[2]s AS ur ON r.id = ur.role_id
+	WHERE ur.user_id = %[3]s.id AND r.parent_id IS NOT NULL
+	UNION
+	SELECT r.parent_id FROM %[1]s AS r
+	JOIN ancestor AS a ON r.id = a.id
+	WHERE r.parent_id IS NOT NULL
+)
+SELECT array_to_json(array_agg(id)) FROM ancestor
+) AS inherited_roles`,
+		c.tableName("_role"),
+		c.tableName("_user_role"),
+		c.tableName("_user"),
+	)
+}
+
 func (c *conn) baseUserBuilder() sq.SelectBuilder {
 	return psql.Select("id", "username", "email", "password", "auth",
 		"token_valid_since", "last_login_at", "last_seen_at",
-		"array_to_json(array_agg(role_id)) AS roles").
+		"array_to_json(array_agg(role_id)) AS roles",
+		c.inheritedRolesColumn()).
 		From(c.tableName("_user")).
 		LeftJoin(c.tableName("_user_role") + " ON id = user_id").
 		GroupBy("id")
@@ -169,6 +195,7 @@ func (c *conn) doScanUser(userinfo *skydb.UserInfo, scanner sq.RowScanner) error
 		lastLoginAt     pq.NullTime
 		lastSeenAt      pq.NullTime
 		roles           nullJSONStringSlice
+		inheritedRoles  nullJSONStringSlice
 	)
 	password, auth := []byte{}, authInfoValue{}
 
@@ -182,6 +209,7 @@ func (c *conn) doScanUser(userinfo *skydb.UserInfo, scanner sq.RowScanner) error
 		&lastLoginAt,
 		&lastSeenAt,
 		&roles,
+		&inheritedRoles,
 	)
 	if err != nil {
 		log.Infof(err.Error())
@@ -211,6 +239,7 @@ func (c *conn) doScanUser(userinfo *skydb.UserInfo, scanner sq.RowScanner) error
 		userinfo.LastSeenAt = nil
 	}
 	userinfo.Roles = roles.slice
+	userinfo.InheritedRoles = inheritedRoles.slice
 
 	return err
 }
@@ -316,3 +345,160 @@ func (c *conn) DeleteUser(id string) error {
 
 	return nil
 }
+
+func (c *conn) MergeUser(fromID string, toID string) error {
+	for _, id := range []string{fromID, toID} {
+		var exists bool
+		builder := psql.Select("TRUE").From(c.tableName("_user")).
+			Where("id = ?", id)
+		if err := c.QueryRowWith(builder).Scan(&exists); err == sql.ErrNoRows {
+			return skydb.ErrUserNotFound
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if err := c.mergeUserRecords(fromID, toID); err != nil {
+		return err
+	}
+
+	device := c.tableName("_device")
+	userRole := c.tableName("_user_role")
+	stmts := []string{
+		// Devices of the same type and token are already registered
+		// to the target user.
+		fmt.Sprintf(`DELETE FROM %[1]s AS d WHERE user_id = $1 AND EXISTS (
+			SELECT 1 FROM %[1]s WHERE user_id = $2 AND type = d.type AND token = d.token
+		)`, device),
+		fmt.Sprintf(`UPDATE %s SET user_id = $2 WHERE user_id = $1`, device),
+		fmt.Sprintf(`UPDATE %s SET user_id = $2 WHERE user_id = $1`, c.tableName("_subscription")),
+		fmt.Sprintf(`INSERT INTO %[1]s (user_id, role_id)
+			SELECT $2, role_id FROM %[1]s WHERE user_id = $1
+			ON CONFLICT DO NOTHING`, userRole),
+		fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, userRole),
+		fmt.Sprintf(`UPDATE %s SET user_id = $2 WHERE user_id = $1`, c.tableName("_asset_upload_session")),
+		// Users targeted by scheduled pushes which are not sent yet.
+		fmt.Sprintf(`UPDATE %s SET user_ids = (
+			SELECT jsonb_agg(DISTINCT CASE WHEN id = $1 THEN $2 ELSE id END)
+			FROM jsonb_array_elements_text(user_ids) AS id
+		)
+		WHERE user_ids @> jsonb_build_array($1::text)`, c.tableName("_push_schedule")),
+	}
+	for _, relation := range []string{"_friend", "_follow"} {
+		// Relations that the target user already has, or that would
+		// relate the target user to itself, are dropped.
+		table := c.tableName(relation)
+		stmts = append(stmts,
+			fmt.Sprintf(`DELETE FROM %[1]s AS r WHERE left_id = $1 AND (right_id = $2 OR EXISTS (
+				SELECT 1 FROM %[1]s WHERE left_id = $2 AND right_id = r.right_id
+			))`, table),
+			fmt.Sprintf(`UPDATE %s SET left_id = $2 WHERE left_id = $1`, table),
+			fmt.Sprintf(`DELETE FROM %[1]s AS r WHERE right_id = $1 AND (left_id = $2 OR EXISTS (
+				SELECT 1 FROM %[1]s WHERE right_id = $2 AND left_id = r.left_id
+			))`, table),
+			fmt.Sprintf(`UPDATE %s SET right_id = $2 WHERE right_id = $1`, table),
+		)
+	}
+
+	for _, stmt := range stmts {
+		if _, err := c.Exec(stmt, fromID, toID); err != nil {
+			return err
+		}
+	}
+
+	return c.DeleteUser(fromID)
+}
+
+// mergeUserRecords reassigns the ownership of records in every record
+// table, including records in the private database and user IDs in
+// record ACL, and the record changes of them. The user record of fromID
+// is removed.
+//
+// Record ID is unique in a record table regardless of the database, so
+// private records of the two users cannot have the same ID. Should it
+// happen, the merge fails instead of discarding either record.
+func (c *conn) mergeUserRecords(fromID string, toID string) error {
+	rows, err := c.Queryx(`
+	SELECT table_name
+	FROM information_schema.tables
+	WHERE (table_name NOT LIKE '\_%') AND (table_schema=$1)
+	`, c.schemaName())
+	if err != nil {
+		return err
+	}
+
+	recordTypes := []string{}
+	for rows.Next() {
+		var recordType string
+		if err := rows.Scan(&recordType); err != nil {
+			rows.Close()
+			return err
+		}
+		recordTypes = append(recordTypes, recordType)
+	}
+	rows.Close()
+
+	for _, recordType := range recordTypes {
+		table := c.tableName(recordType)
+		if recordType == "user" {
+			stmt := fmt.Sprintf(`DELETE FROM %s WHERE _id = $1`, table)
+			if _, err := c.Exec(stmt, fromID); err != nil {
+				return err
+			}
+		}
+
+		stmt := fmt.Sprintf(`
+		UPDATE %s SET
+			_owner_id = CASE WHEN _owner_id = $1 THEN $2 ELSE _owner_id END,
+			_database_id = CASE WHEN _database_id = $1 THEN $2 ELSE _database_id END,
+			_created_by = CASE WHEN _created_by = $1 THEN $2 ELSE _created_by END,
+			_updated_by = CASE WHEN _updated_by = $1 THEN $2 ELSE _updated_by END
+		WHERE $1 IN (_owner_id, _database_id, _created_by, _updated_by)
+		`, table)
+		if _, err := c.Exec(stmt, fromID, toID); isUniqueViolated(err) {
+			return skyerr.NewError(skyerr.ConstraintViolated, fmt.Sprintf(
+				"merge user: records of type %s in the private databases of both users have the same ID",
+				recordType,
+			))
+		} else if err != nil {
+			return err
+		}
+
+		if err := c.mergeUserACL(table, "_access", fromID, toID); err != nil {
+			return err
+		}
+	}
+
+	// Record changes keep the owner and ACL of records for checking
+	// access of deleted records, and changes of the private database
+	// move with the records.
+	recordChange := c.tableName("_record_change")
+	stmt := fmt.Sprintf(`
+	UPDATE %s SET
+		owner_id = CASE WHEN owner_id = $1 THEN $2 ELSE owner_id END,
+		database_id = CASE WHEN database_id = $1 THEN $2 ELSE database_id END
+	WHERE $1 IN (owner_id, database_id)
+	`, recordChange)
+	if _, err := c.Exec(stmt, fromID, toID); err != nil {
+		return err
+	}
+
+	return c.mergeUserACL(recordChange, "access", fromID, toID)
+}
+
+// mergeUserACL replaces fromID with toID in the user entries of the ACL
+// stored in column of table.
+func (c *conn) mergeUserACL(table string, column string, fromID string, toID string) error {
+	stmt := fmt.Sprintf(`
+	UPDATE %[1]s SET %[2]s = (
+		SELECT jsonb_agg(CASE
+			WHEN ace->>'user_id' = $1 THEN jsonb_set(ace, '{user_id}', to_jsonb($2::text))
+			ELSE ace
+		END)
+		FROM jsonb_array_elements(%[2]s) AS ace
+	)
+	WHERE %[2]s @> jsonb_build_array(jsonb_build_object('user_id', $1::text))
+	`, table, pq.QuoteIdentifier(column))
+	_, err := c.Exec(stmt, fromID, toID)
+	return err
+}
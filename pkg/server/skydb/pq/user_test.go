@@ -396,3 +396,72 @@ func TestUserEagerLoadRole(t *testing.T) {
 		})
 	})
 }
+
+func TestMergeUser(t *testing.T) {
+	Convey("MergeUser", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		addUser(t, c, "anonymous")
+		addUser(t, c, "user")
+
+		_, err := c.PublicDB().Extend("note", skydb.RecordSchema{})
+		So(err, ShouldBeNil)
+
+		privateDB := c.PrivateDB("anonymous")
+		So(privateDB.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "private"),
+			OwnerID: "anonymous",
+		}), ShouldBeNil)
+
+		publicDB := c.PublicDB()
+		So(publicDB.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "public"),
+			OwnerID: "anonymous",
+			ACL: skydb.RecordACL{
+				skydb.NewRecordACLEntryDirect("anonymous", skydb.ReadLevel),
+			},
+		}), ShouldBeNil)
+		So(publicDB.Delete(skydb.NewRecordID("note", "public")), ShouldBeNil)
+
+		So(c.SaveUploadSession(&skydb.UploadSession{
+			ID:          "session",
+			AssetName:   "video.mp4",
+			ContentType: "video/mp4",
+			Size:        1,
+			UserID:      "anonymous",
+			CreatedAt:   time.Now().UTC(),
+			UpdatedAt:   time.Now().UTC(),
+		}), ShouldBeNil)
+
+		So(c.MergeUser("anonymous", "user"), ShouldBeNil)
+
+		Convey("moves private records and their changes", func() {
+			record := skydb.Record{}
+			So(c.PrivateDB("user").Get(skydb.NewRecordID("note", "private"), &record), ShouldBeNil)
+			So(record.OwnerID, ShouldEqual, "user")
+
+			changes, err := c.PrivateDB("user").GetRecordChanges("note", skydb.RecordChangeCursor{}, 10)
+			So(err, ShouldBeNil)
+			So(len(changes), ShouldEqual, 1)
+			So(changes[0].OwnerID, ShouldEqual, "user")
+		})
+
+		Convey("reassigns owner and ACL of record changes", func() {
+			changes, err := publicDB.GetRecordChanges("note", skydb.RecordChangeCursor{}, 10)
+			So(err, ShouldBeNil)
+			So(len(changes), ShouldEqual, 1)
+			So(changes[0].Event, ShouldEqual, skydb.RecordChangeDelete)
+			So(changes[0].OwnerID, ShouldEqual, "user")
+			So(changes[0].ACL, ShouldResemble, skydb.RecordACL{
+				skydb.NewRecordACLEntryDirect("user", skydb.ReadLevel),
+			})
+		})
+
+		Convey("reassigns upload sessions", func() {
+			session := skydb.UploadSession{}
+			So(c.GetUploadSession("session", &session), ShouldBeNil)
+			So(session.UserID, ShouldEqual, "user")
+		})
+	})
+}
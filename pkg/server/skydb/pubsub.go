@@ -0,0 +1,83 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"strings"
+	"time"
+)
+
+// ChannelAccessLevel is the kind of clients allowed to subscribe or
+// publish to a pubsub channel.
+type ChannelAccessLevel string
+
+// The access levels of a ChannelAccess. Clients with the master key are
+// allowed regardless of the access level.
+const (
+	ChannelAccessPublic        ChannelAccessLevel = "public"
+	ChannelAccessAuthenticated ChannelAccessLevel = "authenticated"
+	ChannelAccessMasterKey     ChannelAccessLevel = "master_key"
+)
+
+// ChannelAccess declares the clients allowed to subscribe or publish to
+// channels.
+type ChannelAccess struct {
+	Level ChannelAccessLevel `json:"level" mapstructure:"level"`
+
+	// Roles restricts ChannelAccessAuthenticated to users having any of
+	// the roles. Users of all roles are allowed if Roles is empty.
+	Roles []string `json:"roles,omitempty" mapstructure:"roles"`
+}
+
+// ChannelRule declares the clients allowed to subscribe and publish to
+// the channels matching Channel.
+//
+// Channel is either a channel name, or a prefix of channel names
+// followed by "*". When multiple rules match a channel, the rule with
+// the exact name, or else the longest prefix, applies.
+type ChannelRule struct {
+	Channel   string        `json:"channel"`
+	Subscribe ChannelAccess `json:"subscribe"`
+	Publish   ChannelAccess `json:"publish"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// MatchChannel returns whether the channel matches the pattern, which is
+// either a channel name or a prefix followed by "*".
+func MatchChannel(pattern string, channel string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(channel, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == channel
+}
+
+// MatchChannelRule returns the rule applied to the channel, or nil if
+// no rules match the channel.
+func MatchChannelRule(rules []ChannelRule, channel string) *ChannelRule {
+	var matched *ChannelRule
+	for i, rule := range rules {
+		if !MatchChannel(rule.Channel, channel) {
+			continue
+		}
+		if rule.Channel == channel {
+			return &rules[i]
+		}
+		if matched == nil || len(rule.Channel) > len(matched.Channel) {
+			matched = &rules[i]
+		}
+	}
+	return matched
+}
@@ -0,0 +1,38 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMatchChannelRule(t *testing.T) {
+	Convey("MatchChannelRule", t, func() {
+		rules := []ChannelRule{
+			{Channel: "*"},
+			{Channel: "chat/*"},
+			{Channel: "chat/lobby"},
+			{Channel: "chat/private/*"},
+		}
+
+		So(MatchChannelRule(rules, "chat/lobby").Channel, ShouldEqual, "chat/lobby")
+		So(MatchChannelRule(rules, "chat/room1").Channel, ShouldEqual, "chat/*")
+		So(MatchChannelRule(rules, "chat/private/room1").Channel, ShouldEqual, "chat/private/*")
+		So(MatchChannelRule(rules, "news").Channel, ShouldEqual, "*")
+		So(MatchChannelRule(rules[1:], "news"), ShouldBeNil)
+	})
+}
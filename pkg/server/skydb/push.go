@@ -0,0 +1,123 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import "time"
+
+// PushDeliveryState is the state of a PushDelivery.
+type PushDeliveryState string
+
+// The states of a PushDelivery. A delivery starts as PushDeliveryQueued
+// and ends at one of the other states.
+const (
+	PushDeliveryQueued       PushDeliveryState = "queued"
+	PushDeliverySent         PushDeliveryState = "sent"
+	PushDeliveryFailed       PushDeliveryState = "failed"
+	PushDeliveryTokenInvalid PushDeliveryState = "token-invalid"
+
+	// PushDeliveryCollapsed is the state of a queued delivery replaced by
+	// a later delivery to the same device with the same collapse key.
+	PushDeliveryCollapsed PushDeliveryState = "collapsed"
+)
+
+// PushDelivery is a notification queued for delivery to a single device.
+// Deliveries of the same notification share a NotificationID, and
+// are kept after delivery as the delivery log of the notification.
+type PushDelivery struct {
+	ID             string
+	NotificationID string
+	DeviceID       string
+	DeviceType     string
+	DeviceToken    string
+	Notification   map[string]interface{}
+	CollapseKey    string
+	State          PushDeliveryState
+	Attempts       int
+	LastError      string
+	NextAttemptAt  time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// PushTemplate is a named notification registered with push:template
+// actions. It is rendered for each device with the localization
+// matching the device locale.
+type PushTemplate struct {
+	Name string `json:"name"`
+
+	// DefaultLocale is the localization used for devices whose locale
+	// matches none of Localizations.
+	DefaultLocale string                              `json:"default_locale"`
+	Localizations map[string]PushTemplateLocalization `json:"localizations"`
+
+	// Sound, Badge and Data apply to all localizations. Badge is either
+	// a number or a placeholder referencing one.
+	Sound string                 `json:"sound,omitempty"`
+	Badge interface{}            `json:"badge,omitempty"`
+	Data  map[string]interface{} `json:"data,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PushTemplateLocalization is the text of a PushTemplate in a locale.
+//
+// Title and Body are displayed as is after rendering. Alternatively,
+// the LocKey and LocArgs fields refer to localized strings bundled with
+// the app, which are resolved on the device.
+type PushTemplateLocalization struct {
+	Title        string   `json:"title,omitempty" mapstructure:"title"`
+	Body         string   `json:"body,omitempty" mapstructure:"body"`
+	TitleLocKey  string   `json:"title_loc_key,omitempty" mapstructure:"title_loc_key"`
+	TitleLocArgs []string `json:"title_loc_args,omitempty" mapstructure:"title_loc_args"`
+	BodyLocKey   string   `json:"body_loc_key,omitempty" mapstructure:"body_loc_key"`
+	BodyLocArgs  []string `json:"body_loc_args,omitempty" mapstructure:"body_loc_args"`
+}
+
+// PushScheduleState is the state of a PushSchedule.
+type PushScheduleState string
+
+// The states of a PushSchedule. A schedule starts as PushScheduleScheduled
+// and ends at one of the other states.
+const (
+	PushScheduleScheduled PushScheduleState = "scheduled"
+	PushScheduleSent      PushScheduleState = "sent"
+	PushScheduleCancelled PushScheduleState = "cancelled"
+)
+
+// PushSchedule is a notification to be sent to users or devices at
+// SendAt. Either UserIDs or DeviceIDs is set. The content of the
+// notification is either Notification, or Template rendered with Context.
+//
+// Schedules of the same notification share a NotificationID.
+type PushSchedule struct {
+	ID             string
+	NotificationID string
+	UserIDs        []string
+	DeviceIDs      []string
+	Topic          string
+	Notification   map[string]interface{}
+	Template       string
+	Context        map[string]interface{}
+	CollapseKey    string
+
+	// Urgent notifications are sent during the quiet hours of users.
+	Urgent bool
+
+	State     PushScheduleState
+	SendAt    time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -156,6 +156,17 @@ func (expr Expression) IsLiteralNull() bool {
 	return expr.Value == nil
 }
 
+// IsCurrentUser returns true if the expression is a CurrentUserFunc, whose
+// value is known only when the predicate is bound with the current user.
+func (expr Expression) IsCurrentUser() bool {
+	if expr.Type != Function {
+		return false
+	}
+
+	_, ok := expr.Value.(CurrentUserFunc)
+	return ok
+}
+
 func (expr Expression) KeyPathComponents() []string {
 	if expr.Type != KeyPath {
 		panic("expression is not a keypath")
@@ -237,10 +248,10 @@ func (p Predicate) validateInPredicate(parentPredicate *Predicate) skyerr.Error
 			`either one of the operands of "IN" must be key path`)
 	}
 
-	if rhs.IsKeyPath() && !lhs.IsLiteralString() {
+	if rhs.IsKeyPath() && !lhs.IsLiteralString() && !lhs.IsCurrentUser() {
 		return skyerr.NewError(skyerr.RecordQueryInvalid,
 			`left operand of "IN" must be a string if comparing with a keypath`)
-	} else if lhs.IsKeyPath() && !rhs.IsLiteralArray() {
+	} else if lhs.IsKeyPath() && !rhs.IsLiteralArray() && !rhs.IsCurrentUser() {
 		return skyerr.NewError(skyerr.RecordQueryInvalid,
 			`right operand of "IN" must be an array if comparing with a keypath`)
 	}
@@ -439,3 +450,18 @@ type UserDataFunc struct {
 func (f UserDataFunc) Args() []interface{} {
 	return []interface{}{}
 }
+
+// CurrentUserFunc represents the user on whose behalf a predicate is
+// evaluated, such as the user a record policy is checked against.
+//
+// It refers to the user ID if KeyPath is empty, or to a field of the user
+// record otherwise. A predicate containing CurrentUserFunc has to be bound
+// with BindCurrentUser before it is evaluated.
+type CurrentUserFunc struct {
+	KeyPath string
+}
+
+// Args implements the Func interface
+func (f CurrentUserFunc) Args() []interface{} {
+	return []interface{}{}
+}
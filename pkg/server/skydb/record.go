@@ -133,7 +133,7 @@ func (ace *RecordACLEntry) Accessible(userinfo *UserInfo, level ACLLevel) bool {
 			return true
 		}
 	}
-	for _, role := range userinfo.Roles {
+	for _, role := range userinfo.EffectiveRoles() {
 		if role == ace.Role {
 			if ace.AccessibleLevel(level) {
 				return true
@@ -281,6 +281,11 @@ type Record struct {
 	ACL        RecordACL
 	Data       Data
 	Transient  Data `json:"-"`
+
+	// ACLParent is the record whose access is inherited by this record
+	// when this record has no ACL. It is resolved by ResolveACLParent and
+	// is not persisted.
+	ACLParent *Record `json:"-"`
 }
 
 // Get returns the value specified by key. If no value is associated
@@ -365,7 +370,7 @@ func (r *Record) Set(key string, i interface{}) {
 }
 
 func (r *Record) Accessible(userinfo *UserInfo, level ACLLevel) bool {
-	if r.ACL == nil {
+	if r.ACL == nil && r.ACLParent == nil {
 		return true
 	}
 	userID := ""
@@ -378,6 +383,9 @@ func (r *Record) Accessible(userinfo *UserInfo, level ACLLevel) bool {
 	if r.OwnerID == userID {
 		return true
 	}
+	if r.ACL == nil {
+		return r.ACLParent.Accessible(userinfo, level)
+	}
 
 	return r.ACL.Accessible(userinfo, level)
 }
@@ -0,0 +1,67 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import "time"
+
+// RecordChangeEvent is the kind of change made to a record as recorded in
+// the change log of a Database.
+type RecordChangeEvent string
+
+// See the definition of RecordChangeEvent
+const (
+	RecordChangeCreate RecordChangeEvent = "create"
+	RecordChangeUpdate RecordChangeEvent = "update"
+	RecordChangeDelete RecordChangeEvent = "delete"
+)
+
+// RecordChange is an entry in the change log of a Database. An entry is
+// written whenever a record is saved or deleted, in the same statement
+// saving or deleting the record.
+//
+// IDs of RecordChange are increasing in the order the changes are
+// written. TxID is the ID of the transaction writing the change.
+type RecordChange struct {
+	ID        int64
+	TxID      int64
+	RecordID  RecordID
+	Event     RecordChangeEvent
+	ChangedAt time.Time
+
+	// OwnerID and ACL are those of the record at the time of the change,
+	// so that access to a deleted record can be checked.
+	OwnerID string
+	ACL     RecordACL
+}
+
+// Cursor returns the position of the change in the change log.
+func (change RecordChange) Cursor() RecordChangeCursor {
+	return RecordChangeCursor{
+		TxID: change.TxID,
+		ID:   change.ID,
+	}
+}
+
+// RecordChangeCursor is a position in the change log. Changes are ordered
+// by TxID and then by ID.
+//
+// Changes are written with increasing IDs, but a change with a smaller ID
+// might be committed after one with a greater ID. Changes are therefore
+// returned only after all transactions with a smaller TxID have ended, so
+// that no change is ever committed before a cursor already returned.
+type RecordChangeCursor struct {
+	TxID int64
+	ID   int64
+}
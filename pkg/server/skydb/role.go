@@ -0,0 +1,31 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+// Role is a named group of users.
+//
+// A role may have a parent role. Users assigned to a role are also
+// regarded as members of its parent, grandparent and so on; such roles are
+// put into UserInfo.InheritedRoles when the UserInfo is fetched.
+//
+// Permissions are arbitrary strings granted to the members of the role,
+// which can be required by plugin handlers and lambdas.
+type Role struct {
+	Name        string   `json:"name"`
+	Parent      string   `json:"parent,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	IsAdmin     bool     `json:"is_admin"`
+	ByDefault   bool     `json:"by_default"`
+}
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/utils"
 )
 
 // MapConn is a naive memory implementation of skydb.Conn
@@ -29,6 +30,9 @@ type MapConn struct {
 	usernameMap     map[string]skydb.UserInfo
 	emailMap        map[string]skydb.UserInfo
 	recordAccessMap map[string]skydb.RecordACL
+	recordPolicyMap map[string]skydb.Predicate
+	aclParentMap    map[string]skydb.RecordACLParent
+	RoleMap         map[string]skydb.Role
 	skydb.Conn
 }
 
@@ -39,6 +43,9 @@ func NewMapConn() *MapConn {
 		usernameMap:     map[string]skydb.UserInfo{},
 		emailMap:        map[string]skydb.UserInfo{},
 		recordAccessMap: map[string]skydb.RecordACL{},
+		recordPolicyMap: map[string]skydb.Predicate{},
+		aclParentMap:    map[string]skydb.RecordACLParent{},
+		RoleMap:         map[string]skydb.Role{},
 	}
 }
 
@@ -133,6 +140,24 @@ func (conn *MapConn) DeleteUser(id string) error {
 	return nil
 }
 
+// MergeUser moves the roles of the user of fromID to the user of toID
+// and removes the user of fromID from UserMap.
+func (conn *MapConn) MergeUser(fromID string, toID string) error {
+	from, ok := conn.UserMap[fromID]
+	if !ok {
+		return skydb.ErrUserNotFound
+	}
+	to, ok := conn.UserMap[toID]
+	if !ok {
+		return skydb.ErrUserNotFound
+	}
+
+	to.Roles = append(to.Roles, utils.StringSliceExcept(from.Roles, to.Roles)...)
+	conn.UserMap[toID] = to
+	delete(conn.UserMap, fromID)
+	return nil
+}
+
 // GetAdminRoles is not implemented.
 func (conn *MapConn) GetAdminRoles() ([]string, error) {
 	return []string{
@@ -157,6 +182,116 @@ func (conn *MapConn) SetDefaultRoles(roles []string) error {
 	panic("not implemented")
 }
 
+// CreateRole creates a Role in RoleMap.
+func (conn *MapConn) CreateRole(role *skydb.Role) error {
+	if _, existed := conn.RoleMap[role.Name]; existed {
+		return skydb.ErrRoleDuplicated
+	}
+	if _, existed := conn.RoleMap[role.Parent]; role.Parent != "" && !existed {
+		return skydb.ErrRoleNotFound
+	}
+
+	conn.RoleMap[role.Name] = *role
+	return nil
+}
+
+// GetRoles returns all Role in RoleMap.
+func (conn *MapConn) GetRoles() ([]skydb.Role, error) {
+	roles := []skydb.Role{}
+	for _, role := range conn.RoleMap {
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// DeleteRole removes a Role in RoleMap and revokes it from all users.
+func (conn *MapConn) DeleteRole(name string) error {
+	if _, ok := conn.RoleMap[name]; !ok {
+		return skydb.ErrRoleNotFound
+	}
+
+	delete(conn.RoleMap, name)
+	for _, role := range conn.RoleMap {
+		if role.Parent == name {
+			role.Parent = ""
+			conn.RoleMap[role.Name] = role
+		}
+	}
+	for id, userinfo := range conn.UserMap {
+		userinfo.Roles = removeString(userinfo.Roles, name)
+		conn.UserMap[id] = userinfo
+	}
+	return nil
+}
+
+// AssignUserRoles adds roles to users in UserMap.
+func (conn *MapConn) AssignUserRoles(userIDs []string, roles []string) error {
+	if err := conn.checkRoles(roles); err != nil {
+		return err
+	}
+
+	for _, id := range userIDs {
+		userinfo, ok := conn.UserMap[id]
+		if !ok {
+			return skydb.ErrUserNotFound
+		}
+		for _, role := range roles {
+			userinfo.Roles = append(removeString(userinfo.Roles, role), role)
+		}
+		conn.UserMap[id] = userinfo
+	}
+	return nil
+}
+
+// RevokeUserRoles removes roles from users in UserMap.
+func (conn *MapConn) RevokeUserRoles(userIDs []string, roles []string) error {
+	if err := conn.checkRoles(roles); err != nil {
+		return err
+	}
+
+	for _, id := range userIDs {
+		userinfo, ok := conn.UserMap[id]
+		if !ok {
+			continue
+		}
+		for _, role := range roles {
+			userinfo.Roles = removeString(userinfo.Roles, role)
+		}
+		conn.UserMap[id] = userinfo
+	}
+	return nil
+}
+
+// GetRolePermissions returns permissions of roles in RoleMap.
+func (conn *MapConn) GetRolePermissions(roles []string) ([]string, error) {
+	permissions := []string{}
+	for _, name := range roles {
+		for _, permission := range conn.RoleMap[name].Permissions {
+			permissions = append(removeString(permissions, permission), permission)
+		}
+	}
+	return permissions, nil
+}
+
+func (conn *MapConn) checkRoles(roles []string) error {
+	for _, role := range roles {
+		if _, ok := conn.RoleMap[role]; !ok {
+			return skydb.ErrRoleNotFound
+		}
+	}
+	return nil
+}
+
+func removeString(slice []string, str string) []string {
+	result := []string{}
+	for _, s := range slice {
+		if s != str {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
 // SetRecordAccess sets record creation access
 func (conn *MapConn) SetRecordAccess(recordType string, acl skydb.RecordACL) error {
 	conn.recordAccessMap[recordType] = acl
@@ -173,6 +308,36 @@ func (conn *MapConn) GetRecordAccess(recordType string) (skydb.RecordACL, error)
 	return acl, nil
 }
 
+// SetRecordPolicy sets record policy
+func (conn *MapConn) SetRecordPolicy(recordType string, policy skydb.Predicate) error {
+	if policy.IsEmpty() {
+		delete(conn.recordPolicyMap, recordType)
+	} else {
+		conn.recordPolicyMap[recordType] = policy
+	}
+	return nil
+}
+
+// GetRecordPolicy returns record policy of a specific type
+func (conn *MapConn) GetRecordPolicy(recordType string) (skydb.Predicate, error) {
+	return conn.recordPolicyMap[recordType], nil
+}
+
+// SetRecordACLParent sets ACL parent
+func (conn *MapConn) SetRecordACLParent(recordType string, aclParent skydb.RecordACLParent) error {
+	if aclParent.IsEmpty() {
+		delete(conn.aclParentMap, recordType)
+	} else {
+		conn.aclParentMap[recordType] = aclParent
+	}
+	return nil
+}
+
+// GetRecordACLParent returns ACL parent of a specific type
+func (conn *MapConn) GetRecordACLParent(recordType string) (skydb.RecordACLParent, error) {
+	return conn.aclParentMap[recordType], nil
+}
+
 // GetAsset is not implemented.
 func (conn *MapConn) GetAsset(name string, asset *skydb.Asset) error {
 	panic("not implemented")
@@ -183,6 +348,16 @@ func (conn *MapConn) SaveAsset(asset *skydb.Asset) error {
 	panic("not implemented")
 }
 
+// GetUnreferencedAssets is not implemented.
+func (conn *MapConn) GetUnreferencedAssets(schemas map[string]skydb.RecordSchema, createdBefore time.Time, afterName string, limit int) ([]skydb.Asset, error) {
+	panic("not implemented")
+}
+
+// DeleteAsset is not implemented.
+func (conn *MapConn) DeleteAsset(name string) error {
+	panic("not implemented")
+}
+
 // QueryRelation is not implemented.
 func (conn *MapConn) QueryRelation(user string, name string, direction string, config skydb.QueryConfig) []skydb.UserInfo {
 	panic("not implemented")
@@ -233,6 +408,151 @@ func (conn *MapConn) DeleteEmptyDevicesByTime(t time.Time) error {
 	panic("not implemented")
 }
 
+// SubscribeDeviceTopics is not implemented.
+func (conn *MapConn) SubscribeDeviceTopics(deviceID string, topics []string) error {
+	panic("not implemented")
+}
+
+// UnsubscribeDeviceTopics is not implemented.
+func (conn *MapConn) UnsubscribeDeviceTopics(deviceID string, topics []string) error {
+	panic("not implemented")
+}
+
+// GetDeviceTopics is not implemented.
+func (conn *MapConn) GetDeviceTopics(deviceID string) ([]string, error) {
+	panic("not implemented")
+}
+
+// QueryDevicesByTopic is not implemented.
+func (conn *MapConn) QueryDevicesByTopic(topic string, afterID string, limit int) ([]skydb.Device, error) {
+	panic("not implemented")
+}
+
+// EnqueuePushDeliveries is not implemented.
+func (conn *MapConn) EnqueuePushDeliveries(deliveries []skydb.PushDelivery) error {
+	panic("not implemented")
+}
+
+// ClaimPushDeliveries is not implemented.
+func (conn *MapConn) ClaimPushDeliveries(now time.Time, lease time.Duration, limit int) ([]skydb.PushDelivery, error) {
+	panic("not implemented")
+}
+
+// UpdatePushDelivery is not implemented.
+func (conn *MapConn) UpdatePushDelivery(delivery *skydb.PushDelivery) error {
+	panic("not implemented")
+}
+
+// GetPushDeliveries is not implemented.
+func (conn *MapConn) GetPushDeliveries(notificationID string, afterID string, limit int) ([]skydb.PushDelivery, error) {
+	panic("not implemented")
+}
+
+// CountPushDeliveries is not implemented.
+func (conn *MapConn) CountPushDeliveries(notificationID string) (map[skydb.PushDeliveryState]int, error) {
+	panic("not implemented")
+}
+
+// SavePushSchedule is not implemented.
+func (conn *MapConn) SavePushSchedule(schedule *skydb.PushSchedule) error {
+	panic("not implemented")
+}
+
+// ClaimPushSchedules is not implemented.
+func (conn *MapConn) ClaimPushSchedules(now time.Time, lease time.Duration, limit int) ([]skydb.PushSchedule, error) {
+	panic("not implemented")
+}
+
+// UpdatePushSchedule is not implemented.
+func (conn *MapConn) UpdatePushSchedule(schedule *skydb.PushSchedule) error {
+	panic("not implemented")
+}
+
+// CancelPushSchedules is not implemented.
+func (conn *MapConn) CancelPushSchedules(notificationID string, now time.Time) (int, error) {
+	panic("not implemented")
+}
+
+// GetPushSchedules is not implemented.
+func (conn *MapConn) GetPushSchedules(notificationID string) ([]skydb.PushSchedule, error) {
+	panic("not implemented")
+}
+
+// SavePushTemplate is not implemented.
+func (conn *MapConn) SavePushTemplate(template *skydb.PushTemplate) error {
+	panic("not implemented")
+}
+
+// GetPushTemplate is not implemented.
+func (conn *MapConn) GetPushTemplate(name string, template *skydb.PushTemplate) error {
+	panic("not implemented")
+}
+
+// GetPushTemplates is not implemented.
+func (conn *MapConn) GetPushTemplates() ([]skydb.PushTemplate, error) {
+	panic("not implemented")
+}
+
+// DeletePushTemplate is not implemented.
+func (conn *MapConn) DeletePushTemplate(name string) error {
+	panic("not implemented")
+}
+
+// SaveUploadSession is not implemented.
+func (conn *MapConn) SaveUploadSession(session *skydb.UploadSession) error {
+	panic("not implemented")
+}
+
+// ClaimUploadSession is not implemented.
+func (conn *MapConn) ClaimUploadSession(session *skydb.UploadSession, now time.Time, lease time.Duration) error {
+	panic("not implemented")
+}
+
+// UpdateUploadSession is not implemented.
+func (conn *MapConn) UpdateUploadSession(session *skydb.UploadSession, offset int64) error {
+	panic("not implemented")
+}
+
+// GetUploadSession is not implemented.
+func (conn *MapConn) GetUploadSession(id string, session *skydb.UploadSession) error {
+	panic("not implemented")
+}
+
+// GetExpiredUploadSessions is not implemented.
+func (conn *MapConn) GetExpiredUploadSessions(updatedBefore time.Time, afterID string, limit int) ([]skydb.UploadSession, error) {
+	panic("not implemented")
+}
+
+// DeleteUploadSession is not implemented.
+func (conn *MapConn) DeleteUploadSession(id string) error {
+	panic("not implemented")
+}
+
+// SaveChannelRule is not implemented.
+func (conn *MapConn) SaveChannelRule(rule *skydb.ChannelRule) error {
+	panic("not implemented")
+}
+
+// GetChannelRules is not implemented.
+func (conn *MapConn) GetChannelRules() ([]skydb.ChannelRule, error) {
+	panic("not implemented")
+}
+
+// DeleteChannelRule is not implemented.
+func (conn *MapConn) DeleteChannelRule(channel string) error {
+	panic("not implemented")
+}
+
+// GetLatestRecordChangeCursor is not implemented.
+func (conn *MapConn) GetLatestRecordChangeCursor() (skydb.RecordChangeCursor, error) {
+	panic("not implemented")
+}
+
+// DeleteRecordChanges is not implemented.
+func (conn *MapConn) DeleteRecordChanges(before time.Time) error {
+	panic("not implemented")
+}
+
 // PublicDB is not implemented.
 func (conn *MapConn) PublicDB() skydb.Database {
 	panic("not implemented")
@@ -284,6 +604,9 @@ func NewMapDB() *MapDB {
 	}
 }
 
+// Conn returns DBConn of the MapDB.
+func (db *MapDB) Conn() skydb.Conn { return db.DBConn }
+
 func (db *MapDB) IsReadOnly() bool { return false }
 
 func (db *MapDB) DatabaseType() skydb.DatabaseType { return skydb.PublicDatabase }
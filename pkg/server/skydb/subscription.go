@@ -34,6 +34,11 @@ type Subscription struct {
 // to a target devices via a push service. Currently only APS is supported.
 type NotificationInfo struct {
 	APS APSSetting `json:"aps,omitempty"`
+
+	// Template is the name of a PushTemplate sent to the device when
+	// the subscription is triggered. The template is rendered with the
+	// triggering record as `record`.
+	Template string `json:"template,omitempty"`
 }
 
 // APSSetting describes how server should send a notification to a
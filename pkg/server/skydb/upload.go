@@ -0,0 +1,37 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import "time"
+
+// UploadSession is the state of a resumable upload of an asset, which is
+// uploaded in chunks.
+type UploadSession struct {
+	ID          string
+	AssetName   string
+	ContentType string
+	Size        int64
+
+	// UploadID is the ID of the chunked upload in the asset store.
+	UploadID string
+
+	// Offset is the number of bytes uploaded in Chunks chunks.
+	Offset int64
+	Chunks int
+
+	UserID    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
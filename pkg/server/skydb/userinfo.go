@@ -47,6 +47,7 @@ type UserInfo struct {
 	Email           string     `json:"email,omitempty"`
 	HashedPassword  []byte     `json:"password,omitempty"`
 	Roles           []string   `json:"roles,omitempty"`
+	InheritedRoles  []string   `json:"-"`
 	Auth            AuthInfo   `json:"auth,omitempty"` // auth data for alternative methods
 	TokenValidSince *time.Time `json:"token_valid_since,omitempty"`
 	LastLoginAt     *time.Time `json:"last_login_at,omitempty"`
@@ -108,6 +109,13 @@ func (info UserInfo) IsSamePassword(password string) bool {
 	return bcrypt.CompareHashAndPassword(info.HashedPassword, []byte(password)) == nil
 }
 
+// IsAnonymous determines whether the UserInfo has no credentials
+// attached, i.e. it is created by an anonymous sign up.
+func (info *UserInfo) IsAnonymous() bool {
+	return info.Username == "" && info.Email == "" &&
+		len(info.HashedPassword) == 0 && len(info.Auth) == 0
+}
+
 // SetProvidedAuthData sets the auth data to the specified principal.
 func (info *UserInfo) SetProvidedAuthData(principalID string, authData map[string]interface{}) {
 	if info.Auth == nil {
@@ -116,14 +124,27 @@ func (info *UserInfo) SetProvidedAuthData(principalID string, authData map[strin
 	info.Auth[principalID] = authData
 }
 
+// EffectiveRoles returns the roles assigned to the user together with
+// the roles inherited from them.
+func (info *UserInfo) EffectiveRoles() []string {
+	if len(info.InheritedRoles) == 0 {
+		return info.Roles
+	}
+
+	roles := make([]string, len(info.Roles), len(info.Roles)+len(info.InheritedRoles))
+	copy(roles, info.Roles)
+	roles = append(roles, utils.StringSliceExcept(info.InheritedRoles, info.Roles)...)
+	return roles
+}
+
 // HasAnyRoles return true if userinfo belongs to one of the supplied roles
 func (info *UserInfo) HasAnyRoles(roles []string) bool {
-	return utils.StringSliceContainAny(info.Roles, roles)
+	return utils.StringSliceContainAny(info.EffectiveRoles(), roles)
 }
 
 // HasAllRoles return true if userinfo has all roles supplied
 func (info *UserInfo) HasAllRoles(roles []string) bool {
-	return utils.StringSliceContainAll(info.Roles, roles)
+	return utils.StringSliceContainAll(info.EffectiveRoles(), roles)
 }
 
 // GetProvidedAuthData gets the auth data for the specified principal.
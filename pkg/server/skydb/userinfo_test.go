@@ -105,6 +105,25 @@ func TestIsSamePassword(t *testing.T) {
 	}
 }
 
+func TestIsAnonymous(t *testing.T) {
+	Convey("UserInfo", t, func() {
+		Convey("is anonymous without credentials", func() {
+			info := NewAnonymousUserInfo()
+			So(info.IsAnonymous(), ShouldBeTrue)
+		})
+
+		Convey("is not anonymous with username", func() {
+			info := NewUserInfo("john.doe", "", "secret")
+			So(info.IsAnonymous(), ShouldBeFalse)
+		})
+
+		Convey("is not anonymous with provided auth data", func() {
+			info := NewProvidedAuthUserInfo("com.example:johndoe", map[string]interface{}{})
+			So(info.IsAnonymous(), ShouldBeFalse)
+		})
+	})
+}
+
 func TestGetSetProvidedAuthData(t *testing.T) {
 	Convey("Test Get/Set Provided Auth Data", t, func() {
 		k := "com.example:johndoe"
@@ -150,3 +169,27 @@ func TestGetSetProvidedAuthData(t *testing.T) {
 		})
 	})
 }
+
+func TestEffectiveRoles(t *testing.T) {
+	Convey("Test Effective Roles", t, func() {
+		Convey("Test without inherited roles", func() {
+			info := UserInfo{
+				Roles: []string{"editor"},
+			}
+
+			So(info.EffectiveRoles(), ShouldResemble, []string{"editor"})
+		})
+
+		Convey("Test with inherited roles", func() {
+			info := UserInfo{
+				Roles:          []string{"editor", "writer"},
+				InheritedRoles: []string{"writer", "reader"},
+			}
+
+			So(info.EffectiveRoles(), ShouldResemble, []string{"editor", "writer", "reader"})
+			So(info.Roles, ShouldResemble, []string{"editor", "writer"})
+			So(info.HasAllRoles([]string{"editor", "reader"}), ShouldBeTrue)
+			So(info.HasAnyRoles([]string{"reader"}), ShouldBeTrue)
+		})
+	})
+}
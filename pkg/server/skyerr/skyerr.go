@@ -131,6 +131,10 @@ const (
 	// a response
 	ResponseTimeout
 
+	// SyncTokenExpired occurs when the sync token for fetching changes is
+	// too old, such that the changes after it are no longer available
+	SyncTokenExpired
+
 	// Error codes for expected error condition should be placed
 	// above this line.
 )
@@ -33,6 +33,10 @@ type Notice struct {
 	SubscriptionID string
 	Event          skydb.RecordHookEvent
 	Record         *skydb.Record
+
+	// Notification is the notification configured by the subscription
+	// to be shown on the device, nil if there is none.
+	Notification push.Mapper
 }
 
 // Notifier is the interface implemented by an object that knows how to deliver
@@ -60,14 +64,24 @@ func (notifier *pushNotifier) CanNotify(device skydb.Device) bool {
 }
 
 func (notifier *pushNotifier) Notify(device skydb.Device, notice Notice) error {
+	skygearMap := map[string]interface{}{
+		"seq-num":         notice.SeqNum,
+		"subscription-id": notice.SubscriptionID,
+	}
+
+	if notice.Notification != nil {
+		m := notice.Notification.Map()
+		if apnsMap, ok := m["apns"].(map[string]interface{}); ok {
+			apnsMap["_skygear"] = skygearMap
+		}
+		return notifier.sender.Send(push.MapMapper(m), device)
+	}
+
 	customMap := map[string]interface{}{
 		"aps": map[string]interface{}{
 			"content_available": 1,
 		},
-		"_skygear": map[string]interface{}{
-			"seq-num":         notice.SeqNum,
-			"subscription-id": notice.SubscriptionID,
-		},
+		"_skygear": skygearMap,
 	}
 
 	return notifier.sender.Send(push.MapMapper(customMap), device)
@@ -91,14 +105,11 @@ func (n *hubNotifier) Notify(device skydb.Device, notice Notice) error {
 		SubscriptionID string `json:"subscription-id"`
 	}{notice.SeqNum, notice.SubscriptionID})
 
-	if err == nil {
-		(*pubsub.Hub)(n).Broadcast <- pubsub.Parcel{
-			Channel: fmt.Sprintf("_sub_%s", device.ID),
-			Data:    data,
-		}
+	if err != nil {
+		return err
 	}
 
-	return err
+	return (*pubsub.Hub)(n).Publish(fmt.Sprintf("_sub_%s", device.ID), data)
 }
 
 type multiNotifier []Notifier
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/skygeario/skygear-server/pkg/server/push"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 )
 
@@ -108,13 +109,52 @@ func (s *Service) handleRecordHook(db skydb.Database, e skydb.RecordEvent, seqNu
 			log.Panicf("subscription: failed to get device with id = %v: %v", subscription.DeviceID, err)
 		}
 
-		notice := Notice{seqNum, subscription.ID, e.Event, e.Record}
+		notice := Notice{
+			SeqNum:         seqNum,
+			SubscriptionID: subscription.ID,
+			Event:          e.Event,
+			Record:         e.Record,
+			Notification:   subscriptionNotification(conn, subscription, device, e.Record),
+		}
 		if err := s.Notifier.Notify(device, notice); err != nil {
 			log.Errorf("subscription: failed to send notice to device id = %s", device.ID)
 		}
 	}
 }
 
+// subscriptionNotification returns the notification rendered from the
+// template of the subscription for the device, or nil if the subscription
+// has no template.
+func subscriptionNotification(conn skydb.Conn, subscription skydb.Subscription, device skydb.Device, record *skydb.Record) push.Mapper {
+	if subscription.NotificationInfo == nil || subscription.NotificationInfo.Template == "" {
+		return nil
+	}
+
+	name := subscription.NotificationInfo.Template
+	template := skydb.PushTemplate{}
+	if err := conn.GetPushTemplate(name, &template); err != nil {
+		log.WithFields(logrus.Fields{
+			"subscriptionID": subscription.ID,
+			"template":       name,
+			"err":            err,
+		}).Errorln("subscription: failed to get push template")
+		return nil
+	}
+
+	recordData := map[string]interface{}{}
+	for key, value := range record.Data {
+		recordData[key] = value
+	}
+	recordData["_id"] = record.ID.Key
+	return push.LocalizedMapper{
+		Template: template,
+		Locale:   device.Locale,
+		Context: map[string]interface{}{
+			"record": recordData,
+		},
+	}
+}
+
 func getDB(conn skydb.Conn, record *skydb.Record) skydb.Database {
 	if record.DatabaseID == "" {
 		return conn.PublicDB()
@@ -19,6 +19,7 @@ import (
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/skygeario/skygear-server/pkg/server/push"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skydb/mock_skydb"
 	. "github.com/smartystreets/goconvey/convey"
@@ -151,3 +152,77 @@ func TestService(t *testing.T) {
 		})
 	})
 }
+
+func TestSubscriptionNotification(t *testing.T) {
+	Convey("subscriptionNotification", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mock_skydb.NewMockConn(ctrl)
+		record := skydb.Record{
+			ID: skydb.NewRecordID("note", "note1"),
+			Data: map[string]interface{}{
+				"title": "Shopping list",
+			},
+		}
+		device := skydb.Device{
+			ID:     "deviceid",
+			Locale: "fr-FR",
+		}
+		template := skydb.PushTemplate{
+			Name:          "note-changed",
+			DefaultLocale: "en",
+			Localizations: map[string]skydb.PushTemplateLocalization{
+				"en": {Body: "{{record.title}} is changed"},
+				"fr": {Body: "{{record.title}} a changé"},
+			},
+		}
+
+		Convey("renders template of subscription", func() {
+			conn.EXPECT().GetPushTemplate("note-changed", gomock.Any()).
+				SetArg(1, template).
+				Return(nil)
+
+			m := subscriptionNotification(conn, skydb.Subscription{
+				ID: "subscriptionid",
+				NotificationInfo: &skydb.NotificationInfo{
+					Template: "note-changed",
+				},
+			}, device, &record)
+			So(m, ShouldResemble, push.LocalizedMapper{
+				Template: template,
+				Locale:   "fr-FR",
+				Context: map[string]interface{}{
+					"record": map[string]interface{}{
+						"_id":   "note1",
+						"title": "Shopping list",
+					},
+				},
+			})
+			So(m.Map()["web"], ShouldResemble, map[string]interface{}{
+				"body": "Shopping list a changé",
+			})
+		})
+
+		Convey("returns nil without template", func() {
+			m := subscriptionNotification(conn, skydb.Subscription{
+				ID:               "subscriptionid",
+				NotificationInfo: &skydb.NotificationInfo{},
+			}, device, &record)
+			So(m, ShouldBeNil)
+		})
+
+		Convey("returns nil if template is not found", func() {
+			conn.EXPECT().GetPushTemplate("note-changed", gomock.Any()).
+				Return(skydb.ErrPushTemplateNotFound)
+
+			m := subscriptionNotification(conn, skydb.Subscription{
+				ID: "subscriptionid",
+				NotificationInfo: &skydb.NotificationInfo{
+					Template: "note-changed",
+				},
+			}, device, &record)
+			So(m, ShouldBeNil)
+		})
+	})
+}